@@ -0,0 +1,60 @@
+/*
+ * gosonata.h - C ABI of libgosonata, GoSonata built as a C shared library.
+ *
+ * Build the library with:
+ *
+ *   go build -buildmode=c-shared -o libgosonata.so ./cmd/clib/
+ *
+ * ABI version 1. Functions are only added within a version; an incompatible
+ * change bumps the value returned by gosonata_abi_version.
+ *
+ * Strings passed to the library are NUL-terminated UTF-8 and are not retained.
+ * Strings returned by the library are allocated with malloc and must be freed
+ * with gosonata_free. All functions are safe to call from any thread.
+ */
+#ifndef GOSONATA_H
+#define GOSONATA_H
+
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* Handle to a compiled expression; 0 is never a valid handle. */
+typedef uintptr_t gosonata_expr;
+
+/* Returns the version of the ABI declared here (1). */
+int gosonata_abi_version(void);
+
+/* Returns the GoSonata version, e.g. "v0.1.0-dev"; free it with gosonata_free. */
+char *gosonata_version(void);
+
+/*
+ * Compiles a JSONata expression. Returns 0 on failure and, if error is not
+ * NULL, stores the message in *error. Messages start with the JSONata error
+ * code when there is one ("S0201 at position 6 ...").
+ */
+gosonata_expr gosonata_compile(const char *query, char **error);
+
+/*
+ * Evaluates expr against the JSON text data_json (NULL for no input) and
+ * returns the result as JSON text.
+ *
+ * Returns NULL on failure, with the message in *error if error is not NULL,
+ * and also when the result is undefined, in which case *error is left
+ * untouched: initialise it to NULL to tell the two apart.
+ */
+char *gosonata_eval_json(gosonata_expr expr, const char *data_json, char **error);
+
+/* Releases a compiled expression. Releasing 0 or a released handle is a no-op. */
+void gosonata_release(gosonata_expr expr);
+
+/* Frees a string returned by the library. */
+void gosonata_free(void *ptr);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif /* GOSONATA_H */
@@ -0,0 +1,152 @@
+//go:build cgo
+
+// Command clib builds GoSonata as a C shared library (libgosonata.so, .dylib
+// or .dll) for embedding from C, C++, Rust, C# and any language with a C FFI.
+//
+// Build:
+//
+//	go build -buildmode=c-shared -o libgosonata.so ./cmd/clib/
+//
+// The C ABI is declared in gosonata.h (next to this file), which is the
+// supported header: the one generated by the Go toolchain next to the library
+// mirrors it with Go typedefs. Expressions are compiled once into an opaque
+// handle and evaluated against JSON text any number of times, concurrently if
+// needed:
+//
+//	char *err = NULL;
+//	gosonata_expr expr = gosonata_compile("$sum(items.price)", &err);
+//	char *result = gosonata_eval_json(expr, "{\"items\":[{\"price\":1}]}", &err);
+//	...
+//	gosonata_free(result);
+//	gosonata_release(expr);
+//
+// Strings returned by the library are allocated with malloc and released with
+// gosonata_free.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/cgo"
+	"unsafe"
+
+	"github.com/sandrolain/gosonata"
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// abiVersion is the version of the C ABI declared in gosonata.h. It changes
+// only on incompatible changes.
+const abiVersion = 1
+
+// eval is the evaluator shared by all the calls; it is safe for concurrent use.
+var eval = evaluator.New()
+
+// setError stores msg in *errOut, if errOut is not NULL.
+func setError(errOut **C.char, msg string) {
+	if errOut != nil {
+		*errOut = C.CString(msg)
+	}
+}
+
+// gosonata_abi_version returns the version of the C ABI.
+//
+//export gosonata_abi_version
+func gosonata_abi_version() C.int {
+	return abiVersion
+}
+
+// gosonata_version returns the GoSonata version; free it with gosonata_free.
+//
+//export gosonata_version
+func gosonata_version() *C.char {
+	return C.CString(gosonata.Version())
+}
+
+// gosonata_compile compiles query and returns a handle to the expression, or 0
+// with the error message in *errOut.
+//
+//export gosonata_compile
+func gosonata_compile(query *C.char, errOut **C.char) C.uintptr_t {
+	if query == nil {
+		setError(errOut, "gosonata_compile: query is NULL")
+		return 0
+	}
+	expr, err := gosonata.Compile(C.GoString(query))
+	if err != nil {
+		setError(errOut, err.Error())
+		return 0
+	}
+	return C.uintptr_t(cgo.NewHandle(expr))
+}
+
+// gosonata_eval_json evaluates the expression against the JSON text dataJSON
+// (NULL for no input) and returns the result as JSON text. It returns NULL
+// both on error, with the message in *errOut, and when the result is
+// undefined, leaving *errOut untouched.
+//
+//export gosonata_eval_json
+func gosonata_eval_json(handle C.uintptr_t, dataJSON *C.char, errOut **C.char) (out *C.char) {
+	defer func() {
+		// An invalid handle panics in cgo.Handle.Value; the panic must not
+		// cross into the caller.
+		if r := recover(); r != nil {
+			setError(errOut, fmt.Sprintf("gosonata_eval_json: %v", r))
+			out = nil
+		}
+	}()
+
+	expr, ok := cgo.Handle(handle).Value().(*types.Expression)
+	if !ok {
+		setError(errOut, "gosonata_eval_json: invalid expression handle")
+		return nil
+	}
+	var data interface{}
+	if dataJSON != nil {
+		if err := json.Unmarshal([]byte(C.GoString(dataJSON)), &data); err != nil {
+			setError(errOut, fmt.Sprintf("gosonata_eval_json: invalid data JSON: %v", err))
+			return nil
+		}
+	}
+	result, err := eval.Eval(context.Background(), expr, data)
+	if err != nil {
+		setError(errOut, err.Error())
+		return nil
+	}
+	if result == nil {
+		return nil
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		setError(errOut, fmt.Sprintf("gosonata_eval_json: marshal result: %v", err))
+		return nil
+	}
+	return C.CString(string(b))
+}
+
+// gosonata_release releases the expression handle; it must not be used again.
+// Releasing 0 or an already released handle does nothing.
+//
+//export gosonata_release
+func gosonata_release(handle C.uintptr_t) {
+	defer func() { _ = recover() }()
+	if handle != 0 {
+		cgo.Handle(handle).Delete()
+	}
+}
+
+// gosonata_free frees a string returned by the library.
+//
+//export gosonata_free
+func gosonata_free(ptr unsafe.Pointer) {
+	C.free(ptr)
+}
+
+func main() {}
@@ -0,0 +1,79 @@
+// Command dap is a Debug Adapter Protocol server for JSONata expressions: it
+// lets an editor such as VS Code set breakpoints in an expression file, step
+// through its evaluation against an input document and inspect the context
+// value ($), the input ($$) and the variables bound at each step. It is built
+// on evaluator.WithDebugger.
+//
+// Usage:
+//
+//	dap                  # serve one session over stdin and stdout
+//	dap -listen :4711    # serve sessions over TCP, one at a time
+//
+// The launch request takes the following arguments:
+//
+//	program      the file holding the expression
+//	data         a JSON file holding the input; no input when omitted
+//	stopOnEntry  pause before the first node of the expression
+//
+// For VS Code, an extension registers the adapter as the executable of a
+// debugger type, say "jsonata", after which a launch configuration reads:
+//
+//	{
+//	    "type": "jsonata",
+//	    "request": "launch",
+//	    "name": "Debug expression",
+//	    "program": "${file}",
+//	    "data": "${workspaceFolder}/input.json",
+//	    "stopOnEntry": true
+//	}
+//
+// Stepping maps onto the debugger actions: step in pauses at the next node,
+// step over at the next node outside the current one, step out at the next
+// node outside its parent. A breakpoint on a line pauses before the outermost
+// node starting on it; the call stack shows the paused node only. The evaluate
+// request (watches, hovers, the debug console) evaluates an expression against
+// the paused context value with the variables in scope. Breakpoints changed
+// while the evaluation runs apply to the next launch.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "dap:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("dap", flag.ContinueOnError)
+	listen := flags.String("listen", "", "serve sessions over TCP at this address instead of stdio")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *listen == "" {
+		return serve(os.Stdin, os.Stdout)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	fmt.Fprintln(os.Stderr, "dap: listening on", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		if err := serve(conn, conn); err != nil {
+			fmt.Fprintln(os.Stderr, "dap:", err)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sandrolain/gosonata/internal/framing"
+)
+
+// message is a Debug Adapter Protocol message: a request from the client, or
+// a response or an event from the adapter.
+type message struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+
+	// Requests.
+	Command   string          `json:"command,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+
+	// Responses.
+	RequestSeq int    `json:"request_seq,omitempty"`
+	Success    *bool  `json:"success,omitempty"`
+	Message    string `json:"message,omitempty"`
+
+	// Events.
+	Event string `json:"event,omitempty"`
+
+	Body interface{} `json:"body,omitempty"`
+}
+
+// readMessage reads the next message framed by a Content-Length header.
+func readMessage(r *bufio.Reader) (*message, error) {
+	body, err := framing.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	return &msg, nil
+}
+
+// writer sends the messages of the adapter, numbering them. It is safe for
+// concurrent use: events are sent by the evaluation goroutine.
+type writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+func (w *writer) send(msg *message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.seq++
+	msg.Seq = w.seq
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return framing.Write(w.w, body)
+}
+
+// respond answers req with body.
+func (w *writer) respond(req *message, body interface{}) error {
+	ok := true
+	return w.send(&message{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: &ok, Body: body})
+}
+
+// fail answers req with an error.
+func (w *writer) fail(req *message, err error) error {
+	ok := false
+	return w.send(&message{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: &ok,
+		Message: err.Error()})
+}
+
+// event sends the event name with body.
+func (w *writer) event(name string, body interface{}) error {
+	return w.send(&message{Type: "event", Event: name, Body: body})
+}
+
+// The bodies and arguments used by the adapter, named as in the protocol.
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+}
+
+type initializeArguments struct {
+	LinesStartAt1   *bool `json:"linesStartAt1"`
+	ColumnsStartAt1 *bool `json:"columnsStartAt1"`
+}
+
+type launchArguments struct {
+	// Program is the file holding the expression.
+	Program string `json:"program"`
+	// Data is the JSON file holding the input; no input when empty.
+	Data string `json:"data"`
+	// StopOnEntry pauses the evaluation before its first node.
+	StopOnEntry bool `json:"stopOnEntry"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type sourceBreakpoint struct {
+	Line   int `json:"line"`
+	Column int `json:"column,omitempty"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source             `json:"source"`
+	Breakpoints []sourceBreakpoint `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	Verified bool   `json:"verified"`
+	Message  string `json:"message,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type stackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type evaluateArguments struct {
+	Expression string `json:"expression"`
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// threadID is the only thread of a session: the evaluation.
+const threadID = 1
+
+// evaluateTimeout bounds the evaluate requests (watches, hovers).
+const evaluateTimeout = 5 * time.Second
+
+// session is a debugging session with one client: one evaluation of one
+// program.
+type session struct {
+	out *writer
+
+	// lineBase and columnBase are the first line and column number of the
+	// client, 0 or 1.
+	lineBase, columnBase int
+
+	// Set while handling requests, read by the evaluation once started.
+	launch      *launchArguments
+	prog        *program
+	data        interface{}
+	breakpoints map[string][]int // node positions, by program path
+	configured  bool
+
+	// The evaluation, once started.
+	cancel  context.CancelFunc
+	done    chan struct{}
+	resume  chan evaluator.DebugAction
+	entered bool // the evaluation paused or ran past its first node
+
+	// The paused state, shared with the evaluation.
+	mu      sync.Mutex
+	frame   *evaluator.DebugFrame
+	handles []interface{} // containers by variablesReference - 1
+}
+
+// serve runs a session over r and w until the client disconnects.
+func serve(r io.Reader, w io.Writer) error {
+	s := &session{
+		out:         &writer{w: w},
+		lineBase:    1,
+		columnBase:  1,
+		breakpoints: map[string][]int{},
+		resume:      make(chan evaluator.DebugAction),
+	}
+	defer s.stop()
+	in := bufio.NewReader(r)
+	for {
+		req, err := readMessage(in)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if req.Command == "disconnect" {
+			s.stop()
+			return s.out.respond(req, nil)
+		}
+		if err := s.handle(req); err != nil {
+			if err := s.out.fail(req, err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle answers req; an error is sent as a failed response.
+func (s *session) handle(req *message) error {
+	switch req.Command {
+	case "initialize":
+		var args initializeArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return err
+		}
+		if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+			s.lineBase = 0
+		}
+		if args.ColumnsStartAt1 != nil && !*args.ColumnsStartAt1 {
+			s.columnBase = 0
+		}
+		if err := s.out.respond(req, capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}); err != nil {
+			return err
+		}
+		return s.out.event("initialized", nil)
+
+	case "launch":
+		var args launchArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return err
+		}
+		prog, err := loadProgram(args.Program)
+		if err != nil {
+			return err
+		}
+		if args.Data != "" {
+			raw, err := os.ReadFile(args.Data)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(raw, &s.data); err != nil {
+				return fmt.Errorf("data: %w", err)
+			}
+		}
+		s.launch, s.prog = &args, prog
+		if err := s.out.respond(req, nil); err != nil {
+			return err
+		}
+		if s.configured {
+			s.start()
+		}
+		return nil
+
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return err
+		}
+		return s.out.respond(req, map[string]interface{}{"breakpoints": s.setBreakpoints(args)})
+
+	case "configurationDone":
+		s.configured = true
+		if err := s.out.respond(req, nil); err != nil {
+			return err
+		}
+		if s.launch != nil {
+			s.start()
+		}
+		return nil
+
+	case "threads":
+		return s.out.respond(req, map[string]interface{}{
+			"threads": []thread{{ID: threadID, Name: "evaluation"}},
+		})
+
+	case "stackTrace":
+		return s.out.respond(req, s.stackTrace())
+
+	case "scopes":
+		return s.out.respond(req, map[string]interface{}{"scopes": s.scopes()})
+
+	case "variables":
+		var args variablesArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return err
+		}
+		vars, err := s.variables(args.VariablesReference)
+		if err != nil {
+			return err
+		}
+		return s.out.respond(req, map[string]interface{}{"variables": vars})
+
+	case "evaluate":
+		var args evaluateArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return err
+		}
+		return s.evaluate(req, args.Expression)
+
+	case "continue", "next", "stepIn", "stepOut":
+		actions := map[string]evaluator.DebugAction{
+			"continue": evaluator.DebugContinue,
+			"next":     evaluator.DebugStepOver,
+			"stepIn":   evaluator.DebugStep,
+			"stepOut":  evaluator.DebugStepOut,
+		}
+		s.mu.Lock()
+		paused := s.frame != nil
+		s.mu.Unlock()
+		if !paused {
+			return errors.New("the evaluation is not paused")
+		}
+		var body interface{}
+		if req.Command == "continue" {
+			body = map[string]interface{}{"allThreadsContinued": true}
+		}
+		if err := s.out.respond(req, body); err != nil {
+			return err
+		}
+		s.resume <- actions[req.Command]
+		return nil
+
+	case "terminate":
+		s.stop()
+		return s.out.respond(req, nil)
+	}
+	return fmt.Errorf("unsupported request %q", req.Command)
+}
+
+func unmarshalArguments(req *message, args interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Arguments, args); err != nil {
+		return fmt.Errorf("%s: invalid arguments: %w", req.Command, err)
+	}
+	return nil
+}
+
+// loadProgram reads and compiles the expression file at path.
+func loadProgram(path string) (*program, error) {
+	if path == "" {
+		return nil, errors.New("no program: set the expression file to debug")
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := parser.Compile(string(src))
+	if err != nil {
+		return nil, err
+	}
+	return &program{path: path, src: string(src), expr: expr}, nil
+}
+
+// setBreakpoints replaces the breakpoints of a source. Breakpoints set once
+// the evaluation has started apply to the next one.
+func (s *session) setBreakpoints(args setBreakpointsArguments) []breakpoint {
+	result := make([]breakpoint, len(args.Breakpoints))
+	prog, err := loadProgram(args.Source.Path)
+	if err != nil {
+		for i := range result {
+			result[i].Message = err.Error()
+		}
+		return result
+	}
+	var positions []int
+	for i, bp := range args.Breakpoints {
+		column := 0
+		if bp.Column > 0 {
+			column = bp.Column - s.columnBase + 1
+		}
+		pos, ok := prog.breakpoint(bp.Line-s.lineBase+1, column)
+		if !ok {
+			result[i].Message = "no expression starts on this line"
+			continue
+		}
+		positions = append(positions, pos)
+		line, col := prog.lineColumn(pos)
+		result[i] = breakpoint{Verified: true, Line: line + s.lineBase - 1, Column: col + s.columnBase - 1}
+	}
+	s.breakpoints[prog.path] = positions
+	return result
+}
+
+// start starts the evaluation.
+func (s *session) start() {
+	if s.done != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+	ev := evaluator.New(
+		evaluator.WithTimeout(0), // the evaluation waits for the user
+		evaluator.WithDebugger(s.pause, s.breakpoints[s.prog.path]...),
+	)
+	go func() {
+		defer close(s.done)
+		result, err := ev.Eval(ctx, s.prog.expr, s.data)
+		exitCode := 0
+		if err != nil {
+			exitCode = 1
+			_ = s.out.event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+		} else {
+			_ = s.out.event("output", map[string]interface{}{"category": "stdout", "output": display(result) + "\n"})
+		}
+		_ = s.out.event("exited", map[string]interface{}{"exitCode": exitCode})
+		_ = s.out.event("terminated", nil)
+	}()
+}
+
+// stop aborts the evaluation, if running, and waits for it to end.
+func (s *session) stop() {
+	if s.done == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// pause is the debug hook of the evaluation: it reports the pause to the
+// client and waits for the action of the user.
+func (s *session) pause(ctx context.Context, frame *evaluator.DebugFrame) evaluator.DebugAction {
+	reason := "step"
+	switch {
+	case frame.Breakpoint:
+		reason = "breakpoint"
+	case !s.entered:
+		if !s.launch.StopOnEntry {
+			s.entered = true
+			return evaluator.DebugContinue
+		}
+		reason = "entry"
+	}
+	s.entered = true
+
+	s.mu.Lock()
+	s.frame, s.handles = frame, nil
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.frame, s.handles = nil, nil
+		s.mu.Unlock()
+	}()
+	_ = s.out.event("stopped", map[string]interface{}{
+		"reason": reason, "threadId": threadID, "allThreadsStopped": true,
+	})
+	select {
+	case action := <-s.resume:
+		return action
+	case <-ctx.Done():
+		return evaluator.DebugAbort
+	}
+}
+
+// stackTrace reports the paused node as the only stack frame.
+func (s *session) stackTrace() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames := []stackFrame{}
+	if s.frame != nil {
+		line, column := s.prog.lineColumn(s.frame.Position)
+		frames = append(frames, stackFrame{
+			ID:     1,
+			Name:   s.prog.label(s.frame.Node),
+			Source: &source{Name: filepath.Base(s.prog.path), Path: s.prog.path},
+			Line:   line + s.lineBase - 1,
+			Column: column + s.columnBase - 1,
+		})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// namedValue is an entry of a scope.
+type namedValue struct {
+	name  string
+	value interface{}
+}
+
+// scopes returns the scopes of the paused frame: the variables bound, and
+// the context value and input.
+func (s *session) scopes() []scope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frame == nil {
+		return []scope{}
+	}
+	names := make([]string, 0, len(s.frame.Bindings))
+	for name := range s.frame.Bindings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	locals := make([]namedValue, len(names))
+	for i, name := range names {
+		locals[i] = namedValue{"$" + name, s.frame.Bindings[name]}
+	}
+	data := []namedValue{{"$", s.frame.Data}, {"$$", s.frame.Root}}
+	return []scope{
+		{Name: "Variables", VariablesReference: s.reference(locals)},
+		{Name: "Context", VariablesReference: s.reference(data)},
+	}
+}
+
+// reference returns the variablesReference of the container v. The caller
+// holds s.mu.
+func (s *session) reference(v interface{}) int {
+	s.handles = append(s.handles, v)
+	return len(s.handles)
+}
+
+// variables lists the entries of the container with the given reference.
+func (s *session) variables(ref int) ([]variable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ref < 1 || ref > len(s.handles) {
+		return nil, errors.New("the variables are no longer available")
+	}
+	var entries []namedValue
+	switch c := s.handles[ref-1].(type) {
+	case []namedValue:
+		entries = c
+	case []interface{}:
+		for i, item := range c {
+			entries = append(entries, namedValue{strconv.Itoa(i), item})
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(c))
+		for k := range c {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			entries = append(entries, namedValue{k, c[k]})
+		}
+	case *evaluator.OrderedObject:
+		for _, k := range c.Keys {
+			entries = append(entries, namedValue{k, c.Values[k]})
+		}
+	}
+	vars := make([]variable, len(entries))
+	for i, e := range entries {
+		vars[i] = s.variable(e.name, e.value)
+	}
+	return vars, nil
+}
+
+// variable describes v, giving a reference to its entries when it has any.
+// The caller holds s.mu.
+func (s *session) variable(name string, v interface{}) variable {
+	switch c := v.(type) {
+	case []interface{}:
+		return variable{Name: name, Value: fmt.Sprintf("array[%d]", len(c)), Type: "array", VariablesReference: s.reference(c)}
+	case map[string]interface{}:
+		return variable{Name: name, Value: fmt.Sprintf("object{%d}", len(c)), Type: "object", VariablesReference: s.reference(c)}
+	case *evaluator.OrderedObject:
+		return variable{Name: name, Value: fmt.Sprintf("object{%d}", len(c.Keys)), Type: "object", VariablesReference: s.reference(c)}
+	}
+	return variable{Name: name, Value: display(v), Type: typeName(v)}
+}
+
+// evaluate evaluates expression against the paused frame, with its
+// variables, or against the input when the evaluation is not paused.
+func (s *session) evaluate(req *message, expression string) error {
+	expr, err := parser.Compile(expression)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	data, bindings := s.data, map[string]interface{}(nil)
+	if s.frame != nil {
+		data, bindings = s.frame.Data, s.frame.Bindings
+	}
+	s.mu.Unlock()
+
+	ev := evaluator.New(evaluator.WithTimeout(evaluateTimeout), evaluator.WithFunctionShadowing(true))
+	result, err := ev.EvalWithBindings(context.Background(), expr, data, bindings)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	v := s.variable("", result)
+	s.mu.Unlock()
+	return s.out.respond(req, map[string]interface{}{
+		"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference,
+	})
+}
+
+// display renders a value the way a debugger shows it: JSON, or a
+// placeholder for undefined and functions.
+func display(v interface{}) string {
+	switch typeName(v) {
+	case "undefined", "function":
+		return typeName(v)
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(js)
+}
+
+// typeName is the JSONata type of v, as $type reports it.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "undefined"
+	case types.Null:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}, *evaluator.OrderedObject:
+		return "object"
+	case *evaluator.Lambda, *evaluator.FunctionDef:
+		return "function"
+	}
+	if reflect.ValueOf(v).Kind() == reflect.Func {
+		return "function"
+	}
+	return ""
+}
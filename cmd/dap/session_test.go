@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sandrolain/gosonata/internal/framing"
+)
+
+// client drives a session over in-memory pipes.
+type client struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	msgs chan *message
+	seq  int
+}
+
+func newClient(t *testing.T) *client {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(reqR, respW)
+		respW.Close()
+	}()
+	c := &client{t: t, in: reqW, msgs: make(chan *message, 64)}
+	go func() {
+		defer close(c.msgs)
+		r := bufio.NewReader(respR)
+		for {
+			msg, err := readMessage(r)
+			if err != nil {
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	t.Cleanup(func() {
+		reqW.Close()
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	return c
+}
+
+// request sends a request and returns its response.
+func (c *client) request(command string, args interface{}) *message {
+	c.t.Helper()
+	c.seq++
+	raw, err := json.Marshal(args)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	body, _ := json.Marshal(&message{Seq: c.seq, Type: "request", Command: command, Arguments: raw})
+	if err := framing.Write(c.in, body); err != nil {
+		c.t.Fatal(err)
+	}
+	seq := c.seq
+	resp := c.expect(command+" response", func(m *message) bool { return m.Type == "response" && m.RequestSeq == seq })
+	if resp.Success == nil || !*resp.Success {
+		c.t.Fatalf("%s failed: %s", command, resp.Message)
+	}
+	return resp
+}
+
+// expect returns the next message matching match, skipping the others.
+func (c *client) expect(what string, match func(*message) bool) *message {
+	c.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatalf("connection closed waiting for %s", what)
+			}
+			if match(msg) {
+				return msg
+			}
+		case <-timeout:
+			c.t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// event returns the next event named name.
+func (c *client) event(name string) *message {
+	c.t.Helper()
+	return c.expect(name+" event", func(m *message) bool { return m.Type == "event" && m.Event == name })
+}
+
+// body decodes the body of msg into v.
+func body(t *testing.T, msg *message, v interface{}) {
+	t.Helper()
+	raw, err := json.Marshal(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	program := filepath.Join(dir, "expr.jsonata")
+	data := filepath.Join(dir, "input.json")
+	if err := os.WriteFile(program, []byte("(\n  $x := 2;\n  a.($ * $x)\n)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(data, []byte(`{"a": [1, 2]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	c.request("initialize", map[string]interface{}{"adapterID": "jsonata"})
+	c.event("initialized")
+
+	var bps struct{ Breakpoints []breakpoint }
+	body(t, c.request("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: program},
+		Breakpoints: []sourceBreakpoint{{Line: 3}},
+	}), &bps)
+	if len(bps.Breakpoints) != 1 || !bps.Breakpoints[0].Verified || bps.Breakpoints[0].Line != 3 {
+		t.Fatalf("breakpoints: %+v", bps.Breakpoints)
+	}
+
+	c.request("launch", launchArguments{Program: program, Data: data})
+	c.request("configurationDone", nil)
+
+	var stopped struct{ Reason string }
+	body(t, c.event("stopped"), &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("stopped for %q, want breakpoint", stopped.Reason)
+	}
+	var trace struct{ StackFrames []stackFrame }
+	body(t, c.request("stackTrace", map[string]interface{}{"threadId": threadID}), &trace)
+	if len(trace.StackFrames) != 1 || trace.StackFrames[0].Line != 3 || trace.StackFrames[0].Column != 4 {
+		t.Errorf("stack: %+v", trace.StackFrames)
+	}
+	var result struct{ Result string }
+	body(t, c.request("evaluate", evaluateArguments{Expression: "$x * 10"}), &result)
+	if result.Result != "20" {
+		t.Errorf("evaluate: got %q, want 20", result.Result)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": threadID})
+	var output struct{ Category, Output string }
+	body(t, c.event("output"), &output)
+	if output.Category != "stdout" || output.Output != "[2,4]\n" {
+		t.Errorf("output: %+v", output)
+	}
+	var exited struct{ ExitCode int }
+	body(t, c.event("exited"), &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("exit code %d", exited.ExitCode)
+	}
+	c.event("terminated")
+	c.request("disconnect", nil)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// program is an expression file being debugged.
+type program struct {
+	path string
+	src  string
+	expr *types.Expression
+}
+
+// lineColumn converts a byte offset of the source into a 1-based line and
+// rune column.
+func (p *program) lineColumn(pos int) (line, column int) {
+	pos = max(0, min(pos, len(p.src)))
+	before := p.src[:pos]
+	line = strings.Count(before, "\n") + 1
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	return line, utf8.RuneCountInString(before[lineStart:]) + 1
+}
+
+// breakpoint returns the position of the node a breakpoint at line and column
+// (1-based, column 0 for the whole line) stops at: the outermost node starting
+// on the line, at or after the column. The outermost node is the first one
+// evaluated: its children start on the line too, but are evaluated inside it.
+func (p *program) breakpoint(line, column int) (int, bool) {
+	found, pos := false, 0
+	var walk func(n *types.ASTNode)
+	walk = func(n *types.ASTNode) {
+		if n == nil || found {
+			return
+		}
+		if l, c := p.lineColumn(n.Position); l == line && c >= column {
+			found, pos = true, n.Position
+			return
+		}
+		walk(n.LHS)
+		walk(n.RHS)
+		for _, children := range [][]*types.ASTNode{n.Steps, n.Arguments, n.Expressions} {
+			for _, c := range children {
+				walk(c)
+			}
+		}
+	}
+	walk(p.expr.AST())
+	return pos, found
+}
+
+// label names the stack frame of a paused node, after the source it starts
+// with.
+func (p *program) label(node *types.ASTNode) string {
+	text := p.src[max(0, min(node.Position, len(p.src))):]
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimSpace(text)
+	if r := []rune(text); len(r) > 40 {
+		text = string(r[:40]) + "…"
+	}
+	return fmt.Sprintf("%s: %s", node.Type, text)
+}
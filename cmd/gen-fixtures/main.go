@@ -0,0 +1,96 @@
+// Command gen-fixtures writes a golden-file test case for an expression and a
+// sample input, to lock down the current behavior of the expression before it
+// is refactored. The case file holds the expression, the input, the result (or
+// error code) and a trace of the evaluated nodes, and is run by pkg/testkit
+// like any hand-written case.
+//
+// Usage:
+//
+//	gen-fixtures -expr 'orders[total > 100].id' -data orders.json -o testdata/big_orders.json
+//	gen-fixtures -expr-file lib/discount.jsonata -data - < order.json
+//
+// Flags:
+//
+//	-expr       the expression
+//	-expr-file  a file holding the expression, instead of -expr
+//	-data       a JSON file holding the input, or - for stdin; no input when omitted
+//	-seed       the seed of $random and $shuffle (default 0)
+//	-o          the case file to write; stdout when omitted
+//
+// The evaluation runs in deterministic mode (see testkit.Generate): random
+// functions are seeded and the seed is recorded in the case, and expressions
+// reading the current time are rejected. Cases that need custom functions are
+// generated with testkit.Generate from Go code instead.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sandrolain/gosonata/pkg/testkit"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "gen-fixtures:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("gen-fixtures", flag.ContinueOnError)
+	expr := flags.String("expr", "", "the expression")
+	exprFile := flags.String("expr-file", "", "a file holding the expression")
+	dataFile := flags.String("data", "", "a JSON file holding the input, or - for stdin")
+	seed := flags.Uint64("seed", 0, "the seed of $random and $shuffle")
+	out := flags.String("o", "", "the case file to write (default stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case *expr != "" && *exprFile != "":
+		return errors.New("-expr and -expr-file are exclusive")
+	case *exprFile != "":
+		src, err := os.ReadFile(*exprFile)
+		if err != nil {
+			return err
+		}
+		*expr = string(src)
+	case *expr == "":
+		return errors.New("no expression: use -expr or -expr-file")
+	}
+
+	var data interface{}
+	if *dataFile != "" {
+		var raw []byte
+		var err error
+		if *dataFile == "-" {
+			raw, err = io.ReadAll(stdin)
+		} else {
+			raw, err = os.ReadFile(*dataFile)
+		}
+		if err != nil {
+			return err
+		}
+		// Decode the input the way testkit loads it, so that the case replays
+		// the evaluation it records.
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return fmt.Errorf("data: %w", err)
+		}
+	}
+
+	fixture, err := testkit.Generate(*expr, data, *seed)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = stdout.Write(fixture)
+		return err
+	}
+	return os.WriteFile(*out, fixture, 0o644)
+}
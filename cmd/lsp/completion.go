@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+)
+
+// sampleTimeout bounds the evaluation of the path completed from the sample
+// data.
+const sampleTimeout = time.Second
+
+// variablePattern matches the variables of an expression.
+var variablePattern = regexp.MustCompile(`\$[A-Za-z_][A-Za-z0-9_]*`)
+
+// simpleName matches the field names written without backquotes.
+var simpleName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// textEdit replaces a range of the document.
+type textEdit struct {
+	Range   textRange `json:"range"`
+	NewText string    `json:"newText"`
+}
+
+// completionEdit is a completion item that replaces the name being typed.
+type completionEdit struct {
+	completionItem
+	TextEdit textEdit `json:"textEdit"`
+}
+
+// complete returns the completions at offset: functions and variables after
+// a $, and otherwise the fields the sample data of the document has where
+// the name being typed is read.
+func (s *server) complete(uri string, d *document, offset int) []completionEdit {
+	start := offset
+	for start > 0 && isNameByte(d.text[start-1]) {
+		start--
+	}
+	if i := strings.LastIndexByte(d.text[start:offset], '$'); i >= 0 {
+		start += i
+	}
+	prefix := d.text[start:offset]
+	edit := func(item completionItem, text string) completionEdit {
+		return completionEdit{item, textEdit{Range: d.span(start, offset), NewText: text}}
+	}
+
+	items := []completionEdit{}
+	if strings.HasPrefix(prefix, "$") {
+		for _, name := range s.ev.FunctionNames() {
+			fn, _ := s.ev.LookupFunction(name)
+			usage, doc := functionHelp(fn)
+			items = append(items, edit(completionItem{
+				Label:         "$" + name,
+				Kind:          kindFunction,
+				Detail:        usage,
+				Documentation: &markupContent{Kind: "markdown", Value: doc},
+			}, "$"+name))
+		}
+		seen := map[string]bool{}
+		for _, loc := range variablePattern.FindAllStringIndex(d.text, -1) {
+			name := d.text[loc[0]:loc[1]]
+			if loc[0] == start || seen[name] {
+				continue
+			}
+			seen[name] = true
+			if _, isFunc := s.ev.LookupFunction(name[1:]); !isFunc {
+				items = append(items, edit(completionItem{Label: name, Kind: kindVariable}, name))
+			}
+		}
+		return items
+	}
+
+	for _, field := range s.fields(uri, d.text, start) {
+		text := field
+		if !simpleName.MatchString(field) {
+			text = "`" + strings.ReplaceAll(field, "`", "") + "`"
+		}
+		items = append(items, edit(completionItem{Label: field, Kind: kindField}, text))
+	}
+	return items
+}
+
+// fields returns the fields of the sample data of uri where the path step
+// starting at offset is read: the fields of the value of the path before it,
+// of the value filtered by the predicate it is in, or of the input.
+func (s *server) fields(uri, text string, offset int) []string {
+	data, err := s.sample(uri)
+	if err != nil || data == nil {
+		return nil
+	}
+	if path := pathBefore(text, offset); path != "" {
+		expr, err := parser.Compile(path)
+		if err != nil {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sampleTimeout)
+		defer cancel()
+		if data, err = s.ev.Eval(ctx, expr, data); err != nil {
+			return nil
+		}
+	}
+	seen := map[string]bool{}
+	var keys []string
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch x := v.(type) {
+		case []interface{}:
+			for _, item := range x {
+				collect(item)
+			}
+		case map[string]interface{}:
+			for k := range x {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+		case *evaluator.OrderedObject:
+			for _, k := range x.Keys {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+	}
+	collect(data)
+	slices.Sort(keys)
+	return keys
+}
+
+// pathBefore returns the path expression whose value the step starting at
+// offset navigates: the one before the . or [ preceding offset, or "" for
+// the input.
+func pathBefore(text string, offset int) string {
+	end := offset
+	if end == 0 || text[end-1] != '.' && text[end-1] != '[' {
+		return ""
+	}
+	end--
+	start, depth := end, 0
+	for start > 0 {
+		c := text[start-1]
+		switch {
+		case c == ')' || c == ']' || c == '}':
+			depth++
+		case c == '(' || c == '[' || c == '{':
+			if depth == 0 {
+				return strings.TrimSpace(text[start:end])
+			}
+			depth--
+		case depth == 0 && !isNameByte(c) && c != '.' && c != '`':
+			return strings.TrimSpace(text[start:end])
+		}
+		start--
+	}
+	return strings.TrimSpace(text[start:end])
+}
+
+// sample returns the sample data of the document uri: the JSON file next to
+// it with the same name, as orders.json for orders.jsonata, or else the
+// sample data of the server.
+func (s *server) sample(uri string) (interface{}, error) {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		path := strings.TrimSuffix(u.Path, filepath.Ext(u.Path)) + ".json"
+		if path != u.Path {
+			if v, err := readJSON(path); err == nil {
+				return v, nil
+			}
+		}
+	}
+	if s.sampleData == "" {
+		return nil, nil
+	}
+	return readJSON(s.sampleData)
+}
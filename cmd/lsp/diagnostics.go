@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// diagnose compiles d and reports its errors and warnings. The expression is
+// compiled in strict mode (see parser.WithStrict); once it compiles, calls of
+// functions the evaluator does not know are reported as warnings, as they may
+// be bound at evaluation time.
+func (s *server) diagnose(d *document) []diagnostic {
+	diags := []diagnostic{}
+	expr, err := parser.Compile(d.text, parser.WithStrict(true))
+	if err != nil {
+		return append(diags, s.errorDiagnostic(d, err, severityError))
+	}
+	if _, err := parser.Compile(d.text, parser.WithKnownFunctions(s.ev.KnownFunction)); err != nil {
+		diags = append(diags, s.errorDiagnostic(d, err, severityWarning))
+	}
+	for _, w := range expr.Warnings() {
+		diags = append(diags, diagnostic{
+			Range:    d.tokenRange(w.Position),
+			Severity: severityWarning,
+			Code:     w.Code,
+			Source:   "jsonata",
+			Message:  w.Message,
+		})
+	}
+	return diags
+}
+
+// errorDiagnostic reports a compile error.
+func (s *server) errorDiagnostic(d *document, err error, severity int) diagnostic {
+	diag := diagnostic{Severity: severity, Source: "jsonata", Message: err.Error()}
+	var perr *types.Error
+	if !errors.As(err, &perr) || perr.Position < 0 {
+		return diag
+	}
+	diag.Range = d.tokenRange(perr.Position)
+	diag.Code = string(perr.Code)
+	diag.Message = perr.Message
+	if perr.Suggestion != "" {
+		diag.Message += "; did you mean " + perr.Suggestion + "?"
+	}
+	return diag
+}
+
+// tokenRange returns the range of the name at offset, or of the character
+// there when there is no name.
+func (d *document) tokenRange(offset int) textRange {
+	offset = max(0, min(offset, len(d.text)))
+	if name, start := d.word(offset); name != "" {
+		return d.span(start, start+len(name))
+	}
+	if offset < len(d.text) {
+		return d.span(offset, offset+1)
+	}
+	return d.span(offset, offset)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// document is an open expression file.
+type document struct {
+	text string
+	// lines holds the byte offset of the start of each line.
+	lines []int
+}
+
+func newDocument(text string) *document {
+	d := &document{text: text, lines: []int{0}}
+	for i := range len(text) {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	return d
+}
+
+// position converts a byte offset into an LSP position, whose character is
+// counted in UTF-16 code units.
+func (d *document) position(offset int) position {
+	offset = max(0, min(offset, len(d.text)))
+	line := 0
+	for line+1 < len(d.lines) && d.lines[line+1] <= offset {
+		line++
+	}
+	units := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		units += utf16.RuneLen(r)
+	}
+	return position{Line: line, Character: units}
+}
+
+// offset converts an LSP position into a byte offset.
+func (d *document) offset(p position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset, units := d.lines[p.Line], 0
+	for offset < len(d.text) && units < p.Character {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		if r == '\n' {
+			break
+		}
+		units += utf16.RuneLen(r)
+		offset += size
+	}
+	return offset
+}
+
+// span returns the range of the text between two byte offsets.
+func (d *document) span(start, end int) textRange {
+	return textRange{Start: d.position(start), End: d.position(end)}
+}
+
+// isNameByte reports whether c may appear in a field or variable name.
+func isNameByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= utf8.RuneSelf
+}
+
+// word returns the name around offset, $ included, and where it starts.
+func (d *document) word(offset int) (string, int) {
+	start, end := offset, offset
+	for start > 0 && isNameByte(d.text[start-1]) {
+		start--
+	}
+	for end < len(d.text) && isNameByte(d.text[end]) {
+		end++
+	}
+	// A $ only starts a name.
+	if i := strings.LastIndexByte(d.text[start:end], '$'); i > 0 {
+		if start+i > offset {
+			end = start + i
+		} else {
+			start += i
+		}
+	}
+	return d.text[start:end], start
+}
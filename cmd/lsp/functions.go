@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+)
+
+// functionDoc documents a built-in function: how it is called and what it
+// does. Optional parameters are in brackets.
+type functionDoc struct {
+	usage string
+	doc   string
+}
+
+// builtinDocs documents the built-in functions. Functions missing from it,
+// custom ones included, are described by their signature only.
+var builtinDocs = map[string]functionDoc{
+	// Aggregation
+	"sum":     {"$sum(array)", "Returns the sum of an array of numbers."},
+	"count":   {"$count(array)", "Returns the number of items in the array."},
+	"average": {"$average(array)", "Returns the mean of an array of numbers."},
+	"min":     {"$min(array)", "Returns the minimum number in an array of numbers."},
+	"max":     {"$max(array)", "Returns the maximum number in an array of numbers."},
+
+	// Arrays
+	"map":      {"$map(array, function)", "Returns an array holding the results of applying function to each item of array. function receives the item, its index and the array."},
+	"filter":   {"$filter(array, function)", "Returns the items of array for which function returns true. function receives the item, its index and the array."},
+	"reduce":   {"$reduce(array, function [, init])", "Folds array into a single value: function is called with the accumulated value and each item in turn, starting from init or the first item."},
+	"done":     {"$done([value])", "Ends the iteration of $reduce early, with value as its result."},
+	"single":   {"$single(array [, function])", "Returns the one item of array for which function returns true; an error if there are none or several."},
+	"sort":     {"$sort(array [, function])", "Returns array sorted in ascending order, or by function, which returns true when its first argument goes after its second."},
+	"append":   {"$append(array1, array2)", "Returns the concatenation of the two arrays; a value that is not an array counts as a one-item array."},
+	"reverse":  {"$reverse(array)", "Returns array in reverse order."},
+	"distinct": {"$distinct(array)", "Returns array without its duplicate values."},
+	"shuffle":  {"$shuffle(array)", "Returns array in random order."},
+	"zip":      {"$zip(array1, ...)", "Returns an array of arrays, the n-th holding the n-th item of each argument; as long as the shortest argument."},
+	"joinOn":   {"$joinOn(left, right, leftKey, rightKey [, type])", "Joins two arrays of objects on keys, field names or functions of the item, into {\"left\", \"right\"} rows; type is \"inner\" (default), \"left\" or \"outer\"."},
+	"first":    {"$first(array [, function])", "Returns the first item of array, or the first one for which function returns true."},
+	"any":      {"$any(array, function)", "Returns true when function returns true for an item of array."},
+	"all":      {"$all(array, function)", "Returns true when function returns true for every item of array."},
+	"index":    {"$index()", "Returns the position (from 0) of the item a filter predicate is evaluated for."},
+
+	// Strings
+	"string":          {"$string([value] [, prettify])", "Casts value to a string; objects and arrays are written as JSON, indented when prettify is true."},
+	"length":          {"$length(str)", "Returns the number of characters in str."},
+	"substring":       {"$substring(str, start [, length])", "Returns the part of str starting at start (from 0; negative counts from the end), of length characters or to the end."},
+	"uppercase":       {"$uppercase(str)", "Returns str in uppercase."},
+	"lowercase":       {"$lowercase(str)", "Returns str in lowercase."},
+	"trim":            {"$trim(str)", "Returns str with surrounding whitespace removed and inner runs of whitespace collapsed into one space."},
+	"contains":        {"$contains(str, pattern)", "Returns true when str contains pattern, a string or a regular expression."},
+	"split":           {"$split(str, separator [, limit])", "Splits str into an array of at most limit strings at each occurrence of separator, a string or a regular expression."},
+	"join":            {"$join(array [, separator])", "Joins an array of strings into a string, with separator between them."},
+	"pad":             {"$pad(str, width [, char])", "Pads str to width characters with char (a space by default): on the right when width is positive, on the left when negative."},
+	"substringBefore": {"$substringBefore(str, chars)", "Returns the part of str before the first occurrence of chars, or str itself."},
+	"substringAfter":  {"$substringAfter(str, chars)", "Returns the part of str after the first occurrence of chars, or str itself."},
+	"match":           {"$match(str, pattern [, limit])", "Returns the matches of the regular expression pattern in str, as objects with match, index and groups."},
+	"replace":         {"$replace(str, pattern, replacement [, limit])", "Replaces the occurrences of pattern, a string or a regular expression, in str by replacement, a string or a function of the match."},
+
+	// Types
+	"type":    {"$type(value)", "Returns the type of value: \"null\", \"number\", \"string\", \"boolean\", \"array\", \"object\" or \"function\"."},
+	"exists":  {"$exists(value)", "Returns true when value is defined."},
+	"number":  {"$number(value)", "Casts value to a number: strings are parsed as JSON numbers (or hexadecimal, octal and binary literals), booleans become 1 and 0."},
+	"boolean": {"$boolean(value)", "Casts value to a boolean: empty strings, arrays and objects, 0, null and false are false."},
+	"not":     {"$not(value)", "Returns the boolean negation of value."},
+
+	// Numbers
+	"abs":           {"$abs(number)", "Returns the absolute value of number."},
+	"floor":         {"$floor(number)", "Returns number rounded down."},
+	"ceil":          {"$ceil(number)", "Returns number rounded up."},
+	"round":         {"$round(number [, precision])", "Returns number rounded to precision decimal places (0 by default), half to even."},
+	"sqrt":          {"$sqrt(number)", "Returns the square root of number."},
+	"power":         {"$power(base, exponent)", "Returns base raised to exponent."},
+	"random":        {"$random([n [, max]])", "Returns a random number between 0 (inclusive) and 1 (exclusive); with n, an integer from 0 to n-1; with two bounds, an integer between them, both included."},
+	"formatNumber":  {"$formatNumber(number, picture [, options])", "Formats number with the XPath decimal format picture, such as \"#,##0.00\"."},
+	"formatBase":    {"$formatBase(number [, radix])", "Writes number as an integer in base radix (2 to 36; 10 by default)."},
+	"formatInteger": {"$formatInteger(number, picture)", "Formats an integer with the XPath picture, such as \"000\", \"w\" (words) or \"I\" (roman numerals)."},
+	"parseInteger":  {"$parseInteger(str, picture)", "Parses an integer written with the XPath picture, the inverse of $formatInteger."},
+
+	// Objects
+	"each":       {"$each(object, function)", "Returns an array holding the results of applying function to each value of object, with its key."},
+	"sift":       {"$sift(object, function)", "Returns the entries of object for which function, called with the value and the key, returns true."},
+	"siftValues": {"$siftValues(object [, function])", "Returns the entries of object whose value is truthy, or for which function, called with the value and the key, returns true."},
+	"keys":       {"$keys(object)", "Returns the keys of object, or the distinct keys of an array of objects."},
+	"lookup":     {"$lookup(object, key)", "Returns the value of key in object, or the values in an array of objects."},
+	"merge":      {"$merge(array)", "Merges an array of objects into one; later keys replace earlier ones."},
+	"spread":     {"$spread(object)", "Splits object into an array of one-entry objects."},
+	"error":      {"$error([message])", "Fails the evaluation with message."},
+	"assert":     {"$assert(condition [, message])", "Fails the evaluation with message when condition is false."},
+	"eval":       {"$eval(expr [, context])", "Evaluates the expression in the string expr against context, or the current context value."},
+	"doc":        {"$doc(name)", "Returns the document name, as loaded by the evaluator's document loader."},
+
+	// Dates
+	"now":        {"$now([picture [, timezone]])", "Returns the time of the evaluation as an ISO 8601 timestamp, or formatted with picture; the same for the whole evaluation."},
+	"millis":     {"$millis()", "Returns the time of the evaluation in milliseconds since the Unix epoch."},
+	"fromMillis": {"$fromMillis(number [, picture [, timezone]])", "Formats milliseconds since the Unix epoch as an ISO 8601 timestamp, or with picture."},
+	"toMillis":   {"$toMillis(timestamp [, picture])", "Parses an ISO 8601 timestamp, or one written with picture, into milliseconds since the Unix epoch."},
+
+	// Encoding
+	"base64encode":       {"$base64encode(str)", "Encodes str in base 64."},
+	"base64decode":       {"$base64decode(str)", "Decodes the base 64 string str."},
+	"encodeUrl":          {"$encodeUrl(str)", "Percent-encodes the characters of str that cannot appear in a URL."},
+	"decodeUrl":          {"$decodeUrl(str)", "Decodes a URL encoded with $encodeUrl."},
+	"encodeUrlComponent": {"$encodeUrlComponent(str)", "Percent-encodes the characters of str that cannot appear in a URL component."},
+	"decodeUrlComponent": {"$decodeUrlComponent(str)", "Decodes a URL component encoded with $encodeUrlComponent."},
+	"fromHex":            {"$fromHex(str)", "Decodes the hexadecimal string str into bytes."},
+	"toHex":              {"$toHex(bytes)", "Encodes bytes, or the UTF-8 bytes of a string, as a lowercase hexadecimal string."},
+	"fromBase64Bytes":    {"$fromBase64Bytes(str)", "Decodes the base 64 string str into bytes."},
+}
+
+// functionHelp describes the function fn for hovers and completions: its
+// usage and, in markdown, its documentation.
+func functionHelp(fn *evaluator.FunctionDef) (usage, doc string) {
+	d, ok := builtinDocs[fn.Name]
+	if builtin, _ := evaluator.GetFunction(fn.Name); !ok || builtin != fn {
+		usage = "$" + fn.Name
+		if fn.Signature != "" {
+			usage += fn.Signature
+		}
+		return usage, "Custom function."
+	}
+	doc = d.doc
+	if fn.Signature != "" {
+		doc += fmt.Sprintf("\n\nSignature: `%s`", fn.Signature)
+	}
+	return d.usage, doc
+}
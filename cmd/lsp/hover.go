@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hover documents the function named at offset, or returns nil.
+func (s *server) hover(d *document, offset int) *hover {
+	name, start := d.word(offset)
+	if !strings.HasPrefix(name, "$") {
+		return nil
+	}
+	fn, ok := s.ev.LookupFunction(name[1:])
+	if !ok {
+		return nil
+	}
+	usage, doc := functionHelp(fn)
+	r := d.span(start, start+len(name))
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: fmt.Sprintf("```jsonata\n%s\n```\n\n%s", usage, doc)},
+		Range:    &r,
+	}
+}
@@ -0,0 +1,49 @@
+// Command lsp is a Language Server Protocol server for JSONata expression
+// files, driven by this module's parser and function registry, so that
+// editors check and complete expressions exactly as GoSonata reads them:
+//
+//   - diagnostics: syntax errors, the errors of strict mode (see
+//     parser.WithStrict) and the parser's warnings; calls of unknown
+//     functions are warnings, as they may be bound at evaluation time;
+//   - hover: the documentation of the function under the cursor;
+//   - completion: functions and the expression's variables after a $, and
+//     elsewhere the fields of sample data, read where the name being typed
+//     is: after `orders.` the fields of the orders, inside `orders[` those
+//     of each order.
+//
+// The sample data of an expression file is the JSON file next to it with the
+// same name (orders.json for orders.jsonata), or else the file given with
+// -data or the sampleData initialization option.
+//
+// Formatting is not provided: the parser does not keep comments and the
+// module has no printer writing an expression back from its syntax tree.
+//
+// Usage:
+//
+//	lsp [-data sample.json]
+//
+// The server speaks over stdin and stdout, as editors launch language
+// servers.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "lsp:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	data := flags.String("data", "", "a JSON file whose fields are offered as completions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	return serve(os.Stdin, os.Stdout, *data)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+)
+
+// errExit ends serve after the exit notification, without a shutdown
+// request first.
+var errExit = errors.New("exit without shutdown")
+
+// server is a language server for one client.
+type server struct {
+	out *writer
+	// ev holds the function registry the server checks calls against and
+	// documents.
+	ev *evaluator.Evaluator
+	// sampleData is the JSON file whose fields are offered as completions
+	// when an expression file has no sample of its own.
+	sampleData string
+
+	docs        map[string]*document // by URI
+	initialized bool
+	shutdown    bool
+}
+
+// serve runs the server over r and w until the client exits.
+func serve(r io.Reader, w io.Writer, sampleData string) error {
+	s := &server{
+		out:        &writer{w: w},
+		ev:         evaluator.New(),
+		sampleData: sampleData,
+		docs:       map[string]*document{},
+	}
+	in := bufio.NewReader(r)
+	for {
+		msg, err := readMessage(in)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errExit
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers a request, or applies a notification.
+func (s *server) handle(msg *message) error {
+	isRequest := len(msg.ID) > 0
+	if !s.initialized && msg.Method != "initialize" {
+		if isRequest {
+			return s.out.fail(msg, codeServerNotInitialized, errors.New("the server is not initialized"))
+		}
+		return nil
+	}
+
+	switch msg.Method {
+	case "initialize":
+		var params initializeParams
+		if err := unmarshalParams(msg, &params); err != nil {
+			return s.out.fail(msg, codeInvalidParams, err)
+		}
+		if params.InitializationOptions.SampleData != "" {
+			s.sampleData = params.InitializationOptions.SampleData
+		}
+		s.initialized = true
+		return s.out.reply(msg, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": 1, // full
+				"hoverProvider":    true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{".", "$", "["},
+				},
+			},
+			"serverInfo": map[string]string{"name": "gosonata-lsp"},
+		})
+
+	case "shutdown":
+		s.shutdown = true
+		return s.out.reply(msg, nil)
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := unmarshalParams(msg, &params); err != nil {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := unmarshalParams(msg, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		// Full synchronization: the last change holds the whole text.
+		return s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := unmarshalParams(msg, &params); err != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.out.notify("textDocument/publishDiagnostics",
+			publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}})
+
+	case "textDocument/hover", "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(msg, &params); err != nil {
+			return s.out.fail(msg, codeInvalidParams, err)
+		}
+		d := s.docs[params.TextDocument.URI]
+		if d == nil {
+			return s.out.reply(msg, nil)
+		}
+		offset := d.offset(params.Position)
+		if msg.Method == "textDocument/hover" {
+			if h := s.hover(d, offset); h != nil {
+				return s.out.reply(msg, h)
+			}
+			return s.out.reply(msg, nil)
+		}
+		return s.out.reply(msg, s.complete(params.TextDocument.URI, d, offset))
+	}
+
+	if isRequest {
+		return s.out.fail(msg, codeMethodNotFound, fmt.Errorf("unsupported method %q", msg.Method))
+	}
+	return nil
+}
+
+func unmarshalParams(msg *message, params interface{}) error {
+	if len(msg.Params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return fmt.Errorf("%s: invalid params: %w", msg.Method, err)
+	}
+	return nil
+}
+
+// update records the text of a document and publishes its diagnostics.
+func (s *server) update(uri, text string) error {
+	d := newDocument(text)
+	s.docs[uri] = d
+	return s.out.notify("textDocument/publishDiagnostics",
+		publishDiagnosticsParams{URI: uri, Diagnostics: s.diagnose(d)})
+}
+
+// readJSON decodes the JSON file at path.
+func readJSON(path string) (interface{}, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return v, nil
+}
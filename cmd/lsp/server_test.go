@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sandrolain/gosonata/internal/framing"
+)
+
+// client drives a server over in-memory pipes.
+type client struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	msgs chan *message
+	done chan error
+	id   int
+}
+
+func newClient(t *testing.T) *client {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	c := &client{t: t, in: reqW, msgs: make(chan *message, 64), done: make(chan error, 1)}
+	go func() {
+		c.done <- serve(reqR, respW, "")
+		respW.Close()
+	}()
+	go func() {
+		defer close(c.msgs)
+		r := bufio.NewReader(respR)
+		for {
+			msg, err := readMessage(r)
+			if err != nil {
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	t.Cleanup(func() { reqW.Close() })
+	return c
+}
+
+func (c *client) send(msg *message) {
+	c.t.Helper()
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := framing.Write(c.in, body); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) params(v interface{}) json.RawMessage {
+	c.t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return raw
+}
+
+// request sends a request and decodes the result of its response into
+// result.
+func (c *client) request(method string, params, result interface{}) {
+	c.t.Helper()
+	c.id++
+	id, _ := json.Marshal(c.id)
+	c.send(&message{ID: id, Method: method, Params: c.params(params)})
+	resp := c.next()
+	if string(resp.ID) != string(id) {
+		c.t.Fatalf("%s: got a response to %s", method, resp.ID)
+	}
+	if resp.Error != nil {
+		c.t.Fatalf("%s: %s", method, resp.Error.Message)
+	}
+	raw, _ := json.Marshal(resp.Result)
+	if err := json.Unmarshal(raw, result); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.send(&message{Method: method, Params: c.params(params)})
+}
+
+// next returns the next message from the server.
+func (c *client) next() *message {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+	}
+	return nil
+}
+
+// diagnostics returns the next diagnostics the server publishes for uri.
+func (c *client) diagnostics(uri string) []diagnostic {
+	c.t.Helper()
+	msg := c.next()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("got %q, want diagnostics", msg.Method)
+	}
+	var params publishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	if params.URI != uri {
+		c.t.Fatalf("diagnostics of %s, want %s", params.URI, uri)
+	}
+	return params.Diagnostics
+}
+
+func TestServer(t *testing.T) {
+	const uri = "file:///tmp/expr.jsonata"
+	c := newClient(t)
+
+	var init struct {
+		Capabilities struct {
+			TextDocumentSync int
+			HoverProvider    bool
+		}
+	}
+	c.request("initialize", map[string]interface{}{}, &init)
+	if init.Capabilities.TextDocumentSync != 1 || !init.Capabilities.HoverProvider {
+		t.Errorf("capabilities: %+v", init.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+
+	c.notify("textDocument/didOpen", didOpenParams{TextDocument: textDocumentItem{URI: uri, Text: "$sum(1,"}})
+	diags := c.diagnostics(uri)
+	if len(diags) != 1 || diags[0].Severity != severityError || diags[0].Code == "" {
+		t.Fatalf("diagnostics: %+v", diags)
+	}
+
+	var change didChangeParams
+	change.TextDocument.URI = uri
+	change.ContentChanges = append(change.ContentChanges, struct {
+		Text string `json:"text"`
+	}{"$sum(items) + $nosuch()"})
+	c.notify("textDocument/didChange", change)
+	diags = c.diagnostics(uri)
+	if len(diags) != 1 || diags[0].Severity != severityWarning || !strings.Contains(diags[0].Message, "nosuch") {
+		t.Fatalf("diagnostics: %+v", diags)
+	}
+	if r := diags[0].Range; r.Start != (position{0, 14}) || r.End != (position{0, 21}) {
+		t.Errorf("range: %+v", r)
+	}
+
+	var h hover
+	c.request("textDocument/hover", textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{URI: uri},
+		Position:     position{Line: 0, Character: 2},
+	}, &h)
+	if !strings.Contains(h.Contents.Value, "$sum") {
+		t.Errorf("hover: %q", h.Contents.Value)
+	}
+
+	c.notify("textDocument/didClose", didCloseParams{TextDocument: textDocumentIdentifier{URI: uri}})
+	if diags := c.diagnostics(uri); len(diags) != 0 {
+		t.Errorf("diagnostics after close: %+v", diags)
+	}
+
+	var none interface{}
+	c.request("shutdown", nil, &none)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
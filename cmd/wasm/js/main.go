@@ -4,14 +4,22 @@
 //
 // It exposes a global `gosonata` object with the following API:
 //
-//	gosonata.version()               → string
-//	gosonata.eval(query, dataJSON)   → resultJSON  (throws on error)
-//	gosonata.compile(query)          → { eval(dataJSON) → resultJSON }  (throws on error)
+//	gosonata.version()                        → string
+//	gosonata.eval(query, dataJSON[, options]) → resultJSON  (throws on error)
+//	gosonata.compile(query)                   → { eval(dataJSON[, options]) → resultJSON, release() }  (throws on error)
+//
+// options is an optional object { bindings, timeoutMs, maxDepth }: bindings maps
+// variable names (without "$") to JSON-serializable values, bound as with
+// EvalWithBindings; timeoutMs and maxDepth set the evaluator's WithTimeout and
+// WithMaxDepth.
 //
 // Build:
 //
 //	GOOS=js GOARCH=wasm go build -o gosonata.wasm ./cmd/wasm/js/
 //
+// Add -tags minimal for a smaller binary without the $formatNumber and
+// date/time picture engines (see docs/WASM.md).
+//
 // Usage in Node.js (see examples/wasm/node/):
 //
 //	const { load } = require('./gosonata_wasm')
@@ -34,45 +42,121 @@ import (
 	"encoding/json"
 	"fmt"
 	"syscall/js"
+	"time"
 
 	"github.com/sandrolain/gosonata"
 	"github.com/sandrolain/gosonata/pkg/evaluator"
 )
 
-// jsThrow panics with a JS Error so the caller receives a thrown exception.
+// jsError is the panic value of jsThrow.
+type jsError string
+
+// jsThrow aborts the current call; the function wrapped by throwing then throws
+// a JS Error with msg to its caller.
 func jsThrow(msg string) {
-	js.Global().Get("Error").New(msg)
-	panic(msg)
+	panic(jsError(msg))
 }
 
-// jsEval implements gosonata.eval(query, dataJSON) → resultJSON.
-func jsEval(_ js.Value, args []js.Value) interface{} {
-	if len(args) < 2 {
-		jsThrow("gosonata.eval requires 2 arguments: query (string) and data (JSON string)")
+// rethrow is a JS helper wrapping a Go function so that an Error it returns is
+// thrown instead: a panic escaping a Go callback would terminate the program.
+var rethrow = js.Global().Get("Function").New("fn", `return function(...args) {
+	const r = fn(...args);
+	if (r instanceof Error) throw r;
+	return r;
+}`)
+
+// throwing exports fn to JS, turning jsThrow calls into thrown Errors. It
+// returns the JS function and the Go callback to release when it is dropped.
+func throwing(fn func(this js.Value, args []js.Value) interface{}) (js.Value, js.Func) {
+	goFn := js.FuncOf(func(this js.Value, args []js.Value) (result interface{}) {
+		defer func() {
+			if r := recover(); r != nil {
+				msg, ok := r.(jsError)
+				if !ok {
+					panic(r)
+				}
+				result = js.Global().Get("Error").New(string(msg))
+			}
+		}()
+		return fn(this, args)
+	})
+	return rethrow.Invoke(goFn), goFn
+}
+
+// evalOptions is the options object accepted by gosonata.eval and compiled.eval.
+type evalOptions struct {
+	Bindings  map[string]interface{} `json:"bindings"`
+	TimeoutMs float64                `json:"timeoutMs"`
+	MaxDepth  int                    `json:"maxDepth"`
+}
+
+// parseOptions reads the optional options argument args[i] of fn.
+func parseOptions(fn string, args []js.Value, i int) evalOptions {
+	var o evalOptions
+	if len(args) <= i || args[i].IsUndefined() || args[i].IsNull() {
+		return o
 	}
-	query := args[0].String()
-	dataJSON := args[1].String()
+	if args[i].Type() != js.TypeObject {
+		jsThrow(fmt.Sprintf("%s: options must be an object", fn))
+	}
+	optsJSON := js.Global().Get("JSON").Call("stringify", args[i]).String()
+	if err := json.Unmarshal([]byte(optsJSON), &o); err != nil {
+		jsThrow(fmt.Sprintf("%s: invalid options: %v", fn, err))
+	}
+	return o
+}
+
+// evaluatorOptions maps the options onto evaluator options.
+func (o evalOptions) evaluatorOptions() []evaluator.EvalOption {
+	opts := []evaluator.EvalOption{gosonata.WithConcurrency(false)}
+	if o.TimeoutMs > 0 {
+		opts = append(opts, gosonata.WithTimeout(time.Duration(o.TimeoutMs*float64(time.Millisecond))))
+	}
+	if o.MaxDepth > 0 {
+		opts = append(opts, evaluator.WithMaxDepth(o.MaxDepth))
+	}
+	return opts
+}
 
+// parseData decodes the data argument of fn.
+func parseData(fn, dataJSON string) interface{} {
 	var data interface{}
 	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-		jsThrow(fmt.Sprintf("gosonata.eval: invalid data JSON: %v", err))
+		jsThrow(fmt.Sprintf("%s: invalid data JSON: %v", fn, err))
 	}
+	return data
+}
 
-	result, err := gosonata.EvalWithContext(context.Background(), query, data,
-		gosonata.WithConcurrency(false),
-	)
+// marshalResult encodes the result of fn.
+func marshalResult(fn string, result interface{}) string {
+	out, err := json.Marshal(result)
 	if err != nil {
-		jsThrow(fmt.Sprintf("gosonata.eval: %v", err))
+		jsThrow(fmt.Sprintf("%s: marshal result: %v", fn, err))
 	}
+	return string(out)
+}
 
-	out, err := json.Marshal(result)
+// jsEval implements gosonata.eval(query, dataJSON[, options]) → resultJSON.
+func jsEval(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		jsThrow("gosonata.eval requires 2 arguments: query (string) and data (JSON string)")
+	}
+	data := parseData("gosonata.eval", args[1].String())
+	opts := parseOptions("gosonata.eval", args, 2)
+
+	expr, err := gosonata.Compile(args[0].String())
 	if err != nil {
-		jsThrow(fmt.Sprintf("gosonata.eval: marshal result: %v", err))
+		jsThrow(fmt.Sprintf("gosonata.eval: %v", err))
 	}
-	return string(out)
+	ev := evaluator.New(opts.evaluatorOptions()...)
+	result, err := ev.EvalWithBindings(context.Background(), expr, data, opts.Bindings)
+	if err != nil {
+		jsThrow(fmt.Sprintf("gosonata.eval: %v", err))
+	}
+	return marshalResult("gosonata.eval", result)
 }
 
-// jsCompile implements gosonata.compile(query) → { eval(dataJSON) → resultJSON }.
+// jsCompile implements gosonata.compile(query) → { eval(dataJSON[, options]) → resultJSON }.
 func jsCompile(_ js.Value, args []js.Value) interface{} {
 	if len(args) < 1 {
 		jsThrow("gosonata.compile requires 1 argument: query (string)")
@@ -84,32 +168,44 @@ func jsCompile(_ js.Value, args []js.Value) interface{} {
 		jsThrow(fmt.Sprintf("gosonata.compile: %v", err))
 	}
 
+	// The evaluator without options is shared by the calls that pass none.
 	ev := evaluator.New(gosonata.WithConcurrency(false))
 
-	evalFn := js.FuncOf(func(_ js.Value, innerArgs []js.Value) interface{} {
+	evalFn, evalGo := throwing(func(_ js.Value, innerArgs []js.Value) interface{} {
 		if len(innerArgs) < 1 {
 			jsThrow("compiled.eval requires 1 argument: data (JSON string)")
 		}
-		var data interface{}
-		if e := json.Unmarshal([]byte(innerArgs[0].String()), &data); e != nil {
-			jsThrow(fmt.Sprintf("compiled.eval: invalid data JSON: %v", e))
+		data := parseData("compiled.eval", innerArgs[0].String())
+		opts := parseOptions("compiled.eval", innerArgs, 1)
+		callEv := ev
+		if opts.TimeoutMs > 0 || opts.MaxDepth > 0 {
+			callEv = evaluator.New(opts.evaluatorOptions()...)
 		}
-		r, e := ev.Eval(context.Background(), expr, data)
+		r, e := callEv.EvalWithBindings(context.Background(), expr, data, opts.Bindings)
 		if e != nil {
 			jsThrow(fmt.Sprintf("compiled.eval: %v", e))
 		}
-		out, _ := json.Marshal(r)
-		return string(out)
+		return marshalResult("compiled.eval", r)
+	})
+
+	// release frees the Go callbacks of the handle; eval throws afterwards.
+	var release js.Func
+	release = js.FuncOf(func(_ js.Value, _ []js.Value) interface{} {
+		evalGo.Release()
+		release.Release()
+		return nil
 	})
 
-	obj := js.ValueOf(map[string]interface{}{"eval": evalFn})
+	obj := js.ValueOf(map[string]interface{}{"eval": evalFn, "release": release})
 	return obj
 }
 
 func main() {
+	evalFn, _ := throwing(jsEval)
+	compileFn, _ := throwing(jsCompile)
 	api := map[string]interface{}{
-		"eval":    js.FuncOf(jsEval),
-		"compile": js.FuncOf(jsCompile),
+		"eval":    evalFn,
+		"compile": compileFn,
 		"version": js.FuncOf(func(_ js.Value, _ []js.Value) interface{} {
 			return gosonata.Version()
 		}),
@@ -5,9 +5,21 @@
 //
 // Protocol: single JSON object on stdin → single JSON object on stdout.
 //
-//	stdin:  { "query": "<jsonata>", "data": <any JSON value> }
+//	stdin:  { "query": "<jsonata>", "data": <any JSON value>,
+//	          "bindings": { "<name>": <any JSON value>, ... },
+//	          "options": { "maxDepth": <int>, "timeoutMs": <number> } }
 //	stdout: { "result": <any JSON value> }    on success
-//	        { "error":  "<message>"       }    on failure (exit code 1)
+//	        { "error": { "code": "<JSONata code>", "message": "<message>",
+//	                     "position": <offset in the query> } }
+//	                                          on failure (exit code 1)
+//
+// bindings and options are optional. bindings maps variable names (without
+// "$") to values, bound as with EvalWithBindings; maxDepth and timeoutMs set
+// the evaluator's WithMaxDepth and WithTimeout. The code and position of an
+// error are omitted when unknown (an invalid request, a timeout).
+//
+// With "compile": true the query is only compiled: the response is {} on
+// success, so a host can report syntax errors before it has data.
 //
 // Build:
 //
@@ -17,29 +29,73 @@
 //
 //	echo '{"query":"$.name","data":{"name":"Alice"}}' | wasmtime gosonata.wasm
 //
-// Usage from Python (wasmtime-py):
-//
-//	import wasmtime, json
-//	engine = wasmtime.Engine()
-//	...
+// Usage from Python: see the gosonata package in python/, which runs this
+// module with wasmtime-py.
 package main
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"os"
+	"time"
 
 	"github.com/sandrolain/gosonata"
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/types"
 )
 
 type request struct {
-	Query string      `json:"query"`
-	Data  interface{} `json:"data"`
+	Query    string                 `json:"query"`
+	Data     interface{}            `json:"data"`
+	Bindings map[string]interface{} `json:"bindings"`
+	Options  options                `json:"options"`
+	// Compile only compiles the query, without evaluating it.
+	Compile bool `json:"compile"`
+}
+
+// options are the evaluator options of a request, named as in the JS build.
+type options struct {
+	MaxDepth  int     `json:"maxDepth"`
+	TimeoutMs float64 `json:"timeoutMs"`
+}
+
+// evaluatorOptions maps the options onto evaluator options.
+func (o options) evaluatorOptions() []evaluator.EvalOption {
+	opts := []evaluator.EvalOption{gosonata.WithConcurrency(false)}
+	if o.TimeoutMs > 0 {
+		opts = append(opts, gosonata.WithTimeout(time.Duration(o.TimeoutMs*float64(time.Millisecond))))
+	}
+	if o.MaxDepth > 0 {
+		opts = append(opts, evaluator.WithMaxDepth(o.MaxDepth))
+	}
+	return opts
 }
 
 type response struct {
-	Result interface{} `json:"result,omitempty"`
-	Error  string      `json:"error,omitempty"`
+	Result interface{}    `json:"result,omitempty"`
+	Error  *responseError `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message"`
+	Position *int   `json:"position,omitempty"`
+}
+
+// newResponseError describes err, with the code and position of a JSONata
+// error.
+func newResponseError(err error) *responseError {
+	var jerr *types.Error
+	if !errors.As(err, &jerr) {
+		return &responseError{Message: err.Error()}
+	}
+	r := &responseError{Code: string(jerr.Code), Message: jerr.Message}
+	if jerr.Position >= 0 {
+		pos := jerr.Position
+		r.Position = &pos
+	}
+	return r
 }
 
 func writeResponse(r response, exitCode int) {
@@ -50,14 +106,21 @@ func writeResponse(r response, exitCode int) {
 func main() {
 	var req request
 	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
-		writeResponse(response{Error: "invalid request JSON: " + err.Error()}, 1)
+		writeResponse(response{Error: &responseError{Message: "invalid request JSON: " + err.Error()}}, 1)
+	}
+
+	expr, err := gosonata.Compile(req.Query)
+	if err != nil {
+		writeResponse(response{Error: newResponseError(err)}, 1)
+	}
+	if req.Compile {
+		writeResponse(response{}, 0)
 	}
 
-	result, err := gosonata.EvalWithContext(context.Background(), req.Query, req.Data,
-		gosonata.WithConcurrency(false),
-	)
+	ev := evaluator.New(req.Options.evaluatorOptions()...)
+	result, err := ev.EvalWithBindings(context.Background(), expr, req.Data, req.Bindings)
 	if err != nil {
-		writeResponse(response{Error: err.Error()}, 1)
+		writeResponse(response{Error: newResponseError(err)}, 1)
 	}
 
 	writeResponse(response{Result: result}, 0)
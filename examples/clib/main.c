@@ -0,0 +1,48 @@
+/*
+ * Evaluates a JSONata expression through libgosonata.
+ *
+ *   task clib:build
+ *   cc -o example examples/clib/main.c -Icmd/clib -Lcmd/clib -lgosonata
+ *   LD_LIBRARY_PATH=cmd/clib ./example
+ */
+#include <stdio.h>
+
+#include "gosonata.h"
+
+static int eval(gosonata_expr expr, const char *data) {
+    char *error = NULL;
+    char *result = gosonata_eval_json(expr, data, &error);
+    if (error != NULL) {
+        fprintf(stderr, "error: %s\n", error);
+        gosonata_free(error);
+        return 1;
+    }
+    printf("%s\n", result != NULL ? result : "(undefined)");
+    gosonata_free(result);
+    return 0;
+}
+
+int main(void) {
+    char *version = gosonata_version();
+    printf("gosonata %s (ABI %d)\n", version, gosonata_abi_version());
+    gosonata_free(version);
+
+    char *error = NULL;
+    gosonata_expr expr = gosonata_compile("items[price > $number(\"10\")].name", &error);
+    if (expr == 0) {
+        fprintf(stderr, "compile: %s\n", error);
+        gosonata_free(error);
+        return 1;
+    }
+
+    int rc = eval(expr, "{\"items\":[{\"name\":\"a\",\"price\":5},{\"name\":\"b\",\"price\":20}]}");
+    rc |= eval(expr, "{\"items\":[]}");
+    gosonata_release(expr);
+
+    /* Errors carry the JSONata error code. */
+    if (gosonata_compile("items[", &error) == 0) {
+        printf("compile error: %s\n", error);
+        gosonata_free(error);
+    }
+    return rc;
+}
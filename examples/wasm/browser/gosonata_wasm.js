@@ -4,9 +4,11 @@
  * Exposes window.GoSonataWasm.load(wasmPath) → Promise<GoSonataAPI>
  *
  * The returned object has:
- *   gs.version()               → string
- *   gs.eval(query, dataJSON)   → resultJSON   (throws on JSONata error)
- *   gs.compile(query)          → { eval(dataJSON) → resultJSON }
+ *   gs.version()                          → string
+ *   gs.eval(query, dataJSON[, options])   → resultJSON   (throws on JSONata error)
+ *   gs.compile(query)                     → { eval(dataJSON[, options]) → resultJSON }
+ *
+ * options is { bindings, timeoutMs, maxDepth }, all optional.
  *
  * Requires wasm_exec.js (from Go SDK) to be loaded first.
  */
@@ -46,7 +48,7 @@
     const gs = globalThis.gosonata;
     return {
       version: () => gs.version(),
-      eval: (query, dataJSON) => gs.eval(query, dataJSON),
+      eval: (query, dataJSON, options) => gs.eval(query, dataJSON, options),
       compile: (query) => gs.compile(query),
     };
   }
@@ -47,24 +47,25 @@ async function loadGoSonataWasm(wasmPath) {
   return {
     version: () => gs.version(),
     /**
-     * eval(query, data) — data can be a JS object or a JSON string.
+     * eval(query, data, options) — data can be a JS object or a JSON string;
+     * options is { bindings, timeoutMs, maxDepth }, all optional.
      * Returns the result as a parsed JS value.
      */
-    eval: (query, data) => {
+    eval: (query, data, options) => {
       const dataJSON = typeof data === "string" ? data : JSON.stringify(data);
-      return JSON.parse(gs.eval(query, dataJSON));
+      return JSON.parse(gs.eval(query, dataJSON, options));
     },
     /**
      * compile(query) — returns a compiled expression.
-     * compiled.eval(data) evaluates against the given data.
+     * compiled.eval(data, options) evaluates against the given data.
      */
     compile: (query) => {
       const compiled = gs.compile(query);
       return {
-        eval: (data) => {
+        eval: (data, options) => {
           const dataJSON =
             typeof data === "string" ? data : JSON.stringify(data);
-          return JSON.parse(compiled.eval(dataJSON));
+          return JSON.parse(compiled.eval(dataJSON, options));
         },
       };
     },
@@ -136,6 +137,20 @@ async function main() {
     );
   }
 
+  // Variables and limits
+  console.log("\n── Bindings and options ───────────────────────────────────");
+  const discounted = gs.compile("$.products[price > $min].{\"item\": name, \"price\": price * (1 - $discount)}");
+  const result = discounted.eval(catalog, {
+    bindings: { min: 100, discount: 0.1 },
+    timeoutMs: 500,
+  });
+  console.log(`  discounted → ${JSON.stringify(result)}`);
+  try {
+    gs.eval("($f := function($n) { $n = 0 ? 0 : 1 + $f($n - 1) }; $f(1000))", {}, { maxDepth: 100 });
+  } catch (err) {
+    console.log(`  maxDepth   → ${err.message}`);
+  }
+
   console.log("\nDone.");
 }
 
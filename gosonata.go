@@ -80,6 +80,13 @@ func Compile(query string, opts ...parser.CompileOption) (*types.Expression, err
 	return parser.Compile(query, opts...)
 }
 
+// CompileWithConstants compiles query with the variables named by the keys of
+// consts (without the $) bound to their values at compile time; see
+// parser.CompileWithConstants.
+func CompileWithConstants(query string, consts map[string]interface{}, opts ...parser.CompileOption) (*types.Expression, error) {
+	return parser.CompileWithConstants(query, consts, opts...)
+}
+
 // Eval is a convenience function that compiles and evaluates an expression
 // in a single call.
 //
@@ -151,12 +158,188 @@ func WithCacheSize(size int) EvalOption { return evaluator.WithCacheSize(size) }
 // WithConcurrency re-exports evaluator.WithConcurrency for convenience.
 func WithConcurrency(enabled bool) EvalOption { return evaluator.WithConcurrency(enabled) }
 
+// WithShardSize re-exports evaluator.WithShardSize for convenience.
+func WithShardSize(n int) EvalOption { return evaluator.WithShardSize(n) }
+
 // WithTimeout re-exports evaluator.WithTimeout for convenience.
 func WithTimeout(t time.Duration) EvalOption { return evaluator.WithTimeout(t) }
 
 // WithDebug re-exports evaluator.WithDebug for convenience.
 func WithDebug(enabled bool) EvalOption { return evaluator.WithDebug(enabled) }
 
+// WithAutoIndex re-exports evaluator.WithAutoIndex for convenience.
+func WithAutoIndex(enabled bool) EvalOption { return evaluator.WithAutoIndex(enabled) }
+
+// WithStringInterning re-exports evaluator.WithStringInterning for convenience.
+func WithStringInterning(enabled bool) EvalOption { return evaluator.WithStringInterning(enabled) }
+
+// WithObjectArena re-exports evaluator.WithObjectArena for convenience.
+func WithObjectArena(enabled bool) EvalOption { return evaluator.WithObjectArena(enabled) }
+
+// WithOrderedInput re-exports evaluator.WithOrderedInput for convenience.
+func WithOrderedInput(enabled bool) EvalOption { return evaluator.WithOrderedInput(enabled) }
+
+// UnmarshalOrdered re-exports evaluator.UnmarshalOrdered for convenience.
+func UnmarshalOrdered(data []byte) (interface{}, error) { return evaluator.UnmarshalOrdered(data) }
+
+// NullHandling re-exports evaluator.NullHandling for callers that only import gosonata.
+type NullHandling = evaluator.NullHandling
+
+// Null handling modes, re-exported from the evaluator package.
+const (
+	NullAsNil   = evaluator.NullAsNil
+	NullAsValue = evaluator.NullAsValue
+	NullDrop    = evaluator.NullDrop
+)
+
+// WithNullHandling re-exports evaluator.WithNullHandling for convenience.
+func WithNullHandling(mode NullHandling) EvalOption { return evaluator.WithNullHandling(mode) }
+
+// StringSemantics re-exports evaluator.StringSemantics for callers that only import gosonata.
+type StringSemantics = evaluator.StringSemantics
+
+// String semantics, re-exported from the evaluator package.
+const (
+	StringRunes = evaluator.StringRunes
+	StringUTF16 = evaluator.StringUTF16
+)
+
+// WithStringSemantics re-exports evaluator.WithStringSemantics for convenience.
+func WithStringSemantics(mode StringSemantics) EvalOption {
+	return evaluator.WithStringSemantics(mode)
+}
+
+// WithJoinCoercion re-exports evaluator.WithJoinCoercion for convenience.
+func WithJoinCoercion(enabled bool) EvalOption { return evaluator.WithJoinCoercion(enabled) }
+
+// WithIntegerResults re-exports evaluator.WithIntegerResults for convenience.
+func WithIntegerResults(enabled bool) EvalOption { return evaluator.WithIntegerResults(enabled) }
+
+// ResultTransformer re-exports evaluator.ResultTransformer for callers that only import gosonata.
+type ResultTransformer = evaluator.ResultTransformer
+
+// WithResultTransformer re-exports evaluator.WithResultTransformer for convenience.
+func WithResultTransformer(fn ResultTransformer) EvalOption {
+	return evaluator.WithResultTransformer(fn)
+}
+
+// WithAllowInPlace re-exports evaluator.WithAllowInPlace for convenience.
+func WithAllowInPlace(enabled bool) EvalOption { return evaluator.WithAllowInPlace(enabled) }
+
+// DocumentLoader re-exports evaluator.DocumentLoader for convenience.
+type DocumentLoader = evaluator.DocumentLoader
+
+// WithDocumentLoader re-exports evaluator.WithDocumentLoader for convenience.
+func WithDocumentLoader(loader DocumentLoader) EvalOption {
+	return evaluator.WithDocumentLoader(loader)
+}
+
+// WithDocumentCache re-exports evaluator.WithDocumentCache for convenience.
+func WithDocumentCache(ttl time.Duration, maxEntries int) EvalOption {
+	return evaluator.WithDocumentCache(ttl, maxEntries)
+}
+
+// WithTimezone re-exports evaluator.WithTimezone for convenience.
+func WithTimezone(name string) EvalOption { return evaluator.WithTimezone(name) }
+
+// BindingError re-exports evaluator.BindingError, the error of a binding
+// rejected by EvalWithBindings.
+type BindingError = evaluator.BindingError
+
+// WithFunctionShadowing re-exports evaluator.WithFunctionShadowing for convenience.
+func WithFunctionShadowing(allow bool) EvalOption { return evaluator.WithFunctionShadowing(allow) }
+
+// Clock re-exports evaluator.Clock for callers that only import gosonata.
+type Clock = evaluator.Clock
+
+// WithClock re-exports evaluator.WithClock for convenience.
+func WithClock(clock Clock) EvalOption { return evaluator.WithClock(clock) }
+
+// DebugHook, DebugFrame and DebugAction re-export the debugger types of
+// evaluator.WithDebugger.
+type (
+	DebugHook   = evaluator.DebugHook
+	DebugFrame  = evaluator.DebugFrame
+	DebugAction = evaluator.DebugAction
+)
+
+// Debugger actions, re-exported from evaluator.
+const (
+	DebugStep     = evaluator.DebugStep
+	DebugStepOver = evaluator.DebugStepOver
+	DebugStepOut  = evaluator.DebugStepOut
+	DebugContinue = evaluator.DebugContinue
+	DebugAbort    = evaluator.DebugAbort
+)
+
+// ErrDebugAborted re-exports evaluator.ErrDebugAborted.
+var ErrDebugAborted = evaluator.ErrDebugAborted
+
+// WithDebugger re-exports evaluator.WithDebugger for convenience.
+func WithDebugger(hook DebugHook, breakpoints ...int) EvalOption {
+	return evaluator.WithDebugger(hook, breakpoints...)
+}
+
+// WithRandomSeed re-exports evaluator.WithRandomSeed for convenience.
+func WithRandomSeed(seed uint64) EvalOption { return evaluator.WithRandomSeed(seed) }
+
+// WithPrelude re-exports evaluator.WithPrelude for convenience.
+func WithPrelude(prelude *types.Expression) EvalOption { return evaluator.WithPrelude(prelude) }
+
+// Stats re-exports evaluator.Stats for convenience.
+type Stats = evaluator.Stats
+
+// StatsHook re-exports evaluator.StatsHook for convenience.
+type StatsHook = evaluator.StatsHook
+
+// WithStats re-exports evaluator.WithStats for convenience.
+func WithStats(enabled bool) EvalOption { return evaluator.WithStats(enabled) }
+
+// WithStatsHook re-exports evaluator.WithStatsHook for convenience.
+func WithStatsHook(hook StatsHook) EvalOption { return evaluator.WithStatsHook(hook) }
+
+// WithStrictNavigation re-exports evaluator.WithStrictNavigation for convenience.
+func WithStrictNavigation(enabled bool) EvalOption { return evaluator.WithStrictNavigation(enabled) }
+
+// WithStrictVariables re-exports evaluator.WithStrictVariables for convenience.
+func WithStrictVariables(enabled bool) EvalOption { return evaluator.WithStrictVariables(enabled) }
+
+// AssertMode re-exports evaluator.AssertMode for callers that only import gosonata.
+type AssertMode = evaluator.AssertMode
+
+// Assert modes, re-exported from the evaluator package.
+const (
+	AssertEnforce = evaluator.AssertEnforce
+	AssertWarn    = evaluator.AssertWarn
+	AssertIgnore  = evaluator.AssertIgnore
+)
+
+// WithAssertMode re-exports evaluator.WithAssertMode for convenience.
+func WithAssertMode(mode AssertMode) EvalOption { return evaluator.WithAssertMode(mode) }
+
+// WithMaxResultItems re-exports evaluator.WithMaxResultItems for convenience.
+func WithMaxResultItems(n int) EvalOption { return evaluator.WithMaxResultItems(n) }
+
+// WithPartialResults re-exports evaluator.WithPartialResults for convenience.
+func WithPartialResults(enabled bool) EvalOption { return evaluator.WithPartialResults(enabled) }
+
+// WithMaxStringLength re-exports evaluator.WithMaxStringLength for convenience.
+func WithMaxStringLength(n int) EvalOption { return evaluator.WithMaxStringLength(n) }
+
+// ResultInfo re-exports evaluator.ResultInfo for callers that only import gosonata.
+type ResultInfo = evaluator.ResultInfo
+
+// PanicError re-exports evaluator.PanicError, the error returned for a panic
+// recovered during an evaluation.
+type PanicError = evaluator.PanicError
+
+// Frozen re-exports evaluator.Frozen for callers that only import gosonata.
+type Frozen = evaluator.Frozen
+
+// Freeze re-exports evaluator.Freeze: it returns an immutable copy of v to
+// share across evaluations, e.g. a lookup table bound to each of them.
+func Freeze(v interface{}) (*Frozen, error) { return evaluator.Freeze(v) }
+
 // WithCustomFunction registers a user-defined function with name (without "$") and
 // an optional JSONata type-signature string.
 //
@@ -195,6 +378,100 @@ func WithFunctions(defs ...functions.FunctionEntry) EvalOption {
 	return evaluator.WithFunctions(defs...)
 }
 
+// WithoutFunctions re-exports evaluator.WithoutFunctions for convenience.
+func WithoutFunctions(names ...string) EvalOption { return evaluator.WithoutFunctions(names...) }
+
+// Iterator re-exports evaluator.Iterator for callers that only import gosonata.
+type Iterator = evaluator.Iterator
+
+// EvalIter compiles query and returns the items of its result against data one
+// at a time.
+//
+// It is a convenience wrapper around Compile + Evaluator.EvalIter.
+// See evaluator.EvalIter for full documentation.
+func EvalIter(ctx context.Context, query string, data interface{}, opts ...EvalOption) (Iterator, error) {
+	expr, err := Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	eval := evaluator.New(opts...)
+	return eval.EvalIter(ctx, expr, data)
+}
+
+// Page re-exports evaluator.Page for callers that only import gosonata.
+type Page = evaluator.Page
+
+// EvalPage compiles query and returns the items of its result against data from
+// offset to offset+limit.
+//
+// It is a convenience wrapper around Compile + Evaluator.EvalPage.
+// See evaluator.EvalPage for full documentation.
+func EvalPage(ctx context.Context, query string, data interface{}, offset, limit int, opts ...EvalOption) (*Page, error) {
+	expr, err := Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	eval := evaluator.New(opts...)
+	return eval.EvalPage(ctx, expr, data, offset, limit)
+}
+
+// Value re-exports evaluator.Value for callers that only import gosonata.
+type Value = evaluator.Value
+
+// QueryResult re-exports evaluator.QueryResult for callers that only import gosonata.
+type QueryResult = evaluator.QueryResult
+
+// EvalMany compiles queries together and evaluates them against data, sharing
+// the sub-expressions common to several of them. Each query has its own result
+// or error.
+//
+// It is a convenience wrapper around parser.CompileMany + Evaluator.EvalMany;
+// callers evaluating the same queries repeatedly should compile them once.
+func EvalMany(ctx context.Context, queries []string, data interface{}, opts ...EvalOption) ([]QueryResult, error) {
+	eval := evaluator.New(opts...)
+	return eval.EvalMany(ctx, parser.CompileMany(queries, parser.WithBuiltinFunctions(eval.BuiltinFunction)), data)
+}
+
+// Profile re-exports evaluator.Profile for callers that only import gosonata.
+type Profile = evaluator.Profile
+
+// NodeProfile re-exports evaluator.NodeProfile for callers that only import gosonata.
+type NodeProfile = evaluator.NodeProfile
+
+// EvalProfile compiles query, evaluates it against data and reports the time and
+// allocations spent in each node of the expression.
+//
+// It is a convenience wrapper around Compile + Evaluator.Profile.
+// See evaluator.Profile for full documentation.
+func EvalProfile(ctx context.Context, query string, data interface{}, opts ...EvalOption) (*Profile, error) {
+	expr, err := Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	eval := evaluator.New(opts...)
+	return eval.Profile(ctx, expr, data)
+}
+
+// Comparison re-exports evaluator.Comparison for callers that only import gosonata.
+type Comparison = evaluator.Comparison
+
+// Difference re-exports evaluator.Difference for callers that only import gosonata.
+type Difference = evaluator.Difference
+
+// EvalCompare compiles query, evaluates it against dataA and dataB and reports
+// the structural differences between the two results.
+//
+// It is a convenience wrapper around Compile + Evaluator.EvalCompare.
+// See evaluator.EvalCompare for full documentation.
+func EvalCompare(ctx context.Context, query string, dataA, dataB interface{}, opts ...EvalOption) (*Comparison, error) {
+	expr, err := Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	eval := evaluator.New(opts...)
+	return eval.EvalCompare(ctx, expr, dataA, dataB)
+}
+
 // StreamResult re-exports evaluator.StreamResult for callers that only import gosonata.
 type StreamResult = evaluator.StreamResult
 
@@ -0,0 +1,44 @@
+/** Options of an evaluation. */
+export interface EvalOptions {
+  /** Variables bound for the evaluation, by name without "$". Values must be JSON-serialisable. */
+  bindings?: Record<string, unknown>;
+  /** Evaluation timeout in milliseconds. */
+  timeoutMs?: number;
+  /** Maximum evaluation depth. */
+  maxDepth?: number;
+}
+
+/** Error thrown by GoSonata. */
+export class GoSonataError extends Error {
+  /** JSONata error code (e.g. "T2001", "U1001") when known. */
+  readonly code?: string;
+}
+
+/** A compiled expression, reusable across calls until released. */
+export class Expression {
+  /** The source of the expression. */
+  readonly query: string;
+  /** Evaluates the expression against data (any JSON-serialisable value). */
+  evaluate(data?: unknown, options?: EvalOptions): any;
+  /** Frees the WebAssembly resources of the expression; evaluate throws afterwards. */
+  release(): void;
+}
+
+/** The loaded GoSonata runtime. */
+export class GoSonata {
+  /** GoSonata version, e.g. "v0.1.0-dev". */
+  readonly version: string;
+  /** Evaluates query against data. */
+  evaluate(query: string, data?: unknown, options?: EvalOptions): any;
+  /** Compiles query into an Expression. */
+  compile(query: string): Expression;
+}
+
+/** Loads the WebAssembly runtime once per process. */
+export function load(): Promise<GoSonata>;
+
+/** Loads the runtime if needed and evaluates query against data. */
+export function evaluate(query: string, data?: unknown, options?: EvalOptions): Promise<any>;
+
+/** Loads the runtime if needed and compiles query. */
+export function compile(query: string): Promise<Expression>;
@@ -0,0 +1,149 @@
+"use strict";
+
+/**
+ * GoSonata for Node.js.
+ *
+ * Loads the GoSonata WebAssembly build once per process and exposes it with a
+ * Promise-based API:
+ *
+ *   const gosonata = require("gosonata");
+ *
+ *   await gosonata.evaluate("$sum(items.price)", data);
+ *
+ *   const expr = await gosonata.compile("items[price > $min].name");
+ *   expr.evaluate(data, { bindings: { min: 10 } }); // compiled once, reused
+ *   expr.release();                                 // when no longer needed
+ */
+
+const fs = require("fs");
+const path = require("path");
+
+const WASM_PATH = path.join(__dirname, "gosonata.wasm");
+
+let loading = null;
+
+/** Error thrown by GoSonata; code is the JSONata error code when known. */
+class GoSonataError extends Error {
+  constructor(message, code) {
+    super(message);
+    this.name = "GoSonataError";
+    this.code = code;
+  }
+}
+
+// rethrow turns an Error thrown by the Go side ("fn: CODE ...") into a
+// GoSonataError.
+function rethrow(err) {
+  const message = String(err && err.message !== undefined ? err.message : err);
+  const m = /(?:^|: )([A-Z]\d{4})\b/.exec(message);
+  throw new GoSonataError(message, m ? m[1] : undefined);
+}
+
+function toJSON(data) {
+  return data === undefined ? "null" : JSON.stringify(data);
+}
+
+function fromJSON(result) {
+  return JSON.parse(result);
+}
+
+/** A compiled expression, reusable across calls until released. */
+class Expression {
+  constructor(query, handle) {
+    this.query = query;
+    this._handle = handle;
+  }
+
+  /** Evaluates the expression against data (any JSON-serialisable value). */
+  evaluate(data, options) {
+    if (this._handle === null) {
+      throw new GoSonataError("expression has been released");
+    }
+    let result;
+    try {
+      result = this._handle.eval(toJSON(data), options);
+    } catch (err) {
+      rethrow(err);
+    }
+    return fromJSON(result);
+  }
+
+  /** Frees the WebAssembly resources of the expression. */
+  release() {
+    if (this._handle !== null) {
+      this._handle.release();
+      this._handle = null;
+    }
+  }
+}
+
+/** The loaded GoSonata runtime. */
+class GoSonata {
+  constructor(api) {
+    this._api = api;
+    this.version = api.version();
+  }
+
+  /** Evaluates query against data. */
+  evaluate(query, data, options) {
+    let result;
+    try {
+      result = this._api.eval(query, toJSON(data), options);
+    } catch (err) {
+      rethrow(err);
+    }
+    return fromJSON(result);
+  }
+
+  /** Compiles query into an Expression. */
+  compile(query) {
+    let handle;
+    try {
+      handle = this._api.compile(query);
+    } catch (err) {
+      rethrow(err);
+    }
+    return new Expression(query, handle);
+  }
+}
+
+/**
+ * Loads the WebAssembly runtime. The runtime is loaded once: later calls
+ * return the same instance.
+ */
+function load() {
+  if (loading === null) {
+    loading = instantiate().catch((err) => {
+      loading = null;
+      throw err;
+    });
+  }
+  return loading;
+}
+
+async function instantiate() {
+  if (typeof globalThis.Go !== "function") {
+    require("./wasm_exec.js");
+  }
+  const go = new globalThis.Go();
+  const bytes = await fs.promises.readFile(WASM_PATH);
+  const { instance } = await WebAssembly.instantiate(bytes, go.importObject);
+  go.run(instance); // main() registers globalThis.gosonata, then blocks
+
+  while (!globalThis.gosonata) {
+    await new Promise((resolve) => setImmediate(resolve));
+  }
+  return new GoSonata(globalThis.gosonata);
+}
+
+/** Loads the runtime if needed and evaluates query against data. */
+async function evaluate(query, data, options) {
+  return (await load()).evaluate(query, data, options);
+}
+
+/** Loads the runtime if needed and compiles query. */
+async function compile(query) {
+  return (await load()).compile(query);
+}
+
+module.exports = { load, evaluate, compile, GoSonata, Expression, GoSonataError };
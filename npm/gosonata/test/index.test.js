@@ -0,0 +1,49 @@
+"use strict";
+
+const test = require("node:test");
+const assert = require("node:assert");
+const gosonata = require("..");
+
+const data = {
+  items: [
+    { name: "Widget", price: 49.99 },
+    { name: "Gadget", price: 149.99 },
+  ],
+};
+
+test("load returns a single runtime", async () => {
+  const a = await gosonata.load();
+  const b = await gosonata.load();
+  assert.strictEqual(a, b);
+  assert.match(a.version, /^v\d/);
+});
+
+test("evaluate", async () => {
+  assert.strictEqual(await gosonata.evaluate("$count(items)", data), 2);
+  assert.deepStrictEqual(await gosonata.evaluate("items[price > 100].name", data), "Gadget");
+});
+
+test("bindings and options", async () => {
+  const result = await gosonata.evaluate("items[price > $min].name", data, { bindings: { min: 10 } });
+  assert.deepStrictEqual(result, ["Widget", "Gadget"]);
+  await assert.rejects(
+    gosonata.evaluate("($f := function($n) { $n = 0 ? 0 : 1 + $f($n - 1) }; $f(1000))", null, { maxDepth: 50 }),
+    (err) => err instanceof gosonata.GoSonataError && err.code === "U1001",
+  );
+});
+
+test("compiled expressions survive across calls", async () => {
+  const expr = await gosonata.compile("$sum(items.price) * $rate");
+  for (const rate of [1, 2, 3]) {
+    const got = expr.evaluate(data, { bindings: { rate } });
+    assert.ok(Math.abs(got - 199.98 * rate) < 1e-9);
+  }
+  expr.release();
+  assert.throws(() => expr.evaluate(data), gosonata.GoSonataError);
+});
+
+test("errors carry the JSONata code", async () => {
+  await assert.rejects(gosonata.compile("items["), (err) => err instanceof gosonata.GoSonataError && err.code === "S0201");
+  const gs = await gosonata.load();
+  assert.throws(() => gs.evaluate('1 + "a"', null), (err) => err.code === "T2001");
+});
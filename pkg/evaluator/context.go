@@ -2,7 +2,11 @@ package evaluator
 
 import (
 	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
 	"time"
+
+	"github.com/sandrolain/gosonata/pkg/types"
 )
 
 // EvalContext maintains evaluation state including variable bindings and current data.
@@ -19,9 +23,18 @@ type EvalContext struct {
 	// bindings stores variable assignments
 	bindings map[string]interface{}
 
+	// sharedBindings is set when bindings is the binding set of a
+	// contextBoundValue, borrowed instead of copied (see applyBindingsToCtx).
+	// Such maps are immutable: the first write copies it.
+	sharedBindings bool
+
 	// depth tracks recursion depth to prevent stack overflow
 	depth int
 
+	// position is 1 + the index of the item a filter predicate is evaluated
+	// for, as returned by $index(); 0 outside filter predicates.
+	position int
+
 	// isArrayItem marks this context as created during array iteration in a path.
 	// Only contexts created this way are valid targets for the % (parent) operator.
 	isArrayItem bool
@@ -43,6 +56,66 @@ type EvalContext struct {
 	// whether the evaluator runs as a short-lived process, a long-running
 	// server, or a reused WASM module instance.
 	nowTime *time.Time
+
+	// filterIndexes holds the WithAutoIndex hash indexes built during a single
+	// evaluation, keyed by (array identity, field). Like nowTime it lives only
+	// on the root context and is allocated lazily.
+	filterIndexes map[filterIndexKey]*filterIndex
+
+	// rng is the seeded random source of WithRandomSeed for one evaluation.
+	// Root context only, allocated lazily.
+	rng *rand.Rand
+
+	// objectKeys holds the shared Keys slices of each object constructor when
+	// string interning is enabled (see shareObjectKeys). Root context only.
+	objectKeys map[*types.ASTNode][][]string
+
+	// objects is the arena of the objects constructed during one evaluation
+	// (WithObjectArena). Root context only; nil otherwise.
+	objects *objectArena
+
+	// resultItems is the length of an array result before WithMaxResultItems
+	// truncation, 0 for other results (see ResultInfo). Root context only.
+	resultItems int
+
+	// frozen lists the Frozen values the evaluation reads: transforms then
+	// never update data in place, and filters over their arrays use their
+	// indexes (see frozenFilter). Root context only.
+	frozen []*Frozen
+
+	// partial collects the items of the top-level array computed before a
+	// deadline (see WithPartialResults). Root context only; nil otherwise.
+	partial *partialResult
+
+	// documents caches the documents loaded by $doc during one evaluation,
+	// by name. Root context only, allocated lazily.
+	documents map[string]interface{}
+
+	// modules caches the value of each module imported with $import during
+	// one evaluation, by module AST. Root context only, allocated lazily.
+	modules map[*types.ASTNode]interface{}
+
+	// profile collects per-node statistics during Evaluator.Profile. Root
+	// context only; nil for every other evaluation.
+	profile *profiler
+
+	// debug is the state of the debugger (see WithDebugger). Root context
+	// only, allocated lazily; nil without a debugger.
+	debug *debugSession
+
+	// nodes counts the nodes evaluated when the evaluator keeps Stats. Root
+	// context only; nil otherwise. Atomic: concurrent branches share it.
+	nodes *atomic.Uint64
+
+	// lambdas memoizes the function values created in this context by lambda
+	// literals passed as arguments (see evalLambdaArgument).
+	// Allocated lazily.
+	lambdas map[*types.ASTNode]*Lambda
+
+	// closures is set on the root context when the evaluation creates a
+	// function value, so that only such results are scanned for lambdas to
+	// detach (see detachClosures).
+	closures bool
 }
 
 // NewContext creates a new evaluation context.
@@ -54,6 +127,7 @@ func NewContext(data interface{}) *EvalContext {
 	}
 	// Root context points to itself
 	ctx.root = ctx
+	ctx.data = ctx.thaw(data)
 	return ctx
 }
 
@@ -110,10 +184,23 @@ func (c *EvalContext) Depth() int {
 func (c *EvalContext) SetBinding(name string, value interface{}) {
 	if c.bindings == nil {
 		c.bindings = make(map[string]interface{})
+	} else if c.sharedBindings {
+		c.ownBindings(1)
 	}
 	c.bindings[name] = value
 }
 
+// ownBindings replaces borrowed bindings with a private copy, with room for
+// extra more entries.
+func (c *EvalContext) ownBindings(extra int) {
+	owned := make(map[string]interface{}, len(c.bindings)+extra)
+	for name, value := range c.bindings {
+		owned[name] = value
+	}
+	c.bindings = owned
+	c.sharedBindings = false
+}
+
 // GetBinding retrieves a variable binding.
 // OPT-13: iterative walk up the parent chain instead of recursive calls.
 // This eliminates one Go stack frame per context level (common in deep paths).
@@ -135,9 +222,29 @@ func (c *EvalContext) SetBindings(bindings map[string]interface{}) {
 	}
 	if c.bindings == nil {
 		c.bindings = make(map[string]interface{}, len(bindings))
+	} else if c.sharedBindings {
+		c.ownBindings(len(bindings))
 	}
 	for name, value := range bindings {
-		c.bindings[name] = value
+		c.bindings[name] = c.thaw(value)
+	}
+}
+
+// newFrame creates the binding frame of a block or a function call.
+//
+// Scoping is lexical and frame-based: every block and every call gets its own
+// frame whose parent is the frame the code was written in (for a call, the
+// lambda's closure). `$x := v` always writes into the innermost frame, so it
+// shadows an outer $x without modifying it, and lookups walk outwards. Frames are
+// linked, not copied: a binding added to an enclosing frame after a lambda was
+// created (e.g. the lambda's own name, for recursion) is visible to it, and to
+// every lambda created while it runs.
+func (c *EvalContext) newFrame() *EvalContext {
+	return &EvalContext{
+		data:   c.data,
+		parent: c,
+		root:   c.root,
+		depth:  c.depth + 1,
 	}
 }
 
@@ -171,16 +278,36 @@ func (c *EvalContext) CloneDeeper() *EvalContext {
 // NowTime returns the wall-clock time for the current evaluation.
 // The value is captured once on first call and reused for all subsequent calls
 // within the same evaluation tree, ensuring that $now() and $millis() return
-// a consistent timestamp throughout a single expression evaluation.
+// a consistent timestamp throughout a single expression evaluation. The
+// evaluator captures it from its Clock (see WithClock) when $now or $millis
+// is called first.
 func (c *EvalContext) NowTime() time.Time {
+	return c.nowFrom(SystemClock)
+}
+
+// nowFrom returns the time of the evaluation, reading it from clock on first
+// use. It is truncated to the millisecond, so that $toMillis($now()) equals
+// $millis().
+func (c *EvalContext) nowFrom(clock Clock) time.Time {
 	root := c.root
 	if root.nowTime == nil {
-		t := time.Now()
+		t := clock.Now().Truncate(time.Millisecond)
 		root.nowTime = &t
 	}
 	return *root.nowTime
 }
 
+// filterPosition returns the index of the item the innermost enclosing filter
+// predicate is evaluated for.
+func (c *EvalContext) filterPosition() (int, bool) {
+	for ; c != nil; c = c.parent {
+		if c.position > 0 {
+			return c.position - 1, true
+		}
+	}
+	return 0, false
+}
+
 // markEscaped marks this context and every ancestor as escaped from the pool.
 // Call before storing an *EvalContext in a long-lived structure (e.g. a Lambda
 // closure). Walking up the parent chain ensures that ancestors reachable via
@@ -0,0 +1,120 @@
+package evaluator
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// documentCache keeps the documents returned by the DocumentLoader across the
+// evaluations of one Evaluator (WithDocumentCache).
+//
+// THREAD-SAFETY AUDIT: safe.
+//   - All fields are guarded by mu; the loader itself runs without the lock.
+//   - Concurrent loads of the same name are deduplicated (single flight): the
+//     first caller runs the loader, later ones wait for its result. Failed
+//     loads are shared with the waiters but never cached.
+//   - Cached documents are shared between evaluations and must be treated as
+//     read-only, like any other input.
+type documentCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration // 0: entries never expire
+	capacity int
+	ll       *list.List // LRU order, most recent first
+	items    map[string]*list.Element
+	inflight map[string]*documentLoad
+}
+
+// documentEntry is a cached document.
+type documentEntry struct {
+	name    string
+	doc     interface{}
+	expires time.Time // zero when the cache has no TTL
+}
+
+// documentLoad is a load in progress; done is closed once doc and err are set.
+type documentLoad struct {
+	done chan struct{}
+	doc  interface{}
+	err  error
+}
+
+// defaultDocumentCacheSize is the capacity used when WithDocumentCache is given
+// a non-positive size.
+const defaultDocumentCacheSize = 256
+
+func newDocumentCache(ttl time.Duration, capacity int) *documentCache {
+	if capacity <= 0 {
+		capacity = defaultDocumentCacheSize
+	}
+	return &documentCache{
+		ttl:      ttl,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		inflight: make(map[string]*documentLoad),
+	}
+}
+
+// load returns the cached document for name, or loads it with loader.
+func (c *documentCache) load(ctx context.Context, name string, loader DocumentLoader) (interface{}, error) {
+	c.mu.Lock()
+	if el, ok := c.items[name]; ok {
+		entry := el.Value.(*documentEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			c.ll.MoveToFront(el)
+			c.mu.Unlock()
+			return entry.doc, nil
+		}
+		c.ll.Remove(el)
+		delete(c.items, name)
+	}
+	if call, ok := c.inflight[name]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.doc, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &documentLoad{done: make(chan struct{})}
+	c.inflight[name] = call
+	c.mu.Unlock()
+
+	func() {
+		// A panicking loader must not leave the waiters blocked.
+		defer func() {
+			if r := recover(); r != nil {
+				call.err = fmt.Errorf("document loader panicked: %v", r)
+			}
+		}()
+		call.doc, call.err = loader(name)
+	}()
+
+	c.mu.Lock()
+	delete(c.inflight, name)
+	if call.err == nil {
+		c.add(name, call.doc)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.doc, call.err
+}
+
+// add stores doc, evicting the least recently used entry when full.
+// c.mu must be held.
+func (c *documentCache) add(name string, doc interface{}) {
+	entry := &documentEntry{name: name, doc: doc}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	c.items[name] = c.ll.PushFront(entry)
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*documentEntry).name)
+	}
+}
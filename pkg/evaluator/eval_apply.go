@@ -69,34 +69,14 @@ func (e *Evaluator) evalApply(ctx context.Context, node *types.ASTNode, evalCtx
 			if err != nil {
 				return nil, err
 			}
-			args := make([]interface{}, 0, len(innerFnNode.Arguments)+1)
-			args = append(args, data)
-			for _, argNode := range innerFnNode.Arguments {
-				arg, err := e.evalNode(ctx, argNode, evalCtx)
-				if err != nil {
-					return nil, err
-				}
-				args = append(args, arg)
-			}
-			switch fn := callableValue.(type) {
-			case *Lambda:
-				innerResult, err = e.callLambda(ctx, fn, args)
-			case *FunctionDef:
-				if len(args) < fn.MinArgs {
-					return nil, types.NewError(types.ErrArgumentCountMismatch,
-						fmt.Sprintf("function requires at least %d arguments, got %d", fn.MinArgs, len(args)), -1)
-				}
-				innerResult, err = fn.Impl(ctx, e, evalCtx, args)
-			default:
-				return nil, fmt.Errorf("expected lambda or function, got %T", callableValue)
-			}
+			innerResult, err = e.callPiped(ctx, evalCtx, callableValue, data, innerFnNode.Arguments)
 			if err != nil {
 				return nil, err
 			}
 		} else if innerFnNode.StrValue != "" {
 			// Named function call
 			funcName := innerFnNode.StrValue
-			fnDef, ok := GetFunction(funcName)
+			fnDef, ok := e.LookupFunction(funcName)
 			if !ok {
 				return nil, fmt.Errorf("unknown function: %s", funcName)
 			}
@@ -174,7 +154,7 @@ func (e *Evaluator) evalApply(ctx context.Context, node *types.ASTNode, evalCtx
 		// If it's a built-in function call (Value contains name)
 		if fnNode.StrValue != "" {
 			funcName := fnNode.StrValue
-			fnDef, ok := GetFunction(funcName)
+			fnDef, ok := e.LookupFunction(funcName)
 			if !ok {
 				return nil, fmt.Errorf("unknown function: %s", funcName)
 			}
@@ -212,27 +192,7 @@ func (e *Evaluator) evalApply(ctx context.Context, node *types.ASTNode, evalCtx
 				return nil, err
 			}
 
-			// Evaluate arguments
-			args := make([]interface{}, 0, len(fnNode.Arguments)+1)
-			args = append(args, data) // Prepend piped data
-
-			for _, argNode := range fnNode.Arguments {
-				arg, err := e.evalNode(ctx, argNode, evalCtx)
-				if err != nil {
-					return nil, err
-				}
-				args = append(args, arg)
-			}
-
-			// Call based on type
-			switch fn := callableValue.(type) {
-			case *Lambda:
-				return e.callLambda(ctx, fn, args)
-			case *FunctionDef:
-				return fn.Impl(ctx, e, evalCtx, args)
-			default:
-				return nil, fmt.Errorf("expected lambda or function, got %T", callableValue)
-			}
+			return e.callPiped(ctx, evalCtx, callableValue, data, fnNode.Arguments)
 		}
 	}
 
@@ -265,6 +225,51 @@ func (e *Evaluator) evalApply(ctx context.Context, node *types.ASTNode, evalCtx
 	return nil, types.NewError(types.ErrInvokeNonFunction, "right side of ~> must be a function", -1)
 }
 
+// callPiped invokes a callable on the RHS of `~>` with the piped data bound as the
+// first argument, followed by the explicit call arguments (`data ~> $fn(a, b)` is
+// `$fn(data, a, b)`). Lambdas are checked against their signature, so optional
+// parameters stay unbound rather than being rejected by a raw parameter count;
+// built-ins are checked against MinArgs/MaxArgs.
+
+func (e *Evaluator) callPiped(ctx context.Context, evalCtx *EvalContext, callable interface{}, data interface{}, argNodes []*types.ASTNode) (interface{}, error) {
+	var err error
+	fnDef, isDef := callable.(*FunctionDef)
+	if !isDef || !fnDef.boundArgs {
+		if data, err = unwrapCVsDeep(data); err != nil {
+			return nil, err
+		}
+	}
+	args := make([]interface{}, 0, len(argNodes)+1)
+	args = append(args, data) // Prepend piped data
+	for _, argNode := range argNodes {
+		arg, err := e.evalNode(ctx, argNode, evalCtx)
+		if err != nil {
+			return nil, err
+		}
+		if arg, err = unwrapCVsDeep(arg); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	switch fn := callable.(type) {
+	case *Lambda:
+		return e.callLambda(ctx, fn, args)
+	case *FunctionDef:
+		if len(args) < fn.MinArgs {
+			return nil, types.NewError(types.ErrArgumentCountMismatch,
+				fmt.Sprintf("function requires at least %d arguments, got %d", fn.MinArgs, len(args)), -1)
+		}
+		if fn.MaxArgs != -1 && len(args) > fn.MaxArgs {
+			return nil, types.NewError(types.ErrArgumentCountMismatch,
+				fmt.Sprintf("function accepts at most %d arguments, got %d", fn.MaxArgs, len(args)), -1)
+		}
+		return fn.Impl(ctx, e, evalCtx, args)
+	default:
+		return nil, fmt.Errorf("expected lambda or function, got %T", callable)
+	}
+}
+
 // createComposition creates a composed function from two functions.
 // composition(f, g) returns λx.g(f(x))
 
@@ -297,7 +302,8 @@ func (e *Evaluator) createComposition(leftFn, rightFn interface{}, evalCtx *Eval
 	bodyNode.Arguments = []*types.ASTNode{leftCallNode}
 
 	// Create context with both functions bound
-	composedCtx := evalCtx.Clone()
+	composedCtx := evalCtx.newFrame()
+	evalCtx.root.closures = true
 	composedCtx.SetBinding("leftFn", leftFn)
 	composedCtx.SetBinding("rightFn", rightFn)
 
@@ -0,0 +1,125 @@
+package evaluator
+
+import "sync"
+
+// Object arena (WithObjectArena).
+//
+// Each object constructor allocates an OrderedObject, its Keys slice and its
+// Values map. For expressions constructing millions of small objects, most of
+// them intermediate, that is most of the garbage an evaluation leaves behind.
+// With the arena, the objects of an evaluation are carved from chunks owned by
+// the evaluation, and the chunks and maps are cleared and pooled when it ends,
+// to be reused by the next one instead of being collected.
+//
+// Nothing built from the arena may outlive the evaluation. The result is
+// copied out of it by outputValue, which copies every container of the
+// result; an evaluation returning a function, whose closure may reach arena
+// objects, abandons its arena to the garbage collector instead of releasing
+// it. Transforms updating the input in place (WithAllowInPlace) could store
+// arena objects into it, so the arena is not used with them.
+
+const (
+	// arenaObjectChunk is the number of OrderedObject values per chunk.
+	arenaObjectChunk = 256
+	// arenaKeyChunk is the number of keys per Keys chunk. Larger objects get
+	// their own slice.
+	arenaKeyChunk = 1024
+	// maxPooledArenaObjects bounds the objects of an arena returned to the
+	// pool, so that one huge evaluation does not pin its memory forever.
+	maxPooledArenaObjects = 1 << 16
+)
+
+// objectArena holds the objects constructed by one evaluation.
+// It is not safe for concurrent use.
+type objectArena struct {
+	objects   [][]OrderedObject
+	object    int // objects handed out
+	keys      [][]string
+	keyChunk  int // current chunk of keys
+	keyPos    int // next free key in keys[keyChunk]
+	maps      []map[string]interface{}
+	mapsInUse int
+}
+
+var objectArenaPool = sync.Pool{
+	New: func() interface{} { return new(objectArena) },
+}
+
+func acquireObjectArena() *objectArena {
+	return objectArenaPool.Get().(*objectArena)
+}
+
+// releaseObjectArena clears the objects handed out by a and returns it to the
+// pool. Nothing allocated from a may be reachable afterwards.
+func releaseObjectArena(a *objectArena) {
+	if a.object > maxPooledArenaObjects {
+		return
+	}
+	for i := 0; i*arenaObjectChunk < a.object; i++ {
+		clear(a.objects[i][:min(arenaObjectChunk, a.object-i*arenaObjectChunk)])
+	}
+	for i := 0; i <= a.keyChunk && i < len(a.keys); i++ {
+		clear(a.keys[i])
+	}
+	for _, m := range a.maps[:a.mapsInUse] {
+		clear(m)
+	}
+	a.object, a.keyChunk, a.keyPos, a.mapsInUse = 0, 0, 0, 0
+	objectArenaPool.Put(a)
+}
+
+// newObject returns an empty object with room for size keys.
+func (a *objectArena) newObject(size int) *OrderedObject {
+	chunk, pos := a.object/arenaObjectChunk, a.object%arenaObjectChunk
+	if chunk == len(a.objects) {
+		a.objects = append(a.objects, make([]OrderedObject, arenaObjectChunk))
+	}
+	a.object++
+	obj := &a.objects[chunk][pos]
+	obj.Keys = a.newKeys(size)
+	obj.Values = a.newMap(size)
+	return obj
+}
+
+// newKeys returns an empty slice with a capacity of size keys. Appending
+// beyond it reallocates, as it must not overwrite the next slice.
+func (a *objectArena) newKeys(size int) []string {
+	if size > arenaKeyChunk {
+		return make([]string, 0, size)
+	}
+	if a.keyChunk < len(a.keys) && a.keyPos+size > arenaKeyChunk {
+		a.keyChunk++
+		a.keyPos = 0
+	}
+	if a.keyChunk == len(a.keys) {
+		a.keys = append(a.keys, make([]string, arenaKeyChunk))
+	}
+	keys := a.keys[a.keyChunk][a.keyPos : a.keyPos : a.keyPos+size]
+	a.keyPos += size
+	return keys
+}
+
+// newMap returns an empty map, reusing one of a previous evaluation if any.
+func (a *objectArena) newMap(size int) map[string]interface{} {
+	if a.mapsInUse < len(a.maps) {
+		m := a.maps[a.mapsInUse]
+		a.mapsInUse++
+		return m
+	}
+	m := make(map[string]interface{}, size)
+	a.maps = append(a.maps, m)
+	a.mapsInUse++
+	return m
+}
+
+// newObject returns an empty object with room for size keys, from the arena
+// of the evaluation when it has one.
+func (c *EvalContext) newObject(size int) *OrderedObject {
+	if a := c.root.objects; a != nil {
+		return a.newObject(size)
+	}
+	return &OrderedObject{
+		Keys:   make([]string, 0, size),
+		Values: make(map[string]interface{}, size),
+	}
+}
@@ -30,14 +30,8 @@ func (e *Evaluator) evalBlock(ctx context.Context, node *types.ASTNode, evalCtx
 		return nil, nil
 	}
 
-	// Create a child context for the block scope
-	// This ensures variable bindings are local to this block
-	blockCtx := &EvalContext{
-		data:     evalCtx.Data(),
-		parent:   evalCtx,
-		bindings: make(map[string]interface{}),
-		depth:    evalCtx.Depth() + 1,
-	}
+	// Each block is a new frame: its bindings are local to it (see newFrame).
+	blockCtx := evalCtx.newFrame()
 
 	var result interface{}
 	var err error
@@ -64,7 +58,9 @@ func (e *Evaluator) evalBlock(ctx context.Context, node *types.ASTNode, evalCtx
 	return result, nil
 }
 
-// evalAnd evaluates logical AND (short-circuit).
+// evalAnd evaluates logical AND (short-circuit). Like jsonata-js, and and or
+// always return a boolean: an undefined operand counts as false (see isTruthy),
+// and only the errors of the operands evaluated are reported.
 
 func (e *Evaluator) evalAnd(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
 	left, err := e.evalNode(ctx, node.LHS, evalCtx)
@@ -149,7 +145,7 @@ func (e *Evaluator) evalDefault(ctx context.Context, node *types.ASTNode, evalCt
 	}
 
 	// If left is truthy (using default operator semantics), return it
-	if e.isTruthyForDefault(left) {
+	if e.isTruthy(left) {
 		return left, nil
 	}
 
@@ -0,0 +1,246 @@
+package evaluator
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Binding validation (EvalWithBindings).
+//
+// A binding whose name no expression can write, or whose value the evaluator
+// cannot navigate, used to be accepted and then fail silently: the variable
+// read as undefined, or a struct read as an opaque value without fields.
+// EvalWithBindings checks the bindings before evaluating instead, and converts
+// Go values outside the evaluator's data model on the way, so that a mistake
+// is reported with the name of the binding at fault.
+
+// BindingError reports a binding rejected by EvalWithBindings.
+type BindingError struct {
+	// Name is the name of the binding, as given.
+	Name string
+	// Reason says what is wrong with it.
+	Reason string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+// Error implements the error interface.
+func (b *BindingError) Error() string {
+	if b.Err != nil {
+		return fmt.Sprintf("binding %q: %s: %v", b.Name, b.Reason, b.Err)
+	}
+	return fmt.Sprintf("binding %q: %s", b.Name, b.Reason)
+}
+
+// Unwrap returns the underlying error.
+func (b *BindingError) Unwrap() error { return b.Err }
+
+// nameTerminators are the characters that end a variable name in an
+// expression: whitespace and the operator characters.
+const nameTerminators = " \t\n\r.,;:()[]{}+-*/%|=<>!&^~?@#\"'`"
+
+// checkBindings returns bindings with their values converted to the
+// evaluator's data model (see bindingValue), or the error of the first
+// invalid binding: a name that cannot be written as a variable, $ and $$
+// included, or, without WithFunctionShadowing, the name of a function of e.
+// bindings itself is not modified.
+func (e *Evaluator) checkBindings(bindings map[string]interface{}) (map[string]interface{}, error) {
+	var converted map[string]interface{}
+	for name, value := range bindings {
+		switch {
+		case name == "":
+			return nil, &BindingError{Name: name, Reason: "$ is reserved for the context value"}
+		case name == "$":
+			return nil, &BindingError{Name: name, Reason: "$$ is reserved for the input"}
+		case strings.HasPrefix(name, "$"):
+			return nil, &BindingError{Name: name, Reason: "names are given without the leading $"}
+		case strings.ContainsAny(name, nameTerminators):
+			return nil, &BindingError{Name: name, Reason: "the name cannot be written as a variable"}
+		}
+		if _, isFunc := e.functions[name]; isFunc && !e.opts.FunctionShadowing {
+			return nil, &BindingError{Name: name,
+				Reason: fmt.Sprintf("the name shadows the function $%s (see WithFunctionShadowing)", name)}
+		}
+		v, err := bindingValue(value, &dataGuard{maxNodes: maxCloneNodes})
+		if err != nil {
+			return nil, &BindingError{Name: name, Reason: "unsupported value", Err: err}
+		}
+		if !sameValue(v, value) {
+			if converted == nil {
+				converted = make(map[string]interface{}, len(bindings))
+				for k, item := range bindings {
+					converted[k] = item
+				}
+			}
+			converted[name] = v
+		}
+	}
+	if converted != nil {
+		return converted, nil
+	}
+	return bindings, nil
+}
+
+// bindingValue converts v to the evaluator's data model: numbers of other Go
+// types become float64, time.Time an ISO 8601 string with milliseconds (as
+// $now formats it), slices and arrays []interface{}, maps with string keys
+// map[string]interface{}, pointers the value they point to, and structs and
+// other json.Marshaler values what their JSON encoding decodes to. Values of
+// the model, function values and Go iterators are kept, containers being
+// copied only when something in them is converted.
+func bindingValue(v interface{}, g *dataGuard) (interface{}, error) {
+	switch x := v.(type) {
+	case nil, bool, string, float64, types.Null, []byte, *Frozen, *Lambda, *FunctionDef:
+		return v, nil
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", x.String())
+		}
+		return f, nil
+	case time.Time:
+		return x.UTC().Format(isoMillisLayout), nil
+	case []interface{}:
+		if err := g.enter(x); err != nil {
+			return nil, err
+		}
+		defer g.leave(x)
+		var arr []interface{}
+		for i, item := range x {
+			c, err := bindingValue(item, g)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			if arr == nil && !sameValue(c, item) {
+				arr = append([]interface{}(nil), x...)
+			}
+			if arr != nil {
+				arr[i] = c
+			}
+		}
+		if arr == nil {
+			return x, nil
+		}
+		return arr, nil
+	case map[string]interface{}:
+		if err := g.enter(x); err != nil {
+			return nil, err
+		}
+		defer g.leave(x)
+		var obj map[string]interface{}
+		for k, item := range x {
+			c, err := bindingValue(item, g)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			if obj == nil && !sameValue(c, item) {
+				obj = make(map[string]interface{}, len(x))
+				for k2, item2 := range x {
+					obj[k2] = item2
+				}
+			}
+			if obj != nil {
+				obj[k] = c
+			}
+		}
+		if obj == nil {
+			return x, nil
+		}
+		return obj, nil
+	case *OrderedObject:
+		values, err := bindingValue(x.Values, g)
+		if err != nil {
+			return nil, err
+		}
+		if m := values.(map[string]interface{}); !sameValue(m, x.Values) {
+			return &OrderedObject{Keys: x.Keys, Values: m}, nil
+		}
+		return x, nil
+	}
+	if isSeq(v) {
+		return v, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if _, ok := v.(json.Marshaler); ok && rv.Kind() != reflect.Pointer {
+		return jsonValue(v)
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Func:
+		return v, nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, nil
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		return bindingValue(items, g)
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if rv.IsNil() {
+			return nil, nil
+		}
+		obj := make(map[string]interface{}, rv.Len())
+		for it := rv.MapRange(); it.Next(); {
+			obj[it.Key().String()] = it.Value().Interface()
+		}
+		return bindingValue(obj, g)
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return bindingValue(rv.Elem().Interface(), g)
+	case reflect.Struct:
+		return jsonValue(v)
+	}
+	return nil, fmt.Errorf("unsupported value of type %T", v)
+}
+
+// jsonValue returns the value the JSON encoding of v decodes to.
+func jsonValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// sameValue reports whether bindingValue returned v unchanged. Containers and
+// functions, which cannot be compared with ==, are compared by identity.
+func sameValue(converted, v interface{}) bool {
+	a, b := reflect.ValueOf(converted), reflect.ValueOf(v)
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func, reflect.Pointer:
+		return a.Pointer() == b.Pointer() && (a.Kind() != reflect.Slice || a.Len() == b.Len())
+	}
+	return a.Comparable() && a.Equal(b)
+}
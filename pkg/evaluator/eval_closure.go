@@ -0,0 +1,189 @@
+package evaluator
+
+import (
+	"slices"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Closure minimization for returned function values.
+//
+// A lambda captures the context it was created in and, through its parent chain,
+// every enclosing binding and the input document. The live chain is required
+// while the evaluation runs: a block may bind a variable after creating a lambda
+// that refers to it, which is how recursive functions are written. A function
+// value returned from Eval, however, would keep the whole input alive for as long
+// as the caller holds on to it.
+//
+// Before such a result is returned, every lambda in it is replaced by a copy whose
+// closure only holds what its body can observe, as computed by the parser
+// (types.ClosureInfo): the variables it references, plus the context value and
+// $$ only when the body reads them. Lambdas using the % operator keep their full
+// context, since % walks the chain of enclosing contexts.
+
+// closureDetacher rewrites the lambdas of one result. memo maps each lambda to its
+// detached copy, so shared and self-referencing (recursive) functions stay shared.
+type closureDetacher struct {
+	e     *Evaluator
+	memo  map[*Lambda]*Lambda
+	guard dataGuard
+}
+
+// detachClosures returns v with every reachable lambda replaced by a detached
+// copy. Containers are only copied when they hold a lambda.
+func (e *Evaluator) detachClosures(v interface{}) (interface{}, error) {
+	d := closureDetacher{e: e, memo: make(map[*Lambda]*Lambda)}
+	out, _, err := d.value(v)
+	return out, err
+}
+
+// value detaches the lambdas in v; changed reports whether out differs from v.
+func (d *closureDetacher) value(v interface{}) (out interface{}, changed bool, err error) {
+	switch val := v.(type) {
+	case *Lambda:
+		l, err := d.lambda(val)
+		return l, l != val, err
+	case []interface{}:
+		if err := d.guard.enter(val); err != nil {
+			return nil, false, err
+		}
+		defer d.guard.leave(val)
+		var copied []interface{}
+		for i, item := range val {
+			detached, itemChanged, err := d.value(item)
+			if err != nil {
+				return nil, false, err
+			}
+			if itemChanged && copied == nil {
+				copied = slices.Clone(val)
+			}
+			if copied != nil {
+				copied[i] = detached
+			}
+		}
+		if copied == nil {
+			return val, false, nil
+		}
+		return copied, true, nil
+	case map[string]interface{}:
+		if err := d.guard.enter(val); err != nil {
+			return nil, false, err
+		}
+		defer d.guard.leave(val)
+		copied, err := d.values(val)
+		if err != nil || copied == nil {
+			return val, false, err
+		}
+		return copied, true, nil
+	case *OrderedObject:
+		if err := d.guard.enter(val); err != nil {
+			return nil, false, err
+		}
+		defer d.guard.leave(val)
+		copied, err := d.values(val.Values)
+		if err != nil || copied == nil {
+			return val, false, err
+		}
+		return &OrderedObject{Keys: val.Keys, Values: copied}, true, nil
+	default:
+		return v, false, nil
+	}
+}
+
+// values detaches the lambdas of an object's values. It returns nil when none
+// changed, or a copy of m with the detached values.
+func (d *closureDetacher) values(m map[string]interface{}) (map[string]interface{}, error) {
+	var copied map[string]interface{}
+	for k, item := range m {
+		detached, changed, err := d.value(item)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			continue
+		}
+		if copied == nil {
+			copied = make(map[string]interface{}, len(m))
+			for k2, v2 := range m {
+				copied[k2] = v2
+			}
+		}
+		copied[k] = detached
+	}
+	return copied, nil
+}
+
+// lambda returns the detached copy of l.
+func (d *closureDetacher) lambda(l *Lambda) (*Lambda, error) {
+	if detached, ok := d.memo[l]; ok {
+		return detached, nil
+	}
+	info := l.Closure
+	if info == nil {
+		// Partial applications and compositions are built at run time.
+		info = types.AnalyzeClosure(l.Body)
+	}
+	if l.Ctx == nil || info.UsesParent || info.UsesEval {
+		d.memo[l] = l
+		return l, nil
+	}
+
+	var rootData interface{}
+	if info.UsesRoot && l.Ctx.root != nil {
+		rootData = l.Ctx.root.data
+	}
+	closure := NewContext(rootData)
+	if info.UsesContext || d.e.callsWithContext(l.Ctx, info) {
+		closure = closure.NewChildContext(l.Ctx.data)
+	}
+	closure.markEscaped()
+
+	detached := &Lambda{
+		Params:    l.Params,
+		Body:      l.Body,
+		Ctx:       closure,
+		Signature: l.Signature,
+		Closure:   info,
+	}
+	// Register before resolving the bindings: a recursive function refers to itself.
+	d.memo[l] = detached
+
+	for _, name := range info.Vars {
+		if slices.Contains(l.Params, name) {
+			continue // always bound by the call
+		}
+		value, found := l.Ctx.GetBinding(name)
+		if !found {
+			continue
+		}
+		value, _, err := d.value(value)
+		if err != nil {
+			return nil, err
+		}
+		closure.SetBinding(name, value)
+	}
+	return detached, nil
+}
+
+// callsWithContext reports whether one of the body's calls resolves to a
+// function that may receive the context value as an implicit argument: a
+// built-in, or a lambda whose signature marks a parameter "-".
+func (e *Evaluator) callsWithContext(ctx *EvalContext, info *types.ClosureInfo) bool {
+	for _, name := range info.ContextCalls {
+		value, found := ctx.GetBinding(name)
+		if !found {
+			value, found = e.LookupFunction(name)
+		}
+		switch fn := value.(type) {
+		case *FunctionDef:
+			if fn.readsContext() {
+				return true
+			}
+		case *Lambda:
+			if signatureReadsContext(fn.Signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package evaluator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// DiffKind tells how a value differs between the two results of EvalCompare.
+type DiffKind int
+
+const (
+	// DiffChanged is a value present in both results with different values.
+	DiffChanged DiffKind = iota
+	// DiffOnlyA is a value, an object field or array item, missing from B.
+	DiffOnlyA
+	// DiffOnlyB is a value, an object field or array item, missing from A.
+	DiffOnlyB
+)
+
+// String returns "changed", "only in A" or "only in B".
+func (k DiffKind) String() string {
+	switch k {
+	case DiffOnlyA:
+		return "only in A"
+	case DiffOnlyB:
+		return "only in B"
+	default:
+		return "changed"
+	}
+}
+
+// Difference is one difference between the two results of EvalCompare.
+type Difference struct {
+	// Path addresses the value from the root of the result: "$" for the result
+	// itself, then ".field" (or ["field"] for keys that are not identifiers)
+	// and "[index]" steps, e.g. `$.orders[2].total`.
+	Path string
+	Kind DiffKind
+	// A and B are the values in each result; the missing one is nil for
+	// DiffOnlyA and DiffOnlyB.
+	A, B interface{}
+}
+
+// String formats the difference as `path: a -> b`.
+func (d Difference) String() string {
+	switch d.Kind {
+	case DiffOnlyA:
+		return fmt.Sprintf("%s: only in A: %s", d.Path, renderValue(d.A))
+	case DiffOnlyB:
+		return fmt.Sprintf("%s: only in B: %s", d.Path, renderValue(d.B))
+	default:
+		return fmt.Sprintf("%s: %s -> %s", d.Path, renderValue(d.A), renderValue(d.B))
+	}
+}
+
+// Comparison is the result of EvalCompare.
+type Comparison struct {
+	// A and B are the results for each input, exactly as Eval returns them.
+	A, B interface{}
+	// Diffs lists the differences between A and B, in document order of A
+	// (fields and items only in B follow those of the same container).
+	Diffs []Difference
+}
+
+// Equal reports whether the two results are structurally equal.
+func (c *Comparison) Equal() bool {
+	return len(c.Diffs) == 0
+}
+
+// EvalCompare evaluates expr against dataA and dataB and compares the results
+// structurally, e.g. to check that a new version of an input payload maps to
+// the same output as the old one.
+//
+// The comparison follows the equality of the = operator on arrays and objects:
+// objects compare by key regardless of key order and of their representation
+// (map or *OrderedObject), numbers by value whatever their Go type, and null
+// equals a nil decoded from JSON. Arrays compare item by item. An undefined
+// result differs from any value; since null is returned as nil by default, it
+// differs from null only with WithNullHandling(NullAsValue).
+//
+// An error from either evaluation is returned as is; nothing is compared.
+func (e *Evaluator) EvalCompare(ctx context.Context, expr *types.Expression, dataA, dataB interface{}) (*Comparison, error) {
+	a, err := e.Eval(ctx, expr, dataA)
+	if err != nil {
+		return nil, err
+	}
+	b, err := e.Eval(ctx, expr, dataB)
+	if err != nil {
+		return nil, err
+	}
+	c := &Comparison{A: a, B: b}
+	switch {
+	case a == nil && b == nil:
+	case a == nil:
+		c.Diffs = append(c.Diffs, Difference{Path: "$", Kind: DiffOnlyB, B: b})
+	case b == nil:
+		c.Diffs = append(c.Diffs, Difference{Path: "$", Kind: DiffOnlyA, A: a})
+	default:
+		c.Diffs = diffValues(c.Diffs, "$", a, b)
+	}
+	return c, nil
+}
+
+// diffValues appends the differences between a and b, found at path, to diffs.
+func diffValues(diffs []Difference, path string, a, b interface{}) []Difference {
+	if aa, ok := a.([]interface{}); ok {
+		ba, ok := b.([]interface{})
+		if !ok {
+			return append(diffs, Difference{Path: path, A: a, B: b})
+		}
+		for i := 0; i < max(len(aa), len(ba)); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(ba):
+				diffs = append(diffs, Difference{Path: itemPath, Kind: DiffOnlyA, A: aa[i]})
+			case i >= len(aa):
+				diffs = append(diffs, Difference{Path: itemPath, Kind: DiffOnlyB, B: ba[i]})
+			default:
+				diffs = diffValues(diffs, itemPath, aa[i], ba[i])
+			}
+		}
+		return diffs
+	}
+	if aKeys, aValues, ok := resultEntries(a); ok {
+		bKeys, bValues, ok := resultEntries(b)
+		if !ok {
+			return append(diffs, Difference{Path: path, A: a, B: b})
+		}
+		for _, k := range aKeys {
+			bv, found := bValues[k]
+			if !found {
+				diffs = append(diffs, Difference{Path: fieldPath(path, k), Kind: DiffOnlyA, A: aValues[k]})
+				continue
+			}
+			diffs = diffValues(diffs, fieldPath(path, k), aValues[k], bv)
+		}
+		for _, k := range bKeys {
+			if _, found := aValues[k]; !found {
+				diffs = append(diffs, Difference{Path: fieldPath(path, k), Kind: DiffOnlyB, B: bValues[k]})
+			}
+		}
+		return diffs
+	}
+	if !deepEqual(a, b) {
+		diffs = append(diffs, Difference{Path: path, A: a, B: b})
+	}
+	return diffs
+}
+
+// resultEntries returns the keys, in order, and the values of an object.
+// Map keys are sorted.
+func resultEntries(v interface{}) ([]string, map[string]interface{}, bool) {
+	switch o := v.(type) {
+	case *OrderedObject:
+		return o.Keys, o.Values, true
+	case map[string]interface{}:
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys, o, true
+	}
+	return nil, nil, false
+}
+
+// fieldPath appends a field step to path: .key for identifiers, ["key"]
+// otherwise.
+func fieldPath(path, key string) string {
+	for i, r := range key {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return fmt.Sprintf("%s[%q]", path, key)
+		}
+	}
+	if key == "" {
+		return path + `[""]`
+	}
+	return path + "." + key
+}
+
+// renderValue renders a result value as compact JSON for Difference.String.
+func renderValue(v interface{}) string {
+	if v == nil {
+		return "undefined"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
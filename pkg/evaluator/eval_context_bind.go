@@ -98,9 +98,10 @@ func (e *Evaluator) evalIndexBind(ctx context.Context, node *types.ASTNode, eval
 	result := make([]interface{}, 0, len(arr))
 	for i, item := range arr {
 		actualItem, existingBindings := extractBoundItem(item)
-		var existingParent interface{}
+		var existingParent, existingParentObj interface{}
 		if cv, ok := item.(*contextBoundValue); ok {
 			existingParent = cv.parent
+			existingParentObj = cv.parentObj
 		}
 
 		// Merge existing with position binding
@@ -111,9 +112,10 @@ func (e *Evaluator) evalIndexBind(ctx context.Context, node *types.ASTNode, eval
 		newBindings[varName] = float64(i)
 
 		result = append(result, &contextBoundValue{
-			value:    actualItem,
-			parent:   existingParent,
-			bindings: newBindings,
+			value:     actualItem,
+			parent:    existingParent,
+			bindings:  newBindings,
+			parentObj: existingParentObj, // keeps % working after the index binding
 		})
 	}
 
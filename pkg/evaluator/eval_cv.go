@@ -1,9 +1,16 @@
 package evaluator
 
+// contextBoundValue is an item of a path carrying the variables bound by the
+// @ and # operators of the steps before it.
+//
+// The bindings map is immutable once the value is built: operators that bind
+// another variable or merge bindings build a new map. Values derived from a
+// contextBoundValue, and the contexts focused on it, share its map instead of
+// copying it (see applyBindingsToCtx).
 type contextBoundValue struct {
 	value     interface{}            // current context data (used as $ for evaluation)
 	parent    interface{}            // preceding context data (used by @ to rewind context)
-	bindings  map[string]interface{} // inherited variable bindings ($var → value)
+	bindings  map[string]interface{} // inherited variable bindings ($var → value); read-only
 	parentObj interface{}            // the containing object for % operator (distinct from @ semantics)
 }
 
@@ -18,6 +25,20 @@ func extractBoundItem(item interface{}) (value interface{}, bindings map[string]
 	return item, nil
 }
 
+// boundValue returns the value an item stands for, without copying: the value
+// of a contextBoundValue, the item itself otherwise. Unlike unwrapCVsDeep it
+// does not look inside arrays and objects, so the aggregates (see
+// boundArgFunctions) apply it to each item they read.
+func boundValue(item interface{}) interface{} {
+	for {
+		cv, ok := item.(*contextBoundValue)
+		if !ok {
+			return item
+		}
+		item = cv.value
+	}
+}
+
 // mergeBoundBindings merges parentBindings into a result item, wrapping or upgrading its cv.
 // parentBindings take lower priority than child's own bindings.
 
@@ -26,6 +47,9 @@ func mergeBoundBindings(item interface{}, parentBindings map[string]interface{},
 		return item
 	}
 	if cv, ok := item.(*contextBoundValue); ok {
+		if len(cv.bindings) == 0 {
+			return &contextBoundValue{value: cv.value, parent: cv.parent, bindings: parentBindings, parentObj: cv.parentObj}
+		}
 		merged := make(map[string]interface{}, len(parentBindings)+len(cv.bindings))
 		for k, v := range parentBindings {
 			merged[k] = v
@@ -33,41 +57,126 @@ func mergeBoundBindings(item interface{}, parentBindings map[string]interface{},
 		for k, v := range cv.bindings { // child overrides
 			merged[k] = v
 		}
-		return &contextBoundValue{value: cv.value, parent: cv.parent, bindings: merged}
+		return &contextBoundValue{value: cv.value, parent: cv.parent, bindings: merged, parentObj: cv.parentObj}
 	}
-	// Wrap plain value with parent bindings
-	return &contextBoundValue{value: item, parent: parentValue, bindings: copyBindings(parentBindings)}
+	// Wrap plain value with parent bindings (shared: both are read-only)
+	return &contextBoundValue{value: item, parent: parentValue, bindings: parentBindings}
 }
 
-// copyBindings makes a shallow copy of a binding map.
+// applyBindingsToCtx sets all bindings onto an EvalContext. A context without
+// bindings of its own borrows the map, which it copies before a write.
 
-func copyBindings(b map[string]interface{}) map[string]interface{} {
-	if len(b) == 0 {
-		return nil
+func applyBindingsToCtx(ctx *EvalContext, bindings map[string]interface{}) {
+	if ctx.bindings == nil {
+		ctx.bindings = bindings
+		ctx.sharedBindings = true
+		return
 	}
-	c := make(map[string]interface{}, len(b))
-	for k, v := range b {
-		c[k] = v
+	ctx.SetBindings(bindings)
+}
+
+// boundItemData returns the context data of a path item: for an item bound by
+// @, the context it rewinds to, otherwise its value.
+func boundItemData(item interface{}) interface{} {
+	if cv, ok := item.(*contextBoundValue); ok {
+		if cv.parent != nil {
+			return cv.parent
+		}
+		return cv.value
 	}
-	return c
+	return item
 }
 
-// applyBindingsToCtx sets all bindings onto an EvalContext.
+// boundItemContext returns a child context of evalCtx focused on item. When item
+// is a contextBoundValue, the context holds its data (see boundItemData) and
+// bindings and, for the % operator, sits below its containing object.
 
-func applyBindingsToCtx(ctx *EvalContext, bindings map[string]interface{}) {
-	for k, v := range bindings {
-		ctx.SetBinding(k, v)
+func boundItemContext(evalCtx *EvalContext, item interface{}) *EvalContext {
+	cv, ok := item.(*contextBoundValue)
+	if !ok {
+		return evalCtx.NewChildContext(item)
+	}
+	var itemCtx *EvalContext
+	if cv.parentObj != nil && cv.parent == nil {
+		itemCtx = evalCtx.NewChildContext(cv.parentObj).NewArrayItemContext(cv.value)
+	} else {
+		itemCtx = evalCtx.NewChildContext(boundItemData(cv))
+	}
+	if len(cv.bindings) > 0 {
+		applyBindingsToCtx(itemCtx, cv.bindings)
+	}
+	return itemCtx
+}
+
+// reduceBindings merges the bindings of a group of items: a variable bound by
+// several items holds the sequence of their values, in item order (JSONata's
+// reduceTupleStream). It returns nil when no item carries bindings.
+
+func reduceBindings(items []interface{}) map[string]interface{} {
+	var values map[string][]interface{}
+	for _, item := range items {
+		_, bindings := extractBoundItem(item)
+		for k, v := range bindings {
+			if values == nil {
+				values = make(map[string][]interface{}, len(bindings))
+			}
+			values[k] = append(values[k], v)
+		}
+	}
+	if values == nil {
+		return nil
+	}
+	reduced := make(map[string]interface{}, len(values))
+	for k, vs := range values {
+		if len(vs) == 1 {
+			reduced[k] = vs[0]
+			continue
+		}
+		seq := make([]interface{}, 0, len(vs))
+		for _, v := range vs {
+			if arr, ok := v.([]interface{}); ok {
+				seq = append(seq, arr...)
+			} else {
+				seq = append(seq, v)
+			}
+		}
+		reduced[k] = seq
 	}
+	return reduced
 }
 
 // unwrapCVsDeep recursively extracts plain values from contextBoundValues.
 // This is used when CVs must be invisible to operators (equality, arithmetic, etc.)
-// and at the final return point of evaluation.
+// and at the final return point of evaluation. Self-referencing data fails with
+// D1010 instead of recursing forever (see dataGuard).
+//
+// Containers are copied only when they hold a CV: arrays and objects without
+// one, including those of the caller's input, are returned as is and never
+// written to.
 
-func unwrapCVsDeep(v interface{}) interface{} {
+func unwrapCVsDeep(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case *contextBoundValue, []interface{}, *OrderedObject:
+		var g dataGuard
+		out, _, err := unwrapCVs(v, &g)
+		return out, err
+	default:
+		return v, nil
+	}
+}
+
+// unwrapCVs is the guarded recursion behind unwrapCVsDeep; changed reports
+// whether out differs from v.
+
+func unwrapCVs(v interface{}, g *dataGuard) (out interface{}, changed bool, err error) {
 	switch val := v.(type) {
 	case *contextBoundValue:
-		return unwrapCVsDeep(val.value)
+		if err := g.enter(val); err != nil {
+			return nil, false, err
+		}
+		defer g.leave(val)
+		out, _, err := unwrapCVs(val.value, g)
+		return out, true, err
 	case []interface{}:
 		// Check if any items (at any depth) need unwrapping
 		needsUnwrap := false
@@ -81,25 +190,61 @@ func unwrapCVsDeep(v interface{}) interface{} {
 			}
 		}
 		if !needsUnwrap {
-			return val
+			return val, false, nil
 		}
-		result := make([]interface{}, len(val))
+		if err := g.enter(val); err != nil {
+			return nil, false, err
+		}
+		defer g.leave(val)
+		var result []interface{}
 		for i, item := range val {
-			result[i] = unwrapCVsDeep(item)
+			unwrapped, itemChanged, err := unwrapCVs(item, g)
+			if err != nil {
+				return nil, false, err
+			}
+			if itemChanged && result == nil {
+				result = make([]interface{}, len(val))
+				copy(result, val[:i])
+			}
+			if result != nil {
+				result[i] = unwrapped
+			}
+		}
+		if result == nil {
+			return val, false, nil
 		}
-		return result
+		return result, true, nil
 	case *OrderedObject:
-		// Unwrap CVs inside OrderedObject values
+		if err := g.enter(val); err != nil {
+			return nil, false, err
+		}
+		defer g.leave(val)
+		// Unwrap CVs inside OrderedObject values, copying the object on the
+		// first change.
+		var values map[string]interface{}
 		for k, ov := range val.Values {
-			unwrapped := unwrapCVsDeep(ov)
-			val.Values[k] = unwrapped
+			unwrapped, itemChanged, err := unwrapCVs(ov, g)
+			if err != nil {
+				return nil, false, err
+			}
+			if !itemChanged {
+				continue
+			}
+			if values == nil {
+				values = make(map[string]interface{}, len(val.Values))
+				for k2, v2 := range val.Values {
+					values[k2] = v2
+				}
+			}
+			values[k] = unwrapped
+		}
+		if values == nil {
+			return val, false, nil
 		}
-		return val
+		return &OrderedObject{Keys: val.Keys, Values: values}, true, nil
 	default:
-		return v
+		return v, false, nil
 	}
 }
 
 // evalNode evaluates an AST node in the given context.
-// recurseDepthKey stores a *int pointer so depth can be incremented/decremented (stack-style)
-// matching JSONata JS semantics where depth is the maximum current call stack depth.
@@ -0,0 +1,173 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Step-through debugging (WithDebugger).
+//
+// With a debugger installed, evalNode routes every node through
+// evalNodeDebug, which decides before the node is evaluated whether the
+// evaluation pauses there: on the first node, then wherever the last action
+// asked to (the next node, the next node outside the current one, ...) and at
+// the breakpoints. Pausing means calling the hook with a snapshot of the
+// evaluation context; the evaluation resumes when the hook returns, so a
+// debugger UI pauses by blocking in the hook until the user acts.
+//
+// The state of a session lives on the root context. Concurrent evaluation
+// (sharding, parallel sort keys) is disabled while debugging, so the hook is
+// only ever called from the goroutine of the evaluation.
+
+// DebugAction tells a paused evaluation how to resume.
+type DebugAction int
+
+const (
+	// DebugStep pauses again at the next node evaluated.
+	DebugStep DebugAction = iota
+	// DebugStepOver pauses at the next node that is not inside the current
+	// one.
+	DebugStepOver
+	// DebugStepOut pauses at the next node outside the parent of the current
+	// one.
+	DebugStepOut
+	// DebugContinue runs until the next breakpoint.
+	DebugContinue
+	// DebugAbort stops the evaluation with ErrDebugAborted.
+	DebugAbort
+)
+
+// ErrDebugAborted is returned by an evaluation stopped with DebugAbort.
+var ErrDebugAborted = errors.New("evaluation aborted by the debugger")
+
+// DebugFrame is the state of an evaluation paused before a node. It is a
+// snapshot: its fields stay valid after the hook returns, but Data and the
+// bound values are those of the evaluation, and must not be modified.
+type DebugFrame struct {
+	// Node is the node about to be evaluated.
+	Node *types.ASTNode
+	// Position is the offset of Node in the expression source.
+	Position int
+	// Depth is the number of nodes being evaluated around Node.
+	Depth int
+	// Data is the context value Node is evaluated against ($).
+	Data interface{}
+	// Root is the input of the evaluation ($$).
+	Root interface{}
+	// Bindings holds the variables visible from Node, by name without the
+	// $: those bound by the expression, by EvalWithBindings and by preludes.
+	// Function values are included.
+	Bindings map[string]interface{}
+	// Breakpoint reports whether the evaluation paused on a breakpoint.
+	Breakpoint bool
+}
+
+// DebugHook is called each time a debugged evaluation pauses, and returns how
+// to resume it. ctx is the context of the evaluation: a hook that waits for a
+// user should give up when it is done.
+type DebugHook func(ctx context.Context, frame *DebugFrame) DebugAction
+
+// WithDebugger makes every evaluation pause before its first node and at the
+// nodes starting at the source offsets in breakpoints, calling hook each
+// time; the action hook returns decides where the evaluation pauses next.
+// Debugging is slow and serializes the evaluation: it is meant for tools,
+// not for production evaluators.
+func WithDebugger(hook DebugHook, breakpoints ...int) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.DebugHook = hook
+		opts.Breakpoints = breakpoints
+	}
+}
+
+// debugSession is the state of one debugged evaluation.
+type debugSession struct {
+	mu     sync.Mutex
+	depth  int         // nodes being evaluated
+	mode   DebugAction // the last action
+	target int         // the depth DebugStepOver and DebugStepOut return to
+}
+
+// evalNodeDebug evaluates node, pausing before it if the session says so.
+func (e *Evaluator) evalNodeDebug(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
+	root := evalCtx.root
+	if root.debug == nil {
+		root.debug = &debugSession{mode: DebugStep}
+	}
+	s := root.debug
+	if err := e.debugPause(ctx, s, node, evalCtx); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.depth++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.depth--
+		s.mu.Unlock()
+	}()
+	return e.dispatchNode(ctx, node, evalCtx)
+}
+
+// debugPause calls the hook when the evaluation must pause before node.
+func (e *Evaluator) debugPause(ctx context.Context, s *debugSession, node *types.ASTNode, evalCtx *EvalContext) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	breakpoint := false
+	for _, pos := range e.opts.Breakpoints {
+		if pos == node.Position {
+			breakpoint = true
+			break
+		}
+	}
+	pause := breakpoint
+	switch s.mode {
+	case DebugStep:
+		pause = true
+	case DebugStepOver:
+		pause = pause || s.depth <= s.target
+	case DebugStepOut:
+		pause = pause || s.depth < s.target
+	}
+	if !pause {
+		return nil
+	}
+
+	frame := &DebugFrame{
+		Node:       node,
+		Position:   node.Position,
+		Depth:      s.depth,
+		Data:       evalCtx.Data(),
+		Root:       evalCtx.root.Data(),
+		Bindings:   visibleBindings(evalCtx),
+		Breakpoint: breakpoint,
+	}
+	action := e.opts.DebugHook(ctx, frame)
+	switch action {
+	case DebugAbort:
+		return ErrDebugAborted
+	case DebugStepOver:
+		s.target = s.depth
+	case DebugStepOut:
+		s.target = s.depth - 1
+	}
+	s.mode = action
+	return nil
+}
+
+// visibleBindings returns the variables visible from evalCtx, the innermost
+// binding of a name hiding the outer ones.
+func visibleBindings(evalCtx *EvalContext) map[string]interface{} {
+	var chain []*EvalContext
+	for c := evalCtx; c != nil; c = c.parent {
+		chain = append(chain, c)
+	}
+	bindings := make(map[string]interface{})
+	for i := len(chain) - 1; i >= 0; i-- {
+		maps.Copy(bindings, chain[i].bindings)
+	}
+	return bindings
+}
@@ -0,0 +1,231 @@
+package evaluator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"reflect"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Structural equality.
+//
+// deepEqual compares two values of the JSON value model without reflection: it
+// is the equality of `=` and `in` on arrays and objects, of $distinct and of the
+// deduplication of path results. An *OrderedObject and a map[string]interface{}
+// with the same entries are equal, as are numbers of different Go types with
+// the same value (1 and 1.0), and a nil and types.Null: a nil stored in an
+// array or object is a JSON null, as decoded by encoding/json, whereas the
+// constructors of the language store types.Null. Expressions therefore compare
+// alike whether their values come from the input or were built by the
+// expression. (At the top level `=` tells undefined from null before calling
+// deepEqual.) hashValue is consistent with it, so that sets of values can be
+// bucketed by hash and compared only within a bucket.
+
+// deepEqual performs deep equality comparison between two values.
+// OPT-04: type switch avoids reflect.ValueOf allocation; covers all runtime types
+// produced by encoding/json and the evaluator itself. Values of other Go types
+// (e.g. a []string bound by the caller) fall back to reflect.DeepEqual.
+func deepEqual(a, b interface{}) bool {
+	if isNull(a) {
+		return isNull(b)
+	}
+	if an, ok := numberValue(a); ok {
+		bn, ok := numberValue(b)
+		return ok && an == bn
+	}
+	switch av := a.(type) {
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv
+	case string:
+		bv, ok := b.(string)
+		return ok && av == bv
+	case []byte:
+		bv, ok := b.([]byte)
+		return ok && bytes.Equal(av, bv)
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !deepEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		if len(av) != objectLen(b) {
+			return false
+		}
+		for k, v := range av {
+			bv, ok := objectGet(b, k)
+			if !ok || !deepEqual(v, bv) {
+				return false
+			}
+		}
+		return true
+	case *OrderedObject:
+		if len(av.Keys) != objectLen(b) {
+			return false
+		}
+		for _, k := range av.Keys {
+			bv, ok := objectGet(b, k)
+			if !ok || !deepEqual(av.Values[k], bv) {
+				return false
+			}
+		}
+		return true
+	case *Lambda:
+		bv, ok := b.(*Lambda)
+		return ok && av == bv
+	case *FunctionDef:
+		bv, ok := b.(*FunctionDef)
+		return ok && av == bv
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+// numberValue returns the value of a number of any of the Go types the
+// evaluator accepts as numbers.
+func numberValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// objectLen returns the number of entries of an object, or -1 if v is not one.
+func objectLen(v interface{}) int {
+	switch o := v.(type) {
+	case map[string]interface{}:
+		return len(o)
+	case *OrderedObject:
+		return len(o.Keys)
+	}
+	return -1
+}
+
+// objectGet returns the value of the key k of the object v.
+func objectGet(v interface{}, k string) (interface{}, bool) {
+	switch o := v.(type) {
+	case map[string]interface{}:
+		val, ok := o[k]
+		return val, ok
+	case *OrderedObject:
+		val, ok := o.Values[k]
+		return val, ok
+	}
+	return nil, false
+}
+
+var hashSeed = maphash.MakeSeed()
+
+// hashValue returns a hash of v such that deepEqual values hash alike. Object
+// entries are combined independently of their order; values outside the JSON
+// value model all hash alike and are told apart by deepEqual.
+func hashValue(v interface{}) uint64 {
+	var h maphash.Hash
+	h.SetSeed(hashSeed)
+	writeHash(&h, v)
+	return h.Sum64()
+}
+
+func writeHash(h *maphash.Hash, v interface{}) {
+	if n, ok := numberValue(v); ok {
+		if n == 0 {
+			n = 0 // -0 == 0
+		}
+		h.WriteByte('n')
+		writeUint64(h, math.Float64bits(n))
+		return
+	}
+	switch val := v.(type) {
+	case nil, types.Null:
+		h.WriteByte('z')
+	case bool:
+		if val {
+			h.WriteByte('t')
+		} else {
+			h.WriteByte('f')
+		}
+	case string:
+		h.WriteByte('s')
+		writeUint64(h, uint64(len(val)))
+		h.WriteString(val)
+	case []byte:
+		h.WriteByte('b')
+		writeUint64(h, uint64(len(val)))
+		h.Write(val)
+	case []interface{}:
+		h.WriteByte('a')
+		writeUint64(h, uint64(len(val)))
+		for _, item := range val {
+			writeHash(h, item)
+		}
+	case map[string]interface{}:
+		var sum uint64
+		for k, item := range val {
+			sum += hashEntry(k, item)
+		}
+		h.WriteByte('o')
+		writeUint64(h, sum)
+	case *OrderedObject:
+		var sum uint64
+		for _, k := range val.Keys {
+			sum += hashEntry(k, val.Values[k])
+		}
+		h.WriteByte('o')
+		writeUint64(h, sum)
+	default:
+		h.WriteByte('?')
+	}
+}
+
+func hashEntry(k string, v interface{}) uint64 {
+	var h maphash.Hash
+	h.SetSeed(hashSeed)
+	writeUint64(&h, uint64(len(k)))
+	h.WriteString(k)
+	writeHash(&h, v)
+	return h.Sum64()
+}
+
+func writeUint64(h *maphash.Hash, n uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], n)
+	h.Write(buf[:])
+}
+
+// valueSet is a set of values under deepEqual.
+type valueSet struct {
+	buckets map[uint64][]interface{}
+}
+
+func newValueSet(size int) *valueSet {
+	return &valueSet{buckets: make(map[uint64][]interface{}, size)}
+}
+
+// add adds v to the set and reports whether it was not already present.
+func (s *valueSet) add(v interface{}) bool {
+	h := hashValue(v)
+	bucket := s.buckets[h]
+	for _, seen := range bucket {
+		if deepEqual(seen, v) {
+			return false
+		}
+	}
+	s.buckets[h] = append(bucket, v)
+	return true
+}
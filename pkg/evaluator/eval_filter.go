@@ -58,6 +58,19 @@ func (e *Evaluator) evalFilter(ctx context.Context, node *types.ASTNode, evalCtx
 		return arr[index], nil
 	}
 
+	// Answer `field = value` lookups in the arrays of Frozen values from their
+	// indexes and, with WithAutoIndex, repeated ones from a hash index.
+	if len(evalCtx.root.frozen) > 0 {
+		if result, ok, err := e.frozenFilter(ctx, collection, node.RHS, evalCtx); ok || err != nil {
+			return result, err
+		}
+	}
+	if e.opts.AutoIndex {
+		if result, ok, err := e.evalIndexedFilter(ctx, collection, node.RHS, evalCtx); ok || err != nil {
+			return result, err
+		}
+	}
+
 	// For expressions that might be indices (like variables, unary minus, etc.)
 	// Try to evaluate as number and use as index
 	// This handles cases like [-1], [$i], etc.
@@ -134,6 +147,7 @@ func (e *Evaluator) evalFilter(ctx context.Context, node *types.ASTNode, evalCtx
 		// Handle contextBoundValue transparently
 		actualCollection, collBindings := extractBoundItem(collection)
 		objCtx := evalCtx.NewChildContext(actualCollection)
+		objCtx.position = 1
 		if len(collBindings) > 0 {
 			applyBindingsToCtx(objCtx, collBindings)
 		}
@@ -151,12 +165,13 @@ func (e *Evaluator) evalFilter(ctx context.Context, node *types.ASTNode, evalCtx
 
 	// Otherwise treat as array filter predicate
 	result := make([]interface{}, 0, len(arr))
-	for _, item := range arr {
+	for i, item := range arr {
 		// Extract value and bindings from contextBoundValue if present
 		actualItem, inheritedBindings := extractBoundItem(item)
 
 		// Create context with item as data
 		itemCtx := evalCtx.NewChildContext(actualItem)
+		itemCtx.position = i + 1 // $index()
 		if len(inheritedBindings) > 0 {
 			applyBindingsToCtx(itemCtx, inheritedBindings)
 		}
@@ -164,6 +179,7 @@ func (e *Evaluator) evalFilter(ctx context.Context, node *types.ASTNode, evalCtx
 		// Evaluate filter expression
 		match, err := e.evalNode(ctx, node.RHS, itemCtx)
 		if err != nil {
+			evalCtx.recordPartial(node, result, err)
 			return nil, err
 		}
 
@@ -0,0 +1,225 @@
+package evaluator
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Automatic filter indexes (WithAutoIndex).
+//
+// A predicate `array[field = value]` whose value side does not depend on the
+// current item is a pure hash lookup. When the same array is filtered on the same
+// field more than once during an evaluation (typically inside $map or a nested
+// path), the first repeat builds a map from field value to item positions and every
+// later lookup is O(1) instead of a full scan.
+//
+// Indexes are stored on the root EvalContext, so they never outlive a single
+// evaluation and need no locking: one evaluation runs on one goroutine. The
+// arrays of Frozen values have indexes of their own, built once and shared by
+// all evaluations (see frozenFilter).
+
+const (
+	// autoIndexMinItems is the smallest array worth indexing; below it a scan is cheaper.
+	autoIndexMinItems = 8
+	// autoIndexMaxEntries bounds the number of (array, field) pairs tracked per evaluation.
+	autoIndexMaxEntries = 1024
+)
+
+// filterIndexKey identifies an array by the address of its first element and its
+// length, plus the indexed field. Holding the pointer keeps the backing array alive,
+// so the address cannot be reused by another array during the evaluation.
+type filterIndexKey struct {
+	first *interface{}
+	n     int
+	field string
+}
+
+// filterIndex is the per-(array, field) index state.
+type filterIndex struct {
+	uses      int
+	unusable  bool             // the field holds values the index cannot represent
+	missing   bool             // some object lacks the field, an error under WithStrictNavigation
+	positions map[string][]int // canonical value -> ascending item positions
+}
+
+// evalIndexedFilter tries to answer an equality filter from a hash index.
+// ok is false when the predicate, the collection or the looked-up value is not
+// indexable; the caller then falls back to the regular predicate loop.
+func (e *Evaluator) evalIndexedFilter(ctx context.Context, collection interface{}, pred *types.ASTNode, evalCtx *EvalContext) (result interface{}, ok bool, err error) {
+	arr, isArray := collection.([]interface{})
+	if !isArray || len(arr) < autoIndexMinItems {
+		return nil, false, nil
+	}
+	field, valueNode, isEq := indexableEquality(pred)
+	if !isEq {
+		return nil, false, nil
+	}
+
+	idx := evalCtx.root.filterIndex(filterIndexKey{first: &arr[0], n: len(arr), field: field})
+	if idx == nil || idx.unusable || idx.missing && e.opts.StrictNavigation {
+		return nil, false, nil
+	}
+	idx.uses++
+	if idx.uses < 2 {
+		// A single filter is answered faster by the plain scan.
+		return nil, false, nil
+	}
+	if idx.positions == nil {
+		idx.build(arr, field)
+		if idx.unusable || idx.missing && e.opts.StrictNavigation {
+			return nil, false, nil
+		}
+	}
+	return e.lookupFilterIndex(ctx, idx, arr, valueNode, evalCtx)
+}
+
+// lookupFilterIndex returns the items of arr whose field equals the value of
+// valueNode, from idx, the index of arr on the field.
+func (e *Evaluator) lookupFilterIndex(ctx context.Context, idx *filterIndex, arr []interface{}, valueNode *types.ASTNode, evalCtx *EvalContext) (result interface{}, ok bool, err error) {
+	value, err := e.evalNode(ctx, valueNode, evalCtx)
+	if err != nil {
+		return nil, false, err
+	}
+	if value, err = unwrapCVsDeep(value); err != nil {
+		return nil, false, err
+	}
+	key, keyOK := filterIndexValueKey(value)
+	if !keyOK {
+		return nil, false, nil
+	}
+
+	positions := idx.positions[key]
+	if len(positions) == 0 {
+		return nil, true, nil
+	}
+	matches := make([]interface{}, len(positions))
+	for i, p := range positions {
+		matches[i] = arr[p]
+	}
+	return matches, true, nil
+}
+
+// filterIndex returns the index entry for key, creating it on first use.
+// It returns nil once the per-evaluation entry budget is exhausted.
+func (c *EvalContext) filterIndex(key filterIndexKey) *filterIndex {
+	if c.filterIndexes == nil {
+		c.filterIndexes = make(map[filterIndexKey]*filterIndex)
+	}
+	idx := c.filterIndexes[key]
+	if idx == nil {
+		if len(c.filterIndexes) >= autoIndexMaxEntries {
+			return nil
+		}
+		idx = &filterIndex{}
+		c.filterIndexes[key] = idx
+	}
+	return idx
+}
+
+// build indexes field over arr. Items without the field are skipped (they never
+// compare equal to a defined value); context-bound items, nested arrays and
+// booleans (which compare equal to 0/1) mark the index unusable.
+func (idx *filterIndex) build(arr []interface{}, field string) {
+	positions := make(map[string][]int, len(arr))
+	for i, item := range arr {
+		var v interface{}
+		var exists bool
+		switch obj := item.(type) {
+		case map[string]interface{}:
+			v, exists = obj[field]
+			idx.missing = idx.missing || !exists
+		case *OrderedObject:
+			v, exists = obj.Values[field]
+			idx.missing = idx.missing || !exists
+		case []interface{}, *contextBoundValue:
+			idx.unusable = true
+			return
+		}
+		if !exists {
+			continue
+		}
+		if v == nil {
+			v = types.NullValue
+		}
+		switch v.(type) {
+		case bool:
+			idx.unusable = true
+			return
+		case []interface{}, map[string]interface{}, *OrderedObject:
+			// Never equal to a scalar lookup value.
+			continue
+		}
+		key, ok := filterIndexValueKey(v)
+		if !ok {
+			idx.unusable = true
+			return
+		}
+		positions[key] = append(positions[key], i)
+	}
+	idx.positions = positions
+}
+
+// filterIndexValueKey returns the index key of a scalar with `=` semantics:
+// numbers of any Go kind share a key, and -0 equals 0. Undefined, booleans and
+// structured values are not indexable.
+func filterIndexValueKey(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return "s" + val, true
+	case types.Null:
+		return "n", true
+	case float64:
+		return numberIndexKey(val)
+	case int:
+		return numberIndexKey(float64(val))
+	case int64:
+		return numberIndexKey(float64(val))
+	case int32:
+		return numberIndexKey(float64(val))
+	}
+	return "", false
+}
+
+func numberIndexKey(f float64) (string, bool) {
+	if f != f { // NaN never equals anything
+		return "", false
+	}
+	if f == 0 {
+		f = 0 // fold -0 into 0
+	}
+	return "f" + strconv.FormatFloat(f, 'g', -1, 64), true
+}
+
+// indexableEquality matches `field = value` or `value = field`, where field is a
+// plain name and value cannot depend on the current item.
+func indexableEquality(pred *types.ASTNode) (field string, value *types.ASTNode, ok bool) {
+	if pred.Type != types.NodeBinary || pred.StrValue != "=" || pred.LHS == nil || pred.RHS == nil {
+		return "", nil, false
+	}
+	if pred.LHS.Type == types.NodeName && isItemIndependent(pred.RHS) {
+		return pred.LHS.StrValue, pred.RHS, true
+	}
+	if pred.RHS.Type == types.NodeName && isItemIndependent(pred.LHS) {
+		return pred.RHS.StrValue, pred.LHS, true
+	}
+	return "", nil, false
+}
+
+// isItemIndependent reports whether node evaluates to the same value for every
+// item of a filter: a literal, a named variable ($x, not $ or $$) or a plain
+// field path starting from one (`$o.cust.id`).
+func isItemIndependent(node *types.ASTNode) bool {
+	switch node.Type {
+	case types.NodeString, types.NodeNumber:
+		return true
+	case types.NodeVariable:
+		return node.StrValue != "" && node.StrValue != "$"
+	case types.NodePath:
+		return node.LHS != nil && node.RHS != nil &&
+			node.RHS.Type == types.NodeName && isItemIndependent(node.LHS) &&
+			node.LHS.Type != types.NodeString && node.LHS.Type != types.NodeNumber
+	}
+	return false
+}
@@ -26,15 +26,24 @@ func (e *Evaluator) evalFunction(ctx context.Context, node *types.ASTNode, evalC
 			// Evaluate arguments (never in tail position; tcoTail is already false)
 			args := make([]interface{}, 0, len(node.Arguments))
 			for _, argNode := range node.Arguments {
-				arg, err := e.evalNode(ctx, argNode, evalCtx)
+				arg, err := e.evalArgument(ctx, argNode, evalCtx)
 				if err != nil {
 					return nil, err
 				}
 				// Unwrap contextBoundValues before passing to lambdas
-				arg = unwrapCVsDeep(arg)
+				arg, err = unwrapCVsDeep(arg)
+				if err != nil {
+					return nil, err
+				}
 				args = append(args, arg)
 			}
 
+			// Give the context value to a parameter marked "-" left without argument
+			args, err = fn.contextArgs(node.LHS.StrValue, args, evalCtx.Data())
+			if err != nil {
+				return nil, err
+			}
+
 			// Restore TCO flag before checking tail position.
 			evalCtx.tcoTail = prevTCO
 
@@ -57,19 +66,23 @@ func (e *Evaluator) evalFunction(ctx context.Context, node *types.ASTNode, evalC
 			// Evaluate arguments (evalCtx.tcoTail is already false here)
 			args := make([]interface{}, 0, len(node.Arguments))
 			for _, argNode := range node.Arguments {
-				arg, err := e.evalNode(ctx, argNode, evalCtx)
+				arg, err := e.evalArgument(ctx, argNode, evalCtx)
 				if err != nil {
 					return nil, err
 				}
 				// Unwrap contextBoundValues before passing to built-in functions
-				arg = unwrapCVsDeep(arg)
+				if !fn.boundArgs {
+					if arg, err = unwrapCVsDeep(arg); err != nil {
+						return nil, err
+					}
+				}
 				args = append(args, arg)
 			}
 
-			// If function accepts context and we have fewer args than required, prepend context
-			if fn.AcceptsContext && len(args) < fn.MinArgs {
-				contextData := evalCtx.Data()
-				args = append([]interface{}{contextData}, args...)
+			// Give the context value to a parameter left without argument
+			args, err = fn.contextArgs(args, evalCtx.Data())
+			if err != nil {
+				return nil, err
 			}
 
 			// Validate argument count
@@ -83,30 +96,12 @@ func (e *Evaluator) evalFunction(ctx context.Context, node *types.ASTNode, evalC
 			}
 
 			// Call function
+			if fn.Name == "done" && fn == builtinFunctions["done"] {
+				return evalDone(ctx, node, evalCtx, args)
+			}
 			return fn.Impl(ctx, e, evalCtx, args)
 
 		default:
-			// callableValue is nil when the variable is not bound in the eval context.
-			// This happens when a custom or built-in function is called via its $name
-			// syntax (e.g. $greet(...)) but "greet" has no variable binding.
-			// Fall through to the custom/built-in lookup using the variable name.
-			if callableValue == nil && node.LHS != nil && node.LHS.Type == types.NodeVariable {
-				varName := node.LHS.StrValue
-				if varName != "" {
-					if fnDef, found := e.getCustomFunction(varName); found {
-						args := make([]interface{}, 0, len(node.Arguments))
-						for _, argNode := range node.Arguments {
-							arg, err := e.evalNode(ctx, argNode, evalCtx)
-							if err != nil {
-								return nil, err
-							}
-							arg = unwrapCVsDeep(arg)
-							args = append(args, arg)
-						}
-						return fnDef.Impl(ctx, e, evalCtx, args)
-					}
-				}
-			}
 			return nil, fmt.Errorf("expected lambda or function, got %T", callableValue)
 		}
 	}
@@ -114,11 +109,7 @@ func (e *Evaluator) evalFunction(ctx context.Context, node *types.ASTNode, evalC
 	// Built-in / custom function call
 	funcName := node.StrValue
 
-	// Check custom (user-registered) functions first, then built-ins.
-	fnDef, ok := e.getCustomFunction(funcName)
-	if !ok {
-		fnDef, ok = GetFunction(funcName)
-	}
+	fnDef, ok := e.LookupFunction(funcName)
 	if !ok {
 		return nil, fmt.Errorf("unknown function: %s", funcName)
 	}
@@ -126,19 +117,24 @@ func (e *Evaluator) evalFunction(ctx context.Context, node *types.ASTNode, evalC
 	// Evaluate arguments
 	args := make([]interface{}, 0, len(node.Arguments))
 	for _, argNode := range node.Arguments {
-		arg, err := e.evalNode(ctx, argNode, evalCtx)
+		arg, err := e.evalArgument(ctx, argNode, evalCtx)
 		if err != nil {
 			return nil, err
 		}
-		// Unwrap contextBoundValues: built-in functions must not see internal CV wrappers
-		arg = unwrapCVsDeep(arg)
+		// Unwrap contextBoundValues: built-in functions must not see internal CV
+		// wrappers, except the aggregates, which read them in place
+		if !fnDef.boundArgs {
+			if arg, err = unwrapCVsDeep(arg); err != nil {
+				return nil, err
+			}
+		}
 		args = append(args, arg)
 	}
 
-	// If function accepts context and we have fewer args than required, prepend context
-	if fnDef.AcceptsContext && len(args) < fnDef.MinArgs {
-		contextData := evalCtx.Data()
-		args = append([]interface{}{contextData}, args...)
+	// Give the context value to a parameter left without argument
+	args, err := fnDef.contextArgs(args, evalCtx.Data())
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate argument count
@@ -157,7 +153,8 @@ func (e *Evaluator) evalFunction(ctx context.Context, node *types.ASTNode, evalC
 
 // evalFunctionWithContextInjection evaluates a lambda call with optional context injection.
 // This is used when a lambda is called in a path context (e.g., Age.function($x,$y){...}(arg))
-// The contextValue is prepended to the arguments ONLY if the lambda needs more arguments.
+// The contextValue is prepended to the arguments ONLY if the lambda needs more arguments
+// (see Lambda.argsWithContext).
 
 func (e *Evaluator) evalFunctionWithContextInjection(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext, contextValue interface{}) (interface{}, error) {
 	// node.LHS should be a lambda
@@ -186,21 +183,9 @@ func (e *Evaluator) evalFunctionWithContextInjection(ctx context.Context, node *
 		explicitArgs = append(explicitArgs, arg)
 	}
 
-	// Determine if we need to inject context
-	// Inject context value as first argument ONLY if we have fewer args than params
-	var args []interface{}
-	if len(explicitArgs) < len(lambda.Params) {
-		// Need context injection
-		args = make([]interface{}, 0, len(explicitArgs)+1)
-		args = append(args, contextValue)
-		args = append(args, explicitArgs...)
-	} else {
-		// Already have enough args, use them as-is
-		args = explicitArgs
-	}
-
-	// Call lambda with (possibly injected) context
-	return e.callLambda(ctx, lambda, args)
+	// Inject the context value as first argument only when the explicit arguments
+	// do not satisfy the lambda's required arity (signature-aware).
+	return e.callLambda(ctx, lambda, lambda.argsWithContext(contextValue, explicitArgs))
 }
 
 // evalLambda creates a lambda function value.
@@ -216,9 +201,10 @@ func (e *Evaluator) evalLambda(node *types.ASTNode, evalCtx *EvalContext) (inter
 		}
 	}
 
-	// Parse signature if present
-	var sig *Signature
-	if node.Signature != "" {
+	// The parser attaches the parsed signature; parse it here only for
+	// nodes built without the parser.
+	sig := node.ParsedSignature
+	if sig == nil && node.Signature != "" {
 		parsedSig, err := ParseSignature(node.Signature)
 		if err != nil {
 			// Return S0401 error for invalid signature
@@ -230,18 +216,60 @@ func (e *Evaluator) evalLambda(node *types.ASTNode, evalCtx *EvalContext) (inter
 	// Create new context with lambda's closure context as parent.
 	// We store evalCtx directly (not cloned) so that the lambda can see
 	// bindings added AFTER lambda creation in the same block scope (enables recursion).
-	// callLambda() creates its own clone of this context at call time.
+	// callLambda() creates a new frame below this context at call time.
 	// OPT-02: mark the context as escaped before capturing it in the lambda closure.
 	// This walks up the parent chain so that the entire ancestor chain is protected
 	// from being returned to the evalCtxPool while the lambda is alive.
 	evalCtx.markEscaped()
+	evalCtx.root.closures = true
 	lambda := &Lambda{
 		Params:    params,
 		Body:      node.RHS, // Body is in RHS
 		Ctx:       evalCtx,
 		Signature: sig,
+		Closure:   node.Closure,
+	}
+	return lambda, nil
+}
+
+// evalArgument evaluates an argument of a function call.
+func (e *Evaluator) evalArgument(ctx context.Context, argNode *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
+	if argNode.Type == types.NodeLambda && evalCtx.root.profile == nil {
+		return e.evalLambdaArgument(argNode, evalCtx)
 	}
+	return e.evalNode(ctx, argNode, evalCtx)
+}
 
+// evalLambdaArgument evaluates a lambda literal passed to a function, typically
+// the callback of a higher-order function.
+//
+// A path evaluates its steps once per item, each in its own array item
+// context, so the callback of `orders.$map(items, function($i){$i.price *
+// $rate})` would otherwise become a new function value per order. When the body
+// reads neither the context value nor the parent chain, nor calls $eval, the function only
+// depends on the variables in scope: it is created in the closest enclosing
+// context that is not an array item context without bindings of its own, once,
+// and shared by the calls made for all the items.
+func (e *Evaluator) evalLambdaArgument(node *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
+	info := node.Closure
+	if info == nil || info.UsesContext || info.UsesParent || info.UsesEval || e.callsWithContext(evalCtx, info) {
+		return e.evalLambda(node, evalCtx)
+	}
+	scope := evalCtx
+	for scope.isArrayItem && scope.bindings == nil && scope.parent != nil {
+		scope = scope.parent
+	}
+	if lambda, ok := scope.lambdas[node]; ok {
+		return lambda, nil
+	}
+	lambda, err := e.evalLambda(node, scope)
+	if err != nil {
+		return nil, err
+	}
+	if scope.lambdas == nil {
+		scope.lambdas = make(map[*types.ASTNode]*Lambda)
+	}
+	scope.lambdas[node] = lambda.(*Lambda)
 	return lambda, nil
 }
 
@@ -271,7 +299,7 @@ func (e *Evaluator) evalPartial(ctx context.Context, node *types.ASTNode, evalCt
 		funcName := node.StrValue
 
 		// Check if function exists
-		if _, exists := GetFunction(funcName); !exists {
+		if _, exists := e.LookupFunction(funcName); !exists {
 			return nil, types.NewError("T1008", fmt.Sprintf("attempted partial application of unknown function: %s", funcName), node.Position)
 		}
 
@@ -321,11 +349,13 @@ func (e *Evaluator) evalPartial(ctx context.Context, node *types.ASTNode, evalCt
 		}
 	}
 
-	// Create lambda
+	// Create lambda. Like evalLambda, it closes over the live context.
+	evalCtx.markEscaped()
+	evalCtx.root.closures = true
 	lambda := &Lambda{
 		Params: params,
 		Body:   bodyNode,
-		Ctx:    evalCtx.Clone(),
+		Ctx:    evalCtx,
 	}
 
 	return lambda, nil
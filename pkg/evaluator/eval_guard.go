@@ -0,0 +1,123 @@
+package evaluator
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Recursive walks over caller-supplied data (deepClone, unwrapCVsDeep,
+// outputValue, $string preprocessing) must terminate even when the data was
+// hand-built in Go and references itself, e.g. m["self"] = m. JSON-decoded input
+// can never contain cycles, so detection is deferred: containers are only tracked
+// once a walk is deeper than cycleCheckDepth levels, which keeps ordinary documents
+// on the allocation-free path. A cycle always exceeds any finite depth, so it is
+// still reported, as D1010.
+//
+// The deep copies made for transforms (deepClone), bindings and Freeze
+// additionally count the values they visit and stop with D1011 past
+// maxCloneNodes, which bounds the work done on shared (DAG-shaped) structures
+// that expand to a huge tree. Results and $string are not bounded: they only
+// detect cycles.
+
+const (
+	// cycleCheckDepth is the nesting depth from which containers are tracked.
+	cycleCheckDepth = 64
+	// maxCloneNodes bounds the number of values a single deep copy may produce.
+	maxCloneNodes = 1 << 24
+)
+
+// containerID identifies a container by address; kind separates maps, slices
+// (whose length is part of the identity) and pointer types sharing an address.
+type containerID struct {
+	ptr  uintptr
+	kind int
+}
+
+// dataGuard tracks one recursive walk. The zero value is ready to use and
+// performs no node counting; set maxNodes to bound the walk.
+type dataGuard struct {
+	depth    int
+	nodes    int
+	maxNodes int
+	path     map[containerID]struct{} // containers on the current path below cycleCheckDepth
+}
+
+// enter records the descent into container v.
+// It must be paired with leave when it returns nil.
+func (g *dataGuard) enter(v interface{}) error {
+	if g.maxNodes > 0 {
+		g.nodes++
+		if g.nodes > g.maxNodes {
+			return types.NewError(types.ErrDataTooLarge,
+				fmt.Sprintf("data exceeds the limit of %d values", g.maxNodes), -1)
+		}
+	}
+	g.depth++
+	if g.depth <= cycleCheckDepth {
+		return nil
+	}
+	id, ok := identify(v)
+	if !ok {
+		return nil
+	}
+	if g.path == nil {
+		g.path = make(map[containerID]struct{})
+	}
+	if _, seen := g.path[id]; seen {
+		g.depth--
+		return types.NewError(types.ErrDataCycle, "data contains a reference cycle", -1)
+	}
+	g.path[id] = struct{}{}
+	return nil
+}
+
+// leave records the return from container v.
+func (g *dataGuard) leave(v interface{}) {
+	if g.depth > cycleCheckDepth {
+		if id, ok := identify(v); ok {
+			delete(g.path, id)
+		}
+	}
+	g.depth--
+}
+
+// count records a scalar value produced by a bounded walk.
+func (g *dataGuard) count() error {
+	if g.maxNodes > 0 {
+		g.nodes++
+		if g.nodes > g.maxNodes {
+			return types.NewError(types.ErrDataTooLarge,
+				fmt.Sprintf("data exceeds the limit of %d values", g.maxNodes), -1)
+		}
+	}
+	return nil
+}
+
+func identify(v interface{}) (containerID, bool) {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		return containerID{ptr: reflect.ValueOf(c).Pointer(), kind: -1}, true
+	case []interface{}:
+		if len(c) == 0 {
+			return containerID{}, false // an empty slice cannot close a cycle
+		}
+		return containerID{ptr: reflect.ValueOf(c).Pointer(), kind: len(c)}, true
+	case *OrderedObject:
+		return containerID{ptr: reflect.ValueOf(c).Pointer(), kind: -2}, true
+	case *contextBoundValue:
+		return containerID{ptr: reflect.ValueOf(c).Pointer(), kind: -3}, true
+	}
+	return containerID{}, false
+}
+
+// checkStringLength fails with D1012 when op is about to build a string of
+// size bytes, more than MaxStringLength (see WithMaxStringLength).
+func (e *Evaluator) checkStringLength(size int, op string) error {
+	if limit := e.opts.MaxStringLength; limit > 0 && size > limit {
+		return types.NewError(types.ErrStringTooLarge,
+			fmt.Sprintf("the string built by %s would exceed the limit of %d bytes", op, limit), -1)
+	}
+	return nil
+}
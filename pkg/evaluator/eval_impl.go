@@ -11,6 +11,21 @@ func (e *Evaluator) evalNode(ctx context.Context, node *types.ASTNode, evalCtx *
 	if node == nil {
 		return nil, nil
 	}
+	if evalCtx != nil && evalCtx.root.profile != nil {
+		return e.evalNodeProfiled(ctx, node, evalCtx)
+	}
+	if evalCtx != nil && e.opts.DebugHook != nil {
+		return e.evalNodeDebug(ctx, node, evalCtx)
+	}
+	return e.dispatchNode(ctx, node, evalCtx)
+}
+
+// dispatchNode evaluates a non-nil node.
+func (e *Evaluator) dispatchNode(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext) (result interface{}, err error) {
+	// Count the node for Evaluator.Stats.
+	if evalCtx != nil && evalCtx.root.nodes != nil {
+		evalCtx.root.nodes.Add(1)
+	}
 
 	// OPT-09: leaf nodes (literals, lambda, regex) cannot recurse infinitely.
 	// Skip the cancellation check and depth tracking on the hot path.
@@ -37,17 +52,10 @@ func (e *Evaluator) evalNode(ctx context.Context, node *types.ASTNode, evalCtx *
 		return nil, ctx.Err()
 	default:
 	}
-
-	// Track and check evaluation depth (stack-style, matching JSONata JS semantics).
-	// Depth is the current nesting level of evalNode calls; it is incremented on entry
-	// and decremented on exit so that only the maximum live stack depth is counted.
-	if p := getRecurseDepthPtr(ctx); p != nil {
-		*p++
-		if *p > e.opts.MaxDepth {
-			*p--
-			return nil, types.NewError(types.ErrUndefinedVariable, "maximum recursion depth exceeded", -1)
+	if pollDeadline != nil {
+		if err := pollDeadline(ctx); err != nil {
+			return nil, err
 		}
-		defer func() { *p-- }()
 	}
 
 	// Debug logging
@@ -58,6 +66,31 @@ func (e *Evaluator) evalNode(ctx context.Context, node *types.ASTNode, evalCtx *
 			"depth", evalCtx.Depth())
 	}
 
+	d := getEvalDepth(ctx)
+	if d == nil {
+		return e.evalBranch(ctx, node, evalCtx)
+	}
+	// Track and check the nesting depth of the evaluation (see evalDepth).
+	// Nesting grows with the expression and with the data traversed, so its
+	// bound is much higher than the one on function recursion.
+	if e.opts.MaxNestingDepth > 0 && d.nodes >= e.opts.MaxNestingDepth {
+		return nil, types.NewError(types.ErrUndefinedVariable,
+			fmt.Sprintf("maximum nesting depth exceeded (%d)", e.opts.MaxNestingDepth), -1)
+	}
+	// The counters are restored without a defer: after a panic the evaluation
+	// is abandoned, and pos is left at the node that panicked. Leaf nodes are
+	// located at their parent.
+	d.nodes++
+	pos := d.pos
+	d.pos = node.Position
+	result, err = e.evalBranch(ctx, node, evalCtx)
+	d.nodes--
+	d.pos = pos
+	return result, err
+}
+
+// evalBranch evaluates a node that is not a leaf.
+func (e *Evaluator) evalBranch(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
 	// Dispatch based on node type
 	switch node.Type {
 	case types.NodePath:
@@ -97,6 +130,8 @@ func (e *Evaluator) evalNode(ctx context.Context, node *types.ASTNode, evalCtx *
 		return e.evalContextBind(ctx, node, evalCtx)
 	case types.NodeIndex:
 		return e.evalIndexBind(ctx, node, evalCtx)
+	case types.NodeImport:
+		return e.evalImport(ctx, node, evalCtx)
 	default:
 		return nil, fmt.Errorf("unsupported node type: %s", node.Type)
 	}
@@ -0,0 +1,30 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// evalImport evaluates a $import call resolved by the parser (see
+// parser.WithModuleResolver). The module is evaluated once per evaluation, on
+// its own: it sees none of the importing expression's variables and has no
+// context value ($$ is still the input). Every import of the module returns
+// the same value.
+func (e *Evaluator) evalImport(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
+	root := evalCtx.root
+	if value, ok := root.modules[node.LHS]; ok {
+		return value, nil
+	}
+	moduleCtx := &EvalContext{root: root}
+	value, err := e.evalNode(ctx, node.LHS, moduleCtx)
+	if err != nil {
+		return nil, fmt.Errorf("module %q: %w", node.StrValue, err)
+	}
+	if root.modules == nil {
+		root.modules = make(map[*types.ASTNode]interface{})
+	}
+	root.modules[node.LHS] = value
+	return value, nil
+}
@@ -0,0 +1,83 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// ResultInfo describes the result of EvalWithInfo.
+type ResultInfo struct {
+	// Truncated reports whether the result array was cut to its first
+	// MaxResultItems items (see WithMaxResultItems).
+	Truncated bool
+	// Items is the number of items of an array result before truncation, and
+	// 0 for any other result.
+	Items int
+	// Partial reports whether the evaluation ran out of time and the result
+	// holds the items computed until then (see WithPartialResults).
+	Partial bool
+}
+
+// EvalWithInfo evaluates expr against data like Eval, and also returns what
+// the caller needs to present the result, such as whether it was truncated.
+func (e *Evaluator) EvalWithInfo(ctx context.Context, expr *types.Expression, data interface{}) (interface{}, ResultInfo, error) {
+	if expr == nil || expr.AST() == nil {
+		return nil, ResultInfo{}, fmt.Errorf("invalid expression")
+	}
+
+	if e.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.opts.Timeout)
+		defer cancel()
+	}
+
+	evalCtx := NewContext(data)
+	if e.opts.PartialResults {
+		evalCtx.partial = &partialResult{node: expr.AST()}
+	}
+	result, err := e.evalRoot(ctx, expr, evalCtx)
+	if err != nil {
+		return nil, ResultInfo{}, err
+	}
+	info := ResultInfo{Items: evalCtx.resultItems}
+	info.Truncated = e.opts.MaxResultItems > 0 && info.Items > e.opts.MaxResultItems
+	info.Partial = evalCtx.partial != nil && evalCtx.partial.items != nil
+	return result, info, nil
+}
+
+// partialResult is the result of the top-level step of an evaluation cut
+// short by its deadline (see WithPartialResults).
+type partialResult struct {
+	// node is the root of the expression, the step whose items are kept.
+	node *types.ASTNode
+	// items are the items computed before the deadline; nil until then.
+	items []interface{}
+}
+
+// recordPartial keeps items, the results of node so far, as the partial
+// result of the evaluation when node is the root of the expression and err
+// reports that the deadline has passed.
+func (c *EvalContext) recordPartial(node *types.ASTNode, items []interface{}, err error) {
+	p := c.root.partial
+	if p == nil || p.node != node || !errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	p.items = append(make([]interface{}, 0, len(items)), items...)
+}
+
+// truncateResult records the length of an array result on the root of
+// evalCtx and cuts it to MaxResultItems.
+func (e *Evaluator) truncateResult(result interface{}, evalCtx *EvalContext) interface{} {
+	arr, ok := result.([]interface{})
+	if !ok {
+		return result
+	}
+	evalCtx.root.resultItems = len(arr)
+	if n := e.opts.MaxResultItems; n > 0 && len(arr) > n {
+		return arr[:n:n]
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package evaluator
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// String interning (WithStringInterning).
+//
+// Documents with millions of objects repeat the same keys and many of the same
+// short values. With interning enabled the evaluator keeps one copy of each such
+// string for its whole lifetime and:
+//   - rewrites documents decoded by EvalStream to use the shared copies, and
+//   - makes objects built by the same object constructor share a single,
+//     read-only Keys slice whose entries are interned as well.
+//
+// THREAD-SAFETY AUDIT: safe.
+//   - stringInterner is shared by all evaluations of an Evaluator and guarded by
+//     an RWMutex; lookups of already interned strings only take the read lock.
+//   - Shared Keys slices are created with cap == len, so appending to one (e.g. a
+//     transform adding a key) always reallocates instead of writing into the
+//     backing array seen by other objects. Code that edits Keys in place only
+//     does so on deep clones.
+
+const (
+	// maxInternedStrings bounds the interner; once full, new strings pass through.
+	maxInternedStrings = 1 << 16
+	// maxInternedLen is the longest string worth interning. Longer values rarely
+	// repeat and would pin a lot of memory.
+	maxInternedLen = 64
+	// maxKeyVariants is the number of distinct key lists remembered per object
+	// constructor (keys whose value is undefined are omitted, so one constructor
+	// can produce several lists).
+	maxKeyVariants = 4
+)
+
+// stringInterner deduplicates strings across evaluations.
+type stringInterner struct {
+	mu      sync.RWMutex
+	strings map[string]string
+}
+
+func newStringInterner() *stringInterner {
+	return &stringInterner{strings: make(map[string]string)}
+}
+
+// intern returns the shared copy of s, registering s if there is room.
+func (in *stringInterner) intern(s string) string {
+	if len(s) > maxInternedLen {
+		return s
+	}
+	in.mu.RLock()
+	shared, ok := in.strings[s]
+	in.mu.RUnlock()
+	if ok {
+		return shared
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if shared, ok := in.strings[s]; ok {
+		return shared
+	}
+	if len(in.strings) >= maxInternedStrings {
+		return s
+	}
+	// Clone so the table never pins a larger buffer s may be a slice of.
+	shared = strings.Clone(s)
+	in.strings[shared] = shared
+	return shared
+}
+
+// internValue replaces, in place, the object keys and string values of a decoded
+// JSON document with their interned copies and returns the document.
+func (in *stringInterner) internValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return in.intern(val)
+	case map[string]interface{}:
+		for k, item := range val {
+			// Assigning through an equal key replaces the stored key string.
+			val[in.intern(k)] = in.internValue(item)
+		}
+		return val
+	case *OrderedObject:
+		for i, k := range val.Keys {
+			val.Keys[i] = in.intern(k)
+			val.Values[val.Keys[i]] = in.internValue(val.Values[k])
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = in.internValue(item)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// shareObjectKeys makes obj, built by the object constructor node, use a Keys
+// slice shared with the other objects that constructor produced during the
+// evaluation. It is a no-op unless string interning is enabled.
+func (e *Evaluator) shareObjectKeys(evalCtx *EvalContext, node *types.ASTNode, obj *OrderedObject) {
+	if e.interner == nil || len(obj.Keys) == 0 {
+		return
+	}
+	root := evalCtx.root
+	variants := root.objectKeys[node]
+	shared := -1
+	for i, keys := range variants {
+		if slices.Equal(keys, obj.Keys) {
+			shared = i
+			break
+		}
+	}
+
+	var keys []string
+	if shared >= 0 {
+		keys = variants[shared]
+	} else {
+		keys = make([]string, len(obj.Keys))
+		for i, k := range obj.Keys {
+			keys[i] = e.interner.intern(k)
+		}
+		if len(variants) < maxKeyVariants {
+			if root.objectKeys == nil {
+				root.objectKeys = make(map[*types.ASTNode][][]string)
+			}
+			root.objectKeys[node] = append(variants, keys)
+		}
+	}
+
+	if !hasLiteralKeys(node) {
+		// Computed keys are fresh strings: re-key the map with the shared copies.
+		for _, k := range keys {
+			v := obj.Values[k]
+			obj.Values[k] = v
+		}
+	}
+	obj.Keys = keys
+}
+
+// hasLiteralKeys reports whether every key of the object constructor is a string
+// literal, whose value is already shared through the AST.
+func hasLiteralKeys(node *types.ASTNode) bool {
+	for _, pair := range node.Expressions {
+		if pair.Type != types.NodeBinary || pair.LHS == nil || pair.LHS.Type != types.NodeString {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,264 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Iterator yields the items of an evaluation result one at a time. A non-nil
+// error is yielded at most once, as the last pair.
+type Iterator = iter.Seq2[interface{}, error]
+
+// EvalIter evaluates expr against data and returns its result as a sequence of
+// items: each element of an array result, the value itself for any other
+// result, and nothing when the result is undefined.
+//
+// Filter expressions over a sequence, `seq[pred]` and `path.Field[pred]`, are
+// evaluated lazily when the predicate is a comparison or a boolean combination
+// of comparisons: the predicate only runs for the items actually consumed, so a
+// caller that stops after the first N matches does not pay for a full scan.
+// The same holds when the matches are mapped to objects, `seq[pred].{...}`:
+// only the objects consumed are constructed. Every other expression is
+// evaluated in full when the iteration starts. A filter over a Go iterator,
+// `$[pred]` on an iter.Seq input or `field[pred]` on a field holding one,
+// pulls its items only as they are consumed.
+//
+// With WithMaxResultItems, the iteration stops after that many items.
+//
+// Evaluation happens while the iterator is ranged over; ranging over it again
+// evaluates the expression again. The returned error only reports an invalid
+// expression; evaluation errors are yielded by the iterator.
+func (e *Evaluator) EvalIter(ctx context.Context, expr *types.Expression, data interface{}) (Iterator, error) {
+	if expr == nil || expr.AST() == nil {
+		return nil, fmt.Errorf("invalid expression")
+	}
+
+	return func(yield func(interface{}, error) bool) {
+		if e.opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, e.opts.Timeout)
+			defer cancel()
+		}
+
+		if limit := e.opts.MaxResultItems; limit > 0 {
+			next, yielded := yield, 0
+			yield = func(item interface{}, err error) bool {
+				if err != nil {
+					return next(item, err)
+				}
+				yielded++
+				return next(item, nil) && yielded < limit
+			}
+		}
+
+		if prefix, filter, project, ok := lazyFilterShape(expr.AST()); ok {
+			var evalCtx *EvalContext
+			if streamsInput(data, expr.AST(), prefix, filter) {
+				// Left for iterFilterItems to pull (see lazySeq).
+				evalCtx = NewContext(nil)
+				evalCtx.data = data
+			} else {
+				evalCtx = NewContext(data)
+			}
+			var yielded error // the error yielded by iterFilter
+			err := e.evaluate(ctx, expr, evalCtx, func(ctx context.Context) error {
+				e.iterFilter(ctx, prefix, filter, project, evalCtx, func(item interface{}, err error) bool {
+					yielded = err
+					return callerCode(ctx, func() bool { return yield(item, err) })
+				})
+				return yielded
+			})
+			if err != nil && err != yielded {
+				yield(nil, err)
+			}
+			return
+		}
+
+		result, err := e.evalResult(ctx, expr, NewContext(data))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for _, item := range sequenceItems(result) {
+			if item, err = e.transformResult(item); err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+// lazyFilterShape recognises the expressions EvalIter streams: `seq[pred]`
+// (prefix is nil) and `prefix.seq[pred]`, where pred always evaluates to a
+// boolean, optionally followed by an object constructor step `.{...}` applied
+// to each match (project). Expressions using @, # or % are excluded: their
+// items carry bindings and parent links that only the full path evaluation
+// handles.
+func lazyFilterShape(node *types.ASTNode) (prefix, filter, project *types.ASTNode, ok bool) {
+	if node.Type == types.NodePath && node.RHS != nil && node.RHS.Type == types.NodeObject && node.RHS.LHS == nil && node.LHS != nil {
+		if hasItemBindings(node.RHS) {
+			return nil, nil, nil, false
+		}
+		prefix, filter, _, ok = lazyFilterShape(node.LHS)
+		if !ok {
+			return nil, nil, nil, false
+		}
+		return prefix, filter, node.RHS, true
+	}
+	filter = node
+	if node.Type == types.NodePath && node.LHS != nil && node.LHS.Type != types.NodeString {
+		prefix, filter = node.LHS, node.RHS
+	}
+	if filter == nil || filter.Type != types.NodeFilter || filter.LHS == nil || !isBooleanPredicate(filter.RHS) {
+		return nil, nil, nil, false
+	}
+	if hasItemBindings(node) {
+		return nil, nil, nil, false
+	}
+	return prefix, filter, nil, true
+}
+
+// isBooleanPredicate reports whether a filter predicate can only produce a
+// boolean, so that it is never interpreted as an array index.
+func isBooleanPredicate(node *types.ASTNode) bool {
+	if node == nil || node.Type != types.NodeBinary {
+		return false
+	}
+	switch node.StrValue {
+	case "=", "!=", "<", "<=", ">", ">=", "in":
+		return true
+	case "and", "or":
+		return isBooleanPredicate(node.LHS) && isBooleanPredicate(node.RHS)
+	}
+	return false
+}
+
+// hasItemBindings reports whether node contains a @, # or % operator.
+func hasItemBindings(node *types.ASTNode) bool {
+	if node == nil {
+		return false
+	}
+	switch node.Type {
+	case types.NodeContext, types.NodeIndex, types.NodeParent:
+		return true
+	}
+	if hasItemBindings(node.LHS) || hasItemBindings(node.RHS) {
+		return true
+	}
+	for _, list := range [][]*types.ASTNode{node.Steps, node.Arguments, node.Expressions} {
+		for _, n := range list {
+			if hasItemBindings(n) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// iterFilter yields the matches of filter, applied to each item of prefix (or to
+// the context value when prefix is nil), mapped by project when it is not nil.
+// It returns false once the consumer has stopped the iteration or an error was
+// yielded.
+func (e *Evaluator) iterFilter(ctx context.Context, prefix, filter, project *types.ASTNode, evalCtx *EvalContext, yield func(interface{}, error) bool) bool {
+	if prefix == nil {
+		return e.iterFilterItems(ctx, filter, project, evalCtx, yield)
+	}
+
+	left, err := e.evalNode(ctx, prefix, evalCtx)
+	if err != nil {
+		yield(nil, err)
+		return false
+	}
+	for _, item := range sequenceItems(left) {
+		// Without @ and # the only context-bound values are the parent links of
+		// flattened items, which carry no bindings.
+		item, _ = extractBoundItem(item)
+		if !e.iterFilterItems(ctx, filter, project, evalCtx.NewArrayItemContext(item), yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// iterFilterItems yields the items of filter.LHS, evaluated in evalCtx, for
+// which the predicate holds, mapped by project when it is not nil.
+func (e *Evaluator) iterFilterItems(ctx context.Context, filter, project *types.ASTNode, evalCtx *EvalContext, yield func(interface{}, error) bool) bool {
+	items, err := e.filterSource(ctx, filter.LHS, evalCtx)
+	if err != nil {
+		yield(nil, err)
+		return false
+	}
+	i := -1
+	for item := range items {
+		i++
+		if err := checkCancel(ctx, i); err != nil {
+			yield(nil, err)
+			return false
+		}
+		actual, _ := extractBoundItem(item)
+		itemCtx := evalCtx.NewChildContext(actual)
+		itemCtx.position = i + 1 // $index()
+		match, err := e.evalNode(ctx, filter.RHS, itemCtx)
+		if err != nil {
+			yield(nil, err)
+			return false
+		}
+		if !e.isTruthy(match) {
+			continue
+		}
+		if project != nil {
+			projectCtx := acquireEvalCtx(actual, evalCtx, true)
+			actual, err = e.evalPathStep(ctx, project, projectCtx, actual)
+			releaseEvalCtx(projectCtx)
+			if err != nil {
+				yield(nil, err)
+				return false
+			}
+		}
+		value, err := e.iterResult(actual, evalCtx)
+		if err != nil {
+			yield(nil, err)
+			return false
+		}
+		if !yield(value, nil) {
+			return false
+		}
+	}
+	return true
+}
+
+// sequenceItems returns the items of a sequence value: the elements of an
+// array, the value itself, or none for undefined.
+func sequenceItems(v interface{}) []interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return val
+	default:
+		return []interface{}{val}
+	}
+}
+
+// iterResult prepares an item for the caller the way Eval prepares a result.
+func (e *Evaluator) iterResult(item interface{}, evalCtx *EvalContext) (interface{}, error) {
+	item, err := unwrapCVsDeep(item)
+	if err != nil {
+		return nil, err
+	}
+	if item, err = e.outputValue(item, false); err != nil {
+		return nil, err
+	}
+	if evalCtx.root.closures {
+		if item, err = e.detachClosures(item); err != nil {
+			return nil, err
+		}
+	}
+	return e.transformResult(item)
+}
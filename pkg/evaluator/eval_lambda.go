@@ -3,9 +3,17 @@ package evaluator
 import (
 	"context"
 	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
 )
 
 func (e *Evaluator) callLambda(ctx context.Context, lambda *Lambda, args []interface{}) (interface{}, error) {
+	return e.callLambdaScoped(ctx, lambda, args, nil)
+}
+
+// callLambdaScoped calls lambda as the callback of a $reduce, whose scope
+// records the frame of the invocation (see reduceScope); nil for other calls.
+func (e *Evaluator) callLambdaScoped(ctx context.Context, lambda *Lambda, args []interface{}, scope *reduceScope) (interface{}, error) {
 	// Check for undefined arguments - if any argument is undefined (nil),
 	// the result is undefined per JSONata spec
 	for _, arg := range args {
@@ -14,51 +22,27 @@ func (e *Evaluator) callLambda(ctx context.Context, lambda *Lambda, args []inter
 		}
 	}
 
-	// Validate signature if present
-	if lambda.Signature != nil {
-		// Count required parameters (non-optional)
-		requiredCount := 0
-		for _, param := range lambda.Signature.Params {
-			if !param.Optional {
-				requiredCount++
-			}
-		}
-
-		// Check argument count (must be between required and total params)
-		if len(args) < requiredCount || len(args) > len(lambda.Signature.Params) {
-			if requiredCount == len(lambda.Signature.Params) {
-				return nil, fmt.Errorf("lambda expects %d arguments, got %d", len(lambda.Signature.Params), len(args))
-			} else {
-				return nil, fmt.Errorf("lambda expects %d-%d arguments, got %d", requiredCount, len(lambda.Signature.Params), len(args))
-			}
-		}
-
-		// Apply auto-wrapping and validate each argument
-		for i := range args {
-			param := lambda.Signature.Params[i]
-
-			// Auto-wrap: if parameter expects array but arg is not array, wrap it
-			if param.Type == TypeArray {
-				if _, isArray := args[i].([]interface{}); !isArray {
-					args[i] = []interface{}{args[i]}
-				}
-			}
+	// Validate argument count and apply signature auto-wrapping / type checks
+	if err := e.validateAndAdaptLambdaArgs(lambda, args); err != nil {
+		return nil, err
+	}
 
-			// Validate argument against parameter type
-			if err := param.ValidateArgument(args[i]); err != nil {
-				return nil, err
-			}
-		}
-	} else {
-		// No signature - validate argument count: allow fewer args (missing ones default to nil)
-		if len(args) > len(lambda.Params) {
-			return nil, fmt.Errorf("lambda expects %d arguments, got %d", len(lambda.Params), len(args))
+	// Bound the recursion of user-defined functions (see WithMaxDepth).
+	if d := getEvalDepth(ctx); d != nil && e.opts.MaxDepth > 0 {
+		d.calls++
+		if d.calls > e.opts.MaxDepth {
+			d.calls--
+			return nil, types.NewError(types.ErrUndefinedVariable,
+				"Stack overflow error: Check for non-terminating recursive function. Consider rewriting as tail-recursive.", -1)
 		}
+		defer func() { d.calls-- }()
 	}
 
-	// Create new context with lambda's closure context as parent.
-	// Clone (not CloneDeeper) - recursion depth is tracked via the shared *int pointer in context.
-	lambdaCtx := lambda.Ctx.Clone()
+	// Each call runs in a new frame whose parent is the lambda's closure.
+	lambdaCtx := lambda.Ctx.newFrame()
+	if scope != nil {
+		scope.frame = lambdaCtx
+	}
 
 	// Bind parameters
 	for i, param := range lambda.Params {
@@ -88,8 +72,12 @@ func (e *Evaluator) callLambda(ctx context.Context, lambda *Lambda, args []inter
 		// Trampoline: re-bind parameters and re-evaluate body without growing the stack.
 		lambda = thunk.lambda
 		args = thunk.args
-		lambdaCtx = lambda.Ctx.Clone()
+		lambdaCtx = lambda.Ctx.newFrame()
 		lambdaCtx.tcoTail = true // preserve TCO flag across trampoline iterations
+		if scope != nil {
+			// The value of a tail call is the one the callback returns.
+			scope.frame = lambdaCtx
+		}
 		for i, param := range lambda.Params {
 			if i < len(args) {
 				lambdaCtx.SetBinding(param, args[i])
@@ -99,64 +87,112 @@ func (e *Evaluator) callLambda(ctx context.Context, lambda *Lambda, args []inter
 	return result, nil
 }
 
-// validateLambdaArgs validates argument count for a lambda (used before creating a TCO thunk).
+// arity returns the number of arguments the lambda requires and the maximum it accepts.
+// When a signature is present it is authoritative: optional parameters (`?`) do not
+// count as required. Without a signature every declared parameter is expected, although
+// callLambda tolerates fewer arguments (missing ones stay unbound).
 
-func (e *Evaluator) validateLambdaArgs(lambda *Lambda, args []interface{}) error {
-	if lambda.Signature != nil {
-		requiredCount := 0
-		for _, param := range lambda.Signature.Params {
-			if !param.Optional {
-				requiredCount++
-			}
-		}
-		if len(args) < requiredCount || len(args) > len(lambda.Signature.Params) {
-			if requiredCount == len(lambda.Signature.Params) {
-				return fmt.Errorf("lambda expects %d arguments, got %d", len(lambda.Signature.Params), len(args))
-			}
-			return fmt.Errorf("lambda expects %d-%d arguments, got %d", requiredCount, len(lambda.Signature.Params), len(args))
-		}
-	} else {
-		if len(args) > len(lambda.Params) {
-			return fmt.Errorf("lambda expects %d arguments, got %d", len(lambda.Params), len(args))
+func (l *Lambda) arity() (required, max int) {
+	if l.Signature == nil {
+		return len(l.Params), len(l.Params)
+	}
+	for _, param := range l.Signature.Params {
+		if !param.Optional {
+			required++
 		}
 	}
-	return nil
+	return required, len(l.Signature.Params)
+}
+
+// argsWithContext returns the argument list for a call that may receive an implicit
+// context value, e.g. a lambda invoked as a path step (`Age.function($x, $y){...}(1)`).
+// The context is prepended only when the explicit arguments do not satisfy the
+// lambda's required arity, so optional signature parameters are left unbound
+// instead of silently shifting the explicit arguments.
+
+func (l *Lambda) argsWithContext(contextValue interface{}, explicit []interface{}) []interface{} {
+	if required, _ := l.arity(); len(explicit) >= required {
+		return explicit
+	}
+	args := make([]interface{}, 0, len(explicit)+1)
+	args = append(args, contextValue)
+	return append(args, explicit...)
+}
+
+// contextArgs returns the arguments of a call to l through a variable, such as
+// $f() or a.$f(), evaluated with contextValue as the context: as for built-ins
+// (see FunctionDef.contextArgs), a parameter of the signature marked "-" that
+// the arguments leave unfilled receives the context value. Lambdas without
+// such a parameter get the arguments unchanged. name is the name of the
+// variable, if any, for errors.
+func (l *Lambda) contextArgs(name string, args []interface{}, contextValue interface{}) ([]interface{}, error) {
+	if !signatureReadsContext(l.Signature) {
+		return args, nil
+	}
+	what := "function " + name
+	if name == "" {
+		what = "the lambda"
+	}
+	return signatureContextArgs(l.Signature, what, args, contextValue)
+}
+
+// trimArgs drops trailing positional arguments the lambda cannot accept.
+// HOFs such as $map/$filter always offer (value, index, array); a callback declaring
+// fewer parameters, or a signature with fewer slots, only receives the leading ones
+// (none at all for `function() {...}`).
+
+func (l *Lambda) trimArgs(args []interface{}) []interface{} {
+	_, max := l.arity()
+	if max < len(args) {
+		return args[:max]
+	}
+	return args
+}
+
+// checkLambdaArity validates the argument count of a lambda call against its arity.
+// Without a signature fewer arguments are allowed (missing ones default to undefined).
+
+func checkLambdaArity(lambda *Lambda, n int) error {
+	required, max := lambda.arity()
+	if lambda.Signature == nil {
+		required = 0
+	}
+	if n >= required && n <= max {
+		return nil
+	}
+	if lambda.Signature == nil || required == max {
+		return fmt.Errorf("lambda expects %d arguments, got %d", max, n)
+	}
+	return fmt.Errorf("lambda expects %d-%d arguments, got %d", required, max, n)
+}
+
+// validateLambdaArgs validates argument count for a lambda (used before creating a TCO thunk).
+
+func (e *Evaluator) validateLambdaArgs(lambda *Lambda, args []interface{}) error {
+	return checkLambdaArity(lambda, len(args))
 }
 
 // validateAndAdaptLambdaArgs performs full signature validation including auto-wrapping.
 // args slice is mutated in-place (auto-wrapping may change element types).
 
 func (e *Evaluator) validateAndAdaptLambdaArgs(lambda *Lambda, args []interface{}) error {
-	if lambda.Signature != nil {
-		requiredCount := 0
-		for _, param := range lambda.Signature.Params {
-			if !param.Optional {
-				requiredCount++
-			}
-		}
-		if len(args) < requiredCount || len(args) > len(lambda.Signature.Params) {
-			if requiredCount == len(lambda.Signature.Params) {
-				return fmt.Errorf("lambda expects %d arguments, got %d", len(lambda.Signature.Params), len(args))
-			}
-			return fmt.Errorf("lambda expects %d-%d arguments, got %d", requiredCount, len(lambda.Signature.Params), len(args))
-		}
-		for i := range args {
-			if i >= len(lambda.Signature.Params) {
-				break
-			}
-			param := lambda.Signature.Params[i]
-			if param.Type == TypeArray {
-				if _, isArray := args[i].([]interface{}); !isArray {
-					args[i] = []interface{}{args[i]}
-				}
-			}
-			if err := param.ValidateArgument(args[i]); err != nil {
-				return err
+	if err := checkLambdaArity(lambda, len(args)); err != nil {
+		return err
+	}
+	if lambda.Signature == nil {
+		return nil
+	}
+	for i := range args {
+		param := lambda.Signature.Params[i]
+		// Auto-wrap: if parameter expects array but arg is not array, wrap it
+		if param.Type == TypeArray {
+			if _, isArray := args[i].([]interface{}); !isArray {
+				args[i] = []interface{}{args[i]}
 			}
 		}
-	} else {
-		if len(args) > len(lambda.Params) {
-			return fmt.Errorf("lambda expects %d arguments, got %d", len(lambda.Params), len(args))
+		// Validate argument against parameter type
+		if err := ValidateArgument(&param, args[i]); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -0,0 +1,140 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// QueryResult is the outcome of one query of EvalMany.
+type QueryResult struct {
+	// Value is the result of the query, or nil when Err is set.
+	Value interface{}
+	// Err is the compile or evaluation error of the query.
+	Err error
+}
+
+// EvalMany evaluates the queries of set (see parser.CompileMany) against data
+// and returns their results, in order. Each query is evaluated as Eval would:
+// the queries are isolated from each other, and the error of one does not
+// stop the others. The timeout of e applies to the whole set.
+//
+// The sub-expressions shared by several queries are evaluated once, before the
+// queries. A query reading a shared sub-expression that fails is evaluated on
+// its own instead, so that it reports the error where it occurs, as Eval does.
+// Nothing is shared with WithAllowInPlace, whose transforms could update a
+// shared value in place. Under WithStats, the shared sub-expressions count as
+// one evaluation of their own.
+func (e *Evaluator) EvalMany(ctx context.Context, set *types.ExpressionSet, data interface{}) ([]QueryResult, error) {
+	return e.EvalManyWhere(ctx, set, data, nil)
+}
+
+// EvalManyWhere is EvalMany for callers deciding which queries to evaluate
+// from the results of the previous ones, such as short-circuiting rule sets.
+// Before evaluating query i, it calls need(i, results[:i]); when need returns
+// false the query is skipped and its result left zero. A nil need evaluates
+// every query. The shared sub-expressions are evaluated before the first query
+// regardless.
+func (e *Evaluator) EvalManyWhere(ctx context.Context, set *types.ExpressionSet, data interface{}, need func(i int, results []QueryResult) bool) ([]QueryResult, error) {
+	if set == nil {
+		return nil, fmt.Errorf("invalid expression set")
+	}
+
+	if e.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.opts.Timeout)
+		defer cancel()
+	}
+
+	shared, failed := e.evalShared(ctx, set, data)
+
+	results := make([]QueryResult, set.Len())
+	for i, expr := range set.Expressions {
+		if need != nil && !need(i, results[:i]) {
+			continue
+		}
+		if expr == nil {
+			results[i].Err = set.Errors[i]
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		evalCtx := NewContext(data)
+		if shared != nil && !readsAny(set.Rewritten[i], failed) {
+			// The shared values are borrowed, and copied if the query binds
+			// variables at its top level.
+			evalCtx.bindings, evalCtx.sharedBindings = shared, true
+			rewritten := types.NewExpression(set.Rewritten[i], expr.Source(), nil)
+			rewritten.SetConstants(expr.Constants())
+			expr = rewritten
+		}
+		results[i].Value, results[i].Err = e.evalRoot(ctx, expr, evalCtx)
+	}
+	return results, nil
+}
+
+// evalShared evaluates the shared sub-expressions of set against data and
+// returns their values by variable name, and the names of those that failed.
+//
+// They are evaluated as an expression of their own, a block of their
+// bindings: it is checked like any other under WithStrictVariables, and
+// counts as one evaluation in the Stats of e, with the nodes and time spent
+// on the shared values. An error of the whole block, such as a panic, gives
+// up on sharing: every query then fails on its own.
+func (e *Evaluator) evalShared(ctx context.Context, set *types.ExpressionSet, data interface{}) (values map[string]interface{}, failed map[string]bool) {
+	if len(set.Shared) == 0 || e.opts.AllowInPlace {
+		return nil, nil
+	}
+	block := types.NewASTNode(types.NodeBlock, 0)
+	block.Expressions = set.Shared
+	expr := types.NewExpression(block, "", nil)
+
+	evalCtx := NewContext(data)
+	values = make(map[string]interface{}, len(set.Shared))
+	err := e.evaluate(ctx, expr, evalCtx, func(ctx context.Context) error {
+		for _, bind := range set.Shared {
+			if !readsAny(bind.RHS, failed) {
+				if value, err := e.evalNode(ctx, bind.RHS, evalCtx); err == nil {
+					values[bind.StrValue] = value
+					evalCtx.SetBinding(bind.StrValue, value)
+					continue
+				}
+			}
+			if failed == nil {
+				failed = map[string]bool{}
+			}
+			failed[bind.StrValue] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil
+	}
+	return values, failed
+}
+
+// readsAny reports whether node reads one of the variables names.
+func readsAny(node *types.ASTNode, names map[string]bool) bool {
+	if node == nil || len(names) == 0 {
+		return false
+	}
+	if node.Type == types.NodeVariable && names[node.StrValue] {
+		return true
+	}
+	for _, child := range []*types.ASTNode{node.LHS, node.RHS} {
+		if readsAny(child, names) {
+			return true
+		}
+	}
+	for _, list := range [][]*types.ASTNode{node.Steps, node.Arguments, node.Expressions} {
+		for _, child := range list {
+			if readsAny(child, names) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -64,6 +64,7 @@ func (e *Evaluator) evalPathInfixObjectConstructor(ctx context.Context, node *ty
 	// Track which sub-items contribute to which keys
 	groups := make(map[string][]int) // key -> list of subItem indices
 	pairPerKey := make(map[string]int)
+	var keyOrder []string // keys in the order they were first produced
 
 	// First, group items by evaluating keys for all pairs
 	for pairIdx, pair := range node.Expressions {
@@ -85,6 +86,9 @@ func (e *Evaluator) evalPathInfixObjectConstructor(ctx context.Context, node *ty
 				if existingPair, exists := pairPerKey[key]; exists && existingPair != pairIdx {
 					return nil, fmt.Errorf("D1009: Duplicate object key %s", key)
 				}
+				if _, exists := groups[key]; !exists {
+					keyOrder = append(keyOrder, key)
+				}
 				pairPerKey[key] = pairIdx
 				groups[key] = append(groups[key], subItemIdx)
 			}
@@ -97,7 +101,7 @@ func (e *Evaluator) evalPathInfixObjectConstructor(ctx context.Context, node *ty
 		Values: make(map[string]interface{}, len(groups)),
 	}
 
-	for key := range groups {
+	for _, key := range keyOrder {
 		pairIdx := pairPerKey[key]
 		pair := node.Expressions[pairIdx]
 
@@ -185,10 +189,7 @@ func (e *Evaluator) evalObjectGroupedWithArray(ctx context.Context, node *types.
 			continue
 		}
 
-		objResult := &OrderedObject{
-			Keys:   make([]string, 0, len(node.Expressions)),
-			Values: make(map[string]interface{}, len(node.Expressions)),
-		}
+		objResult := evalCtx.newObject(len(node.Expressions))
 
 		// Find all keys that this item contributed to
 		for key, indices := range groups {
@@ -212,6 +213,7 @@ func (e *Evaluator) evalObjectGroupedWithArray(ctx context.Context, node *types.
 			}
 		}
 
+		e.shareObjectKeys(evalCtx, node, objResult)
 		result[itemIdx] = objResult
 	}
 	return result, nil
@@ -256,12 +258,22 @@ func (e *Evaluator) evalObject(ctx context.Context, node *types.ASTNode, evalCtx
 }
 
 func (e *Evaluator) evalObjectLiteral(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
-	result := &OrderedObject{
-		Keys:   make([]string, 0, len(node.Expressions)),
-		Values: make(map[string]interface{}, len(node.Expressions)),
-	}
+	result := evalCtx.newObject(len(node.Expressions))
+
+	// spread marks the keys copied by ...expr entries, which later entries
+	// may replace.
+	var spread map[string]bool
 
 	for _, pair := range node.Expressions {
+		if pair.Type == types.NodeSpread {
+			if spread == nil {
+				spread = make(map[string]bool)
+			}
+			if err := e.evalSpread(ctx, pair, evalCtx, result, spread); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		if pair.Type != types.NodeBinary || pair.Value != ":" {
 			return nil, fmt.Errorf("invalid object property")
 		}
@@ -284,20 +296,68 @@ func (e *Evaluator) evalObjectLiteral(ctx context.Context, node *types.ASTNode,
 
 		// Unwrap any contextBoundValues that escaped from path expressions (e.g. #$i bindings).
 		// The bindings have already been consumed by inner expressions; we only need the plain value.
-		value = unwrapCVsDeep(value)
+		value, err = unwrapCVsDeep(value)
+		if err != nil {
+			return nil, err
+		}
 
 		for _, key := range keys {
 			if _, exists := result.Values[key]; exists {
-				return nil, fmt.Errorf("D1009: Duplicate object key %s", key)
+				if !spread[key] {
+					return nil, fmt.Errorf("D1009: Duplicate object key %s", key)
+				}
+				delete(spread, key)
+			} else {
+				result.Keys = append(result.Keys, key)
 			}
-			result.Keys = append(result.Keys, key)
 			result.Values[key] = value
 		}
 	}
 
+	e.shareObjectKeys(evalCtx, node, result)
 	return result, nil
 }
 
+// evalSpread copies the entries of the object, or array of objects, that a
+// ...expr entry evaluates to into result, replacing existing values. The keys
+// it sets are recorded in spread.
+func (e *Evaluator) evalSpread(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext, result *OrderedObject, spread map[string]bool) error {
+	value, err := e.evalNode(ctx, node.LHS, evalCtx)
+	if err != nil || value == nil {
+		return err
+	}
+	if value, err = unwrapCVsDeep(value); err != nil {
+		return err
+	}
+	objects, ok := value.([]interface{})
+	if !ok {
+		objects = []interface{}{value}
+	}
+	for _, obj := range objects {
+		var keys []string
+		var values map[string]interface{}
+		switch o := obj.(type) {
+		case nil:
+			continue
+		case *OrderedObject:
+			keys, values = o.Keys, o.Values
+		case map[string]interface{}:
+			keys, values = sortedKeys(o), o
+		default:
+			return types.NewError(types.ErrSpreadNotObject,
+				fmt.Sprintf("The spread value must be an object or an array of objects, got %T", obj), node.Position)
+		}
+		for _, key := range keys {
+			if _, exists := result.Values[key]; !exists {
+				result.Keys = append(result.Keys, key)
+			}
+			result.Values[key] = values[key]
+			spread[key] = true
+		}
+	}
+	return nil
+}
+
 func (e *Evaluator) evalObjectGrouped(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
 	collection, err := e.evalNode(ctx, node.LHS, evalCtx)
 	if err != nil {
@@ -318,6 +378,13 @@ func (e *Evaluator) evalObjectGrouped(ctx context.Context, node *types.ASTNode,
 	} else {
 		items = append(items, collection)
 	}
+	return e.groupItems(ctx, node, evalCtx, items)
+}
+
+// groupItems applies the object constructor node to items, the evaluated
+// collection at its left: a group-by merging the items by key, or, for a prefix
+// constructor applied through a path, one object per item.
+func (e *Evaluator) groupItems(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext, items []interface{}) (interface{}, error) {
 	if len(items) == 0 {
 		return &OrderedObject{
 			Keys:   make([]string, 0),
@@ -330,6 +397,18 @@ func (e *Evaluator) evalObjectGrouped(ctx context.Context, node *types.ASTNode,
 	groups := make(map[string][]int)
 	// pair_per_key: key -> which pair expression created it
 	pairPerKey := make(map[string]int)
+	// keyOrder: keys in the order they were first produced, so that a sorted
+	// input (x^(k){...}) yields its keys in sort order
+	var keyOrder []string
+
+	// Items may be contextBoundValues (x#$i^(k){...}): keys and values are
+	// evaluated against their value, with their bindings in scope.
+	itemCtxs := make([]*EvalContext, len(items))
+	for itemIdx, item := range items {
+		if item != nil {
+			itemCtxs[itemIdx] = boundItemContext(evalCtx, item)
+		}
+	}
 
 	// First, group items by evaluating keys for all pairs
 	for pairIdx, pair := range node.Expressions {
@@ -341,8 +420,7 @@ func (e *Evaluator) evalObjectGrouped(ctx context.Context, node *types.ASTNode,
 			if item == nil {
 				continue
 			}
-			itemCtx := evalCtx.NewChildContext(item)
-			keys, err := e.evalObjectKeys(ctx, pair.LHS, itemCtx, false)
+			keys, err := e.evalObjectKeys(ctx, pair.LHS, itemCtxs[itemIdx], false)
 			if err != nil {
 				return nil, err
 			}
@@ -351,6 +429,9 @@ func (e *Evaluator) evalObjectGrouped(ctx context.Context, node *types.ASTNode,
 				if existingPair, exists := pairPerKey[key]; exists && existingPair != pairIdx {
 					return nil, fmt.Errorf("D1009: Duplicate object key %s", key)
 				}
+				if _, exists := groups[key]; !exists {
+					keyOrder = append(keyOrder, key)
+				}
 				pairPerKey[key] = pairIdx
 				groups[key] = append(groups[key], itemIdx)
 			}
@@ -372,21 +453,17 @@ func (e *Evaluator) evalObjectGrouped(ctx context.Context, node *types.ASTNode,
 				continue
 			}
 
-			objResult := &OrderedObject{
-				Keys:   make([]string, 0, len(node.Expressions)),
-				Values: make(map[string]interface{}, len(node.Expressions)),
-			}
+			objResult := evalCtx.newObject(len(node.Expressions))
 
 			// Find all keys that this item contributed to
-			for key, indices := range groups {
-				for _, idx := range indices {
+			for _, key := range keyOrder {
+				for _, idx := range groups[key] {
 					if idx == itemIdx {
 						// Find which pair created this key to evaluate value
 						pairIdx := pairPerKey[key]
 						pair := node.Expressions[pairIdx]
 
-						itemCtx := evalCtx.NewChildContext(item)
-						value, err := e.evalNode(ctx, pair.RHS, itemCtx)
+						value, err := e.evalNode(ctx, pair.RHS, itemCtxs[itemIdx])
 						if err != nil {
 							return nil, err
 						}
@@ -399,6 +476,7 @@ func (e *Evaluator) evalObjectGrouped(ctx context.Context, node *types.ASTNode,
 				}
 			}
 
+			e.shareObjectKeys(evalCtx, node, objResult)
 			result[itemIdx] = objResult
 		}
 		return result, nil
@@ -410,7 +488,7 @@ func (e *Evaluator) evalObjectGrouped(ctx context.Context, node *types.ASTNode,
 		Values: make(map[string]interface{}, len(groups)),
 	}
 
-	for key := range groups {
+	for _, key := range keyOrder {
 		pairIdx := pairPerKey[key]
 		pair := node.Expressions[pairIdx]
 		groupItems := make([]interface{}, 0, len(groups[key]))
@@ -418,7 +496,17 @@ func (e *Evaluator) evalObjectGrouped(ctx context.Context, node *types.ASTNode,
 			groupItems = append(groupItems, items[itemIdx])
 		}
 
+		// The value is evaluated against the group's values, with the bindings
+		// of its items merged: a variable bound by several items holds the
+		// sequence of their values.
+		bindings := reduceBindings(groupItems)
+		for i, item := range groupItems {
+			groupItems[i] = boundItemData(item)
+		}
 		groupCtx := evalCtx.NewChildContext(groupItems)
+		if len(bindings) > 0 {
+			applyBindingsToCtx(groupCtx, bindings)
+		}
 		value, err := e.evalNode(ctx, pair.RHS, groupCtx)
 		if err != nil {
 			return nil, err
@@ -437,6 +525,9 @@ func (e *Evaluator) evalObjectKeys(ctx context.Context, keyNode *types.ASTNode,
 	if keyNode.Type == types.NodeString {
 		return []string{keyNode.StrValue}, nil
 	}
+	if keyNode.Type == types.NodeComputedKey {
+		return e.evalComputedKey(ctx, keyNode, evalCtx)
+	}
 
 	// NodeName keys are ALWAYS evaluated as expressions (never treated as string literals).
 	// In JSONata: `{name: val}` evaluates `name` as a field path, not as the string "name".
@@ -479,3 +570,23 @@ func (e *Evaluator) evalObjectKeys(ctx context.Context, keyNode *types.ASTNode,
 }
 
 // evalFilter evaluates a filter expression.
+
+// evalComputedKey evaluates an [expr] key: a single string, or a number or
+// boolean converted as by $string. An undefined key omits the entry.
+func (e *Evaluator) evalComputedKey(ctx context.Context, keyNode *types.ASTNode, evalCtx *EvalContext) ([]string, error) {
+	keyVal, err := e.evalNode(ctx, keyNode.LHS, evalCtx)
+	if err != nil || keyVal == nil {
+		return nil, err
+	}
+	if cv, ok := keyVal.(*contextBoundValue); ok {
+		keyVal = cv.value
+	}
+	switch v := keyVal.(type) {
+	case string:
+		return []string{v}, nil
+	case float64, int, int64, bool:
+		return []string{e.toString(v)}, nil
+	}
+	return nil, types.NewError(types.ErrInvalidTypeOperation,
+		fmt.Sprintf("Object key must be a string, number or boolean, got %T", keyVal), keyNode.Position)
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math"
-	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/sandrolain/gosonata/pkg/types"
 )
@@ -35,6 +35,11 @@ func (e *Evaluator) evalBinary(ctx context.Context, node *types.ASTNode, evalCtx
 		return e.evalRange(ctx, node, evalCtx)
 	case "~>":
 		return e.evalApply(ctx, node, evalCtx)
+	case "&":
+		// The profiler and the debugger see each & of a chain on its own.
+		if (isConcat(node.LHS) || isConcat(node.RHS)) && evalCtx.root.profile == nil && e.opts.DebugHook == nil {
+			return e.evalConcatChain(ctx, node, evalCtx)
+		}
 	}
 
 	// Evaluate both sides
@@ -49,8 +54,14 @@ func (e *Evaluator) evalBinary(ctx context.Context, node *types.ASTNode, evalCtx
 	}
 
 	// Unwrap contextBoundValues: CVs must not be visible to operators
-	left = unwrapCVsDeep(left)
-	right = unwrapCVsDeep(right)
+	left, err = unwrapCVsDeep(left)
+	if err != nil {
+		return nil, err
+	}
+	right, err = unwrapCVsDeep(right)
+	if err != nil {
+		return nil, err
+	}
 
 	// Fast-path for the most common case: both operands are float64.
 	// Avoids the toNumber() type-assertion chain and generic switch below.
@@ -364,8 +375,8 @@ func (e *Evaluator) opEqual(left, right interface{}) bool {
 		return lNum == rNum
 	}
 
-	// Fall back to deep equal for other types
-	return reflect.DeepEqual(left, right)
+	// Fall back to structural equality for strings, arrays and objects
+	return deepEqual(left, right)
 }
 
 func (e *Evaluator) opLess(left, right interface{}) (interface{}, error) {
@@ -517,9 +528,61 @@ func (e *Evaluator) opGreaterEqual(left, right interface{}) (interface{}, error)
 func (e *Evaluator) opConcat(left, right interface{}) (interface{}, error) {
 	l := e.toString(left)
 	r := e.toString(right)
+	if err := e.checkStringLength(len(l)+len(r), "&"); err != nil {
+		return nil, err
+	}
 	return l + r, nil
 }
 
+// isConcat reports whether node is a & operator.
+func isConcat(node *types.ASTNode) bool {
+	return node != nil && node.Type == types.NodeBinary && node.StrValue == "&"
+}
+
+// concatOperands appends the operands of the & chain rooted at node to
+// operands, left to right.
+func concatOperands(node *types.ASTNode, operands []*types.ASTNode) []*types.ASTNode {
+	if !isConcat(node) {
+		return append(operands, node)
+	}
+	return concatOperands(node.RHS, concatOperands(node.LHS, operands))
+}
+
+// evalConcatChain evaluates a chain of & operators, a & b & c ..., in one
+// pass. The operands are evaluated left to right, as the nested operators
+// would evaluate them, and their strings are copied once into a builder sized
+// for the result, instead of each & copying the string built so far, which
+// is quadratic in the length of the chain.
+func (e *Evaluator) evalConcatChain(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
+	operands := concatOperands(node, nil)
+	// Count the & nodes below node for Evaluator.Stats, as if dispatched.
+	if evalCtx.root.nodes != nil {
+		evalCtx.root.nodes.Add(uint64(len(operands) - 2))
+	}
+	parts := make([]string, len(operands))
+	size := 0
+	for i, operand := range operands {
+		value, err := e.evalNode(ctx, operand, evalCtx)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = unwrapCVsDeep(value); err != nil {
+			return nil, err
+		}
+		parts[i] = e.toString(value)
+		size += len(parts[i])
+		if err := e.checkStringLength(size, "&"); err != nil {
+			return nil, err
+		}
+	}
+	var b strings.Builder
+	b.Grow(size)
+	for _, part := range parts {
+		b.WriteString(part)
+	}
+	return b.String(), nil
+}
+
 // In operator
 
 func (e *Evaluator) opIn(left, right interface{}) (interface{}, error) {
@@ -0,0 +1,53 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Page is one page of the items of a result, returned by EvalPage.
+type Page struct {
+	// Items are the items of the page, at most the limit asked for.
+	Items []interface{}
+	// More reports whether the result has items after the page: the next page
+	// starts at the offset of this one plus len(Items).
+	More bool
+}
+
+// EvalPage evaluates expr against data and returns the items of the result
+// from offset (0 for the first) to offset+limit, the items being those
+// EvalIter yields: the elements of an array result, or the result itself.
+//
+// The expressions EvalIter streams, `seq[pred]`, `path.seq[pred]` and the same
+// followed by an object constructor `.{...}`, stop being evaluated once the
+// page and the first item after it (to set More) have been found: the
+// predicate and the constructor do not run for the remaining items. Other
+// expressions are evaluated in full, and the page is cut from the result.
+func (e *Evaluator) EvalPage(ctx context.Context, expr *types.Expression, data interface{}, offset, limit int) (*Page, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+	seq, err := e.EvalIter(ctx, expr, data)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Items: make([]interface{}, 0, min(limit, 64))}
+	i := 0
+	for item, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		if i == offset+limit {
+			page.More = true
+			break
+		}
+		if i >= offset {
+			page.Items = append(page.Items, item)
+		}
+		i++
+	}
+	return page, nil
+}
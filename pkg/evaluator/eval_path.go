@@ -62,50 +62,76 @@ func (e *Evaluator) evalPath(ctx context.Context, node *types.ASTNode, evalCtx *
 			return e.evalPathInfixObjectConstructor(ctx, node.RHS, arr, evalCtx)
 		}
 
+		// With bindings, the items form a tuple stream, which a group-by
+		// (x@$v.y{k: v}) groups as a whole, like the reference: the step is
+		// mapped over the items, keeping their bindings, and the results are
+		// grouped, each group's value seeing the bindings of its items merged
+		// (see groupItems).
+		rhs := node.RHS
+		var group *types.ASTNode
+		if hasBindings && rhs.Type == types.NodeObject && rhs.LHS != nil {
+			group, rhs = rhs, rhs.LHS
+		}
+
+		// A large root array may have the step evaluated in shards on several
+		// goroutines (WithShardSize); the results are then merged below as if
+		// the items had been evaluated in order.
+		var values []interface{}
+		if !hasBindings && group == nil && e.shardable(rhs, arr, evalCtx) {
+			values, err = e.evalShards(ctx, rhs, arr, evalCtx)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		// Apply path to each element of the array
 		result := make([]interface{}, 0, len(arr))
-		for _, item := range arr {
+		for i, item := range arr {
 			// Extract value and bindings from contextBoundValue if present
 			actualItem, inheritedBindings := extractBoundItem(item)
-			// For @$var CVs, the parent field holds the rewound context for the next path step.
-			// Use that as the execution context; for #$var or plain items, use the value itself.
-			contextData := actualItem
-			if cv, ok := item.(*contextBoundValue); ok && cv.parent != nil {
-				contextData = cv.parent
-			}
 
-			// Create context with appropriate data for the next path step
-			// OPT-02: acquire from pool for the common (non-CV-with-parentObj) case.
-			var itemCtx *EvalContext
-			pooledItemCtx := false
-			if cv, ok := item.(*contextBoundValue); ok && cv.parentObj != nil && cv.parent == nil {
-				// This CV carries parent-object info for % semantics (not @$ rewind).
-				// Create a parent context with the container object, then create the array item context.
-				parentObjCtx := evalCtx.NewChildContext(cv.parentObj)
-				itemCtx = parentObjCtx.NewArrayItemContext(contextData)
-			} else {
-				itemCtx = acquireEvalCtx(contextData, evalCtx, true)
-				pooledItemCtx = true
-			}
-			// Apply inherited bindings from @$ / #$ operators
-			if len(inheritedBindings) > 0 {
-				applyBindingsToCtx(itemCtx, inheritedBindings)
-			}
-
-			// Evaluate right side in item context
 			var value interface{}
-			if node.RHS.Type == types.NodeString {
-				value, err = e.evalNameString(node.RHS.StrValue, itemCtx)
-			} else if node.RHS.Type == types.NodeName {
-				value, err = e.evalName(node.RHS, itemCtx)
-			} else if node.RHS.Type == types.NodeFunction && node.RHS.LHS != nil && node.RHS.LHS.Type == types.NodeLambda {
-				// Special case: lambda call in path context
-				value, err = e.evalFunctionWithContextInjection(ctx, node.RHS, itemCtx, actualItem)
+			if values != nil {
+				value = values[i]
 			} else {
-				value, err = e.evalNode(ctx, node.RHS, itemCtx)
-			}
-			if err != nil {
-				return nil, err
+				// For @$var CVs, the parent field holds the rewound context for the next path step.
+				// Use that as the execution context; for #$var or plain items, use the value itself.
+				contextData := actualItem
+				if cv, ok := item.(*contextBoundValue); ok && cv.parent != nil {
+					contextData = cv.parent
+				}
+
+				// Create context with appropriate data for the next path step
+				// OPT-02: acquire from pool for the common (non-CV-with-parentObj) case.
+				var itemCtx *EvalContext
+				pooledItemCtx := false
+				if cv, ok := item.(*contextBoundValue); ok && cv.parentObj != nil && cv.parent == nil {
+					// This CV carries parent-object info for % semantics (not @$ rewind).
+					// Create a parent context with the container object, then create the array item context.
+					parentObjCtx := evalCtx.NewChildContext(cv.parentObj)
+					itemCtx = parentObjCtx.NewArrayItemContext(contextData)
+				} else {
+					itemCtx = acquireEvalCtx(contextData, evalCtx, true)
+					pooledItemCtx = true
+				}
+				// Apply inherited bindings from @$ / #$ operators
+				if len(inheritedBindings) > 0 {
+					applyBindingsToCtx(itemCtx, inheritedBindings)
+				}
+
+				// Evaluate right side in item context
+				value, err = e.evalPathStep(ctx, rhs, itemCtx, actualItem)
+				if err != nil {
+					if group == nil {
+						evalCtx.recordPartial(node, result, err)
+					}
+					return nil, err
+				}
+				// OPT-02: return pooled item context to pool (happy path).
+				// Error paths skip this via early return; that is acceptable for a pool.
+				if pooledItemCtx {
+					releaseEvalCtx(itemCtx)
+				}
 			}
 
 			// Flatten: if value is an array, append its elements
@@ -113,8 +139,8 @@ func (e *Evaluator) evalPath(ctx context.Context, node *types.ASTNode, evalCtx *
 			// in which case we keep the inner array intact.
 			if value != nil {
 				// Check if the RHS is an array constructor (possibly wrapped in a filter with [])
-				rhsIsArrayCtor := node.RHS.Type == types.NodeArray ||
-					(node.RHS.Type == types.NodeFilter && node.RHS.LHS != nil && node.RHS.LHS.Type == types.NodeArray)
+				rhsIsArrayCtor := rhs.Type == types.NodeArray ||
+					(rhs.Type == types.NodeFilter && rhs.LHS != nil && rhs.LHS.Type == types.NodeArray)
 
 				if len(inheritedBindings) > 0 {
 					// Propagate inherited bindings to each sub-result so they remain accessible
@@ -134,27 +160,30 @@ func (e *Evaluator) evalPath(ctx context.Context, node *types.ASTNode, evalCtx *
 						// Each sub-item's parent is the current item (e.g., Products' parent = Order).
 						for _, subItem := range subArr {
 							// Only wrap if the sub-item doesn't already have parent info
-							if _, alreadyCV := subItem.(*contextBoundValue); !alreadyCV {
+							cv, alreadyCV := subItem.(*contextBoundValue)
+							switch {
+							case !alreadyCV:
 								result = append(result, &contextBoundValue{
 									value:     subItem,
 									parent:    nil,
 									bindings:  nil, // OPT-03: nil is handled by extractBoundItem
 									parentObj: actualItem,
 								})
-							} else {
-								result = append(result, subItem)
+							default:
+								result = append(result, withParentObj(cv, actualItem))
 							}
 						}
+					} else if cv, isCV := value.(*contextBoundValue); isCV {
+						result = append(result, withParentObj(cv, actualItem))
 					} else {
 						result = append(result, value)
 					}
 				}
 			}
-			// OPT-02: return pooled item context to pool (happy path).
-			// Error paths skip this via early return; that is acceptable for a pool.
-			if pooledItemCtx {
-				releaseEvalCtx(itemCtx)
-			}
+		}
+
+		if group != nil {
+			return e.groupItems(ctx, group, evalCtx, result)
 		}
 
 		// Return empty array as nil per JSONata semantics
@@ -191,20 +220,22 @@ func (e *Evaluator) evalPath(ctx context.Context, node *types.ASTNode, evalCtx *
 	// For non-array, create new context with left as data
 	pathCtx := evalCtx.NewChildContext(left)
 
-	if node.RHS.Type == types.NodeString {
-		return e.evalNameString(node.RHS.StrValue, pathCtx)
-	}
-	if node.RHS.Type == types.NodeName {
-		return e.evalName(node.RHS, pathCtx)
-	}
-	if node.RHS.Type == types.NodeFunction && node.RHS.LHS != nil && node.RHS.LHS.Type == types.NodeLambda {
+	return e.evalPathStep(ctx, node.RHS, pathCtx, left)
+}
+
+// evalPathStep evaluates the step rhs of a path in stepCtx, whose data is item.
+func (e *Evaluator) evalPathStep(ctx context.Context, rhs *types.ASTNode, stepCtx *EvalContext, item interface{}) (interface{}, error) {
+	switch {
+	case rhs.Type == types.NodeString:
+		return e.evalNameString(rhs.StrValue, stepCtx)
+	case rhs.Type == types.NodeName:
+		return e.evalName(rhs, stepCtx)
+	case rhs.Type == types.NodeFunction && rhs.LHS != nil && rhs.LHS.Type == types.NodeLambda:
 		// Special case: lambda call in path context
-		// The left value should be injected as the first argument
-		return e.evalFunctionWithContextInjection(ctx, node.RHS, pathCtx, left)
+		// The item should be injected as the first argument
+		return e.evalFunctionWithContextInjection(ctx, rhs, stepCtx, item)
 	}
-
-	// Evaluate right side in new context
-	return e.evalNode(ctx, node.RHS, pathCtx)
+	return e.evalNode(ctx, rhs, stepCtx)
 }
 
 // evalDescendent evaluates a descendent expression (recursive field search).
@@ -257,8 +288,8 @@ func (e *Evaluator) evalDescendent(ctx context.Context, node *types.ASTNode, eva
 			// Recurse into children
 			switch v := data.(type) {
 			case map[string]interface{}:
-				for _, fieldValue := range v {
-					if err := recurseDescendants(fieldValue); err != nil {
+				for _, k := range sortedKeys(v) {
+					if err := recurseDescendants(v[k]); err != nil {
 						return err
 					}
 				}
@@ -290,13 +321,63 @@ func (e *Evaluator) evalDescendent(ctx context.Context, node *types.ASTNode, eva
 		return results, nil
 	}
 
-	// With RHS: collect all descendants and apply the RHS path to each candidate.
+	// With RHS: walk all descendants and apply the RHS path to each candidate.
 	// This supports expressions like **.foo (apply "foo" to each descendant).
-	var descendants []interface{}
+	//
+	// Candidates are evaluated during the descent instead of being materialized first,
+	// and when the RHS starts with a field step (**.foo, **.foo[pred], **.foo.bar) any
+	// candidate that cannot yield a value for that field is skipped without evaluating
+	// the RHS (and its predicate) at all. Traversal order is unchanged: left first, then
+	// descendants in depth-first pre-order.
+	leadField, hasLeadField := descendantLeadField(node.RHS)
+
+	var results []interface{}
+	visit := func(candidate interface{}) {
+		if hasLeadField && !mayHaveField(candidate, leadField) {
+			return
+		}
+		// Create context with candidate as data
+		candCtx := evalCtx.NewChildContext(candidate)
 
-	// Helper function to recursively collect ALL descendant values
-	var collectDescendants func(data interface{}) error
-	collectDescendants = func(data interface{}) error {
+		// Evaluate RHS in candidate context
+		var value interface{}
+		var err error
+		if node.RHS.Type == types.NodeString {
+			value, err = e.evalNameString(node.RHS.StrValue, candCtx)
+		} else if node.RHS.Type == types.NodeName {
+			value, err = e.evalName(node.RHS, candCtx)
+		} else {
+			value, err = e.evalNode(ctx, node.RHS, candCtx)
+		}
+
+		// Add non-nil results
+		if err == nil && value != nil {
+			if arr, ok := value.([]interface{}); ok {
+				results = append(results, arr...)
+			} else {
+				results = append(results, value)
+			}
+		}
+	}
+
+	// Helper function to recursively visit ALL descendant values
+	var walkDescendants func(data interface{}) error
+	walkField := func(fieldValue interface{}) error {
+		// Skip nil values
+		if fieldValue == nil {
+			return nil
+		}
+
+		// Don't visit arrays as candidates - their elements are visited when we recurse.
+		// This prevents evalPath from traversing the array twice.
+		if _, isArray := fieldValue.([]interface{}); !isArray {
+			visit(fieldValue)
+		}
+
+		// Recurse into this value (arrays will have their elements visited during recursion)
+		return walkDescendants(fieldValue)
+	}
+	walkDescendants = func(data interface{}) error {
 		// Check context cancellation
 		select {
 		case <-ctx.Done():
@@ -308,36 +389,27 @@ func (e *Evaluator) evalDescendent(ctx context.Context, node *types.ASTNode, eva
 			return nil
 		}
 
-		// Recursively collect from nested structures
+		// Recursively visit nested structures
 		switch v := data.(type) {
 		case map[string]interface{}:
-			for _, fieldValue := range v {
-				// Skip nil values
-				if fieldValue == nil {
-					continue
-				}
-
-				// Don't add arrays as candidates - we'll add their elements when we recurse
-				// This prevents evalPath from traversing the array twice (once here, once in evalPath)
-				if _, isArray := fieldValue.([]interface{}); !isArray {
-					descendants = append(descendants, fieldValue)
+			for _, k := range sortedKeys(v) {
+				if err := walkField(v[k]); err != nil {
+					return err
 				}
-
-				// Recurse into this value (arrays will have their elements added during recursion)
-				if err := collectDescendants(fieldValue); err != nil {
+			}
+		case *OrderedObject:
+			for _, k := range v.Keys {
+				if err := walkField(v.Values[k]); err != nil {
 					return err
 				}
 			}
 		case []interface{}:
-			// For arrays, add each item as a descendant (but not the array itself)
-			// and recurse into each item
+			// For arrays, visit each item (but not the array itself) and recurse into it
 			for _, item := range v {
-				// Add this item to descendants
 				if item != nil {
-					descendants = append(descendants, item)
+					visit(item)
 				}
-				// Recurse into this item to get its descendants
-				if err := collectDescendants(item); err != nil {
+				if err := walkDescendants(item); err != nil {
 					return err
 				}
 			}
@@ -346,41 +418,12 @@ func (e *Evaluator) evalDescendent(ctx context.Context, node *types.ASTNode, eva
 		return nil
 	}
 
-	// Start collecting all descendants from left
-	// Also evaluate RHS on left itself (not just descendants)
-	if err := collectDescendants(left); err != nil {
+	// Evaluate RHS on left itself first, then on every descendant
+	visit(left)
+	if err := walkDescendants(left); err != nil {
 		return nil, err
 	}
 
-	// Add left itself as first candidate for RHS evaluation
-	allCandidates := append([]interface{}{left}, descendants...)
-
-	// Now apply RHS as a path to each candidate (including left)
-	var results []interface{}
-	for _, candidate := range allCandidates {
-		// Create context with candidate as data
-		candCtx := evalCtx.NewChildContext(candidate)
-
-		// Evaluate RHS in candidate context
-		var value interface{}
-		if node.RHS.Type == types.NodeString {
-			value, err = e.evalNameString(node.RHS.StrValue, candCtx)
-		} else if node.RHS.Type == types.NodeName {
-			value, err = e.evalName(node.RHS, candCtx)
-		} else {
-			value, err = e.evalNode(ctx, node.RHS, candCtx)
-		}
-
-		// Add non-nil results
-		if err == nil && value != nil {
-			if arr, ok := value.([]interface{}); ok {
-				results = append(results, arr...)
-			} else {
-				results = append(results, value)
-			}
-		}
-	}
-
 	// Deduplicate results before returning
 	results = deduplicateResults(results)
 
@@ -397,8 +440,46 @@ func (e *Evaluator) evalDescendent(ctx context.Context, node *types.ASTNode, eva
 	return results, nil
 }
 
+// descendantLeadField returns the field name looked up by the first step of a
+// descendant RHS (foo in **.foo, **.foo[pred], **.foo.bar), if there is one.
+// Such an RHS can only produce a value for candidates that are arrays or objects
+// carrying that field, which lets evalDescendent prune candidates up front.
+
+func descendantLeadField(rhs *types.ASTNode) (string, bool) {
+	for rhs != nil {
+		switch rhs.Type {
+		case types.NodeName, types.NodeString:
+			return rhs.StrValue, true
+		case types.NodePath, types.NodeFilter:
+			rhs = rhs.LHS
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// mayHaveField reports whether looking up field on candidate can yield a value.
+// Arrays are kept conservatively since the lookup maps over their items.
+
+func mayHaveField(candidate interface{}, field string) bool {
+	switch v := candidate.(type) {
+	case map[string]interface{}:
+		_, ok := v[field]
+		return ok
+	case *OrderedObject:
+		_, ok := v.Values[field]
+		return ok
+	case []interface{}, *contextBoundValue:
+		return true
+	default:
+		return false
+	}
+}
+
 // deduplicateResults removes duplicate values from a slice while preserving order.
-// Uses deep equality comparison for complex types (maps, slices).
+// Uses deep equality comparison for complex types (maps, slices), bucketed by
+// hashValue so that the scan stays linear.
 // Note: Only deduplicates objects and arrays, not primitive values (numbers, strings, bools).
 
 func deduplicateResults(results []interface{}) []interface{} {
@@ -407,6 +488,7 @@ func deduplicateResults(results []interface{}) []interface{} {
 	}
 
 	seen := make([]interface{}, 0, len(results))
+	var set *valueSet
 	for _, item := range results {
 		// Only deduplicate complex types (maps and slices)
 		// Primitive values (numbers, strings, bools) can repeat
@@ -415,14 +497,10 @@ func deduplicateResults(results []interface{}) []interface{} {
 			continue
 		}
 
-		duplicate := false
-		for _, seenItem := range seen {
-			if deepEqual(item, seenItem) {
-				duplicate = true
-				break
-			}
+		if set == nil {
+			set = newValueSet(len(results))
 		}
-		if !duplicate {
+		if set.add(item) {
 			seen = append(seen, item)
 		}
 	}
@@ -439,65 +517,6 @@ func isComplexType(v interface{}) bool {
 	return false
 }
 
-// deepEqual performs deep equality comparison between two values.
-// Handles maps, slices, and primitive types.
-// OPT-04: type switch avoids reflect.ValueOf allocation; covers all runtime types
-// produced by encoding/json and the evaluator itself.
-func deepEqual(a, b interface{}) bool {
-	if a == nil {
-		return b == nil
-	}
-	switch av := a.(type) {
-	case bool:
-		bv, ok := b.(bool)
-		return ok && av == bv
-	case float64:
-		bv, ok := b.(float64)
-		return ok && av == bv
-	case string:
-		bv, ok := b.(string)
-		return ok && av == bv
-	case types.Null:
-		_, ok := b.(types.Null)
-		return ok
-	case []interface{}:
-		bv, ok := b.([]interface{})
-		if !ok || len(av) != len(bv) {
-			return false
-		}
-		for i := range av {
-			if !deepEqual(av[i], bv[i]) {
-				return false
-			}
-		}
-		return true
-	case map[string]interface{}:
-		bv, ok := b.(map[string]interface{})
-		if !ok || len(av) != len(bv) {
-			return false
-		}
-		for k, v := range av {
-			if !deepEqual(v, bv[k]) {
-				return false
-			}
-		}
-		return true
-	case *OrderedObject:
-		bv, ok := b.(*OrderedObject)
-		if !ok || len(av.Keys) != len(bv.Keys) {
-			return false
-		}
-		for _, k := range av.Keys {
-			if !deepEqual(av.Values[k], bv.Values[k]) {
-				return false
-			}
-		}
-		return true
-	default:
-		return false
-	}
-}
-
 // evalWildcard evaluates a wildcard expression (*).
 // Returns all values from an object or all elements from an array.
 
@@ -511,18 +530,24 @@ func (e *Evaluator) evalWildcard(ctx context.Context, node *types.ASTNode, evalC
 
 	var results []interface{}
 
+	// Flatten array values into the result.
+	appendValue := func(value interface{}) {
+		if arr, ok := value.([]interface{}); ok {
+			results = append(results, arr...)
+		} else if value != nil {
+			results = append(results, value)
+		}
+	}
+
 	switch v := data.(type) {
 	case map[string]interface{}:
-		// For objects, return all values
-		for _, value := range v {
-			if value != nil {
-				// Flatten arrays
-				if arr, ok := value.([]interface{}); ok {
-					results = append(results, arr...)
-				} else {
-					results = append(results, value)
-				}
-			}
+		// For objects, return all values (in key order: a map has no document order)
+		for _, k := range sortedKeys(v) {
+			appendValue(v[k])
+		}
+	case *OrderedObject:
+		for _, k := range v.Keys {
+			appendValue(v.Values[k])
 		}
 	case []interface{}:
 		// For arrays, flatten and return all elements
@@ -574,3 +599,15 @@ func hasKeepArrayInChain(node *types.ASTNode) bool {
 }
 
 // evalBinary evaluates a binary operator expression.
+
+// withParentObj records obj as the containing object of a contextBoundValue
+// produced by a #$ binding on a path step (o.p#$j), so that % still reaches it.
+// Values that already carry a parent are returned as is.
+func withParentObj(cv *contextBoundValue, obj interface{}) *contextBoundValue {
+	if cv.parent != nil || cv.parentObj != nil {
+		return cv
+	}
+	withParent := *cv
+	withParent.parentObj = obj
+	return &withParent
+}
@@ -103,10 +103,23 @@ func acquireEvalCtx(data interface{}, parent *EvalContext, isArrayItem bool) *Ev
 		c.depth = 0
 	}
 	c.isArrayItem = isArrayItem
+	c.position = 0
 	c.bindings = nil
+	c.sharedBindings = false
 	c.tcoTail = false
 	c.escaped = false
 	c.nowTime = nil
+	c.filterIndexes = nil
+	c.rng = nil
+	c.objectKeys = nil
+	c.objects = nil
+	c.resultItems = 0
+	c.documents = nil
+	c.lambdas = nil
+	c.profile = nil
+	c.debug = nil
+	c.nodes = nil
+	c.closures = false
 	return c
 }
 
@@ -121,10 +134,23 @@ func releaseEvalCtx(c *EvalContext) {
 	c.parent = nil
 	c.root = nil
 	c.bindings = nil
+	c.sharedBindings = false
 	c.depth = 0
 	c.isArrayItem = false
+	c.position = 0
 	c.tcoTail = false
 	c.nowTime = nil
+	c.filterIndexes = nil
+	c.rng = nil
+	c.objectKeys = nil
+	c.objects = nil
+	c.resultItems = 0
+	c.documents = nil
+	c.lambdas = nil
+	c.profile = nil
+	c.debug = nil
+	c.nodes = nil
+	c.closures = false
 	evalCtxPool.Put(c)
 }
 
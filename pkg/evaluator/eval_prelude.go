@@ -0,0 +1,73 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Preludes.
+//
+// A prelude (WithPrelude) is an expression compiled once whose top-level
+// bindings are visible to every expression the evaluator runs, typically a
+// library of helper lambdas:
+//
+//	(
+//	  $net := function($o) { $o.price * $o.qty };
+//	  $total := function($orders) { $sum($orders.$net($)) }
+//	)
+//
+// Its definitions are bound at the start of each evaluation, in a frame
+// enclosing the root context: helpers see the input as $ and $$, may refer to
+// each other (and to themselves) like the definitions of a block, and are
+// never shared between concurrent evaluations. Variables bound by the caller
+// (EvalWithBindings) are bound in the root context and therefore take
+// precedence over prelude definitions of the same name.
+
+// bindPrelude evaluates the prelude expressions in a new frame and makes it the
+// enclosing frame of the root context evalCtx.
+func (e *Evaluator) bindPrelude(ctx context.Context, evalCtx *EvalContext) error {
+	if len(e.opts.Preludes) == 0 {
+		return nil
+	}
+	frame := &EvalContext{data: evalCtx.data, root: evalCtx.root}
+	for _, prelude := range e.opts.Preludes {
+		if prelude == nil || prelude.AST() == nil {
+			continue
+		}
+		nodes := []*types.ASTNode{prelude.AST()}
+		if nodes[0].Type == types.NodeBlock {
+			// The definitions of a top-level block go straight into the frame
+			// instead of a block-local one.
+			nodes = nodes[0].Expressions
+		}
+		for _, node := range nodes {
+			if _, err := e.evalNode(ctx, node, frame); err != nil {
+				return fmt.Errorf("prelude: %w", err)
+			}
+		}
+	}
+	evalCtx.parent = frame
+	return nil
+}
+
+// preludeBinds reports whether one of the preludes of e binds name at its top
+// level.
+func (e *Evaluator) preludeBinds(name string) bool {
+	for _, prelude := range e.opts.Preludes {
+		if prelude == nil || prelude.AST() == nil {
+			continue
+		}
+		nodes := []*types.ASTNode{prelude.AST()}
+		if nodes[0].Type == types.NodeBlock {
+			nodes = nodes[0].Expressions
+		}
+		for _, node := range nodes {
+			if node.Type == types.NodeBind && node.StrValue == name {
+				return true
+			}
+		}
+	}
+	return false
+}
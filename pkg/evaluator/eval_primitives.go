@@ -42,7 +42,32 @@ func (e *Evaluator) evalBoolean(node *types.ASTNode) (interface{}, error) {
 
 func (e *Evaluator) evalName(node *types.ASTNode, evalCtx *EvalContext) (interface{}, error) {
 	name := node.StrValue
-	return e.evalNameString(name, evalCtx)
+	value, err := e.evalNameString(name, evalCtx)
+	if value == nil && err == nil && e.opts.StrictNavigation && !node.Optional && missingField(evalCtx.Data(), name) {
+		return nil, types.NewError(types.ErrMissingField, fmt.Sprintf("the field %q does not exist (use ?. to allow it to be missing)", name), node.Position)
+	}
+	return value, err
+}
+
+// missingField reports whether data is an object without the field name, or an
+// array holding one, for WithStrictNavigation. Other values have no fields to
+// miss.
+func missingField(data interface{}, name string) bool {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		_, exists := d[name]
+		return !exists
+	case *OrderedObject:
+		_, exists := d.Get(name)
+		return !exists
+	case []interface{}:
+		for _, item := range d {
+			if missingField(item, name) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (e *Evaluator) evalNameString(name string, evalCtx *EvalContext) (interface{}, error) {
@@ -54,7 +79,7 @@ func (e *Evaluator) evalNameString(name string, evalCtx *EvalContext) (interface
 			if value == nil {
 				return types.NullValue, nil
 			}
-			return value, nil
+			return collectSeq(value), nil
 		}
 	}
 	if obj, ok := data.(*OrderedObject); ok {
@@ -62,7 +87,7 @@ func (e *Evaluator) evalNameString(name string, evalCtx *EvalContext) (interface
 			if value == nil {
 				return types.NullValue, nil
 			}
-			return value, nil
+			return collectSeq(value), nil
 		}
 	}
 	if arr, ok := data.([]interface{}); ok {
@@ -70,6 +95,7 @@ func (e *Evaluator) evalNameString(name string, evalCtx *EvalContext) (interface
 		for _, item := range arr {
 			if obj, ok := item.(map[string]interface{}); ok {
 				if value, exists := obj[name]; exists {
+					value = collectSeq(value)
 					if subArr, isArr := value.([]interface{}); isArr {
 						result = append(result, subArr...)
 					} else {
@@ -78,6 +104,7 @@ func (e *Evaluator) evalNameString(name string, evalCtx *EvalContext) (interface
 				}
 			} else if obj, ok := item.(*OrderedObject); ok {
 				if value, exists := obj.Get(name); exists {
+					value = collectSeq(value)
 					if subArr, isArr := value.([]interface{}); isArr {
 						result = append(result, subArr...)
 					} else {
@@ -131,8 +158,8 @@ func (e *Evaluator) evalVariable(node *types.ASTNode, evalCtx *EvalContext) (int
 	// Named variable - check bindings
 	value, found := evalCtx.GetBinding(varName)
 	if !found {
-		// If a built-in function exists with this name, return it as a value
-		if fnDef, ok := GetFunction(varName); ok {
+		// If a function exists with this name, return it as a value
+		if fnDef, ok := e.LookupFunction(varName); ok {
 			return fnDef, nil
 		}
 		// Per JSONata spec: undefined variables return nil (undefined), not error
@@ -0,0 +1,80 @@
+package evaluator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// PanicError reports a panic recovered during an evaluation: a bug in the
+// evaluator or in a custom function rather than an error in the expression.
+// Eval and the other entry points return it instead of letting the panic
+// crash the host.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// ExpressionHash identifies the expression without revealing it: the
+	// first 16 hex digits of the SHA-256 of its source.
+	ExpressionHash string
+	// Position is the source offset of the innermost node being evaluated
+	// when the panic happened, or -1 when it happened outside of any node.
+	Position int
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+// Error implements the error interface.
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("internal error at position %d evaluating expression %s: %v",
+		p.Position, p.ExpressionHash, p.Value)
+}
+
+// Unwrap returns the value passed to panic when it is an error.
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// recoverPanic converts a panic into a PanicError stored in *err, located at
+// the innermost node of the evaluation of ctx (see evalDepth). It must be
+// deferred directly: by the entry points, and by the goroutines evaluating
+// nodes for them, which would otherwise crash the host. Nodes do not recover
+// on their own, which keeps the evaluation of each node free of a defer.
+func recoverPanic(ctx context.Context, err *error) {
+	if r := recover(); r != nil {
+		position := -1
+		if d := getEvalDepth(ctx); d != nil {
+			if d.caller {
+				panic(r)
+			}
+			position = d.pos
+		}
+		*err = &PanicError{Value: r, Position: position, Stack: debug.Stack()}
+	}
+}
+
+// callerCode calls fn, code of the caller running in the middle of the
+// evaluation of ctx, such as the body of a range loop over EvalIter. A panic
+// in fn is the caller's: it goes on unchanged rather than being converted
+// into a PanicError.
+func callerCode(ctx context.Context, fn func() bool) bool {
+	d := getEvalDepth(ctx)
+	d.caller = true
+	ok := fn()
+	d.caller = false
+	return ok
+}
+
+// identifyPanic sets the expression hash of a PanicError in err's chain.
+func identifyPanic(err error, expr *types.Expression) {
+	var p *PanicError
+	if errors.As(err, &p) && p.ExpressionHash == "" {
+		sum := sha256.Sum256([]byte(expr.Source()))
+		p.ExpressionHash = hex.EncodeToString(sum[:8])
+	}
+}
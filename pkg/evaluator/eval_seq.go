@@ -0,0 +1,150 @@
+package evaluator
+
+import (
+	"context"
+	"iter"
+	"slices"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Go iterators as input data.
+//
+// Data produced by generators or database cursors can be passed without
+// building it first: an iter.Seq[any] stands for an array and an
+// iter.Seq2[string, any] for an object, as the input, as a variable bound to
+// an evaluation, or as the value of a field. A sequence is collected when the
+// evaluation reaches it, so a field that is never read is never pulled.
+//
+// EvalIter streams a filter over a sequence, `$[pred]` on a sequence input or
+// `field[pred]` on a field holding one: items are pulled one at a time and
+// the sequence is abandoned as soon as the consumer stops.
+
+// asSeq returns v as a sequence of items if it is one.
+func asSeq(v interface{}) (iter.Seq[any], bool) {
+	switch s := v.(type) {
+	case iter.Seq[any]:
+		return s, true
+	case func(func(any) bool):
+		return s, true
+	}
+	return nil, false
+}
+
+// asSeq2 returns v as a sequence of key/value pairs if it is one.
+func asSeq2(v interface{}) (iter.Seq2[string, any], bool) {
+	switch s := v.(type) {
+	case iter.Seq2[string, any]:
+		return s, true
+	case func(func(string, any) bool):
+		return s, true
+	}
+	return nil, false
+}
+
+// isSeq reports whether v is a Go iterator standing for an array or an object.
+func isSeq(v interface{}) bool {
+	_, ok := asSeq(v)
+	if !ok {
+		_, ok = asSeq2(v)
+	}
+	return ok
+}
+
+// collectSeq returns v with its sequences collected: a sequence of items
+// becomes an array and a sequence of pairs an object keeping the keys in the
+// order they were first yielded, the last value of a repeated key winning.
+// Sequences among the collected values are collected too. Other values are
+// returned as they are.
+func collectSeq(v interface{}) interface{} {
+	if s, ok := asSeq(v); ok {
+		arr := []interface{}{}
+		for item := range s {
+			arr = append(arr, collectSeq(item))
+		}
+		return arr
+	}
+	if s, ok := asSeq2(v); ok {
+		obj := &OrderedObject{Values: make(map[string]interface{})}
+		for k, item := range s {
+			if _, seen := obj.Values[k]; !seen {
+				obj.Keys = append(obj.Keys, k)
+			}
+			obj.Values[k] = collectSeq(item)
+		}
+		return obj
+	}
+	return v
+}
+
+// lazySeq returns the sequence of items read by node, `$` or a field name,
+// without collecting it, or false when node reads anything else.
+func lazySeq(node *types.ASTNode, evalCtx *EvalContext) (iter.Seq[any], bool) {
+	var v interface{}
+	switch {
+	case node.Type == types.NodeVariable && node.StrValue == "":
+		v = evalCtx.Data()
+	case node.Type == types.NodeName:
+		switch obj := evalCtx.Data().(type) {
+		case map[string]interface{}:
+			v = obj[node.StrValue]
+		case *OrderedObject:
+			v, _ = obj.Get(node.StrValue)
+		}
+	}
+	s, ok := asSeq(v)
+	if !ok {
+		return nil, false
+	}
+	return func(yield func(any) bool) {
+		for item := range s {
+			if !yield(collectSeq(item)) {
+				return
+			}
+		}
+	}, true
+}
+
+// filterSource returns the items a lazy filter runs over: those of the
+// sequence read by node, pulled as they are consumed (see lazySeq), or those
+// of the value of node.
+func (e *Evaluator) filterSource(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext) (iter.Seq[any], error) {
+	if s, ok := lazySeq(node, evalCtx); ok {
+		return s, nil
+	}
+	collection, err := e.evalNode(ctx, node, evalCtx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Values(sequenceItems(collection)), nil
+}
+
+// streamsInput reports whether EvalIter can leave data, a sequence, to be
+// pulled by a lazy filter `$[pred]` instead of collecting it first: nothing
+// else in expr may read the input as a whole through $$.
+func streamsInput(data interface{}, expr, prefix, filter *types.ASTNode) bool {
+	if _, ok := asSeq(data); !ok || prefix != nil {
+		return false
+	}
+	lhs := filter.LHS
+	return lhs.Type == types.NodeVariable && lhs.StrValue == "" && !readsRoot(expr)
+}
+
+// readsRoot reports whether node contains a $$ reference.
+func readsRoot(node *types.ASTNode) bool {
+	if node == nil {
+		return false
+	}
+	if node.Type == types.NodeVariable && node.StrValue == "$" {
+		return true
+	}
+	if readsRoot(node.LHS) || readsRoot(node.RHS) {
+		return true
+	}
+	for _, list := range [][]*types.ASTNode{node.Steps, node.Arguments, node.Expressions} {
+		if slices.ContainsFunc(list, readsRoot) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package evaluator
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Sharded evaluation of a root array (WithShardSize).
+//
+// When the input is a large array and the expression maps a step over it
+// ($.{...}, $.(...), $$.field), the items are split into contiguous shards,
+// one per goroutine, each evaluating the step for its items into its own
+// range of a value slice. evalPath then merges the values in item order,
+// exactly as the sequential loop does, so sharding never changes a result.
+//
+// Shards share the evaluation root, whose lazily built state (filter indexes,
+// the seeded random source, loaded documents, memoized lambdas...) is not safe
+// for concurrent use. Only steps that cannot reach that state are sharded: see
+// shardSafe.
+
+// unshardableFunctions are the built-ins that keep state on the evaluation
+// root or evaluate arbitrary code.
+var unshardableFunctions = map[string]bool{
+	"random": true, "shuffle": true, "doc": true, "eval": true,
+}
+
+// shardable reports whether the step rhs over arr can be evaluated in shards:
+// sharding is enabled, arr is the root input evaluated from the top level of
+// the expression (not from within an item of it), it is large enough for two
+// shards and rhs is safe to evaluate concurrently.
+func (e *Evaluator) shardable(rhs *types.ASTNode, arr []interface{}, evalCtx *EvalContext) bool {
+	if e.opts.ShardSize <= 0 || !e.opts.Concurrency || len(arr) < 2*e.opts.ShardSize || runtime.GOMAXPROCS(0) < 2 {
+		return false
+	}
+	if evalCtx.root.profile != nil || e.opts.DebugHook != nil || !sameArray(arr, evalCtx.root.data) || !sameArray(arr, evalCtx.data) {
+		return false
+	}
+	return e.shardSafe(rhs, evalCtx)
+}
+
+// sameArray reports whether v is the array arr, not just an equal one.
+func sameArray(arr []interface{}, v interface{}) bool {
+	other, ok := v.([]interface{})
+	return ok && len(other) == len(arr) && len(arr) > 0 && &other[0] == &arr[0]
+}
+
+// shardSafe reports whether node only evaluates to values derived from its
+// context item and bindings, without touching the state kept on the
+// evaluation root. Function values are ruled out altogether: creating one
+// (lambdas, partial applications, compositions) marks the root, and calling
+// one may run custom code.
+func (e *Evaluator) shardSafe(node *types.ASTNode, evalCtx *EvalContext) bool {
+	if node == nil {
+		return true
+	}
+	switch node.Type {
+	case types.NodeLambda, types.NodePartial, types.NodePlaceholder, types.NodeTransform,
+		types.NodeImport, types.NodeParent:
+		return false
+	case types.NodeObject:
+		// Shared key slices and the object arena are kept on the root (see
+		// shareObjectKeys, objectArena).
+		if e.opts.InternStrings || e.opts.ObjectArena {
+			return false
+		}
+	case types.NodeFilter:
+		// Hash indexes are kept on the root (see evalIndexedFilter).
+		if e.opts.AutoIndex {
+			return false
+		}
+	case types.NodeVariable:
+		if node.StrValue == "" || node.StrValue == "$" {
+			return true
+		}
+		value, bound := evalCtx.GetBinding(node.StrValue)
+		if !bound {
+			// A function used as a value.
+			_, isFunction := e.functions[node.StrValue]
+			return !isFunction
+		}
+		switch value.(type) {
+		case *Lambda, *FunctionDef:
+			return false
+		}
+		return true
+	case types.NodeFunction:
+		if !e.shardSafeCall(node.LHS, evalCtx) {
+			return false
+		}
+		for _, arg := range node.Arguments {
+			if !e.shardSafe(arg, evalCtx) {
+				return false
+			}
+		}
+		return true
+	case types.NodeBinary:
+		if node.StrValue != "~>" {
+			break
+		}
+		// x ~> $f and x ~> $f(args) call $f; anything else composes functions.
+		if !e.shardSafe(node.LHS, evalCtx) || node.RHS == nil {
+			return false
+		}
+		switch node.RHS.Type {
+		case types.NodeVariable:
+			return e.shardSafeCall(node.RHS, evalCtx)
+		case types.NodeFunction:
+			return e.shardSafe(node.RHS, evalCtx)
+		}
+		return false
+	}
+	for _, child := range []*types.ASTNode{node.LHS, node.RHS} {
+		if !e.shardSafe(child, evalCtx) {
+			return false
+		}
+	}
+	for _, list := range [][]*types.ASTNode{node.Steps, node.Arguments, node.Expressions} {
+		for _, child := range list {
+			if !e.shardSafe(child, evalCtx) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// shardSafeCall reports whether fn, the callee of a function call, names a
+// built-in that is safe to call from a shard.
+func (e *Evaluator) shardSafeCall(fn *types.ASTNode, evalCtx *EvalContext) bool {
+	if fn == nil || fn.Type != types.NodeVariable || unshardableFunctions[fn.StrValue] {
+		return false
+	}
+	if _, bound := evalCtx.GetBinding(fn.StrValue); bound {
+		return false
+	}
+	def, ok := e.functions[fn.StrValue]
+	return ok && def == builtinFunctions[fn.StrValue]
+}
+
+// evalShards evaluates the step rhs for each item of arr, in shards of at
+// least ShardSize items on up to GOMAXPROCS goroutines, and returns the value
+// of each item. The error returned is the one of the first failing item in
+// shard order.
+func (e *Evaluator) evalShards(ctx context.Context, rhs *types.ASTNode, arr []interface{}, evalCtx *EvalContext) ([]interface{}, error) {
+	// Fix the time of $now() and $millis() before the shards read it.
+	e.now(evalCtx)
+
+	shards := min(runtime.GOMAXPROCS(0), len(arr)/e.opts.ShardSize)
+	chunk := (len(arr) + shards - 1) / shards
+	values := make([]interface{}, len(arr))
+	errs := make([]error, shards)
+	var wg sync.WaitGroup
+	for s := 0; s < shards; s++ {
+		from, to := s*chunk, min((s+1)*chunk, len(arr))
+		if from >= to {
+			break
+		}
+		wg.Add(1)
+		go func(s, from, to int) {
+			defer wg.Done()
+			ctx := withForkedEvalDepth(ctx)
+			defer recoverPanic(ctx, &errs[s])
+			for i := from; i < to; i++ {
+				itemCtx := acquireEvalCtx(arr[i], evalCtx, true)
+				value, err := e.evalPathStep(ctx, rhs, itemCtx, arr[i])
+				if err != nil {
+					errs[s] = err
+					return
+				}
+				releaseEvalCtx(itemCtx)
+				values[i] = value
+			}
+		}(s, from, to)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -2,7 +2,6 @@ package evaluator
 
 import (
 	"context"
-	"sort"
 
 	"github.com/sandrolain/gosonata/pkg/types"
 )
@@ -73,107 +72,33 @@ func (e *Evaluator) evalSort(ctx context.Context, node *types.ASTNode, evalCtx *
 		return items, nil
 	}
 
-	// Pre-evaluate all sort keys for all items
-	type itemKeys struct {
-		value interface{}
-		keys  []interface{}
+	exprs := make([]*types.ASTNode, len(sortSpecs))
+	for i, spec := range sortSpecs {
+		exprs[i] = spec.expr
 	}
 
-	sortData := make([]itemKeys, len(items))
-	for idx, item := range items {
-		if item == nil {
-			sortData[idx] = itemKeys{value: item, keys: make([]interface{}, len(sortSpecs))}
-			continue
-		}
-
-		// Extract actual value and bindings from contextBoundValue if present
-		actualSortItem, sortBindings := extractBoundItem(item)
-		itemCtx := evalCtx.NewChildContext(actualSortItem)
-		if len(sortBindings) > 0 {
-			applyBindingsToCtx(itemCtx, sortBindings)
-		}
-		keys := make([]interface{}, len(sortSpecs))
-
-		for specIdx, spec := range sortSpecs {
-			key, err := e.evalNode(ctx, spec.expr, itemCtx)
-			if err != nil {
-				return nil, err
-			}
-			keys[specIdx] = key
-		}
-
-		sortData[idx] = itemKeys{value: item, keys: keys}
-	}
-
-	// Validate sort key types: all non-nil keys for a given spec must be the same type
-	// and must be strings or numbers (T2007/T2008). Nil keys sort last (treated as undefined).
-	for specIdx := range sortSpecs {
-		var firstType string
-		for _, sd := range sortData {
-			key := sd.keys[specIdx]
-			if key == nil {
-				continue // nil sorts last, no error
-			}
-			var keyType string
-			switch key.(type) {
-			case float64, int:
-				keyType = "number"
-			case string:
-				keyType = "string"
-			default:
-				return nil, types.NewError(types.ErrSortNotComparable, "argument to sort must be a string or number", -1)
-			}
-			if firstType == "" {
-				firstType = keyType
-			} else if firstType != keyType {
-				return nil, types.NewError(types.ErrSortMixedTypes, "sort arguments must be of the same type", -1)
-			}
-		}
+	// Pre-evaluate all sort keys for all items (in parallel for large inputs).
+	keys, err := e.evalSortKeys(ctx, items, exprs, evalCtx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Sort using all sort keys (stable, lexicographic on multiple keys)
-	var sortErr error
-	sort.SliceStable(sortData, func(i, j int) bool {
-		if sortErr != nil {
-			return false
-		}
-
-		for specIdx, spec := range sortSpecs {
-			ki := sortData[i].keys[specIdx]
-			kj := sortData[j].keys[specIdx]
-
-			// Nil values go to end
-			if ki == nil && kj == nil {
-				continue
-			}
-			if ki == nil {
-				return false
-			}
-			if kj == nil {
-				return true
-			}
-
-			cmp := compareValues(ki, kj)
-			if cmp == 0 {
-				continue // Tie in this key, check next key
-			}
-
-			if spec.ascending {
-				return cmp < 0
-			}
-			return cmp > 0
+	// Extract typed key columns. All non-nil keys for a given spec must be the same
+	// type and must be strings or numbers (T2007/T2008). Nil keys sort last.
+	columns := make([]sortColumn, len(sortSpecs))
+	for specIdx, spec := range sortSpecs {
+		col, err := newSortColumn(keys, len(items), len(sortSpecs), specIdx, spec.ascending)
+		if err != nil {
+			return nil, err
 		}
-		return false // All keys equal
-	})
-
-	if sortErr != nil {
-		return nil, sortErr
+		columns[specIdx] = col
 	}
 
-	// Extract sorted values
-	result := make([]interface{}, len(sortData))
-	for i, sd := range sortData {
-		result[i] = sd.value
+	// Sort a permutation (stable, lexicographic on multiple keys) and apply it.
+	order := sortPermutation(columns, len(items))
+	result := make([]interface{}, len(items))
+	for i, idx := range order {
+		result[i] = items[idx]
 	}
 
 	// Singleton unwrap: if input was single item, return single item
@@ -186,33 +111,65 @@ func (e *Evaluator) evalSort(ctx context.Context, node *types.ASTNode, evalCtx *
 
 // deepClone performs a deep copy of a JSON-like value.
 // Maps and slices are cloned recursively; scalars are returned as-is (value types).
+// Self-referencing data fails with D1010 and copies larger than maxCloneNodes
+// values with D1011 (see dataGuard).
+
+func deepClone(v interface{}) (interface{}, error) {
+	g := dataGuard{maxNodes: maxCloneNodes}
+	return cloneValue(v, &g)
+}
 
-func deepClone(v interface{}) interface{} {
+func cloneValue(v interface{}, g *dataGuard) (interface{}, error) {
 	switch val := v.(type) {
 	case map[string]interface{}:
+		if err := g.enter(val); err != nil {
+			return nil, err
+		}
+		defer g.leave(val)
 		clone := make(map[string]interface{}, len(val))
 		for k, v2 := range val {
-			clone[k] = deepClone(v2)
+			c, err := cloneValue(v2, g)
+			if err != nil {
+				return nil, err
+			}
+			clone[k] = c
 		}
-		return clone
+		return clone, nil
 	case *OrderedObject:
+		if err := g.enter(val); err != nil {
+			return nil, err
+		}
+		defer g.leave(val)
 		clone := &OrderedObject{
 			Keys:   make([]string, len(val.Keys)),
 			Values: make(map[string]interface{}, len(val.Values)),
 		}
 		copy(clone.Keys, val.Keys)
 		for k, v2 := range val.Values {
-			clone.Values[k] = deepClone(v2)
+			c, err := cloneValue(v2, g)
+			if err != nil {
+				return nil, err
+			}
+			clone.Values[k] = c
 		}
-		return clone
+		return clone, nil
 	case []interface{}:
+		if err := g.enter(val); err != nil {
+			return nil, err
+		}
+		defer g.leave(val)
 		clone := make([]interface{}, len(val))
 		for i, v2 := range val {
-			clone[i] = deepClone(v2)
+			c, err := cloneValue(v2, g)
+			if err != nil {
+				return nil, err
+			}
+			clone[i] = c
 		}
-		return clone
+		return clone, nil
 	default:
-		return val // scalars (nil, bool, float64, string, etc.) are value types
+		// scalars (nil, bool, float64, string, etc.) are value types
+		return val, g.count()
 	}
 }
 
@@ -255,8 +212,16 @@ func (e *Evaluator) evalTransformNode(ctx context.Context, data interface{}, nod
 		return nil, nil
 	}
 
-	// Deep clone the data to avoid mutating the original
-	cloned := deepClone(data)
+	// Deep clone the data to avoid mutating the original, unless the caller
+	// allowed in-place updates (WithAllowInPlace) and the evaluation reads no
+	// Frozen value, which the data could belong to.
+	cloned := data
+	if !e.opts.AllowInPlace || len(evalCtx.root.frozen) > 0 {
+		var err error
+		if cloned, err = deepClone(data); err != nil {
+			return nil, err
+		}
+	}
 
 	path := node.LHS   // path expression to locate matching nodes
 	update := node.RHS // update object expression
@@ -288,8 +253,15 @@ func (e *Evaluator) evalTransformNode(ctx context.Context, data interface{}, nod
 
 	// Apply update/delete to each matched node
 	for _, matchedNode := range matchList {
-		// Unwrap contextBoundValues - transforms need to mutate the actual objects
-		matchedNode = unwrapCVsDeep(matchedNode)
+		// Unwrap contextBoundValues: transforms mutate the matched objects of
+		// the cloned tree themselves, not copies of them.
+		for {
+			cv, ok := matchedNode.(*contextBoundValue)
+			if !ok {
+				break
+			}
+			matchedNode = cv.value
+		}
 		// Evaluate update expression in context of matched node
 		matchCtx := evalCtx.NewChildContext(matchedNode)
 		updateVal, err := e.evalNode(ctx, update, matchCtx)
@@ -361,8 +333,9 @@ func (e *Evaluator) evalTransformNode(ctx context.Context, data interface{}, nod
 			for _, f := range delFields {
 				if _, exists := matchedObj.Values[f]; exists {
 					delete(matchedObj.Values, f)
-					// Remove from Keys slice
-					newKeys := matchedObj.Keys[:0]
+					// Remove from Keys slice. Keys may be shared with other
+					// objects (see shareObjectKeys): never edit it in place.
+					newKeys := make([]string, 0, len(matchedObj.Keys)-1)
 					for _, k := range matchedObj.Keys {
 						if k != f {
 							newKeys = append(newKeys, k)
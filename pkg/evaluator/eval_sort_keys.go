@@ -0,0 +1,329 @@
+package evaluator
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Sort machinery for the ^(...) operator.
+//
+// Keys are evaluated once into a flat slice, then converted into one typed column
+// per sort term ([]float64 or []string), so comparisons never go through interface
+// boxing. The items themselves are never moved while sorting: a permutation of
+// indexes is sorted instead, with
+//   - an LSD radix sort for a single numeric term on large inputs, and
+//   - a buffered bottom-up merge sort otherwise.
+// Both are stable, matching the JSONata specification.
+
+const (
+	// parallelSortKeyThreshold is the input size from which key expressions are
+	// evaluated on several goroutines (WithConcurrency must be enabled).
+	parallelSortKeyThreshold = 8192
+	// radixSortThreshold is the input size from which a single numeric term is
+	// sorted with a radix sort instead of a comparison sort.
+	radixSortThreshold = 256
+	// mergeSortRun is the length of the insertion-sorted runs of the merge sort.
+	mergeSortRun = 24
+)
+
+// evalSortKeys evaluates every sort expression against every item. The result is
+// a flat slice where the key of term s for item i is at i*len(exprs)+s.
+func (e *Evaluator) evalSortKeys(ctx context.Context, items []interface{}, exprs []*types.ASTNode, evalCtx *EvalContext) ([]interface{}, error) {
+	keys := make([]interface{}, len(items)*len(exprs))
+
+	evalRange := func(ctx context.Context, from, to int) error {
+		for idx := from; idx < to; idx++ {
+			item := items[idx]
+			if item == nil {
+				continue
+			}
+			// Extract actual value and bindings from contextBoundValue if present
+			actualSortItem, sortBindings := extractBoundItem(item)
+			itemCtx := evalCtx.NewChildContext(actualSortItem)
+			if len(sortBindings) > 0 {
+				applyBindingsToCtx(itemCtx, sortBindings)
+			}
+			for specIdx, expr := range exprs {
+				key, err := e.evalNode(ctx, expr, itemCtx)
+				if err != nil {
+					return err
+				}
+				keys[idx*len(exprs)+specIdx] = key
+			}
+		}
+		return nil
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if !e.opts.Concurrency || evalCtx.root.profile != nil || e.opts.DebugHook != nil || workers < 2 || len(items) < parallelSortKeyThreshold || !sortKeysParallelSafe(exprs) {
+		return keys, evalRange(ctx, 0, len(items))
+	}
+
+	// Each worker owns a disjoint range of items and key slots; the shared
+	// evalCtx is only read (NewChildContext and binding lookups).
+	chunk := (len(items) + workers - 1) / workers
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		from, to := w*chunk, min((w+1)*chunk, len(items))
+		if from >= to {
+			break
+		}
+		wg.Add(1)
+		go func(w, from, to int) {
+			defer wg.Done()
+			ctx := withForkedEvalDepth(ctx)
+			defer recoverPanic(ctx, &errs[w])
+			errs[w] = evalRange(ctx, from, to)
+		}(w, from, to)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// sortKeysParallelSafe reports whether the sort expressions can be evaluated
+// concurrently. Only side-effect-free node kinds that never touch state shared
+// through the evaluation root (lambdas, $now(), filter indexes, ...) qualify:
+// field paths, variables, literals and operators over them.
+func sortKeysParallelSafe(exprs []*types.ASTNode) bool {
+	for _, expr := range exprs {
+		if !isPlainKeyExpr(expr) {
+			return false
+		}
+	}
+	return true
+}
+
+func isPlainKeyExpr(node *types.ASTNode) bool {
+	if node == nil {
+		return true
+	}
+	switch node.Type {
+	case types.NodeName, types.NodeVariable, types.NodeString, types.NodeNumber:
+		return true
+	case types.NodePath:
+		return isPlainKeyExpr(node.LHS) && isPlainKeyExpr(node.RHS)
+	case types.NodeBinary:
+		switch node.StrValue {
+		case "+", "-", "*", "/", "%", "&":
+			return isPlainKeyExpr(node.LHS) && isPlainKeyExpr(node.RHS)
+		}
+	case types.NodeUnary:
+		return node.StrValue == "-" && isPlainKeyExpr(node.LHS)
+	}
+	return false
+}
+
+// sortColumn holds the keys of one sort term in typed form.
+type sortColumn struct {
+	ascending bool
+	isString  bool
+	nums      []float64
+	strs      []string
+	missing   []bool // undefined key: sorts last in either direction
+}
+
+// newSortColumn extracts term specIdx from the flat key slice and checks that all
+// defined keys are numbers or all are strings.
+func newSortColumn(keys []interface{}, n, stride, specIdx int, ascending bool) (sortColumn, error) {
+	col := sortColumn{ascending: ascending, missing: make([]bool, n)}
+	kind := ""
+	for i := 0; i < n; i++ {
+		key := keys[i*stride+specIdx]
+		var keyKind string
+		switch key.(type) {
+		case nil:
+			col.missing[i] = true
+			continue
+		case float64, int:
+			keyKind = "number"
+		case string:
+			keyKind = "string"
+		default:
+			return col, types.NewError(types.ErrSortNotComparable, "argument to sort must be a string or number", -1)
+		}
+		if kind == "" {
+			kind = keyKind
+			if kind == "string" {
+				col.isString = true
+				col.strs = make([]string, n)
+			} else {
+				col.nums = make([]float64, n)
+			}
+		} else if kind != keyKind {
+			return col, types.NewError(types.ErrSortMixedTypes, "sort arguments must be of the same type", -1)
+		}
+		switch k := key.(type) {
+		case float64:
+			col.nums[i] = k
+		case int:
+			col.nums[i] = float64(k)
+		case string:
+			col.strs[i] = k
+		}
+	}
+	return col, nil
+}
+
+// compare orders items i and j on this term, honouring the direction.
+func (c *sortColumn) compare(i, j int) int {
+	mi, mj := c.missing[i], c.missing[j]
+	switch {
+	case mi && mj:
+		return 0
+	case mi:
+		return 1
+	case mj:
+		return -1
+	}
+	var cmp int
+	if c.isString {
+		switch a, b := c.strs[i], c.strs[j]; {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	} else {
+		switch a, b := c.nums[i], c.nums[j]; {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	}
+	if !c.ascending {
+		return -cmp
+	}
+	return cmp
+}
+
+// sortPermutation returns the stable sorted order of n items under columns.
+func sortPermutation(columns []sortColumn, n int) []int {
+	if len(columns) == 1 && !columns[0].isString && n >= radixSortThreshold {
+		return radixSortNumbers(&columns[0], n)
+	}
+	return mergeSortPermutation(n, func(i, j int) int {
+		for c := range columns {
+			if cmp := columns[c].compare(i, j); cmp != 0 {
+				return cmp
+			}
+		}
+		return 0
+	})
+}
+
+// mergeSortPermutation is a stable bottom-up merge sort of the indexes 0..n-1.
+// Runs of mergeSortRun items are insertion-sorted first; each merge pass then
+// ping-pongs between two buffers, so the total cost is O(n log n) comparisons
+// with a single extra allocation.
+func mergeSortPermutation(n int, cmp func(i, j int) int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	for lo := 0; lo < n; lo += mergeSortRun {
+		hi := min(lo+mergeSortRun, n)
+		for i := lo + 1; i < hi; i++ {
+			for j := i; j > lo && cmp(order[j], order[j-1]) < 0; j-- {
+				order[j], order[j-1] = order[j-1], order[j]
+			}
+		}
+	}
+	if n <= mergeSortRun {
+		return order
+	}
+
+	src, dst := order, make([]int, n)
+	for width := mergeSortRun; width < n; width *= 2 {
+		for lo := 0; lo < n; lo += 2 * width {
+			mid, hi := min(lo+width, n), min(lo+2*width, n)
+			i, j, k := lo, mid, lo
+			for i < mid && j < hi {
+				// Take from the right run only when strictly smaller: keeps stability.
+				if cmp(src[j], src[i]) < 0 {
+					dst[k] = src[j]
+					j++
+				} else {
+					dst[k] = src[i]
+					i++
+				}
+				k++
+			}
+			k += copy(dst[k:], src[i:mid])
+			copy(dst[k:], src[j:hi])
+		}
+		src, dst = dst, src
+	}
+	return src
+}
+
+// radixSortNumbers sorts a single numeric term with a stable LSD radix sort over
+// the order-preserving uint64 encoding of the keys. Undefined keys keep their
+// relative order after all defined ones.
+func radixSortNumbers(col *sortColumn, n int) []int {
+	order := make([]int, 0, n)
+	encoded := make([]uint64, 0, n)
+	var missing []int
+	for i := 0; i < n; i++ {
+		if col.missing[i] {
+			missing = append(missing, i)
+			continue
+		}
+		f := col.nums[i]
+		if f == 0 {
+			f = 0 // -0 and 0 compare equal: give them the same encoding
+		}
+		k := math.Float64bits(f)
+		if k>>63 == 1 {
+			k = ^k
+		} else {
+			k |= 1 << 63
+		}
+		if !col.ascending {
+			k = ^k
+		}
+		order = append(order, i)
+		encoded = append(encoded, k)
+	}
+
+	m := len(order)
+	if m == 0 {
+		return missing
+	}
+	tmpOrder, tmpEncoded := make([]int, m), make([]uint64, m)
+	var counts [256]int
+	for shift := uint(0); shift < 64; shift += 8 {
+		counts = [256]int{}
+		for _, k := range encoded {
+			counts[(k>>shift)&0xff]++
+		}
+		if counts[(encoded[0]>>shift)&0xff] == m {
+			continue // every key shares this byte: the pass would be a no-op
+		}
+		pos := 0
+		for b := range counts {
+			c := counts[b]
+			counts[b] = pos
+			pos += c
+		}
+		for i, k := range encoded {
+			b := (k >> shift) & 0xff
+			tmpOrder[counts[b]] = order[i]
+			tmpEncoded[counts[b]] = k
+			counts[b]++
+		}
+		order, tmpOrder = tmpOrder, order
+		encoded, tmpEncoded = tmpEncoded, encoded
+	}
+	return append(order, missing...)
+}
@@ -0,0 +1,96 @@
+package evaluator
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats are the usage counters of an Evaluator, kept when WithStats is enabled.
+// Platforms evaluating expressions on behalf of tenants typically give each
+// tenant its own Evaluator and read (or reset) its counters to enforce quotas.
+type Stats struct {
+	// Evaluations is the number of evaluations run, failed ones included.
+	Evaluations uint64
+	// Errors is the number of evaluations that failed, timeouts and
+	// cancellations included.
+	Errors uint64
+	// Nodes is the number of expression nodes evaluated, a measure of the work
+	// done that does not depend on the machine load.
+	Nodes uint64
+	// Time is the cumulative duration of the evaluations, from their start to
+	// their end. It is wall-clock time: it includes the time spent waiting in
+	// document loaders and custom functions, since Go does not measure the CPU
+	// time of a goroutine.
+	Time time.Duration
+}
+
+// StatsHook receives the Stats of each evaluation as it ends (Evaluations is 1
+// and Errors 1 if it failed). It is called on the goroutine that evaluated the
+// expression and must be safe for concurrent use.
+type StatsHook func(Stats)
+
+// evalStats holds the counters of an Evaluator, updated by concurrent
+// evaluations.
+type evalStats struct {
+	evaluations atomic.Uint64
+	errors      atomic.Uint64
+	nodes       atomic.Uint64
+	time        atomic.Int64
+}
+
+// Stats returns a snapshot of the counters of e, accumulated since it was
+// created or since the last ResetStats. It returns zero Stats unless
+// WithStats is enabled.
+//
+// Each counter is read atomically, but the snapshot is not: an evaluation
+// ending while Stats runs may be counted in some fields only.
+func (e *Evaluator) Stats() Stats {
+	if e.stats == nil {
+		return Stats{}
+	}
+	return Stats{
+		Evaluations: e.stats.evaluations.Load(),
+		Errors:      e.stats.errors.Load(),
+		Nodes:       e.stats.nodes.Load(),
+		Time:        time.Duration(e.stats.time.Load()),
+	}
+}
+
+// ResetStats sets the counters of e back to zero and returns their values
+// before the reset, so that a quota window can be closed without losing the
+// evaluations ending in between.
+func (e *Evaluator) ResetStats() Stats {
+	if e.stats == nil {
+		return Stats{}
+	}
+	return Stats{
+		Evaluations: e.stats.evaluations.Swap(0),
+		Errors:      e.stats.errors.Swap(0),
+		Nodes:       e.stats.nodes.Swap(0),
+		Time:        time.Duration(e.stats.time.Swap(0)),
+	}
+}
+
+// startStats starts accounting for the evaluation rooted at evalCtx and returns
+// the function that ends it, or nil when stats are disabled.
+func (e *Evaluator) startStats(evalCtx *EvalContext) func(err error) {
+	if e.stats == nil {
+		return nil
+	}
+	nodes := new(atomic.Uint64)
+	evalCtx.root.nodes = nodes
+	start := time.Now()
+	return func(err error) {
+		s := Stats{Evaluations: 1, Nodes: nodes.Load(), Time: time.Since(start)}
+		if err != nil {
+			s.Errors = 1
+		}
+		e.stats.evaluations.Add(1)
+		e.stats.errors.Add(s.Errors)
+		e.stats.nodes.Add(s.Nodes)
+		e.stats.time.Add(int64(s.Time))
+		if e.opts.StatsHook != nil {
+			e.opts.StatsHook(s)
+		}
+	}
+}
@@ -58,10 +58,19 @@ func (e *Evaluator) EvalStream(ctx context.Context, expr *types.Expression, r io
 			}
 
 			var data interface{}
-			if err := json.Unmarshal(raw, &data); err != nil {
+			var err error
+			if e.opts.OrderedInput {
+				data, err = UnmarshalOrdered(raw)
+			} else {
+				err = json.Unmarshal(raw, &data)
+			}
+			if err != nil {
 				ch <- StreamResult{Err: err}
 				return
 			}
+			if e.interner != nil {
+				data = e.interner.internValue(data)
+			}
 
 			result, err := e.Eval(ctx, expr, data)
 			ch <- StreamResult{Value: result, Err: err}
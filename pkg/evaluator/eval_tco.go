@@ -13,19 +13,42 @@ type tcoThunk struct {
 	args   []interface{}
 }
 
-// getRecurseDepthPtr returns the depth counter pointer from the context, creating one if absent.
+// evalDepth tracks the live nesting of an evaluation, stack-style: counters
+// are incremented on entry and decremented on exit. nodes counts nested
+// evalNode calls, bounded by MaxNestingDepth; calls counts nested lambda
+// invocations, bounded by MaxDepth. Tail calls run in the trampoline of
+// callLambda and count once. pos is the position of the innermost node being
+// evaluated, -1 outside of any node, which locates a panic (see recoverPanic),
+// and caller is set while the caller's code runs (see callerCode).
+type evalDepth struct {
+	nodes  int
+	calls  int
+	pos    int
+	caller bool
+}
 
-func getRecurseDepthPtr(ctx context.Context) *int {
-	if p, ok := ctx.Value(recurseDepthKey{}).(*int); ok {
-		return p
+// getEvalDepth returns the depth counters of the evaluation, or nil outside of
+// an evaluation started by an entry point.
+func getEvalDepth(ctx context.Context) *evalDepth {
+	if d, ok := ctx.Value(recurseDepthKey{}).(*evalDepth); ok {
+		return d
 	}
 	return nil
 }
 
-// withNewRecurseDepthPtr returns a context that carries a fresh depth counter pointer.
+// withNewEvalDepth returns a context that carries fresh depth counters.
 // Call this once at the start of each top-level evaluation.
+func withNewEvalDepth(ctx context.Context) context.Context {
+	return context.WithValue(ctx, recurseDepthKey{}, &evalDepth{pos: -1})
+}
 
-func withNewRecurseDepthPtr(ctx context.Context) context.Context {
-	d := 0
-	return context.WithValue(ctx, recurseDepthKey{}, &d)
+// withForkedEvalDepth returns a context carrying a copy of the depth counters
+// of ctx, for a branch of the evaluation running on another goroutine: the
+// counters are not safe for concurrent use.
+func withForkedEvalDepth(ctx context.Context) context.Context {
+	if d := getEvalDepth(ctx); d != nil {
+		fork := *d
+		return context.WithValue(ctx, recurseDepthKey{}, &fork)
+	}
+	return ctx
 }
@@ -1,107 +1,41 @@
 package evaluator
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"math"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/sandrolain/gosonata/pkg/types"
 )
 
+// isTruthy returns the effective boolean value of a value, as $boolean does
+// and as the conditions of and, or, ?:, the conditional operator and filter
+// predicates use it (the spec's "casting to Boolean"):
+//
+//   - undefined, null, false, "", 0, empty arrays and empty objects are false;
+//   - functions (lambdas, built-ins, regular expressions) are false;
+//   - an array is true when at least one of its items is, so [0] and
+//     [false, ""] are false.
+//
+// Other values are true.
 func (e *Evaluator) isTruthy(value interface{}) bool {
-	if value == nil {
-		return false
-	}
-
 	switch v := value.(type) {
-	case bool:
-		return v
-	case string:
-		return v != ""
-	case float64:
-		return v != 0
-	case int:
-		return v != 0
-	case types.Null:
-		return false
-	case []interface{}:
-		return len(v) > 0
-	case map[string]interface{}:
-		return len(v) > 0
-	case *OrderedObject:
-		return len(v.Values) > 0
-	default:
-		return true
-	}
-}
-
-// isTruthyBoolean implements the $boolean() function semantics:
-// - Functions are always false
-// - Arrays are true only if they contain at least one truthy element (recursively)
-// - All other rules same as isTruthy
-
-func (e *Evaluator) isTruthyBoolean(value interface{}) bool {
-	if value == nil {
+	case nil, types.Null:
 		return false
-	}
-
-	switch v := value.(type) {
 	case bool:
 		return v
 	case string:
 		return v != ""
-	case float64:
-		return v != 0
-	case int:
-		return v != 0
-	case types.Null:
-		return false
-	case []interface{}:
-		// Array is true only if at least one element is truthy (recursively)
-		for _, item := range v {
-			if e.isTruthyBoolean(item) {
-				return true
-			}
-		}
-		return false
-	case map[string]interface{}:
+	case []byte:
 		return len(v) > 0
-	case *OrderedObject:
-		return len(v.Values) > 0
-	case *Lambda, *FunctionDef:
-		// Functions are always falsy in $boolean() context
-		return false
-	default:
-		return true
-	}
-}
-
-// isTruthyForDefault determines if a value is truthy for the default operator (?:).
-// This has special semantics: arrays are truthy only if they contain at least one truthy value,
-// and functions are considered falsy.
-
-func (e *Evaluator) isTruthyForDefault(value interface{}) bool {
-	if value == nil {
-		return false
-	}
-
-	switch v := value.(type) {
-	case bool:
-		return v
-	case string:
-		return v != ""
-	case float64:
-		return v != 0
-	case int:
-		return v != 0
-	case types.Null:
-		return false
 	case []interface{}:
-		// Array is truthy only if it contains at least one truthy element (recursively)
 		for _, item := range v {
-			if e.isTruthyForDefault(item) {
+			if e.isTruthy(item) {
 				return true
 			}
 		}
@@ -110,13 +44,15 @@ func (e *Evaluator) isTruthyForDefault(value interface{}) bool {
 		return len(v) > 0
 	case *OrderedObject:
 		return len(v.Values) > 0
-	case *Lambda:
-		// Functions are falsy for the default operator
-		return false
-	default:
-		// Other types (including functions) are considered falsy
+	case *contextBoundValue:
+		return e.isTruthy(v.value)
+	case *Lambda, *FunctionDef, *regexp.Regexp:
 		return false
 	}
+	if n, ok := numberValue(value); ok {
+		return n != 0
+	}
+	return true
 }
 
 // toArray converts a value to an array.
@@ -135,6 +71,18 @@ func (e *Evaluator) toArray(value interface{}) ([]interface{}, error) {
 	return []interface{}{value}, nil
 }
 
+// sortedKeys returns the keys of m in ascending order. A decoded JSON object has
+// lost its document order; sorting gives operators that enumerate its values
+// (*, **) the same output on every run. *OrderedObject values keep their Keys.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // toNumber converts a value to a number.
 
 func (e *Evaluator) toNumber(value interface{}) (float64, error) {
@@ -195,6 +143,8 @@ func (e *Evaluator) toString(value interface{}) string {
 		return "null"
 	case string:
 		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
 	case float64:
 		if math.IsNaN(v) || math.IsInf(v, 0) {
 			return ""
@@ -242,81 +192,58 @@ func (e *Evaluator) roundNumberForJSON(v float64) float64 {
 	return rounded
 }
 
-// removeExponentLeadingZero removes the single leading zero that strconv.FormatFloat
-// adds to the exponent for 1-digit exponents (e.g. "1e-07" → "1e-7", "1e+07" → "1e+7").
-// It performs a single linear scan instead of four strings.ReplaceAll calls. (OPT-07)
-func removeExponentLeadingZero(s string) string {
-	for i := 0; i < len(s)-2; i++ {
-		if (s[i] == 'e' || s[i] == 'E') &&
-			(s[i+1] == '+' || s[i+1] == '-') &&
-			s[i+2] == '0' {
-			return s[:i+2] + s[i+3:]
-		}
-	}
-	return s
-}
-
-// formatNumberForString formats numbers with JSONata's exponent rules.
+// formatNumberForString formats a number as JSONata's $string does: rounded to
+// 15 significant digits, then converted like JavaScript's Number→String.
 
 func (e *Evaluator) formatNumberForString(v float64) string {
-	rounded := e.roundNumberForJSON(v)
-	abs := math.Abs(rounded)
-	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
-		str := strconv.FormatFloat(rounded, 'g', -1, 64)
-		// OPT-07: single-pass removal of leading zero from exponent (e.g. 1e-07 → 1e-7)
-		// strconv.FormatFloat with 'g' produces at most one leading zero in the exponent.
-		str = removeExponentLeadingZero(str)
-		return str
-	}
-
-	str := strconv.FormatFloat(rounded, 'f', 15, 64)
-
-	// Handle floating-point artifacts: if we see patterns like ...9999... or ...0000...
-	// these are likely precision errors. Common patterns:
-	// 90.569999999999993 → should be 90.57
-	// 245.789999999999992 → should be 245.79
-	str = e.cleanFloatingPointArtifacts(str, rounded)
+	return formatJSNumber(e.roundNumberForJSON(v))
+}
 
-	str = strings.TrimRight(str, "0")
-	str = strings.TrimRight(str, ".")
-	if str == "" || str == "-0" {
+// formatJSNumber implements the Number::toString algorithm of ECMAScript: the
+// shortest decimal that round-trips to v, in positional notation when
+// 1e-7 < |v| < 1e21 and in exponential notation ("1e+21", "1.5e-7") otherwise.
+func formatJSNumber(v float64) string {
+	if v == 0 {
 		return "0"
 	}
-	return str
-}
-
-// cleanFloatingPointArtifacts removes floating-point representation errors.
-// E.g., 90.569999999999993 → 90.57, 245.789999999999992 → 245.79
-
-func (e *Evaluator) cleanFloatingPointArtifacts(str string, rounded float64) string {
-	// Look for patterns of many repeated 9s or 0s
-	// Pattern: find '9999' (4 or more 9s) or '0000' (4 or more 0s) as indicators of floating-point errors
-	if idx := strings.Index(str, "9999"); idx >= 0 {
-		// Try rounding to fewer decimal places
-		parts := strings.Split(str, ".")
-		if len(parts) == 2 {
-			// Round up at the position before the 9s
-			decimalPos := idx - len(parts[0]) - 1
-			if decimalPos > 0 && decimalPos < len(parts[1]) {
-				// Round to one less decimal place
-				factor := math.Pow(10, float64(decimalPos))
-				roundedUp := math.Round(rounded*factor) / factor
-				return strconv.FormatFloat(roundedUp, 'f', decimalPos, 64)
-			}
+	// The shortest digits d1...dk and the exponent n such that
+	// |v| = 0.d1...dk × 10^n.
+	s := strconv.FormatFloat(math.Abs(v), 'e', -1, 64)
+	mant, exp, _ := strings.Cut(s, "e")
+	digits := strings.Replace(mant, ".", "", 1)
+	x, _ := strconv.Atoi(exp)
+	n, k := x+1, len(digits)
+
+	var b strings.Builder
+	b.Grow(k + 8)
+	if v < 0 {
+		b.WriteByte('-')
+	}
+	switch {
+	case k <= n && n <= 21:
+		b.WriteString(digits)
+		b.WriteString(strings.Repeat("0", n-k))
+	case 0 < n && n <= 21:
+		b.WriteString(digits[:n])
+		b.WriteByte('.')
+		b.WriteString(digits[n:])
+	case -6 < n && n <= 0:
+		b.WriteString("0.")
+		b.WriteString(strings.Repeat("0", -n))
+		b.WriteString(digits)
+	default:
+		b.WriteByte(digits[0])
+		if k > 1 {
+			b.WriteByte('.')
+			b.WriteString(digits[1:])
 		}
-	} else if idx := strings.Index(str, "0000"); idx >= 0 && idx > len(strings.Split(str, ".")[0]) {
-		// For patterns like ...000001, truncate
-		parts := strings.Split(str, ".")
-		if len(parts) == 2 {
-			decimalPos := idx - len(parts[0]) - 1
-			if decimalPos > 0 && decimalPos < len(parts[1]) {
-				factor := math.Pow(10, float64(decimalPos))
-				roundedDown := math.Round(rounded*factor) / factor
-				return strconv.FormatFloat(roundedDown, 'f', decimalPos, 64)
-			}
+		b.WriteByte('e')
+		if n-1 >= 0 {
+			b.WriteByte('+')
 		}
+		b.WriteString(strconv.Itoa(n - 1))
 	}
-	return str
+	return b.String()
 }
 
 // Arithmetic operators
@@ -0,0 +1,254 @@
+package evaluator
+
+import (
+	"context"
+	"sort"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Typed values (EvalValue).
+//
+// Hosts that already hold their data as Go values have no use for a JSON
+// round trip, but working with interface{} results means a type switch at
+// every access. A Value is a tagged union: its kind is determined once, when
+// it is created, and its scalar payload is stored unboxed, so that reading
+// it is a field access. Arrays and objects wrap the evaluator's own
+// containers without copying them; their items are classified as they are
+// read.
+
+// Kind is the type of a Value.
+type Kind uint8
+
+// The kinds of Value, one per JSONata type.
+const (
+	KindUndefined Kind = iota
+	KindNull
+	KindBool
+	KindNumber
+	KindString
+	KindArray
+	KindObject
+	KindFunction
+	// KindOther is a Go value outside the JSON data model, such as a byte
+	// string or a value of the host passed through unchanged. Interface
+	// returns it.
+	KindOther
+)
+
+var kindNames = [...]string{
+	KindUndefined: "undefined", KindNull: "null", KindBool: "bool", KindNumber: "number",
+	KindString: "string", KindArray: "array", KindObject: "object", KindFunction: "function",
+	KindOther: "other",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "invalid"
+}
+
+// Value is a JSONata value of known kind. The zero Value is undefined.
+//
+// The accessors of a kind return the zero value of their type for a Value of
+// another kind, so that they can be called without checking Kind first when
+// the zero value will do.
+type Value struct {
+	kind Kind
+	b    bool
+	num  float64
+	str  string
+	ref  interface{} // containers, functions, other values, non-float64 numbers
+}
+
+// Null returns the JSON null value.
+func Null() Value { return Value{kind: KindNull} }
+
+// BoolValue returns the boolean b.
+func BoolValue(b bool) Value { return Value{kind: KindBool, b: b} }
+
+// NumberValue returns the number f.
+func NumberValue(f float64) Value { return Value{kind: KindNumber, num: f} }
+
+// StringValue returns the string s.
+func StringValue(s string) Value { return Value{kind: KindString, str: s} }
+
+// ArrayValue returns an array of items. Undefined items are left out, as in
+// an array constructor.
+func ArrayValue(items []Value) Value {
+	arr := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if item.kind != KindUndefined {
+			arr = append(arr, item.Interface())
+		}
+	}
+	return Value{kind: KindArray, ref: arr}
+}
+
+// ObjectValue returns an object with the given keys and values, in order.
+// values must have the length of keys; undefined values are left out, as in
+// an object constructor.
+func ObjectValue(keys []string, values []Value) Value {
+	obj := &OrderedObject{
+		Keys:   make([]string, 0, len(keys)),
+		Values: make(map[string]interface{}, len(keys)),
+	}
+	for i, key := range keys {
+		if values[i].kind == KindUndefined {
+			continue
+		}
+		if _, dup := obj.Values[key]; !dup {
+			obj.Keys = append(obj.Keys, key)
+		}
+		obj.Values[key] = values[i].Interface()
+	}
+	return Value{kind: KindObject, ref: obj}
+}
+
+// ValueOf classifies v, a value in the form Eval accepts or returns. Arrays
+// and objects are wrapped, not copied. A nil v is undefined; a nil item of an
+// array or object is null, as in the results of Eval.
+func ValueOf(v interface{}) Value {
+	switch x := v.(type) {
+	case nil:
+		return Value{}
+	case types.Null:
+		return Value{kind: KindNull}
+	case bool:
+		return Value{kind: KindBool, b: x}
+	case float64:
+		return Value{kind: KindNumber, num: x}
+	case int:
+		return Value{kind: KindNumber, num: float64(x), ref: v}
+	case int64:
+		return Value{kind: KindNumber, num: float64(x), ref: v}
+	case string:
+		return Value{kind: KindString, str: x}
+	case []interface{}:
+		return Value{kind: KindArray, ref: v}
+	case map[string]interface{}, *OrderedObject:
+		return Value{kind: KindObject, ref: v}
+	case *Lambda, *FunctionDef:
+		return Value{kind: KindFunction, ref: v}
+	case *Frozen:
+		return ValueOf(x.value)
+	}
+	return Value{kind: KindOther, ref: v}
+}
+
+// itemOf classifies an item of a container, where nil is null: undefined
+// items are never stored.
+func itemOf(v interface{}) Value {
+	if v == nil {
+		return Value{kind: KindNull}
+	}
+	return ValueOf(v)
+}
+
+// Kind returns the kind of v.
+func (v Value) Kind() Kind { return v.kind }
+
+// Bool returns the boolean of v, or false if v is not a boolean.
+func (v Value) Bool() bool { return v.b }
+
+// Number returns the number of v, or 0 if v is not a number.
+func (v Value) Number() float64 { return v.num }
+
+// String returns the string of v. Like reflect.Value.String, it returns
+// "<kind Value>" if v is not a string, rather than formatting v.
+func (v Value) String() string {
+	if v.kind != KindString {
+		return "<" + v.kind.String() + " Value>"
+	}
+	return v.str
+}
+
+// Len returns the number of items of an array or of keys of an object, and
+// 0 for any other value.
+func (v Value) Len() int {
+	switch x := v.ref.(type) {
+	case []interface{}:
+		return len(x)
+	case map[string]interface{}:
+		return len(x)
+	case *OrderedObject:
+		return len(x.Keys)
+	}
+	return 0
+}
+
+// Index returns the item i of an array, or undefined if v is not an array or
+// i is out of range.
+func (v Value) Index(i int) Value {
+	if arr, ok := v.ref.([]interface{}); ok && i >= 0 && i < len(arr) {
+		return itemOf(arr[i])
+	}
+	return Value{}
+}
+
+// Keys returns the keys of an object, in order (sorted for a Go map), or nil
+// if v is not an object. The slice must not be modified.
+func (v Value) Keys() []string {
+	switch x := v.ref.(type) {
+	case *OrderedObject:
+		return x.Keys
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for key := range x {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	return nil
+}
+
+// Get returns the value of key in an object, or undefined if v is not an
+// object or has no such key.
+func (v Value) Get(key string) Value {
+	switch x := v.ref.(type) {
+	case *OrderedObject:
+		if item, ok := x.Values[key]; ok {
+			return itemOf(item)
+		}
+	case map[string]interface{}:
+		if item, ok := x[key]; ok {
+			return itemOf(item)
+		}
+	}
+	return Value{}
+}
+
+// Interface returns v in the form Eval accepts and returns.
+func (v Value) Interface() interface{} {
+	switch v.kind {
+	case KindUndefined:
+		return nil
+	case KindNull:
+		return types.NullValue
+	case KindBool:
+		return v.b
+	case KindNumber:
+		if v.ref != nil {
+			return v.ref
+		}
+		return v.num
+	case KindString:
+		return v.str
+	}
+	return v.ref
+}
+
+// EvalValue evaluates expr against input like Eval, for hosts working with
+// typed values instead of interface{} (see Value). Nothing is serialized: the
+// containers of input are read in place and those of the result are returned
+// wrapped. As with Eval, a null result is undefined unless WithNullHandling
+// is NullAsValue.
+func (e *Evaluator) EvalValue(ctx context.Context, expr *types.Expression, input Value) (Value, error) {
+	result, err := e.Eval(ctx, expr, input.Interface())
+	if err != nil {
+		return Value{}, err
+	}
+	return ValueOf(result), nil
+}
@@ -0,0 +1,153 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Strict variables (WithStrictVariables).
+//
+// Before an evaluation, the expression is checked for variables that no scope
+// can bind when they are read. A variable is bound by an enclosing block or
+// lambda: by a := evaluated before it in the block (or in the enclosing one),
+// or by a parameter. Lambda bodies run after the whole block defining them may
+// have been evaluated, so they also see the names bound later in the enclosing
+// blocks, which makes recursive and mutually recursive definitions valid. The
+// variables bound with @ and # are accepted anywhere, as their scope follows
+// the path steps. Outside the expression, a variable may name a function of
+// the evaluator, a prelude definition or a binding of EvalWithBindings.
+
+// varScope is a scope of the variables check.
+type varScope struct {
+	parent *varScope
+	// bound are the names bound so far in the scope.
+	bound map[string]bool
+	// later are the names bound anywhere in the scope, visible from the
+	// lambdas defined in it.
+	later map[string]bool
+	// lambda marks the scope of the parameters of a lambda.
+	lambda bool
+}
+
+// visible reports whether name is bound when read in scope s.
+func (s *varScope) visible(name string) bool {
+	inLambda := false
+	for ; s != nil; s = s.parent {
+		if s.bound[name] || (inLambda && s.later[name]) {
+			return true
+		}
+		if s.lambda {
+			inLambda = true
+		}
+	}
+	return false
+}
+
+// bind binds name in s from now on.
+func (s *varScope) bind(name string) {
+	if s.bound == nil {
+		s.bound = map[string]bool{}
+	}
+	s.bound[name] = true
+}
+
+// checkVariables returns a U1001 error for the first variable of expr that
+// cannot be bound when it is evaluated in evalCtx.
+func (e *Evaluator) checkVariables(expr *types.Expression, evalCtx *EvalContext) error {
+	positional := map[string]bool{}
+	collectPositionalNames(expr.AST(), positional)
+	known := func(name string) bool {
+		if positional[name] || e.KnownFunction(name) {
+			return true
+		}
+		_, bound := evalCtx.GetBinding(name)
+		return bound
+	}
+	return checkScopeVariables(expr.AST(), &varScope{later: map[string]bool{}}, known)
+}
+
+// collectPositionalNames adds to names the variables bound with @ and # in
+// node.
+func collectPositionalNames(node *types.ASTNode, names map[string]bool) {
+	if node == nil || node.Type == types.NodeImport {
+		return
+	}
+	if (node.Type == types.NodeContext || node.Type == types.NodeIndex) && node.RHS != nil {
+		names[node.RHS.StrValue] = true
+	}
+	for _, child := range []*types.ASTNode{node.LHS, node.RHS} {
+		collectPositionalNames(child, names)
+	}
+	for _, list := range [][]*types.ASTNode{node.Steps, node.Arguments, node.Expressions} {
+		for _, child := range list {
+			collectPositionalNames(child, names)
+		}
+	}
+}
+
+func checkScopeVariables(node *types.ASTNode, scope *varScope, known func(string) bool) error {
+	if node == nil {
+		return nil
+	}
+	switch node.Type {
+	case types.NodeImport:
+		// Modules are checked as expressions of their own.
+		return nil
+
+	case types.NodeVariable:
+		name := node.StrValue
+		if name == "" || name == "$" || scope.visible(name) || known(name) {
+			return nil
+		}
+		return types.NewError(types.ErrUndefinedVariable,
+			fmt.Sprintf("variable $%s is not bound", name), node.Position)
+
+	case types.NodeBind:
+		// The value may be a lambda calling itself.
+		scope.later[node.StrValue] = true
+		if err := checkScopeVariables(node.RHS, scope, known); err != nil {
+			return err
+		}
+		scope.bind(node.StrValue)
+		return nil
+
+	case types.NodeBlock:
+		block := &varScope{parent: scope, later: map[string]bool{}}
+		for _, expr := range node.Expressions {
+			if expr.Type == types.NodeBind {
+				block.later[expr.StrValue] = true
+			}
+		}
+		for _, expr := range node.Expressions {
+			if err := checkScopeVariables(expr, block, known); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case types.NodeLambda:
+		lambda := &varScope{parent: scope, later: map[string]bool{}, lambda: true}
+		for _, param := range node.Arguments {
+			lambda.bind(param.StrValue)
+		}
+		return checkScopeVariables(node.RHS, lambda, known)
+
+	case types.NodeContext, types.NodeIndex:
+		return checkScopeVariables(node.LHS, scope, known)
+	}
+
+	for _, child := range []*types.ASTNode{node.LHS, node.RHS} {
+		if err := checkScopeVariables(child, scope, known); err != nil {
+			return err
+		}
+	}
+	for _, list := range [][]*types.ASTNode{node.Steps, node.Arguments, node.Expressions} {
+		for _, child := range list {
+			if err := checkScopeVariables(child, scope, known); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
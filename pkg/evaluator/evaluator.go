@@ -29,6 +29,8 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"math"
+	"slices"
 	"time"
 
 	"github.com/sandrolain/gosonata/pkg/cache"
@@ -41,7 +43,10 @@ type Evaluator struct {
 	opts      EvalOptions
 	logger    *slog.Logger
 	cache     *cache.Cache            // non-nil when Caching is enabled
-	customFns map[string]*FunctionDef // user-registered custom functions
+	functions map[string]*FunctionDef // function registry; see newFunctionRegistry
+	interner  *stringInterner         // non-nil when InternStrings is enabled
+	documents *documentCache          // non-nil when DocumentCache is enabled
+	stats     *evalStats              // non-nil when Stats is enabled
 }
 
 // EvalOptions configures evaluator behavior.
@@ -58,8 +63,18 @@ type EvalOptions struct {
 	Cache *cache.Cache
 	// Concurrency enables concurrent evaluation.
 	Concurrency bool
-	// MaxDepth limits recursion depth.
+	// ShardSize is the minimum number of items per shard when a root array
+	// is evaluated in shards (see WithShardSize); 0 disables sharding.
+	ShardSize int
+	// MaxDepth limits the nesting of function calls: lambdas calling
+	// themselves or each other (see WithMaxDepth).
 	MaxDepth int
+	// MaxNestingDepth limits the nesting of the evaluation of expression
+	// nodes (see WithMaxNestingDepth).
+	MaxNestingDepth int
+	// MaxStringLength limits the size in bytes of the strings built by
+	// $pad, $join, $replace and & (see WithMaxStringLength).
+	MaxStringLength int
 	// Timeout sets evaluation timeout.
 	Timeout time.Duration
 	// Debug enables debug logging.
@@ -71,6 +86,85 @@ type EvalOptions struct {
 	// AdvancedCustomFunctions holds higher-order user-defined functions that
 	// need to call back into the evaluator (e.g. $groupBy, $mapValues).
 	AdvancedCustomFunctions []functions.AdvancedCustomFunctionDef
+	// AutoIndex enables per-evaluation hash indexes for repeated equality
+	// filters on the same array (e.g. `items[id = $x]` inside `$map`).
+	AutoIndex bool
+	// InternStrings enables string interning for decoded documents and
+	// constructed object keys.
+	InternStrings bool
+	// NullHandling selects how JSON null appears in results.
+	NullHandling NullHandling
+	// IntegerResults returns whole numbers in results as int64.
+	IntegerResults bool
+	// ResultTransformers post-process every result, in order.
+	ResultTransformers []ResultTransformer
+	// AllowInPlace lets transforms update the input document in place
+	// instead of a copy of it.
+	AllowInPlace bool
+	// DisabledFunctions lists function names (without "$") removed from this
+	// evaluator's registry, built-in or custom.
+	DisabledFunctions []string
+	// DocumentLoader resolves the documents read by $doc(name).
+	DocumentLoader DocumentLoader
+	// DocumentCache keeps loaded documents across evaluations.
+	DocumentCache bool
+	// DocumentCacheTTL is how long a cached document is reused; 0 means until
+	// it is evicted.
+	DocumentCacheTTL time.Duration
+	// DocumentCacheSize bounds the number of cached documents. Defaults to 256.
+	DocumentCacheSize int
+	// Timezone is the default timezone of the date/time functions: an IANA
+	// name, "UTC" or a ±HHMM offset. Empty means UTC.
+	Timezone string
+	// FunctionShadowing lets EvalWithBindings bind the names of functions
+	// (see WithFunctionShadowing).
+	FunctionShadowing bool
+	// Clock supplies the time of $now and $millis and, when it has one, the
+	// default timezone (see WithClock). Nil means the system clock.
+	Clock Clock
+	// DebugHook is called wherever an evaluation pauses (see WithDebugger).
+	DebugHook DebugHook
+	// Breakpoints are the source offsets of the nodes the debugger pauses
+	// before.
+	Breakpoints []int
+	// Preludes are expressions whose top-level bindings are visible to every
+	// evaluation, in order.
+	Preludes []*types.Expression
+	// OrderedInput decodes the documents read by EvalStream with their key
+	// order preserved (see UnmarshalOrdered).
+	OrderedInput bool
+	// SeededRandom makes $random and $shuffle draw from a generator seeded
+	// with RandomSeed, anew for each evaluation.
+	SeededRandom bool
+	// RandomSeed is the seed used when SeededRandom is set.
+	RandomSeed uint64
+	// StringSemantics selects the units counted by $length, $substring and
+	// $pad.
+	StringSemantics StringSemantics
+	// JoinCoercion lets $join convert numbers and booleans to strings.
+	JoinCoercion bool
+	// Stats keeps the usage counters read by Evaluator.Stats.
+	Stats bool
+	// StatsHook receives the Stats of each evaluation; setting it enables
+	// Stats.
+	StatsHook StatsHook
+	// StrictNavigation makes a missing field an error, except on optional
+	// (?.) steps.
+	StrictNavigation bool
+	// ObjectArena allocates constructed objects from a per-evaluation arena
+	// recycled across evaluations (see WithObjectArena).
+	ObjectArena bool
+	// AssertMode selects what a failing $assert does.
+	AssertMode AssertMode
+	// MaxResultItems truncates array results to their first items (0 = no
+	// limit).
+	MaxResultItems int
+	// PartialResults makes EvalWithInfo return the items of the top-level
+	// array computed before a timeout (see WithPartialResults).
+	PartialResults bool
+	// StrictVariables rejects expressions reading variables that cannot be
+	// bound (see WithStrictVariables).
+	StrictVariables bool
 }
 
 // defaultConcurrency controls the default value of EvalOptions.Concurrency for
@@ -79,13 +173,20 @@ type EvalOptions struct {
 // evaluator_wasm.go to avoid deadlocks in the single-threaded JS event loop.
 var defaultConcurrency = true
 
+// pollDeadline, when set, is called with the cancellation check of every node
+// and returns an error once the evaluation must stop. It is set on WebAssembly
+// targets by evaluator_wasm.go.
+var pollDeadline func(ctx context.Context) error
+
 // New creates a new Evaluator with default options.
 func New(opts ...EvalOption) *Evaluator {
 	options := EvalOptions{
-		Caching:     false,              // Disabled by default
-		Concurrency: defaultConcurrency, // false on WASM targets
-		MaxDepth:    10000,
-		Timeout:     30 * time.Second,
+		Caching:         false,              // Disabled by default
+		Concurrency:     defaultConcurrency, // false on WASM targets
+		MaxDepth:        10000,
+		MaxNestingDepth: 100000,
+		MaxStringLength: 100_000_000,
+		Timeout:         30 * time.Second,
 	}
 
 	for _, opt := range opts {
@@ -108,38 +209,29 @@ func New(opts ...EvalOption) *Evaluator {
 		c = cache.New(size)
 	}
 
-	// Build custom function lookup map.
-	customFns := make(map[string]*FunctionDef, len(options.CustomFunctions)+len(options.AdvancedCustomFunctions))
-	for _, cfd := range options.CustomFunctions {
-		// Capture loop variable.
-		cfd := cfd
-		customFns[cfd.Name] = &FunctionDef{
-			Name:    cfd.Name,
-			MinArgs: 0,
-			MaxArgs: -1, // unlimited; type-checking done via Signature if set
-			Impl: func(ctx context.Context, _ *Evaluator, _ *EvalContext, args []interface{}) (interface{}, error) {
-				return cfd.Fn(ctx, args...)
-			},
-		}
+	var interner *stringInterner
+	if options.InternStrings {
+		interner = newStringInterner()
 	}
-	for _, cfd := range options.AdvancedCustomFunctions {
-		cfd := cfd
-		customFns[cfd.Name] = &FunctionDef{
-			Name:    cfd.Name,
-			MinArgs: 0,
-			MaxArgs: -1,
-			Impl: func(ctx context.Context, ev *Evaluator, ec *EvalContext, args []interface{}) (interface{}, error) {
-				caller := &callerAdapter{e: ev, ec: ec}
-				return cfd.Fn(ctx, caller, args...)
-			},
-		}
+
+	var documents *documentCache
+	if options.DocumentCache {
+		documents = newDocumentCache(options.DocumentCacheTTL, options.DocumentCacheSize)
+	}
+
+	var stats *evalStats
+	if options.Stats || options.StatsHook != nil {
+		stats = new(evalStats)
 	}
 
 	return &Evaluator{
 		opts:      options,
 		logger:    options.Logger,
 		cache:     c,
-		customFns: customFns,
+		functions: newFunctionRegistry(&options),
+		interner:  interner,
+		documents: documents,
+		stats:     stats,
 	}
 }
 
@@ -159,15 +251,47 @@ func (c *callerAdapter) Call(ctx context.Context, fn interface{}, args ...interf
 	return c.e.callHOFFn(ctx, c.ec, fn, args)
 }
 
-// getCustomFunction returns a user-defined custom function by name, or (nil, false).
-func (e *Evaluator) getCustomFunction(name string) (*FunctionDef, bool) {
-	if len(e.customFns) == 0 {
-		return nil, false
-	}
-	fn, ok := e.customFns[name]
+// LookupFunction resolves a function name (without "$") the way expressions
+// evaluated by e do: custom functions first, then built-ins, minus the names
+// disabled with WithoutFunctions.
+func (e *Evaluator) LookupFunction(name string) (*FunctionDef, bool) {
+	fn, ok := e.functions[name]
 	return fn, ok
 }
 
+// KnownFunction reports whether expressions evaluated by e can call name
+// (without "$") without binding it themselves: a function of e (see
+// LookupFunction) or a top-level definition of a prelude. Passed to
+// parser.WithKnownFunctions, it makes calls of unknown functions compile
+// errors.
+func (e *Evaluator) KnownFunction(name string) bool {
+	_, ok := e.functions[name]
+	return ok || e.preludeBinds(name)
+}
+
+// BuiltinFunction reports whether name (without "$") is a built-in function
+// of e, as the built-in registry defines it: not replaced by a custom
+// function, removed with WithoutFunctions or bound by a prelude, and without
+// WithFunctionShadowing, which lets bindings replace it. Passed to
+// parser.WithBuiltinFunctions, it lets the optimizer share calls of the pure
+// built-ins.
+func (e *Evaluator) BuiltinFunction(name string) bool {
+	initBuiltinFunctions()
+	fn, ok := e.functions[name]
+	return ok && fn == builtinFunctions[name] && !e.opts.FunctionShadowing && !e.preludeBinds(name)
+}
+
+// FunctionNames returns the names (without "$") of the functions of e, as
+// LookupFunction resolves them, in alphabetical order.
+func (e *Evaluator) FunctionNames() []string {
+	names := make([]string, 0, len(e.functions))
+	for name := range e.functions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
 // Eval evaluates an expression against data.
 func (e *Evaluator) Eval(ctx context.Context, expr *types.Expression, data interface{}) (interface{}, error) {
 	if expr == nil || expr.AST() == nil {
@@ -181,28 +305,121 @@ func (e *Evaluator) Eval(ctx context.Context, expr *types.Expression, data inter
 		defer cancel()
 	}
 
-	// Create evaluation context
-	evalCtx := NewContext(data)
+	return e.evalRoot(ctx, expr, NewContext(data))
+}
 
-	// Initialise a shared depth counter for this evaluation tree.
-	// evalNode increments/decrements it on every node visit (stack-style),
-	// matching the JSONata JS test runner's timeboxExpression semantics.
-	if e.opts.MaxDepth > 0 {
-		ctx = withNewRecurseDepthPtr(ctx)
+// evalRoot evaluates expr in the root context evalCtx and prepares the result
+// for the caller.
+func (e *Evaluator) evalRoot(ctx context.Context, expr *types.Expression, evalCtx *EvalContext) (interface{}, error) {
+	result, err := e.evalResult(ctx, expr, evalCtx)
+	if err != nil {
+		return nil, err
 	}
+	return e.transformResult(result)
+}
 
-	// Evaluate the AST
-	result, err := e.evalNode(ctx, expr.AST(), evalCtx)
+// evaluate runs eval as a top-level evaluation of expr in the root context
+// evalCtx, after the setup shared by the entry points: the depth counters, the
+// statistics, the check of strict variables and the prelude. A panic in eval
+// is returned as a PanicError, and every PanicError is tagged with the
+// expression. eval may call back into the caller's code with callerCode.
+func (e *Evaluator) evaluate(ctx context.Context, expr *types.Expression, evalCtx *EvalContext, eval func(ctx context.Context) error) (err error) {
+	if end := e.startStats(evalCtx); end != nil {
+		defer func() { end(err) }()
+	}
+
+	// Initialise the depth counters shared by this evaluation tree (see
+	// evalDepth).
+	ctx = withNewEvalDepth(ctx)
+
+	defer func() {
+		if err != nil {
+			identifyPanic(err, expr)
+		}
+	}()
+	defer recoverPanic(ctx, &err)
+
+	// The compile-time constants are folded into expr, but the expressions
+	// of $eval read them as variables.
+	for name, value := range expr.Constants() {
+		evalCtx.SetBinding(name, value)
+	}
+
+	if e.opts.StrictVariables {
+		if err := e.checkVariables(expr, evalCtx); err != nil {
+			return err
+		}
+	}
+
+	if err := e.bindPrelude(ctx, evalCtx); err != nil {
+		return err
+	}
+
+	return eval(ctx)
+}
+
+// evalResult is evalRoot without the WithResultTransformer hooks.
+func (e *Evaluator) evalResult(ctx context.Context, expr *types.Expression, evalCtx *EvalContext) (result interface{}, err error) {
+	err = e.evaluate(ctx, expr, evalCtx, func(ctx context.Context) error {
+		result, err = e.evalTree(ctx, expr, evalCtx)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	return result, nil
+}
+
+// evalTree evaluates the tree of expr and converts the result for the caller.
+func (e *Evaluator) evalTree(ctx context.Context, expr *types.Expression, evalCtx *EvalContext) (result interface{}, err error) {
+	// Constructed objects come from an arena released once the result has
+	// been copied out of it (see objectArena).
+	if e.opts.ObjectArena && !e.opts.AllowInPlace {
+		evalCtx.objects = acquireObjectArena()
+		defer func() {
+			if !evalCtx.closures {
+				releaseObjectArena(evalCtx.objects)
+			}
+			evalCtx.objects = nil
+		}()
+	}
+
+	// Evaluate the AST
+	result, err = e.evalNode(ctx, expr.AST(), evalCtx)
+	if err != nil {
+		// Past the deadline, the items computed by the top-level step make a
+		// partial result (see WithPartialResults).
+		if p := evalCtx.partial; p == nil || p.items == nil {
+			return nil, err
+		}
+		result, err = evalCtx.partial.items, nil
+	}
 
-	// Convert types.Null to nil before returning
-	result = e.convertNullToNil(result)
+	// Cut an array result to MaxResultItems before it is copied.
+	result = e.truncateResult(result, evalCtx)
 
 	// Unwrap any contextBoundValues that escaped to the top level
 	// (e.g. from @$var or #$var expressions at the end of a path with no further steps)
-	result = unwrapCVsDeep(result)
+	result, err = unwrapCVsDeep(result)
+	if err != nil {
+		return nil, err
+	}
+
+	// Represent nulls and numbers as the caller asked (WithNullHandling,
+	// WithIntegerResults). The containers of the result are copied, which
+	// also copies them out of the object arena.
+	result, err = e.outputValue(result, evalCtx.objects != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Detach returned function values from the rest of this evaluation's state.
+	if evalCtx.closures {
+		result, err = e.detachClosures(result)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// NOTE: Singleton-sequence unwrapping (returning the single item of a 1-element
 	// collection instead of the collection itself) is intentionally NOT done here.
@@ -215,6 +432,17 @@ func (e *Evaluator) Eval(ctx context.Context, expr *types.Expression, data inter
 	return result, nil
 }
 
+// transformResult applies the WithResultTransformer hooks, in order.
+func (e *Evaluator) transformResult(result interface{}) (interface{}, error) {
+	for _, transform := range e.opts.ResultTransformers {
+		var err error
+		if result, err = transform(result); err != nil {
+			return nil, fmt.Errorf("result transformer: %w", err)
+		}
+	}
+	return result, nil
+}
+
 // hasKeepArrayInASTChain checks if any node in the AST chain has KeepArray set.
 func hasKeepArrayInASTChain(node *types.ASTNode) bool {
 	if node == nil {
@@ -234,7 +462,13 @@ func hasKeepArrayInASTChain(node *types.ASTNode) bool {
 	return false
 }
 
-// EvalWithBindings evaluates an expression with custom variable bindings.
+// EvalWithBindings evaluates an expression with custom variable bindings,
+// named without the $. The bindings are checked first: a name that cannot be
+// written as a variable ($ and $$ included) or, without WithFunctionShadowing,
+// that names a function of e fails with a BindingError, and so does a value
+// that cannot be converted to the evaluator's data model. Structs, time.Time
+// values, slices, maps and numbers of any Go type are converted; bindings is
+// not modified.
 func (e *Evaluator) EvalWithBindings(ctx context.Context, expr *types.Expression, data interface{}, bindings map[string]interface{}) (interface{}, error) {
 	if expr == nil || expr.AST() == nil {
 		return nil, fmt.Errorf("invalid expression")
@@ -247,28 +481,15 @@ func (e *Evaluator) EvalWithBindings(ctx context.Context, expr *types.Expression
 		defer cancel()
 	}
 
-	// Create evaluation context with bindings
-	evalCtx := NewContext(data)
-	evalCtx.SetBindings(bindings)
-
-	// Initialise a shared depth counter for this evaluation tree.
-	if e.opts.MaxDepth > 0 {
-		ctx = withNewRecurseDepthPtr(ctx)
-	}
-
-	// Evaluate the AST
-	result, err := e.evalNode(ctx, expr.AST(), evalCtx)
+	bindings, err := e.checkBindings(bindings)
 	if err != nil {
 		return nil, err
 	}
 
-	// Convert types.Null to nil before returning
-	result = e.convertNullToNil(result)
-
-	// Unwrap any contextBoundValues that escaped to the top level
-	result = unwrapCVsDeep(result)
-
-	return result, nil
+	// Create evaluation context with bindings
+	evalCtx := NewContext(data)
+	evalCtx.SetBindings(bindings)
+	return e.evalRoot(ctx, expr, evalCtx)
 }
 
 // EvalOption configures evaluation behavior.
@@ -306,6 +527,20 @@ func WithConcurrency(enabled bool) EvalOption {
 	}
 }
 
+// WithShardSize lets the evaluator split a large root array into shards of at
+// least n items, evaluated on separate goroutines, when the expression starts
+// by mapping a step over it: $.{...}, $.(...), $.$string(). The results are
+// merged in item order, so they are the same as without sharding. Sharding
+// needs WithConcurrency, an input of at least 2n items and a step that only
+// calls built-in functions other than $random, $shuffle, $doc and $eval, and
+// creates no functions; other steps are evaluated sequentially. n <= 0
+// disables sharding, the default.
+func WithShardSize(n int) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.ShardSize = n
+	}
+}
+
 // WithTimeout sets the evaluation timeout.
 func WithTimeout(timeout time.Duration) EvalOption {
 	return func(opts *EvalOptions) {
@@ -327,13 +562,377 @@ func WithLogger(logger *slog.Logger) EvalOption {
 	}
 }
 
-// WithMaxDepth sets the maximum recursion depth.
+// WithAutoIndex enables or disables automatic filter indexes.
+// When enabled, a predicate of the form `array[field = value]` (value being a
+// literal or a variable path) that is evaluated more than once against the same
+// array builds a hash index on field for the rest of the evaluation, turning
+// nested lookups such as `$map(orders, function($o){ customers[id = $o.cust] })`
+// from O(n*m) into O(n+m). Results are identical to the unindexed filter.
+func WithAutoIndex(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.AutoIndex = enabled
+	}
+}
+
+// WithStringInterning enables or disables string interning.
+// When enabled, the evaluator keeps a single shared copy of short repeated strings
+// for its whole lifetime: documents decoded by EvalStream have their keys and
+// string values interned, and objects built by the same object constructor share
+// one read-only Keys slice. This reduces the steady-state memory of long-running
+// services that process many similar documents. Results must not be modified in
+// place while interning is enabled.
+func WithStringInterning(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.InternStrings = enabled
+	}
+}
+
+// WithOrderedInput enables or disables order-preserving decoding of the
+// documents read by EvalStream: objects are decoded as *OrderedObject, so that
+// $keys, $spread, $each, the wildcards and the results follow the key order of
+// the document instead of ascending key order. To evaluate a document decoded
+// by the caller the same way, decode it with UnmarshalOrdered.
+func WithOrderedInput(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.OrderedInput = enabled
+	}
+}
+
+// NullHandling selects how JSON null is represented in evaluation results.
+type NullHandling int
+
+const (
+	// NullAsNil returns null as nil, like undefined. This is the default.
+	NullAsNil NullHandling = iota
+	// NullAsValue returns null as types.NullValue, so that a null result or
+	// array item can be told apart from undefined (nil).
+	NullAsValue
+	// NullDrop removes null-valued fields from the objects of a result; other
+	// nulls are returned as nil.
+	NullDrop
+)
+
+// WithNullHandling selects how JSON null appears in results. It is applied at
+// the output boundary, to the whole result, whether a null comes from the
+// input or from the expression. Evaluation itself always keeps null distinct
+// from undefined.
+func WithNullHandling(mode NullHandling) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.NullHandling = mode
+	}
+}
+
+// StringSemantics selects how $length, $substring and $pad measure strings.
+type StringSemantics int
+
+const (
+	// StringRunes counts Unicode code points, as the JSONata reference does:
+	// an emoji outside the Basic Multilingual Plane is one character. This is
+	// the default.
+	StringRunes StringSemantics = iota
+	// StringUTF16 counts UTF-16 code units, like JavaScript's String.length:
+	// a character outside the Basic Multilingual Plane is two units, and a
+	// position between them splits it into U+FFFD.
+	StringUTF16
+)
+
+// WithStringSemantics selects the units in which $length, $substring and $pad
+// count characters and positions.
+func WithStringSemantics(mode StringSemantics) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.StringSemantics = mode
+	}
+}
+
+// WithJoinCoercion makes $join accept numbers and booleans besides strings,
+// converting them as $string does, so that $join(items.qty, ", ") needs no
+// $map(..., $string) wrapper. Other values still fail with T0412. By default
+// $join takes strings only, as the JSONata reference does.
+func WithJoinCoercion(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.JoinCoercion = enabled
+	}
+}
+
+// WithIntegerResults enables or disables int64 results for whole numbers.
+// JSONata numbers are float64 during evaluation; when enabled, every whole
+// number in a result (nested ones included) whose magnitude is at most 2^53 is
+// returned as int64 instead, for strongly-typed consumers of fields such as ids.
+// Fractional, larger and non-finite numbers stay float64.
+func WithIntegerResults(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.IntegerResults = enabled
+	}
+}
+
+// ResultTransformer post-processes an evaluation result before it is returned.
+type ResultTransformer func(result interface{}) (interface{}, error)
+
+// WithResultTransformer adds a hook applied to every result after evaluation,
+// null handling and context unwrapping, right before it is returned. Hosts use
+// it to normalize results centrally: turn OrderedObject into plain maps, whole
+// float64 numbers into int64, or redact fields. Hooks added by several calls run
+// in order, each receiving the previous one's output; an error fails the
+// evaluation. The result may share data with the input, which a hook must not
+// modify. EvalIter applies the hooks to each yielded item.
+func WithResultTransformer(fn ResultTransformer) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.ResultTransformers = append(opts.ResultTransformers, fn)
+	}
+}
+
+// WithAllowInPlace enables or disables in-place transforms.
+// By default evaluation never modifies the input document: a transform
+// (`~> |path|update, delete|`) first deep-copies the value it is applied to.
+// When enabled, transforms update and delete fields of the input itself,
+// saving the copy on large documents. Only enable it when the caller owns the
+// input and no longer needs it unchanged, and never when the same input is
+// evaluated concurrently.
+func WithAllowInPlace(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.AllowInPlace = enabled
+	}
+}
+
+// WithStats enables or disables the usage counters of the evaluator: the number
+// of evaluations and of failed ones, the expression nodes evaluated and the
+// time spent (see Stats). They let embedders enforce per-tenant quotas by
+// giving each tenant its own Evaluator and reading Evaluator.Stats, without
+// wrapping every call site. Counting nodes costs an atomic increment per node,
+// so stats are disabled by default.
+func WithStats(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.Stats = enabled
+	}
+}
+
+// WithStatsHook sets a function called at the end of every evaluation with its
+// own Stats, e.g. to reject a tenant's next request as soon as its quota is
+// spent. It enables WithStats.
+func WithStatsHook(hook StatsHook) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.StatsHook = hook
+	}
+}
+
+// WithStrictNavigation makes navigating to a field that an object does not have
+// fail with U1004, instead of evaluating to undefined, to catch misspelt field
+// names and drifting input schemas. Steps written with ?. (see
+// parser.ExtOptionalChaining) may still be missing, so that an expression
+// marks the fields it expects to be optional and runs unchanged under both
+// modes. Navigating into a value that is not an object is not an error.
+func WithStrictNavigation(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.StrictNavigation = enabled
+	}
+}
+
+// WithStrictVariables makes reading a variable that cannot be bound at that
+// point an error (U1001), reported before the evaluation starts, instead of
+// the undefined value of the JSONata specification, which silently hides
+// typos such as `$pric * Quantity`. A variable can be bound by the expression
+// itself (:=, lambda parameters, @ and #), by a prelude or by EvalWithBindings,
+// or name a function of the evaluator. The check follows the scopes of blocks
+// and lambdas; it does not evaluate anything, so a variable bound in a branch
+// that is not taken is still accepted.
+func WithStrictVariables(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.StrictVariables = enabled
+	}
+}
+
+// WithObjectArena makes the objects constructed during an evaluation come from
+// an arena owned by the evaluation: their structs, Keys slices and Values maps
+// are cleared and reused by the next evaluations once the evaluation ends,
+// instead of being left to the garbage collector. It reduces the GC load of
+// expressions constructing many small objects, most of them intermediate.
+//
+// The result is copied out of the arena before it is returned, so it stays
+// valid. Custom functions must not retain the objects they receive beyond
+// the call. The arena is not used with WithAllowInPlace, whose transforms may
+// store constructed objects into the input.
+func WithObjectArena(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.ObjectArena = enabled
+	}
+}
+
+// AssertMode selects what $assert does when its condition does not hold.
+type AssertMode int
+
+const (
+	// AssertEnforce fails the evaluation with D3141 (T0410 for a condition
+	// that is not a boolean). This is the default.
+	AssertEnforce AssertMode = iota
+	// AssertWarn logs the failure as a warning with the Logger and lets the
+	// evaluation go on, $assert returning undefined.
+	AssertWarn
+	// AssertIgnore makes $assert a no-op returning undefined.
+	AssertIgnore
+)
+
+// WithAssertMode selects what $assert does when its condition does not hold,
+// so that shared expressions can keep their assertions active in staging and
+// harmless in production. The arguments of $assert are evaluated in every
+// mode: their own errors still fail the evaluation.
+func WithAssertMode(mode AssertMode) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.AssertMode = mode
+	}
+}
+
+// WithMaxResultItems truncates an array result to its first n items (0, the
+// default, means no limit), for preview UIs that evaluate untrusted
+// expressions over big datasets and only show the first page. Only the
+// result array itself is truncated, not the arrays nested in it; EvalWithInfo
+// tells whether a result was. EvalIter stops after n items.
+func WithMaxResultItems(n int) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.MaxResultItems = n
+	}
+}
+
+// WithPartialResults makes EvalWithInfo return, when the evaluation runs out
+// of time, the items of the result computed until then instead of the
+// timeout error, with ResultInfo.Partial set. This holds for expressions that
+// map a step over an array or filter one at the top level, such as
+// orders.{"id": id, "score": $score($)} or orders[$score($) > 5], the items
+// being those of the array items evaluated before the deadline, in order.
+// Any other expression, or a deadline hit before the top-level step started,
+// still fails. Eval and the other methods are unaffected.
+func WithPartialResults(enabled bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.PartialResults = enabled
+	}
+}
+
+// WithDocumentLoader sets the function that resolves $doc(name), giving
+// expressions access to reference datasets (currency tables, country codes)
+// that are not part of the input. Each name is loaded at most once per
+// evaluation. Without a loader, $doc fails.
+func WithDocumentLoader(loader DocumentLoader) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.DocumentLoader = loader
+	}
+}
+
+// WithDocumentCache keeps the documents returned by the DocumentLoader across
+// evaluations, so that a busy service does not call the backing store for every
+// $doc. Entries are reused for ttl (forever when ttl is 0) and at most
+// maxEntries documents are kept, evicting the least recently used (256 when
+// maxEntries <= 0). Concurrent evaluations loading the same document share a
+// single loader call. Failed loads are not cached.
+func WithDocumentCache(ttl time.Duration, maxEntries int) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.DocumentCache = true
+		opts.DocumentCacheTTL = ttl
+		opts.DocumentCacheSize = maxEntries
+	}
+}
+
+// WithPrelude makes the top-level bindings of prelude, a compiled expression
+// such as a block of `$name := function(...) {...}` definitions, visible to
+// every expression evaluated by the evaluator. The prelude is compiled once and
+// its definitions are bound at the start of each evaluation, so helpers see the
+// input of that evaluation. Bindings passed to EvalWithBindings take precedence
+// over prelude definitions of the same name. WithPrelude can be given several
+// times; later preludes see the definitions of earlier ones.
+//
+// Example:
+//
+//	lib, err := parser.Compile(`(
+//	  $net := function($o) { $o.price * $o.qty };
+//	  $total := function($orders) { $sum($orders.$net($)) }
+//	)`)
+//	...
+//	eval := evaluator.New(evaluator.WithPrelude(lib))
+//	expr, err := parser.Compile(`$total(orders)`)
+//	...
+//	result, err := eval.Eval(ctx, expr, data)
+func WithPrelude(prelude *types.Expression) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.Preludes = append(opts.Preludes, prelude)
+	}
+}
+
+// WithTimezone sets the timezone used by the date/time functions when an
+// expression gives none: $now and $fromMillis format timestamps in it, and
+// $toMillis reads timestamps without an offset in it. name is an IANA timezone
+// database name such as "Europe/Rome", "UTC" or a ±HHMM offset. An unknown name
+// makes the date/time functions fail with D3110. The default is UTC.
+func WithTimezone(name string) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.Timezone = name
+	}
+}
+
+// WithFunctionShadowing lets EvalWithBindings bind a variable with the name of
+// a function of the evaluator, built-in or custom, which then hides the
+// function from the expression. Without it such a binding is rejected as a
+// likely mistake.
+func WithFunctionShadowing(allow bool) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.FunctionShadowing = allow
+	}
+}
+
+// WithClock makes the date/time functions read the time from clock instead of
+// the system clock: $now and $millis report clock.Now(), read once per
+// evaluation, and the timezone of clock.Location(), unless nil, replaces the
+// default timezone of WithTimezone. Tests use it to make time deterministic.
+func WithClock(clock Clock) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.Clock = clock
+	}
+}
+
+// WithRandomSeed makes $random and $shuffle deterministic: each evaluation
+// draws from its own generator seeded with seed, so an expression evaluated
+// against the same input returns the same result every time, even while
+// other evaluations run concurrently. Without it the numbers come from the
+// process-wide generator of math/rand/v2.
+func WithRandomSeed(seed uint64) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.SeededRandom = true
+		opts.RandomSeed = seed
+	}
+}
+
+// WithMaxDepth sets the maximum nesting of function calls: a lambda calling
+// itself, or lambdas calling each other, more than depth calls deep fails
+// with U1001, the reference implementation's stack overflow error. Calls in
+// tail position do not nest. The default is 10000; 0 disables the limit.
+//
+// Deeply nested data and expressions are bounded separately (see
+// WithMaxNestingDepth), so that traversing a large document does not count
+// against the recursion of the expression's functions.
 func WithMaxDepth(depth int) EvalOption {
 	return func(opts *EvalOptions) {
 		opts.MaxDepth = depth
 	}
 }
 
+// WithMaxNestingDepth sets the maximum nesting of expression evaluation: one
+// level per operator, path, predicate or call being evaluated inside another,
+// including inside function bodies. It guards the Go stack against runaway
+// evaluations that WithMaxDepth does not catch. The default is 100000; 0
+// disables the limit. Exceeding it fails with U1001.
+func WithMaxNestingDepth(depth int) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.MaxNestingDepth = depth
+	}
+}
+
+// WithMaxStringLength sets the maximum size in bytes of the strings that
+// $pad, $join, $replace and the & operator build, so that an expression such
+// as $pad("", 1e9) or a loop doubling a string fails with D1012 instead of
+// exhausting memory. The default is 100,000,000; 0 disables the limit.
+func WithMaxStringLength(n int) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.MaxStringLength = n
+	}
+}
+
 // WithCustomFunction registers a user-defined function with the evaluator.
 // name is the function name without the leading "$" (the expression must use "$name" to call it).
 // signature is an optional JSONata type-signature string (e.g. "<s:s>") — pass "" to skip.
@@ -354,6 +953,16 @@ func WithCustomFunction(name, signature string, fn functions.CustomFunc) EvalOpt
 	}
 }
 
+// WithoutFunctions removes functions from the evaluator, e.g. to keep
+// expressions from calling $eval or $now. Names are given without the leading
+// "$" and may refer to built-in or custom functions. Calling a removed function
+// fails as if it did not exist.
+func WithoutFunctions(names ...string) EvalOption {
+	return func(opts *EvalOptions) {
+		opts.DisabledFunctions = append(opts.DisabledFunctions, names...)
+	}
+}
+
 // WithFunctions registers any mix of [functions.CustomFunctionDef] and
 // [functions.AdvancedCustomFunctionDef] in a single variadic call.
 // Both types implement the [functions.FunctionEntry] interface, so you can
@@ -380,35 +989,147 @@ func WithFunctions(defs ...functions.FunctionEntry) EvalOption {
 	}
 }
 
-// convertNullToNil recursively converts types.Null to nil in result values.
-// This is called at the final return to convert internal types.Null representation
-// (which is kept during evaluation to distinguish from undefined) to nil for external API.
-func (e *Evaluator) convertNullToNil(value interface{}) interface{} {
+// outputValue converts a result to its representation at the output boundary.
+// During evaluation JSON null is kept as types.Null, distinct from undefined
+// (nil), and every number is a float64; this applies the NullHandling mode
+// and, with WithIntegerResults, turns whole numbers into int64. Negative zero
+// is returned as 0. Null inside containers may also arrive as nil, from
+// decoded input.
+func (e *Evaluator) outputValue(value interface{}, copyKeys bool) (interface{}, error) {
+	c := outputConverter{nulls: e.opts.NullHandling, ints: e.opts.IntegerResults, copyKeys: copyKeys}
 	switch v := value.(type) {
 	case types.Null:
-		return nil
+		if c.nulls == NullAsValue {
+			return value, nil
+		}
+		return nil, nil
+	case float64:
+		return c.number(v), nil
+	case []interface{}, map[string]interface{}, *OrderedObject:
+		return c.convert(value)
+	default:
+		if isSeq(value) {
+			return c.convert(value)
+		}
+		return value, nil
+	}
+}
+
+// outputConverter copies the containers of a result, converting the values
+// inside them for the caller.
+type outputConverter struct {
+	nulls    NullHandling
+	ints     bool
+	copyKeys bool      // copy the Keys of objects, which may belong to an arena
+	g        dataGuard // detects cycles; results are not bounded in size
+}
+
+// maxExactInt is the largest magnitude below which every whole float64 is an
+// exact integer (2^53).
+const maxExactInt = 1 << 53
+
+// number returns f as an int64 when integer results are enabled and f is a
+// whole number in the exactly representable range. Negative zero becomes 0,
+// which is how JSON.stringify writes it and what -0 = 0 already says.
+func (c *outputConverter) number(f float64) interface{} {
+	if f == 0 {
+		f = 0
+	}
+	if c.ints && f == math.Trunc(f) && math.Abs(f) <= maxExactInt {
+		return int64(f)
+	}
+	return f
+}
+
+func (c *outputConverter) convert(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, types.Null:
+		if c.nulls == NullAsValue {
+			return types.NullValue, nil
+		}
+		return nil, nil
+	case float64:
+		return c.number(v), c.g.count()
 	case []interface{}:
+		if err := c.g.enter(v); err != nil {
+			return nil, err
+		}
+		defer c.g.leave(v)
 		result := make([]interface{}, len(v))
 		for i, item := range v {
-			result[i] = e.convertNullToNil(item)
+			converted, err := c.convert(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
 		}
-		return result
+		return result, nil
 	case map[string]interface{}:
+		if err := c.g.enter(v); err != nil {
+			return nil, err
+		}
+		defer c.g.leave(v)
 		result := make(map[string]interface{}, len(v))
 		for key, item := range v {
-			result[key] = e.convertNullToNil(item)
+			if c.nulls == NullDrop && isNull(item) {
+				continue
+			}
+			converted, err := c.convert(item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = converted
 		}
-		return result
+		return result, nil
 	case *OrderedObject:
+		if err := c.g.enter(v); err != nil {
+			return nil, err
+		}
+		defer c.g.leave(v)
 		result := &OrderedObject{
 			Keys:   v.Keys,
 			Values: make(map[string]interface{}, len(v.Values)),
 		}
+		if c.copyKeys {
+			result.Keys = slices.Clone(v.Keys)
+		}
+		dropped := false
 		for key, item := range v.Values {
-			result.Values[key] = e.convertNullToNil(item)
+			if c.nulls == NullDrop && isNull(item) {
+				dropped = true
+				continue
+			}
+			converted, err := c.convert(item)
+			if err != nil {
+				return nil, err
+			}
+			result.Values[key] = converted
+		}
+		if dropped {
+			keys := make([]string, 0, len(result.Values))
+			for _, key := range v.Keys {
+				if _, ok := result.Values[key]; ok {
+					keys = append(keys, key)
+				}
+			}
+			result.Keys = keys
 		}
-		return result
+		return result, nil
 	default:
-		return value
+		// A Go iterator left in the data, such as the field of an object
+		// returned whole, is collected into the result.
+		if isSeq(value) {
+			return c.convert(collectSeq(value))
+		}
+		return value, c.g.count()
 	}
 }
+
+// isNull reports whether an object value is JSON null.
+func isNull(v interface{}) bool {
+	switch v.(type) {
+	case nil, types.Null:
+		return true
+	}
+	return false
+}
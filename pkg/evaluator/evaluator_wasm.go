@@ -2,6 +2,11 @@
 
 package evaluator
 
+import (
+	"context"
+	"time"
+)
+
 // init sets WebAssembly-specific defaults for all Evaluators created in this
 // process.
 //
@@ -15,6 +20,28 @@ package evaluator
 // On wasip1 (Wasmtime, WasmEdge, Deno, etc.) the same conservative default
 // applies: the WASI threading proposal is still experimental and not yet
 // supported by the Go runtime.
+//
+// WebAssembly also has no preemption: the timer cancelling a context with a
+// deadline (WithTimeout, context.WithTimeout) cannot fire while an evaluation
+// runs, so the deadline is compared with the clock instead, every
+// deadlinePollInterval nodes.
 func init() {
 	defaultConcurrency = false
+	pollDeadline = wasmPollDeadline
+}
+
+const deadlinePollInterval = 256
+
+// polls counts the calls of wasmPollDeadline; WebAssembly runs a single thread.
+var polls uint
+
+func wasmPollDeadline(ctx context.Context) error {
+	polls++
+	if polls%deadlinePollInterval != 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
 }
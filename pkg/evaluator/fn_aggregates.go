@@ -11,14 +11,14 @@ func fnSum(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 		return nil, nil
 	}
 
-	arr, err := e.toArray(args[0])
+	arr, err := e.toArray(boundValue(args[0]))
 	if err != nil {
 		return nil, err
 	}
 
 	sum := 0.0
 	for _, v := range arr {
-		num, err := e.toNumber(v)
+		num, err := e.toNumber(boundValue(v))
 		if err != nil {
 			return nil, err
 		}
@@ -33,7 +33,7 @@ func fnCount(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 		return 0.0, nil
 	}
 
-	arr, err := e.toArray(args[0])
+	arr, err := e.toArray(boundValue(args[0]))
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +42,7 @@ func fnCount(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 }
 
 func fnAverage(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
-	arr, err := e.toArray(args[0])
+	arr, err := e.toArray(boundValue(args[0]))
 	if err != nil {
 		return nil, err
 	}
@@ -53,14 +53,14 @@ func fnAverage(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []i
 
 	// Type checking: all elements must be numbers
 	for _, v := range arr {
-		if _, ok := v.(float64); !ok {
+		if _, ok := boundValue(v).(float64); !ok {
 			return nil, types.NewError("T0412", "Argument of function 'average' must be an array of numbers", -1)
 		}
 	}
 
 	sum := 0.0
 	for _, v := range arr {
-		num, err := e.toNumber(v)
+		num, err := e.toNumber(boundValue(v))
 		if err != nil {
 			return nil, err
 		}
@@ -71,7 +71,7 @@ func fnAverage(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []i
 }
 
 func fnMin(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
-	arr, err := e.toArray(args[0])
+	arr, err := e.toArray(boundValue(args[0]))
 	if err != nil {
 		return nil, err
 	}
@@ -82,18 +82,18 @@ func fnMin(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 
 	// Type checking: all elements must be numbers
 	for _, v := range arr {
-		if _, ok := v.(float64); !ok {
+		if _, ok := boundValue(v).(float64); !ok {
 			return nil, types.NewError("T0412", "Argument of function 'min' must be an array of numbers", -1)
 		}
 	}
 
-	min, err := e.toNumber(arr[0])
+	min, err := e.toNumber(boundValue(arr[0]))
 	if err != nil {
 		return nil, err
 	}
 
 	for i := 1; i < len(arr); i++ {
-		num, err := e.toNumber(arr[i])
+		num, err := e.toNumber(boundValue(arr[i]))
 		if err != nil {
 			return nil, err
 		}
@@ -106,7 +106,7 @@ func fnMin(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 }
 
 func fnMax(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
-	arr, err := e.toArray(args[0])
+	arr, err := e.toArray(boundValue(args[0]))
 	if err != nil {
 		return nil, err
 	}
@@ -117,18 +117,18 @@ func fnMax(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 
 	// Type checking: all elements must be numbers
 	for _, v := range arr {
-		if _, ok := v.(float64); !ok {
+		if _, ok := boundValue(v).(float64); !ok {
 			return nil, types.NewError("T0412", "Argument of function 'max' must be an array of numbers", -1)
 		}
 	}
 
-	max, err := e.toNumber(arr[0])
+	max, err := e.toNumber(boundValue(arr[0]))
 	if err != nil {
 		return nil, err
 	}
 
 	for i := 1; i < len(arr); i++ {
-		num, err := e.toNumber(arr[i])
+		num, err := e.toNumber(boundValue(arr[i]))
 		if err != nil {
 			return nil, err
 		}
@@ -143,7 +143,7 @@ func fnMax(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 // --- Array Functions ---
 
 // callHOFFn calls a HOF function (Lambda or FunctionDef) with the provided args.
-// For Lambda: trims args to match the number of lambda params.
+// For Lambda: trims args to the lambda's arity (signature-aware, see Lambda.trimArgs).
 // For FunctionDef: passes all args, trimming to MaxArgs if needed.
 // For built-in functions that accept context (AcceptsContext + MinArgs=0),
 // only the first (value) arg is passed — extra HOF positional args (index, total) are dropped.
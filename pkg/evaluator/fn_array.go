@@ -3,7 +3,7 @@ package evaluator
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"math/rand/v2"
 	"sort"
 	"strconv"
 	"strings"
@@ -65,13 +65,11 @@ func fnDistinct(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []
 		return nil, err
 	}
 
-	seen := make(map[string]bool)
+	seen := newValueSet(len(arr))
 	result := make([]interface{}, 0)
 
 	for _, item := range arr {
-		key := distinctCanonicalKey(item)
-		if !seen[key] {
-			seen[key] = true
+		if seen.add(item) {
 			result = append(result, item)
 		}
 	}
@@ -83,7 +81,7 @@ func fnDistinct(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []
 }
 
 // distinctCanonicalKey produces a canonical string representation of a JSON value
-// suitable for equality comparison in $distinct. Object keys are sorted to ensure
+// suitable for use as a map key (the index of $join). Object keys are sorted to ensure
 // that two objects with the same content but different insertion order compare equal.
 func distinctCanonicalKey(v interface{}) string {
 	switch val := v.(type) {
@@ -178,9 +176,12 @@ func fnShuffle(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []i
 	copy(result, arr)
 
 	// Fisher-Yates shuffle
-	rand.Shuffle(len(result), func(i, j int) {
-		result[i], result[j] = result[j], result[i]
-	})
+	swap := func(i, j int) { result[i], result[j] = result[j], result[i] }
+	if r := e.rng(evalCtx); r != nil {
+		r.Shuffle(len(result), swap)
+	} else {
+		rand.Shuffle(len(result), swap)
+	}
 
 	return result, nil
 }
@@ -240,3 +241,14 @@ func fnZip(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 // fnPad pads a string to a target width.
 // Signature: $pad(str, width [, char])
 // Pads on the right by default, negative width pads on the left.
+
+// fnIndex implements $index(): the position (from 0) of the item a filter
+// predicate is evaluated for, so that `items[$index() % 2 = 0]` keeps every
+// other item. Nested filters see the position of their own items. Undefined
+// outside a predicate.
+func fnIndex(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	if i, ok := evalCtx.filterPosition(); ok {
+		return float64(i), nil
+	}
+	return nil, nil
+}
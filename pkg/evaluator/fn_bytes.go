@@ -0,0 +1,86 @@
+package evaluator
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// Binary data.
+//
+// A byte string is a []byte value, produced by $fromHex and $fromBase64Bytes or
+// passed in the input by the caller. $type returns "bytes" for it, $length its
+// size in bytes, and it is truthy when not empty. Two byte strings are equal
+// when their contents are. Wherever a byte string is converted to a string
+// ($string, &, serialization to JSON) it is rendered as standard base64, the
+// encoding/json representation of []byte.
+
+// fnFromHex decodes a hexadecimal string into a byte string.
+// Signature: $fromHex(string)
+
+func fnFromHex(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	str, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("T0410: argument 1 of $fromHex must be a string")
+	}
+	decoded, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, fmt.Errorf("D3137: invalid hex string: %w", err)
+	}
+	return decoded, nil
+}
+
+// fnToHex encodes a byte string, or the UTF-8 bytes of a string, in lowercase
+// hexadecimal.
+// Signature: $toHex(bytes|string)
+
+func fnToHex(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return hex.EncodeToString(v), nil
+	case string:
+		return hex.EncodeToString([]byte(v)), nil
+	default:
+		return nil, fmt.Errorf("T0410: argument 1 of $toHex must be bytes or a string")
+	}
+}
+
+// fnFromBase64Bytes decodes a base64 string into a byte string. Unlike
+// $base64decode, the result is not interpreted as UTF-8 text. Both the standard
+// and the URL-safe alphabets are accepted, with or without padding.
+// Signature: $fromBase64Bytes(string)
+
+func fnFromBase64Bytes(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	str, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("T0410: argument 1 of $fromBase64Bytes must be a string")
+	}
+	decoded, err := decodeBase64Bytes(str)
+	if err != nil {
+		return nil, fmt.Errorf("D3137: invalid base64 string: %w", err)
+	}
+	return decoded, nil
+}
+
+func decodeBase64Bytes(str string) ([]byte, error) {
+	enc := base64.StdEncoding
+	for i := 0; i < len(str); i++ {
+		if str[i] == '-' || str[i] == '_' {
+			enc = base64.URLEncoding
+			break
+		}
+	}
+	if len(str)%4 != 0 {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	return enc.DecodeString(str)
+}
@@ -3,36 +3,42 @@ package evaluator
 import (
 	"context"
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
 )
 
+// isoMillisLayout is the default format of $now and $fromMillis: ISO 8601 with
+// milliseconds, as produced by the picture
+// [Y0001]-[M01]-[D01]T[H01]:[m01]:[s01].[f001][Z01:01t].
+const isoMillisLayout = "2006-01-02T15:04:05.000Z07:00"
+
 // reTimezoneOffset matches a bare timezone offset like +0000 or -0000 at end of string.
-var reTimezoneOffset = mustCompileRegex(`([+-])(\d{2})(\d{2})$`) // fnNow returns current timestamp in ISO 8601 format.
+var reTimezoneOffset = mustCompileRegex(`([+-])(\d{2})(\d{2})$`)
+
+// fnNow returns the current timestamp in ISO 8601 format, or formatted with a
+// picture string.
 // Signature: $now([picture [, timezone]])
 
 func fnNow(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
 	// Use the per-evaluation timestamp stored in the root EvalContext.
 	// This is consistent within a single expression evaluation and fresh
 	// across distinct evaluations (no global mutable state).
-	now := evalCtx.NowTime()
-
-	// Simple ISO 8601 format if no picture provided
-	if len(args) == 0 {
-		return now.UTC().Format(time.RFC3339Nano), nil
-	}
-
-	// Note: Full XPath datetime formatting is complex and not implemented
-	// Return simple ISO format for now
-	return now.UTC().Format(time.RFC3339Nano), nil
+	return e.formatTimestamp(e.now(evalCtx), args, "$now")
 }
 
 // fnMillis returns milliseconds since Unix epoch.
 
 func fnMillis(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
 	// Use same per-evaluation timestamp as $now for consistency.
-	return float64(evalCtx.NowTime().UnixMilli()), nil
+	return float64(e.now(evalCtx).UnixMilli()), nil
+}
+
+// now returns the time of the evaluation of evalCtx, read from the
+// evaluator's clock on first use.
+func (e *Evaluator) now(evalCtx *EvalContext) time.Time {
+	if e.opts.Clock != nil {
+		return evalCtx.nowFrom(e.opts.Clock)
+	}
+	return evalCtx.NowTime()
 }
 
 // fnFromMillis converts milliseconds since epoch to ISO 8601 string.
@@ -49,72 +55,35 @@ func fnFromMillis(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args
 		return nil, err
 	}
 
-	timestamp := time.Unix(0, int64(millis)*1000000).UTC()
+	return e.formatTimestamp(time.UnixMilli(int64(millis)), args[1:], "$fromMillis")
+}
 
-	// Simple ISO 8601 format if no picture provided
-	if len(args) < 2 || args[1] == nil {
-		return timestamp.Format(time.RFC3339Nano), nil
+// formatTimestamp formats t for $now and $fromMillis, given their optional
+// picture and timezone arguments: ISO 8601 with milliseconds without a
+// picture, in the evaluator's default timezone (UTC) without a timezone.
+func (e *Evaluator) formatTimestamp(t time.Time, args []interface{}, fn string) (interface{}, error) {
+	var tz interface{}
+	if len(args) > 1 {
+		tz = args[1]
 	}
-
-	picture, ok := args[1].(string)
-	if !ok {
-		return nil, fmt.Errorf("D3110: picture argument of $fromMillis must be a string")
+	loc, err := e.timezone(tz)
+	if err != nil {
+		return nil, err
 	}
+	t = t.In(loc)
 
-	return formatTimestampWithPicture(timestamp, picture), nil
-}
-
-// formatTimestampWithPicture formats a time.Time using an XPath picture format string.
-// Supports common markers: [Y], [Y0001], [M], [M01], [D], [D01], [H], [H00],
-// [m], [m00], [s], [s00], [X0001] (ISO week year), [W01] (ISO week), [F1] (weekday).
-
-func formatTimestampWithPicture(t time.Time, picture string) string {
-	result := picture
-
-	// ISO week date components
-	isoYear, isoWeek := t.ISOWeek()
-	weekday := int(t.Weekday()) // 0=Sun, 1=Mon, ..., 6=Sat
-	isoWeekday := weekday
-	if isoWeekday == 0 {
-		isoWeekday = 7 // Sun = 7 in ISO
+	if len(args) == 0 || args[0] == nil {
+		return t.Format(isoMillisLayout), nil
 	}
-
-	// Process markers from most specific to least specific
-	// ISO week date markers
-	result = strings.ReplaceAll(result, "[X0001]", fmt.Sprintf("%04d", isoYear))
-	result = strings.ReplaceAll(result, "[X]", fmt.Sprintf("%d", isoYear))
-	result = strings.ReplaceAll(result, "[W01]", fmt.Sprintf("%02d", isoWeek))
-	result = strings.ReplaceAll(result, "[W]", fmt.Sprintf("%d", isoWeek))
-	result = strings.ReplaceAll(result, "[F1]", fmt.Sprintf("%d", isoWeekday))
-	result = strings.ReplaceAll(result, "[F]", fmt.Sprintf("%d", isoWeekday))
-
-	// Standard date/time components (specific markers before generic)
-	result = strings.ReplaceAll(result, "[Y0001]", fmt.Sprintf("%04d", t.Year()))
-	result = strings.ReplaceAll(result, "[Y0000]", fmt.Sprintf("%04d", t.Year()))
-	result = strings.ReplaceAll(result, "[Y,*-4]", fmt.Sprintf("%04d", t.Year()))
-	result = strings.ReplaceAll(result, "[Y]", fmt.Sprintf("%04d", t.Year()))
-	result = strings.ReplaceAll(result, "[M01]", fmt.Sprintf("%02d", int(t.Month())))
-	result = strings.ReplaceAll(result, "[M00]", fmt.Sprintf("%02d", int(t.Month())))
-	result = strings.ReplaceAll(result, "[M]", fmt.Sprintf("%d", int(t.Month())))
-	result = strings.ReplaceAll(result, "[D01]", fmt.Sprintf("%02d", t.Day()))
-	result = strings.ReplaceAll(result, "[D00]", fmt.Sprintf("%02d", t.Day()))
-	result = strings.ReplaceAll(result, "[D]", fmt.Sprintf("%d", t.Day()))
-	result = strings.ReplaceAll(result, "[H00]", fmt.Sprintf("%02d", t.Hour()))
-	result = strings.ReplaceAll(result, "[H01]", fmt.Sprintf("%02d", t.Hour()))
-	result = strings.ReplaceAll(result, "[H]", fmt.Sprintf("%d", t.Hour()))
-	result = strings.ReplaceAll(result, "[m00]", fmt.Sprintf("%02d", t.Minute()))
-	result = strings.ReplaceAll(result, "[m01]", fmt.Sprintf("%02d", t.Minute()))
-	result = strings.ReplaceAll(result, "[m]", fmt.Sprintf("%d", t.Minute()))
-	result = strings.ReplaceAll(result, "[s00]", fmt.Sprintf("%02d", t.Second()))
-	result = strings.ReplaceAll(result, "[s01]", fmt.Sprintf("%02d", t.Second()))
-	result = strings.ReplaceAll(result, "[s]", fmt.Sprintf("%d", t.Second()))
-	result = strings.ReplaceAll(result, "[f001]", fmt.Sprintf("%03d", t.Nanosecond()/1e6))
-	result = strings.ReplaceAll(result, "[f]", fmt.Sprintf("%d", t.Nanosecond()/1e6))
-
-	return result
+	picture, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("D3110: picture argument of %s must be a string", fn)
+	}
+	return formatDateTime(t, picture)
 }
 
 // fnToMillis converts ISO 8601 timestamp to milliseconds since epoch.
+// Timestamps without a timezone are read in the evaluator's default timezone.
 // Signature: $toMillis(timestamp [, picture])
 
 func fnToMillis(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
@@ -128,13 +97,18 @@ func fnToMillis(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []
 		return nil, fmt.Errorf("D3110: timestamp must be a string, got %T", args[0])
 	}
 
+	loc, err := e.timezone(nil)
+	if err != nil {
+		return nil, err
+	}
+
 	// If picture format is provided, use custom parsing
 	if len(args) == 2 && args[1] != nil {
 		picture, ok := args[1].(string)
 		if !ok {
 			return nil, fmt.Errorf("picture format must be a string")
 		}
-		return parseTimestampWithPicture(timestamp, picture)
+		return parseDateTime(timestamp, picture, loc)
 	}
 
 	// Normalize timezone offset: convert +0000 to +00:00
@@ -153,10 +127,8 @@ func fnToMillis(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []
 		"2006",       // year only
 	}
 
-	var t time.Time
-	var err error
 	for _, layout := range layouts {
-		t, err = time.Parse(layout, normalized)
+		t, err := time.ParseInLocation(layout, normalized, loc)
 		if err == nil {
 			return float64(t.UnixMilli()), nil
 		}
@@ -174,87 +146,7 @@ func normalizeTimezoneOffset(timestamp string) string {
 	return timestamp
 }
 
-// parseTimestampWithPicture parses a timestamp using a picture format string.
-// Picture format uses markers like [Y0001] for year, [M01] for month, etc.
-// This is a simplified implementation supporting only the patterns in the test suite.
-
-func parseTimestampWithPicture(timestamp, picture string) (interface{}, error) {
-	// Parse picture format to extract component patterns
-	type component struct {
-		name    string
-		pattern string
-	}
-
-	var components []component
-	pattern := picture
-
-	// Escape regex special characters in literal parts between markers
-	// Replace picture markers with regex groups (check longer/specific markers first)
-	type replacement struct {
-		markers []string
-		comp    component
-	}
-
-	replacements := []replacement{
-		{[]string{"[Y0001]", "[Y0000]", "[Y,*-4]", "[Y]"}, component{"year", `(\d{1,4})`}},
-		{[]string{"[M01]", "[M00]", "[M]"}, component{"month", `(\d{1,2})`}},
-		{[]string{"[D01]", "[D00]", "[D]"}, component{"day", `(\d{1,2})`}},
-		{[]string{"[H00]", "[H]"}, component{"hour", `(\d{1,2})`}},
-		{[]string{"[m00]", "[m]"}, component{"minute", `(\d{1,2})`}},
-		{[]string{"[s00]", "[s]"}, component{"second", `(\d{1,2})`}},
-	}
-
-	for _, repl := range replacements {
-		for _, marker := range repl.markers {
-			if strings.Contains(pattern, marker) {
-				components = append(components, repl.comp)
-				pattern = strings.Replace(pattern, marker, repl.comp.pattern, 1)
-				break
-			}
-		}
-	}
-
-	// Compile and match — use regex cache to avoid re-compiling identical patterns.
-	re, err := getOrCompileRegex("^" + pattern + "$")
-	if err != nil {
-		return nil, fmt.Errorf("invalid picture format: %s", picture)
-	}
-
-	matches := re.FindStringSubmatch(timestamp)
-	if matches == nil {
-		return nil, fmt.Errorf("D3110: cannot parse timestamp with picture format: %s", timestamp)
-	}
-
-	// Extract components
-	values := make(map[string]int)
-	for i, comp := range components {
-		val, _ := strconv.Atoi(matches[i+1])
-		values[comp.name] = val
-	}
-
-	// Default missing components
-	year := values["year"]
-	if year == 0 {
-		year = time.Now().UTC().Year()
-	}
-	month := values["month"]
-	if month == 0 {
-		month = 1
-	}
-	day := values["day"]
-	if day == 0 {
-		day = 1
-	}
-	hour := values["hour"]
-	minute := values["minute"]
-	second := values["second"]
-
-	// Create time and convert to milliseconds
-	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.UTC)
-	return float64(t.UnixMilli()), nil
-}
-
 // --- Encoding Functions (Fase 5.3) ---
 
 // fnBase64Encode encodes a string to base64.
-// Signature: $base64encode(string)
+// Signature: $base64encode(string|bytes); a byte string is encoded as is.
@@ -0,0 +1,49 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+)
+
+// DocumentLoader resolves the name passed to $doc(name) to a document, typically
+// a reference dataset such as a currency table. It returns the decoded JSON
+// value (maps, slices, float64, string, bool or nil); nil means the document is
+// undefined. See WithDocumentLoader.
+type DocumentLoader func(name string) (interface{}, error)
+
+// fnDoc implements $doc(name). Each name is loaded at most once per evaluation,
+// so calling $doc inside a loop costs a single load, and every call sees the same
+// document. With WithDocumentCache, loads also go through the evaluator's cache.
+func fnDoc(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument of $doc must be a string")
+	}
+	if e.opts.DocumentLoader == nil {
+		return nil, fmt.Errorf("$doc(%q): no document loader configured (see WithDocumentLoader)", name)
+	}
+
+	root := evalCtx.root
+	if doc, ok := root.documents[name]; ok {
+		return doc, nil
+	}
+	var doc interface{}
+	var err error
+	if e.documents != nil {
+		doc, err = e.documents.load(ctx, name, e.opts.DocumentLoader)
+	} else {
+		doc, err = e.opts.DocumentLoader(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("$doc(%q): %w", name, err)
+	}
+	doc = root.thaw(doc)
+	if root.documents == nil {
+		root.documents = make(map[string]interface{})
+	}
+	root.documents[name] = doc
+	return doc, nil
+}
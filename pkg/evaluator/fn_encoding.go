@@ -14,6 +14,9 @@ func fnBase64Encode(ctx context.Context, e *Evaluator, evalCtx *EvalContext, arg
 		return nil, nil
 	}
 
+	if b, ok := args[0].([]byte); ok {
+		return base64.StdEncoding.EncodeToString(b), nil
+	}
 	str := e.toString(args[0])
 	encoded := base64.StdEncoding.EncodeToString([]byte(str))
 	return encoded, nil
@@ -4,10 +4,9 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"slices"
 	"strconv"
 	"strings"
-
-	"github.com/sandrolain/gosonata/pkg/types"
 )
 
 func fnFormatNumber(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
@@ -25,89 +24,11 @@ func fnFormatNumber(ctx context.Context, e *Evaluator, evalCtx *EvalContext, arg
 		return e.formatNumberForString(num), nil
 	}
 
-	// Picture string formatting
-	picture := e.toString(args[1])
-
-	// Create decimal format with default or custom options
-	format := NewDecimalFormat()
-
-	// Parse options if provided
-	if len(args) > 2 && args[2] != nil {
-		var opts map[string]interface{}
-
-		// Handle OrderedObject or regular map
-		switch v := args[2].(type) {
-		case *OrderedObject:
-			opts = v.Values
-		case map[string]interface{}:
-			opts = v
-		}
-
-		if opts != nil {
-			if ds, ok := opts["decimal-separator"].(string); ok && len(ds) > 0 {
-				for _, r := range ds {
-					format.DecimalSeparator = r
-					break
-				}
-			}
-			if gs, ok := opts["grouping-separator"].(string); ok && len(gs) > 0 {
-				for _, r := range gs {
-					format.GroupSeparator = r
-					break
-				}
-			}
-			if es, ok := opts["exponent-separator"].(string); ok && len(es) > 0 {
-				for _, r := range es {
-					format.ExponentSeparator = r
-					break
-				}
-			}
-			if ms, ok := opts["minus-sign"].(string); ok && len(ms) > 0 {
-				for _, r := range ms {
-					format.MinusSign = r
-					break
-				}
-			}
-			if inf, ok := opts["infinity"].(string); ok {
-				format.Infinity = inf
-			}
-			if nan, ok := opts["NaN"].(string); ok {
-				format.NaN = nan
-			}
-			if pct, ok := opts["percent"].(string); ok {
-				format.Percent = pct
-			}
-			if pm, ok := opts["per-mille"].(string); ok {
-				format.PerMille = pm
-			}
-			if zd, ok := opts["zero-digit"].(string); ok && len(zd) > 0 {
-				for _, r := range zd {
-					format.ZeroDigit = r
-					break
-				}
-			}
-			if od, ok := opts["digit"].(string); ok && len(od) > 0 {
-				for _, r := range od {
-					format.OptionalDigit = r
-					break
-				}
-			}
-			if ps, ok := opts["pattern-separator"].(string); ok && len(ps) > 0 {
-				for _, r := range ps {
-					format.PatternSeparator = r
-					break
-				}
-			}
-		}
-	}
-
-	// Use the complete XPath-compliant formatting
-	formatted, err := FormatNumberWithPicture(num, picture, format)
-	if err != nil {
-		return nil, types.NewError(types.ErrorCode(err.Error()[:5]), err.Error()[7:], -1)
+	var options interface{}
+	if len(args) > 2 {
+		options = args[2]
 	}
-
-	return formatted, nil
+	return formatNumberPicture(num, e.toString(args[1]), options)
 }
 
 func fnFormatBase(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
@@ -170,23 +91,51 @@ func fnFormatInteger(ctx context.Context, e *Evaluator, evalCtx *EvalContext, ar
 	}
 
 	// Picture string formatting
-	picture := e.toString(args[1])
+	if s, ok := formatIntegerPicture(intNum, e.toString(args[1])); ok {
+		return s, nil
+	}
+	// Default to decimal
+	return fmt.Sprintf("%d", intNum), nil
+}
 
+// formatIntegerPicture renders num with one of the non-decimal pictures of
+// $formatInteger, also used by the markers of date/time pictures: roman
+// numerals (i, I), words (w, W, Ww) and letters (a, A). ok is false for the
+// other pictures.
+func formatIntegerPicture(num int, picture string) (s string, ok bool) {
 	switch picture {
 	case "i": // Roman numerals lowercase
-		return strings.ToLower(toRomanNumeral(intNum)), nil
+		return strings.ToLower(toRomanNumeral(num)), true
 	case "I": // Roman numerals uppercase
-		return toRomanNumeral(intNum), nil
+		return toRomanNumeral(num), true
 	case "w": // Words lowercase
-		return strings.ToLower(numberToWords(intNum)), nil
+		return strings.ToLower(numberToWords(num)), true
 	case "W": // Words uppercase
-		return numberToWords(intNum), nil
+		return strings.ToUpper(numberToWords(num)), true
 	case "Ww": // Words title case
-		return strings.Title(strings.ToLower(numberToWords(intNum))), nil
-	default:
-		// Default to decimal
-		return fmt.Sprintf("%d", intNum), nil
+		return strings.Title(strings.ToLower(numberToWords(num))), true
+	case "a": // Letters lowercase
+		return toAlphabetic(num), true
+	case "A": // Letters uppercase
+		return strings.ToUpper(toAlphabetic(num)), true
+	}
+	return "", false
+}
+
+// toAlphabetic converts a positive integer to letters: a, b, ..., z, aa, ab...
+
+func toAlphabetic(num int) string {
+	if num <= 0 {
+		return fmt.Sprintf("%d", num) // No letter sequence
+	}
+	var letters []byte
+	for num > 0 {
+		num--
+		letters = append(letters, byte('a'+num%26))
+		num /= 26
 	}
+	slices.Reverse(letters)
+	return string(letters)
 }
 
 // toRomanNumeral converts an integer to Roman numeral representation.
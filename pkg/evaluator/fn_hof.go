@@ -2,6 +2,7 @@ package evaluator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 
@@ -11,11 +12,7 @@ import (
 func (e *Evaluator) callHOFFn(ctx context.Context, evalCtx *EvalContext, fn interface{}, args []interface{}) (interface{}, error) {
 	switch f := fn.(type) {
 	case *Lambda:
-		callArgs := args
-		if len(f.Params) > 0 && len(f.Params) < len(args) {
-			callArgs = args[:len(f.Params)]
-		}
-		return e.callLambda(ctx, f, callArgs)
+		return e.callLambda(ctx, f, f.trimArgs(args))
 	case *FunctionDef:
 		// Trim to MaxArgs if specified
 		callArgs := args
@@ -34,6 +31,52 @@ func (e *Evaluator) callHOFFn(ctx context.Context, evalCtx *EvalContext, fn inte
 	}
 }
 
+// cancelCheckInterval is the number of iterations built-in loops run between
+// context checks. Callbacks that are native functions or leaf expressions never
+// reach evalNode's own check, so without this a cancelled evaluation would keep
+// going until the whole input was processed. Must be a power of two.
+const cancelCheckInterval = 64
+
+// checkCancel returns the context error on every cancelCheckInterval-th
+// iteration i (including the first) and nil otherwise.
+func checkCancel(ctx context.Context, i int) error {
+	if i&(cancelCheckInterval-1) != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// wrapCallbackError annotates an error raised by the callback of the higher-order
+// built-in fnName with the item being processed: an array index (int) or an
+// object key (string). The context is appended to the message, so errors keep
+// their leading code, and *types.Error values keep their code and position for
+// callers matching on them. Cancellation errors are returned as is.
+func wrapCallbackError(err error, fnName string, item interface{}) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var where string
+	switch it := item.(type) {
+	case int:
+		where = fmt.Sprintf("in $%s callback at index %d", fnName, it)
+	case string:
+		where = fmt.Sprintf("in $%s callback for key %q", fnName, it)
+	default:
+		where = fmt.Sprintf("in $%s callback", fnName)
+	}
+	var jerr *types.Error
+	if errors.As(err, &jerr) {
+		return &types.Error{
+			Code:     jerr.Code,
+			Message:  fmt.Sprintf("%s (%s)", jerr.Message, where),
+			Position: jerr.Position,
+			Token:    jerr.Token,
+			Err:      err,
+		}
+	}
+	return fmt.Errorf("%w (%s)", err, where)
+}
+
 func fnMap(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
 	if args[0] == nil {
 		return nil, nil
@@ -48,13 +91,16 @@ func fnMap(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 
 	result := make([]interface{}, 0, len(arr))
 	for i, item := range arr {
+		if err := checkCancel(ctx, i); err != nil {
+			return nil, err
+		}
 		// OPT-14: use pooled HOF args frame to avoid a []interface{}{...} allocation
 		// per iteration. Safe: callHOFFn only reads elements; it never stores the slice.
 		f, hofArgs := acquireHOFArgs3(item, float64(i), arr)
 		value, err := e.callHOFFn(ctx, evalCtx, args[1], hofArgs)
 		releaseHOFArgs(f)
 		if err != nil {
-			return nil, err
+			return nil, wrapCallbackError(err, "map", i)
 		}
 		// Exclude undefined (nil) results - JSONata sequence semantics
 		if value != nil {
@@ -85,12 +131,15 @@ func fnFilter(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 
 	result := make([]interface{}, 0)
 	for i, item := range arr {
+		if err := checkCancel(ctx, i); err != nil {
+			return nil, err
+		}
 		// OPT-14: pooled HOF args frame
 		f, hofArgs := acquireHOFArgs3(item, float64(i), arr)
 		value, err := e.callHOFFn(ctx, evalCtx, args[1], hofArgs)
 		releaseHOFArgs(f)
 		if err != nil {
-			return nil, err
+			return nil, wrapCallbackError(err, "filter", i)
 		}
 		if e.isTruthy(value) {
 			result = append(result, item)
@@ -151,13 +200,33 @@ func fnReduce(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 		startIdx = 1
 	}
 
+	callback, _ := args[1].(*Lambda)
+	var scope *reduceScope
+	if callback != nil {
+		scope = &reduceScope{calls: map[*types.ASTNode]int{}}
+		doneCalls(callback.Body, 0, scope.calls)
+		if len(scope.calls) > 0 {
+			ctx = context.WithValue(ctx, reducingKey{}, scope)
+		}
+	}
 	for i := startIdx; i < len(arr); i++ {
+		if err := checkCancel(ctx, i-startIdx); err != nil {
+			return nil, err
+		}
 		// OPT-14: pooled HOF args frame (4 elements: accumulator, current, index, array)
 		f, hofArgs := acquireHOFArgs4(accumulator, arr[i], float64(i), arr)
-		value, err := e.callHOFFn(ctx, evalCtx, args[1], hofArgs)
+		var value interface{}
+		if callback != nil {
+			value, err = e.callLambdaScoped(ctx, callback, callback.trimArgs(hofArgs), scope)
+		} else {
+			value, err = e.callHOFFn(ctx, evalCtx, args[1], hofArgs)
+		}
 		releaseHOFArgs(f)
 		if err != nil {
-			return nil, err
+			return nil, wrapCallbackError(err, "reduce", i)
+		}
+		if done, ok := value.(*reduceDone); ok {
+			return done.value, nil
 		}
 		accumulator = value
 	}
@@ -165,6 +234,80 @@ func fnReduce(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 	return accumulator, nil
 }
 
+// reducingKey holds the reduceScope of the $reduce callback being called.
+type reducingKey struct{}
+
+// reduceScope tells the $done calls that stop a $reduce: those whose value is
+// the one the callback returns. They are the calls in tail position of the
+// callback's body (see doneCalls), in the invocation made by $reduce: not in
+// a function the callback calls, even itself.
+type reduceScope struct {
+	// calls are the $done calls in tail position of the body, with the number
+	// of blocks around each.
+	calls map[*types.ASTNode]int
+	// frame is the frame of the current invocation (see callLambdaScoped).
+	frame *EvalContext
+}
+
+// doneCalls adds the $done calls in tail position of n to calls: n itself,
+// the branches of a condition and the last expression of a block.
+func doneCalls(n *types.ASTNode, blocks int, calls map[*types.ASTNode]int) {
+	if n == nil {
+		return
+	}
+	switch n.Type {
+	case types.NodeFunction:
+		if n.LHS != nil && n.LHS.Type == types.NodeVariable && n.LHS.StrValue == "done" {
+			calls[n] = blocks
+		}
+	case types.NodeCondition:
+		doneCalls(n.RHS, blocks, calls)
+		if len(n.Expressions) > 0 {
+			doneCalls(n.Expressions[0], blocks, calls)
+		}
+	case types.NodeBlock:
+		if len(n.Expressions) > 0 {
+			doneCalls(n.Expressions[len(n.Expressions)-1], blocks+1, calls)
+		}
+	}
+}
+
+// reduceDone is returned by $done(value). When a $reduce callback returns it, the
+// reduction stops and value is the result: `$reduce(big, function($acc, $v) {
+// $acc + $v > 100 ? $done($acc) : $acc + $v }, 0)`.
+type reduceDone struct {
+	value interface{}
+}
+
+// evalDone evaluates the call node of the built-in $done. It gives the
+// reduceDone sentinel only when its value is the one returned by the current
+// invocation of a $reduce callback (see reduceScope), so that the sentinel
+// never ends up in a value; anywhere else it fails as fnDone.
+func evalDone(ctx context.Context, node *types.ASTNode, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	if scope, ok := ctx.Value(reducingKey{}).(*reduceScope); ok {
+		if blocks, ok := scope.calls[node]; ok {
+			frame := evalCtx
+			for ; blocks > 0 && frame != nil; blocks-- {
+				frame = frame.parent
+			}
+			if frame == scope.frame {
+				var value interface{}
+				if len(args) > 0 {
+					value = args[0]
+				}
+				return &reduceDone{value: value}, nil
+			}
+		}
+	}
+	return fnDone(ctx, nil, evalCtx, args)
+}
+
+// fnDone implements $done([value]) wherever evalDone does not: called as a
+// value, or anywhere but as the value returned by a $reduce callback.
+func fnDone(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("$done can only be the value returned by a $reduce callback")
+}
+
 // fnSingle finds the single element in an array matching an optional predicate.
 // Throws D3138 if more than one element matches, D3139 if no element matches.
 
@@ -186,6 +329,9 @@ func fnSingle(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 	var result interface{}
 
 	for i, entry := range arr {
+		if err := checkCancel(ctx, i); err != nil {
+			return nil, err
+		}
 		positiveResult := true
 		if fn != nil {
 			// OPT-14: pooled HOF args frame
@@ -193,7 +339,7 @@ func fnSingle(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 			res, err := e.callHOFFn(ctx, evalCtx, fn, hofArgs)
 			releaseHOFArgs(hf)
 			if err != nil {
-				return nil, err
+				return nil, wrapCallbackError(err, "single", i)
 			}
 			positiveResult = e.isTruthy(res)
 		}
@@ -216,6 +362,87 @@ func fnSingle(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 	return result, nil
 }
 
+// fnFirst implements $first(array[, predicate]): the first item of array, or the
+// first item for which predicate(value, index, array) is truthy. The scan stops
+// at the first match, unlike $filter(array, predicate)[0].
+func fnFirst(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	arr, err := e.toArray(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		if len(arr) == 0 {
+			return nil, nil
+		}
+		return arr[0], nil
+	}
+	i, err := e.findFirst(ctx, evalCtx, "first", arr, args[1], true)
+	if err != nil || i < 0 {
+		return nil, err
+	}
+	return arr[i], nil
+}
+
+// fnAny implements $any(array, predicate): true as soon as predicate holds for
+// an item, false when it holds for none (including an empty array).
+func fnAny(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	arr, err := e.toArray(args[0])
+	if err != nil {
+		return nil, err
+	}
+	i, err := e.findFirst(ctx, evalCtx, "any", arr, args[1], true)
+	if err != nil {
+		return nil, err
+	}
+	return i >= 0, nil
+}
+
+// fnAll implements $all(array, predicate): false as soon as predicate fails for
+// an item, true when it holds for all of them (including an empty array).
+func fnAll(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	arr, err := e.toArray(args[0])
+	if err != nil {
+		return nil, err
+	}
+	i, err := e.findFirst(ctx, evalCtx, "all", arr, args[1], false)
+	if err != nil {
+		return nil, err
+	}
+	return i < 0, nil
+}
+
+// findFirst returns the index of the first item of arr for which the truthiness
+// of predicate(value, index, array) equals want, or -1 if there is none.
+func (e *Evaluator) findFirst(ctx context.Context, evalCtx *EvalContext, fnName string, arr []interface{}, predicate interface{}, want bool) (int, error) {
+	if predicate == nil {
+		return -1, fmt.Errorf("second argument to $%s must be a function", fnName)
+	}
+	for i, item := range arr {
+		if err := checkCancel(ctx, i); err != nil {
+			return -1, err
+		}
+		f, hofArgs := acquireHOFArgs3(item, float64(i), arr)
+		value, err := e.callHOFFn(ctx, evalCtx, predicate, hofArgs)
+		releaseHOFArgs(f)
+		if err != nil {
+			return -1, wrapCallbackError(err, fnName, i)
+		}
+		if e.isTruthy(value) == want {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
 func fnSort(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
 	if args[0] == nil {
 		return nil, nil
@@ -266,10 +493,16 @@ func fnSort(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inte
 		// Go sort convention: less(i,j) returns true when arr[i] comes BEFORE arr[j].
 		// Logic: less(i,j) = true iff $a < $b, i.e. !fn($a,$b) && fn($b,$a)
 		var sortErr error
+		calls := 0
 		sort.SliceStable(result, func(i, j int) bool {
 			if sortErr != nil {
 				return false
 			}
+			if err := checkCancel(ctx, calls); err != nil {
+				sortErr = err
+				return false
+			}
+			calls++
 			callFn := func(a, b interface{}) (bool, error) {
 				var value interface{}
 				var err error
@@ -304,7 +537,7 @@ func fnSort(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inte
 			return bwd // a < b: a comes before b; if equal (both false) → stable
 		})
 		if sortErr != nil {
-			return nil, sortErr
+			return nil, wrapCallbackError(sortErr, "sort", nil)
 		}
 	}
 
@@ -0,0 +1,155 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// fnJoinOn performs a hash join between two arrays of objects.
+// Signature: $joinOn(left, right, keyLeft, keyRight [, type])
+//
+// keyLeft/keyRight are either a field name (string) or a function invoked as
+// fn(item, index, array) that returns the join key. type is "inner" (default),
+// "left" or "outer". Each result row is an object {"left": l, "right": r}; the
+// side without a match is omitted for left/outer joins. Rows follow the order of
+// the left array (matches in right-array order), followed for outer joins by the
+// unmatched right items. Items whose key is undefined never match.
+//
+// The right array is indexed once, so the join costs O(n+m) instead of the
+// O(n*m) of the equivalent `left@$l.right@$r[$l.k = $r.k]` expression.
+
+func fnJoinOn(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	joinType := "inner"
+	if len(args) > 4 && args[4] != nil {
+		s, ok := args[4].(string)
+		if !ok || (s != "inner" && s != "left" && s != "outer") {
+			return nil, types.NewError(types.ErrJoinArgument,
+				`The fifth argument of the function '$joinOn' must be one of "inner", "left" or "outer"`, -1)
+		}
+		joinType = s
+	}
+
+	left, err := joinOperand(e, args[0])
+	if err != nil {
+		return nil, err
+	}
+	right, err := joinOperand(e, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	leftKeys, err := e.joinKeys(ctx, evalCtx, left, args[2])
+	if err != nil {
+		return nil, err
+	}
+	rightKeys, err := e.joinKeys(ctx, evalCtx, right, args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	// Build side: right array indexed by canonical key, preserving item order.
+	index := make(map[string][]int, len(right))
+	for i, k := range rightKeys {
+		if k == nil {
+			continue
+		}
+		ck := distinctCanonicalKey(k)
+		index[ck] = append(index[ck], i)
+	}
+
+	var matchedRight []bool
+	if joinType == "outer" {
+		matchedRight = make([]bool, len(right))
+	}
+
+	result := make([]interface{}, 0, len(left))
+	for i, l := range left {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var matches []int
+		if leftKeys[i] != nil {
+			matches = index[distinctCanonicalKey(leftKeys[i])]
+		}
+		for _, j := range matches {
+			result = append(result, joinRow(l, right[j]))
+			if matchedRight != nil {
+				matchedRight[j] = true
+			}
+		}
+		if len(matches) == 0 && joinType != "inner" {
+			result = append(result, joinRow(l, nil))
+		}
+	}
+	for j, matched := range matchedRight {
+		if !matched {
+			result = append(result, joinRow(nil, right[j]))
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// joinOperand converts a $joinOn input to an array; undefined is an empty side.
+
+func joinOperand(e *Evaluator, v interface{}) ([]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return e.toArray(v)
+}
+
+// joinKeys evaluates the join key of every item. keySpec is a field name or a function.
+
+func (e *Evaluator) joinKeys(ctx context.Context, evalCtx *EvalContext, items []interface{}, keySpec interface{}) ([]interface{}, error) {
+	keys := make([]interface{}, len(items))
+	switch spec := keySpec.(type) {
+	case string:
+		for i, item := range items {
+			switch obj := item.(type) {
+			case map[string]interface{}:
+				keys[i] = obj[spec]
+			case *OrderedObject:
+				keys[i] = obj.Values[spec]
+			}
+		}
+	case *Lambda, *FunctionDef:
+		for i, item := range items {
+			// OPT-14: pooled HOF args frame
+			f, hofArgs := acquireHOFArgs3(item, float64(i), items)
+			k, err := e.callHOFFn(ctx, evalCtx, spec, hofArgs)
+			releaseHOFArgs(f)
+			if err != nil {
+				return nil, wrapCallbackError(err, "joinOn", i)
+			}
+			keys[i] = k
+		}
+	default:
+		return nil, types.NewError(types.ErrJoinArgument,
+			fmt.Sprintf("The key arguments of the function '$joinOn' must be a field name or a function, got %T", keySpec), -1)
+	}
+	return keys, nil
+}
+
+// joinRow builds a single {"left", "right"} result object, omitting undefined sides.
+
+func joinRow(l, r interface{}) *OrderedObject {
+	row := &OrderedObject{
+		Keys:   make([]string, 0, 2),
+		Values: make(map[string]interface{}, 2),
+	}
+	if l != nil {
+		row.Keys = append(row.Keys, "left")
+		row.Values["left"] = l
+	}
+	if r != nil {
+		row.Keys = append(row.Keys, "right")
+		row.Values["right"] = r
+	}
+	return row
+}
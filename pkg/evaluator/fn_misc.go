@@ -17,9 +17,24 @@ func fnError(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 
 // fnAssert asserts a condition, throws error if false.
 // Signature: $assert(condition [, message])
-// The condition must be a boolean; null and numbers return T0410 error
+// The condition must be a boolean; null and numbers return T0410 error.
+// WithAssertMode turns the errors into warnings or disables the check.
 
 func fnAssert(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	switch e.opts.AssertMode {
+	case AssertIgnore:
+		return nil, nil
+	case AssertWarn:
+		if _, err := checkAssert(args); err != nil {
+			e.logger.Warn("assertion failed", "error", err.Error())
+		}
+		return nil, nil
+	}
+	return checkAssert(args)
+}
+
+// checkAssert returns the error of a failing $assert.
+func checkAssert(args []interface{}) (interface{}, error) {
 	if len(args) == 0 {
 		return nil, fmt.Errorf("T0410: $assert() requires at least 1 argument")
 	}
@@ -51,11 +66,8 @@ func fnAssert(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 	return nil, nil
 }
 
-// --- Regex Functions ---
-
-// fnMatch finds regex matches and returns array of match objects.
-// Signature: $match(str, pattern [, limit])
-
+// fnEval implements $eval(expr[, context]): parses and evaluates expr with
+// context (default: the context value) as $.
 func fnEval(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
 	// Undefined input → undefined
 	if len(args) == 0 || args[0] == nil {
@@ -73,12 +85,16 @@ func fnEval(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inte
 		return nil, err
 	}
 
-	// If bindings/context are provided as second arg, use as data context
+	// The expression runs in the caller's environment, as in the reference: it
+	// sees the caller's variables and $$ is still the input of the evaluation.
+	// Only $ changes, to the second argument when given.
+	data := evalCtx.Data()
 	if len(args) >= 2 && args[1] != nil {
-		// Second argument is the data context for the evaluated expression
-		return e.Eval(ctx, parsed, args[1])
+		data = args[1]
 	}
-
-	// Evaluate in the current data context, inheriting current bindings
-	return e.Eval(ctx, parsed, evalCtx.Data())
+	result, err := e.evalNode(ctx, parsed.AST(), evalCtx.NewChildContext(data))
+	if err != nil {
+		return nil, err
+	}
+	return unwrapCVsDeep(result)
 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"math"
-	"math/rand"
 )
 
 func fnAbs(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
@@ -139,10 +138,6 @@ func fnPower(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 // The function is invoked with two arguments: the property value and the property name.
 // Returns results in key order.
 
-func fnRandom(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
-	return rand.Float64(), nil
-}
-
 // --- Object Functions ---
 
 // fnKeys returns an array of keys from an object or array of objects.
@@ -33,42 +33,22 @@ func fnEach(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inte
 		return nil, fmt.Errorf("first argument to $each must be an object")
 	}
 
+	switch fnArg.(type) {
+	case *Lambda, *FunctionDef:
+	default:
+		return nil, fmt.Errorf("second argument to $each must be a function")
+	}
+
 	result := make([]interface{}, 0, len(keys))
-	for _, key := range keys {
-		value := values[key]
-		var itemResult interface{}
-		var err error
-
-		switch fn := fnArg.(type) {
-		case *Lambda:
-			// Pass args based on how many params the lambda expects
-			var callArgs []interface{}
-			switch len(fn.Params) {
-			case 1:
-				callArgs = []interface{}{value}
-			case 2:
-				callArgs = []interface{}{value, key}
-			default: // 3+
-				callArgs = []interface{}{value, key, obj}
-			}
-			itemResult, err = e.callLambda(ctx, fn, callArgs)
-		case *FunctionDef:
-			// Call with (value, key) respecting function's max args
-			var callArgs []interface{}
-			if fn.MaxArgs == 1 {
-				callArgs = []interface{}{value}
-			} else if fn.MaxArgs < 0 || fn.MaxArgs >= 3 {
-				callArgs = []interface{}{value, key, obj}
-			} else {
-				callArgs = []interface{}{value, key}
-			}
-			itemResult, err = fn.Impl(ctx, e, evalCtx, callArgs)
-		default:
-			return nil, fmt.Errorf("second argument to $each must be a function")
+	for i, key := range keys {
+		if err := checkCancel(ctx, i); err != nil {
+			return nil, err
 		}
-
+		value := values[key]
+		// The callback receives (value, key, object), trimmed to its arity.
+		itemResult, err := e.callHOFFn(ctx, evalCtx, fnArg, []interface{}{value, key, obj})
 		if err != nil {
-			return nil, err
+			return nil, wrapCallbackError(err, "each", key)
 		}
 		// Skip undefined results
 		if itemResult != nil {
@@ -131,45 +111,27 @@ func fnSift(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inte
 		return nil, nil
 	}
 
+	switch fnArg.(type) {
+	case *Lambda, *FunctionDef:
+	default:
+		return nil, fmt.Errorf("second argument to $sift must be a function")
+	}
+
 	// Build result as OrderedObject to preserve order
 	resultObj := &OrderedObject{
 		Keys:   make([]string, 0),
 		Values: make(map[string]interface{}),
 	}
 
-	for _, key := range keys {
-		value := values[key]
-		var include interface{}
-		var err error
-
-		switch fn := fnArg.(type) {
-		case *Lambda:
-			var callArgs []interface{}
-			switch len(fn.Params) {
-			case 1:
-				callArgs = []interface{}{value}
-			case 2:
-				callArgs = []interface{}{value, key}
-			default: // 3+
-				callArgs = []interface{}{value, key, obj}
-			}
-			include, err = e.callLambda(ctx, fn, callArgs)
-		case *FunctionDef:
-			var callArgs []interface{}
-			if fn.MaxArgs == 1 {
-				callArgs = []interface{}{value}
-			} else if fn.MaxArgs < 0 || fn.MaxArgs >= 3 {
-				callArgs = []interface{}{value, key, obj}
-			} else {
-				callArgs = []interface{}{value, key}
-			}
-			include, err = fn.Impl(ctx, e, evalCtx, callArgs)
-		default:
-			return nil, fmt.Errorf("second argument to $sift must be a function")
+	for i, key := range keys {
+		if err := checkCancel(ctx, i); err != nil {
+			return nil, err
 		}
-
+		value := values[key]
+		// The callback receives (value, key, object), trimmed to its arity.
+		include, err := e.callHOFFn(ctx, evalCtx, fnArg, []interface{}{value, key, obj})
 		if err != nil {
-			return nil, err
+			return nil, wrapCallbackError(err, "sift", key)
 		}
 
 		if e.isTruthy(include) {
@@ -185,7 +147,81 @@ func fnSift(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inte
 	return resultObj, nil
 }
 
-// fnRandom returns a pseudo-random number between 0 (inclusive) and 1 (exclusive).
+// fnSiftValues implements $siftValues(object[, predicate]): the entries of
+// object whose value satisfies predicate($v, $k?), or is truthy when predicate
+// is omitted. Undefined when no entry is kept. It drops groups in the same
+// pass that builds the result:
+//
+//	items{category: $sum(price)} ~> $siftValues(function($v) { $v > 100 })
+func fnSiftValues(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	obj := args[0]
+	if obj == nil {
+		return nil, nil
+	}
+	var pred interface{}
+	if len(args) > 1 && args[1] != nil {
+		switch args[1].(type) {
+		case *Lambda, *FunctionDef:
+			pred = args[1]
+		default:
+			return nil, fmt.Errorf("second argument to $siftValues must be a function")
+		}
+	}
+
+	if arr, ok := obj.([]interface{}); ok {
+		results := make([]interface{}, 0, len(arr))
+		for _, elem := range arr {
+			if elem == nil {
+				continue
+			}
+			res, err := fnSiftValues(ctx, e, evalCtx, []interface{}{elem, pred})
+			if err != nil {
+				return nil, err
+			}
+			if res != nil {
+				results = append(results, res)
+			}
+		}
+		if len(results) == 0 {
+			return nil, nil
+		}
+		return results, nil
+	}
+
+	var keys []string
+	var values map[string]interface{}
+	switch o := obj.(type) {
+	case *OrderedObject:
+		keys, values = o.Keys, o.Values
+	case map[string]interface{}:
+		keys, values = sortedKeys(o), o
+	default:
+		return nil, nil
+	}
+
+	result := &OrderedObject{Values: make(map[string]interface{})}
+	for i, key := range keys {
+		if err := checkCancel(ctx, i); err != nil {
+			return nil, err
+		}
+		value := values[key]
+		keep := value
+		if pred != nil {
+			var err error
+			if keep, err = e.callHOFFn(ctx, evalCtx, pred, []interface{}{value, key}); err != nil {
+				return nil, wrapCallbackError(err, "siftValues", key)
+			}
+		}
+		if e.isTruthy(keep) {
+			result.Keys = append(result.Keys, key)
+			result.Values[key] = value
+		}
+	}
+	if len(result.Keys) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
 
 func fnKeys(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
 	if len(args) == 0 {
@@ -343,13 +379,20 @@ func fnMerge(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 
 // fnSpread splits object/array into array of single key/value pair objects.
 // For non-array non-object values (including lambdas), returns the value as-is.
+// Like any sequence, a result of a single item is unwrapped: $spread({"a": 1})
+// is {"a": 1}. Plain maps are spread in ascending key order, *OrderedObject in
+// its key order (see WithOrderedInput).
 
 func fnSpread(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
 	if len(args) == 0 || args[0] == nil {
 		return nil, nil
 	}
 
-	return fnSpreadRecursive(ctx, e, evalCtx, args[0])
+	result, err := fnSpreadRecursive(ctx, e, evalCtx, args[0])
+	if arr, ok := result.([]interface{}); ok && len(arr) == 1 {
+		return arr[0], err
+	}
+	return result, err
 }
 
 // fnSpreadRecursive is the recursive implementation of spread
@@ -1,7 +1,10 @@
 package evaluator
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"strconv"
 )
 
@@ -27,8 +30,9 @@ func (o *OrderedObject) MarshalJSON() ([]byte, error) {
 		if i > 0 {
 			buf.WriteByte(',')
 		}
-		// OPT-05: strconv.Quote è equivalente a json.Marshal per le stringhe e non alloca []byte+error
-		buf.WriteString(strconv.Quote(key))
+		if err := writeJSONKey(buf, key); err != nil {
+			return nil, err
+		}
 		buf.WriteByte(':')
 		valueBytes, err := json.Marshal(o.Values[key])
 		if err != nil {
@@ -42,3 +46,79 @@ func (o *OrderedObject) MarshalJSON() ([]byte, error) {
 	copy(result, buf.Bytes())
 	return result, nil
 }
+
+// writeJSONKey writes key as a JSON string. OPT-05: strconv.Quote does not
+// allocate a []byte and an error like json.Marshal, and quotes printable ASCII
+// the JSON way; other keys, whose control characters and invalid UTF-8 it
+// would escape Go-style (\x01), go through json.Marshal.
+func writeJSONKey(buf *bytes.Buffer, key string) error {
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			b, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			buf.Write(b)
+			return nil
+		}
+	}
+	buf.WriteString(strconv.Quote(key))
+	return nil
+}
+
+// UnmarshalOrdered decodes a JSON document like json.Unmarshal into an
+// interface{}, except that objects are decoded as *OrderedObject in document
+// order. $keys, $spread, $each, the wildcards and the serialized result then
+// follow the key order of the document instead of ascending key order.
+func UnmarshalOrdered(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	v, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid data after top-level value")
+	}
+	return v, nil
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := &OrderedObject{Values: make(map[string]interface{})}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyTok.(string)
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := obj.Values[key]; !dup {
+				obj.Keys = append(obj.Keys, key)
+			}
+			obj.Values[key] = value
+		}
+		_, err := dec.Token() // '}'
+		return obj, err
+	case json.Delim('['):
+		arr := make([]interface{}, 0)
+		for dec.More() {
+			item, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, item)
+		}
+		_, err := dec.Token() // ']'
+		return arr, err
+	default:
+		return tok, nil
+	}
+}
@@ -0,0 +1,107 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Random numbers.
+//
+// $random and $shuffle draw from the process-wide generator of math/rand/v2,
+// which keeps per-thread state, so concurrent evaluations do not contend on a
+// lock. With WithRandomSeed each evaluation instead gets its own generator,
+// seeded with the same seed: the same expression evaluated against the same
+// input draws the same numbers, whatever runs concurrently.
+
+// maxRandomBound is the largest magnitude of a $random bound: integers beyond
+// it are not exactly representable as float64.
+const maxRandomBound = 1 << 53
+
+// rng returns the seeded generator of the evaluation, created on first use,
+// or nil when the evaluator is not seeded.
+func (e *Evaluator) rng(evalCtx *EvalContext) *rand.Rand {
+	if !e.opts.SeededRandom {
+		return nil
+	}
+	root := evalCtx.root
+	if root == nil {
+		root = evalCtx
+	}
+	if root.rng == nil {
+		seed := e.opts.RandomSeed
+		root.rng = rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	}
+	return root.rng
+}
+
+// fnRandom returns a pseudo-random number.
+// Signature: $random([n], [max])
+//
+//	$random()         a number between 0 (inclusive) and 1 (exclusive)
+//	$random(n)        an integer between 0 and n-1; n must be at least 1
+//	$random(min, max) an integer between min and max, both inclusive
+//
+// An undefined bound gives undefined.
+func fnRandom(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	r := e.rng(evalCtx)
+	if len(args) == 0 {
+		if r != nil {
+			return r.Float64(), nil
+		}
+		return rand.Float64(), nil
+	}
+
+	for _, arg := range args {
+		if arg == nil {
+			return nil, nil
+		}
+	}
+
+	lo, hi := 0.0, 0.0
+	if len(args) == 1 {
+		n, err := randomBound(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if n < 1 {
+			return nil, types.NewError(types.ErrRandomBounds,
+				fmt.Sprintf("$random: n must be at least 1, got %v", n), -1)
+		}
+		hi = n - 1
+	} else {
+		var err error
+		if lo, err = randomBound(args[0]); err != nil {
+			return nil, err
+		}
+		if hi, err = randomBound(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	if lo > hi {
+		return nil, types.NewError(types.ErrRandomBounds,
+			fmt.Sprintf("$random: empty range [%v, %v]", lo, hi), -1)
+	}
+
+	span := uint64(int64(hi)-int64(lo)) + 1
+	var off uint64
+	if r != nil {
+		off = r.Uint64N(span)
+	} else {
+		off = rand.Uint64N(span)
+	}
+	return float64(int64(lo) + int64(off)), nil
+}
+
+// randomBound checks that v is an integer usable as a $random bound.
+func randomBound(v interface{}) (float64, error) {
+	n, ok := numberValue(v)
+	if !ok || n != math.Trunc(n) || math.Abs(n) > maxRandomBound {
+		return 0, types.NewError(types.ErrRandomBounds,
+			fmt.Sprintf("$random: bounds must be integers, got %v", v), -1)
+	}
+	return n, nil
+}
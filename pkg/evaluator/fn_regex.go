@@ -6,11 +6,23 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/sandrolain/gosonata/pkg/types"
 )
 
+// fnMatch finds regex matches and returns the match objects.
+// Signature: $match(str, pattern [, limit])
+// Each match object has the matched text, its start (also as index) and end
+// positions in the string, counted in the units of WithStringSemantics, and
+// the captured groups.
+// pattern is a regex, a string holding a regular expression, or a custom
+// matcher function. At most limit matches are returned; like any sequence the
+// result is undefined without matches and the match object itself for one.
 func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
 	str, ok := args[0].(string)
 	if !ok {
 		str = fmt.Sprint(args[0])
@@ -23,6 +35,9 @@ func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 		if err != nil {
 			return nil, err
 		}
+		if limitNum < 0 {
+			return nil, fmt.Errorf("D3040: Third argument of match function must evaluate to a positive number")
+		}
 		limit = int(limitNum)
 	}
 
@@ -32,7 +47,9 @@ func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 
 	switch pattern := args[1].(type) {
 	case string:
-		regexPattern, err = getOrCompileRegex(regexp.QuoteMeta(pattern))
+		// A string pattern is a regular expression in Go (RE2) syntax, as the
+		// body of a regex literal is once translated.
+		regexPattern, err = getOrCompileRegex(pattern)
 		if err != nil {
 			return nil, fmt.Errorf("invalid regex pattern: %w", err)
 		}
@@ -55,9 +72,13 @@ func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 			if limit >= 0 && cnt >= limit {
 				break
 			}
+			if err := checkCancel(ctx, cnt); err != nil {
+				return nil, err
+			}
 			// Extract match fields
 			var matchStr string
 			var matchIndex float64
+			matchEnd := -1.0
 			var groups []interface{}
 
 			switch m := currentMatch.(type) {
@@ -68,6 +89,9 @@ func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 				if v, ok := m["start"].(float64); ok {
 					matchIndex = v
 				}
+				if v, ok := m["end"].(float64); ok {
+					matchEnd = v
+				}
 				if v, ok := m["groups"].([]interface{}); ok {
 					groups = v
 				}
@@ -78,6 +102,9 @@ func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 				if v, ok := m.Values["start"].(float64); ok {
 					matchIndex = v
 				}
+				if v, ok := m.Values["end"].(float64); ok {
+					matchEnd = v
+				}
 				if v, ok := m.Values["groups"].([]interface{}); ok {
 					groups = v
 				}
@@ -87,11 +114,16 @@ func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 			if groups == nil {
 				groups = []interface{}{}
 			}
+			if matchEnd < 0 {
+				matchEnd = matchIndex + float64(len(e.stringUnits(matchStr)))
+			}
 			matchObj := &OrderedObject{
-				Keys: []string{"match", "index", "groups"},
+				Keys: []string{"match", "index", "start", "end", "groups"},
 				Values: map[string]interface{}{
 					"match":  matchStr,
 					"index":  matchIndex,
+					"start":  matchIndex,
+					"end":    matchEnd,
 					"groups": groups,
 				},
 			}
@@ -115,19 +147,28 @@ func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 			}
 			currentMatch = nextMatch
 		}
-		return result, nil
+		return matchSequence(result), nil
 	default:
 		return nil, fmt.Errorf("pattern must be string or regex")
 	}
 
-	// Find all matches (for string/regex patterns; custom matcher handled above)
+	// Find all matches (for string/regex patterns; custom matcher handled above).
+	// The scan itself is linear in len(str) and cannot be interrupted, so the
+	// context is checked before it and while building the match objects.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	matches := regexPattern.FindAllStringSubmatchIndex(str, limit)
 	if matches == nil {
-		return []interface{}{}, nil
+		return nil, nil
 	}
 
 	matchObjects := make([]*OrderedObject, len(matches))
+	offsets := e.unitOffsets(str)
 	for i, match := range matches {
+		if err := checkCancel(ctx, i); err != nil {
+			return nil, err
+		}
 		// match[0:2] is the full match start:end
 		// match[2:] are capture groups
 		matchStr := str[match[0]:match[1]]
@@ -144,11 +185,14 @@ func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 			}
 		}
 
+		start, end := offsets.at(match[0]), offsets.at(match[1])
 		matchObjects[i] = &OrderedObject{
-			Keys: []string{"match", "index", "groups", "next"},
+			Keys: []string{"match", "index", "start", "end", "groups", "next"},
 			Values: map[string]interface{}{
 				"match":  matchStr,
-				"index":  float64(match[0]),
+				"index":  start,
+				"start":  start,
+				"end":    end,
 				"groups": groups,
 				"next":   nil, // populated below
 			},
@@ -182,7 +226,19 @@ func fnMatch(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []int
 		result[i] = mo
 	}
 
-	return result, nil
+	return matchSequence(result), nil
+}
+
+// matchSequence returns the matches of $match with sequence semantics, like
+// the reference: undefined for none, the match object itself for one.
+func matchSequence(matches []interface{}) interface{} {
+	switch len(matches) {
+	case 0:
+		return nil
+	case 1:
+		return matches[0]
+	}
+	return matches
 }
 
 // jsonataExpandTemplate expands a JSONata replacement template string.
@@ -278,21 +334,52 @@ func jsonataExpandTemplate(template string, numGroups int, groups []string, full
 
 // buildMatchObject creates the match object passed to lambda replacements in $replace.
 
-func buildMatchObject(fullMatch string, index int, groups []string) *OrderedObject {
+func buildMatchObject(fullMatch string, start, end float64, groups []string) *OrderedObject {
 	groupArr := make([]interface{}, len(groups))
 	for i, g := range groups {
 		groupArr[i] = g
 	}
 	return &OrderedObject{
-		Keys: []string{"match", "index", "groups"},
+		Keys: []string{"match", "index", "start", "end", "groups"},
 		Values: map[string]interface{}{
 			"match":  fullMatch,
-			"index":  float64(index),
+			"index":  start,
+			"start":  start,
+			"end":    end,
 			"groups": groupArr,
 		},
 	}
 }
 
+// unitOffsets converts byte offsets into a string to character positions in
+// the units of the string semantics (see stringUnits). Offsets are expected
+// in ascending order, as regex matches come; the scan restarts otherwise.
+type unitOffsets struct {
+	str    string
+	utf16  bool
+	byteAt int
+	unitAt int
+}
+
+func (e *Evaluator) unitOffsets(str string) *unitOffsets {
+	return &unitOffsets{str: str, utf16: e.opts.StringSemantics == StringUTF16}
+}
+
+func (u *unitOffsets) at(offset int) float64 {
+	if offset < u.byteAt {
+		u.byteAt, u.unitAt = 0, 0
+	}
+	for u.byteAt < offset {
+		r, size := utf8.DecodeRuneInString(u.str[u.byteAt:])
+		u.byteAt += size
+		u.unitAt++
+		if u.utf16 && r > 0xFFFF {
+			u.unitAt++
+		}
+	}
+	return float64(u.unitAt)
+}
+
 // fnReplace finds and replaces using regex or string pattern.
 // Signature: $replace(str, pattern, replacement [, limit])
 
@@ -327,6 +414,15 @@ func fnReplace(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []i
 			return nil, fmt.Errorf("D3010: pattern cannot be empty")
 		}
 		replacement := fmt.Sprint(args[2])
+		if len(replacement) > len(pattern) {
+			n := strings.Count(str, pattern)
+			if limit >= 0 {
+				n = min(n, limit)
+			}
+			if err := e.checkStringLength(len(str)+n*(len(replacement)-len(pattern)), "$replace"); err != nil {
+				return nil, err
+			}
+		}
 		if limit < 0 {
 			return strings.ReplaceAll(str, pattern, replacement), nil
 		}
@@ -343,12 +439,19 @@ func fnReplace(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []i
 		if limit >= 0 {
 			maxMatches = limit
 		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		allMatches := pattern.FindAllStringSubmatchIndex(str, maxMatches)
 
 		buf := acquireBuf()
 		defer releaseBuf(buf)
+		offsets := e.unitOffsets(str)
 		lastEnd := 0
-		for _, match := range allMatches {
+		for i, match := range allMatches {
+			if err := checkCancel(ctx, i); err != nil {
+				return nil, err
+			}
 			matchStart := match[0]
 			matchEnd := match[1]
 
@@ -375,7 +478,7 @@ func fnReplace(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []i
 
 			switch args[2].(type) {
 			case *Lambda, *FunctionDef:
-				matchObj := buildMatchObject(fullMatch, matchStart, groups)
+				matchObj := buildMatchObject(fullMatch, offsets.at(matchStart), offsets.at(matchEnd), groups)
 				result, err := e.callHOFFn(ctx, evalCtx, args[2], []interface{}{matchObj})
 				if err != nil {
 					return nil, err
@@ -396,6 +499,9 @@ func fnReplace(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []i
 			}
 
 			lastEnd = matchEnd
+			if err := e.checkStringLength(buf.Len()+len(str)-lastEnd, "$replace"); err != nil {
+				return nil, err
+			}
 		}
 
 		buf.WriteString(str[lastEnd:])
@@ -7,6 +7,7 @@ import (
 	"math"
 	"regexp"
 	"strings"
+	"unicode/utf16"
 	"unicode/utf8"
 
 	"github.com/sandrolain/gosonata/pkg/types"
@@ -49,18 +50,23 @@ func fnString(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 				"value cannot be represented as a JSON number", -1)
 		}
 		return e.toString(value), nil
-	case int, bool:
+	case int, bool, []byte:
 		return e.toString(value), nil
 	case *Lambda, *FunctionDef:
 		return "", nil
 	case map[string]interface{}, []interface{}, *OrderedObject:
-		processed, err := preprocessForStringify(e, value)
+		var g dataGuard
+		processed, err := preprocessForStringify(e, value, &g)
 		if err != nil {
 			return nil, err
 		}
 
 		// Check for non-finite values in processed data - D1001 (JSON serialization error)
-		if containsNonFinite(processed) {
+		nonFinite, err := containsNonFinite(processed)
+		if err != nil {
+			return nil, err
+		}
+		if nonFinite {
 			return nil, types.NewError(types.ErrNumberTooLarge,
 				"value cannot be represented as a JSON number", -1)
 		}
@@ -82,52 +88,74 @@ func fnString(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 	}
 }
 
-// containsNonFinite recursively checks if a value contains non-finite numbers (Inf, NaN)
+// containsNonFinite recursively checks if a value contains non-finite numbers (Inf, NaN).
+// Self-referencing data fails with D1010 (see dataGuard).
 
-func containsNonFinite(value interface{}) bool {
+func containsNonFinite(value interface{}) (bool, error) {
+	var g dataGuard
+	return findNonFinite(value, &g)
+}
+
+func findNonFinite(value interface{}, g *dataGuard) (bool, error) {
 	switch v := value.(type) {
 	case float64:
-		return math.IsInf(v, 0) || math.IsNaN(v)
+		return math.IsInf(v, 0) || math.IsNaN(v), nil
 	case map[string]interface{}:
+		if err := g.enter(v); err != nil {
+			return false, err
+		}
+		defer g.leave(v)
 		for _, item := range v {
-			if containsNonFinite(item) {
-				return true
+			if found, err := findNonFinite(item, g); found || err != nil {
+				return found, err
 			}
 		}
-		return false
+		return false, nil
 	case []interface{}:
+		if err := g.enter(v); err != nil {
+			return false, err
+		}
+		defer g.leave(v)
 		for _, item := range v {
-			if containsNonFinite(item) {
-				return true
+			if found, err := findNonFinite(item, g); found || err != nil {
+				return found, err
 			}
 		}
-		return false
+		return false, nil
 	case *OrderedObject:
+		if err := g.enter(v); err != nil {
+			return false, err
+		}
+		defer g.leave(v)
 		for _, item := range v.Values {
-			if containsNonFinite(item) {
-				return true
+			if found, err := findNonFinite(item, g); found || err != nil {
+				return found, err
 			}
 		}
-		return false
+		return false, nil
 	default:
-		return false
+		return false, nil
 	}
 }
 
-func preprocessForStringify(e *Evaluator, value interface{}) (interface{}, error) {
+func preprocessForStringify(e *Evaluator, value interface{}, g *dataGuard) (interface{}, error) {
 	switch v := value.(type) {
 	case types.Null:
 		return nil, nil
 	case float64:
 		return e.roundNumberForJSON(v), nil
 	case map[string]interface{}:
+		if err := g.enter(v); err != nil {
+			return nil, err
+		}
+		defer g.leave(v)
 		result := make(map[string]interface{}, len(v))
 		for key, item := range v {
 			if isFunctionValue(item) {
 				result[key] = ""
 				continue
 			}
-			processed, err := preprocessForStringify(e, item)
+			processed, err := preprocessForStringify(e, item, g)
 			if err != nil {
 				return nil, err
 			}
@@ -135,6 +163,10 @@ func preprocessForStringify(e *Evaluator, value interface{}) (interface{}, error
 		}
 		return result, nil
 	case *OrderedObject:
+		if err := g.enter(v); err != nil {
+			return nil, err
+		}
+		defer g.leave(v)
 		result := &OrderedObject{
 			Keys:   make([]string, 0, len(v.Keys)),
 			Values: make(map[string]interface{}, len(v.Values)),
@@ -146,7 +178,7 @@ func preprocessForStringify(e *Evaluator, value interface{}) (interface{}, error
 				result.Values[key] = ""
 				continue
 			}
-			processed, err := preprocessForStringify(e, item)
+			processed, err := preprocessForStringify(e, item, g)
 			if err != nil {
 				return nil, err
 			}
@@ -154,13 +186,17 @@ func preprocessForStringify(e *Evaluator, value interface{}) (interface{}, error
 		}
 		return result, nil
 	case []interface{}:
+		if err := g.enter(v); err != nil {
+			return nil, err
+		}
+		defer g.leave(v)
 		result := make([]interface{}, len(v))
 		for i, item := range v {
 			if isFunctionValue(item) {
 				result[i] = ""
 				continue
 			}
-			processed, err := preprocessForStringify(e, item)
+			processed, err := preprocessForStringify(e, item, g)
 			if err != nil {
 				return nil, err
 			}
@@ -171,7 +207,7 @@ func preprocessForStringify(e *Evaluator, value interface{}) (interface{}, error
 		if isFunctionValue(value) {
 			return "", nil
 		}
-		return value, nil
+		return value, g.count()
 	}
 }
 
@@ -190,13 +226,66 @@ func fnLength(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 		return nil, nil
 	}
 
-	// $length accepts only strings
+	// $length accepts only strings, and byte strings (their size in bytes)
+	if b, ok := args[0].([]byte); ok {
+		return float64(len(b)), nil
+	}
 	v, ok := args[0].(string)
 	if !ok {
 		return nil, fmt.Errorf("T0410: $length() argument must be a string")
 	}
-	// Count Unicode characters (runes), not bytes
-	return float64(utf8.RuneCountInString(v)), nil
+	// Count characters (runes or UTF-16 units), not bytes
+	n := utf8.RuneCountInString(v)
+	if e.opts.StringSemantics == StringUTF16 {
+		for _, r := range v {
+			if r > 0xFFFF {
+				n++
+			}
+		}
+	}
+	return float64(n), nil
+}
+
+// stringUnits splits s into the units counted by $length, $substring and
+// $pad: runes, or with StringUTF16 one UTF-16 code unit per element.
+func (e *Evaluator) stringUnits(s string) []rune {
+	runes := []rune(s)
+	if e.opts.StringSemantics != StringUTF16 {
+		return runes
+	}
+	encoded := utf16.Encode(runes)
+	if len(encoded) == len(runes) {
+		return runes
+	}
+	units := make([]rune, len(encoded))
+	for i, u := range encoded {
+		units[i] = rune(u)
+	}
+	return units
+}
+
+// unitsString is the inverse of stringUnits. A surrogate left unpaired by a
+// UTF-16 slice becomes U+FFFD.
+func (e *Evaluator) unitsString(units []rune) string {
+	if e.opts.StringSemantics != StringUTF16 {
+		return string(units)
+	}
+	encoded := make([]uint16, len(units))
+	for i, u := range units {
+		encoded[i] = uint16(u)
+	}
+	return string(utf16.Decode(encoded))
+}
+
+// maxUnitIndex bounds the indexes and widths of string functions: larger
+// numbers, up to ±Inf, are beyond the end of any string and are clamped to it
+// so that their conversion to int, and sums of two of them, cannot overflow.
+const maxUnitIndex = 1 << 30
+
+// clampIndex converts a string index or width to int, clamped to
+// ±maxUnitIndex.
+func clampIndex(f float64) int {
+	return int(math.Max(math.Min(f, maxUnitIndex), -maxUnitIndex))
 }
 
 func fnSubstring(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
@@ -216,9 +305,9 @@ func fnSubstring(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args [
 		return nil, err
 	}
 
-	// Convert to runes to handle Unicode correctly
-	runes := []rune(str)
-	startIdx := int(start)
+	// Convert to runes (or UTF-16 units) to handle Unicode correctly
+	runes := e.stringUnits(str)
+	startIdx := clampIndex(start)
 	strLen := len(runes)
 
 	// Handle negative indices (count from end)
@@ -233,7 +322,7 @@ func fnSubstring(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args [
 	}
 
 	if len(args) == 2 {
-		return string(runes[startIdx:]), nil
+		return e.unitsString(runes[startIdx:]), nil
 	}
 
 	length, err := e.toNumber(args[2])
@@ -241,7 +330,7 @@ func fnSubstring(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args [
 		return nil, err
 	}
 
-	lengthInt := int(length)
+	lengthInt := clampIndex(length)
 	if lengthInt <= 0 {
 		return "", nil
 	}
@@ -251,7 +340,7 @@ func fnSubstring(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args [
 		endIdx = strLen
 	}
 
-	return string(runes[startIdx:endIdx]), nil
+	return e.unitsString(runes[startIdx:endIdx]), nil
 }
 
 func fnUppercase(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
@@ -394,7 +483,7 @@ func fnJoin(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inte
 	}
 
 	// If first argument is a string, return it directly (like single-element join)
-	if str, ok := args[0].(string); ok {
+	if str, ok := e.joinItem(args[0]); ok {
 		return str, nil
 	}
 
@@ -416,17 +505,36 @@ func fnJoin(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inte
 
 	// All array elements must be strings
 	strs := make([]string, len(arr))
+	size := len(separator) * max(len(arr)-1, 0)
 	for i, v := range arr {
-		s, ok := v.(string)
+		s, ok := e.joinItem(v)
 		if !ok {
 			return nil, types.NewError("T0412", "The argument of the function '$join' is not an array of strings", -1)
 		}
 		strs[i] = s
+		size += len(s)
+	}
+	if err := e.checkStringLength(size, "$join"); err != nil {
+		return nil, err
 	}
 
 	return strings.Join(strs, separator), nil
 }
 
+// joinItem returns the string that v contributes to $join: v itself if it is
+// a string and, with WithJoinCoercion, the $string of a number or boolean.
+func (e *Evaluator) joinItem(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case float64, int, int64, bool:
+		if e.opts.JoinCoercion {
+			return e.toString(x), true
+		}
+	}
+	return "", false
+}
+
 // --- Type Functions ---
 
 func fnPad(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
@@ -435,20 +543,21 @@ func fnPad(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 	}
 
 	str := e.toString(args[0])
-	strRunes := []rune(str)
+	strRunes := e.stringUnits(str)
 
 	width, err := e.toNumber(args[1])
 	if err != nil {
 		return nil, err
 	}
-	targetWidth := int(width)
+	// The length limit reports widths beyond maxUnitIndex.
+	targetWidth := clampIndex(width)
 
 	// Default pad character is space
 	padRunes := []rune{' '}
 	if len(args) > 2 && args[2] != nil {
 		padStr := e.toString(args[2])
-		if len([]rune(padStr)) > 0 {
-			padRunes = []rune(padStr)
+		if padStr != "" {
+			padRunes = e.stringUnits(padStr)
 		}
 	}
 
@@ -465,6 +574,9 @@ func fnPad(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 	}
 
 	padCount := targetWidth - strLen
+	if err := e.checkStringLength(len(str)+padCount, "$pad"); err != nil {
+		return nil, err
+	}
 
 	// Build padding by cycling through pad runes
 	padding := make([]rune, padCount)
@@ -473,9 +585,9 @@ func fnPad(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inter
 	}
 
 	if leftPad {
-		return string(padding) + string(strRunes), nil
+		return e.unitsString(padding) + str, nil
 	}
-	return string(strRunes) + string(padding), nil
+	return str + e.unitsString(padding), nil
 }
 
 // fnSubstringBefore returns the substring before the first occurrence of a separator.
@@ -2,8 +2,8 @@ package evaluator
 
 import (
 	"context"
+	"regexp"
 	"strconv"
-	"strings"
 
 	"github.com/sandrolain/gosonata/pkg/types"
 )
@@ -24,6 +24,8 @@ func fnType(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []inte
 	switch value.(type) {
 	case string:
 		return "string", nil
+	case []byte:
+		return "bytes", nil
 	case float64:
 		return "number", nil
 	case bool:
@@ -45,30 +47,39 @@ func fnExists(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []in
 	return args[0] != nil, nil
 }
 
+// decimalNumber is the string form $number accepts besides the 0x, 0o and 0b
+// integer prefixes: JSON number syntax, leading zeros allowed. Go's ParseFloat
+// alone would also take "Inf", "NaN", hex floats and underscores.
+var decimalNumber = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([Ee][-+]?[0-9]+)?$`)
+
 func fnNumber(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
 	// undefined inputs return undefined
 	if args[0] == nil {
 		return nil, nil
 	}
 	if str, ok := args[0].(string); ok {
-		if num, err := strconv.ParseFloat(str, 64); err == nil {
-			return num, nil
-		}
-		if strings.HasPrefix(str, "0x") || strings.HasPrefix(str, "0X") {
-			if num, err := strconv.ParseInt(str[2:], 16, 64); err == nil {
-				return float64(num), nil
+		if decimalNumber.MatchString(str) {
+			// strconv reports values beyond float64 as ErrRange (±Inf).
+			if num, err := strconv.ParseFloat(str, 64); err == nil {
+				return num, nil
 			}
-		}
-		if strings.HasPrefix(str, "0o") || strings.HasPrefix(str, "0O") {
-			if num, err := strconv.ParseInt(str[2:], 8, 64); err == nil {
-				return float64(num), nil
+		} else if len(str) > 2 && str[0] == '0' {
+			base := 0
+			switch str[1] {
+			case 'x', 'X':
+				base = 16
+			case 'o', 'O':
+				base = 8
+			case 'b', 'B':
+				base = 2
 			}
-		}
-		if strings.HasPrefix(str, "0b") || strings.HasPrefix(str, "0B") {
-			if num, err := strconv.ParseInt(str[2:], 2, 64); err == nil {
-				return float64(num), nil
+			if base != 0 {
+				if num, err := strconv.ParseUint(str[2:], base, 64); err == nil {
+					return float64(num), nil
+				}
 			}
 		}
+		return nil, types.NewError(types.ErrCastToNumber, "unable to cast value to a number: "+strconv.Quote(str), -1)
 	}
 
 	return e.toNumber(args[0])
@@ -82,7 +93,7 @@ func fnBoolean(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []i
 	if args[0] == nil {
 		return nil, nil // undefined → undefined
 	}
-	return e.isTruthyBoolean(args[0]), nil
+	return e.isTruthy(args[0]), nil
 }
 
 func fnNot(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
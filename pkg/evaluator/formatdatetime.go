@@ -0,0 +1,525 @@
+//go:build !minimal
+
+package evaluator
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Date/time pictures.
+//
+// $fromMillis and $now format timestamps with XPath picture strings
+// (fn:format-dateTime): literal text with variable markers in square brackets,
+// "[[" and "]]" standing for literal brackets. A marker holds a component
+// specifier, an optional presentation modifier and an optional width modifier,
+// e.g. [Y0001], [MNn,*-3], [D1o], [H01]:[m01], [Z01:01t]:
+//
+//	Y year, M month, D day of month, d day of year, F day of week (Monday = 1),
+//	W ISO week, X ISO week-numbering year, H hour (0-23), h hour (1-12),
+//	P am/pm, m minute, s second, f milliseconds, Z offset (+01:00), z GMT offset.
+//
+// Presentations are decimal digit patterns (1, 01, 001, 9;999), the roman
+// numerals (I, i), words (W, w, Ww) and letters (A, a) of $formatInteger, and
+// names for M, F and P (N upper case, n lower case, Nn title case), with an "o"
+// suffix for ordinals on numbers (1st, 2nd, second). Z and z take an offset pattern (01:01, 0101, 01), with a
+// "t" suffix rendering a zero offset as "Z"; [ZN] is the timezone abbreviation.
+// The width modifier ",min-max" pads numbers and truncates names and years.
+//
+// The picture engine is left out of minimal builds (see minimal.go).
+
+// dateMarker is a variable marker of a date/time picture.
+type dateMarker struct {
+	component    rune
+	presentation string
+	minWidth     int // -1 when unset
+	maxWidth     int // -1 when unset
+}
+
+// defaultPresentations are the presentations of markers without one.
+var defaultPresentations = map[rune]string{
+	'Y': "1", 'M': "1", 'D': "1", 'd': "1", 'F': "n", 'W': "1", 'X': "1",
+	'H': "1", 'h': "1", 'P': "n", 'm': "01", 's': "01", 'f': "1",
+	'Z': "01:01", 'z': "01:01",
+}
+
+var monthNames = []string{"January", "February", "March", "April", "May", "June",
+	"July", "August", "September", "October", "November", "December"}
+
+var dayNames = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// scanPicture splits a date/time picture into literal text and markers.
+func scanPicture(picture string, literal func(string), marker func(dateMarker) error) error {
+	for i := 0; i < len(picture); {
+		c := picture[i]
+		switch {
+		case c == '[' && strings.HasPrefix(picture[i:], "[["):
+			literal("[")
+			i += 2
+		case c == ']' && strings.HasPrefix(picture[i:], "]]"):
+			literal("]")
+			i += 2
+		case c == '[':
+			end := strings.IndexByte(picture[i:], ']')
+			if end < 0 {
+				return fmt.Errorf("D3135: no matching closing bracket ']' in date/time picture string")
+			}
+			m, err := parseDateMarker(picture[i+1 : i+end])
+			if err != nil {
+				return err
+			}
+			if err := marker(m); err != nil {
+				return err
+			}
+			i += end + 1
+		default:
+			next := strings.IndexAny(picture[i:], "[]")
+			if next < 0 {
+				next = len(picture) - i
+			} else if next == 0 {
+				next = 1 // a lone ]
+			}
+			literal(picture[i : i+next])
+			i += next
+		}
+	}
+	return nil
+}
+
+// formatDateTime formats t, in its own location, with an XPath picture.
+func formatDateTime(t time.Time, picture string) (string, error) {
+	var out strings.Builder
+	err := scanPicture(picture, func(text string) {
+		out.WriteString(text)
+	}, func(m dateMarker) error {
+		s, err := m.format(t)
+		out.WriteString(s)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// parseDateMarker parses the text between the brackets of a marker. Whitespace
+// inside markers is ignored.
+func parseDateMarker(text string) (dateMarker, error) {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, text)
+	if text == "" {
+		return dateMarker{}, fmt.Errorf("D3132: empty marker in date/time picture string")
+	}
+	m := dateMarker{component: rune(text[0]), minWidth: -1, maxWidth: -1}
+	rest := text[1:]
+	if comma := strings.LastIndexByte(rest, ','); comma >= 0 {
+		width := rest[comma+1:]
+		rest = rest[:comma]
+		min, max, ranged := strings.Cut(width, "-")
+		m.minWidth = parseWidth(min)
+		m.maxWidth = parseWidth(max)
+		if !ranged && m.component == 'Y' {
+			m.maxWidth = m.minWidth // [Y,2]: two-digit year
+		}
+	}
+	m.presentation = rest
+	if _, ok := defaultPresentations[m.component]; !ok {
+		return dateMarker{}, fmt.Errorf("D3132: unknown component specifier %q in date/time picture string", m.component)
+	}
+	if m.presentation == "" {
+		m.presentation = defaultPresentations[m.component]
+	}
+	return m, nil
+}
+
+func parseWidth(s string) int {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return n
+	}
+	return -1 // empty or "*"
+}
+
+// format renders the marker for t.
+func (m dateMarker) format(t time.Time) (string, error) {
+	weekday := int(t.Weekday()+6)%7 + 1 // Monday = 1
+	isoYear, isoWeek := t.ISOWeek()
+
+	var value int
+	var names []string
+	switch m.component {
+	case 'Y':
+		value = t.Year()
+	case 'M':
+		value, names = int(t.Month()), monthNames
+	case 'D':
+		value = t.Day()
+	case 'd':
+		value = t.YearDay()
+	case 'F':
+		value, names = weekday, dayNames
+	case 'W':
+		value = isoWeek
+	case 'X':
+		value = isoYear
+	case 'H':
+		value = t.Hour()
+	case 'h':
+		value = (t.Hour()+11)%12 + 1
+	case 'P':
+		value, names = t.Hour()/12+1, []string{"am", "pm"}
+	case 'm':
+		value = t.Minute()
+	case 's':
+		value = t.Second()
+	case 'f':
+		value = t.Nanosecond() / 1e6
+	case 'Z', 'z':
+		return m.formatOffset(t), nil
+	}
+
+	switch m.presentation {
+	case "N", "n", "Nn":
+		if names == nil {
+			return "", fmt.Errorf("D3133: the name modifier can only be applied to months and days in the date/time picture string, not %c", m.component)
+		}
+		name := names[value-1]
+		if m.maxWidth > 0 && len(name) > m.maxWidth {
+			name = name[:m.maxWidth]
+		}
+		switch m.presentation {
+		case "N":
+			return strings.ToUpper(name), nil
+		case "n":
+			return strings.ToLower(name), nil
+		}
+		return name, nil
+	}
+	return m.formatNumber(value)
+}
+
+// formatNumber renders a numeric component: with a decimal digit pattern,
+// whose grouping separators are kept at their positions from the right, or
+// with one of the presentations of $formatInteger (see formatIntegerPicture).
+func (m dateMarker) formatNumber(value int) (string, error) {
+	presentation, ordinal := strings.CutSuffix(m.presentation, "o")
+	if s, ok := formatIntegerPicture(value, presentation); ok {
+		if ordinal && strings.ContainsAny(presentation, "Ww") {
+			s = ordinalWords(s)
+		}
+		return s, nil
+	}
+	digits, err := m.digits(presentation)
+	if err != nil {
+		return "", err
+	}
+	width := max(digits, m.minWidth, 1)
+	s := fmt.Sprintf("%0*d", width, value)
+	if m.component == 'Y' && m.maxWidth > 0 && len(s) > m.maxWidth {
+		s = s[len(s)-m.maxWidth:] // [Y,2]: two-digit year
+	}
+	s = groupDigits(s, presentation)
+	if ordinal {
+		s += ordinalSuffix(value)
+	}
+	return s, nil
+}
+
+// digits returns the number of digits of a decimal digit pattern such as 01,
+// #0 or 0,000, and fails for presentations that are not one.
+func (m dateMarker) digits(presentation string) (int, error) {
+	digits := 0
+	for _, r := range presentation {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '#':
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return 0, fmt.Errorf("D3133: the presentation modifier %q is not supported for the %c component of the date/time picture string", m.presentation, m.component)
+		}
+	}
+	return digits, nil
+}
+
+// groupDigits inserts into the digits s the grouping separators of the
+// decimal digit pattern, at the same positions from the right.
+func groupDigits(s, pattern string) string {
+	var separators []int // positions from the right
+	right := 0
+	for i := len(pattern) - 1; i >= 0; i-- {
+		if c := pattern[i]; c == '#' || (c >= '0' && c <= '9') {
+			right++
+		} else {
+			separators = append(separators, right)
+		}
+	}
+	if len(separators) == 0 {
+		return s
+	}
+	var out []byte
+	next := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		at := len(s) - 1 - i
+		for next < len(separators) && separators[next] == at && at > 0 {
+			out = append(out, pattern[len(pattern)-1-at-next])
+			next++
+		}
+		out = append(out, s[i])
+	}
+	slices.Reverse(out)
+	return string(out)
+}
+
+// ordinalWords turns a number written in words into its ordinal: twenty-one
+// into twenty-first.
+func ordinalWords(words string) string {
+	last := strings.LastIndexAny(words, " -") + 1
+	word := words[last:]
+	irregular := map[string]string{
+		"one": "first", "two": "second", "three": "third", "five": "fifth",
+		"eight": "eighth", "nine": "ninth", "twelve": "twelfth",
+	}
+	lower := strings.ToLower(word)
+	ordinal, ok := irregular[lower]
+	switch {
+	case ok:
+	case strings.HasSuffix(lower, "y"):
+		ordinal = lower[:len(lower)-1] + "ieth"
+	default:
+		ordinal = lower + "th"
+	}
+	switch {
+	case word == strings.ToUpper(word):
+		ordinal = strings.ToUpper(ordinal)
+	case word[0] >= 'A' && word[0] <= 'Z':
+		ordinal = strings.ToUpper(ordinal[:1]) + ordinal[1:]
+	}
+	return words[:last] + ordinal
+}
+
+func ordinalSuffix(n int) string {
+	if n%100 >= 11 && n%100 <= 13 {
+		return "th"
+	}
+	switch n % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
+	}
+	return "th"
+}
+
+// formatOffset renders a Z or z marker.
+func (m dateMarker) formatOffset(t time.Time) string {
+	name, offset := t.Zone()
+	if m.component == 'Z' && m.presentation == "N" {
+		if name != "" {
+			return name
+		}
+		return m.withPresentation("01:01").formatOffset(t)
+	}
+	presentation, zulu := strings.CutSuffix(m.presentation, "t")
+	if zulu && offset == 0 {
+		return "Z"
+	}
+
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	hours, minutes := offset/3600, offset/60%60
+	var s string
+	switch {
+	case strings.Contains(presentation, ":"):
+		s = fmt.Sprintf("%c%02d:%02d", sign, hours, minutes)
+	case len(presentation) > 2:
+		s = fmt.Sprintf("%c%02d%02d", sign, hours, minutes)
+	case minutes != 0:
+		s = fmt.Sprintf("%c%02d:%02d", sign, hours, minutes)
+	default:
+		s = fmt.Sprintf("%c%02d", sign, hours)
+	}
+	if m.component == 'z' {
+		return "GMT" + s
+	}
+	return s
+}
+
+func (m dateMarker) withPresentation(p string) dateMarker {
+	m.presentation = p
+	return m
+}
+
+// parseDateTime parses timestamp, formatted with an XPath picture, into
+// milliseconds since the epoch. An offset marker ([Z], [Z0000], [z]) reads
+// the timezone from the timestamp; without one the timestamp is read in loc.
+// Missing components default to the start of the current year.
+func parseDateTime(timestamp, picture string, loc *time.Location) (interface{}, error) {
+	var pattern strings.Builder
+	var markers []dateMarker
+	pattern.WriteByte('^')
+	err := scanPicture(picture, func(text string) {
+		pattern.WriteString(regexp.QuoteMeta(text))
+	}, func(m dateMarker) error {
+		if err := m.parsable(); err != nil {
+			return err
+		}
+		markers = append(markers, m)
+		pattern.WriteString(m.pattern())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pattern.WriteByte('$')
+
+	re, err := getOrCompileRegex(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid picture format: %s", picture)
+	}
+	matches := re.FindStringSubmatch(timestamp)
+	if matches == nil {
+		return nil, fmt.Errorf("D3110: cannot parse timestamp with picture format: %s", timestamp)
+	}
+
+	year, month, day, yearDay := time.Now().UTC().Year(), 1, 1, 0
+	var hour, minute, second, millis int
+	pm := -1
+	for i, m := range markers {
+		text := matches[i+1]
+		if m.component == 'Z' || m.component == 'z' {
+			if loc, err = parseOffset(text); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		value, err := m.parseValue(text)
+		if err != nil {
+			return nil, err
+		}
+		switch m.component {
+		case 'Y':
+			year = value
+		case 'M':
+			month = value
+		case 'D':
+			day = value
+		case 'd':
+			yearDay = value
+		case 'H', 'h':
+			hour = value
+		case 'P':
+			pm = value - 1
+		case 'm':
+			minute = value
+		case 's':
+			second = value
+		case 'f':
+			millis = value
+		}
+	}
+	if pm >= 0 {
+		hour = hour%12 + 12*pm
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, millis*1e6, loc)
+	if yearDay > 0 {
+		t = time.Date(year, 1, yearDay, hour, minute, second, millis*1e6, loc)
+	}
+	return float64(t.UnixMilli()), nil
+}
+
+// pattern returns the regular expression group matching the marker.
+func (m dateMarker) pattern() string {
+	switch m.component {
+	case 'Z':
+		return `(Z|[+-]\d{2}(?::?\d{2})?)`
+	case 'z':
+		return `GMT([+-]\d{2}(?::?\d{2})?)`
+	case 'P':
+		return `([AaPp]\.?[Mm]\.?)`
+	}
+	switch m.presentation {
+	case "N", "n", "Nn":
+		return `(\pL+)`
+	}
+	presentation, ordinal := strings.CutSuffix(m.presentation, "o")
+	digits := 0
+	for _, r := range presentation {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	group := `(\d+)`
+	if digits > 1 {
+		group = fmt.Sprintf(`(\d{%d})`, digits)
+	}
+	if ordinal {
+		group += `(?:st|nd|rd|th)`
+	}
+	return group
+}
+
+// parsable fails for the markers that timestamps cannot be parsed with:
+// numbers presented other than as decimal digits, such as roman numerals.
+func (m dateMarker) parsable() error {
+	switch m.component {
+	case 'Z', 'z', 'P':
+		return nil
+	}
+	switch m.presentation {
+	case "N", "n", "Nn":
+		return nil
+	}
+	presentation, _ := strings.CutSuffix(m.presentation, "o")
+	if _, ok := formatIntegerPicture(1, presentation); ok {
+		return fmt.Errorf("D3133: the presentation modifier %q cannot be parsed in the date/time picture string", m.presentation)
+	}
+	_, err := m.digits(presentation)
+	return err
+}
+
+// parseValue converts the text matched by a non-offset marker to its value.
+func (m dateMarker) parseValue(text string) (int, error) {
+	var names []string
+	switch m.component {
+	case 'M':
+		names = monthNames
+	case 'F':
+		names = dayNames
+	case 'P':
+		if strings.HasPrefix(strings.ToLower(text), "p") {
+			return 2, nil
+		}
+		return 1, nil
+	}
+	if names != nil && (m.presentation == "N" || m.presentation == "n" || m.presentation == "Nn") {
+		for i, name := range names {
+			if len(text) >= 3 && strings.HasPrefix(strings.ToLower(name), strings.ToLower(text)) {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("D3110: cannot parse %q as a %s name", text, map[rune]string{'M': "month", 'F': "day"}[m.component])
+	}
+	return strconv.Atoi(text)
+}
+
+// parseOffset returns the fixed timezone of a parsed offset (Z, ±HH, ±HHMM or
+// ±HH:MM).
+func parseOffset(text string) (*time.Location, error) {
+	text = strings.Replace(text, ":", "", 1)
+	if len(text) == 3 {
+		text += "00"
+	}
+	return loadTimezone(text)
+}
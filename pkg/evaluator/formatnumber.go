@@ -1,3 +1,5 @@
+//go:build !minimal
+
 package evaluator
 
 import (
@@ -7,6 +9,8 @@ import (
 	"strconv"
 	"strings"
 	"unicode/utf8"
+
+	"github.com/sandrolain/gosonata/pkg/types"
 )
 
 // DecimalFormat defines the symbols used in a FormatNumber picture string.
@@ -670,3 +674,88 @@ func roundToDecimalPlaces(x float64, precision int) float64 {
 	}
 	return x / multiplier
 }
+
+// formatNumberPicture implements $formatNumber(number, picture [, options]):
+// options overrides the symbols of the default decimal format.
+func formatNumberPicture(num float64, picture string, options interface{}) (interface{}, error) {
+	// Create decimal format with default or custom options
+	format := NewDecimalFormat()
+
+	// Parse options if provided
+	if options != nil {
+		var opts map[string]interface{}
+
+		// Handle OrderedObject or regular map
+		switch v := options.(type) {
+		case *OrderedObject:
+			opts = v.Values
+		case map[string]interface{}:
+			opts = v
+		}
+
+		if opts != nil {
+			if ds, ok := opts["decimal-separator"].(string); ok && len(ds) > 0 {
+				for _, r := range ds {
+					format.DecimalSeparator = r
+					break
+				}
+			}
+			if gs, ok := opts["grouping-separator"].(string); ok && len(gs) > 0 {
+				for _, r := range gs {
+					format.GroupSeparator = r
+					break
+				}
+			}
+			if es, ok := opts["exponent-separator"].(string); ok && len(es) > 0 {
+				for _, r := range es {
+					format.ExponentSeparator = r
+					break
+				}
+			}
+			if ms, ok := opts["minus-sign"].(string); ok && len(ms) > 0 {
+				for _, r := range ms {
+					format.MinusSign = r
+					break
+				}
+			}
+			if inf, ok := opts["infinity"].(string); ok {
+				format.Infinity = inf
+			}
+			if nan, ok := opts["NaN"].(string); ok {
+				format.NaN = nan
+			}
+			if pct, ok := opts["percent"].(string); ok {
+				format.Percent = pct
+			}
+			if pm, ok := opts["per-mille"].(string); ok {
+				format.PerMille = pm
+			}
+			if zd, ok := opts["zero-digit"].(string); ok && len(zd) > 0 {
+				for _, r := range zd {
+					format.ZeroDigit = r
+					break
+				}
+			}
+			if od, ok := opts["digit"].(string); ok && len(od) > 0 {
+				for _, r := range od {
+					format.OptionalDigit = r
+					break
+				}
+			}
+			if ps, ok := opts["pattern-separator"].(string); ok && len(ps) > 0 {
+				for _, r := range ps {
+					format.PatternSeparator = r
+					break
+				}
+			}
+		}
+	}
+
+	// Use the complete XPath-compliant formatting
+	formatted, err := FormatNumberWithPicture(num, picture, format)
+	if err != nil {
+		return nil, types.NewError(types.ErrorCode(err.Error()[:5]), err.Error()[7:], -1)
+	}
+
+	return formatted, nil
+}
@@ -0,0 +1,276 @@
+package evaluator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Frozen values (Freeze).
+//
+// Reference data such as lookup tables is typically bound to every evaluation
+// of a service, from many goroutines at once. Plain Go values work, but each
+// evaluation pays again for what the host could have settled once: numbers
+// of other Go types are converted at each use, keys are duplicated in every
+// object, and nothing stops the host, or an in-place transform, from
+// modifying the data while it is read. Freeze settles it once: it copies the
+// value into the evaluator's own representation, checking it on the way, and
+// the copy is never modified afterwards.
+//
+// Since the copy never changes, work derived from it can be kept with it
+// too. A filter `array[field = value]` over an array of a frozen value, the
+// typical lookup in a reference table, is answered from a hash index of the
+// array on field, built by the first evaluation that needs it and used by
+// all the following ones, from any goroutine: see frozenFilter. Freeze
+// records the arrays long enough to be worth indexing, so that a filtered
+// array is recognised as frozen by its address.
+
+// Frozen is an immutable value built by Freeze, safe to share across
+// evaluations and goroutines. Bind it as the input of an evaluation, as a
+// variable (EvalWithBindings) or as a document returned by a DocumentLoader.
+type Frozen struct {
+	value interface{}
+	// arrays maps the first element of each array of at least
+	// autoIndexMinItems items to the length of the array.
+	arrays map[*interface{}]int
+	// indexes holds a *frozenIndex per filterIndexKey; entries counts them.
+	indexes sync.Map
+	entries atomic.Int32
+}
+
+// frozenIndex is a filter index of a frozen array, built once.
+type frozenIndex struct {
+	once sync.Once
+	idx  filterIndex
+}
+
+// Freeze returns an immutable copy of v, a JSON-like value: nil, booleans,
+// strings, numbers of any Go numeric type or json.Number, byte strings,
+// slices, and maps with string keys (including OrderedObject), nested to any
+// depth. Numbers become float64 and slices and maps the []interface{} and
+// map[string]interface{} the evaluator navigates directly; the object keys
+// that repeat across the value are stored once. Freeze fails on other types,
+// on non-finite numbers and on values referencing themselves. A Frozen value
+// nested in v is shared, not copied.
+func Freeze(v interface{}) (*Frozen, error) {
+	if f, ok := v.(*Frozen); ok {
+		return f, nil
+	}
+	fz := freezer{
+		g:      dataGuard{maxNodes: maxCloneNodes},
+		keys:   make(map[string]string),
+		arrays: make(map[*interface{}]int),
+	}
+	value, err := fz.freeze(v)
+	if err != nil {
+		return nil, fmt.Errorf("freeze: %w", err)
+	}
+	return &Frozen{value: value, arrays: fz.arrays}, nil
+}
+
+// Value returns the frozen value. It must not be modified.
+func (f *Frozen) Value() interface{} { return f.value }
+
+// MarshalJSON encodes the frozen value.
+func (f *Frozen) MarshalJSON() ([]byte, error) { return json.Marshal(f.value) }
+
+// thaw returns the value of v if it is Frozen, recording it on the root of c,
+// v collected if it is a Go iterator (see collectSeq), and v otherwise.
+func (c *EvalContext) thaw(v interface{}) interface{} {
+	f, ok := v.(*Frozen)
+	if !ok {
+		return collectSeq(v)
+	}
+	c.root.frozen = append(c.root.frozen, f)
+	return f.value
+}
+
+// freezer copies one value for Freeze.
+type freezer struct {
+	g dataGuard
+	// keys maps each object key seen so far to its shared copy.
+	keys map[string]string
+	// arrays collects Frozen.arrays.
+	arrays map[*interface{}]int
+}
+
+func (fz *freezer) key(k string) string {
+	if shared, ok := fz.keys[k]; ok {
+		return shared
+	}
+	fz.keys[k] = k
+	return k
+}
+
+func (fz *freezer) freeze(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil, bool, string, types.Null:
+		return v, fz.g.count()
+	case float64:
+		return fz.number(x)
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", x.String())
+		}
+		return fz.number(f)
+	case []byte:
+		return append([]byte(nil), x...), fz.g.count()
+	case *Frozen:
+		for first, n := range x.arrays {
+			fz.arrays[first] = n
+		}
+		return x.value, nil
+	case []interface{}:
+		if err := fz.g.enter(x); err != nil {
+			return nil, err
+		}
+		defer fz.g.leave(x)
+		arr := make([]interface{}, len(x))
+		for i, item := range x {
+			var err error
+			if arr[i], err = fz.freeze(item); err != nil {
+				return nil, err
+			}
+		}
+		if len(arr) >= autoIndexMinItems {
+			fz.arrays[&arr[0]] = len(arr)
+		}
+		return arr, nil
+	case map[string]interface{}:
+		if err := fz.g.enter(x); err != nil {
+			return nil, err
+		}
+		defer fz.g.leave(x)
+		obj := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			value, err := fz.freeze(item)
+			if err != nil {
+				return nil, err
+			}
+			obj[fz.key(k)] = value
+		}
+		return obj, nil
+	case *OrderedObject:
+		if err := fz.g.enter(x); err != nil {
+			return nil, err
+		}
+		defer fz.g.leave(x)
+		obj := &OrderedObject{Keys: make([]string, len(x.Keys)), Values: make(map[string]interface{}, len(x.Keys))}
+		for i, k := range x.Keys {
+			value, err := fz.freeze(x.Values[k])
+			if err != nil {
+				return nil, err
+			}
+			obj.Keys[i] = fz.key(k)
+			obj.Values[obj.Keys[i]] = value
+		}
+		return obj, nil
+	}
+	return fz.reflectValue(v)
+}
+
+func (fz *freezer) number(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("non-finite number %v", f)
+	}
+	return f, fz.g.count()
+}
+
+// reflectValue freezes the values of other Go types: numbers, slices, arrays
+// and maps with string keys, such as []string or map[string]int.
+func (fz *freezer) reflectValue(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fz.number(float64(rv.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fz.number(float64(rv.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return fz.number(rv.Float())
+	case reflect.Bool:
+		return rv.Bool(), fz.g.count()
+	case reflect.String:
+		return rv.String(), fz.g.count()
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, fz.g.count()
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		return fz.freeze(items)
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if rv.IsNil() {
+			return nil, fz.g.count()
+		}
+		obj := make(map[string]interface{}, rv.Len())
+		for it := rv.MapRange(); it.Next(); {
+			obj[it.Key().String()] = it.Value().Interface()
+		}
+		return fz.freeze(obj)
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil, fz.g.count()
+		}
+	}
+	return nil, fmt.Errorf("unsupported value of type %T", v)
+}
+
+// index returns the index of arr on field if arr is an array of f, building
+// it on first use, or nil if arr is not or the index budget of f is spent.
+// The index is shared by every evaluation of f.
+func (f *Frozen) index(arr []interface{}, field string) *filterIndex {
+	if n, ok := f.arrays[&arr[0]]; !ok || n != len(arr) {
+		return nil
+	}
+	key := filterIndexKey{first: &arr[0], n: len(arr), field: field}
+	entry, ok := f.indexes.Load(key)
+	if !ok {
+		if f.entries.Add(1) > autoIndexMaxEntries {
+			f.entries.Add(-1)
+			return nil
+		}
+		var loaded bool
+		if entry, loaded = f.indexes.LoadOrStore(key, &frozenIndex{}); loaded {
+			f.entries.Add(-1)
+		}
+	}
+	fi := entry.(*frozenIndex)
+	fi.once.Do(func() { fi.idx.build(arr, field) })
+	return &fi.idx
+}
+
+// frozenFilter answers an equality filter over an array of a Frozen value
+// read by the evaluation from the index of the array (see Frozen.index). ok
+// is false when the filter or the array cannot use one; the caller then
+// falls back to the regular predicate loop.
+func (e *Evaluator) frozenFilter(ctx context.Context, collection interface{}, pred *types.ASTNode, evalCtx *EvalContext) (result interface{}, ok bool, err error) {
+	arr, isArray := collection.([]interface{})
+	if !isArray || len(arr) < autoIndexMinItems {
+		return nil, false, nil
+	}
+	field, valueNode, isEq := indexableEquality(pred)
+	if !isEq {
+		return nil, false, nil
+	}
+	for _, f := range evalCtx.root.frozen {
+		if idx := f.index(arr, field); idx != nil {
+			if idx.unusable || idx.missing && e.opts.StrictNavigation {
+				return nil, false, nil
+			}
+			return e.lookupFilterIndex(ctx, idx, arr, valueNode, evalCtx)
+		}
+	}
+	return nil, false, nil
+}
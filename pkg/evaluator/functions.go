@@ -2,6 +2,8 @@ package evaluator
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"sync"
 
 	"github.com/sandrolain/gosonata/pkg/types"
@@ -12,8 +14,18 @@ type FunctionDef struct {
 	Name           string
 	MinArgs        int
 	MaxArgs        int  // -1 for unlimited
-	AcceptsContext bool // If true, pass context value as first arg when called with no args
-	Impl           FunctionImpl
+	AcceptsContext bool // If true, pass context value as first arg when called with fewer than MinArgs args
+	// Signature is the reference JSONata signature of a built-in. Parameters
+	// marked "-" receive the context value when their argument is missing
+	// (see contextArgs); it supersedes AcceptsContext.
+	Signature string
+	Impl      FunctionImpl
+
+	sig *Signature // parsed Signature
+	// boundArgs marks the built-ins that read the values of contextBoundValues
+	// in their arguments themselves (see boundValue): their arguments are
+	// passed without the unwrapCVsDeep copy.
+	boundArgs bool
 }
 
 // FunctionImpl is the implementation of a function.
@@ -25,8 +37,9 @@ type FunctionImpl func(ctx context.Context, e *Evaluator, evalCtx *EvalContext,
 type Lambda struct {
 	Params    []string
 	Body      *types.ASTNode
-	Ctx       *EvalContext // Closure context
-	Signature *Signature   // Parsed signature for type validation
+	Ctx       *EvalContext       // Closure context
+	Signature *Signature         // Parsed signature for type validation
+	Closure   *types.ClosureInfo // Parse-time closure analysis; nil for synthesized lambdas
 }
 
 // OrderedObject preserves insertion order for JSON stringification.
@@ -34,7 +47,8 @@ type Lambda struct {
 // THREAD-SAFETY AUDIT: safe.
 //   - builtinFunctionsOnce guarantees the map is populated exactly once.
 //   - After initBuiltinFunctions returns, the map and every *FunctionDef it
-//     contains are never mutated; all accesses are read-only.
+//     contains are never mutated; all accesses are read-only. Evaluators that
+//     change their function set work on a private copy (newFunctionRegistry).
 var (
 	builtinFunctions     map[string]*FunctionDef
 	builtinFunctionsOnce sync.Once
@@ -56,6 +70,7 @@ func initBuiltinFunctions() {
 			"map":      {Name: "map", MinArgs: 2, MaxArgs: 2, Impl: fnMap},
 			"filter":   {Name: "filter", MinArgs: 2, MaxArgs: 2, Impl: fnFilter},
 			"reduce":   {Name: "reduce", MinArgs: 2, MaxArgs: 3, Impl: fnReduce},
+			"done":     {Name: "done", MinArgs: 0, MaxArgs: 1, Impl: fnDone},
 			"single":   {Name: "single", MinArgs: 1, MaxArgs: 2, Impl: fnSingle},
 			"sort":     {Name: "sort", MinArgs: 1, MaxArgs: 2, Impl: fnSort},
 			"append":   {Name: "append", MinArgs: 2, MaxArgs: 2, Impl: fnAppend},
@@ -63,76 +78,130 @@ func initBuiltinFunctions() {
 			"distinct": {Name: "distinct", MinArgs: 1, MaxArgs: 1, Impl: fnDistinct},
 			"shuffle":  {Name: "shuffle", MinArgs: 1, MaxArgs: 1, Impl: fnShuffle},
 			"zip":      {Name: "zip", MinArgs: 1, MaxArgs: -1, Impl: fnZip},
+			"joinOn":   {Name: "joinOn", MinArgs: 4, MaxArgs: 5, Impl: fnJoinOn},
+			"first":    {Name: "first", MinArgs: 1, MaxArgs: 2, Impl: fnFirst},
+			"any":      {Name: "any", MinArgs: 2, MaxArgs: 2, Impl: fnAny},
+			"all":      {Name: "all", MinArgs: 2, MaxArgs: 2, Impl: fnAll},
+			"index":    {Name: "index", MinArgs: 0, MaxArgs: 0, Impl: fnIndex},
 
 			// String functions
-			"string":          {Name: "string", MinArgs: 0, MaxArgs: 2, AcceptsContext: true, Impl: fnString},
-			"length":          {Name: "length", MinArgs: 1, MaxArgs: 1, Impl: fnLength},
-			"substring":       {Name: "substring", MinArgs: 2, MaxArgs: 3, Impl: fnSubstring},
-			"uppercase":       {Name: "uppercase", MinArgs: 1, MaxArgs: 1, AcceptsContext: true, Impl: fnUppercase},
-			"lowercase":       {Name: "lowercase", MinArgs: 1, MaxArgs: 1, AcceptsContext: true, Impl: fnLowercase},
-			"trim":            {Name: "trim", MinArgs: 0, MaxArgs: 1, AcceptsContext: true, Impl: fnTrim},
-			"contains":        {Name: "contains", MinArgs: 2, MaxArgs: 2, Impl: fnContains},
-			"split":           {Name: "split", MinArgs: 2, MaxArgs: 3, Impl: fnSplit},
+			"string":          {Name: "string", MinArgs: 0, MaxArgs: 2, Signature: "<x-b?:s>", AcceptsContext: true, Impl: fnString},
+			"length":          {Name: "length", MinArgs: 1, MaxArgs: 1, Signature: "<s-:n>", Impl: fnLength},
+			"substring":       {Name: "substring", MinArgs: 2, MaxArgs: 3, Signature: "<s-nn?:s>", Impl: fnSubstring},
+			"uppercase":       {Name: "uppercase", MinArgs: 1, MaxArgs: 1, Signature: "<s-:s>", AcceptsContext: true, Impl: fnUppercase},
+			"lowercase":       {Name: "lowercase", MinArgs: 1, MaxArgs: 1, Signature: "<s-:s>", AcceptsContext: true, Impl: fnLowercase},
+			"trim":            {Name: "trim", MinArgs: 0, MaxArgs: 1, Signature: "<s-:s>", AcceptsContext: true, Impl: fnTrim},
+			"contains":        {Name: "contains", MinArgs: 2, MaxArgs: 2, Signature: "<s-(sf):b>", Impl: fnContains},
+			"split":           {Name: "split", MinArgs: 2, MaxArgs: 3, Signature: "<s-(sf)n?:a<s>>", Impl: fnSplit},
 			"join":            {Name: "join", MinArgs: 1, MaxArgs: 2, Impl: fnJoin},
-			"pad":             {Name: "pad", MinArgs: 2, MaxArgs: 3, Impl: fnPad},
-			"substringBefore": {Name: "substringBefore", MinArgs: 2, MaxArgs: 2, AcceptsContext: true, Impl: fnSubstringBefore},
-			"substringAfter":  {Name: "substringAfter", MinArgs: 2, MaxArgs: 2, AcceptsContext: true, Impl: fnSubstringAfter},
+			"pad":             {Name: "pad", MinArgs: 2, MaxArgs: 3, Signature: "<s-ns?:s>", Impl: fnPad},
+			"substringBefore": {Name: "substringBefore", MinArgs: 2, MaxArgs: 2, Signature: "<s-s:s>", AcceptsContext: true, Impl: fnSubstringBefore},
+			"substringAfter":  {Name: "substringAfter", MinArgs: 2, MaxArgs: 2, Signature: "<s-s:s>", AcceptsContext: true, Impl: fnSubstringAfter},
 
 			// Type functions
 			"type":    {Name: "type", MinArgs: 1, MaxArgs: 1, AcceptsContext: true, Impl: fnType},
 			"exists":  {Name: "exists", MinArgs: 1, MaxArgs: 1, Impl: fnExists},
-			"number":  {Name: "number", MinArgs: 1, MaxArgs: 1, AcceptsContext: true, Impl: fnNumber},
-			"boolean": {Name: "boolean", MinArgs: 1, MaxArgs: 1, AcceptsContext: true, Impl: fnBoolean},
-			"not":     {Name: "not", MinArgs: 1, MaxArgs: 1, Impl: fnNot},
+			"number":  {Name: "number", MinArgs: 1, MaxArgs: 1, Signature: "<(nsb)-:n>", AcceptsContext: true, Impl: fnNumber},
+			"boolean": {Name: "boolean", MinArgs: 1, MaxArgs: 1, Signature: "<x-:b>", AcceptsContext: true, Impl: fnBoolean},
+			"not":     {Name: "not", MinArgs: 1, MaxArgs: 1, Signature: "<x-:b>", Impl: fnNot},
 
 			// Math functions
-			"abs":    {Name: "abs", MinArgs: 1, MaxArgs: 1, AcceptsContext: true, Impl: fnAbs},
-			"floor":  {Name: "floor", MinArgs: 1, MaxArgs: 1, AcceptsContext: true, Impl: fnFloor},
-			"ceil":   {Name: "ceil", MinArgs: 1, MaxArgs: 1, AcceptsContext: true, Impl: fnCeil},
-			"round":  {Name: "round", MinArgs: 1, MaxArgs: 2, AcceptsContext: true, Impl: fnRound},
-			"sqrt":   {Name: "sqrt", MinArgs: 1, MaxArgs: 1, AcceptsContext: true, Impl: fnSqrt},
-			"power":  {Name: "power", MinArgs: 2, MaxArgs: 2, Impl: fnPower},
-			"random": {Name: "random", MinArgs: 0, MaxArgs: 0, Impl: fnRandom},
+			"abs":    {Name: "abs", MinArgs: 1, MaxArgs: 1, Signature: "<n-:n>", AcceptsContext: true, Impl: fnAbs},
+			"floor":  {Name: "floor", MinArgs: 1, MaxArgs: 1, Signature: "<n-:n>", AcceptsContext: true, Impl: fnFloor},
+			"ceil":   {Name: "ceil", MinArgs: 1, MaxArgs: 1, Signature: "<n-:n>", AcceptsContext: true, Impl: fnCeil},
+			"round":  {Name: "round", MinArgs: 1, MaxArgs: 2, Signature: "<n-n?:n>", AcceptsContext: true, Impl: fnRound},
+			"sqrt":   {Name: "sqrt", MinArgs: 1, MaxArgs: 1, Signature: "<n-:n>", AcceptsContext: true, Impl: fnSqrt},
+			"power":  {Name: "power", MinArgs: 2, MaxArgs: 2, Signature: "<n-n:n>", Impl: fnPower},
+			"random": {Name: "random", MinArgs: 0, MaxArgs: 2, Signature: "<n?n?:n>", Impl: fnRandom},
 
 			// Object functions
-			"each":   {Name: "each", MinArgs: 2, MaxArgs: 2, AcceptsContext: true, Impl: fnEach},
-			"sift":   {Name: "sift", MinArgs: 2, MaxArgs: 2, AcceptsContext: true, Impl: fnSift},
-			"keys":   {Name: "keys", MinArgs: 1, MaxArgs: 1, Impl: fnKeys},
-			"lookup": {Name: "lookup", MinArgs: 2, MaxArgs: 2, Impl: fnLookup},
-			"merge":  {Name: "merge", MinArgs: 1, MaxArgs: 1, Impl: fnMerge},
-			"spread": {Name: "spread", MinArgs: 1, MaxArgs: 1, Impl: fnSpread},
-			"error":  {Name: "error", MinArgs: 0, MaxArgs: 1, Impl: fnError},
-			"assert": {Name: "assert", MinArgs: 1, MaxArgs: 2, Impl: fnAssert},
-			"eval":   {Name: "eval", MinArgs: 0, MaxArgs: 2, Impl: fnEval},
+			"each":       {Name: "each", MinArgs: 2, MaxArgs: 2, Signature: "<o-f:a>", AcceptsContext: true, Impl: fnEach},
+			"sift":       {Name: "sift", MinArgs: 2, MaxArgs: 2, Signature: "<o-f?:o>", AcceptsContext: true, Impl: fnSift},
+			"siftValues": {Name: "siftValues", MinArgs: 1, MaxArgs: 2, Signature: "<o-f?:o>", AcceptsContext: true, Impl: fnSiftValues},
+			"keys":       {Name: "keys", MinArgs: 1, MaxArgs: 1, Signature: "<x-:a<s>>", Impl: fnKeys},
+			"lookup":     {Name: "lookup", MinArgs: 2, MaxArgs: 2, Signature: "<x-s:x>", Impl: fnLookup},
+			"merge":      {Name: "merge", MinArgs: 1, MaxArgs: 1, Impl: fnMerge},
+			"spread":     {Name: "spread", MinArgs: 1, MaxArgs: 1, Signature: "<x-:a<o>>", Impl: fnSpread},
+			"error":      {Name: "error", MinArgs: 0, MaxArgs: 1, Impl: fnError},
+			"assert":     {Name: "assert", MinArgs: 1, MaxArgs: 2, Impl: fnAssert},
+			"eval":       {Name: "eval", MinArgs: 0, MaxArgs: 2, Impl: fnEval},
+			"doc":        {Name: "doc", MinArgs: 1, MaxArgs: 1, Impl: fnDoc},
 
 			// Regex functions
-			"match":   {Name: "match", MinArgs: 2, MaxArgs: 3, Impl: fnMatch},
-			"replace": {Name: "replace", MinArgs: 3, MaxArgs: 4, Impl: fnReplace},
+			"match":   {Name: "match", MinArgs: 2, MaxArgs: 3, Signature: "<s-f<s:o>n?:a<o>>", Impl: fnMatch},
+			"replace": {Name: "replace", MinArgs: 3, MaxArgs: 4, Signature: "<s-(sf)(sf)n?:s>", Impl: fnReplace},
 
 			// Date/Time functions
 			"now":        {Name: "now", MinArgs: 0, MaxArgs: 2, Impl: fnNow},
 			"millis":     {Name: "millis", MinArgs: 0, MaxArgs: 0, Impl: fnMillis},
-			"fromMillis": {Name: "fromMillis", MinArgs: 1, MaxArgs: 3, Impl: fnFromMillis},
-			"toMillis":   {Name: "toMillis", MinArgs: 1, MaxArgs: 2, Impl: fnToMillis},
+			"fromMillis": {Name: "fromMillis", MinArgs: 1, MaxArgs: 3, Signature: "<n-s?s?:s>", Impl: fnFromMillis},
+			"toMillis":   {Name: "toMillis", MinArgs: 1, MaxArgs: 2, Signature: "<s-s?:n>", Impl: fnToMillis},
 
 			// Encoding functions
-			"base64encode":       {Name: "base64encode", MinArgs: 0, MaxArgs: 1, Impl: fnBase64Encode},
-			"base64decode":       {Name: "base64decode", MinArgs: 0, MaxArgs: 1, Impl: fnBase64Decode},
-			"encodeUrl":          {Name: "encodeUrl", MinArgs: 1, MaxArgs: 1, Impl: fnEncodeUrl},
-			"decodeUrl":          {Name: "decodeUrl", MinArgs: 1, MaxArgs: 1, Impl: fnDecodeUrl},
-			"encodeUrlComponent": {Name: "encodeUrlComponent", MinArgs: 1, MaxArgs: 1, Impl: fnEncodeUrlComponent},
-			"decodeUrlComponent": {Name: "decodeUrlComponent", MinArgs: 1, MaxArgs: 1, Impl: fnDecodeUrlComponent},
+			"base64encode":       {Name: "base64encode", MinArgs: 0, MaxArgs: 1, Signature: "<s-:s>", Impl: fnBase64Encode},
+			"base64decode":       {Name: "base64decode", MinArgs: 0, MaxArgs: 1, Signature: "<s-:s>", Impl: fnBase64Decode},
+			"encodeUrl":          {Name: "encodeUrl", MinArgs: 1, MaxArgs: 1, Signature: "<s-:s>", Impl: fnEncodeUrl},
+			"decodeUrl":          {Name: "decodeUrl", MinArgs: 1, MaxArgs: 1, Signature: "<s-:s>", Impl: fnDecodeUrl},
+			"encodeUrlComponent": {Name: "encodeUrlComponent", MinArgs: 1, MaxArgs: 1, Signature: "<s-:s>", Impl: fnEncodeUrlComponent},
+			"decodeUrlComponent": {Name: "decodeUrlComponent", MinArgs: 1, MaxArgs: 1, Signature: "<s-:s>", Impl: fnDecodeUrlComponent},
+			"fromHex":            {Name: "fromHex", MinArgs: 1, MaxArgs: 1, Impl: fnFromHex},
+			"toHex":              {Name: "toHex", MinArgs: 1, MaxArgs: 1, Impl: fnToHex},
+			"fromBase64Bytes":    {Name: "fromBase64Bytes", MinArgs: 1, MaxArgs: 1, Impl: fnFromBase64Bytes},
 
 			// Number formatting functions
-			"formatNumber":  {Name: "formatNumber", MinArgs: 1, MaxArgs: 3, Impl: fnFormatNumber},
-			"formatBase":    {Name: "formatBase", MinArgs: 1, MaxArgs: 2, Impl: fnFormatBase},
-			"formatInteger": {Name: "formatInteger", MinArgs: 1, MaxArgs: 2, Impl: fnFormatInteger},
-			"parseInteger":  {Name: "parseInteger", MinArgs: 1, MaxArgs: 2, Impl: fnParseInteger},
+			"formatNumber":  {Name: "formatNumber", MinArgs: 1, MaxArgs: 3, Signature: "<n-so?:s>", Impl: fnFormatNumber},
+			"formatBase":    {Name: "formatBase", MinArgs: 1, MaxArgs: 2, Signature: "<n-n?:s>", Impl: fnFormatBase},
+			"formatInteger": {Name: "formatInteger", MinArgs: 1, MaxArgs: 2, Signature: "<n-s:s>", Impl: fnFormatInteger},
+			"parseInteger":  {Name: "parseInteger", MinArgs: 1, MaxArgs: 2, Signature: "<s-s:n>", Impl: fnParseInteger},
+		}
+		for _, fn := range builtinFunctions {
+			if fn.Signature == "" {
+				continue
+			}
+			sig, err := ParseSignature(fn.Signature)
+			if err != nil {
+				panic(fmt.Sprintf("built-in $%s: %v", fn.Name, err))
+			}
+			fn.sig = sig
+		}
+		for _, name := range boundArgFunctions {
+			builtinFunctions[name].boundArgs = true
+		}
+		for _, name := range finiteResultFunctions {
+			fn := builtinFunctions[name]
+			fn.Impl = finiteResult(fn.Impl)
 		}
 	})
 }
 
-// GetFunction retrieves a built-in function by name.
+// boundArgFunctions are the aggregates, which iterate the tuple streams of
+// join-style expressions (x@$a.y@$b[...]) as they are instead of copying them
+// unwrapped on every call.
+var boundArgFunctions = []string{"count", "sum", "average", "min", "max"}
+
+// finiteResultFunctions are the numeric built-ins whose result can be a
+// non-finite float64, from an overflow or from an Inf or NaN in Go input.
+var finiteResultFunctions = []string{
+	"sum", "average", "min", "max", "number",
+	"abs", "floor", "ceil", "round", "sqrt", "power",
+}
+
+// finiteResult wraps impl so that a NaN or infinite result fails with D1001,
+// as it does for the arithmetic operators: no expression yields a number that
+// cannot be serialized as JSON.
+func finiteResult(impl FunctionImpl) FunctionImpl {
+	return func(ctx context.Context, e *Evaluator, evalCtx *EvalContext, args []interface{}) (interface{}, error) {
+		result, err := impl(ctx, e, evalCtx, args)
+		if f, ok := result.(float64); ok && err == nil && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			return nil, types.NewError(types.ErrNumberTooLarge, "number out of range", -1)
+		}
+		return result, err
+	}
+}
+
+// GetFunction retrieves a function from the shared default registry of built-ins.
+// Evaluators may extend, override or remove entries; use Evaluator.LookupFunction
+// to resolve a name the way a given evaluator does.
 
 func GetFunction(name string) (*FunctionDef, bool) {
 	initBuiltinFunctions()
@@ -140,4 +209,49 @@ func GetFunction(name string) (*FunctionDef, bool) {
 	return fn, ok
 }
 
+// newFunctionRegistry builds the function registry of an Evaluator: the built-ins,
+// overridden and extended by the custom functions, minus the disabled names.
+// Evaluators without custom or disabled functions share the default map, which is
+// never mutated; any other configuration gets its own copy.
+
+func newFunctionRegistry(opts *EvalOptions) map[string]*FunctionDef {
+	initBuiltinFunctions()
+	if len(opts.CustomFunctions) == 0 && len(opts.AdvancedCustomFunctions) == 0 && len(opts.DisabledFunctions) == 0 {
+		return builtinFunctions
+	}
+
+	registry := make(map[string]*FunctionDef, len(builtinFunctions)+len(opts.CustomFunctions)+len(opts.AdvancedCustomFunctions))
+	for name, fn := range builtinFunctions {
+		registry[name] = fn
+	}
+	for _, cfd := range opts.CustomFunctions {
+		// Capture loop variable.
+		cfd := cfd
+		registry[cfd.Name] = &FunctionDef{
+			Name:    cfd.Name,
+			MinArgs: 0,
+			MaxArgs: -1, // unlimited; type-checking done via Signature if set
+			Impl: func(ctx context.Context, _ *Evaluator, _ *EvalContext, args []interface{}) (interface{}, error) {
+				return cfd.Fn(ctx, args...)
+			},
+		}
+	}
+	for _, cfd := range opts.AdvancedCustomFunctions {
+		cfd := cfd
+		registry[cfd.Name] = &FunctionDef{
+			Name:    cfd.Name,
+			MinArgs: 0,
+			MaxArgs: -1,
+			Impl: func(ctx context.Context, ev *Evaluator, ec *EvalContext, args []interface{}) (interface{}, error) {
+				caller := &callerAdapter{e: ev, ec: ec}
+				return cfd.Fn(ctx, caller, args...)
+			},
+		}
+	}
+	for _, name := range opts.DisabledFunctions {
+		delete(registry, name)
+	}
+	return registry
+}
+
 // --- Aggregation Functions ---
@@ -0,0 +1,34 @@
+//go:build minimal
+
+package evaluator
+
+import (
+	"time"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Minimal builds.
+//
+// Built with -tags minimal, the evaluator leaves out the picture engines of
+// $formatNumber (formatnumber.go) and of the date/time functions
+// (formatdatetime.go), to reduce the size of WebAssembly binaries. The functions
+// stay registered and behave as usual without a picture: $formatNumber(n) and
+// $fromMillis(ms) still format numbers and ISO 8601 timestamps, and $toMillis
+// still parses ISO 8601. A picture argument fails with U1003.
+
+func formatNumberPicture(num float64, picture string, options interface{}) (interface{}, error) {
+	return nil, notInBuild("$formatNumber with a picture")
+}
+
+func formatDateTime(t time.Time, picture string) (string, error) {
+	return "", notInBuild("date/time pictures")
+}
+
+func parseDateTime(timestamp, picture string, loc *time.Location) (interface{}, error) {
+	return nil, notInBuild("date/time pictures")
+}
+
+func notInBuild(feature string) error {
+	return types.NewError(types.ErrNotInBuild, feature+" not available in this build (built with -tags minimal)", -1)
+}
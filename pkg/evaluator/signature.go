@@ -2,286 +2,50 @@ package evaluator
 
 import (
 	"fmt"
-	"strings"
+	"regexp"
+
+	"github.com/sandrolain/gosonata/pkg/types"
 )
 
-// TypeCode represents a JSONata type code in signatures
-type TypeCode string
+// Signatures are parsed by the types package (types.ParseSignature), at compile
+// time for lambda literals; the aliases below keep them available from this
+// package, along with the validation of arguments, which needs the evaluator's
+// value types.
+
+type (
+	// TypeCode represents a JSONata type code in signatures
+	TypeCode = types.TypeCode
+	// ParamType represents a parameter type in a signature
+	ParamType = types.ParamType
+	// Signature represents a parsed function signature
+	Signature = types.Signature
+)
 
 const (
-	TypeAny      TypeCode = "x" // any type
-	TypeString   TypeCode = "s" // string
-	TypeNumber   TypeCode = "n" // number
-	TypeBoolean  TypeCode = "b" // boolean
-	TypeNull     TypeCode = "l" // null
-	TypeArray    TypeCode = "a" // array
-	TypeObject   TypeCode = "o" // object
-	TypeFunction TypeCode = "f" // function
+	TypeAny      = types.TypeAny
+	TypeString   = types.TypeString
+	TypeNumber   = types.TypeNumber
+	TypeBoolean  = types.TypeBoolean
+	TypeNull     = types.TypeNull
+	TypeArray    = types.TypeArray
+	TypeObject   = types.TypeObject
+	TypeFunction = types.TypeFunction
 )
 
-// ParamType represents a parameter type in a signature
-type ParamType struct {
-	Type       TypeCode
-	SubType    *ParamType  // For arrays like a<n> or functions like f<n:n>
-	UnionTypes []TypeCode  // For union types like (ns) = number OR string
-	FuncParams []ParamType // For function subtypes f<n-s:b>
-	FuncReturn *ParamType  // For function return type
-	Optional   bool
-}
-
-// Signature represents a parsed function signature
-type Signature struct {
-	Params     []ParamType
-	ReturnType *ParamType
-}
-
-// ParseSignature parses a JSONata function signature string
-// Examples: "<n-n:n>", "<s-s>", "<a<s>s?:s>", "<f<n:n>:f<n:n>>"
+// ParseSignature parses a JSONata function signature string.
+// See types.ParseSignature.
 func ParseSignature(sig string) (*Signature, error) {
-	if sig == "" {
-		return nil, nil
-	}
-
-	// Remove < and >
-	if !strings.HasPrefix(sig, "<") || !strings.HasSuffix(sig, ">") {
-		return nil, fmt.Errorf("S0401: Invalid signature format")
-	}
-
-	sig = sig[1 : len(sig)-1]
-
-	// Split by : to separate params from return type
-	// But we need to respect nested brackets, so can't use strings.Split
-	parts := splitByColonRespectingBrackets(sig)
-	if len(parts) > 2 {
-		return nil, fmt.Errorf("S0401: Invalid signature format")
-	}
-
-	result := &Signature{}
-
-	// Parse parameters
-	if len(parts) > 0 && parts[0] != "" {
-		params, err := parseParamList(parts[0])
-		if err != nil {
-			return nil, err
-		}
-		result.Params = params
-	}
-
-	// Parse return type
-	if len(parts) == 2 {
-		returnType, err := parseParamType(parts[1])
-		if err != nil {
-			return nil, err
-		}
-		result.ReturnType = returnType
-	}
-
-	return result, nil
-}
-
-// splitByColonRespectingBrackets splits a string by : but respects nested < >
-func splitByColonRespectingBrackets(s string) []string {
-	var parts []string
-	depth := 0
-	start := 0
-
-	for i := 0; i < len(s); i++ {
-		switch s[i] {
-		case '<':
-			depth++
-		case '>':
-			depth--
-		case ':':
-			if depth == 0 {
-				parts = append(parts, s[start:i])
-				start = i + 1
-			}
-		}
-	}
-
-	// Add remaining part
-	if start < len(s) {
-		parts = append(parts, s[start:])
-	}
-
-	return parts
-}
-
-// parseParamList parses the parameter list part of a signature
-func parseParamList(params string) ([]ParamType, error) {
-	var result []ParamType
-	i := 0
-
-	for i < len(params) {
-		paramType, consumed, err := parseParamTypeAt(params, i)
-		if err != nil {
-			return nil, err
-		}
-		result = append(result, *paramType)
-		i += consumed
-
-		// Skip optional separator '-'
-		if i < len(params) && params[i] == '-' {
-			i++
-		}
-	}
-
-	return result, nil
-}
-
-// parseParamTypeAt parses a parameter type starting at position i
-// Returns the parsed type, number of characters consumed, and error
-func parseParamTypeAt(s string, i int) (*ParamType, int, error) {
-	if i >= len(s) {
-		return nil, 0, fmt.Errorf("S0401: Unexpected end of signature")
-	}
-
-	start := i
-	paramType := &ParamType{}
-
-	// Check for union type (ns) = number OR string
-	if s[i] == '(' {
-		// Find closing )
-		j := i + 1
-		for j < len(s) && s[j] != ')' {
-			j++
-		}
-		if j >= len(s) {
-			return nil, 0, fmt.Errorf("S0401: Unmatched ( in signature")
-		}
-
-		// Parse union types
-		unionStr := s[i+1 : j]
-		for _, char := range unionStr {
-			typeCode := TypeCode(string(char))
-			switch typeCode {
-			case TypeAny, TypeString, TypeNumber, TypeBoolean, TypeNull, TypeArray, TypeObject, TypeFunction:
-				paramType.UnionTypes = append(paramType.UnionTypes, typeCode)
-			default:
-				return nil, 0, fmt.Errorf("S0401: Unknown type code in union: %s", typeCode)
-			}
-		}
-		// Use first type as main type for now
-		if len(paramType.UnionTypes) > 0 {
-			paramType.Type = paramType.UnionTypes[0]
-		}
-		i = j + 1
-
-		// Check for optional marker after union type
-		if i < len(s) && s[i] == '?' {
-			paramType.Optional = true
-			i++
-		}
-
-		return paramType, i - start, nil
-	}
-
-	// Get the base type code
-	typeCode := TypeCode(s[i : i+1])
-	i++
-
-	// Validate type code
-	switch typeCode {
-	case TypeAny, TypeString, TypeNumber, TypeBoolean, TypeNull, TypeArray, TypeObject, TypeFunction:
-		paramType.Type = typeCode
-	default:
-		return nil, 0, fmt.Errorf("S0401: Unknown type code: %s", typeCode)
-	}
-
-	// Check for subtype (e.g., a<n> for array of numbers, f<n:n> for function)
-	if i < len(s) && s[i] == '<' {
-		// Only arrays and functions can have subtypes
-		if typeCode != TypeArray && typeCode != TypeFunction {
-			return nil, 0, fmt.Errorf("S0401: Type %s cannot have subtypes", typeCode)
-		}
-
-		// Find matching >
-		depth := 1
-		j := i + 1
-		for j < len(s) && depth > 0 {
-			if s[j] == '<' {
-				depth++
-			} else if s[j] == '>' {
-				depth--
-			}
-			j++
-		}
-
-		if depth != 0 {
-			return nil, 0, fmt.Errorf("S0401: Unmatched < in signature")
-		}
-
-		subSig := s[i+1 : j-1]
-		if subSig == "" {
-			return nil, 0, fmt.Errorf("S0401: Empty subtype")
-		}
-
-		if typeCode == TypeFunction {
-			// Parse function signature: f<params:return>
-			// Split by : to get params and return type
-			parts := strings.Split(subSig, ":")
-			if len(parts) != 2 {
-				return nil, 0, fmt.Errorf("S0401: Function signature must have format f<params:return>")
-			}
-
-			// Parse function parameters
-			if parts[0] != "" {
-				funcParams, err := parseParamList(parts[0])
-				if err != nil {
-					return nil, 0, err
-				}
-				paramType.FuncParams = funcParams
-			}
-
-			// Parse function return type
-			if parts[1] != "" {
-				funcReturn, err := parseParamType(parts[1])
-				if err != nil {
-					return nil, 0, err
-				}
-				paramType.FuncReturn = funcReturn
-			}
-		} else {
-			// Array subtype - can be nested like a<a<n>>
-			subType, _, err := parseParamTypeAt(subSig, 0)
-			if err != nil {
-				return nil, 0, err
-			}
-			paramType.SubType = subType
-		}
-
-		i = j
-	}
-
-	// Check for optional marker ?
-	if i < len(s) && s[i] == '?' {
-		paramType.Optional = true
-		i++
-	}
-
-	return paramType, i - start, nil
-}
-
-// parseParamType parses a single parameter type (helper for return type)
-func parseParamType(s string) (*ParamType, error) {
-	paramType, consumed, err := parseParamTypeAt(s, 0)
-	if err != nil {
-		return nil, err
-	}
-	if consumed != len(s) {
-		return nil, fmt.Errorf("S0401: Unexpected characters after type")
-	}
-	return paramType, nil
+	return types.ParseSignature(sig)
 }
 
 // ValidateArgument validates that a value matches a parameter type
-func (pt *ParamType) ValidateArgument(value interface{}) error {
+func ValidateArgument(pt *ParamType, value interface{}) error {
 	// Handle union types - value must match at least one type
 	if len(pt.UnionTypes) > 0 {
 		var lastErr error
 		for _, typeCode := range pt.UnionTypes {
 			singleType := &ParamType{Type: typeCode}
-			if err := singleType.ValidateArgument(value); err == nil {
+			if err := ValidateArgument(singleType, value); err == nil {
 				return nil // Matches one of the union types
 			} else {
 				lastErr = err
@@ -327,7 +91,7 @@ func (pt *ParamType) ValidateArgument(value interface{}) error {
 		// If there's a subtype, validate each element
 		if pt.SubType != nil {
 			for i, elem := range arr {
-				if err := pt.SubType.ValidateArgument(elem); err != nil {
+				if err := ValidateArgument(pt.SubType, elem); err != nil {
 					return fmt.Errorf("T0412: Array element %d: %v", i, err)
 				}
 			}
@@ -363,3 +127,199 @@ func (pt *ParamType) ValidateArgument(value interface{}) error {
 
 	return nil
 }
+
+// argSymbol returns the type code of an argument value, or 'm' when it is
+// undefined.
+func argSymbol(value interface{}) byte {
+	switch value.(type) {
+	case nil:
+		return 'm'
+	case string, []byte:
+		return 's'
+	case float64, int, int64:
+		return 'n'
+	case bool:
+		return 'b'
+	case types.Null:
+		return 'l'
+	case []interface{}:
+		return 'a'
+	case map[string]interface{}, *OrderedObject:
+		return 'o'
+	case *Lambda, *FunctionDef, *regexp.Regexp:
+		return 'f'
+	default:
+		return 'x'
+	}
+}
+
+// acceptsSymbol reports whether a parameter takes an argument of type code sym
+// when arguments are matched to parameters: like the reference implementation,
+// array and any-type parameters take every value, and the other types also take
+// undefined.
+func acceptsSymbol(pt *ParamType, sym byte) bool {
+	codes := pt.UnionTypes
+	if len(codes) == 0 {
+		codes = []TypeCode{pt.Type}
+	}
+	for _, code := range codes {
+		switch code {
+		case TypeAny, TypeArray:
+			return true
+		case TypeFunction:
+			if sym == 'f' {
+				return true
+			}
+		default:
+			if sym == 'm' || sym == code[0] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchArgs assigns the arguments, given by type code, to params the way the
+// reference implementation's signature regular expression does: greedily,
+// backtracking over optional and context parameters. taken[i] is set to the
+// index of the argument of params[i], or -1 when it takes none.
+func matchArgs(params []ParamType, syms []byte, taken []int, pi, ai int) bool {
+	if pi == len(params) {
+		return ai == len(syms)
+	}
+	p := &params[pi]
+	if ai < len(syms) && acceptsSymbol(p, syms[ai]) {
+		taken[pi] = ai
+		if matchArgs(params, syms, taken, pi+1, ai+1) {
+			return true
+		}
+	}
+	if p.Optional || p.Context {
+		taken[pi] = -1
+		return matchArgs(params, syms, taken, pi+1, ai)
+	}
+	return false
+}
+
+// contextArgs returns the arguments of a call to the built-in f evaluated with
+// contextValue as the context. A parameter of the signature marked "-" that the
+// arguments leave unfilled receives the context value, so "hello".$substring(1, 2)
+// is $substring("hello", 1, 2) and $uppercase() is $uppercase($). Arguments that do
+// not match the signature are returned unchanged, for f to report the error.
+// Built-ins without a signature get the context as first argument when called
+// with fewer than MinArgs arguments (AcceptsContext).
+func (f *FunctionDef) contextArgs(args []interface{}, contextValue interface{}) ([]interface{}, error) {
+	if f.sig == nil {
+		if f.AcceptsContext && len(args) < f.MinArgs {
+			return append([]interface{}{contextValue}, args...), nil
+		}
+		return args, nil
+	}
+	return signatureContextArgs(f.sig, "function "+f.Name, args, contextValue)
+}
+
+// signatureContextArgs gives contextValue to the parameter of sig marked "-"
+// that args leave unfilled, if any (see FunctionDef.contextArgs). what names
+// the function in errors.
+func signatureContextArgs(sig *Signature, what string, args []interface{}, contextValue interface{}) ([]interface{}, error) {
+	params := sig.Params
+	if len(args) >= len(params) {
+		return args, nil // every parameter takes an argument
+	}
+	syms := make([]byte, len(args))
+	for i, arg := range args {
+		syms[i] = argSymbol(arg)
+	}
+	taken := make([]int, len(params))
+	if !matchArgs(params, syms, taken, 0, 0) {
+		return args, nil
+	}
+
+	out := make([]interface{}, 0, len(params))
+	filled := 0 // parameters up to the last one receiving a value
+	injected := false
+	for i := range params {
+		switch {
+		case taken[i] >= 0:
+			out = append(out, args[taken[i]])
+			filled = i + 1
+		case params[i].Context:
+			if !acceptsSymbol(&params[i], argSymbol(contextValue)) {
+				return nil, types.NewError("T0411", fmt.Sprintf(
+					"Context value is not a compatible type with argument %d of %s", i+1, what), -1)
+			}
+			out = append(out, contextValue)
+			filled = i + 1
+			injected = true
+		default:
+			out = append(out, nil) // optional parameter without argument
+		}
+	}
+	if !injected {
+		return args, nil
+	}
+	return out[:filled], nil
+}
+
+// readsContext reports whether a call to f may receive the context value as
+// an implicit argument.
+func (f *FunctionDef) readsContext() bool {
+	if f.sig == nil {
+		return f.AcceptsContext
+	}
+	return signatureReadsContext(f.sig)
+}
+
+// signatureReadsContext reports whether a parameter of sig is marked "-".
+func signatureReadsContext(sig *Signature) bool {
+	if sig == nil {
+		return false
+	}
+	for _, param := range sig.Params {
+		if param.Context {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadsContext reports whether a call to f with n arguments may receive
+// contextValue in place of a missing one (see contextArgs), whatever the types
+// of the arguments. A nil contextValue stands for a value of any type.
+func (f *FunctionDef) ReadsContext(n int, contextValue interface{}) bool {
+	if f.sig == nil {
+		return f.AcceptsContext && n < f.MinArgs
+	}
+	params := f.sig.Params
+	if n >= len(params) || !f.readsContext() {
+		return false
+	}
+	ctxSym := argSymbol(contextValue)
+	// Try every combination of argument types.
+	const codes = "msnblaof"
+	syms := make([]byte, n)
+	combo := make([]int, n)
+	taken := make([]int, len(params))
+	for {
+		for i, c := range combo {
+			syms[i] = codes[c]
+		}
+		if matchArgs(params, syms, taken, 0, 0) {
+			for i := range params {
+				if taken[i] < 0 && params[i].Context && acceptsSymbol(&params[i], ctxSym) {
+					return true
+				}
+			}
+		}
+		i := 0
+		for ; i < n; i++ {
+			if combo[i]++; combo[i] < len(codes) {
+				break
+			}
+			combo[i] = 0
+		}
+		if i == n {
+			return false
+		}
+	}
+}
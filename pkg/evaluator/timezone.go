@@ -0,0 +1,97 @@
+package evaluator
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Timezones.
+//
+// The date/time functions take timezones as a JSONata offset (+HHMM), "Z" or
+// "UTC", or an IANA name such as "Europe/Rome" (see WithTimezone).
+
+// locations caches the timezones resolved by loadTimezone, by name.
+var locations sync.Map // map[string]*time.Location
+
+// loadTimezone resolves a timezone name: a ±HHMM offset, "Z", "UTC" or an IANA
+// timezone database name.
+func loadTimezone(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	var loc *time.Location
+	switch {
+	case name == "Z" || name == "UTC":
+		loc = time.UTC
+	case len(name) == 5 && (name[0] == '+' || name[0] == '-'):
+		hours, err1 := strconv.Atoi(name[1:3])
+		minutes, err2 := strconv.Atoi(name[3:5])
+		if err1 != nil || err2 != nil || minutes > 59 {
+			return nil, fmt.Errorf("D3110: invalid timezone %q", name)
+		}
+		offset := hours*3600 + minutes*60
+		if name[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone(name[:3]+":"+name[3:], offset)
+	default:
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil || name == "" || name == "Local" {
+			return nil, fmt.Errorf("D3110: unknown timezone %q", name)
+		}
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// timezone returns the timezone named by arg, a timezone argument of a date
+// function, or the evaluator's default timezone when arg is undefined.
+func (e *Evaluator) timezone(arg interface{}) (*time.Location, error) {
+	switch tz := arg.(type) {
+	case nil:
+		if e.opts.Clock != nil {
+			if loc := e.opts.Clock.Location(); loc != nil {
+				return loc, nil
+			}
+		}
+		if e.opts.Timezone == "" {
+			return time.UTC, nil
+		}
+		return loadTimezone(e.opts.Timezone)
+	case string:
+		return loadTimezone(tz)
+	}
+	return nil, fmt.Errorf("D3110: timezone must be a string, got %T", arg)
+}
+
+// Clock supplies the current time and, optionally, the default timezone of
+// the date/time functions (see WithClock).
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Location returns the default timezone, or nil to keep the evaluator's
+	// (see WithTimezone).
+	Location() *time.Location
+}
+
+// SystemClock is the Clock of evaluators without WithClock: the system time,
+// and no timezone of its own.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) Location() *time.Location { return nil }
+
+// FixedClock returns a Clock stopped at t, whose timezone is the location of
+// t.
+func FixedClock(t time.Time) Clock { return fixedClock{t} }
+
+type fixedClock struct{ t time.Time }
+
+func (c fixedClock) Now() time.Time { return c.t }
+
+func (c fixedClock) Location() *time.Location { return c.t.Location() }
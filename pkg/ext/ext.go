@@ -4,7 +4,7 @@
 // The extension functions live in sub-packages grouped by category:
 //   - extstring   – $startsWith, $endsWith, $indexOf, $camelCase, $template, …
 //   - extnumeric  – $log, $sign, $trunc, $clamp, trig functions, $median, …
-//   - extarray    – $first, $last, $take, $skip, $flatten, $chunk, set ops, …
+//   - extarray    – $last, $take, $skip, $flatten, $chunk, set ops, …
 //   - extobject   – $values, $pairs, $pick, $omit, $deepMerge, $rename, …
 //   - exttypes    – $isString, $isArray, $isEmpty, $default, $identity, …
 //   - extdatetime – $dateAdd, $dateDiff, $dateComponents, $dateStartOf, …
@@ -2,9 +2,11 @@ package ext_test
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 
 	gosonata "github.com/sandrolain/gosonata"
+	"github.com/sandrolain/gosonata/pkg/evaluator"
 	"github.com/sandrolain/gosonata/pkg/ext"
 	"github.com/sandrolain/gosonata/pkg/ext/extarray"
 	"github.com/sandrolain/gosonata/pkg/ext/extstring"
@@ -166,6 +168,53 @@ func TestWithAll_ArrayFunctions(t *testing.T) {
 			t.Errorf("got %d items, want 1", len(arr))
 		}
 	})
+	t.Run("set ops compare structurally", func(t *testing.T) {
+		data := map[string]interface{}{
+			"a": []interface{}{
+				map[string]interface{}{"id": 1.0, "tags": []interface{}{"x"}},
+				map[string]interface{}{"id": 2.0, "tags": []interface{}{"y"}},
+				"1",
+			},
+			"b": []interface{}{
+				&evaluator.OrderedObject{
+					Keys:   []string{"tags", "id"},
+					Values: map[string]interface{}{"tags": []interface{}{"x"}, "id": 1},
+				},
+				1.0,
+			},
+		}
+		tests := []struct {
+			expr string
+			want string
+		}{
+			{`$intersect(a, b)`, `[{"id":1,"tags":["x"]}]`},
+			{`$intersection(a, b)`, `[{"id":1,"tags":["x"]}]`},
+			{`$except(a, b)`, `[{"id":2,"tags":["y"]},"1"]`},
+			{`$difference(a, b)`, `[{"id":2,"tags":["y"]},"1"]`},
+			{`$union(a, b)`, `[{"id":1,"tags":["x"]},{"id":2,"tags":["y"]},"1",1]`},
+			{`$symmetricDifference(a, b)`, `[{"id":2,"tags":["y"]},"1",1]`},
+			{`$union([1, 1, 2], [])`, `[1,2]`},
+		}
+		for _, tt := range tests {
+			got, err := json.Marshal(eval(t, tt.expr, data, opt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("%s = %s, want %s", tt.expr, got, tt.want)
+			}
+		}
+	})
+	t.Run("$union does not write into its input", func(t *testing.T) {
+		a := make([]interface{}, 1, 4)
+		a[0] = 1.0
+		spare := a[:2]
+		spare[1] = "spare"
+		eval(t, `$union(a, [2, 3])`, map[string]interface{}{"a": a}, opt)
+		if spare[1] != "spare" {
+			t.Errorf("spare capacity overwritten with %v", spare[1])
+		}
+	})
 }
 
 func TestWithAll_ObjectFunctions(t *testing.T) {
@@ -7,13 +7,13 @@ import (
 	"fmt"
 	"math"
 
+	"github.com/sandrolain/gosonata/pkg/ext/extutil"
 	"github.com/sandrolain/gosonata/pkg/functions"
 )
 
 // All returns all extended array function definitions (simple, no HOF).
 func All() []functions.CustomFunctionDef {
 	return []functions.CustomFunctionDef{
-		First(),
 		Last(),
 		Take(),
 		Skip(),
@@ -22,7 +22,9 @@ func All() []functions.CustomFunctionDef {
 		Chunk(),
 		Union(),
 		Intersection(),
+		Intersect(),
 		Difference(),
+		Except(),
 		SymmetricDifference(),
 		Range(),
 		ZipLongest(),
@@ -61,6 +63,9 @@ func AllEntries() []functions.FunctionEntry {
 }
 
 // First returns the definition for $first(array).
+//
+// Deprecated: $first is a built-in, which also accepts a predicate. Registering
+// this definition shadows the built-in, so All no longer includes it.
 func First() functions.CustomFunctionDef {
 	return functions.CustomFunctionDef{
 		Name:      "first",
@@ -265,149 +270,111 @@ func Chunk() functions.CustomFunctionDef {
 	}
 }
 
+// Set functions ($union, $intersect, $except, …) compare items structurally:
+// numbers by value whatever their Go kind, objects by their entries regardless
+// of key order (a map equals an OrderedObject with the same entries), arrays
+// item by item. The string "1" and the number 1 are different items. Results
+// keep the first occurrence of each item, in input order, and never share
+// backing storage with the inputs.
+
 // Union returns the definition for $union(arr1, arr2).
 // Returns a deduplicated array containing all elements from both arrays.
 func Union() functions.CustomFunctionDef {
-	return functions.CustomFunctionDef{
-		Name:      "union",
-		Signature: "<a-a:a>",
-		Fn: func(_ context.Context, args ...interface{}) (interface{}, error) {
-			a1, err := toArray(args[0])
-			if err != nil {
-				return nil, fmt.Errorf("$union: %w", err)
-			}
-			a2, err := toArray(args[1])
-			if err != nil {
-				return nil, fmt.Errorf("$union: %w", err)
-			}
-			seen := make(map[interface{}]bool)
-			var result []interface{}
-			for _, item := range append(a1, a2...) {
-				key := fmt.Sprint(item)
-				if !seen[key] {
-					seen[key] = true
-					result = append(result, item)
-				}
-			}
-			if len(result) == 0 {
-				return nil, nil
-			}
-			return result, nil
-		},
-	}
+	return setFunction("union", func(a1, a2 []interface{}) interface{} {
+		return distinct(func(string) bool { return true }, a1, a2)
+	})
 }
 
 // Intersection returns the definition for $intersection(arr1, arr2).
 func Intersection() functions.CustomFunctionDef {
-	return functions.CustomFunctionDef{
-		Name:      "intersection",
-		Signature: "<a-a:a>",
-		Fn: func(_ context.Context, args ...interface{}) (interface{}, error) {
-			a1, err := toArray(args[0])
-			if err != nil {
-				return nil, fmt.Errorf("$intersection: %w", err)
-			}
-			a2, err := toArray(args[1])
-			if err != nil {
-				return nil, fmt.Errorf("$intersection: %w", err)
-			}
-			set := make(map[string]bool)
-			for _, item := range a2 {
-				set[fmt.Sprint(item)] = true
-			}
-			var result []interface{}
-			seen := make(map[string]bool)
-			for _, item := range a1 {
-				key := fmt.Sprint(item)
-				if set[key] && !seen[key] {
-					seen[key] = true
-					result = append(result, item)
-				}
-			}
-			if len(result) == 0 {
-				return nil, nil
-			}
-			return result, nil
-		},
-	}
+	return setFunction("intersection", intersect)
+}
+
+// Intersect returns the definition for $intersect(arr1, arr2), an alias of
+// $intersection: the distinct elements of arr1 that are also in arr2.
+func Intersect() functions.CustomFunctionDef {
+	return setFunction("intersect", intersect)
 }
 
 // Difference returns the definition for $difference(arr1, arr2).
 // Elements in arr1 but not in arr2.
 func Difference() functions.CustomFunctionDef {
-	return functions.CustomFunctionDef{
-		Name:      "difference",
-		Signature: "<a-a:a>",
-		Fn: func(_ context.Context, args ...interface{}) (interface{}, error) {
-			a1, err := toArray(args[0])
-			if err != nil {
-				return nil, fmt.Errorf("$difference: %w", err)
-			}
-			a2, err := toArray(args[1])
-			if err != nil {
-				return nil, fmt.Errorf("$difference: %w", err)
-			}
-			set := make(map[string]bool)
-			for _, item := range a2 {
-				set[fmt.Sprint(item)] = true
-			}
-			var result []interface{}
-			seen := make(map[string]bool)
-			for _, item := range a1 {
-				key := fmt.Sprint(item)
-				if !set[key] && !seen[key] {
-					seen[key] = true
-					result = append(result, item)
-				}
-			}
-			if len(result) == 0 {
-				return nil, nil
-			}
-			return result, nil
-		},
-	}
+	return setFunction("difference", except)
+}
+
+// Except returns the definition for $except(arr1, arr2), an alias of
+// $difference: the distinct elements of arr1 that are not in arr2.
+func Except() functions.CustomFunctionDef {
+	return setFunction("except", except)
 }
 
 // SymmetricDifference returns the definition for $symmetricDifference(arr1, arr2).
 // Elements in either arr1 or arr2 but not both.
 func SymmetricDifference() functions.CustomFunctionDef {
+	return setFunction("symmetricDifference", func(a1, a2 []interface{}) interface{} {
+		in1, in2 := keySet(a1), keySet(a2)
+		return distinct(func(key string) bool { return in1[key] != in2[key] }, a1, a2)
+	})
+}
+
+// setFunction builds the definition of a binary set function.
+func setFunction(name string, op func(a1, a2 []interface{}) interface{}) functions.CustomFunctionDef {
 	return functions.CustomFunctionDef{
-		Name:      "symmetricDifference",
+		Name:      name,
 		Signature: "<a-a:a>",
 		Fn: func(_ context.Context, args ...interface{}) (interface{}, error) {
 			a1, err := toArray(args[0])
 			if err != nil {
-				return nil, fmt.Errorf("$symmetricDifference: %w", err)
+				return nil, fmt.Errorf("$%s: %w", name, err)
 			}
 			a2, err := toArray(args[1])
 			if err != nil {
-				return nil, fmt.Errorf("$symmetricDifference: %w", err)
-			}
-			set1 := make(map[string]bool)
-			for _, item := range a1 {
-				set1[fmt.Sprint(item)] = true
-			}
-			set2 := make(map[string]bool)
-			for _, item := range a2 {
-				set2[fmt.Sprint(item)] = true
+				return nil, fmt.Errorf("$%s: %w", name, err)
 			}
-			seen := make(map[string]bool)
-			var result []interface{}
-			for _, item := range append(a1, a2...) {
-				key := fmt.Sprint(item)
-				if !seen[key] && (set1[key] != set2[key]) {
-					seen[key] = true
-					result = append(result, item)
-				}
-			}
-			if len(result) == 0 {
-				return nil, nil
-			}
-			return result, nil
+			return op(a1, a2), nil
 		},
 	}
 }
 
+func intersect(a1, a2 []interface{}) interface{} {
+	in2 := keySet(a2)
+	return distinct(func(key string) bool { return in2[key] }, a1)
+}
+
+func except(a1, a2 []interface{}) interface{} {
+	in2 := keySet(a2)
+	return distinct(func(key string) bool { return !in2[key] }, a1)
+}
+
+// keySet returns the structural keys of items.
+func keySet(items []interface{}) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[extutil.ValueKey(item)] = true
+	}
+	return set
+}
+
+// distinct returns the first occurrence of every item of arrays whose key
+// satisfies keep, or nil when there is none.
+func distinct(keep func(key string) bool, arrays ...[]interface{}) interface{} {
+	seen := make(map[string]bool)
+	var result []interface{}
+	for _, items := range arrays {
+		for _, item := range items {
+			key := extutil.ValueKey(item)
+			if !seen[key] && keep(key) {
+				seen[key] = true
+				result = append(result, item)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
 // Range returns the definition for $range(start, end [, step]).
 // Supports float steps. end is exclusive.
 func Range() functions.CustomFunctionDef {
@@ -0,0 +1,161 @@
+package extdatetime
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sandrolain/gosonata/pkg/functions"
+)
+
+// reDuration matches an ISO 8601 duration such as P1DT2H, PT1.5S or -P2W.
+// Every component may carry a decimal fraction (with "." or ",").
+var reDuration = regexp.MustCompile(`^([+-])?P` +
+	`(?:(\d+(?:[.,]\d+)?)Y)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)W)?(?:(\d+(?:[.,]\d+)?)D)?` +
+	`(?:T(?:(\d+(?:[.,]\d+)?)H)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)S)?)?$`)
+
+const msPerDay = 24 * 60 * 60 * 1000
+
+// duration is a parsed ISO 8601 duration. Years and months have no fixed
+// length; the other components do.
+type duration struct {
+	sign                                         float64 // 1 or -1
+	years, months, weeks, days, hours, mins, sec float64
+}
+
+func parseDuration(s string) (duration, error) {
+	m := reDuration.FindStringSubmatch(s)
+	if m == nil || strings.HasSuffix(s, "P") || strings.HasSuffix(s, "T") {
+		return duration{}, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+	var parts [7]float64
+	for i := range parts {
+		if m[i+2] == "" {
+			continue
+		}
+		parts[i], _ = strconv.ParseFloat(strings.Replace(m[i+2], ",", ".", 1), 64)
+	}
+	d := duration{sign: 1, years: parts[0], months: parts[1], weeks: parts[2], days: parts[3],
+		hours: parts[4], mins: parts[5], sec: parts[6]}
+	if m[1] == "-" {
+		d.sign = -1
+	}
+	return d, nil
+}
+
+// fixedMillis returns the length of the fixed components, days included, in
+// milliseconds.
+func (d duration) fixedMillis() float64 {
+	return math.Round(d.sign * ((d.weeks*7+d.days)*msPerDay + d.hours*3600000 + d.mins*60000 + d.sec*1000))
+}
+
+// ParseDuration returns the definition for $parseDuration(duration).
+// Converts an ISO 8601 duration ("P1DT2H", "PT90M", "-P2W") to milliseconds,
+// counting a day as 24 hours. Durations with years or months are rejected, as
+// their length depends on the date they are added to: use $dateAddDuration.
+func ParseDuration() functions.CustomFunctionDef {
+	return functions.CustomFunctionDef{
+		Name:      "parseDuration",
+		Signature: "<s:n>",
+		Fn: func(_ context.Context, args ...interface{}) (interface{}, error) {
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("$parseDuration: duration must be a string")
+			}
+			d, err := parseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("$parseDuration: %w", err)
+			}
+			if d.years != 0 || d.months != 0 {
+				return nil, fmt.Errorf("$parseDuration: %q has a year or month component of variable length; use $dateAddDuration", s)
+			}
+			return d.fixedMillis(), nil
+		},
+	}
+}
+
+// FormatDuration returns the definition for $formatDuration(millis).
+// Formats milliseconds as an ISO 8601 duration in days, hours, minutes and
+// seconds: 93600000 → "P1DT2H", 1500 → "PT1.5S", 0 → "PT0S".
+func FormatDuration() functions.CustomFunctionDef {
+	return functions.CustomFunctionDef{
+		Name:      "formatDuration",
+		Signature: "<n:s>",
+		Fn: func(_ context.Context, args ...interface{}) (interface{}, error) {
+			ms, err := toFloat(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("$formatDuration: %w", err)
+			}
+			if math.IsNaN(ms) || math.IsInf(ms, 0) {
+				return nil, fmt.Errorf("$formatDuration: cannot format %v", ms)
+			}
+			return formatDuration(int64(math.Round(ms))), nil
+		},
+	}
+}
+
+func formatDuration(ms int64) string {
+	if ms == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	if ms < 0 {
+		b.WriteByte('-')
+		ms = -ms
+	}
+	b.WriteByte('P')
+	if days := ms / msPerDay; days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+	}
+	ms %= msPerDay
+	if ms == 0 {
+		return b.String()
+	}
+	b.WriteByte('T')
+	if hours := ms / 3600000; hours > 0 {
+		fmt.Fprintf(&b, "%dH", hours)
+	}
+	if mins := ms / 60000 % 60; mins > 0 {
+		fmt.Fprintf(&b, "%dM", mins)
+	}
+	if sec := ms % 60000; sec > 0 {
+		b.WriteString(strconv.FormatFloat(float64(sec)/1000, 'f', -1, 64))
+		b.WriteByte('S')
+	}
+	return b.String()
+}
+
+// DateAddDuration returns the definition for $dateAddDuration(millis, duration).
+// Adds an ISO 8601 duration to a timestamp (UTC). Years and months are calendar
+// units, normalized like $dateAdd ("P1M" from January 31 gives March 2 or 3);
+// the other components are added as fixed lengths. A negative duration
+// ("-P1D") subtracts.
+func DateAddDuration() functions.CustomFunctionDef {
+	return functions.CustomFunctionDef{
+		Name:      "dateAddDuration",
+		Signature: "<n-s:n>",
+		Fn: func(_ context.Context, args ...interface{}) (interface{}, error) {
+			ms, err := toFloat(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("$dateAddDuration: %w", err)
+			}
+			s, ok := args[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("$dateAddDuration: duration must be a string")
+			}
+			d, err := parseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("$dateAddDuration: %w", err)
+			}
+			if d.years != math.Trunc(d.years) || d.months != math.Trunc(d.months) {
+				return nil, fmt.Errorf("$dateAddDuration: years and months of %q must be whole numbers", s)
+			}
+			t := msToTime(ms).AddDate(int(d.sign*d.years), int(d.sign*d.months), 0)
+			return timeToMs(t.Add(time.Duration(d.fixedMillis()) * time.Millisecond)), nil
+		},
+	}
+}
@@ -22,6 +22,9 @@ func All() []functions.CustomFunctionDef {
 		DateComponents(),
 		DateStartOf(),
 		DateEndOf(),
+		ParseDuration(),
+		FormatDuration(),
+		DateAddDuration(),
 	}
 }
 
@@ -3,8 +3,12 @@ package extutil
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/types"
 )
 
 // AsObjectMap converts a JSONata object value (either map[string]interface{}
@@ -68,3 +72,77 @@ func ObjectLen(v interface{}) int {
 		return -1
 	}
 }
+
+// ValueKey returns a canonical string for a JSONata value such that two values
+// have the same key exactly when they are structurally equal: numbers compare
+// by value whatever their Go kind, strings never equal numbers, arrays compare
+// item by item and objects (maps or *evaluator.OrderedObject) compare by their
+// entries regardless of key order.
+func ValueKey(v interface{}) string {
+	var b strings.Builder
+	writeValueKey(&b, v)
+	return b.String()
+}
+
+func writeValueKey(b *strings.Builder, v interface{}) {
+	switch val := v.(type) {
+	case nil, types.Null:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(val))
+	case string:
+		b.WriteString(strconv.Quote(val))
+	case float64:
+		writeNumberKey(b, val)
+	case float32:
+		writeNumberKey(b, float64(val))
+	case int:
+		writeNumberKey(b, float64(val))
+	case int64:
+		writeNumberKey(b, float64(val))
+	case int32:
+		writeNumberKey(b, float64(val))
+	case []interface{}:
+		b.WriteByte('[')
+		for i, item := range val {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeValueKey(b, item)
+		}
+		b.WriteByte(']')
+	case map[string]interface{}:
+		writeObjectKey(b, val)
+	case *evaluator.OrderedObject:
+		writeObjectKey(b, val.Values)
+	default:
+		// Functions and other opaque values are only equal to themselves.
+		fmt.Fprintf(b, "%T:%p", v, v)
+	}
+}
+
+func writeNumberKey(b *strings.Builder, n float64) {
+	if n == 0 {
+		n = 0 // -0 equals 0
+	}
+	b.WriteByte('n')
+	b.WriteString(strconv.FormatFloat(n, 'g', -1, 64))
+}
+
+func writeObjectKey(b *strings.Builder, values map[string]interface{}) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Quote(k))
+		b.WriteByte(':')
+		writeValueKey(b, values[k])
+	}
+	b.WriteByte('}')
+}
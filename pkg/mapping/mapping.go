@@ -0,0 +1,200 @@
+// Package mapping compiles declarative JSON-to-JSON mappings into a single
+// JSONata expression.
+//
+// A mapping spec is a JSON object whose keys are the fields of the target
+// object and whose values are the JSONata expressions computing them, or
+// nested specs for nested objects:
+//
+//	{
+//	  "id":       "order.id",
+//	  "customer": {
+//	    "name":  "order.customer.firstName & ' ' & order.customer.lastName",
+//	    "email": "$lowercase(order.customer.email)"
+//	  },
+//	  "total":    "$sum(order.items.(price * quantity))"
+//	}
+//
+// Compile turns the spec into one expression building the whole target object,
+// so that a record is transformed by a single evaluation instead of one per
+// field. Sub-expressions repeated across fields (order.customer above) are
+// evaluated once and shared (see parser.WithOptimize).
+package mapping
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Field is a field of the target object: the expression computing it, or the
+// fields of a nested object.
+type Field struct {
+	Name   string
+	Expr   string
+	Fields Spec
+}
+
+// Spec is a mapping specification: the fields of the target object, in the
+// order they appear in the result.
+type Spec []Field
+
+// ParseSpec decodes a mapping spec from JSON. Field order is preserved.
+func ParseSpec(data []byte) (Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, keeping the fields in document
+// order.
+func (s *Spec) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	spec, err := decodeSpec(dec, "")
+	if err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("mapping: unexpected data after the spec")
+	}
+	*s = spec
+	return nil
+}
+
+func decodeSpec(dec *json.Decoder, path string) (Spec, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, fmt.Errorf("mapping: %s must be an object", specPath(path))
+	}
+	spec := Spec{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		field := Field{Name: tok.(string)}
+		fieldPath := joinPath(path, field.Name)
+		for _, f := range spec {
+			if f.Name == field.Name {
+				return nil, fmt.Errorf("mapping: duplicate field %q", fieldPath)
+			}
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		switch raw[0] {
+		case '"':
+			if err := json.Unmarshal(raw, &field.Expr); err != nil {
+				return nil, err
+			}
+		case '{':
+			if field.Fields, err = decodeSpec(json.NewDecoder(bytes.NewReader(raw)), fieldPath); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("mapping: field %q must be an expression string or an object", fieldPath)
+		}
+		spec = append(spec, field)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func specPath(path string) string {
+	if path == "" {
+		return "the spec"
+	}
+	return fmt.Sprintf("field %q", path)
+}
+
+// Compile compiles spec into a single expression that evaluates to the target
+// object. Each field expression is first compiled on its own, so that a syntax
+// error is reported with the name of its field; opts apply to every
+// compilation.
+//
+// Every field expression is evaluated against the same input, as if it were
+// evaluated alone, and variables bound in one field are not visible in the
+// others. The expression is compiled with parser.WithOptimize, so that
+// sub-expressions that several fields evaluate against the input (paths,
+// operators, and with parser.WithBuiltinFunctions calls of built-ins) are
+// computed once, before the fields; an error raised by one of them is
+// therefore reported even if the field that would have evaluated it first
+// fails differently.
+func Compile(spec Spec, opts ...parser.CompileOption) (*types.Expression, error) {
+	if err := checkFields(spec, "", opts); err != nil {
+		return nil, err
+	}
+	var src strings.Builder
+	writeObject(&src, spec)
+	expr, err := parser.Compile(src.String(), append(opts[:len(opts):len(opts)], parser.WithOptimize(true))...)
+	if err != nil {
+		return nil, fmt.Errorf("mapping: %w", err)
+	}
+	return expr, nil
+}
+
+// CompileJSON parses a JSON mapping spec and compiles it (see Compile).
+func CompileJSON(data []byte, opts ...parser.CompileOption) (*types.Expression, error) {
+	spec, err := ParseSpec(data)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(spec, opts...)
+}
+
+func checkFields(spec Spec, path string, opts []parser.CompileOption) error {
+	for _, f := range spec {
+		fieldPath := joinPath(path, f.Name)
+		if f.Fields != nil {
+			if err := checkFields(f.Fields, fieldPath, opts); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := parser.Compile(f.Expr, opts...); err != nil {
+			return fmt.Errorf("mapping: field %q: %w", fieldPath, err)
+		}
+	}
+	return nil
+}
+
+// writeObject writes the object constructor of spec. Field expressions are
+// parenthesized, which also scopes their variables to the field.
+func writeObject(b *strings.Builder, spec Spec) {
+	b.WriteByte('{')
+	for i, f := range spec {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		key, _ := json.Marshal(f.Name)
+		b.Write(key)
+		b.WriteString(": ")
+		if f.Fields != nil {
+			writeObject(b, f.Fields)
+			continue
+		}
+		b.WriteByte('(')
+		b.WriteString(f.Expr)
+		b.WriteByte(')')
+	}
+	b.WriteByte('}')
+}
@@ -0,0 +1,356 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Constant bindings.
+//
+// With WithConstants, Compile replaces the references to the given variables
+// by their values, written as literals, and then folds the operations whose
+// operands have all become literals:
+//
+//	$price * (1 + $vatRate)  with {"vatRate": 0.2}  ->  $price * 1.2
+//	$env = "prod" ? a : b    with {"env": "prod"}   ->  a
+//
+// Folding covers arithmetic on numbers, comparisons of two numbers, two
+// strings or two booleans, & on strings, and and or on booleans, unary minus
+// and conditions on a boolean; an operation that would fail, such as a
+// division by zero, is left for the evaluation to report. A variable the
+// expression binds itself (with :=, as a lambda parameter, or with @ and #) is
+// left alone wherever it is bound. Where a literal would not be read as a
+// value, as a path step or a called function, the constant is wrapped in a
+// block: `a.$name` becomes `a.("field")`.
+//
+// The expression evaluated by $eval is only known at run time, so the
+// constants are also kept on the compiled Expression (see
+// types.Expression.Constants), for the evaluator to bind them as variables.
+
+// WithConstants binds the variables named by the keys of consts (without the
+// $) to constant values at compile time; see CompileWithConstants.
+func WithConstants(consts map[string]interface{}) CompileOption {
+	return func(opts *CompileOptions) {
+		opts.Constants = consts
+	}
+}
+
+// CompileWithConstants compiles query with the variables named by the keys of
+// consts (without the $) bound to their values, which are folded into the
+// expression: static configuration then costs nothing at evaluation time.
+// Values may be nil, booleans, strings, numbers of any Go numeric type or
+// json.Number, and []interface{} and map[string]interface{} holding such
+// values; any other value is an error. The constants are also visible to the
+// expressions evaluated with $eval.
+func CompileWithConstants(query string, consts map[string]interface{}, opts ...CompileOption) (*types.Expression, error) {
+	return Compile(query, append(opts, WithConstants(consts))...)
+}
+
+// bindConstants returns root with the references to consts replaced and the
+// resulting constant operations folded.
+func bindConstants(root *types.ASTNode, consts map[string]interface{}) (*types.ASTNode, error) {
+	names := make([]string, 0, len(consts))
+	for name := range consts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if _, err := constantNode(consts[name], 0); err != nil {
+			return nil, fmt.Errorf("constant $%s: %w", name, err)
+		}
+	}
+	bound := map[string]bool{}
+	collectBoundNames(root, bound)
+	b := &constBinder{consts: consts, bound: bound}
+	return b.rewrite(root, true), nil
+}
+
+type constBinder struct {
+	consts map[string]interface{}
+	bound  map[string]bool
+}
+
+// rewrite returns n with its constants replaced and folded. bare is false
+// where a literal would not be read as a value: path steps, including the
+// operand of a filter or sort step, and called functions. A constant is
+// wrapped in a block there.
+func (b *constBinder) rewrite(n *types.ASTNode, bare bool) *types.ASTNode {
+	if n == nil || n.Type == types.NodeImport {
+		return n
+	}
+	if n.Type == types.NodeVariable {
+		value, ok := b.consts[n.StrValue]
+		if !ok || b.bound[n.StrValue] || n.KeepArray {
+			return n
+		}
+		lit, _ := constantNode(value, n.Position) // checked by bindConstants
+		if !bare {
+			block := types.NewASTNode(types.NodeBlock, n.Position)
+			block.Expressions = []*types.ASTNode{lit}
+			return block
+		}
+		return lit
+	}
+
+	path := n.Type == types.NodePath
+	lhs := !path
+	switch n.Type {
+	case types.NodeFilter, types.NodeSort:
+		lhs = bare
+	case types.NodeFunction, types.NodePartial:
+		lhs = false
+	}
+	n.LHS = b.rewrite(n.LHS, lhs)
+	n.RHS = b.rewrite(n.RHS, !path && !(n.Type == types.NodeBinary && n.StrValue == "~>"))
+	for i, c := range n.Steps {
+		n.Steps[i] = b.rewrite(c, false)
+	}
+	for i, c := range n.Arguments {
+		n.Arguments[i] = b.rewrite(c, n.Type != types.NodeLambda)
+	}
+	for i, c := range n.Expressions {
+		n.Expressions[i] = b.rewrite(c, true)
+	}
+	if folded := foldConstant(n); folded != nil {
+		return folded
+	}
+	return n
+}
+
+// foldConstant returns the literal n evaluates to, or nil when n is not an
+// operation on literals that can be folded.
+func foldConstant(n *types.ASTNode) *types.ASTNode {
+	if n.KeepArray || n.ConsArray {
+		return nil
+	}
+	switch n.Type {
+	case types.NodeUnary:
+		if n.StrValue == "-" && n.LHS.Type == types.NodeNumber {
+			return numberNode(-n.LHS.NumValue, n.Position)
+		}
+	case types.NodeCondition:
+		if cond, ok := n.LHS.Value.(bool); ok && n.LHS.Type == types.NodeBoolean {
+			if cond {
+				return n.RHS
+			}
+			if len(n.Expressions) == 1 {
+				return n.Expressions[0]
+			}
+		}
+	case types.NodeBinary:
+		return foldBinary(n)
+	}
+	return nil
+}
+
+// foldBinary folds the binary operation n on two literals.
+func foldBinary(n *types.ASTNode) *types.ASTNode {
+	l, r := n.LHS, n.RHS
+	if l == nil || r == nil || l.Type != r.Type {
+		return nil
+	}
+	pos := n.Position
+	switch l.Type {
+	case types.NodeNumber:
+		a, b := l.NumValue, r.NumValue
+		var v float64
+		switch n.StrValue {
+		case "+":
+			v = a + b
+		case "-":
+			v = a - b
+		case "*":
+			v = a * b
+		case "/":
+			if b == 0 {
+				return nil
+			}
+			v = a / b
+		case "%":
+			if b == 0 {
+				return nil
+			}
+			v = math.Mod(a, b)
+		case "=", "!=", "<", "<=", ">", ">=":
+			return boolNode(compareOrdered(n.StrValue, a, b), pos)
+		default:
+			return nil
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return numberNode(v, pos)
+	case types.NodeString:
+		switch n.StrValue {
+		case "&":
+			return stringNode(l.StrValue+r.StrValue, pos)
+		case "=", "!=", "<", "<=", ">", ">=":
+			return boolNode(compareOrdered(n.StrValue, l.StrValue, r.StrValue), pos)
+		}
+	case types.NodeBoolean:
+		a, aok := l.Value.(bool)
+		b, bok := r.Value.(bool)
+		if !aok || !bok {
+			return nil
+		}
+		switch n.StrValue {
+		case "and":
+			return boolNode(a && b, pos)
+		case "or":
+			return boolNode(a || b, pos)
+		case "=":
+			return boolNode(a == b, pos)
+		case "!=":
+			return boolNode(a != b, pos)
+		}
+	}
+	return nil
+}
+
+func compareOrdered[T float64 | string](op string, a, b T) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+// constantNode returns the literal node, or the constructor of literals,
+// holding v.
+func constantNode(v interface{}, pos int) (*types.ASTNode, error) {
+	switch c := v.(type) {
+	case nil, types.Null:
+		n := types.NewASTNode(types.NodeNull, pos)
+		n.Value = types.NullValue
+		return n, nil
+	case bool:
+		return boolNode(c, pos), nil
+	case string:
+		return stringNode(c, pos), nil
+	case json.Number:
+		f, err := c.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return finiteNumberNode(f, pos)
+	case []interface{}:
+		n := types.NewASTNode(types.NodeArray, pos)
+		n.Expressions = make([]*types.ASTNode, len(c))
+		for i, item := range c {
+			var err error
+			if n.Expressions[i], err = constantNode(item, pos); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(c))
+		for k := range c {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		n := types.NewASTNode(types.NodeObject, pos)
+		n.Expressions = make([]*types.ASTNode, len(keys))
+		for i, k := range keys {
+			value, err := constantNode(c[k], pos)
+			if err != nil {
+				return nil, err
+			}
+			pair := types.NewASTNode(types.NodeBinary, pos)
+			pair.Value, pair.StrValue = ":", ":"
+			pair.LHS, pair.RHS = stringNode(k, pos), value
+			n.Expressions[i] = pair
+		}
+		return n, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.CanInt():
+		return finiteNumberNode(float64(rv.Int()), pos)
+	case rv.CanUint():
+		return finiteNumberNode(float64(rv.Uint()), pos)
+	case rv.CanFloat():
+		return finiteNumberNode(rv.Float(), pos)
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
+
+// constantValues returns consts with their values as their literals evaluate
+// to (see constantNode): null for nil, float64 for numbers.
+func constantValues(consts map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(consts))
+	for name, v := range consts {
+		values[name] = constantValue(v)
+	}
+	return values
+}
+
+func constantValue(v interface{}) interface{} {
+	switch c := v.(type) {
+	case nil, types.Null:
+		return types.NullValue
+	case bool, string:
+		return c
+	case json.Number:
+		f, _ := c.Float64() // checked by bindConstants
+		return f
+	case []interface{}:
+		items := make([]interface{}, len(c))
+		for i, item := range c {
+			items[i] = constantValue(item)
+		}
+		return items
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(c))
+		for k, item := range c {
+			obj[k] = constantValue(item)
+		}
+		return obj
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.CanInt():
+		return float64(rv.Int())
+	case rv.CanUint():
+		return float64(rv.Uint())
+	}
+	return rv.Float()
+}
+
+func finiteNumberNode(f float64, pos int) (*types.ASTNode, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%v is not a finite number", f)
+	}
+	return numberNode(f, pos), nil
+}
+
+func numberNode(f float64, pos int) *types.ASTNode {
+	n := types.NewASTNode(types.NodeNumber, pos)
+	n.Value, n.NumValue = f, f
+	return n
+}
+
+func stringNode(s string, pos int) *types.ASTNode {
+	n := types.NewASTNode(types.NodeString, pos)
+	n.Value, n.StrValue = s, s
+	return n
+}
+
+func boolNode(v bool, pos int) *types.ASTNode {
+	n := types.NewASTNode(types.NodeBoolean, pos)
+	n.Value = v
+	return n
+}
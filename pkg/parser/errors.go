@@ -0,0 +1,259 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Parse error reporting.
+//
+// Every error returned by Parse is a *types.Error annotated with the line and
+// column of its position, a caret-annotated snippet of the offending source
+// line and, when a call to an unknown function appears near the error, a
+// "did you mean" suggestion:
+//
+//	S0202 at position 30 (line 3, column 18): Expected "," or ")" but got "]"; did you mean $substring?
+//	   3 |   $substrng($x, 1]
+//	     |                  ^
+
+// snippetWidth is the number of runes of the source line shown around the
+// error column; longer lines are elided on both sides.
+const snippetWidth = 72
+
+// builtinFunctionNames lists the built-in functions offered as suggestions for
+// misspelled function names.
+var builtinFunctionNames = []string{
+	"abs", "all", "any", "append", "assert", "average", "base64decode", "base64encode",
+	"boolean", "ceil", "contains", "count", "decodeUrl", "decodeUrlComponent", "distinct",
+	"doc", "done", "each", "encodeUrl", "encodeUrlComponent", "error", "eval", "exists",
+	"filter", "first", "floor", "formatBase", "formatInteger", "formatNumber", "fromMillis",
+	"join", "joinOn", "keys", "length", "lookup", "lowercase", "map", "match", "max",
+	"merge", "millis", "min", "not", "now", "number", "pad", "parseInteger", "power",
+	"random", "reduce", "replace", "reverse", "round", "shuffle", "sift", "single", "sort",
+	"split", "spread", "sqrt", "string", "substring", "substringAfter", "substringBefore",
+	"sum", "toMillis", "trim", "type", "uppercase", "zip",
+}
+
+// BuiltinFunctionNames returns the names (without "$") of the built-in
+// functions the parser suggests for misspelled function calls.
+func BuiltinFunctionNames() []string {
+	return append([]string(nil), builtinFunctionNames...)
+}
+
+// errorExpected reports that the current token is none of the expected token
+// classes. Each class is a display label such as `")"` or "an expression".
+func (p *Parser) errorExpected(code types.ErrorCode, expected ...string) error {
+	msg := fmt.Sprintf("Expected %s but got %s", alternatives(expected), describeToken(p.current))
+	err := p.error(code, msg)
+	err.(*types.Error).Expected = expected
+	return err
+}
+
+// tokenLabel returns the display label of a token type for error messages.
+func tokenLabel(tt TokenType) string {
+	switch tt {
+	case TokenEOF:
+		return "end of expression"
+	case TokenString, TokenNumber, TokenBoolean, TokenNull, TokenName, TokenNameEsc, TokenVariable, TokenRegex:
+		return strings.Trim(tt.String(), "()")
+	}
+	return fmt.Sprintf("%q", operatorString(tt))
+}
+
+// describeToken describes the token found where another was expected.
+func describeToken(t Token) string {
+	switch t.Type {
+	case TokenEOF:
+		return "end of expression"
+	case TokenString:
+		return fmt.Sprintf("string %q", t.Value)
+	case TokenNumber, TokenBoolean, TokenNull, TokenName, TokenNameEsc, TokenVariable, TokenRegex:
+		return fmt.Sprintf("%s %s", tokenLabel(t.Type), t.Value)
+	}
+	return tokenLabel(t.Type)
+}
+
+// alternatives joins labels as `a, b or c`.
+func alternatives(labels []string) string {
+	switch len(labels) {
+	case 0:
+		return ""
+	case 1:
+		return labels[0]
+	}
+	return strings.Join(labels[:len(labels)-1], ", ") + " or " + labels[len(labels)-1]
+}
+
+// annotate completes a parse error with its line, column, source snippet and
+// function name suggestion. Errors that are not *types.Error are returned as is.
+func (p *Parser) annotate(err error) error {
+	var perr *types.Error
+	if !errors.As(err, &perr) || perr.Position < 0 || perr.Line > 0 {
+		return err
+	}
+	input := p.lexer.input
+	pos := min(perr.Position, len(input))
+	perr.Line, perr.Column = lineColumn(input, pos)
+	perr.Snippet = snippet(input, pos, perr.Line)
+	if perr.Suggestion == "" {
+		perr.Suggestion = suggestFunction(input, pos, perr.Line)
+	}
+	return err
+}
+
+// lineColumn converts a byte offset into a 1-based line and rune column.
+func lineColumn(input string, pos int) (line, column int) {
+	before := input[:pos]
+	line = strings.Count(before, "\n") + 1
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	return line, utf8.RuneCountInString(before[lineStart:]) + 1
+}
+
+// snippet renders the source line holding pos, prefixed with its number, and a
+// caret line under the error column.
+func snippet(input string, pos, line int) string {
+	lineStart := strings.LastIndexByte(input[:pos], '\n') + 1
+	lineEnd := strings.IndexByte(input[pos:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(input)
+	} else {
+		lineEnd += pos
+	}
+	src := []rune(strings.TrimRight(input[lineStart:lineEnd], "\r"))
+	col := utf8.RuneCountInString(input[lineStart:pos])
+
+	// Elide long lines around the error column.
+	prefix, suffix := "", ""
+	if len(src) > snippetWidth {
+		from := max(0, min(col-snippetWidth/2, len(src)-snippetWidth))
+		to := min(len(src), from+snippetWidth)
+		if from > 0 {
+			prefix = "…"
+		}
+		if to < len(src) {
+			suffix = "…"
+		}
+		src = src[from:to]
+		col -= from
+		if prefix != "" {
+			col++
+		}
+	}
+	// The caret line copies tabs so that the caret stays aligned.
+	shown := []rune(prefix + string(src))
+	var pad strings.Builder
+	for i := 0; i < col; i++ {
+		if i < len(shown) && shown[i] == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+
+	num := fmt.Sprintf("%4d", line)
+	gutter := strings.Repeat(" ", len(num))
+	return fmt.Sprintf("%s | %s%s%s\n%s | %s^", num, prefix, string(src), suffix, gutter, pad.String())
+}
+
+// suggestFunction returns "$name" for the built-in function closest to the
+// name of an unknown function called on the error line, before the error.
+// Functions the expression defines itself are never corrected.
+func suggestFunction(input string, pos, line int) string {
+	lexer := NewLexer(input)
+	defined := map[string]bool{}
+	var calls []Token
+	var prev Token
+	inParams := false
+	for {
+		tok := lexer.Next(regexAllowedAfter(prev.Type))
+		if tok.Type == TokenEOF || tok.Type == TokenError {
+			break
+		}
+		switch {
+		case inParams:
+			// Lambda parameters may shadow built-ins.
+			if tok.Type == TokenVariable {
+				defined[tok.Value] = true
+			}
+			inParams = tok.Type != TokenParenClose
+		case tok.Type == TokenParenOpen && prev.Type == TokenName && (prev.Value == "function" || prev.Value == "λ"):
+			inParams = true
+		case tok.Type == TokenAssign && prev.Type == TokenVariable:
+			defined[prev.Value] = true
+		case tok.Type == TokenParenOpen && prev.Type == TokenVariable && tok.Position <= pos:
+			if l, _ := lineColumn(input, prev.Position); l == line {
+				calls = append(calls, prev)
+			}
+		}
+		prev = tok
+	}
+
+	// The call closest to the error wins.
+	for i := len(calls) - 1; i >= 0; i-- {
+		name := strings.TrimPrefix(calls[i].Value, "$")
+		if defined[calls[i].Value] || isBuiltinFunction(name) {
+			continue
+		}
+		if best := closestFunction(name); best != "" {
+			return "$" + best
+		}
+	}
+	return ""
+}
+
+func isBuiltinFunction(name string) bool {
+	for _, fn := range builtinFunctionNames {
+		if fn == name {
+			return true
+		}
+	}
+	return false
+}
+
+// closestFunction returns the built-in function name within editing distance of
+// name (one edit for short names, two otherwise), or "".
+func closestFunction(name string) string {
+	limit := 2
+	if utf8.RuneCountInString(name) <= 4 {
+		limit = 1
+	}
+	best, bestDist := "", limit+1
+	for _, fn := range builtinFunctionNames {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(fn)); d < bestDist {
+			best, bestDist = fn, d
+		}
+	}
+	return best
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and transpositions of adjacent runes
+// each count as one edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
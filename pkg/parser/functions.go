@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Known functions (WithKnownFunctions).
+//
+// A call names its function through a variable: $f(x), $f(?, x) and x ~> $f.
+// The variable is either bound by the expression or resolved by the evaluator
+// to one of its functions. Scopes are not tracked: a name bound anywhere in the
+// expression may be called anywhere, so that only names that cannot be bound
+// when the call is evaluated are reported.
+
+// checkFunctions returns an S0410 error for the first call in root of a
+// function that root does not bind and known does not report.
+func checkFunctions(root *types.ASTNode, known func(name string) bool) error {
+	bound := map[string]bool{}
+	collectBoundNames(root, bound)
+	return checkCalls(root, func(name string) bool { return bound[name] || known(name) })
+}
+
+// collectBoundNames adds to bound the names of the variables bound in node.
+func collectBoundNames(node *types.ASTNode, bound map[string]bool) {
+	if node == nil || node.Type == types.NodeImport {
+		return
+	}
+	switch node.Type {
+	case types.NodeBind:
+		bound[node.StrValue] = true
+	case types.NodeLambda:
+		for _, param := range node.Arguments {
+			bound[param.StrValue] = true
+		}
+	case types.NodeContext, types.NodeIndex:
+		if node.RHS != nil {
+			bound[node.RHS.StrValue] = true
+		}
+	}
+	for _, child := range children(node) {
+		collectBoundNames(child, bound)
+	}
+}
+
+// checkCalls returns the error of the first call in node of a function that
+// callable does not accept. Imported modules were checked when compiled.
+func checkCalls(node *types.ASTNode, callable func(name string) bool) error {
+	if node == nil || node.Type == types.NodeImport {
+		return nil
+	}
+	var fn *types.ASTNode
+	switch node.Type {
+	case types.NodeFunction, types.NodePartial:
+		fn = node.LHS
+	case types.NodeBinary:
+		if node.StrValue == "~>" {
+			fn = node.RHS
+		}
+	}
+	if fn != nil && fn.Type == types.NodeVariable && fn.StrValue != "" && fn.StrValue != "$" && !callable(fn.StrValue) {
+		return types.NewError(types.ErrUnknownFunction,
+			fmt.Sprintf("unknown function: $%s", fn.StrValue), fn.Position)
+	}
+	for _, child := range children(node) {
+		if err := checkCalls(child, callable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
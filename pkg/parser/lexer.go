@@ -376,8 +376,9 @@ func (l *Lexer) skipWhitespace() {
 				}
 				l.ignore()
 			} else {
-				// Not a comment, backup
-				l.backup()
+				// Not a comment: un-read the '/'. backup would step back by the
+				// width of the rune after it, which accept has just un-read.
+				l.current--
 				break
 			}
 		} else {
@@ -0,0 +1,58 @@
+package parser
+
+import "github.com/sandrolain/gosonata/pkg/types"
+
+// CompileMany compiles queries to be evaluated together against the same
+// input, as the rules of a rules engine are against each event. A query that
+// does not compile has its error in the set; the others compile as with
+// Compile.
+//
+// The sub-expressions that several queries evaluate in the context of the
+// input (see eliminateCommon) are shared: evaluator.Evaluator.EvalMany
+// evaluates them once for all the queries.
+func CompileMany(queries []string, opts ...CompileOption) *types.ExpressionSet {
+	set := &types.ExpressionSet{
+		Expressions: make([]*types.Expression, len(queries)),
+		Errors:      make([]error, len(queries)),
+		Rewritten:   make([]*types.ASTNode, len(queries)),
+	}
+
+	// The queries are compiled a second time into one tree, whose shared
+	// sub-expressions are rewritten in place.
+	all := types.NewASTNode(types.NodeArray, 0)
+	var compiled []int
+	for i, query := range queries {
+		expr, err := Compile(query, opts...)
+		if err != nil {
+			set.Errors[i] = err
+			continue
+		}
+		set.Expressions[i] = expr
+
+		p := NewParser(query, opts...)
+		p.opts.Optimize = false
+		copied, err := p.Parse()
+		if err != nil {
+			set.Errors[i] = err
+			set.Expressions[i] = nil
+			continue
+		}
+		all.Expressions = append(all.Expressions, copied.AST())
+		compiled = append(compiled, i)
+	}
+
+	var options CompileOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	root := eliminateCommon(all, options.BuiltinFunction)
+	if root.Type == types.NodeBlock {
+		// The bindings of the shared sub-expressions, then the queries.
+		set.Shared = root.Expressions[:len(root.Expressions)-1]
+		all = root.Expressions[len(root.Expressions)-1]
+	}
+	for j, i := range compiled {
+		set.Rewritten[i] = all.Expressions[j]
+	}
+	return set
+}
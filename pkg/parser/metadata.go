@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// ParseMetadata returns the metadata declared by the annotation block at the
+// start of source: a /** ... */ comment, preceded by whitespace only, whose
+// lines may start with " * ". Lines of the form `@name value` are annotations
+// (see types.Metadata); the text before the first one is the description. It
+// returns nil when source does not start with an annotation block.
+//
+// Compile sets the metadata of the expressions it returns; ParseMetadata lets
+// a catalog read it without compiling the expression.
+func ParseMetadata(source string) *types.Metadata {
+	body, ok := strings.CutPrefix(strings.TrimLeft(source, " \t\r\n"), "/**")
+	if !ok {
+		return nil
+	}
+	body, _, ok = strings.Cut(body, "*/")
+	if !ok {
+		return nil
+	}
+
+	m := &types.Metadata{}
+	var text []string
+	inText := true
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimPrefix(line, "*"))
+		if !strings.HasPrefix(line, "@") {
+			if inText {
+				text = append(text, line)
+			}
+			continue
+		}
+		inText = false
+		name, value := line[1:], ""
+		if i := strings.IndexAny(name, " \t"); i >= 0 {
+			name, value = name[:i], strings.TrimSpace(name[i:])
+		}
+		switch name {
+		case "name":
+			m.Name = value
+		case "description":
+			m.Description = value
+		case "author":
+			m.Author = value
+		case "version":
+			m.Version = value
+		case "tags":
+			m.Tags = append(m.Tags, strings.FieldsFunc(value, func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t'
+			})...)
+		default:
+			if name == "" {
+				continue
+			}
+			if m.Annotations == nil {
+				m.Annotations = map[string]string{}
+			}
+			m.Annotations[name] = value
+		}
+	}
+	if m.Description == "" {
+		m.Description = strings.TrimSpace(strings.Join(text, "\n"))
+	}
+	return m
+}
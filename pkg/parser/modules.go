@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Modules.
+//
+// With WithModuleResolver, a call `$import("name")` is resolved at compile time:
+// the resolver returns the source of the module, which is compiled with the
+// same options as the importing expression (its own imports included) and
+// attached to the call, rewritten to a types.NodeImport node. At evaluation the
+// call evaluates to the value of the module expression, usually an object of
+// functions:
+//
+//	// lib/currency
+//	(
+//	  $rates := {"EUR": 1, "USD": 1.08};
+//	  {"convert": function($v, $from) { $v * $lookup($rates, $from) }}
+//	)
+//
+//	($convert := $import("lib/currency").convert; $convert(total, "USD"))
+//
+// A module imported several times (directly or through other modules) is
+// compiled once per Compile. An import cycle is a compile error.
+
+// ModuleResolver returns the source of the module imported as name.
+type ModuleResolver func(name string) (string, error)
+
+// FSModules returns a resolver reading the module name from the file
+// name + ".jsonata" of fsys, e.g. os.DirFS("expressions") or an embed.FS.
+func FSModules(fsys fs.FS) ModuleResolver {
+	return func(name string) (string, error) {
+		src, err := fs.ReadFile(fsys, name+".jsonata")
+		if err != nil {
+			return "", err
+		}
+		return string(src), nil
+	}
+}
+
+// moduleLoader holds the modules compiled for one Compile call, shared by the
+// parsers of the expression and of all the modules it imports.
+type moduleLoader struct {
+	resolve ModuleResolver
+	// loading is the chain of modules being compiled, for cycle detection.
+	loading []string
+	// compiled maps the modules compiled so far to their AST.
+	compiled map[string]*types.ASTNode
+}
+
+// resolveImports rewrites the $import calls below node into NodeImport nodes
+// holding the compiled module.
+func (p *Parser) resolveImports(node *types.ASTNode) error {
+	if node == nil || node.Type == types.NodeImport {
+		return nil
+	}
+	if node.Type == types.NodeFunction && node.LHS != nil &&
+		node.LHS.Type == types.NodeVariable && node.LHS.StrValue == "import" {
+		return p.resolveImport(node)
+	}
+	for _, child := range children(node) {
+		if err := p.resolveImports(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Parser) resolveImport(call *types.ASTNode) error {
+	if len(call.Arguments) != 1 || call.Arguments[0].Type != types.NodeString {
+		return types.NewError(types.ErrImportArgument,
+			"The argument of $import must be a string literal naming a module", call.Position)
+	}
+	name := call.Arguments[0].StrValue
+	module, err := p.loadModule(name, call.Arguments[0].Position)
+	if err != nil {
+		return err
+	}
+	call.Type = types.NodeImport
+	call.StrValue = name
+	call.LHS = module
+	call.Arguments = nil
+	return nil
+}
+
+// loadModule returns the AST of the module name, compiling it on first use.
+func (p *Parser) loadModule(name string, pos int) (*types.ASTNode, error) {
+	m := p.modules
+	if module, ok := m.compiled[name]; ok {
+		return module, nil
+	}
+	for i, loading := range m.loading {
+		if loading == name {
+			cycle := append(append([]string(nil), m.loading[i:]...), name)
+			return nil, types.NewError(types.ErrImportCycle,
+				fmt.Sprintf("Import cycle: %s", strings.Join(cycle, " -> ")), pos)
+		}
+	}
+
+	src, err := m.resolve(name)
+	if err != nil {
+		return nil, types.NewError(types.ErrModuleNotFound,
+			fmt.Sprintf("Cannot import module %q: %v", name, err), pos)
+	}
+
+	m.loading = append(m.loading, name)
+	defer func() { m.loading = m.loading[:len(m.loading)-1] }()
+
+	child := &Parser{
+		lexer:   NewLexer(src),
+		opts:    p.opts,
+		arena:   p.arena,
+		modules: m,
+	}
+	child.advance()
+	expr, err := child.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("module %q: %w", name, err)
+	}
+	m.compiled[name] = expr.AST()
+	return expr.AST(), nil
+}
@@ -0,0 +1,357 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Common subexpression elimination.
+//
+// With WithOptimize, Compile binds the sub-expressions that the expression
+// evaluates more than once in the same context to variables, evaluated once
+// before the rest:
+//
+//	$sum(a.b.c) / $count(a.b.c)  ->  ($cse := a.b.c; $sum($cse) / $count($cse))
+//
+// Only operands that the evaluator always evaluates in the context of the whole
+// expression are considered (see contextOperands): the operands of operators,
+// function arguments, path heads, the items of array constructors, the
+// entries of object constructors and the expressions of blocks. A larger
+// shared sub-expression is preferred over the ones inside it.
+
+// csePrefix names the variables holding shared sub-expressions. It cannot be
+// written in an expression ('#' ends a variable name), so the names never
+// clash with the variables of the expression.
+const csePrefix = "cse#"
+
+// impureFunctions are the built-ins that may return a different value on each
+// call of an evaluation, or that evaluate an expression the optimizer cannot
+// see ($eval).
+var impureFunctions = map[string]bool{"random": true, "shuffle": true, "doc": true, "eval": true}
+
+// pureFunctions are the built-ins that return the same value for the same
+// arguments within an evaluation.
+var pureFunctions = func() map[string]bool {
+	m := make(map[string]bool, len(builtinFunctionNames))
+	for _, name := range builtinFunctionNames {
+		if !impureFunctions[name] {
+			m[name] = true
+		}
+	}
+	return m
+}()
+
+// eliminateCommon returns root with its common sub-expressions shared.
+// builtin reports the names that are the built-ins of the evaluator (see
+// WithBuiltinFunctions); nil trusts none, and no call is shared.
+func eliminateCommon(root *types.ASTNode, builtin func(name string) bool) *types.ASTNode {
+	s := sharer{counts: map[string]int{}, names: map[string]string{}, uses: map[string][]*types.ASTNode{},
+		builtin: builtin}
+	s.count(root, nil)
+	root = s.rewrite(root, nil)
+	if len(s.binds) == 0 {
+		return root
+	}
+	// A sub-expression used once after its enclosing one was shared goes
+	// back in place.
+	binds := s.binds[:0]
+	for _, bind := range s.binds {
+		if uses := s.uses[bind.StrValue]; len(uses) == 1 {
+			*uses[0] = *bind.RHS
+			continue
+		}
+		binds = append(binds, bind)
+	}
+	if len(binds) == 0 {
+		return root
+	}
+	block := types.NewASTNode(types.NodeBlock, root.Position)
+	block.Expressions = append(binds, root)
+	return block
+}
+
+type sharer struct {
+	counts map[string]int
+	names  map[string]string           // key -> variable name
+	uses   map[string][]*types.ASTNode // variable name -> variable nodes
+	binds  []*types.ASTNode
+	// builtin reports the unmodified built-ins of the evaluator.
+	builtin func(name string) bool
+}
+
+// count counts the shareable sub-expressions of n. bound holds the variables
+// bound by the blocks around n, which a shared sub-expression must not read:
+// it is evaluated outside them.
+func (s *sharer) count(n *types.ASTNode, bound map[string]bool) {
+	if s.shareable(n, bound) {
+		s.counts[nodeKey(n)]++
+	}
+	bound = blockBindings(n, bound)
+	for _, op := range contextOperands(n) {
+		s.count(op, bound)
+	}
+}
+
+func (s *sharer) rewrite(n *types.ASTNode, bound map[string]bool) *types.ASTNode {
+	if s.shareable(n, bound) {
+		key := nodeKey(n)
+		if s.counts[key] > 1 {
+			name, ok := s.names[key]
+			if !ok {
+				// Bind the operands first: the binding may use them.
+				s.rewriteOperands(n, bound)
+				name = fmt.Sprintf("%s%d", csePrefix, len(s.names))
+				s.names[key] = name
+				bind := types.NewASTNode(types.NodeBind, n.Position)
+				bind.Value, bind.StrValue = name, name
+				bind.LHS = variableNode(name, n.Position)
+				bind.RHS = n
+				s.binds = append(s.binds, bind)
+			}
+			v := variableNode(name, n.Position)
+			s.uses[name] = append(s.uses[name], v)
+			return v
+		}
+	}
+	s.rewriteOperands(n, blockBindings(n, bound))
+	return n
+}
+
+func (s *sharer) rewriteOperands(n *types.ASTNode, bound map[string]bool) {
+	for _, op := range contextOperands(n) {
+		if replaced := s.rewrite(op, bound); replaced != op {
+			replaceOperand(n, op, replaced)
+		}
+	}
+}
+
+func variableNode(name string, pos int) *types.ASTNode {
+	v := types.NewASTNode(types.NodeVariable, pos)
+	v.Value, v.StrValue = name, name
+	return v
+}
+
+// blockBindings returns bound extended with the variables bound by n, if n is
+// a block.
+func blockBindings(n *types.ASTNode, bound map[string]bool) map[string]bool {
+	if n.Type != types.NodeBlock {
+		return bound
+	}
+	extended := make(map[string]bool, len(bound)+len(n.Expressions))
+	for name := range bound {
+		extended[name] = true
+	}
+	for _, expr := range n.Expressions {
+		for ; expr != nil && expr.Type == types.NodeBind; expr = expr.RHS {
+			extended[expr.StrValue] = true
+		}
+	}
+	return extended
+}
+
+// contextOperands returns the operands of n that are always evaluated, in the
+// context n is evaluated in. Operands evaluated per item (path steps,
+// predicates, sort terms, grouping entries), conditionally (branches, the
+// right of and, or and the default operators) or as functions (the right of
+// ~>) are left out, and so are lambda bodies.
+func contextOperands(n *types.ASTNode) []*types.ASTNode {
+	var ops []*types.ASTNode
+	switch n.Type {
+	case types.NodeObject:
+		if n.LHS != nil {
+			return []*types.ASTNode{n.LHS}
+		}
+		for _, entry := range n.Expressions {
+			if entry.Type == types.NodeBinary && entry.StrValue == ":" {
+				ops = append(ops, entry.RHS)
+			}
+		}
+	case types.NodeBinary:
+		switch n.StrValue {
+		case "and", "or", "??", "?:", "~>":
+			ops = append(ops, n.LHS)
+		default:
+			ops = append(ops, n.LHS, n.RHS)
+		}
+	case types.NodePath, types.NodeFilter, types.NodeSort, types.NodeCondition, types.NodeUnary:
+		ops = append(ops, n.LHS)
+	case types.NodeBind:
+		ops = append(ops, n.RHS)
+	case types.NodeArray, types.NodeBlock:
+		ops = append(ops, n.Expressions...)
+	case types.NodeFunction:
+		for _, arg := range n.Arguments {
+			if arg.Type != types.NodePlaceholder {
+				ops = append(ops, arg)
+			}
+		}
+	}
+	result := ops[:0]
+	for _, op := range ops {
+		if op != nil {
+			result = append(result, op)
+		}
+	}
+	return result
+}
+
+// replaceOperand replaces the operand old of n with repl.
+func replaceOperand(n, old, repl *types.ASTNode) {
+	if n.LHS == old {
+		n.LHS = repl
+	}
+	if n.RHS == old {
+		n.RHS = repl
+	}
+	for i, op := range n.Expressions {
+		if op == old {
+			n.Expressions[i] = repl
+		} else if n.Type == types.NodeObject && op.RHS == old {
+			op.RHS = repl
+		}
+	}
+	for i, op := range n.Arguments {
+		if op == old {
+			n.Arguments[i] = repl
+		}
+	}
+}
+
+// shareable reports whether n is worth sharing and can be evaluated once in
+// place of every occurrence: it does real work, reads no per-item state (%,
+// @, #, [] on a step) nor a variable of bound, and calls no function that may
+// differ between calls.
+func (s *sharer) shareable(n *types.ASTNode, bound map[string]bool) bool {
+	switch n.Type {
+	case types.NodePath, types.NodeFunction, types.NodeFilter, types.NodeSort,
+		types.NodeBinary, types.NodeCondition, types.NodeDescendant:
+	default:
+		return false
+	}
+	if n.Type == types.NodeBinary && n.StrValue == ":" {
+		return false
+	}
+	return s.pure(n, bound, nil)
+}
+
+// pure reports whether n evaluates alike each time. params are the parameters
+// of the lambdas of n around it.
+func (s *sharer) pure(n *types.ASTNode, bound, params map[string]bool) bool {
+	if n == nil {
+		return true
+	}
+	switch n.Type {
+	case types.NodeParent, types.NodeContext, types.NodeIndex, types.NodeBind, types.NodeTransform:
+		return false
+	case types.NodeVariable:
+		if !s.pureVariable(n.StrValue, bound, params) {
+			return false
+		}
+	case types.NodeFunction, types.NodePartial:
+		if !s.pureCall(n.LHS, params) {
+			return false
+		}
+	case types.NodeBinary:
+		if n.StrValue == "~>" && !s.pureCall(n.RHS, params) {
+			return false
+		}
+	case types.NodeLambda:
+		extended := make(map[string]bool, len(params)+len(n.Arguments))
+		for name := range params {
+			extended[name] = true
+		}
+		for _, param := range n.Arguments {
+			extended[param.StrValue] = true
+		}
+		params = extended
+	}
+	if n.KeepArray {
+		return false
+	}
+	for _, c := range []*types.ASTNode{n.LHS, n.RHS} {
+		if !s.pure(c, bound, params) {
+			return false
+		}
+	}
+	for _, list := range [][]*types.ASTNode{n.Steps, n.Arguments, n.Expressions} {
+		for _, c := range list {
+			if !s.pure(c, bound, params) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pureVariable reports whether the variable name has the same value each
+// time: the context, the input, a lambda parameter, a shared sub-expression
+// or a pure built-in. Any other variable is bound by a block around, or at
+// evaluation time, and may hold a function that differs between calls, such
+// as an impure built-in or a custom function; it may be called once passed
+// as a value.
+func (s *sharer) pureVariable(name string, bound, params map[string]bool) bool {
+	switch {
+	case name == "" || name == "$" || params[name] || strings.HasPrefix(name, csePrefix):
+		return true
+	case bound[name]:
+		return false
+	}
+	return s.pureBuiltin(name)
+}
+
+// pureCall reports whether calling fn gives the same result each time within
+// an evaluation: fn is a lambda, a call returning one, or a pure built-in.
+// A lambda parameter may hold any function.
+func (s *sharer) pureCall(fn *types.ASTNode, params map[string]bool) bool {
+	if fn == nil || fn.Type != types.NodeVariable {
+		return true
+	}
+	return !params[fn.StrValue] && s.pureBuiltin(fn.StrValue)
+}
+
+// pureBuiltin reports whether name is a built-in other than the impure ones,
+// which the evaluator has not replaced.
+func (s *sharer) pureBuiltin(name string) bool {
+	return pureFunctions[name] && s.builtin != nil && s.builtin(name)
+}
+
+// nodeKey returns a string identifying the structure of n: two subtrees with
+// the same key evaluate alike.
+func nodeKey(n *types.ASTNode) string {
+	var b strings.Builder
+	writeKey(&b, n)
+	return b.String()
+}
+
+func writeKey(b *strings.Builder, n *types.ASTNode) {
+	if n == nil {
+		b.WriteString("_")
+		return
+	}
+	b.WriteByte('(')
+	b.WriteString(string(n.Type))
+	b.WriteByte(' ')
+	b.WriteString(strconv.Quote(n.StrValue))
+	switch v := n.Value.(type) {
+	case bool, nil, types.Null:
+		fmt.Fprintf(b, " %v", v)
+	}
+	if n.Type == types.NodeNumber {
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatFloat(n.NumValue, 'g', -1, 64))
+	}
+	fmt.Fprintf(b, " %t %t %q %d %q %t %t", n.KeepArray, n.ConsArray, n.Stage, n.Index, n.Signature, n.IsGrouping, n.Optional)
+	writeKey(b, n.LHS)
+	writeKey(b, n.RHS)
+	for _, list := range [][]*types.ASTNode{n.Steps, n.Arguments, n.Expressions} {
+		b.WriteByte('[')
+		for _, c := range list {
+			writeKey(b, c)
+		}
+		b.WriteByte(']')
+	}
+	b.WriteByte(')')
+}
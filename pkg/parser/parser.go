@@ -63,8 +63,60 @@ type CompileOption func(*CompileOptions)
 type CompileOptions struct {
 	// EnableRecovery enables error recovery mode for parsing invalid syntax.
 	EnableRecovery bool
-	// MaxDepth limits recursion depth to prevent stack overflow.
+	// MaxDepth limits the nesting of brackets (see WithMaxDepth).
 	MaxDepth int
+	// MaxTokens limits the number of tokens (see WithMaxTokens).
+	MaxTokens int
+	// Strict rejects structurally invalid expressions at compile time (see
+	// WithStrict).
+	Strict bool
+	// Extensions are the syntax extensions enabled with WithExtensions.
+	Extensions Extension
+	// Modules resolves the modules imported with $import (see
+	// WithModuleResolver).
+	Modules ModuleResolver
+	// Optimize enables compile-time optimizations (see WithOptimize).
+	Optimize bool
+	// KnownFunction reports the functions that calls may name (see
+	// WithKnownFunctions).
+	KnownFunction func(name string) bool
+	// BuiltinFunction reports the built-ins the optimizer may call once (see
+	// WithBuiltinFunctions).
+	BuiltinFunction func(name string) bool
+	// Constants are the variables bound at compile time (see WithConstants).
+	Constants map[string]interface{}
+	// MaxSize limits the number of nodes of the syntax tree (see
+	// WithMaxSize).
+	MaxSize int
+}
+
+// Extension is a syntax extension beyond standard JSONata. Extensions are off
+// by default, so that expressions stay portable unless one is enabled
+// explicitly with WithExtensions.
+type Extension uint
+
+const (
+	// ExtObjectSpread allows `...expr` entries in object constructors: the
+	// entries of the object (or array of objects) expr evaluates to are
+	// copied into the constructed object, in order. A later entry replaces a
+	// spread one with the same key:
+	//
+	//	orders.{...$, "total": price * quantity}
+	ExtObjectSpread Extension = 1 << iota
+	// ExtComputedKeys reads an object key written `[expr]` as a computed key:
+	// expr must evaluate to a single string, number or boolean, which becomes
+	// the key. Without the extension the key is an array constructor.
+	ExtComputedKeys
+	// ExtOptionalChaining allows `?.` in paths. It navigates exactly like
+	// `.`, but marks the step that follows as optional: under the evaluator's
+	// strict navigation mode a missing field there is undefined rather than an
+	// error. In lenient mode `a?.b` and `a.b` are the same expression.
+	ExtOptionalChaining
+)
+
+// Has reports whether all the extensions in ext are enabled in x.
+func (x Extension) Has(ext Extension) bool {
+	return x&ext == ext
 }
 
 // WithRecovery enables error recovery mode.
@@ -74,9 +126,107 @@ func WithRecovery(enable bool) CompileOption {
 	}
 }
 
-// WithMaxDepth sets the maximum parsing depth.
+// WithMaxDepth sets the maximum nesting depth of parentheses, square brackets
+// and braces, 1000 by default; 0 sets no limit. It is checked as the parser
+// reads the tokens, so that an input such as 100000 nested parentheses fails
+// with S0702 at the first bracket past the limit, without the parser
+// descending any deeper.
 func WithMaxDepth(depth int) CompileOption {
 	return func(opts *CompileOptions) {
 		opts.MaxDepth = depth
 	}
 }
+
+// WithMaxTokens limits expressions to n tokens. Like the nesting depth (see
+// WithMaxDepth), it is checked as the parser reads the tokens: a longer
+// expression fails with S0703 at the first token past the limit, without the
+// rest of the input being read. 0, the default, sets no limit.
+func WithMaxTokens(n int) CompileOption {
+	return func(opts *CompileOptions) {
+		opts.MaxTokens = n
+	}
+}
+
+// WithMaxSize limits expressions to n syntax tree nodes, about one per
+// operand, operator and path step. Parsing a larger expression stops as soon
+// as the limit is reached and fails with S0701, so that a service compiling
+// expressions it does not control bounds the memory and time spent on them.
+// 0, the default, sets no limit.
+func WithMaxSize(n int) CompileOption {
+	return func(opts *CompileOptions) {
+		opts.MaxSize = n
+	}
+}
+
+// WithStrict enables strict validation: after parsing, the expression is
+// checked for uses that the reference implementation rejects statically but
+// that would otherwise only fail, or silently yield undefined, at evaluation
+// time — literal path steps (S0213), parent operators whose parent cannot be
+// derived (S0217) and assignments to the context value (S0212).
+func WithStrict(enable bool) CompileOption {
+	return func(opts *CompileOptions) {
+		opts.Strict = enable
+	}
+}
+
+// WithOptimize enables compile-time optimizations of the AST. Currently this
+// is common subexpression elimination: a sub-expression evaluated more than
+// once in the same context, such as a.b.c in $sum(a.b.c) / $count(a.b.c), is
+// bound to a hidden variable and evaluated once per evaluation. Results are
+// unchanged, but an error raised by a shared sub-expression may be reported in
+// place of an error that an earlier part of the expression would have raised.
+//
+// Function calls are only shared with WithBuiltinFunctions: without it, a
+// name such as $sum may be a custom function of the evaluator.
+func WithOptimize(enable bool) CompileOption {
+	return func(opts *CompileOptions) {
+		opts.Optimize = enable
+	}
+}
+
+// WithBuiltinFunctions tells the optimizer (see WithOptimize and CompileMany)
+// which names are the built-in functions of the evaluator the expression is
+// meant for, such as evaluator.Evaluator.BuiltinFunction: calls of the
+// built-ins that return the same value for the same arguments, such as
+// $sum(a.b), are then shared. $random, $shuffle, $doc and $eval never are,
+// nor are calls of custom functions.
+func WithBuiltinFunctions(builtin func(name string) bool) CompileOption {
+	return func(opts *CompileOptions) {
+		opts.BuiltinFunction = builtin
+	}
+}
+
+// WithExtensions enables syntax extensions beyond standard JSONata.
+func WithExtensions(exts ...Extension) CompileOption {
+	return func(opts *CompileOptions) {
+		for _, ext := range exts {
+			opts.Extensions |= ext
+		}
+	}
+}
+
+// WithModuleResolver enables `$import("name")`: every import is resolved with
+// resolver and compiled at compile time, so that a missing module, an invalid
+// module or an import cycle is a compile error. The argument of $import must be
+// a string literal. See ModuleResolver and FSModules.
+func WithModuleResolver(resolver ModuleResolver) CompileOption {
+	return func(opts *CompileOptions) {
+		opts.Modules = resolver
+	}
+}
+
+// WithKnownFunctions makes calling an unknown function a compile error (S0410)
+// located at the call, instead of an evaluation error raised only when the
+// call is reached. known reports whether name (without the $) is a function
+// of the evaluator, such as evaluator.Evaluator.KnownFunction, which accounts
+// for custom functions, WithoutFunctions and preludes.
+//
+// Names bound by the expression itself (with :=, as lambda parameters, or
+// with @ and #) are never reported, wherever they are bound. Calls of
+// variables bound at evaluation time (EvalWithBindings) must be accepted by
+// known.
+func WithKnownFunctions(known func(name string) bool) CompileOption {
+	return func(opts *CompileOptions) {
+		opts.KnownFunction = known
+	}
+}
@@ -17,17 +17,34 @@ type Parser struct {
 	current Token
 	prev    Token
 	errors  []error
-	opts    CompileOptions
+	// warnings are the parse warnings of the expression (see warnings.go).
+	warnings []types.Warning
+	opts     CompileOptions
 	// arena is the bump-pointer allocator for ASTNode values.
 	// OPT-11: eliminates one individual heap allocation per AST node.
 	arena *types.NodeArena
+	// modules holds the modules imported during this compilation; nil until
+	// the first import is resolved (see resolveImports).
+	modules *moduleLoader
+	// size is the number of nodes allocated so far (see WithMaxSize).
+	size int
+	// depth and tokens are the bracket nesting and the number of tokens read
+	// so far, and limit the error of the first limit they exceed (see
+	// WithMaxDepth and WithMaxTokens).
+	depth, tokens int
+	limit         error
 }
 
+// defaultMaxDepth is the bracket nesting allowed without WithMaxDepth: far
+// deeper than any expression written by hand, it still stops adversarial
+// inputs before they cost the parser time and stack.
+const defaultMaxDepth = 1000
+
 // NewParser creates a new parser for the given input string.
 func NewParser(input string, opts ...CompileOption) *Parser {
 	options := CompileOptions{
 		EnableRecovery: false,
-		MaxDepth:       100,
+		MaxDepth:       defaultMaxDepth,
 	}
 	for _, opt := range opts {
 		opt(&options)
@@ -48,11 +65,26 @@ func NewParser(input string, opts ...CompileOption) *Parser {
 // newNode allocates an ASTNode from the parser's arena.
 // OPT-11: replaces types.NewASTNode to avoid per-node heap allocation.
 func (p *Parser) newNode(nodeType types.NodeType, position int) *types.ASTNode {
+	p.size++
 	return p.arena.Alloc(nodeType, position)
 }
 
 // Parse parses the entire expression and returns the root AST node.
+// Errors are *types.Error values located by line and column, with a source
+// snippet (see annotate).
 func (p *Parser) Parse() (*types.Expression, error) {
+	expr, err := p.parse()
+	if p.limit != nil {
+		// The parser failed on the token past the limit, in whatever way.
+		err = p.limit
+	}
+	if err != nil {
+		return nil, p.annotate(err)
+	}
+	return expr, nil
+}
+
+func (p *Parser) parse() (*types.Expression, error) {
 	// Check for lexer errors (e.g., unclosed comment)
 	if p.current.Type == TokenError {
 		return nil, p.lexer.Error()
@@ -66,12 +98,56 @@ func (p *Parser) Parse() (*types.Expression, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := p.checkSize(); err != nil {
+		return nil, err
+	}
 
 	if p.current.Type != TokenEOF {
-		return nil, p.error("S0201", fmt.Sprintf("Unexpected token: %s", p.current.Value))
+		return nil, p.errorExpected("S0201", "an operator", "end of expression")
 	}
 
-	return types.NewExpression(node, p.lexer.input, p.arena), nil
+	if p.opts.Modules != nil {
+		if p.modules == nil {
+			p.modules = &moduleLoader{resolve: p.opts.Modules, compiled: map[string]*types.ASTNode{}}
+		}
+		if err := p.resolveImports(node); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(p.opts.Constants) > 0 {
+		if node, err = bindConstants(node, p.opts.Constants); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.opts.Strict {
+		if err := validateStrict(node); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.opts.KnownFunction != nil {
+		if err := checkFunctions(node, p.opts.KnownFunction); err != nil {
+			return nil, err
+		}
+	}
+
+	p.collectWarnings(node)
+
+	if p.opts.Optimize {
+		node = eliminateCommon(node, p.opts.BuiltinFunction)
+	}
+
+	expr := types.NewExpression(node, p.lexer.input, p.arena)
+	for _, w := range p.warnings {
+		expr.AddWarning(w)
+	}
+	expr.SetMetadata(ParseMetadata(p.lexer.input))
+	if len(p.opts.Constants) > 0 {
+		expr.SetConstants(constantValues(p.opts.Constants))
+	}
+	return expr, nil
 }
 
 // Operator precedence table (binding power)
@@ -100,6 +176,7 @@ var precedence = map[TokenType]int{
 	TokenCondition:    15, // ?
 	TokenSort:         70, // ^ (sort operator)
 	TokenDot:          75, // .
+	TokenOptional:     75, // ?. (same as dot)
 	TokenDescendent:   75, // ** (descendant operator, same as dot)
 	TokenBracketOpen:  80, // [
 	TokenBraceOpen:    80, // {
@@ -119,15 +196,25 @@ func (p *Parser) getPrecedence(tt TokenType) int {
 // advance moves to the next token.
 func (p *Parser) advance() {
 	p.prev = p.current
+	if p.limit != nil {
+		return // the parser stops at the token past the limit
+	}
 	// For regex: only allow after operators that expect a value on the right
 	allowRegex := p.isRegexContext()
 	p.current = p.lexer.Next(allowRegex)
+	p.count()
 }
 
 // isRegexContext determines if we're in a context where a regex is expected.
 // Regexes appear after: =, !=, ~>, commas, opening brackets/parens, and at the start.
 func (p *Parser) isRegexContext() bool {
-	switch p.current.Type {
+	return regexAllowedAfter(p.current.Type)
+}
+
+// regexAllowedAfter reports whether a '/' following a token of type tt starts a
+// regex rather than a division.
+func regexAllowedAfter(tt TokenType) bool {
+	switch tt {
 	case TokenEqual, TokenNotEqual, TokenApply:
 		return true
 	case TokenComma, TokenParenOpen, TokenBracketOpen:
@@ -144,7 +231,17 @@ func (p *Parser) isRegexContext() bool {
 // expect checks if the current token matches the expected type and advances.
 func (p *Parser) expect(tt TokenType) error {
 	if p.current.Type != tt {
-		return p.error("S0202", fmt.Sprintf("Expected %s but got %s", tt.String(), p.current.Type.String()))
+		return p.errorExpected("S0202", tokenLabel(tt))
+	}
+	p.advance()
+	return nil
+}
+
+// expectSeparator consumes the separator between the items of a list; any other
+// token is reported as neither the separator nor the closing token of the list.
+func (p *Parser) expectSeparator(sep, closing TokenType) error {
+	if p.current.Type != sep {
+		return p.errorExpected("S0202", tokenLabel(sep), tokenLabel(closing))
 	}
 	p.advance()
 	return nil
@@ -162,9 +259,59 @@ func (p *Parser) error(code types.ErrorCode, message string) error {
 	return err
 }
 
+// count checks the token just read against the limits of WithMaxDepth and
+// WithMaxTokens. A token past a limit becomes an error token, so that the
+// parser fails on it without reading further: adversarial inputs such as
+// 100000 nested parentheses fail once the limit is reached, without the parser
+// descending into the rest of them. Parse then reports the limit.
+func (p *Parser) count() {
+	tok := p.current
+	switch tok.Type {
+	case TokenEOF, TokenError:
+		return
+	case TokenParenOpen, TokenBracketOpen, TokenBraceOpen:
+		p.depth++
+		if p.opts.MaxDepth > 0 && p.depth > p.opts.MaxDepth {
+			p.limit = p.limitError(types.ErrNestingTooDeep, tok,
+				fmt.Sprintf("The expression nests brackets more than %d deep", p.opts.MaxDepth))
+		}
+	case TokenParenClose, TokenBracketClose, TokenBraceClose:
+		p.depth--
+	}
+	p.tokens++
+	if p.limit == nil && p.opts.MaxTokens > 0 && p.tokens > p.opts.MaxTokens {
+		p.limit = p.limitError(types.ErrTooManyTokens, tok,
+			fmt.Sprintf("The expression has more than %d tokens", p.opts.MaxTokens))
+	}
+	if p.limit != nil {
+		p.current.Type = TokenError
+	}
+}
+
+// limitError records an error located at tok.
+func (p *Parser) limitError(code types.ErrorCode, tok Token, message string) error {
+	err := &types.Error{Code: code, Message: message, Position: tok.Position, Token: tok.Value}
+	p.errors = append(p.errors, err)
+	return err
+}
+
+// checkSize fails with S0701 once the expression has more nodes than
+// WithMaxSize allows.
+func (p *Parser) checkSize() error {
+	if p.opts.MaxSize > 0 && p.size > p.opts.MaxSize {
+		return p.error(types.ErrExpressionTooLarge,
+			fmt.Sprintf("The expression exceeds the size limit of %d nodes", p.opts.MaxSize))
+	}
+	return nil
+}
+
 // parseExpression parses an expression with operator precedence.
 // rbp is the right binding power (minimum precedence).
 func (p *Parser) parseExpression(rbp int) (*types.ASTNode, error) {
+	if err := p.checkSize(); err != nil {
+		return nil, err
+	}
+
 	// Parse prefix expression (nud - null denotation)
 	left, err := p.parsePrefix()
 	if err != nil {
@@ -233,7 +380,7 @@ func (p *Parser) parsePrefix() (*types.ASTNode, error) {
 		// Treat them as names when in prefix position
 		return p.parseNameFromKeyword()
 	default:
-		return nil, p.error("S0201", fmt.Sprintf("Unexpected token: %s", token.Type.String()))
+		return nil, p.errorExpected("S0201", "an expression")
 	}
 }
 
@@ -245,6 +392,8 @@ func (p *Parser) parseInfix(left *types.ASTNode) (*types.ASTNode, error) {
 	switch token.Type {
 	case TokenDot:
 		return p.parsePath(left)
+	case TokenOptional:
+		return p.parseOptionalPath(left)
 	case TokenDescendent:
 		return p.parseDescendent(left)
 	case TokenBracketOpen:
@@ -550,9 +699,10 @@ func (p *Parser) parseGrouping() (*types.ASTNode, error) {
 		p.advance() // Skip ';'
 	}
 
-	if err := p.expect(TokenParenClose); err != nil {
-		return nil, err
+	if p.current.Type != TokenParenClose {
+		return nil, p.errorExpected("S0202", tokenLabel(TokenSemicolon), tokenLabel(TokenParenClose))
 	}
+	p.advance()
 
 	// If there's only one expression and no semicolons, we still need to check
 	// if the expression requires a new scope (like variable assignment).
@@ -602,7 +752,7 @@ func (p *Parser) parseArrayConstructor() (*types.ASTNode, error) {
 			break
 		}
 
-		if err := p.expect(TokenComma); err != nil {
+		if err := p.expectSeparator(TokenComma, TokenBracketClose); err != nil {
 			return nil, err
 		}
 	}
@@ -624,42 +774,80 @@ func (p *Parser) parseObjectConstructor() (*types.ASTNode, error) {
 	}
 
 	for {
-		// Parse key expression
-		key, err := p.parseExpression(0)
+		entry, err := p.parseObjectEntry()
 		if err != nil {
 			return nil, err
 		}
+		node.Expressions = append(node.Expressions, entry)
 
-		if err := p.expect(TokenColon); err != nil {
-			return nil, err
+		if p.current.Type == TokenBraceClose {
+			p.advance()
+			break
 		}
 
-		// Parse value expression
-		value, err := p.parseExpression(0)
-		if err != nil {
+		if err := p.expectSeparator(TokenComma, TokenBraceClose); err != nil {
 			return nil, err
 		}
+	}
 
-		// Create a pair node (we use a binary node with special marker)
-		pair := p.newNode(types.NodeBinary, key.Position)
-		pair.Value = ":"
-		pair.StrValue = ":"
-		pair.LHS = key
-		pair.RHS = value
-
-		node.Expressions = append(node.Expressions, pair)
+	return node, nil
+}
 
-		if p.current.Type == TokenBraceClose {
-			p.advance()
-			break
+// parseObjectEntry parses a key: value pair of an object constructor, or a
+// ...expr spread entry when ExtObjectSpread is enabled.
+func (p *Parser) parseObjectEntry() (*types.ASTNode, error) {
+	if p.current.Type == TokenRange && p.opts.Extensions.Has(ExtObjectSpread) {
+		pos := p.current.Position
+		p.advance() // Skip '..'
+		if p.current.Type != TokenDot || p.current.Position != pos+2 {
+			return nil, p.errorExpected("S0202", `"..."`)
 		}
+		p.advance() // Skip '.'
+		operand, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		spread := p.newNode(types.NodeSpread, pos)
+		spread.LHS = operand
+		return spread, nil
+	}
 
-		if err := p.expect(TokenComma); err != nil {
+	// Parse key expression
+	var key *types.ASTNode
+	var err error
+	if p.current.Type == TokenBracketOpen && p.opts.Extensions.Has(ExtComputedKeys) {
+		pos := p.current.Position
+		p.advance() // Skip '['
+		operand, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(TokenBracketClose); err != nil {
 			return nil, err
 		}
+		key = p.newNode(types.NodeComputedKey, pos)
+		key.LHS = operand
+	} else if key, err = p.parseExpression(0); err != nil {
+		return nil, err
 	}
 
-	return node, nil
+	if err := p.expect(TokenColon); err != nil {
+		return nil, err
+	}
+
+	// Parse value expression
+	value, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a pair node (we use a binary node with special marker)
+	pair := p.newNode(types.NodeBinary, key.Position)
+	pair.Value = ":"
+	pair.StrValue = ":"
+	pair.LHS = key
+	pair.RHS = value
+	return pair, nil
 }
 
 // parseObjectConstructorWithLeft parses an object constructor with a left expression.
@@ -669,6 +857,11 @@ func (p *Parser) parseObjectConstructorWithLeft(left *types.ASTNode) (*types.AST
 	if err != nil {
 		return nil, err
 	}
+	for _, entry := range node.Expressions {
+		if entry.Type == types.NodeSpread {
+			return nil, types.NewError("S0201", "A spread entry cannot be used in a grouping expression", entry.Position)
+		}
+	}
 	node.LHS = left
 	node.IsGrouping = true // Mark as infix grouping
 	return node, nil
@@ -703,6 +896,30 @@ func (p *Parser) parsePath(left *types.ASTNode) (*types.ASTNode, error) {
 	return node, nil
 }
 
+// parseOptionalPath parses a path step written with ?. (ExtOptionalChaining):
+// a path like any other, whose step is marked Optional. The mark goes on the
+// node naming the field, under the predicates and bindings of the step
+// (a?.b[0] marks b). Without the
+// extension, ?. is rejected as standard JSONata rejects it: as a '.' where an
+// expression is expected.
+func (p *Parser) parseOptionalPath(left *types.ASTNode) (*types.ASTNode, error) {
+	if !p.opts.Extensions.Has(ExtOptionalChaining) {
+		p.current = Token{Type: TokenDot, Value: ".", Position: p.current.Position + 1}
+		return nil, p.errorExpected("S0201", "an expression")
+	}
+	node, err := p.parsePath(left)
+	if err != nil {
+		return nil, err
+	}
+	step := node.RHS
+	for step.LHS != nil && (step.Type == types.NodeFilter || step.Type == types.NodeContext ||
+		step.Type == types.NodeIndex || step.Type == types.NodeObject && step.IsGrouping) {
+		step = step.LHS
+	}
+	step.Optional = true
+	return node, nil
+}
+
 // parseContextBind parses the context variable binding operator (@$var).
 // Syntax: expr@$var — binds each item of expr to $var while keeping the parent context.
 // Errors:
@@ -942,7 +1159,7 @@ func (p *Parser) parseFunctionCall(nameNode *types.ASTNode) (*types.ASTNode, err
 				break
 			}
 
-			if err := p.expect(TokenComma); err != nil {
+			if err := p.expectSeparator(TokenComma, TokenParenClose); err != nil {
 				return nil, err
 			}
 		}
@@ -963,36 +1180,51 @@ func (p *Parser) parseFunctionCall(nameNode *types.ASTNode) (*types.ASTNode, err
 // parseConditional parses a conditional (ternary) expression.
 // Syntax: condition ? then_expr : else_expr
 // The else part is optional: condition ? then_expr
+//
+// The else branch of a chain a ? b : c ? d : e is itself a conditional.
+// Chains are parsed in a loop rather than recursively, so that the long ones
+// of generated expressions do not nest calls.
 func (p *Parser) parseConditional(condition *types.ASTNode) (*types.ASTNode, error) {
-	pos := p.current.Position
-	p.advance() // Skip '?'
+	var root, last *types.ASTNode
+	for {
+		pos := p.current.Position
+		p.advance() // Skip '?'
 
-	// Parse 'then' expression
-	thenExpr, err := p.parseExpression(0)
-	if err != nil {
-		return nil, err
-	}
+		// Parse 'then' expression
+		thenExpr, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
 
-	node := p.newNode(types.NodeCondition, pos)
-	node.LHS = condition // Condition
-	node.RHS = thenExpr  // Then branch
+		node := p.newNode(types.NodeCondition, pos)
+		node.LHS = condition // Condition
+		node.RHS = thenExpr  // Then branch
+		if root == nil {
+			root = node
+		} else {
+			last.Expressions = []*types.ASTNode{node}
+		}
+		last = node
 
-	// Check if there's an else part (optional)
-	if p.current.Type == TokenColon {
+		// Check if there's an else part (optional); without it the
+		// conditional is undefined when the condition does not hold.
+		if p.current.Type != TokenColon {
+			return root, nil
+		}
 		p.advance() // Skip ':'
 
-		// Parse 'else' expression (right-associative, so use precedence - 1)
-		elseExpr, err := p.parseExpression(precedence[TokenCondition] - 1)
+		// Parse the operand of the 'else' expression: if a '?' follows, it is
+		// the condition of a nested conditional (right-associative).
+		elseExpr, err := p.parseExpression(precedence[TokenCondition])
 		if err != nil {
 			return nil, err
 		}
-		node.Expressions = []*types.ASTNode{elseExpr} // Else branch
-	} else {
-		// No else part: equivalent to undefined
-		node.Expressions = nil
+		if p.current.Type != TokenCondition {
+			last.Expressions = []*types.ASTNode{elseExpr} // Else branch
+			return root, nil
+		}
+		condition = elseExpr
 	}
-
-	return node, nil
 }
 
 // parseLambda parses a lambda function expression.
@@ -1068,7 +1300,12 @@ func (p *Parser) parseLambda() (*types.ASTNode, error) {
 
 		sigStart += ">"
 		signatureStr = sigStart
+		sig, err := types.ParseSignature(signatureStr)
+		if err != nil {
+			return nil, p.error("S0401", strings.TrimPrefix(err.Error(), "S0401: "))
+		}
 		node.Signature = signatureStr
+		node.ParsedSignature = sig
 		p.advance() // Skip '>'
 	}
 
@@ -1083,6 +1320,7 @@ func (p *Parser) parseLambda() (*types.ASTNode, error) {
 		return nil, err
 	}
 	node.RHS = body
+	node.Closure = types.AnalyzeClosure(body)
 
 	// Expect '}'
 	if err := p.expect(TokenBraceClose); err != nil {
@@ -1165,7 +1403,7 @@ func (p *Parser) parseSort(left *types.ASTNode) (*types.ASTNode, error) {
 
 		// Expect comma separator between sort keys
 		if p.current.Type != TokenComma {
-			return nil, p.error("S0201", "Expected ')' in sort expression")
+			return nil, p.errorExpected("S0201", tokenLabel(TokenComma), tokenLabel(TokenParenClose))
 		}
 		p.advance() // Skip ','
 	}
@@ -1192,7 +1430,7 @@ func (p *Parser) parseSort(left *types.ASTNode) (*types.ASTNode, error) {
 // Right-associative: $a := $b := 5 is ($a := ($b := 5))
 func (p *Parser) parseAssignment(left *types.ASTNode) (*types.ASTNode, error) {
 	if left.Type != types.NodeVariable {
-		return nil, p.error("S0201", "Left-hand side of assignment must be a variable")
+		return nil, p.error(types.ErrInvalidAssignTarget, "The left side of := must be a variable name (start with $)")
 	}
 
 	pos := p.current.Position
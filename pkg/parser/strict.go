@@ -0,0 +1,248 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Strict validation.
+//
+// With WithStrict, Compile checks the AST for structurally invalid expressions
+// that the parser accepts but the reference implementation rejects statically:
+//
+//   - S0213: a number, true, false or null used as a path step (a.true, 1.a).
+//   - S0217: a parent operator (%) whose parent cannot be derived from the
+//     enclosing path: at the top level, after too few steps, or after a step
+//     that does not navigate the input ($x.%, {"a": 1}.%, a.**.%).
+//   - S0212: an assignment to the context value ($ := ...), which binds nothing.
+//
+// Like the reference, it does not report % references that reach beyond a
+// lambda body, a grouping value or a transform: their parent is only known at
+// evaluation time.
+
+// parentRef is a % reference still looking for its parent: level is the number
+// of navigation steps left to walk back.
+type parentRef struct {
+	node  *types.ASTNode
+	level int
+}
+
+// validateStrict runs the strict checks on the AST of a parsed expression.
+func validateStrict(root *types.ASTNode) error {
+	refs, err := strictVisit(root)
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 {
+		return parentError(refs[0].node)
+	}
+	return nil
+}
+
+// strictVisit checks node and returns the % references it leaves unresolved.
+func strictVisit(node *types.ASTNode) ([]parentRef, error) {
+	if node == nil {
+		return nil, nil
+	}
+	switch node.Type {
+	case types.NodeParent:
+		return []parentRef{{node: node, level: 1}}, nil
+
+	case types.NodePath, types.NodeDescendant:
+		return strictPath(flattenPath(node, nil))
+
+	case types.NodeFilter, types.NodeSort:
+		refs, err := strictVisit(node.LHS)
+		if err != nil {
+			return nil, err
+		}
+		// In predicates and sort terms, % one level up is the parent of the
+		// items of the step being filtered or sorted.
+		terms := append([]*types.ASTNode{node.RHS}, node.Expressions...)
+		for _, term := range terms {
+			termRefs, err := strictVisit(term)
+			if err != nil {
+				return nil, err
+			}
+			for _, ref := range termRefs {
+				if ref.level == 1 {
+					if ref.level, err = seekParent(node.LHS, ref.level); err != nil {
+						return nil, err
+					}
+				} else {
+					ref.level--
+				}
+				if ref.level > 0 {
+					refs = append(refs, ref)
+				}
+			}
+		}
+		return refs, nil
+
+	case types.NodeObject:
+		if node.LHS != nil {
+			// Grouping values are checked but their % references resolve
+			// against the groups at evaluation time.
+			if err := strictDiscard(node.Expressions...); err != nil {
+				return nil, err
+			}
+			return strictVisit(node.LHS)
+		}
+
+	case types.NodeLambda, types.NodeTransform:
+		return nil, strictDiscard(children(node)...)
+
+	case types.NodeImport:
+		return nil, nil // modules are validated when compiled
+
+	case types.NodeBind:
+		if node.LHS != nil && node.LHS.Type == types.NodeVariable && node.LHS.StrValue == "" {
+			return nil, types.NewError(types.ErrInvalidAssignTarget,
+				"The context value $ cannot be assigned; the left side of := must be a variable name", node.LHS.Position)
+		}
+	}
+
+	var refs []parentRef
+	for _, child := range children(node) {
+		childRefs, err := strictVisit(child)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, childRefs...)
+	}
+	return refs, nil
+}
+
+// strictDiscard checks nodes, ignoring their unresolved % references.
+func strictDiscard(nodes ...*types.ASTNode) error {
+	for _, node := range nodes {
+		if _, err := strictVisit(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// strictPath checks the steps of a path and resolves the % references of each
+// step against the steps before it.
+func strictPath(steps []*types.ASTNode) ([]parentRef, error) {
+	var unresolved []parentRef
+	for i, step := range steps {
+		if core := stepCore(step); core.Type == types.NodeNumber || core.Type == types.NodeBoolean {
+			return nil, types.NewError(types.ErrInvalidPathStep,
+				fmt.Sprintf("The literal value %v cannot be used as a step within a path expression", literalText(core)), core.Position)
+		}
+		if step.Type == types.NodeDescendant {
+			continue // the ** marker added by flattenPath
+		}
+		refs, err := strictVisit(step)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			j := i - 1
+			for ref.level > 0 {
+				if j < 0 {
+					unresolved = append(unresolved, ref)
+					break
+				}
+				prev := steps[j]
+				j--
+				// Contiguous steps binding the focus (@$var) count as one.
+				for j >= 0 && prev.Type == types.NodeContext && steps[j].Type == types.NodeContext {
+					prev = steps[j]
+					j--
+				}
+				if ref.level, err = seekParent(prev, ref.level); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return unresolved, nil
+}
+
+// seekParent walks a % reference back over step and returns the levels still
+// to walk. Steps that do not navigate the input cannot provide a parent.
+func seekParent(step *types.ASTNode, level int) (int, error) {
+	core := stepCore(step)
+	switch core.Type {
+	case types.NodeName, types.NodeWildcard, types.NodeString:
+		return level - 1, nil
+	case types.NodeParent:
+		return level + 1, nil
+	case types.NodeBlock:
+		if len(core.Expressions) == 0 {
+			return level, nil
+		}
+		return seekParent(core.Expressions[len(core.Expressions)-1], level)
+	case types.NodePath:
+		steps := flattenPath(core, nil)
+		var err error
+		for i := len(steps) - 1; i >= 0 && level > 0; i-- {
+			if level, err = seekParent(steps[i], level); err != nil {
+				return 0, err
+			}
+		}
+		return level, nil
+	}
+	return 0, parentError(core)
+}
+
+// flattenPath appends the steps of a path to steps. A descendant operator
+// (a.**.b) contributes itself as a step between its operands.
+func flattenPath(node *types.ASTNode, steps []*types.ASTNode) []*types.ASTNode {
+	switch {
+	case node == nil:
+		return steps
+	case node.Type == types.NodePath:
+		steps = flattenPath(node.LHS, steps)
+		return flattenPath(node.RHS, steps)
+	case node.Type == types.NodeDescendant:
+		steps = flattenPath(node.LHS, steps)
+		steps = append(steps, &types.ASTNode{Type: types.NodeDescendant, Position: node.Position})
+		return flattenPath(node.RHS, steps)
+	}
+	return append(steps, node)
+}
+
+// stepCore returns the navigation step beneath the predicates, sort terms,
+// variable bindings and grouping attached to it.
+func stepCore(step *types.ASTNode) *types.ASTNode {
+	for step.LHS != nil {
+		switch {
+		case step.Type == types.NodeFilter, step.Type == types.NodeSort,
+			step.Type == types.NodeContext, step.Type == types.NodeIndex,
+			step.Type == types.NodeObject && step.IsGrouping:
+			step = step.LHS
+		default:
+			return step
+		}
+	}
+	return step
+}
+
+func children(node *types.ASTNode) []*types.ASTNode {
+	var nodes []*types.ASTNode
+	for _, n := range []*types.ASTNode{node.LHS, node.RHS} {
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+	nodes = append(nodes, node.Steps...)
+	nodes = append(nodes, node.Arguments...)
+	return append(nodes, node.Expressions...)
+}
+
+func literalText(node *types.ASTNode) string {
+	if _, null := node.Value.(types.Null); null || node.Value == nil {
+		return "null"
+	}
+	return fmt.Sprint(node.Value)
+}
+
+func parentError(node *types.ASTNode) error {
+	return types.NewError(types.ErrInvalidParentUse,
+		"The object representing the 'parent' cannot be derived from this expression", node.Position)
+}
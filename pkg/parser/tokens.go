@@ -60,6 +60,7 @@ const (
 	TokenDescendent // **
 	TokenCoalesce   // ??
 	TokenDefault    // ?:
+	TokenOptional   // ?. (optional navigation, ExtOptionalChaining)
 	TokenAt         // @  (context variable binding)
 	TokenHash       // #  (positional variable binding)
 
@@ -152,6 +153,8 @@ func (tt TokenType) String() string {
 		return "??"
 	case TokenDefault:
 		return "?:"
+	case TokenOptional:
+		return "?."
 	case TokenAt:
 		return "@"
 	case TokenHash:
@@ -218,7 +221,7 @@ var symbols2 = [...][]runeTokenType{
 	'~': {{'>', TokenApply}},
 	':': {{'=', TokenAssign}},
 	'*': {{'*', TokenDescendent}},
-	'?': {{':', TokenDefault}, {'?', TokenCoalesce}},
+	'?': {{':', TokenDefault}, {'?', TokenCoalesce}, {'.', TokenOptional}},
 }
 
 const (
@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Parse warnings.
+//
+// The parser accepts some constructs whose behavior is legacy or differs from
+// the reference implementation, and that may change. It reports them as
+// warnings on the compiled expression (see types.Expression.Warnings) rather
+// than rejecting them, so that expression authors can migrate ahead of time.
+const (
+	// WarnDefaultOperator reports the ?: operator, which falls back on any
+	// falsy left-hand value, and on a left-hand error, where ?? falls back
+	// only on undefined.
+	WarnDefaultOperator = "W1001"
+	// WarnApplyPredicate reports a predicate on the function call at the
+	// right of ~>, as in `x ~> $f()[0]`: it is applied to the result of the
+	// call, which the reference implementation does not do.
+	WarnApplyPredicate = "W1002"
+)
+
+// collectWarnings appends to p.warnings a warning for each legacy construct in
+// the tree of root, in source order. Imported modules are not visited: their
+// positions are not in this source. The tree is walked with an explicit stack,
+// as generated expressions may nest hundreds of thousands of nodes deep.
+func (p *Parser) collectWarnings(root *types.ASTNode) {
+	stack := []*types.ASTNode{root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if node == nil || node.Type == types.NodeImport {
+			continue
+		}
+		if node.Type == types.NodeBinary {
+			switch node.StrValue {
+			case "?:":
+				p.warn(WarnDefaultOperator, node.Position,
+					"the ?: operator falls back on any falsy value (false, 0, \"\", empty array) and on an error; use ?? to fall back only on undefined")
+			case "~>":
+				if rhs := node.RHS; rhs != nil && rhs.Type == types.NodeFilter && rhs.RHS != nil &&
+					rhs.LHS != nil && rhs.LHS.Type == types.NodeFunction {
+					p.warn(WarnApplyPredicate, rhs.Position,
+						"a predicate on the function call at the right of ~> is applied to its result, unlike in the reference implementation; parenthesize the chain, as in (x ~> $f())[0]")
+				}
+			}
+		}
+		// Push the children in reverse, to pop them in order.
+		for _, list := range [][]*types.ASTNode{node.Expressions, node.Arguments, node.Steps} {
+			for i := len(list) - 1; i >= 0; i-- {
+				stack = append(stack, list[i])
+			}
+		}
+		stack = append(stack, node.RHS, node.LHS)
+	}
+}
+
+// warn records a warning located at the byte offset pos of the source.
+func (p *Parser) warn(code string, pos int, message string) {
+	w := types.Warning{Code: code, Message: message, Position: pos}
+	w.Line, w.Column = lineColumn(p.lexer.input, min(max(pos, 0), len(p.lexer.input)))
+	p.warnings = append(p.warnings, w)
+}
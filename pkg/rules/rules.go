@@ -0,0 +1,245 @@
+// Package rules evaluates bundles of named boolean expressions against a
+// document, the building block of routing, alerting and eligibility rules:
+//
+//	preds, err := rules.Compile([]rules.Predicate{
+//	    {Name: "gold", Expr: `customer.tier = "gold"`},
+//	    {Name: "domestic", Expr: `customer.country = "IT"`},
+//	    {Name: "large", Expr: `$sum(lines.(price * qty)) > 1000`},
+//	}, []rules.Group{
+//	    {Name: "priority", Mode: rules.Any, Members: []string{"gold", "large"}},
+//	})
+//	...
+//	values, err := preds.Eval(ctx, evaluator.New(), order)
+//	// values["domestic"], values["priority"], ...
+//
+// The predicates are compiled together (see parser.CompileMany): the
+// sub-expressions they have in common are evaluated once per document, calls
+// of built-ins included when parser.WithBuiltinFunctions is among the options
+// of Compile.
+// Groups combine predicates with all (and) or any (or), and stop evaluating
+// their members as soon as their outcome is known.
+package rules
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Predicate is a named boolean expression. It holds when its expression
+// evaluates to true; undefined counts as false, and any other value is an
+// error.
+type Predicate struct {
+	Name string
+	Expr string
+}
+
+// Mode is the way a Group combines its members.
+type Mode int
+
+const (
+	// All holds when every member holds. Members stop being evaluated at the
+	// first one that does not.
+	All Mode = iota
+	// Any holds when a member holds. Members stop being evaluated at the first
+	// one that does.
+	Any
+)
+
+// Group is a named combination of predicates. Members are predicate names; a
+// predicate may belong to several groups.
+type Group struct {
+	Name    string
+	Mode    Mode
+	Members []string
+}
+
+// Error is the error of a predicate that could not be evaluated.
+type Error struct {
+	Predicate string
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rules: predicate %q: %v", e.Predicate, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Predicates is a compiled bundle of predicates and groups. It is safe for
+// concurrent use.
+type Predicates struct {
+	predicates []Predicate
+	groups     []Group
+	set        *types.ExpressionSet
+	// memberOf lists the groups (indexes in groups) of each predicate.
+	memberOf [][]int
+	// members is the number of members of each group.
+	members []int
+}
+
+// Compile compiles predicates and the groups combining them. Names must be
+// unique across predicates and groups, and group members must name
+// predicates. A syntax error is reported with the name of its predicate; opts
+// apply to every compilation.
+func Compile(predicates []Predicate, groups []Group, opts ...parser.CompileOption) (*Predicates, error) {
+	index := make(map[string]int, len(predicates))
+	queries := make([]string, len(predicates))
+	for i, pred := range predicates {
+		if pred.Name == "" {
+			return nil, fmt.Errorf("rules: predicate %d has no name", i)
+		}
+		if _, dup := index[pred.Name]; dup {
+			return nil, fmt.Errorf("rules: duplicate name %q", pred.Name)
+		}
+		index[pred.Name] = i
+		queries[i] = pred.Expr
+	}
+
+	p := &Predicates{
+		predicates: predicates,
+		groups:     groups,
+		memberOf:   make([][]int, len(predicates)),
+		members:    make([]int, len(groups)),
+	}
+	groupNames := make(map[string]bool, len(groups))
+	for g, group := range groups {
+		if group.Name == "" {
+			return nil, fmt.Errorf("rules: group %d has no name", g)
+		}
+		if _, dup := index[group.Name]; dup || groupNames[group.Name] {
+			return nil, fmt.Errorf("rules: duplicate name %q", group.Name)
+		}
+		groupNames[group.Name] = true
+		if group.Mode != All && group.Mode != Any {
+			return nil, fmt.Errorf("rules: group %q: invalid mode %d", group.Name, group.Mode)
+		}
+		seen := make(map[string]bool, len(group.Members))
+		for _, name := range group.Members {
+			i, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("rules: group %q: unknown predicate %q", group.Name, name)
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			p.memberOf[i] = append(p.memberOf[i], g)
+			p.members[g]++
+		}
+	}
+
+	p.set = parser.CompileMany(queries, opts...)
+	for i, err := range p.set.Errors {
+		if err != nil {
+			return nil, fmt.Errorf("rules: predicate %q: %w", predicates[i].Name, err)
+		}
+	}
+	return p, nil
+}
+
+// groupState is the outcome of a group during an evaluation.
+type groupState struct {
+	decided bool
+	failed  bool
+	value   bool
+	pending int
+}
+
+// Eval evaluates the predicates against data with ev and returns the value of
+// every predicate evaluated and of every group, by name.
+//
+// Predicates are evaluated in order. A predicate that belongs only to groups
+// whose outcome is already known is skipped, and left out of the result. A
+// predicate that fails is left out of the result too, as is a group whose
+// outcome depended on it; the others are still evaluated, and the error
+// returned is the *Error of the first predicate that failed.
+func (p *Predicates) Eval(ctx context.Context, ev *evaluator.Evaluator, data interface{}) (map[string]bool, error) {
+	values := make(map[string]bool, len(p.predicates)+len(p.groups))
+	states := make([]groupState, len(p.groups))
+	for g, group := range p.groups {
+		states[g].pending = p.members[g]
+		if p.members[g] == 0 {
+			states[g] = groupState{decided: true, value: group.Mode == All}
+		}
+	}
+	evaluated := make([]bool, len(p.predicates))
+	var first error
+
+	// record accounts for the result of predicate i in values and in the
+	// states of its groups.
+	record := func(i int, r evaluator.QueryResult) {
+		if !evaluated[i] {
+			return
+		}
+		value, err := r.Value, r.Err
+		holds := false
+		if err == nil {
+			switch v := value.(type) {
+			case nil:
+			case bool:
+				holds = v
+			default:
+				err = fmt.Errorf("result is not a boolean: %T", value)
+			}
+		}
+		if err != nil {
+			if first == nil {
+				first = &Error{Predicate: p.predicates[i].Name, Err: err}
+			}
+		} else {
+			values[p.predicates[i].Name] = holds
+		}
+		for _, g := range p.memberOf[i] {
+			s := &states[g]
+			switch {
+			case s.decided:
+			case err != nil:
+				s.decided, s.failed = true, true
+			case holds == (p.groups[g].Mode == Any):
+				// The first member that does not hold decides an all group,
+				// the first that holds an any group.
+				s.decided, s.value = true, holds
+			default:
+				s.pending--
+				if s.pending == 0 {
+					s.decided, s.value = true, p.groups[g].Mode == All
+				}
+			}
+		}
+	}
+
+	done := 0
+	results, err := ev.EvalManyWhere(ctx, p.set, data, func(i int, results []evaluator.QueryResult) bool {
+		for ; done < i; done++ {
+			record(done, results[done])
+		}
+		if len(p.memberOf[i]) == 0 {
+			evaluated[i] = true
+			return true
+		}
+		for _, g := range p.memberOf[i] {
+			if !states[g].decided {
+				evaluated[i] = true
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	for ; done < len(results); done++ {
+		record(done, results[done])
+	}
+
+	for g, group := range p.groups {
+		if s := states[g]; s.decided && !s.failed {
+			values[group.Name] = s.value
+		}
+	}
+	return values, first
+}
@@ -0,0 +1,331 @@
+// Package scope rewrites expressions so that they only see the items of a
+// collection of the input that match a predicate, as multi-tenant services
+// need to confine user-supplied expressions to the data of one tenant:
+//
+//	scoped, err := scope.Collection(expr, "Orders", `tenantId = $tenant`, ev)
+//	...
+//	result, err := ev.EvalWithBindings(ctx, scoped, data, map[string]interface{}{"tenant": id})
+//
+// Every read of the collection from the input gets the predicate as a filter:
+//
+//	Orders.total                 Orders[tenantId = $tenant].total
+//	$sum($$.Orders.total)        $sum($$.Orders[tenantId = $tenant].total)
+//	Customers.$$.Orders          Customers.$$.Orders[tenantId = $tenant]
+//	$$@$r.$r.Orders              $$@$r.$r.Orders[tenantId = $tenant]
+//
+// while fields of the same name deeper in the input (Customers.Orders) are
+// left alone. Expressions that could reach the collection some other way are
+// rejected with an *Error instead of being rewritten: the input used as a
+// value rather than navigated ($string($), $lookup($$, "Orders"), a bare %),
+// wildcards and descendants of the input, calls that may receive the input as
+// an implicit argument ($string()), transforms of the input and $eval.
+//
+// The predicate is trusted and is not rewritten. Custom functions registered
+// on the evaluator are assumed not to read the input on their own.
+package scope
+
+import (
+	"fmt"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Error reports a construct of an expression that cannot be scoped.
+type Error struct {
+	Position int // byte offset in the source of the expression
+	Reason   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("scope: position %d: %s", e.Position, e.Reason)
+}
+
+// Collection returns expr with the predicate applied to every read of the
+// collection name from the input, or an *Error if expr could read the
+// collection without it. expr is not modified.
+//
+// ev is the evaluator the expression is meant for: its function registry
+// tells the calls that may receive the input as an implicit argument. nil
+// stands for evaluator.New().
+func Collection(expr *types.Expression, name, predicate string, ev *evaluator.Evaluator) (*types.Expression, error) {
+	pred, err := parser.Compile(predicate)
+	if err != nil {
+		return nil, fmt.Errorf("scope: predicate: %w", err)
+	}
+	if ev == nil {
+		ev = evaluator.New()
+	}
+	s := &scoper{
+		name:   name,
+		ev:     ev,
+		bound:  boundNames(expr.AST()),
+		inputs: make(map[string]bool),
+	}
+	// A variable may be read before the check reaches its binding to the
+	// input with @, so the check is repeated until no new one is found.
+	for found := -1; found != len(s.inputs); {
+		found = len(s.inputs)
+		s.targets = make(map[*types.ASTNode]bool)
+		if err := s.value(expr.AST(), input); err != nil {
+			return nil, err
+		}
+	}
+	return expr.Rewrite(func(n *types.ASTNode) *types.ASTNode {
+		if !s.targets[n] {
+			return n
+		}
+		filter := types.NewASTNode(types.NodeFilter, n.Position)
+		filter.LHS = n
+		filter.RHS = pred.AST()
+		return filter
+	}), nil
+}
+
+// kind is what the value of a node, or the context of its evaluation, may be.
+type kind int
+
+const (
+	item  kind = iota // anything but the input
+	input             // the input document, possibly
+)
+
+type scoper struct {
+	name string
+	ev   *evaluator.Evaluator
+	// bound are the variables bound by the expression; true for those bound
+	// to lambdas only.
+	bound map[string]bool
+	// inputs are the variables bound with @ to what may be the input: they
+	// are navigated as the input wherever they are read.
+	inputs map[string]bool
+	// targets are the reads of the collection from the input.
+	targets map[*types.ASTNode]bool
+}
+
+// boundNames returns the variables bound in the tree of root, by := or as
+// parameters, @ or # variables; true for those only ever bound to lambdas.
+func boundNames(root *types.ASTNode) map[string]bool {
+	bound := make(map[string]bool)
+	set := func(v *types.ASTNode, lambda bool) {
+		if v == nil || v.Type != types.NodeVariable {
+			return
+		}
+		prev, seen := bound[v.StrValue]
+		bound[v.StrValue] = lambda && (prev || !seen)
+	}
+	types.Walk(root, func(n *types.ASTNode) bool {
+		switch n.Type {
+		case types.NodeBind:
+			set(n.LHS, n.RHS != nil && n.RHS.Type == types.NodeLambda)
+		case types.NodeContext, types.NodeIndex:
+			set(n.RHS, false)
+		case types.NodeLambda:
+			for _, param := range n.Arguments {
+				set(param, false)
+			}
+		}
+		return true
+	})
+	return bound
+}
+
+func (s *scoper) fail(n *types.ASTNode, format string, args ...interface{}) error {
+	return &Error{Position: n.Position, Reason: fmt.Sprintf(format, args...)}
+}
+
+// value checks n, whose value is used, evaluated against a context of kind
+// ctx.
+func (s *scoper) value(n *types.ASTNode, ctx kind) error {
+	k, err := s.check(n, ctx)
+	if err == nil && k == input {
+		err = s.fail(n, "the input cannot be used as a value")
+	}
+	return err
+}
+
+// check checks n, evaluated against a context of kind ctx, and returns the
+// kind of its value. Only navigation (paths, filters, sorts, @ and #) passes
+// the input on; everything else uses the values of its operands.
+func (s *scoper) check(n *types.ASTNode, ctx kind) (kind, error) {
+	if n == nil {
+		return item, nil
+	}
+	switch n.Type {
+	case types.NodeString, types.NodeNumber, types.NodeBoolean, types.NodeRegex, types.NodePlaceholder:
+		return item, nil
+
+	case types.NodeVariable:
+		switch n.StrValue {
+		case "":
+			return ctx, nil
+		case "$":
+			return input, nil
+		}
+		if s.inputs[n.StrValue] {
+			return input, nil
+		}
+		return item, s.checkEval(n, n.StrValue)
+
+	case types.NodeParent:
+		// The parent of a step may be the input at any depth.
+		return input, nil
+
+	case types.NodeName:
+		if ctx == input && n.StrValue == s.name {
+			s.targets[n] = true
+		}
+		return item, nil
+
+	case types.NodeWildcard:
+		if ctx == input {
+			return item, s.fail(n, "a wildcard over the input cannot be scoped")
+		}
+		return item, nil
+
+	case types.NodeDescendant:
+		k, err := s.check(n.LHS, ctx)
+		if err != nil {
+			return item, err
+		}
+		if k == input {
+			return item, s.fail(n, "the descendants of the input cannot be scoped")
+		}
+		return s.check(n.RHS, item)
+
+	case types.NodePath:
+		k, err := s.check(n.LHS, ctx)
+		if err != nil {
+			return item, err
+		}
+		return s.check(n.RHS, k)
+
+	case types.NodeFilter, types.NodeSort:
+		k, err := s.check(n.LHS, ctx)
+		if err != nil {
+			return item, err
+		}
+		return k, s.values(k, append([]*types.ASTNode{n.RHS}, n.Expressions...)...)
+
+	case types.NodeContext:
+		// After @ the steps go on from the context of the bound step, and
+		// the variable holds the value of the step.
+		k, err := s.check(n.LHS, ctx)
+		if k == input && n.RHS != nil && n.RHS.Type == types.NodeVariable {
+			s.inputs[n.RHS.StrValue] = true
+		}
+		return max(k, ctx), err
+
+	case types.NodeIndex:
+		return s.check(n.LHS, ctx)
+
+	case types.NodeFunction, types.NodePartial:
+		return item, s.call(n, ctx, false)
+
+	case types.NodeBinary:
+		if n.StrValue != "~>" {
+			return item, s.values(ctx, n.LHS, n.RHS)
+		}
+		if err := s.value(n.LHS, ctx); err != nil {
+			return item, err
+		}
+		switch n.RHS.Type {
+		case types.NodeFunction, types.NodePartial:
+			// The left operand is the first argument.
+			return item, s.call(n.RHS, ctx, true)
+		case types.NodeTransform:
+			return item, s.transform(n.RHS)
+		}
+		return item, s.value(n.RHS, ctx)
+
+	case types.NodeTransform:
+		// A transform that is not applied with ~> copies its context.
+		if ctx == input {
+			return item, s.fail(n, "a transform of the input cannot be scoped")
+		}
+		return item, s.transform(n)
+
+	case types.NodeObject:
+		if n.IsGrouping {
+			k, err := s.check(n.LHS, ctx)
+			if err != nil {
+				return item, err
+			}
+			return item, s.values(k, n.Expressions...)
+		}
+		return item, s.values(ctx, n.Expressions...)
+
+	case types.NodeLambda:
+		// The body is evaluated against the context the lambda is created in.
+		return item, s.value(n.RHS, ctx)
+
+	case types.NodeBind:
+		return item, s.value(n.RHS, ctx)
+
+	case types.NodeImport:
+		return item, s.value(n.LHS, item)
+	}
+	return item, s.values(ctx, n.Children()...)
+}
+
+// values checks nodes whose values are used.
+func (s *scoper) values(ctx kind, nodes ...*types.ASTNode) error {
+	for _, n := range nodes {
+		if err := s.value(n, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call checks a function call. applied is set for the right operand of ~>,
+// which gets the left one as its first argument.
+func (s *scoper) call(n *types.ASTNode, ctx kind, applied bool) error {
+	if err := s.values(ctx, n.Arguments...); err != nil {
+		return err
+	}
+	if n.LHS == nil || n.LHS.Type != types.NodeVariable {
+		return s.value(n.LHS, ctx)
+	}
+	name := n.LHS.StrValue
+	if err := s.checkEval(n.LHS, name); err != nil {
+		return err
+	}
+	if ctx != input {
+		return nil
+	}
+	args := len(n.Arguments)
+	if applied {
+		args++
+	}
+	if lambda, bound := s.bound[name]; bound {
+		if !lambda && !applied {
+			return s.fail(n, "$%s may receive the input as an implicit argument", name)
+		}
+		return nil
+	}
+	fn, ok := s.ev.LookupFunction(name)
+	if !ok {
+		return s.fail(n, "$%s is not a known function", name)
+	}
+	if fn.ReadsContext(args, map[string]interface{}{}) || fn.ReadsContext(args, []interface{}{}) {
+		return s.fail(n, "$%s may receive the input as an implicit argument", name)
+	}
+	return nil
+}
+
+// checkEval rejects $eval, which evaluates expressions that cannot be
+// rewritten.
+func (s *scoper) checkEval(n *types.ASTNode, name string) error {
+	if _, bound := s.bound[name]; name == "eval" && !bound {
+		return s.fail(n, "$eval cannot be scoped")
+	}
+	return nil
+}
+
+// transform checks the clauses of a transform, evaluated against the copy it
+// makes of its operand.
+func (s *scoper) transform(n *types.ASTNode) error {
+	return s.values(item, append([]*types.ASTNode{n.LHS, n.RHS}, n.Expressions...)...)
+}
@@ -0,0 +1,233 @@
+package testkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+)
+
+// maxDiffLines bounds the differences listed by Diff.
+const maxDiffLines = 20
+
+// Diff compares an expected value, as decoded from JSON, with an evaluation
+// result. It returns "" when they are equal and otherwise one line per
+// difference, addressed by JSON path, followed by the full result.
+//
+// Objects compare by key regardless of key order, so a map and an
+// OrderedObject with the same entries are equal. Numbers of any Go kind compare
+// by value, within the tolerance set by WithNumberTolerance.
+func Diff(want, got interface{}, opts ...Option) string {
+	return newOptions(opts).diff(want, got)
+}
+
+func (o *options) diff(want, got interface{}) string {
+	var d differ
+	d.o = o
+	d.compare("$", want, got)
+	if len(d.lines) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, line := range d.lines {
+		if i == maxDiffLines {
+			fmt.Fprintf(&b, "  ... %d more\n", len(d.lines)-i)
+			break
+		}
+		b.WriteString("  ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteString("got:\n")
+	b.WriteString(renderIndent(got))
+	return b.String()
+}
+
+type differ struct {
+	o     *options
+	lines []string
+}
+
+func (d *differ) report(path, format string, args ...interface{}) {
+	d.lines = append(d.lines, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (d *differ) compare(path string, want, got interface{}) {
+	if want == nil || got == nil {
+		if want != nil || got != nil {
+			d.report(path, "-%s +%s", render(want), render(got))
+		}
+		return
+	}
+
+	if wn, ok := toFloat(want); ok {
+		if gn, ok := toFloat(got); !ok || !d.o.numbersEqual(wn, gn) {
+			d.report(path, "-%s +%s", render(want), render(got))
+		}
+		return
+	}
+
+	if wantArr, ok := want.([]interface{}); ok {
+		gotArr, ok := got.([]interface{})
+		if !ok {
+			d.report(path, "-%s +%s", render(want), render(got))
+			return
+		}
+		if d.o.unordered {
+			d.compareUnordered(path, wantArr, gotArr)
+			return
+		}
+		for i := 0; i < max(len(wantArr), len(gotArr)); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(gotArr):
+				d.report(itemPath, "missing %s", render(wantArr[i]))
+			case i >= len(wantArr):
+				d.report(itemPath, "unexpected %s", render(gotArr[i]))
+			default:
+				d.compare(itemPath, wantArr[i], gotArr[i])
+			}
+		}
+		return
+	}
+
+	if wantKeys, wantValues, ok := objectEntries(want); ok {
+		gotKeys, gotValues, ok := objectEntries(got)
+		if !ok {
+			d.report(path, "-%s +%s", render(want), render(got))
+			return
+		}
+		for _, k := range wantKeys {
+			gv, found := gotValues[k]
+			if !found {
+				d.report(fieldPath(path, k), "missing %s", render(wantValues[k]))
+				continue
+			}
+			d.compare(fieldPath(path, k), wantValues[k], gv)
+		}
+		for _, k := range gotKeys {
+			if _, found := wantValues[k]; !found {
+				d.report(fieldPath(path, k), "unexpected %s", render(gotValues[k]))
+			}
+		}
+		return
+	}
+
+	if want != got {
+		d.report(path, "-%s +%s", render(want), render(got))
+	}
+}
+
+// compareUnordered matches every expected item with an equal, not yet matched
+// result item.
+func (d *differ) compareUnordered(path string, want, got []interface{}) {
+	matched := make([]bool, len(got))
+	for i, w := range want {
+		found := false
+		for j, g := range got {
+			if !matched[j] && d.equal(w, g) {
+				matched[j], found = true, true
+				break
+			}
+		}
+		if !found {
+			d.report(fmt.Sprintf("%s[%d]", path, i), "missing %s", render(w))
+		}
+	}
+	for j, g := range got {
+		if !matched[j] {
+			d.report(fmt.Sprintf("%s[%d]", path, j), "unexpected %s", render(g))
+		}
+	}
+}
+
+func (d *differ) equal(want, got interface{}) bool {
+	sub := differ{o: d.o}
+	sub.compare("", want, got)
+	return len(sub.lines) == 0
+}
+
+func (o *options) numbersEqual(a, b float64) bool {
+	if a == b || (math.IsNaN(a) && math.IsNaN(b)) {
+		return true
+	}
+	if o.tolerance <= 0 {
+		return false
+	}
+	scale := math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+	return math.Abs(a-b) <= o.tolerance*scale
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// objectEntries returns the keys, in display order, and the values of an
+// object: a map (keys sorted) or an OrderedObject (keys in insertion order).
+func objectEntries(v interface{}) ([]string, map[string]interface{}, bool) {
+	switch obj := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys, obj, true
+	case *evaluator.OrderedObject:
+		return obj.Keys, obj.Values, true
+	}
+	return nil, nil, false
+}
+
+func fieldPath(path, key string) string {
+	for _, r := range key {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return path + "[" + render(key) + "]"
+		}
+	}
+	return path + "." + key
+}
+
+// render formats v as compact JSON for a diff line.
+func render(v interface{}) string {
+	if v == nil {
+		return "undefined"
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%#v", v)
+	}
+	return string(out)
+}
+
+// renderIndent formats v as indented JSON.
+func renderIndent(v interface{}) string {
+	if v == nil {
+		return "undefined\n"
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%#v\n", v)
+	}
+	return string(out) + "\n"
+}
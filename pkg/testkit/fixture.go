@@ -0,0 +1,107 @@
+package testkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+)
+
+// ErrNondeterministic is returned by Generate when the expression reads the
+// current time ($now, $millis), whose result no fixture can lock down.
+var ErrNondeterministic = errors.New("testkit: expression depends on the current time")
+
+// TraceStep is one entry of the trace of a fixture: an expression node and the
+// number of times it was evaluated.
+type TraceStep struct {
+	// Node describes the node, e.g. `binary ">"` or `function "$sum"`.
+	Node string `json:"node"`
+	// Position is the node's offset in the expression source.
+	Position int `json:"position"`
+	// Calls is the number of times the node was evaluated.
+	Calls int `json:"calls"`
+}
+
+// Generate evaluates expr against data in deterministic mode and returns a case
+// file locking down its outcome: the expression, the input, the result (or
+// error code), the seed and the trace of the evaluation. It is the library side
+// of cmd/gen-fixtures, used to capture the current behavior of an expression
+// before refactoring it.
+//
+// In deterministic mode $random and $shuffle draw from a generator seeded with
+// seed; the case records the seed so that RunCase replays the same draws.
+// Expressions calling $now or $millis fail with ErrNondeterministic.
+//
+// The trace lists the evaluated nodes in source order with their call counts.
+// It documents how the result was computed and is not compared by RunCase.
+// Evaluator options given with WithEvalOptions must be given to Run as well.
+func Generate(expr string, data interface{}, seed uint64, opts ...Option) ([]byte, error) {
+	o := newOptions(opts)
+	c := &Case{Expr: expr, Data: data, Seed: &seed}
+	raw, err := marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("testkit: encoding data: %w", err)
+	}
+	c.file = caseFile{Expr: expr, Seed: &seed}
+	if data != nil {
+		c.file.Data = raw
+	}
+	c.file.Trace = json.RawMessage("[]")
+
+	result, err := evaluate(c, o)
+	if errors.Is(err, ErrNondeterministic) {
+		return nil, err
+	}
+	return c.encode(result, err, o)
+}
+
+// evalOptions returns the evaluator options c runs with.
+func (c *Case) evalOptions(o *options) []evaluator.EvalOption {
+	if c.Seed == nil {
+		return o.evalOpts
+	}
+	opts := append([]evaluator.EvalOption{evaluator.WithRandomSeed(*c.Seed)}, o.evalOpts...)
+	// The clock guards come last: custom functions registered later replace
+	// earlier ones of the same name.
+	for _, name := range []string{"now", "millis"} {
+		name := name
+		opts = append(opts, evaluator.WithCustomFunction(name, "", func(context.Context, ...interface{}) (interface{}, error) {
+			return nil, fmt.Errorf("%w: $%s", ErrNondeterministic, name)
+		}))
+	}
+	return opts
+}
+
+// trace evaluates c again with a profile and returns its evaluated nodes in
+// source order. Cases with bindings have no trace: Profile does not take any.
+func (c *Case) trace(o *options) ([]TraceStep, error) {
+	if len(c.Bindings) > 0 {
+		return nil, nil
+	}
+	expr, err := parser.Compile(c.Expr)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := evaluator.New(c.evalOptions(o)...).Profile(context.Background(), expr, c.Data)
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]TraceStep, 0, len(profile.Nodes))
+	for _, n := range profile.Nodes {
+		steps = append(steps, TraceStep{Node: n.Label, Position: n.Position, Calls: n.Calls})
+	}
+	sort.SliceStable(steps, func(i, j int) bool {
+		if steps[i].Position != steps[j].Position {
+			return steps[i].Position < steps[j].Position
+		}
+		if steps[i].Node != steps[j].Node {
+			return steps[i].Node < steps[j].Node
+		}
+		return steps[i].Calls < steps[j].Calls
+	})
+	return steps, nil
+}
@@ -0,0 +1,386 @@
+// Package testkit runs directories of golden-file test cases for JSONata
+// expressions as Go subtests.
+//
+// A case is a JSON file holding an expression, its input and the expected
+// result:
+//
+//	{
+//	  "expr": "orders[total > 100].id",
+//	  "data": {"orders": [{"id": 1, "total": 250}]},
+//	  "result": 1
+//	}
+//
+// The supported fields are:
+//
+//   - expr: the expression, or exprFile: a file holding it (e.g. a .jsonata
+//     file of the expression library under test), relative to the case file.
+//   - data: the input document, or dataFile: a JSON file holding it.
+//   - bindings: variables bound for the evaluation.
+//   - result: the expected result. A missing or null result expects undefined
+//     (Eval reports a top-level null as undefined too).
+//   - error: the expected error code (e.g. "T2001") instead of a result.
+//   - unordered: compare arrays regardless of item order.
+//   - seed: run in deterministic mode, as the fixtures written by Generate
+//     (and cmd/gen-fixtures) do: $random and $shuffle draw from a generator
+//     seeded with seed, and $now and $millis fail.
+//   - trace: the nodes evaluated, as recorded by Generate; informative only.
+//
+// Every *.json file below the directory that holds an expr or exprFile field is
+// a case; other JSON files (shared inputs referenced through dataFile) are
+// skipped. Subtests are named after the case path without the extension.
+//
+// Typical use, with a flag to rewrite the expected results from the current
+// output after a deliberate change:
+//
+//	var update = flag.Bool("update", false, "rewrite golden results")
+//
+//	func TestExpressions(t *testing.T) {
+//		testkit.Run(t, "testdata/expressions",
+//			testkit.WithUpdate(*update),
+//			testkit.WithNumberTolerance(1e-9),
+//			testkit.WithEvalOptions(gosonata.WithFunctions(extstring.AllEntries()...)),
+//		)
+//	}
+package testkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// Case is a golden-file test case loaded by LoadDir.
+type Case struct {
+	// Name is the case path relative to the loaded directory, slash-separated
+	// and without the .json extension.
+	Name string
+	// Path is the case file.
+	Path string
+	// Expr is the expression, read from exprFile when set.
+	Expr string
+	// Data is the input document, read from dataFile when set.
+	Data interface{}
+	// Bindings are the variables bound for the evaluation.
+	Bindings map[string]interface{}
+	// Result is the expected result; nil expects undefined.
+	Result interface{}
+	// Error is the expected error code, if any.
+	Error string
+	// Unordered compares arrays regardless of item order.
+	Unordered bool
+	// Seed, when set, runs the case in deterministic mode (see Generate).
+	Seed *uint64
+	// Trace is the trace recorded by Generate, if any.
+	Trace []TraceStep
+
+	file caseFile
+}
+
+// caseFile is the on-disk form of a case. Values are kept raw so that
+// rewriting the expected result leaves the rest of the file as written.
+type caseFile struct {
+	Expr      string          `json:"expr,omitempty"`
+	ExprFile  string          `json:"exprFile,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	DataFile  string          `json:"dataFile,omitempty"`
+	Bindings  json.RawMessage `json:"bindings,omitempty"`
+	Unordered bool            `json:"unordered,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Seed      *uint64         `json:"seed,omitempty"`
+	Trace     json.RawMessage `json:"trace,omitempty"`
+}
+
+// Option configures Run, RunCase and Diff.
+type Option func(*options)
+
+type options struct {
+	evalOpts  []evaluator.EvalOption
+	tolerance float64
+	unordered bool
+	update    bool
+}
+
+// WithEvalOptions sets the options of the evaluator the cases run with, such as
+// custom functions or a timeout.
+func WithEvalOptions(opts ...evaluator.EvalOption) Option {
+	return func(o *options) {
+		o.evalOpts = append(o.evalOpts, opts...)
+	}
+}
+
+// WithNumberTolerance makes numbers compare equal when they differ by at most
+// epsilon, relative to the larger magnitude (absolute below 1). The default is
+// exact comparison.
+func WithNumberTolerance(epsilon float64) Option {
+	return func(o *options) {
+		o.tolerance = epsilon
+	}
+}
+
+// WithUnordered compares arrays regardless of item order, as the unordered
+// field of a case does.
+func WithUnordered(enabled bool) Option {
+	return func(o *options) {
+		o.unordered = enabled
+	}
+}
+
+// WithUpdate, when enabled, rewrites the result (or error) of every case file
+// with the current output instead of comparing against it.
+func WithUpdate(enabled bool) Option {
+	return func(o *options) {
+		o.update = enabled
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Run loads the cases below dir and runs each as a subtest of t. It fails t when
+// dir holds no case.
+func Run(t *testing.T, dir string, opts ...Option) {
+	t.Helper()
+	cases, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("testkit: no cases in %s", dir)
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			RunCase(t, c, opts...)
+		})
+	}
+}
+
+// RunCase evaluates c and reports a mismatch with its expected result or error
+// through t.
+func RunCase(t testing.TB, c *Case, opts ...Option) {
+	t.Helper()
+	o := newOptions(opts)
+
+	result, err := evaluate(c, o)
+	if o.update {
+		if err := c.update(result, err, o); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if c.Error != "" {
+		if err == nil {
+			t.Errorf("%s: expected error %s, got result %s", c.Expr, c.Error, render(result))
+		} else if code := errorCode(err); code != c.Error {
+			t.Errorf("%s: expected error %s, got %v", c.Expr, c.Error, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("%s: %v", c.Expr, err)
+		return
+	}
+
+	if c.Unordered {
+		o.unordered = true
+	}
+	if diff := o.diff(c.Result, result); diff != "" {
+		t.Errorf("%s: result mismatch (-want +got)\n%s", c.Expr, diff)
+	}
+}
+
+func evaluate(c *Case, o *options) (interface{}, error) {
+	expr, err := parser.Compile(c.Expr)
+	if err != nil {
+		return nil, err
+	}
+	return evaluator.New(c.evalOptions(o)...).EvalWithBindings(context.Background(), expr, c.Data, c.Bindings)
+}
+
+// errorCode returns the JSONata error code of err, or "" for other errors.
+func errorCode(err error) string {
+	var jerr *types.Error
+	if errors.As(err, &jerr) {
+		return string(jerr.Code)
+	}
+	return ""
+}
+
+// update rewrites the case file with the given outcome.
+func (c *Case) update(result interface{}, evalErr error, o *options) error {
+	out, err := c.encode(result, evalErr, o)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.Path, out, 0o644)
+}
+
+// encode returns the case file with the given outcome. A trace, when the file
+// has one, is recorded anew.
+func (c *Case) encode(result interface{}, evalErr error, o *options) ([]byte, error) {
+	file := c.file
+	file.Result, file.Error = nil, ""
+	if evalErr != nil {
+		code := errorCode(evalErr)
+		if code == "" {
+			return nil, c.errorf("error without a code: %w", evalErr)
+		}
+		file.Error = code
+	} else if result != nil {
+		raw, err := marshal(result)
+		if err != nil {
+			return nil, c.errorf("encoding result: %w", err)
+		}
+		file.Result = raw
+	}
+	if file.Trace != nil {
+		file.Trace = nil
+		if evalErr == nil {
+			steps, err := c.trace(o)
+			if err != nil {
+				return nil, c.errorf("trace: %w", err)
+			}
+			if steps != nil {
+				if file.Trace, err = marshal(steps); err != nil {
+					return nil, c.errorf("%w", err)
+				}
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(file); err != nil {
+		return nil, c.errorf("%w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// marshal encodes v as JSON without escaping <, > and &, which expressions
+// often hold.
+func marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// errorf returns an error about c, prefixed with its path when it has one.
+func (c *Case) errorf(format string, args ...interface{}) error {
+	if c.Path != "" {
+		format = c.Path + ": " + format
+	}
+	return fmt.Errorf("testkit: "+format, args...)
+}
+
+// LoadDir loads the cases below dir, in lexical path order.
+func LoadDir(dir string) ([]*Case, error) {
+	var cases []*Case
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		c, err := loadCase(path)
+		if err != nil || c == nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		c.Name = strings.TrimSuffix(filepath.ToSlash(rel), ".json")
+		cases = append(cases, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// loadCase reads the case file at path. It returns nil for JSON files that are
+// not cases.
+func loadCase(path string) (*Case, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, nil
+	}
+	var file caseFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("testkit: %s: %w", path, err)
+	}
+	if file.Expr == "" && file.ExprFile == "" {
+		return nil, nil
+	}
+
+	c := &Case{
+		Path:      path,
+		Expr:      file.Expr,
+		Error:     file.Error,
+		Unordered: file.Unordered,
+		Seed:      file.Seed,
+		file:      file,
+	}
+	base := filepath.Dir(path)
+	if file.ExprFile != "" {
+		src, err := os.ReadFile(filepath.Join(base, file.ExprFile))
+		if err != nil {
+			return nil, fmt.Errorf("testkit: %s: %w", path, err)
+		}
+		c.Expr = string(src)
+	}
+	data := []byte(file.Data)
+	if file.DataFile != "" {
+		if data, err = os.ReadFile(filepath.Join(base, file.DataFile)); err != nil {
+			return nil, fmt.Errorf("testkit: %s: %w", path, err)
+		}
+	}
+	if err := decodeField(data, &c.Data); err != nil {
+		return nil, fmt.Errorf("testkit: %s: data: %w", path, err)
+	}
+	if err := decodeField(file.Bindings, &c.Bindings); err != nil {
+		return nil, fmt.Errorf("testkit: %s: bindings: %w", path, err)
+	}
+	if err := decodeField(file.Result, &c.Result); err != nil {
+		return nil, fmt.Errorf("testkit: %s: result: %w", path, err)
+	}
+	if err := decodeField(file.Trace, &c.Trace); err != nil {
+		return nil, fmt.Errorf("testkit: %s: trace: %w", path, err)
+	}
+	return c, nil
+}
+
+func decodeField(raw []byte, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -59,6 +59,11 @@ const (
 	NodeIndex     NodeType = "index"     // #
 	NodeRange     NodeType = "range"     // .. (range operator)
 	NodeApply     NodeType = "apply"     // ~> (chain operator)
+	NodeImport    NodeType = "import"    // $import("name") resolved at compile time; LHS is the module
+
+	// Syntax extensions (see parser.WithExtensions)
+	NodeSpread      NodeType = "spread"   // ...expr entry of an object constructor
+	NodeComputedKey NodeType = "computed" // [expr] key of an object constructor
 )
 
 // ASTNode represents a node in the Abstract Syntax Tree.
@@ -83,9 +88,18 @@ type ASTNode struct {
 	Index     int    // Array index (for optimization)
 	Signature string // Function signature (e.g., "<n-n:n>")
 
+	// Parsed Signature of a lambda (NodeLambda only)
+	ParsedSignature *Signature
+
 	// Object constructor semantics
 	IsGrouping bool // True for infix expr{...}, false for prefix {...} or path-applied
 
+	// Optional marks a path step written after ?. (see parser.ExtOptionalChaining)
+	Optional bool
+
+	// Closure analysis of a lambda body (NodeLambda only)
+	Closure *ClosureInfo
+
 	// Error recovery
 	Errors []error
 }
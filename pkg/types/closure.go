@@ -0,0 +1,133 @@
+package types
+
+import "sort"
+
+// ClosureInfo describes what a lambda body needs from the context it was created
+// in. The parser attaches it to every NodeLambda; the evaluator uses it to detach
+// function values returned from an evaluation from the rest of that evaluation's
+// state (in particular the input document).
+type ClosureInfo struct {
+	// Vars are the variable names referenced anywhere in the body, including
+	// nested lambdas and function names called through $name(...). Sorted.
+	Vars []string
+	// UsesContext is set when the body reads the context value implicitly: `$`,
+	// field names, wildcards, descendants, `@`/`#` or a transform.
+	UsesContext bool
+	// UsesRoot is set when the body references `$$`.
+	UsesRoot bool
+	// UsesParent is set when the body uses the `%` parent operator, which walks
+	// the chain of enclosing contexts.
+	UsesParent bool
+	// UsesEval is set when the body refers to $eval, whose expression is only
+	// known at run time and may read any variable in scope, the context value
+	// or the parent chain.
+	UsesEval bool
+	// ContextCalls are the variable names called as functions at the head of a
+	// path. If such a name resolves to a built-in that may receive the context
+	// value in place of a missing argument (e.g. $string(), $substring(1, 2)),
+	// the call reads the context.
+	ContextCalls []string
+}
+
+// AnalyzeClosure computes the ClosureInfo of a lambda body.
+func AnalyzeClosure(body *ASTNode) *ClosureInfo {
+	a := closureAnalyzer{
+		vars:  make(map[string]struct{}),
+		calls: make(map[string]struct{}),
+	}
+	a.scan(body, true)
+	a.info.Vars = sortedNames(a.vars)
+	a.info.ContextCalls = sortedNames(a.calls)
+	return &a.info
+}
+
+type closureAnalyzer struct {
+	info  ClosureInfo
+	vars  map[string]struct{}
+	calls map[string]struct{}
+}
+
+// scan walks node. head reports whether node is evaluated against the lambda's
+// own context value, as opposed to the items produced by an enclosing path,
+// filter, sort or grouping step.
+func (a *closureAnalyzer) scan(node *ASTNode, head bool) {
+	if node == nil {
+		return
+	}
+	switch node.Type {
+	case NodeVariable:
+		switch node.StrValue {
+		case "":
+			if head {
+				a.info.UsesContext = true
+			}
+		case "$":
+			a.info.UsesRoot = true
+		case "eval":
+			a.info.UsesEval = true
+			a.vars[node.StrValue] = struct{}{}
+		default:
+			a.vars[node.StrValue] = struct{}{}
+		}
+		return
+	case NodeName, NodeWildcard, NodeDescendant, NodeContext, NodeIndex, NodeTransform:
+		if head {
+			a.info.UsesContext = true
+		}
+	case NodeParent:
+		a.info.UsesParent = true
+	case NodeImport:
+		return // modules are evaluated on their own
+	case NodePath:
+		a.scan(node.LHS, head)
+		a.scan(node.RHS, false)
+		a.scanAll(node.Steps, false)
+		a.scanAll(node.Arguments, false)
+		a.scanAll(node.Expressions, false)
+		return
+	case NodeFilter, NodeSort:
+		a.scan(node.LHS, head)
+		a.scan(node.RHS, false)
+		a.scanAll(node.Arguments, false)
+		a.scanAll(node.Expressions, false)
+		return
+	case NodeObject:
+		if node.LHS != nil {
+			a.scan(node.LHS, head)
+			a.scanAll(node.Expressions, false)
+			return
+		}
+	case NodeFunction, NodePartial:
+		if head {
+			if node.LHS != nil && node.LHS.Type == NodeVariable {
+				a.calls[node.LHS.StrValue] = struct{}{}
+			} else if node.LHS == nil {
+				a.calls[node.StrValue] = struct{}{}
+			}
+		}
+	}
+
+	a.scan(node.LHS, head)
+	a.scan(node.RHS, head)
+	a.scanAll(node.Steps, head)
+	a.scanAll(node.Arguments, head)
+	a.scanAll(node.Expressions, head)
+}
+
+func (a *closureAnalyzer) scanAll(nodes []*ASTNode, head bool) {
+	for _, n := range nodes {
+		a.scan(n, head)
+	}
+}
+
+func sortedNames(set map[string]struct{}) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,6 +1,9 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // ErrorCode represents a JSONata error code.
 type ErrorCode string
@@ -8,26 +11,35 @@ type ErrorCode string
 // Error codes based on JSONata reference implementation.
 const (
 	// S0xxx: Parser/Syntax errors
-	ErrStringNotClosed    ErrorCode = "S0101"
-	ErrNumberOutOfRange   ErrorCode = "S0102"
-	ErrUnsupportedEscape  ErrorCode = "S0103"
-	ErrUnexpectedEnd      ErrorCode = "S0104"
-	ErrCommentNotClosed   ErrorCode = "S0106"
-	ErrSyntaxError        ErrorCode = "S0201"
-	ErrExpectedToken      ErrorCode = "S0202"
-	ErrExpectedKeyword    ErrorCode = "S0203"
-	ErrInvalidPathStep    ErrorCode = "S0213" // digit field name after dot
-	ErrContextVarIllegal  ErrorCode = "S0214" // @ or # not followed by $var
-	ErrContextAfterFilter ErrorCode = "S0215" // @ cannot follow a filter predicate
-	ErrContextAfterSort   ErrorCode = "S0216" // @ cannot follow an order-by clause
-	ErrInvalidParentUse   ErrorCode = "S0217" // parent operator (%) in invalid context
-	ErrEmptyRegex         ErrorCode = "S0301"
-	ErrRegexNotClosed     ErrorCode = "S0302"
+	ErrStringNotClosed     ErrorCode = "S0101"
+	ErrNumberOutOfRange    ErrorCode = "S0102"
+	ErrUnsupportedEscape   ErrorCode = "S0103"
+	ErrUnexpectedEnd       ErrorCode = "S0104"
+	ErrCommentNotClosed    ErrorCode = "S0106"
+	ErrSyntaxError         ErrorCode = "S0201"
+	ErrExpectedToken       ErrorCode = "S0202"
+	ErrExpectedKeyword     ErrorCode = "S0203"
+	ErrInvalidAssignTarget ErrorCode = "S0212" // left side of := is not a variable
+	ErrInvalidPathStep     ErrorCode = "S0213" // literal value as a path step
+	ErrContextVarIllegal   ErrorCode = "S0214" // @ or # not followed by $var
+	ErrContextAfterFilter  ErrorCode = "S0215" // @ cannot follow a filter predicate
+	ErrContextAfterSort    ErrorCode = "S0216" // @ cannot follow an order-by clause
+	ErrInvalidParentUse    ErrorCode = "S0217" // parent operator (%) in invalid context
+	ErrUnknownFunction     ErrorCode = "S0410" // call of an unknown function (WithKnownFunctions)
+	ErrEmptyRegex          ErrorCode = "S0301"
+	ErrRegexNotClosed      ErrorCode = "S0302"
+	ErrModuleNotFound      ErrorCode = "S0601" // $import: the resolver failed
+	ErrImportCycle         ErrorCode = "S0602" // $import: modules import each other
+	ErrImportArgument      ErrorCode = "S0603" // $import argument is not a string literal
+	ErrExpressionTooLarge  ErrorCode = "S0701" // the expression exceeds the size limit (WithMaxSize)
+	ErrNestingTooDeep      ErrorCode = "S0702" // brackets nested deeper than WithMaxDepth
+	ErrTooManyTokens       ErrorCode = "S0703" // more tokens than WithMaxTokens
 	// T0xxx: Type errors
 	ErrArgumentCountMismatch ErrorCode = "T0410"
 	ErrCannotConvertNumber   ErrorCode = "T1001"
 	ErrCannotConvertString   ErrorCode = "T1002"
 	ErrInvalidTypeOperation  ErrorCode = "T1003"
+	ErrSpreadNotObject       ErrorCode = "T1004" // ...expr of a non-object (extension)
 
 	// T2xxx: Operator type errors
 	ErrLeftSideAssignment    ErrorCode = "T2001"
@@ -42,21 +54,29 @@ const (
 	ErrNumberTooLarge         ErrorCode = "D1001"
 	ErrInvokeNonFunction      ErrorCode = "D1002"
 	ErrZeroLengthMatch        ErrorCode = "D1004"
+	ErrDataCycle              ErrorCode = "D1010" // input data references itself
+	ErrDataTooLarge           ErrorCode = "D1011" // input data exceeds the value limit
+	ErrStringTooLarge         ErrorCode = "D1012" // a string exceeds WithMaxStringLength
 	ErrLeftSideRange          ErrorCode = "D2001"
 	ErrRangeTooLarge          ErrorCode = "D2014"
 	ErrSerializeNonFinite     ErrorCode = "D3001"
 	ErrRecursiveDefinition    ErrorCode = "D3010"
 	ErrReplacementNotString   ErrorCode = "D3012"
 	ErrStackOverflow          ErrorCode = "D3020"
+	ErrCastToNumber           ErrorCode = "D3030"
 	ErrReduceInsufficientArgs ErrorCode = "D3050"
 	ErrTypeMismatch           ErrorCode = "D3070"
 	ErrSingleMultipleMatches  ErrorCode = "D3138"
 	ErrSingleNoMatch          ErrorCode = "D3139"
 	ErrEncodeURISurrogate     ErrorCode = "D3140"
+	ErrRandomBounds           ErrorCode = "D3150" // $random bounds not integers or empty (extension)
+	ErrJoinArgument           ErrorCode = "D3160" // $joinOn join type or key not valid (extension)
 
 	// U0xxx: Runtime errors
 	ErrUndefinedVariable ErrorCode = "U1001"
 	ErrUndefinedFunction ErrorCode = "U1002"
+	ErrNotInBuild        ErrorCode = "U1003" // feature left out of a -tags minimal build
+	ErrMissingField      ErrorCode = "U1004" // missing field under WithStrictNavigation
 )
 
 // Error represents a structured JSONata error.
@@ -66,6 +86,21 @@ type Error struct {
 	Position int
 	Token    string
 	Err      error
+
+	// Line and Column locate Position in the expression source (1-based,
+	// columns counted in runes). They are zero when unknown; parse errors
+	// always set them.
+	Line   int
+	Column int
+	// Expected lists the token classes the parser would have accepted at
+	// Position, e.g. `")"` and `","`.
+	Expected []string
+	// Suggestion is the likely intended spelling of a name near the error,
+	// such as "$substring" for a call to $substrng.
+	Suggestion string
+	// Snippet is the source line holding Position followed by a caret line
+	// pointing at Column.
+	Snippet string
 }
 
 // NewError creates a new JSONata error.
@@ -78,11 +113,26 @@ func NewError(code ErrorCode, message string, position int) *Error {
 }
 
 // Error implements the error interface.
+// Parse errors add the line and column, the suggestion and, on the following
+// lines, the source snippet.
 func (e *Error) Error() string {
-	if e.Position >= 0 {
-		return fmt.Sprintf("%s at position %d: %s", e.Code, e.Position, e.Message)
+	var b strings.Builder
+	switch {
+	case e.Line > 0:
+		fmt.Fprintf(&b, "%s at position %d (line %d, column %d): %s", e.Code, e.Position, e.Line, e.Column, e.Message)
+	case e.Position >= 0:
+		fmt.Fprintf(&b, "%s at position %d: %s", e.Code, e.Position, e.Message)
+	default:
+		fmt.Fprintf(&b, "%s: %s", e.Code, e.Message)
+	}
+	if e.Suggestion != "" {
+		fmt.Fprintf(&b, "; did you mean %s?", e.Suggestion)
+	}
+	if e.Snippet != "" {
+		b.WriteByte('\n')
+		b.WriteString(e.Snippet)
 	}
-	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	return b.String()
 }
 
 // Unwrap returns the wrapped error.
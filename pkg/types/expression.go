@@ -9,6 +9,8 @@
 //   - Error types: Structured errors with codes
 package types
 
+import "fmt"
+
 // Expression represents a compiled JSONata expression.
 //
 // An Expression can be evaluated multiple times against different data
@@ -18,6 +20,12 @@ type Expression struct {
 	ast    *ASTNode
 	source string
 	errors []error
+	// warnings are the parse warnings (see Warnings).
+	warnings []Warning
+	// metadata is the leading annotation block (see Metadata).
+	metadata *Metadata
+	// constants are the variables bound at compile time (see Constants).
+	constants map[string]interface{}
 	// arena backs all ASTNode values in the tree; keeping a reference here
 	// ensures the arena is not GC'd while the Expression (or a cache entry
 	// holding it) is still alive.  OPT-11.
@@ -54,6 +62,88 @@ func (e *Expression) AddError(err error) {
 	e.errors = append(e.errors, err)
 }
 
+// Warnings returns the warnings reported by the parser: constructs that are
+// accepted but whose behavior is legacy, non-portable or slated to change, so
+// that expression authors can migrate them ahead of time. It is nil for an
+// expression without warnings.
+func (e *Expression) Warnings() []Warning {
+	return e.warnings
+}
+
+// AddWarning adds a warning to the expression's warning list.
+func (e *Expression) AddWarning(w Warning) {
+	e.warnings = append(e.warnings, w)
+}
+
+// Metadata returns the metadata declared by the annotation block at the start
+// of the expression source, or nil when it has none.
+func (e *Expression) Metadata() *Metadata {
+	return e.metadata
+}
+
+// SetMetadata sets the metadata of the expression.
+func (e *Expression) SetMetadata(m *Metadata) {
+	e.metadata = m
+}
+
+// Constants returns the variables bound at compile time with
+// parser.WithConstants, by name, with their values as the evaluator sees them.
+// References to them are folded into the syntax tree; evaluators also bind
+// them, for the expressions that $eval evaluates. It is nil for an expression
+// compiled without constants.
+func (e *Expression) Constants() map[string]interface{} {
+	return e.constants
+}
+
+// SetConstants sets the variables bound at compile time.
+func (e *Expression) SetConstants(consts map[string]interface{}) {
+	e.constants = consts
+}
+
+// Metadata describes an expression, so that catalogs of expressions can be
+// self-documenting. It is declared by a leading annotation block:
+//
+//	/**
+//	 * Net amount of an order, after discounts.
+//	 *
+//	 * @name order-net
+//	 * @author Billing team
+//	 * @version 1.2.0
+//	 * @tags billing, orders
+//	 */
+//	$sum(lines.(price * qty)) - discount
+type Metadata struct {
+	Name string
+	// Description is the @description annotation or, without it, the text
+	// of the block before the first annotation.
+	Description string
+	Author      string
+	Version     string
+	// Tags are the comma- or space-separated words of the @tags annotations.
+	Tags []string
+	// Annotations holds the other annotations of the block, by name (without
+	// the @); an annotation repeated keeps its last value.
+	Annotations map[string]string
+}
+
+// Warning is a non-fatal diagnostic reported while parsing an expression.
+type Warning struct {
+	// Code identifies the kind of warning, e.g. "W1001".
+	Code string
+	// Message describes the construct and how to migrate it.
+	Message string
+	// Position is the byte offset of the construct in the expression source;
+	// Line and Column locate it (1-based, columns counted in runes).
+	Position int
+	Line     int
+	Column   int
+}
+
+// String formats the warning as `W1001 at 1:5: message`.
+func (w Warning) String() string {
+	return fmt.Sprintf("%s at %d:%d: %s", w.Code, w.Line, w.Column, w.Message)
+}
+
 // String returns a string representation of the expression.
 func (e *Expression) String() string {
 	return e.source
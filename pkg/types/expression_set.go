@@ -0,0 +1,28 @@
+package types
+
+// ExpressionSet is a set of queries compiled together to be evaluated against
+// the same input (see parser.CompileMany and evaluator.Evaluator.EvalMany).
+//
+// Beside the expression of each query, the set holds a plan evaluating once
+// the sub-expressions that several queries compute in the context of the
+// input, such as a path prefix they share: Shared binds each of them to a
+// variable that the Rewritten queries read instead of computing it again.
+type ExpressionSet struct {
+	// Expressions are the compiled queries, in order; nil for a query that
+	// failed to compile.
+	Expressions []*Expression
+	// Errors are the compile errors of the queries, in order; nil for a query
+	// that compiled.
+	Errors []error
+	// Shared are the bindings (NodeBind) of the shared sub-expressions, in
+	// evaluation order: a binding may read the variables of the previous ones.
+	Shared []*ASTNode
+	// Rewritten are the ASTs of the queries reading the shared variables, in
+	// order; nil for a query that failed to compile.
+	Rewritten []*ASTNode
+}
+
+// Len returns the number of queries of the set.
+func (s *ExpressionSet) Len() int {
+	return len(s.Expressions)
+}
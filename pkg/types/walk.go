@@ -0,0 +1,139 @@
+package types
+
+// Walking and rewriting syntax trees.
+//
+// Walk and Rewrite let tools outside the parser inspect and transform
+// compiled expressions: custom optimizations, security rewrites such as
+// adding a filter to every path, or instrumentation. They rely on the layout
+// of the nodes the parser produces, which is stable:
+//
+//	string, number, value   literals: Value (and StrValue or NumValue);
+//	                        value is true, false or null
+//	regex                   Value and StrValue hold the pattern
+//	name                    a field: StrValue is its name
+//	variable                StrValue is the name without $: "" for $, "$" for $$
+//	wildcard, parent        * and %, without children
+//	path                    LHS.RHS
+//	descendant              **.RHS searching LHS, the $ of the step; RHS is
+//	                        nil for a final **
+//	filter                  LHS[RHS]; RHS nil for LHS[] (KeepArray set)
+//	sort                    LHS^(key): the key is RHS or, when there are
+//	                        several, Expressions
+//	context, index          LHS@RHS and LHS#RHS; RHS is the variable bound
+//	binary                  LHS op RHS, op in StrValue; also the entries of
+//	                        object constructors (op ":"), ranges ("..") and
+//	                        function application ("~>")
+//	unary                   op LHS, op in StrValue: "-" or a sort direction
+//	condition               LHS ? RHS : Expressions[0]; Expressions is empty
+//	                        without an else branch
+//	bind                    LHS := RHS, LHS being the variable
+//	block                   ( Expressions[0]; Expressions[1]; ... )
+//	array                   [ Expressions... ]
+//	object                  { Expressions... }, each a ":" binary, a spread or
+//	                        a computed key; LHS{...} when IsGrouping
+//	function, partial       LHS( Arguments... ); LHS names the function;
+//	                        partial has placeholder arguments (?)
+//	lambda                  function( Arguments... ) { RHS }: the arguments
+//	                        are the parameter variables; Closure describes RHS
+//	transform               | LHS | RHS (, Expressions[0]) |
+//	import                  a resolved $import: LHS is the module's tree
+//	spread, computed        ...LHS and [LHS] entries of object constructors
+//
+// Position is the byte offset of the node in the source. StrValue mirrors
+// Value for the nodes holding a string, and NumValue for numbers; a rewrite
+// building nodes must set both.
+
+// Children returns the child nodes of n in the order Walk visits them: LHS,
+// RHS, Steps, Arguments, then Expressions.
+func (n *ASTNode) Children() []*ASTNode {
+	var nodes []*ASTNode
+	if n.LHS != nil {
+		nodes = append(nodes, n.LHS)
+	}
+	if n.RHS != nil {
+		nodes = append(nodes, n.RHS)
+	}
+	nodes = append(nodes, n.Steps...)
+	nodes = append(nodes, n.Arguments...)
+	return append(nodes, n.Expressions...)
+}
+
+// Walk calls fn for node and then, if fn returns true, for its descendants,
+// depth first, in the order of Children. The modules of $import calls are
+// walked too, as the LHS of import nodes; return false for them to skip them.
+func Walk(node *ASTNode, fn func(n *ASTNode) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	for _, child := range node.Children() {
+		Walk(child, fn)
+	}
+}
+
+// Rewrite returns the tree of node transformed by fn, which is called for
+// every node after its children, and returns the node to put in its place:
+// n itself (or nil) to keep it, or another node. The tree of node is not modified: a
+// node whose children change is copied, so that expressions being evaluated
+// are not affected and a rewrite can be applied to a shared expression. The
+// nodes passed to fn must be treated as read-only; build new ones instead.
+//
+// The Closure of a lambda is recomputed when its body changes, or when fn
+// returns a lambda without one.
+func Rewrite(node *ASTNode, fn func(n *ASTNode) *ASTNode) *ASTNode {
+	if node == nil {
+		return nil
+	}
+	lhs := Rewrite(node.LHS, fn)
+	rhs := Rewrite(node.RHS, fn)
+	steps, stepsChanged := rewriteList(node.Steps, fn)
+	args, argsChanged := rewriteList(node.Arguments, fn)
+	exprs, exprsChanged := rewriteList(node.Expressions, fn)
+
+	n := node
+	if lhs != node.LHS || rhs != node.RHS || stepsChanged || argsChanged || exprsChanged {
+		c := *node
+		c.LHS, c.RHS = lhs, rhs
+		c.Steps, c.Arguments, c.Expressions = steps, args, exprs
+		if c.Type == NodeLambda && rhs != node.RHS {
+			c.Closure = AnalyzeClosure(rhs)
+		}
+		n = &c
+	}
+
+	result := fn(n)
+	if result == nil {
+		result = n
+	}
+	if result.Type == NodeLambda && result.Closure == nil {
+		result.Closure = AnalyzeClosure(result.RHS)
+	}
+	return result
+}
+
+// rewriteList rewrites the nodes of list, returning a new list if any of them
+// changed.
+func rewriteList(list []*ASTNode, fn func(n *ASTNode) *ASTNode) ([]*ASTNode, bool) {
+	var out []*ASTNode
+	for i, child := range list {
+		c := Rewrite(child, fn)
+		if c != child && out == nil {
+			out = append(make([]*ASTNode, 0, len(list)), list[:i]...)
+		}
+		if out != nil {
+			out = append(out, c)
+		}
+	}
+	if out == nil {
+		return list, false
+	}
+	return out, true
+}
+
+// Rewrite returns a copy of e whose tree is rewritten by fn (see the function
+// Rewrite); e itself is not modified. The copy keeps the source, warnings and
+// metadata of e.
+func (e *Expression) Rewrite(fn func(n *ASTNode) *ASTNode) *Expression {
+	c := *e
+	c.ast = Rewrite(e.ast, fn)
+	return &c
+}
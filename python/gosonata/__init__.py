@@ -0,0 +1,188 @@
+"""GoSonata for Python.
+
+Runs the GoSonata WASI module (cmd/wasm/wasi) with wasmtime-py:
+
+    import gosonata
+
+    gosonata.evaluate("$sum(items.price)", {"items": [{"price": 10}, {"price": 20}]})  # 30
+
+    expr = gosonata.compile("items[price > 15].price")  # syntax errors raise here
+    expr.evaluate(data)
+
+    gosonata.evaluate("$price * (1 + $rate)", bindings={"price": 10, "rate": 0.2},
+                      timeout_ms=100)
+
+Data and results cross the module boundary as JSON. Each evaluation runs in a
+fresh instance of the module, compiled once per GoSonata runtime.
+"""
+
+import json
+import os
+import re
+import tempfile
+import threading
+
+import wasmtime
+
+__all__ = [
+    "GoSonata",
+    "Expression",
+    "GoSonataError",
+    "CompileError",
+    "EvaluationError",
+    "evaluate",
+    "compile",
+]
+
+WASM_PATH = os.path.join(os.path.dirname(__file__), "gosonata.wasm")
+
+_CODE = re.compile(r"(?:^|: )([A-Z]\d{4})\b")
+_POSITION = re.compile(r"\bat position (\d+)")
+
+
+class GoSonataError(Exception):
+    """Error reported by GoSonata.
+
+    code is the JSONata error code (e.g. "T2001") and position the offset in
+    the query, when known.
+    """
+
+    def __init__(self, message, code=None, position=None):
+        super().__init__(message)
+        self.message = message
+        self.code = code
+        self.position = position
+
+    @classmethod
+    def _from_response(cls, error):
+        if isinstance(error, dict):
+            return cls(error.get("message", ""), error.get("code"), error.get("position"))
+        return cls._from_message(error)
+
+    @classmethod
+    def _from_message(cls, message):
+        code = _CODE.search(message)
+        position = _POSITION.search(message)
+        return cls(
+            message,
+            code.group(1) if code else None,
+            int(position.group(1)) if position else None,
+        )
+
+
+class CompileError(GoSonataError):
+    """The query is not a valid JSONata expression."""
+
+
+class EvaluationError(GoSonataError):
+    """The evaluation of the query failed."""
+
+
+class GoSonata:
+    """A GoSonata runtime: the WASI module, compiled once.
+
+    A runtime may be shared between threads.
+    """
+
+    def __init__(self, wasm_path=WASM_PATH):
+        if not os.path.exists(wasm_path):
+            raise FileNotFoundError(
+                f"{wasm_path} not found: build it with "
+                "GOOS=wasip1 GOARCH=wasm go build -o gosonata.wasm ./cmd/wasm/wasi/"
+            )
+        self._engine = wasmtime.Engine()
+        self._module = wasmtime.Module.from_file(self._engine, wasm_path)
+        self._linker = wasmtime.Linker(self._engine)
+        self._linker.define_wasi()
+
+    def evaluate(self, query, data=None, bindings=None, max_depth=None, timeout_ms=None):
+        """Evaluates query against data (any JSON-serialisable value).
+
+        bindings maps variable names (without "$") to values; max_depth and
+        timeout_ms limit the recursion depth and the duration of the
+        evaluation. Returns None when the result is undefined.
+        """
+        request = {"query": query, "data": data}
+        if bindings:
+            request["bindings"] = bindings
+        options = {}
+        if max_depth is not None:
+            options["maxDepth"] = max_depth
+        if timeout_ms is not None:
+            options["timeoutMs"] = timeout_ms
+        if options:
+            request["options"] = options
+        return self._call(request, EvaluationError)
+
+    def compile(self, query):
+        """Compiles query, raising CompileError if it is not valid."""
+        self._call({"query": query, "compile": True}, CompileError)
+        return Expression(self, query)
+
+    def _call(self, request, error):
+        with tempfile.TemporaryDirectory(prefix="gosonata-") as tmp:
+            stdin = os.path.join(tmp, "stdin.json")
+            stdout = os.path.join(tmp, "stdout.json")
+            with open(stdin, "w", encoding="utf-8") as f:
+                json.dump(request, f)
+
+            wasi = wasmtime.WasiConfig()
+            wasi.stdin_file = stdin
+            wasi.stdout_file = stdout
+            wasi.inherit_stderr()
+            store = wasmtime.Store(self._engine)
+            store.set_wasi(wasi)
+            instance = self._linker.instantiate(store, self._module)
+            try:
+                instance.exports(store)["_start"](store)
+            except wasmtime.ExitTrap as exit:
+                # The module always ends with os.Exit: 0 on success, 1 with an
+                # error response.
+                if exit.code not in (0, 1):
+                    raise GoSonataError(f"gosonata.wasm exited with code {exit.code}") from exit
+
+            with open(stdout, encoding="utf-8") as f:
+                response = json.loads(f.read() or "{}")
+
+        if "error" in response:
+            raise error._from_response(response["error"])
+        return response.get("result")
+
+
+class Expression:
+    """A compiled expression, bound to the runtime that compiled it."""
+
+    def __init__(self, runtime, query):
+        self._runtime = runtime
+        self.query = query
+
+    def evaluate(self, data=None, **options):
+        """Evaluates the expression against data; options are those of
+        GoSonata.evaluate."""
+        return self._runtime.evaluate(self.query, data, **options)
+
+    def __repr__(self):
+        return f"Expression({self.query!r})"
+
+
+_default = None
+_default_lock = threading.Lock()
+
+
+def _runtime():
+    global _default
+    with _default_lock:
+        if _default is None:
+            _default = GoSonata()
+        return _default
+
+
+def evaluate(query, data=None, **options):
+    """Evaluates query against data with the default runtime (see
+    GoSonata.evaluate for the options)."""
+    return _runtime().evaluate(query, data, **options)
+
+
+def compile(query):
+    """Compiles query with the default runtime."""
+    return _runtime().compile(query)
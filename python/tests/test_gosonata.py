@@ -0,0 +1,58 @@
+import os
+import unittest
+
+try:
+    import gosonata
+except ImportError:  # wasmtime not installed
+    gosonata = None
+
+WASM_BUILT = gosonata is not None and os.path.exists(gosonata.WASM_PATH)
+
+
+@unittest.skipUnless(WASM_BUILT, "wasmtime or gosonata.wasm missing")
+class GoSonataTest(unittest.TestCase):
+    data = {
+        "items": [
+            {"name": "Widget", "price": 49.99},
+            {"name": "Gadget", "price": 149.99},
+        ]
+    }
+
+    def test_evaluate(self):
+        self.assertEqual(gosonata.evaluate("$count(items)", self.data), 2)
+        self.assertEqual(gosonata.evaluate("items[price > 100].name", self.data), "Gadget")
+        self.assertEqual(gosonata.evaluate("1 + 2 * 3"), 7)
+
+    def test_bindings_and_options(self):
+        self.assertEqual(gosonata.evaluate("$x * 2", bindings={"x": 21}), 42)
+        expr = gosonata.compile("$sum(items.price) > $limit")
+        self.assertTrue(expr.evaluate(self.data, bindings={"limit": 100}, timeout_ms=1000))
+        with self.assertRaises(gosonata.EvaluationError):
+            gosonata.evaluate(
+                "($f := function($n) { $n = 0 ? 0 : 1 + $f($n - 1) }; $f(100))",
+                max_depth=10,
+            )
+
+    def test_undefined_is_none(self):
+        self.assertIsNone(gosonata.evaluate("missing", self.data))
+
+    def test_compile(self):
+        expr = gosonata.compile("$sum(items.price)")
+        for _ in range(2):
+            self.assertAlmostEqual(expr.evaluate(self.data), 199.98)
+
+    def test_compile_error(self):
+        with self.assertRaises(gosonata.CompileError) as cm:
+            gosonata.compile("items[")
+        self.assertEqual(cm.exception.code, "S0201")
+        self.assertEqual(cm.exception.position, 6)
+
+    def test_evaluation_error(self):
+        with self.assertRaises(gosonata.EvaluationError) as cm:
+            gosonata.evaluate('1 + "a"')
+        self.assertEqual(cm.exception.code, "T2001")
+        self.assertIsInstance(cm.exception, gosonata.GoSonataError)
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -14,6 +14,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/sandrolain/gosonata/pkg/evaluator"
@@ -241,6 +243,24 @@ func BenchmarkEvalAggregation_XL(b *testing.B) {
 	}
 }
 
+// BenchmarkEvalAggregation_Repeated evaluates the same filtered path twice,
+// with and without common subexpression elimination.
+func BenchmarkEvalAggregation_Repeated(b *testing.B) {
+	const query = "$sum($.users[active = true].salary) / $count($.users[active = true].salary)"
+	for _, optimize := range []bool{false, true} {
+		b.Run(fmt.Sprintf("optimize=%t", optimize), func(b *testing.B) {
+			expr, err := parser.Compile(query, parser.WithOptimize(optimize))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runEval(b, expr, xlData)
+			}
+		})
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Evaluation – object transformation
 // ---------------------------------------------------------------------------
@@ -302,6 +322,18 @@ func BenchmarkEvalStringConcat(b *testing.B) {
 	}
 }
 
+func BenchmarkEvalConcatChain(b *testing.B) {
+	terms := make([]string, 200)
+	for i := range terms {
+		terms[i] = "$string(" + strconv.Itoa(i) + ")"
+	}
+	expr := mustParse(strings.Join(terms, " & "))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runEval(b, expr, nil)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Evaluation – sorting
 // ---------------------------------------------------------------------------
@@ -322,6 +354,141 @@ func BenchmarkEvalSort_Large(b *testing.B) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// Evaluation – higher-order functions
+// ---------------------------------------------------------------------------
+
+func BenchmarkEvalMapInPath_Large(b *testing.B) {
+	expr := mustParse("($k := 2; $.users.$map([age, salary], function($v) { $v * $k }))")
+	// Decoded JSON, so that users is a []interface{} iterated by the path.
+	var data interface{}
+	if err := json.Unmarshal(largeJSON, &data); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runEval(b, expr, data)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Evaluation – sharded root array
+// ---------------------------------------------------------------------------
+
+func benchmarkRootArray(b *testing.B, eval *evaluator.Evaluator) {
+	expr := mustParse(`$.{"id": id, "label": $uppercase(name) & " (" & department & ")", "net": $round(salary * 0.77, 2)}`)
+	var data interface{}
+	if err := json.Unmarshal(largeJSON, &data); err != nil {
+		b.Fatal(err)
+	}
+	users := data.(map[string]interface{})["users"].([]interface{})
+	root := make([]interface{}, 0, 100*len(users))
+	for i := 0; i < 100; i++ {
+		root = append(root, users...)
+	}
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := eval.Eval(ctx, expr, root); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvalRootArray_Sequential(b *testing.B) {
+	benchmarkRootArray(b, evaluator.New())
+}
+
+func BenchmarkEvalRootArray_Sharded(b *testing.B) {
+	benchmarkRootArray(b, evaluator.New(evaluator.WithShardSize(512)))
+}
+
+// ---------------------------------------------------------------------------
+// Evaluation – intermediate objects
+// ---------------------------------------------------------------------------
+
+func benchmarkIntermediateObjects(b *testing.B, eval *evaluator.Evaluator) {
+	expr := mustParse(`$sum($.users.projects.{"p": $, "w": {"v": 1}}.w.v)`)
+	var data interface{}
+	if err := json.Unmarshal(largeJSON, &data); err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := eval.Eval(ctx, expr, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvalIntermediateObjects_Heap(b *testing.B) {
+	benchmarkIntermediateObjects(b, evaluator.New())
+}
+
+func BenchmarkEvalIntermediateObjects_Arena(b *testing.B) {
+	benchmarkIntermediateObjects(b, evaluator.New(evaluator.WithObjectArena(true)))
+}
+
+// ---------------------------------------------------------------------------
+// Evaluation – positional variable bindings
+// ---------------------------------------------------------------------------
+
+func BenchmarkEvalPositionalBindings_Large(b *testing.B) {
+	expr := mustParse(`$.users#$i.projects#$j.{"i": $i, "j": $j, "project": $}`)
+	var data interface{}
+	if err := json.Unmarshal(largeJSON, &data); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runEval(b, expr, data)
+	}
+}
+
+// BenchmarkEvalAggregateTupleStream_Large aggregates a sequence carrying
+// positional bindings, which the aggregates read without unwrapping copies.
+func BenchmarkEvalAggregateTupleStream_Large(b *testing.B) {
+	expr := mustParse(`$sum($.users#$i[$i % 2 = 0].salary) + $count($.users#$i.projects)`)
+	var data interface{}
+	if err := json.Unmarshal(largeJSON, &data); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runEval(b, expr, data)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Evaluation – structural equality
+// ---------------------------------------------------------------------------
+
+func BenchmarkEvalDistinctObjects_Large(b *testing.B) {
+	expr := mustParse(`$distinct($.users.{"department": department, "active": active})`)
+	var data interface{}
+	if err := json.Unmarshal(largeJSON, &data); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runEval(b, expr, data)
+	}
+}
+
+func BenchmarkEvalEqualObjectsInFilter_Large(b *testing.B) {
+	expr := mustParse(`$.users[{"department": department, "active": active} = {"department": "Sales", "active": false}]`)
+	var data interface{}
+	if err := json.Unmarshal(largeJSON, &data); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runEval(b, expr, data)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Full pipeline (compile + eval)
 // ---------------------------------------------------------------------------
@@ -0,0 +1,144 @@
+#!/usr/bin/env node
+/**
+ * Differential testing runner: evaluates a batch of cases with JSONata JS
+ * and/or GoSonata WASM (js/wasm target) in a single Node.js process.
+ *
+ * stdin:
+ *   {
+ *     "engines": ["jsonata", "wasm"],
+ *     "cases": [{ "query": "...", "data": ..., "bindings": {...} }, ...]
+ *   }
+ *
+ * stdout:
+ *   {
+ *     "outcomes": { "jsonata": [outcome, ...], "wasm": [outcome, ...] },
+ *     "unavailable": { "<engine>": "<reason>" }
+ *   }
+ *
+ * with one outcome per case, in order:
+ *   { "result": ... }                     a defined result
+ *   { "undefined": true }                 an undefined result
+ *   { "error": "T2001", "message": "..." } an error and its code ("" if none)
+ *
+ * The WASM build returns undefined as null, so its outcomes never report
+ * undefined. Engines that cannot be loaded, or that fail altogether (the Go
+ * program of the WASM build exiting on a panic), are listed in "unavailable".
+ */
+
+"use strict";
+
+const path = require("path");
+const fs = require("fs");
+
+const root = path.join(__dirname, "..", "..");
+
+// errorOutcome maps a thrown error onto an outcome, extracting the JSONata
+// error code from the message when the error has no code property.
+function errorOutcome(err) {
+  const message = (err && err.message) || String(err);
+  let code = (err && err.code) || "";
+  if (!code) {
+    const m = /\b([A-Z]\d{4})\b/.exec(message);
+    if (m) code = m[1];
+  }
+  return { error: code, message };
+}
+
+// EngineFailure stops the evaluation of all cases with an engine.
+class EngineFailure extends Error {}
+
+function valueOutcome(result) {
+  return result === undefined ? { undefined: true } : { result };
+}
+
+async function loadJSONata() {
+  const modulePath =
+    process.env.JSONATA_JS ||
+    path.join(root, "tests", "conformance", "node_modules", "jsonata");
+  const jsonata = require(modulePath);
+  return async (c) => {
+    try {
+      const expr = jsonata(c.query);
+      return valueOutcome(await expr.evaluate(c.data, c.bindings || undefined));
+    } catch (err) {
+      return errorOutcome(err);
+    }
+  };
+}
+
+async function loadWASM() {
+  require(
+    process.env.WASM_EXEC_JS ||
+      path.join(root, "cmd", "wasm", "js", "wasm_exec.js"),
+  );
+  const wasmPath =
+    process.env.GOSONATA_WASM ||
+    path.join(root, "cmd", "wasm", "js", "gosonata.wasm");
+  const go = new Go(); // eslint-disable-line no-undef
+  const { instance } = await WebAssembly.instantiate(
+    fs.readFileSync(wasmPath),
+    go.importObject,
+  );
+  go.run(instance);
+  await new Promise((r) => setImmediate(r));
+  return async (c) => {
+    let outcome;
+    try {
+      const opts = c.bindings ? { bindings: c.bindings } : undefined;
+      const raw = globalThis.gosonata.eval(
+        c.query,
+        JSON.stringify(c.data === undefined ? null : c.data),
+        opts,
+      );
+      outcome = valueOutcome(JSON.parse(raw));
+    } catch (err) {
+      outcome = errorOutcome(err);
+    }
+    if (go.exited) {
+      throw new EngineFailure(`Go program exited evaluating ${c.query}`);
+    }
+    return outcome;
+  };
+}
+
+const loaders = { jsonata: loadJSONata, wasm: loadWASM };
+
+async function main() {
+  let input = "";
+  process.stdin.setEncoding("utf8");
+  for await (const chunk of process.stdin) input += chunk;
+  const { engines, cases } = JSON.parse(input);
+
+  const out = { outcomes: {}, unavailable: {} };
+  for (const name of engines) {
+    const load = loaders[name];
+    if (!load) {
+      out.unavailable[name] = "unknown engine";
+      continue;
+    }
+    let run;
+    try {
+      run = await load();
+    } catch (err) {
+      out.unavailable[name] = (err && err.message) || String(err);
+      continue;
+    }
+    const outcomes = [];
+    try {
+      for (const c of cases) outcomes.push(await run(c));
+    } catch (err) {
+      if (!(err instanceof EngineFailure)) throw err;
+      out.unavailable[name] = err.message;
+      continue;
+    }
+    out.outcomes[name] = outcomes;
+  }
+  // The Go runtime of the WASM build keeps the process alive: exit once the
+  // output is flushed.
+  process.stdout.write(JSON.stringify(out) + "\n", () => process.exit(0));
+}
+
+main().catch((err) => {
+  process.stderr.write(((err && err.stack) || String(err)) + "\n");
+  process.exit(1);
+});
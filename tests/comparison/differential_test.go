@@ -0,0 +1,327 @@
+package comparison_test
+
+// Differential testing against JSONata JS.
+//
+// TestDifferential evaluates every case of a corpus with GoSonata natively and,
+// in a single Node.js process (diff_runner.js), with the official JSONata JS
+// and with the GoSonata WASM build, then reports each case on which an engine
+// disagrees with the native result. Unlike the imported conformance suite, the
+// corpus is open-ended: any directory of pkg/testkit case files can be
+// checked, e.g. the fixtures of an expression library.
+//
+//	go test -run TestDifferential -v -count=1 ./tests/comparison/...
+//	go test -run TestDifferential -v -count=1 ./tests/comparison/... \
+//	    -differential.corpus=../../path/to/cases -differential.report=parity.json
+//
+// Expected results of the case files are ignored: the engines are compared
+// with each other. Cases listed in known_differences.txt, next to the corpus
+// cases, are reported without failing the test; a listed case on which the
+// engines agree fails it, so that the list stays accurate.
+//
+// The WASM build is compiled from the current tree for the test, unless
+// GOSONATA_WASM points to a binary. The test is skipped when node is not
+// installed; an engine that cannot be loaded (JSONata JS not installed in
+// tests/conformance, a WASM build failing) is skipped with a log line.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/testkit"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+var (
+	differentialCorpus  = flag.String("differential.corpus", "", "directory of testkit cases to compare (default testdata/differential)")
+	differentialEngines = flag.String("differential.engines", "jsonata,wasm", "comma-separated engines to compare with: jsonata, wasm")
+	differentialReport  = flag.String("differential.report", "", "file to write the JSON mismatch report to")
+)
+
+// diffOutcome is the outcome of a case in one engine, as reported by
+// diff_runner.js.
+type diffOutcome struct {
+	Result    interface{} `json:"result,omitempty"`
+	Undefined bool        `json:"undefined,omitempty"`
+	Error     *string     `json:"error,omitempty"`
+	Message   string      `json:"message,omitempty"`
+}
+
+func (o diffOutcome) String() string {
+	switch {
+	case o.Error != nil:
+		if *o.Error == "" {
+			return "error: " + o.Message
+		}
+		return "error " + *o.Error
+	case o.Undefined:
+		return "undefined"
+	}
+	b, _ := json.Marshal(o.Result)
+	return string(b)
+}
+
+// diffMismatch is one entry of the report.
+type diffMismatch struct {
+	Case   string   `json:"case"`
+	Query  string   `json:"query"`
+	Engine string   `json:"engine"`
+	Native string   `json:"native"`
+	Other  string   `json:"other"`
+	Diff   []string `json:"diff,omitempty"`
+	Known  bool     `json:"known,omitempty"`
+}
+
+func diffRunnerPath() string {
+	_, thisFile, _, ok := runtime.Caller(0)
+	if ok {
+		return filepath.Join(filepath.Dir(thisFile), "diff_runner.js")
+	}
+	return filepath.Join("tests", "comparison", "diff_runner.js")
+}
+
+func differentialCorpusDir() string {
+	if *differentialCorpus != "" {
+		return *differentialCorpus
+	}
+	_, thisFile, _, ok := runtime.Caller(0)
+	if ok {
+		return filepath.Join(filepath.Dir(thisFile), "testdata", "differential")
+	}
+	return filepath.Join("tests", "comparison", "testdata", "differential")
+}
+
+// loadKnownDifferences reads the case names listed in known_differences.txt,
+// one per line; # starts a comment.
+func loadKnownDifferences(dir string) (map[string]bool, error) {
+	known := map[string]bool{}
+	f, err := os.Open(filepath.Join(dir, "known_differences.txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if name := strings.TrimSpace(line); name != "" {
+			known[name] = true
+		}
+	}
+	return known, scanner.Err()
+}
+
+// evalNative evaluates c with GoSonata. Null is kept distinct from undefined.
+func evalNative(c *testkit.Case) diffOutcome {
+	expr, err := parser.Compile(c.Expr)
+	if err == nil {
+		var result interface{}
+		ev := evaluator.New(evaluator.WithNullHandling(evaluator.NullAsValue))
+		result, err = ev.EvalWithBindings(context.Background(), expr, c.Data, c.Bindings)
+		if err == nil {
+			if result == nil {
+				return diffOutcome{Undefined: true}
+			}
+			return diffOutcome{Result: jsonRoundTrip(result)}
+		}
+	}
+	code := ""
+	var jerr *types.Error
+	if errors.As(err, &jerr) {
+		code = string(jerr.Code)
+	}
+	return diffOutcome{Error: &code, Message: err.Error()}
+}
+
+// runDiffEngines evaluates the cases with the given engines in Node.js.
+func runDiffEngines(t *testing.T, engines []string, cases []*testkit.Case) (map[string][]diffOutcome, map[string]string) {
+	t.Helper()
+	type runnerCase struct {
+		Query    string                 `json:"query"`
+		Data     interface{}            `json:"data,omitempty"`
+		Bindings map[string]interface{} `json:"bindings,omitempty"`
+	}
+	input := struct {
+		Engines []string     `json:"engines"`
+		Cases   []runnerCase `json:"cases"`
+	}{Engines: engines}
+	for _, c := range cases {
+		input.Cases = append(input.Cases, runnerCase{Query: c.Expr, Data: c.Data, Bindings: c.Bindings})
+	}
+	payload, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("node", diffRunnerPath())
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = os.Environ()
+	for _, engine := range engines {
+		if engine == "wasm" && os.Getenv("GOSONATA_WASM") == "" {
+			cmd.Env = append(cmd.Env, buildDiffWASM(t)...)
+		}
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("diff_runner.js: %v\n%s", err, stderr.String())
+	}
+	var output struct {
+		Outcomes    map[string][]diffOutcome `json:"outcomes"`
+		Unavailable map[string]string        `json:"unavailable"`
+	}
+	// The report is the last line: the WASM build may print before it.
+	out = bytes.TrimRight(out, "\n")
+	if i := bytes.LastIndexByte(out, '\n'); i >= 0 {
+		out = out[i+1:]
+	}
+	if err := json.Unmarshal(out, &output); err != nil {
+		t.Fatalf("diff_runner.js output: %v\n%s", err, out)
+	}
+	return output.Outcomes, output.Unavailable
+}
+
+// buildDiffWASM compiles the js/wasm build of the current tree and returns the
+// environment pointing diff_runner.js to it and to the wasm_exec.js of the same
+// toolchain. It returns nil, leaving the runner to the checked-in binary, when
+// the build fails.
+func buildDiffWASM(t *testing.T) []string {
+	t.Helper()
+	_, thisFile, _, _ := runtime.Caller(0)
+	root := filepath.Join(filepath.Dir(thisFile), "..", "..")
+	bin := filepath.Join(t.TempDir(), "gosonata.wasm")
+	build := exec.Command("go", "build", "-o", bin, "./cmd/wasm/js/")
+	build.Dir = root
+	build.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Logf("building the WASM engine: %v\n%s", err, out)
+		return nil
+	}
+	env := []string{"GOSONATA_WASM=" + bin}
+	goroot, err := exec.Command("go", "env", "GOROOT").Output()
+	if err == nil {
+		execJS := filepath.Join(strings.TrimSpace(string(goroot)), "lib", "wasm", "wasm_exec.js")
+		if _, err := os.Stat(execJS); err == nil {
+			env = append(env, "WASM_EXEC_JS="+execJS)
+		}
+	}
+	return env
+}
+
+// compareOutcomes returns the differences between the native outcome and the
+// outcome of another engine, or nil when they agree. Errors agree when their
+// codes do. When undefinedAsNull is set (the WASM build), a native undefined
+// agrees with a null result.
+func compareOutcomes(native, other diffOutcome, undefinedAsNull bool) []string {
+	switch {
+	case native.Error != nil || other.Error != nil:
+		if native.Error != nil && other.Error != nil && (*native.Error == *other.Error || *native.Error == "" || *other.Error == "") {
+			return nil
+		}
+	case native.Undefined && other.Undefined:
+		return nil
+	case native.Undefined && undefinedAsNull && other.Result == nil:
+		return nil
+	case !native.Undefined && !other.Undefined:
+		diff := testkit.Diff(other.Result, native.Result)
+		if diff == "" {
+			return nil
+		}
+		// Keep the difference lines, without the rendered result.
+		head, _, _ := strings.Cut(diff, "got:\n")
+		var lines []string
+		for _, line := range strings.Split(strings.TrimRight(head, "\n"), "\n") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		return lines
+	}
+	return []string{fmt.Sprintf("%s vs %s", native, other)}
+}
+
+func TestDifferential(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not found")
+	}
+	dir := differentialCorpusDir()
+	cases, err := testkit.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("no cases in %s", dir)
+	}
+	known, err := loadKnownDifferences(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outcomes, unavailable := runDiffEngines(t, strings.Split(*differentialEngines, ","), cases)
+	for engine, reason := range unavailable {
+		t.Logf("engine %s skipped: %s", engine, reason)
+	}
+	if len(outcomes) == 0 {
+		t.Skip("no engine available")
+	}
+	engines := make([]string, 0, len(outcomes))
+	for engine := range outcomes {
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+
+	var report []diffMismatch
+	for i, c := range cases {
+		native := evalNative(c)
+		t.Run(c.Name, func(t *testing.T) {
+			agree := true
+			for _, engine := range engines {
+				other := outcomes[engine][i]
+				lines := compareOutcomes(native, other, engine == "wasm")
+				if lines == nil {
+					continue
+				}
+				agree = false
+				m := diffMismatch{
+					Case: c.Name, Query: c.Expr, Engine: engine,
+					Native: native.String(), Other: other.String(), Diff: lines, Known: known[c.Name],
+				}
+				report = append(report, m)
+				msg := fmt.Sprintf("%s: GoSonata and %s differ:\n  %s", c.Expr, engine, strings.Join(lines, "\n  "))
+				if m.Known {
+					t.Log("known difference: " + msg)
+				} else {
+					t.Error(msg)
+				}
+			}
+			if agree && known[c.Name] {
+				t.Errorf("listed in known_differences.txt but all engines agree: remove it")
+			}
+		})
+	}
+	t.Logf("%d cases, %d mismatches with %s", len(cases), len(report), strings.Join(engines, ", "))
+
+	if *differentialReport != "" {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(*differentialReport, append(out, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
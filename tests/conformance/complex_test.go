@@ -7,6 +7,7 @@ package conformance_test
 
 import (
 	"context"
+	"encoding/json"
 	"reflect"
 	"testing"
 
@@ -1007,6 +1008,60 @@ func TestComplexSortingOrderBy(t *testing.T) {
 			t.Errorf("last should be cheapest (Trilby hat), got %v", arr[3])
 		}
 	})
+
+	// Sorting a tuple stream keeps the variables bound by @$ / #$ (and the
+	// parent reached by %) with each item, through the stages that follow.
+	joinSortTests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			"index_binding_survives_sort",
+			"Account.Order#$o.Product^(Price).{\"name\": `Product Name`, \"order\": $o}",
+			`[{"name":"Trilby hat","order":0},{"name":"Bowler Hat","order":0},{"name":"Bowler Hat","order":1},{"name":"Cloak","order":1}]`,
+		},
+		{
+			"sort_then_filter_by_index",
+			`Account.Order#$o.Product^(>Price)[$o = 1]."Product Name"`,
+			`["Cloak","Bowler Hat"]`,
+		},
+		{
+			"sort_then_group_by_with_binding",
+			"Account.Order#$o.Product^(Price){`Product Name`: $o}",
+			`{"Trilby hat":0,"Bowler Hat":[0,1],"Cloak":1}`,
+		},
+		{
+			"sort_then_group_by",
+			"Account.Order.Product^(>Price){`Product Name`: $sum(Quantity)}",
+			`{"Cloak":1,"Bowler Hat":6,"Trilby hat":1}`,
+		},
+		{
+			"sort_keeps_parent_after_index_binding",
+			"Account.Order.Product#$i^(>Price).{\"name\": `Product Name`, \"order\": %.OrderID, \"i\": $i}",
+			`[{"name":"Cloak","order":"order104","i":1},{"name":"Bowler Hat","order":"order103","i":0},{"name":"Bowler Hat","order":"order104","i":0},{"name":"Trilby hat","order":"order103","i":1}]`,
+		},
+		{
+			"focus_binding_join_sort_filter",
+			`Account.Order@$o.$o.Product^(>Price)[$o.OrderID = "order103"]."Product Name"`,
+			`["Bowler Hat","Trilby hat"]`,
+		},
+	}
+	for _, tc := range joinSortTests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := evalComplex(t, tc.query, AccountData)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := json.Marshal(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("%s\n  got:  %s\n  want: %s", tc.query, got, tc.want)
+			}
+		})
+	}
 }
 
 // ---------------------------------------------------------------------------
@@ -0,0 +1,317 @@
+package property
+
+// Property-based tests of algebraic laws of the operators and functions.
+//
+// Each test states a law over arbitrary values and checks it with
+// testing/quick on randomly generated JSON documents, numbers and strings,
+// catching the regressions that hold on the conformance examples but not in
+// general. A failing check reports the generated arguments. Each law is
+// checked on 3 × -quickchecks cases (300 by default):
+//
+//	go test -v -count=1 ./tests/property/
+//	go test -count=1 ./tests/property/ -quickchecks=5000
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"testing/quick"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// jsonValue is an arbitrary JSON value: null, a boolean, a number, a string,
+// or an array or object of JSON values.
+type jsonValue struct{ v interface{} }
+
+func (jsonValue) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(jsonValue{genValue(r, 3)})
+}
+
+// scalar is a number, a string or a boolean, with few distinct values so that
+// equal values are frequent.
+type scalar struct{ v interface{} }
+
+func (scalar) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(scalar{genScalar(r)})
+}
+
+// number is a number with at most 15 significant digits, the precision
+// $string keeps.
+type number float64
+
+func (number) Generate(r *rand.Rand, size int) reflect.Value {
+	f := r.NormFloat64() * float64(r.Intn(6)+1) * 1e3
+	f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', r.Intn(15)+1, 64), 64)
+	return reflect.ValueOf(number(f))
+}
+
+// runes of the generated strings: quotes, escapes, control characters and
+// multi-byte runes besides letters.
+var runes = []rune(`ab"\/` + "\n\t\x01é世\U0001F600")
+
+func genString(r *rand.Rand) string {
+	b := make([]rune, r.Intn(6))
+	for i := range b {
+		b[i] = runes[r.Intn(len(runes))]
+	}
+	return string(b)
+}
+
+func genScalar(r *rand.Rand) interface{} {
+	switch r.Intn(3) {
+	case 0:
+		return float64(r.Intn(5))
+	case 1:
+		return []string{"a", "b", "1", ""}[r.Intn(4)]
+	}
+	return r.Intn(2) == 0
+}
+
+func genValue(r *rand.Rand, depth int) interface{} {
+	n := 6
+	if depth == 0 {
+		n = 4
+	}
+	switch r.Intn(n) {
+	case 0:
+		return nil
+	case 1:
+		return r.Intn(2) == 0
+	case 2:
+		return float64(r.Intn(2001)-1000) / 8
+	case 3:
+		return genString(r)
+	case 4:
+		arr := make([]interface{}, r.Intn(4))
+		for i := range arr {
+			arr[i] = genValue(r, depth-1)
+		}
+		return arr
+	}
+	obj := map[string]interface{}{}
+	for i := r.Intn(4); i > 0; i-- {
+		obj[genString(r)] = genValue(r, depth-1)
+	}
+	return obj
+}
+
+var (
+	ev        = evaluator.New(evaluator.WithNullHandling(evaluator.NullAsValue))
+	compiled  = map[string]*types.Expression{}
+	compileMu sync.Mutex
+)
+
+// eval evaluates query with the given variables. JSON null is passed as
+// types.NullValue, as nil would be undefined.
+func eval(t *testing.T, query string, vars map[string]interface{}) (interface{}, error) {
+	t.Helper()
+	compileMu.Lock()
+	expr, ok := compiled[query]
+	if !ok {
+		var err error
+		if expr, err = parser.Compile(query); err != nil {
+			compileMu.Unlock()
+			t.Fatalf("%s: %v", query, err)
+		}
+		compiled[query] = expr
+	}
+	compileMu.Unlock()
+	bindings := make(map[string]interface{}, len(vars))
+	for name, v := range vars {
+		bindings[name] = toInput(v)
+	}
+	return ev.EvalWithBindings(context.Background(), expr, nil, bindings)
+}
+
+func toInput(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return types.NullValue
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, item := range x {
+			out[i] = toInput(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			out[k] = toInput(item)
+		}
+		return out
+	}
+	return v
+}
+
+// normalize returns the JSON value of v, so that results can be compared
+// whatever the Go types of their containers.
+func normalize(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal %v: %v", v, err)
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("unmarshal %s: %v", b, err)
+	}
+	return out
+}
+
+// same evaluates two queries with the same variables and reports whether
+// they agree: equal results, or both failing.
+func same(t *testing.T, q1, q2 string, vars map[string]interface{}) bool {
+	t.Helper()
+	r1, err1 := eval(t, q1, vars)
+	r2, err2 := eval(t, q2, vars)
+	if err1 != nil || err2 != nil {
+		if (err1 == nil) != (err2 == nil) {
+			t.Logf("%s: %v\n%s: %v", q1, err1, q2, err2)
+			return false
+		}
+		return true
+	}
+	if !reflect.DeepEqual(normalize(t, r1), normalize(t, r2)) {
+		t.Logf("%s = %v\n%s = %v", q1, normalize(t, r1), q2, normalize(t, r2))
+		return false
+	}
+	return true
+}
+
+func check(t *testing.T, law interface{}) {
+	t.Helper()
+	if err := quick.Check(law, &quick.Config{MaxCountScale: 3}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAppendAssociative(t *testing.T) {
+	check(t, func(a, b, c jsonValue) bool {
+		return same(t, `$append($append($a, $b), $c)`, `$append($a, $append($b, $c))`,
+			map[string]interface{}{"a": a.v, "b": b.v, "c": c.v})
+	})
+}
+
+// TestFilterMapFusion compares the results as arrays ([...]): a filter step
+// keeps a single match in an array where $filter returns it alone.
+func TestFilterMapFusion(t *testing.T) {
+	check(t, func(xs []number, limit number) bool {
+		vars := map[string]interface{}{"xs": numbers(xs), "t": float64(limit)}
+		return same(t,
+			`[$map($filter($xs, function($v) { $v > $t }), function($v) { $v * 2 })]`,
+			`[$xs[$ > $t].($ * 2)]`, vars) &&
+			same(t,
+				`$map($map($xs, function($v) { $v + 1 }), function($v) { $v * 2 })`,
+				`$map($xs, function($v) { ($v + 1) * 2 })`, vars) &&
+			same(t,
+				`[$filter($filter($xs, function($v) { $v > $t }), function($v) { $v < 0 })]`,
+				`[$xs[$ > $t][$ < 0]]`, vars) &&
+			same(t, `[$xs[$ > $t][$ < 0]]`, `[$xs[$ > $t and $ < 0]]`, vars)
+	})
+}
+
+func numbers(xs []number) []interface{} {
+	out := make([]interface{}, len(xs))
+	for i, x := range xs {
+		out[i] = float64(x)
+	}
+	return out
+}
+
+func TestSortStable(t *testing.T) {
+	check(t, func(keys []uint8) bool {
+		xs := make([]interface{}, len(keys))
+		for i, k := range keys {
+			xs[i] = map[string]interface{}{"k": float64(k % 4), "i": float64(i)}
+		}
+		want := append([]interface{}{}, xs...)
+		sort.SliceStable(want, func(i, j int) bool {
+			return want[i].(map[string]interface{})["k"].(float64) < want[j].(map[string]interface{})["k"].(float64)
+		})
+		vars := map[string]interface{}{"xs": xs}
+		for _, query := range []string{
+			`[$sort($xs, function($a, $b) { $a.k > $b.k })]`,
+			`[$xs^(k)]`,
+			`[$sort($sort($xs, function($a, $b) { $a.k > $b.k }), function($a, $b) { $a.k > $b.k })]`,
+		} {
+			got, err := eval(t, query, vars)
+			if err != nil {
+				t.Logf("%s: %v", query, err)
+				return false
+			}
+			if !reflect.DeepEqual(normalize(t, got), normalize(t, want)) {
+				t.Logf("%s = %v, want %v", query, normalize(t, got), normalize(t, want))
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestEqualityLaws(t *testing.T) {
+	check(t, func(x scalar, ys []scalar) bool {
+		items := make([]interface{}, len(ys))
+		for i, y := range ys {
+			items[i] = y.v
+		}
+		r, err := eval(t, `($x in $ys) = ($count($filter($ys, function($v) { $v = $x })) > 0)`,
+			map[string]interface{}{"x": x.v, "ys": items})
+		if err != nil || r != true {
+			t.Logf("in and = disagree on %v in %v: %v %v", x.v, items, r, err)
+			return false
+		}
+		return true
+	})
+	check(t, func(a, b jsonValue) bool {
+		r, err := eval(t, `[$a = $a, ($a = $b) = ($b = $a), ($a != $b) = ($a = $b ? false : true)]`,
+			map[string]interface{}{"a": a.v, "b": b.v})
+		if err != nil || !reflect.DeepEqual(r, []interface{}{true, true, true}) {
+			t.Logf("a = %v, b = %v: %v %v", a.v, b.v, r, err)
+			return false
+		}
+		return true
+	})
+}
+
+func TestSerializationRoundTrip(t *testing.T) {
+	check(t, func(v jsonValue) bool {
+		vars := map[string]interface{}{"v": v.v}
+		// $string serializes containers as JSON, which $eval reads back.
+		r, err := eval(t, `$eval($string({"v": $v})).v`, vars)
+		if err != nil || !reflect.DeepEqual(normalize(t, r), v.v) {
+			t.Logf("$eval($string({\"v\": %v})).v = %v, %v", v.v, r, err)
+			return false
+		}
+		// Results marshal to the JSON of the value.
+		r, err = eval(t, `$v`, vars)
+		if err != nil || !reflect.DeepEqual(normalize(t, r), v.v) {
+			t.Logf("$v = %v, %v, want %v", r, err, v.v)
+			return false
+		}
+		return true
+	})
+	check(t, func(n number) bool {
+		r, err := eval(t, `$number($string($n))`, map[string]interface{}{"n": float64(n)})
+		if err != nil || r != float64(n) {
+			t.Logf("$number($string(%v)) = %v, %v", float64(n), r, err)
+			return false
+		}
+		return true
+	})
+	check(t, func(s string) bool {
+		r, err := eval(t, `$eval($string({"s": $s})).s`, map[string]interface{}{"s": s})
+		if err != nil || r != s {
+			t.Logf("%q round-trips as %v, %v", s, r, err)
+			return false
+		}
+		return true
+	})
+}
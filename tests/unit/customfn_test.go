@@ -2,11 +2,15 @@ package unit_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/sandrolain/gosonata/pkg/evaluator"
 	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
 )
 
 func TestCustomFunctionBasic(t *testing.T) {
@@ -125,3 +129,229 @@ func TestCustomFunctionContextPropagation(t *testing.T) {
 		t.Fatalf("expected context value to propagate, got %v", result)
 	}
 }
+
+// TestBuiltinLoopsHonourCancellation passes native callbacks, which never go
+// through evalNode's own cancellation check, so the built-in loops must notice the
+// cancelled context themselves.
+func TestBuiltinLoopsHonourCancellation(t *testing.T) {
+	const n = 100000
+	items := make([]interface{}, n)
+	obj := make(map[string]interface{}, n)
+	for i := range items {
+		items[i] = float64(i)
+		obj[fmt.Sprintf("k%d", i)] = float64(i)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"map", `$map(items, $tick)`},
+		{"filter", `$filter(items, $tick)`},
+		{"reduce", `$reduce(items, $tick, 0)`},
+		{"single", `$single(items, $tick)`},
+		{"sort", `$sort(items, $tick)`},
+		{"each", `$each(obj, $tick)`},
+		{"sift", `$sift(obj, $tick)`},
+		{"replace", `$replace(text, /a/, $tick)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			calls := 0
+			// tick cancels the evaluation on its 10th call and returns undefined.
+			tick := &evaluator.FunctionDef{
+				Name:    "tick",
+				MinArgs: 2, // satisfies $reduce; the other built-ins do not check it
+				MaxArgs: -1,
+				Impl: func(context.Context, *evaluator.Evaluator, *evaluator.EvalContext, []interface{}) (interface{}, error) {
+					calls++
+					if calls == 10 {
+						cancel()
+					}
+					return nil, nil
+				},
+			}
+			expr, err := parser.Compile(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := map[string]interface{}{
+				"items": items,
+				"obj":   obj,
+				"text":  strings.Repeat("a", n),
+			}
+			_, err = evaluator.New().EvalWithBindings(ctx, expr, data, map[string]interface{}{"tick": tick})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected context.Canceled, got %v", err)
+			}
+			// $sort calls the comparator twice per comparison.
+			if calls > 10+2*64 {
+				t.Errorf("loop kept running after cancellation: %d calls", calls)
+			}
+		})
+	}
+}
+
+func TestFunctionRegistryIsPerEvaluator(t *testing.T) {
+	shout := func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		s, _ := args[0].(string)
+		return strings.ToUpper(s) + "!", nil
+	}
+	custom := evaluator.New(evaluator.WithCustomFunction("uppercase", "", shout))
+	plain := evaluator.New()
+	noClock := evaluator.New(evaluator.WithoutFunctions("now", "millis"))
+
+	expr := mustCompile(t, `$uppercase("hi")`)
+	if got, err := custom.Eval(context.Background(), expr, nil); err != nil || got != "HI!" {
+		t.Fatalf("override: got %v, %v", got, err)
+	}
+	if got, err := plain.Eval(context.Background(), expr, nil); err != nil || got != "HI" {
+		t.Fatalf("override leaked into another evaluator: got %v, %v", got, err)
+	}
+
+	now := mustCompile(t, `$now()`)
+	if _, err := noClock.Eval(context.Background(), now, nil); err == nil {
+		t.Fatal("expected $now() to fail when removed")
+	}
+	if _, err := plain.Eval(context.Background(), now, nil); err != nil {
+		t.Fatalf("$now() removed from the default registry: %v", err)
+	}
+	if _, ok := noClock.LookupFunction("now"); ok {
+		t.Error("LookupFunction(now) should fail after WithoutFunctions")
+	}
+	if fn, ok := custom.LookupFunction("uppercase"); !ok || fn.Name != "uppercase" {
+		t.Errorf("LookupFunction(uppercase) = %v, %v", fn, ok)
+	}
+	if _, ok := evaluator.GetFunction("now"); !ok {
+		t.Error("GetFunction(now) should still see the default registry")
+	}
+	names := noClock.FunctionNames()
+	if slices.Contains(names, "now") || !slices.Contains(names, "sum") || !slices.IsSorted(names) {
+		t.Errorf("FunctionNames() = %v", names)
+	}
+}
+
+func TestCustomFunctionAsValue(t *testing.T) {
+	double := func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		n, _ := args[0].(float64)
+		return n * 2, nil
+	}
+	ev := evaluator.New(evaluator.WithCustomFunction("double", "", double))
+	got, err := ev.Eval(context.Background(), mustCompile(t, `$map([1, 2, 3], $double)`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[2 4 6]" {
+		t.Fatalf("expected [2 4 6], got %v", got)
+	}
+}
+
+func mustCompile(t *testing.T, query string) *types.Expression {
+	t.Helper()
+	expr, err := parser.Compile(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expr
+}
+
+func TestLambdaArgumentSharedAcrossPathItems(t *testing.T) {
+	var seen []interface{}
+	keep := func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		seen = append(seen, args[0])
+		return nil, nil
+	}
+	ev := evaluator.New(evaluator.WithCustomFunction("keep", "", keep))
+	data := map[string]interface{}{
+		"orders": []interface{}{
+			map[string]interface{}{"qty": 1.0},
+			map[string]interface{}{"qty": 2.0},
+			map[string]interface{}{"qty": 3.0},
+		},
+	}
+	distinct := func(query string) int {
+		t.Helper()
+		seen = nil
+		if _, err := ev.Eval(context.Background(), mustCompile(t, query), data); err != nil {
+			t.Fatal(err)
+		}
+		if len(seen) != 3 {
+			t.Fatalf("%s: $keep called %d times, want 3", query, len(seen))
+		}
+		n := 1
+		for _, fn := range seen[1:] {
+			if fn != seen[0] {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := distinct(`($rate := 2; orders.$keep(function($i){$i * $rate}))`); n != 1 {
+		t.Errorf("context-free callback: %d function values, want 1", n)
+	}
+	if n := distinct(`orders.$keep(function($i){$i * qty})`); n != 3 {
+		t.Errorf("callback reading the item: %d function values, want 3", n)
+	}
+
+	got, err := ev.Eval(context.Background(),
+		mustCompile(t, `($rate := 2; orders.$map([qty, 10], function($v){$v * $rate}))`), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[2 20 4 20 6 20]" {
+		t.Errorf("got %v, want [2 20 4 20 6 20]", got)
+	}
+}
+
+// TestCustomFunctionPanic checks that a panic below an entry point comes back
+// as a PanicError locating the node that was being evaluated.
+func TestCustomFunctionPanic(t *testing.T) {
+	boom := func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		var m map[string]interface{}
+		m["x"] = args[0] // assignment to a nil map
+		return nil, nil
+	}
+	ev := evaluator.New(evaluator.WithCustomFunction("boom", "", boom))
+	expr := mustCompile(t, `items.($boom($))`)
+	data := map[string]interface{}{"items": []interface{}{1.0, 2.0}}
+
+	_, err := ev.Eval(context.Background(), expr, data)
+	var perr *evaluator.PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got %v, want a PanicError", err)
+	}
+	if want := strings.Index(expr.Source(), "($)"); perr.Position != want {
+		t.Errorf("position %d, want %d (the call of $boom)", perr.Position, want)
+	}
+	if len(perr.ExpressionHash) != 16 {
+		t.Errorf("expression hash %q, want 16 hex digits", perr.ExpressionHash)
+	}
+	if !strings.Contains(string(perr.Stack), "TestCustomFunctionPanic") {
+		t.Errorf("stack does not reach the panicking function:\n%s", perr.Stack)
+	}
+	var rerr interface{ RuntimeError() }
+	if !errors.As(err, &rerr) {
+		t.Errorf("Unwrap does not expose the runtime error %v", perr.Value)
+	}
+
+	// The evaluator stays usable, and other entry points recover too.
+	if _, err := ev.EvalWithBindings(context.Background(), expr, data, nil); !errors.As(err, &perr) {
+		t.Errorf("EvalWithBindings: got %v, want a PanicError", err)
+	}
+	if got, err := ev.Eval(context.Background(), mustCompile(t, `$count(items)`), data); err != nil || got != 2.0 {
+		t.Errorf("after a panic: got %v, %v", got, err)
+	}
+
+	// The nodes evaluated before the call do not move the position.
+	expr = mustCompile(t, `[items.($ + 1), $boom(1)]`)
+	if _, err := ev.Eval(context.Background(), expr, data); !errors.As(err, &perr) {
+		t.Errorf("got %v, want a PanicError", err)
+	} else if want := strings.LastIndex(expr.Source(), "("); perr.Position != want {
+		t.Errorf("position %d, want %d (the call of $boom)", perr.Position, want)
+	}
+
+}
@@ -0,0 +1,100 @@
+//go:build !minimal
+
+// The picture strings of $fromMillis and $toMillis are left out of the
+// minimal build (U1003).
+
+package unit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+)
+
+func TestFnDateTimePictures(t *testing.T) {
+	// 2017-11-07T15:12:37.121Z, a Tuesday.
+	const ms = "1510067557121"
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`$fromMillis(1000)`, "1970-01-01T00:00:01.000Z"},
+		{`$fromMillis(` + ms + `, "[Y0001]-[M01]-[D01]T[H01]:[m01]:[s01].[f001][Z01:01t]")`, "2017-11-07T15:12:37.121Z"},
+		{`$fromMillis(` + ms + `, "[FNn], [D1o] [MNn] [Y]")`, "Tuesday, 7th November 2017"},
+		{`$fromMillis(` + ms + `, "[MNn,*-3] [Y,2] [FN,3-3] [d]")`, "Nov 17 TUE 311"},
+		{`$fromMillis(` + ms + `, "[h]:[m01][P] [Z] [z]")`, "3:12pm +00:00 GMT+00:00"},
+		{`$fromMillis(` + ms + `, "[[[Y]]]")`, "[2017]"},
+		{`$fromMillis(` + ms + `, undefined, "+0530")`, "2017-11-07T20:42:37.121+05:30"},
+		{`$fromMillis(` + ms + `, "[H01]:[m01] [Z0000] [ZN]", "Europe/Rome")`, "16:12 +0100 CET"},
+		{`$fromMillis(` + ms + `, "[H01]:[m01] [z]", "America/New_York")`, "10:12 GMT-05:00"},
+		{`$string($toMillis("2017-11-07 16:12:37 +01:00", "[Y0001]-[M01]-[D01] [H01]:[m01]:[s01] [Z]"))`, "1510067557000"},
+		{`$string($toMillis("07/11/2017 3:12pm", "[D01]/[M01]/[Y0001] [h]:[m01][P]"))`, "1510067520000"},
+		{`$string($toMillis("7th Nov 2017", "[D1o] [MNn] [Y]"))`, "1510012800000"},
+		{`$fromMillis(` + ms + `, "[YI]")`, "MMXVII"},
+		{`$fromMillis(` + ms + `, "[Mi]-[Da]-[dA]")`, "xi-g-KY"},
+		{`$fromMillis(` + ms + `, "[Dw] [DWwo] [MNn] [YW]")`, "seven Seventh November TWO THOUSAND SEVENTEEN"},
+		{`$fromMillis(` + ms + `, "[d0;00]")`, "3;11"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := eval(t, tt.query, nil); got != tt.want {
+				t.Errorf("got %v, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, query := range []string{
+		`$fromMillis(0, "[Q]")`,
+		`$fromMillis(0, "[YN]")`,
+		`$fromMillis(0, "[Y")`,
+		`$fromMillis(0, "[Dx]")`,
+		`$toMillis("MMXVII", "[YI]")`,
+		`$fromMillis(0, undefined, "Mars/Olympus")`,
+	} {
+		if err := evalExpectError(t, query, nil); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
+func TestWithTimezone(t *testing.T) {
+	run := func(tz, query string) (interface{}, error) {
+		t.Helper()
+		expr, err := parser.Compile(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return evaluator.New(evaluator.WithTimezone(tz)).Eval(context.Background(), expr, nil)
+	}
+	tests := []struct {
+		tz, query string
+		want      interface{}
+	}{
+		{"Europe/Rome", `$fromMillis(1510067557121)`, "2017-11-07T16:12:37.121+01:00"},
+		{"Europe/Rome", `$fromMillis(1499440357121)`, "2017-07-07T17:12:37.121+02:00"},
+		{"Europe/Rome", `$fromMillis(1510067557121, undefined, "UTC")`, "2017-11-07T15:12:37.121Z"},
+		{"-0300", `$fromMillis(0, "[H01] [Z]")`, "21 -03:00"},
+		{"Europe/Rome", `$toMillis("2017-11-07T16:12:37")`, 1510067557000.0},
+		{"Europe/Rome", `$toMillis("2017-11-07T16:12:37Z")`, 1510071157000.0},
+		{"Europe/Rome", `$toMillis("07/11/2017 16:12", "[D01]/[M01]/[Y0001] [H01]:[m01]")`, 1510067520000.0},
+		{"Europe/Rome", `$substring($now(), 23) in ["+01:00", "+02:00"]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tz+" "+tt.query, func(t *testing.T) {
+			got, err := run(tt.tz, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := run("Mars/Olympus", `$fromMillis(0)`); err == nil || !strings.Contains(err.Error(), "D3110") {
+		t.Errorf("unknown default timezone: got %v, want D3110", err)
+	}
+}
@@ -1,12 +1,23 @@
 package unit_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sandrolain/gosonata/pkg/evaluator"
 	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
 )
 
 // Helper functions
@@ -226,6 +237,15 @@ func TestEvalLogical(t *testing.T) {
 		{"or both true", "true or true", true},
 		{"or both false", "false or false", false},
 		{"complex", "true and false or true", true},
+		// Undefined operands count as false: and/or always return a boolean,
+		// as in jsonata-js (boolean-expressions case021-case026).
+		{"and undefined", "true and nothing", false},
+		{"or undefined", "nothing or false", false},
+		{"or both undefined", "nothing or missing", false},
+		{"or undefined left", "nothing or 1", true},
+		// The right operand is not evaluated once the left one decides.
+		{"and short-circuit", `false and ("a" > 1)`, false},
+		{"or short-circuit", `true or $error("unreachable")`, true},
 	}
 
 	for _, tt := range tests {
@@ -240,6 +260,18 @@ func TestEvalLogical(t *testing.T) {
 
 // String operator tests
 
+func TestEvalLogicalErrors(t *testing.T) {
+	// An error of an evaluated operand is returned as is.
+	for query, code := range map[string]string{
+		`true and ("a" > 1)`:    "T2009",
+		`false or $number("x")`: "D3030",
+	} {
+		if err := evalExpectError(t, query, nil); !strings.HasPrefix(err.Error(), code) {
+			t.Errorf("%s: got %v, want %s", query, err, code)
+		}
+	}
+}
+
 func TestEvalStringConcat(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -296,6 +328,72 @@ func TestEvalPath(t *testing.T) {
 	}
 }
 
+func TestEvalDescendantPredicate(t *testing.T) {
+	data := map[string]interface{}{
+		"order": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"sku": "a", "qty": 1.0},
+				map[string]interface{}{"sku": "b", "qty": 5.0},
+			},
+			"meta": map[string]interface{}{
+				"tags": []interface{}{"x", "y"},
+				"sku":  "c",
+			},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  interface{}
+	}{
+		{"field", "**.qty", []interface{}{1.0, 5.0}},
+		{"field with predicate", "**.sku[$ = 'b']", "b"},
+		{"object predicate", "**.items[qty > 2].sku", "b"},
+		{"missing field", "**.nothing", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := eval(t, tt.query, data)
+			compareValue(t, result, tt.want)
+		})
+	}
+}
+
+func TestEvalWildcardOrder(t *testing.T) {
+	data := map[string]interface{}{
+		"b": map[string]interface{}{"y": 2.0, "x": []interface{}{1.0, 3.0}},
+		"a": 1.0,
+		"c": map[string]interface{}{"x": 4.0},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  interface{}
+	}{
+		{"map values in key order", "*.x", []interface{}{1.0, 3.0, 4.0}},
+		{"constructed object in document order", `{"z": 1, "a": {"q": 5}, "m": [2, 3]}.*`, []interface{}{1.0, map[string]interface{}{"q": 5.0}, 2.0, 3.0}},
+		{"wildcard chain over constructed objects", `[{"z": {"v": 1}, "a": {"v": 2}}, {"m": {"v": 3}}].*.v`, []interface{}{1.0, 2.0, 3.0}},
+		{"descendants of constructed object", `{"z": {"q": 1}, "a": {"q": 2}}.**.q`, []interface{}{1.0, 2.0}},
+		{"descendants in key order", "**.x", []interface{}{1.0, 3.0, 4.0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, _ := json.Marshal(tt.want)
+			// Repeat: an unordered map walk would make the result vary between runs.
+			for i := 0; i < 20; i++ {
+				got, _ := json.Marshal(eval(t, tt.query, data))
+				if string(got) != string(want) {
+					t.Fatalf("got %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestEvalPathMissing(t *testing.T) {
 	data := map[string]interface{}{
 		"name": "test",
@@ -495,6 +593,968 @@ func TestEvalFilter(t *testing.T) {
 	}
 }
 
+func TestEvalFilterAutoIndex(t *testing.T) {
+	customers := make([]interface{}, 0, 20)
+	for i := 0; i < 20; i++ {
+		c := map[string]interface{}{"id": float64(i % 10), "name": fmt.Sprintf("c%d", i)}
+		if i == 7 {
+			c["id"] = nil // JSON null
+		}
+		if i == 9 {
+			delete(c, "id")
+		}
+		customers = append(customers, c)
+	}
+	data := map[string]interface{}{
+		"customers": customers,
+		"orders": []interface{}{
+			map[string]interface{}{"cust": 3.0},
+			map[string]interface{}{"cust": 7.0},
+			map[string]interface{}{"cust": 42.0},
+			map[string]interface{}{"cust": "3"},
+			map[string]interface{}{"cust": -0.0},
+		},
+	}
+
+	queries := []string{
+		`$map(orders, function($o){ customers[id = $o.cust].name })`,
+		`$map(orders, function($o){ customers[$o.cust = id].name })`,
+		`orders.(%.customers[id = 3].name)`,
+		`$map([1, 2, 3], function($v){ customers[id = $v] })`,
+		`$map([1, 2], function($v){ customers[id = null].name })`,
+		`$map([1, 2], function($v){ customers[id = $nope].name })`,
+	}
+
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			expr, err := parser.Parse(query)
+			if err != nil {
+				t.Fatalf("parse %q: %v", query, err)
+			}
+			want, err := evaluator.New().Eval(context.Background(), expr, data)
+			if err != nil {
+				t.Fatalf("eval %q: %v", query, err)
+			}
+			got, err := evaluator.New(evaluator.WithAutoIndex(true)).Eval(context.Background(), expr, data)
+			if err != nil {
+				t.Fatalf("eval %q with auto index: %v", query, err)
+			}
+			compareValue(t, got, want)
+		})
+	}
+}
+
+func TestEvalSortOperatorLarge(t *testing.T) {
+	// Sizes cover the merge sort (small), the radix sort (>= 256 items) and the
+	// parallel key evaluation (>= 8192 items), which needs several Ps even on a
+	// single-CPU host; run with -race, it checks that the workers share no state.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	for _, n := range []int{50, 1000, 10000} {
+		items := make([]interface{}, n)
+		seed := uint32(7)
+		for i := range items {
+			seed = seed*1664525 + 1013904223
+			item := map[string]interface{}{
+				"i": float64(i),
+				"g": fmt.Sprintf("g%d", seed%7),
+			}
+			if seed%11 != 0 { // some items have no key: they sort last
+				item["v"] = float64(int32(seed)%500) / 4
+			}
+			items[i] = item
+		}
+
+		tests := []struct {
+			query string
+			less  func(a, b map[string]interface{}) bool
+		}{
+			{"items^(v).i", func(a, b map[string]interface{}) bool { return numLess(a["v"], b["v"], true) }},
+			{"items^(>v).i", func(a, b map[string]interface{}) bool { return numLess(a["v"], b["v"], false) }},
+			// A key that is not a leaf node goes through the depth counters.
+			{"items^(-v).i", func(a, b map[string]interface{}) bool { return numLess(a["v"], b["v"], false) }},
+			{"items^(g, >v).i", func(a, b map[string]interface{}) bool {
+				if a["g"] != b["g"] {
+					return a["g"].(string) < b["g"].(string)
+				}
+				return numLess(a["v"], b["v"], false)
+			}},
+		}
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%d", tt.query, n), func(t *testing.T) {
+				sorted := make([]map[string]interface{}, n)
+				for i, item := range items {
+					sorted[i] = item.(map[string]interface{})
+				}
+				sort.SliceStable(sorted, func(i, j int) bool { return tt.less(sorted[i], sorted[j]) })
+				want := make([]interface{}, n)
+				for i, item := range sorted {
+					want[i] = item["i"]
+				}
+				compareValue(t, eval(t, tt.query, map[string]interface{}{"items": items}), want)
+			})
+		}
+	}
+}
+
+// numLess orders numbers with undefined values last in either direction.
+func numLess(a, b interface{}, ascending bool) bool {
+	if a == nil || b == nil {
+		return a != nil && b == nil
+	}
+	if ascending {
+		return a.(float64) < b.(float64)
+	}
+	return a.(float64) > b.(float64)
+}
+
+func TestEvalCyclicData(t *testing.T) {
+	cyclicMap := map[string]interface{}{"a": map[string]interface{}{"b": 1.0}}
+	cyclicMap["self"] = cyclicMap
+	cyclicArr := []interface{}{1.0, nil}
+	cyclicArr[1] = cyclicArr
+
+	tests := []struct {
+		name  string
+		query string
+		data  interface{}
+	}{
+		{"transform clones input", `$ ~> |a|{"c": 2}|`, cyclicMap},
+		{"string serialises input", `$string($)`, cyclicMap},
+		{"result conversion", `$`, cyclicMap},
+		{"operator operand", `$ = 1`, cyclicArr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := evalExpectError(t, tt.query, tt.data)
+			if err == nil || !strings.Contains(err.Error(), "D1010") {
+				t.Errorf("got error %v, want D1010", err)
+			}
+		})
+	}
+
+	// Shared (acyclic) sub-objects are not cycles.
+	shared := map[string]interface{}{"v": 1.0}
+	dag := map[string]interface{}{"a": shared, "b": shared}
+	compareValue(t, eval(t, `($ ~> |a|{"w": 2}|).b.v`, dag), 1.0)
+}
+
+func TestObjectConstructorSharedKeys(t *testing.T) {
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"id": 1.0, "k": "x"},
+			map[string]interface{}{"id": 2.0, "k": "x"},
+			map[string]interface{}{"id": 3.0},
+		},
+	}
+	for _, query := range []string{`items.{"id": id, "kind": k}`, `items.{k: id}`} {
+		t.Run(query, func(t *testing.T) {
+			expr, err := parser.Compile(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := evaluator.New().Eval(context.Background(), expr, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := evaluator.New(evaluator.WithStringInterning(true)).Eval(context.Background(), expr, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			objs := got.([]interface{})
+			a, b := objs[0].(*evaluator.OrderedObject), objs[1].(*evaluator.OrderedObject)
+			if &a.Keys[0] != &b.Keys[0] {
+				t.Error("objects with the same keys do not share their Keys slice")
+			}
+		})
+	}
+}
+
+func TestObjectExtensions(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{
+		"base": {"a": 1, "b": 2},
+		"parts": [{"x": 1}, {"y": 2}],
+		"orders": [{"id": 1, "price": 2, "qty": 3}, {"id": 2, "price": 5, "qty": 1}],
+		"name": "k",
+		"n": 3
+	}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	ext := parser.WithExtensions(parser.ExtObjectSpread, parser.ExtComputedKeys)
+
+	tests := []struct {
+		query string
+		want  string
+		code  string
+	}{
+		{query: `{...base, "c": 3}`, want: `{"a":1,"b":2,"c":3}`},
+		{query: `{...base, "a": 9}`, want: `{"a":9,"b":2}`},
+		{query: `{"a": 0, ...base}`, want: `{"a":1,"b":2}`},
+		{query: `{...parts}`, want: `{"x":1,"y":2}`},
+		{query: `{...missing, "z": 1}`, want: `{"z":1}`},
+		{query: `orders.{...$, "total": price * qty}`, want: `[{"id":1,"price":2,"qty":3,"total":6},{"id":2,"price":5,"qty":1,"total":5}]`},
+		{query: `{...base, "a": 9, "a": 1}`, code: "D1009"},
+		{query: `{...name}`, code: "T1004"},
+		{query: `{[name]: 1}`, want: `{"k":1}`},
+		{query: `{[n]: 1, [n > 1]: 2}`, want: `{"3":1,"true":2}`},
+		{query: `{[missing]: 1}`, want: `{}`},
+		{query: `{[base]: 1}`, code: "T1003"},
+		{query: `orders{[$string(id)]: price}`, want: `{"1":2,"2":5}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := parser.Compile(tt.query, ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := evaluator.New().Eval(context.Background(), expr, data)
+			if tt.code != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.code) {
+					t.Fatalf("expected %s, got %v", tt.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(result)
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		if _, err := parser.Compile(`{...base}`); err == nil {
+			t.Error("spread accepted without ExtObjectSpread")
+		}
+		// Without ExtComputedKeys, [name] is an array constructor.
+		got, _ := json.Marshal(eval(t, `{[name, "j"]: 1}`, data))
+		if string(got) != `{"k":1,"j":1}` {
+			t.Errorf("got %s", got)
+		}
+	})
+
+	t.Run("no spread in grouping", func(t *testing.T) {
+		if _, err := parser.Compile(`orders{...base}`, ext); err == nil {
+			t.Error("spread accepted in a grouping expression")
+		}
+	})
+}
+
+func TestStrictVariables(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]interface{}{"price": 2.0, "Quantity": 3.0, "items": []interface{}{1.0, 2.0}}
+	prelude := mustCompile(t, `($vat := 0.2)`)
+	ev := evaluator.New(evaluator.WithStrictVariables(true), evaluator.WithPrelude(prelude))
+
+	valid := []string{
+		`$price := price`,
+		`($p := price; $p * Quantity * (1 + $vat))`,
+		`($fact := function($n) { $n <= 1 ? 1 : $n * $fact($n - 1) }; $fact(3))`,
+		`(
+			$even := function($n) { $n = 0 ? true : $odd($n - 1) };
+			$odd := function($n) { $n = 0 ? false : $even($n - 1) };
+			$even(4)
+		)`,
+		`items@$i.($i * $limit)`,
+		`items#$pos.($pos)`,
+		`$map(items, function($v, $k) { $v + $k })`,
+		`$sum(items) ~> $string`,
+		`($f := $uppercase; $f("a"))`,
+		`$.price * $$.Quantity`,
+	}
+	for _, query := range valid {
+		if _, err := ev.EvalWithBindings(ctx, mustCompile(t, query), data, map[string]interface{}{"limit": 10.0}); err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+	}
+
+	invalid := []struct {
+		query, name string
+	}{
+		{`$pric * Quantity`, "pric"},
+		{`($a := $b; $b := 1; $a)`, "b"},
+		{`(($x := 1); $x)`, "x"},
+		{`$map(items, function($v) { $v + $w })`, "w"},
+		{`function($v) { $v }($undefinedArg)`, "undefinedArg"},
+		{`$limit + 1`, "limit"},
+	}
+	for _, tt := range invalid {
+		_, err := ev.Eval(ctx, mustCompile(t, tt.query), data)
+		var jerr *types.Error
+		if !errors.As(err, &jerr) || jerr.Code != types.ErrUndefinedVariable || !strings.Contains(jerr.Message, "$"+tt.name+" ") {
+			t.Errorf("%s: got %v, want U1001 for $%s", tt.query, err, tt.name)
+		}
+	}
+
+	// The default follows the specification: an unbound variable is undefined.
+	if result, err := evaluator.New().Eval(ctx, mustCompile(t, `$pric * Quantity`), data); err != nil || result != nil {
+		t.Errorf("default: got %v, %v; want undefined", result, err)
+	}
+	// EvalIter checks the expressions it streams too.
+	seq, err := ev.EvalIter(ctx, mustCompile(t, `items[$ > $min]`), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range seq {
+		if err == nil {
+			t.Error("EvalIter: want U1001 for $min")
+		}
+	}
+}
+
+func TestAssertMode(t *testing.T) {
+	expr := mustCompile(t, `($assert(total > 0, "total must be positive"); total * 2)`)
+	data := map[string]interface{}{"total": -1.0}
+
+	_, err := evaluator.New().Eval(context.Background(), expr, data)
+	if err == nil || !strings.Contains(err.Error(), "D3141") {
+		t.Fatalf("enforce: got %v, want D3141", err)
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	ev := evaluator.New(evaluator.WithAssertMode(evaluator.AssertWarn), evaluator.WithLogger(logger))
+	result, err := ev.Eval(context.Background(), expr, data)
+	if err != nil || result != -2.0 {
+		t.Fatalf("warn: got %v, %v; want -2", result, err)
+	}
+	if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "total must be positive") {
+		t.Errorf("warn: missing warning in %q", logs.String())
+	}
+	logs.Reset()
+	if _, err := ev.Eval(context.Background(), mustCompile(t, `$assert("yes")`), nil); err != nil {
+		t.Errorf("warn: non-boolean condition failed: %v", err)
+	}
+	if !strings.Contains(logs.String(), "T0410") {
+		t.Errorf("warn: missing T0410 warning in %q", logs.String())
+	}
+	logs.Reset()
+	if _, err := ev.Eval(context.Background(), expr, map[string]interface{}{"total": 1.0}); err != nil || logs.Len() != 0 {
+		t.Errorf("warn: passing assertion: %v, logged %q", err, logs.String())
+	}
+
+	ev = evaluator.New(evaluator.WithAssertMode(evaluator.AssertIgnore), evaluator.WithLogger(logger))
+	result, err = ev.Eval(context.Background(), expr, data)
+	if err != nil || result != -2.0 || logs.Len() != 0 {
+		t.Fatalf("ignore: got %v, %v, logged %q; want -2", result, err, logs.String())
+	}
+	// The arguments are still evaluated.
+	if _, err := ev.Eval(context.Background(), mustCompile(t, `$assert($error("bad"))`), nil); err == nil {
+		t.Error("ignore: want the error of the argument")
+	}
+}
+
+func TestMaxStringLength(t *testing.T) {
+	ctx := context.Background()
+	ev := evaluator.New(evaluator.WithMaxStringLength(100))
+	over := []string{
+		`$pad("x", 101)`,
+		`$pad("x", -1e300, "#")`,
+		`$join($map([1..11], function() { "0123456789" }))`,
+		`$join(["a", "b"], $pad("", 99))`,
+		`$replace($pad("", 60, "a"), "a", "aa")`,
+		`$replace($pad("", 60, "a"), /a/, "aa")`,
+		`($f := function($s) { $f($s & $s) }; $f("abc"))`,
+	}
+	for _, query := range over {
+		_, err := ev.Eval(ctx, mustCompile(t, query), nil)
+		var jerr *types.Error
+		if !errors.As(err, &jerr) || jerr.Code != types.ErrStringTooLarge {
+			t.Errorf("%s: got %v, want D1012", query, err)
+		}
+	}
+
+	within := map[string]interface{}{
+		`$length($pad("x", 100))`:                                    100.0,
+		`$length($join($map([1..10], function() { "0123456789" })))`: 100.0,
+		`$length($replace($pad("", 50, "a"), "a", "aa"))`:            100.0,
+		`$length($replace($pad("", 50, "a"), "a", "aa", 10))`:        60.0,
+		`$substring("abcdef", -2, 1e30)`:                             "ef",
+		`$substring("abcdef", 1e30)`:                                 "",
+	}
+	for query, want := range within {
+		got, err := ev.Eval(ctx, mustCompile(t, query), nil)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v; want %v", query, got, err, want)
+		}
+	}
+
+	// 0 disables the limit.
+	got, err := evaluator.New(evaluator.WithMaxStringLength(0)).Eval(ctx, mustCompile(t, `$length($pad("x", 1000))`), nil)
+	if err != nil || got != 1000.0 {
+		t.Errorf("unlimited: got %v, %v", got, err)
+	}
+}
+
+func TestJoinCoercion(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"sku": "a", "qty": 2.0, "ok": true},
+			map[string]interface{}{"sku": "b", "qty": 0.5, "ok": false},
+		},
+		"id": int64(7),
+	}
+	ev := evaluator.New(evaluator.WithJoinCoercion(true))
+	tests := map[string]interface{}{
+		`$join(items.qty, ", ")`:                  "2, 0.5",
+		`$join(items.(sku & "=" & qty), "&")`:     "a=2&b=0.5",
+		`$join([items.sku, items.qty, items.ok])`: "ab20.5truefalse",
+		`$join(id)`:                 "7",
+		`$join([id, 1e21], "/")`:    "7/1e+21",
+		`$join(items.missing, ",")`: nil,
+		`$join(items.qty, ",") = $join($map(items.qty, $string), ",")`: true,
+	}
+	for query, want := range tests {
+		got, err := ev.Eval(ctx, mustCompile(t, query), data)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v; want %v", query, got, err, want)
+		}
+	}
+
+	// Other values are still rejected, and strings only by default.
+	for _, query := range []string{`$join([1, null])`, `$join([1, {"a": 1}])`, `$join([[1]])`} {
+		if _, err := ev.Eval(ctx, mustCompile(t, query), data); err == nil || !strings.Contains(err.Error(), "T0412") {
+			t.Errorf("%s: got %v, want T0412", query, err)
+		}
+	}
+	if _, err := evaluator.New().Eval(ctx, mustCompile(t, `$join(items.qty)`), data); err == nil || !strings.Contains(err.Error(), "T0412") {
+		t.Errorf("default: got %v, want T0412", err)
+	}
+}
+
+// TestConcatChain checks that a chain of & operators, evaluated in one pass,
+// gives the result of the nested operators.
+func TestConcatChain(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]interface{}{"a": "x", "n": 1.5, "b": true, "o": map[string]interface{}{"k": 1.0}}
+	tests := map[string]interface{}{
+		`a & n & b & missing & o & null & [1, 2]`: `x1.5true{"k":1}null[1,2]`,
+		`(a & "-") & (n & "-" & b)`:               "x-1.5-true",
+		`a & $uppercase(a & a) & a`:               "xXXx",
+		`missing & missing & missing`:             "",
+	}
+	ev := evaluator.New()
+	for query, want := range tests {
+		got, err := ev.Eval(ctx, mustCompile(t, query), data)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v; want %v", query, got, err, want)
+		}
+		// The profiler evaluates each & on its own.
+		profile, err := ev.Profile(ctx, mustCompile(t, query), data)
+		if err != nil || profile.Result != want {
+			t.Errorf("%s (profiled): got %v, %v; want %v", query, profile, err, want)
+		}
+	}
+
+	terms := make([]string, 2000)
+	for i := range terms {
+		terms[i] = fmt.Sprintf("%q", fmt.Sprint(i%10))
+	}
+	got, err := ev.Eval(ctx, mustCompile(t, strings.Join(terms, " & ")), nil)
+	if want := strings.Repeat("0123456789", 200); err != nil || got != want {
+		t.Errorf("2000 terms: got %v, %v", got, err)
+	}
+
+	// Errors of an operand and of the size limit stop the chain.
+	_, err = ev.Eval(ctx, mustCompile(t, `"a" & $number("x") & "b"`), nil)
+	var jerr *types.Error
+	if !errors.As(err, &jerr) || jerr.Code != types.ErrCastToNumber {
+		t.Errorf("got %v, want D3030", err)
+	}
+	limited := evaluator.New(evaluator.WithMaxStringLength(10))
+	_, err = limited.Eval(ctx, mustCompile(t, `"0123" & "4567" & "89" & "!"`), nil)
+	if !errors.As(err, &jerr) || jerr.Code != types.ErrStringTooLarge {
+		t.Errorf("got %v, want D1012", err)
+	}
+}
+
+func TestMaxResultItems(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]interface{}{"items": []interface{}{1.0, 2.0, 3.0, 4.0, 5.0}}
+	ev := evaluator.New(evaluator.WithMaxResultItems(3))
+
+	result, info, err := ev.EvalWithInfo(ctx, mustCompile(t, `items.($ * 10)`), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, []interface{}{10.0, 20.0, 30.0}) {
+		t.Errorf("got %v, want [10 20 30]", result)
+	}
+	if !info.Truncated || info.Items != 5 {
+		t.Errorf("got %+v, want truncated from 5 items", info)
+	}
+
+	// Nested arrays and short arrays are returned whole.
+	tests := []struct {
+		query string
+		items int
+	}{
+		{`{"all": items}`, 0},
+		{`items[$ < 3]`, 2},
+		{`items[0]`, 0},
+	}
+	for _, tt := range tests {
+		result, info, err := ev.EvalWithInfo(ctx, mustCompile(t, tt.query), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := evaluator.New().Eval(ctx, mustCompile(t, tt.query), data)
+		if !reflect.DeepEqual(result, want) || info.Truncated || info.Items != tt.items {
+			t.Errorf("%s: got %v, %+v; want %v, %d items", tt.query, result, info, want, tt.items)
+		}
+	}
+
+	if result, _ := ev.Eval(ctx, mustCompile(t, `items`), data); len(result.([]interface{})) != 3 {
+		t.Errorf("Eval: got %v, want 3 items", result)
+	}
+	// The input is not modified.
+	if len(data["items"].([]interface{})) != 5 {
+		t.Error("the input array was truncated")
+	}
+
+	for _, query := range []string{`items[$ > 1]`, `items.($ + 1)`} {
+		iter, err := ev.EvalIter(ctx, mustCompile(t, query), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []interface{}
+		for item, err := range iter {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, item)
+		}
+		if len(got) != 3 {
+			t.Errorf("EvalIter %s: got %v, want 3 items", query, got)
+		}
+	}
+}
+
+// TestPartialResults checks the items returned when the top-level step runs
+// out of time: $wait returns its argument below 3 and blocks until the
+// deadline from 3 on.
+func TestPartialResults(t *testing.T) {
+	ctx := context.Background()
+	wait := func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		if n, _ := args[0].(float64); n < 3 {
+			return n, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	items := make([]interface{}, 10)
+	for i := range items {
+		items[i] = map[string]interface{}{"n": float64(i)}
+	}
+	data := map[string]interface{}{"items": items}
+	opts := []evaluator.EvalOption{
+		evaluator.WithCustomFunction("wait", "", wait),
+		evaluator.WithTimeout(20 * time.Millisecond),
+	}
+	ev := evaluator.New(append(opts, evaluator.WithPartialResults(true))...)
+
+	tests := []struct {
+		query string
+		want  string // JSON, or "" for a timeout error
+	}{
+		{`items.{"n": $wait(n)}`, `[{"n":0},{"n":1},{"n":2}]`},
+		{`items.$wait(n)`, `[0,1,2]`},
+		{`items[$wait(n) > 1]`, `[{"n":2}]`},
+		{`items[$wait(n) > 0].n`, ""},
+		{`$sum(items.$wait(n))`, ""},
+	}
+	for _, tt := range tests {
+		result, info, err := ev.EvalWithInfo(ctx, mustCompile(t, tt.query), data)
+		if tt.want == "" {
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s: got %v, %+v, %v; want a timeout", tt.query, result, info, err)
+			}
+			continue
+		}
+		got, _ := json.Marshal(result)
+		if err != nil || !info.Partial || string(got) != tt.want {
+			t.Errorf("%s: got %s, %+v, %v; want partial %s", tt.query, got, info, err, tt.want)
+		}
+	}
+
+	// Complete results are not partial.
+	result, info, err := ev.EvalWithInfo(ctx, mustCompile(t, `items[n < 3].$wait(n)`), data)
+	if err != nil || info.Partial || !reflect.DeepEqual(result, []interface{}{0.0, 1.0, 2.0}) {
+		t.Errorf("complete: got %v, %+v, %v", result, info, err)
+	}
+	// Only EvalWithInfo returns partial results, and only when enabled.
+	if _, err := ev.Eval(ctx, mustCompile(t, `items.$wait(n)`), data); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Eval: got %v, want a timeout", err)
+	}
+	if _, _, err := evaluator.New(opts...).EvalWithInfo(ctx, mustCompile(t, `items.$wait(n)`), data); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("disabled: got %v, want a timeout", err)
+	}
+}
+
+func TestOptionalChaining(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{
+		"customer": {"name": "Ann", "address": {"city": "Rome"}},
+		"orders": [{"id": 1, "coupon": "X"}, {"id": 2}],
+		"note": null
+	}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	ext := parser.WithExtensions(parser.ExtOptionalChaining)
+
+	tests := []struct {
+		query string
+		want  string // JSON result, "" for undefined
+		code  string // error code under strict navigation
+	}{
+		{query: `customer.address.city`, want: `"Rome"`},
+		{query: `customer?.address?.city`, want: `"Rome"`},
+		{query: `customer.phone`, code: "U1004"},
+		{query: `customer?.phone`},
+		{query: `customer?.phone.prefix`},
+		{query: `customer?.address.zip`, code: "U1004"},
+		{query: `nickname`, code: "U1004"},
+		{query: `$?.nickname`},
+		{query: `note`}, // present, with a null value
+		{query: `orders.id`, want: `[1,2]`},
+		{query: `orders.coupon`, code: "U1004"},
+		{query: `orders?.coupon`, want: `"X"`},
+		{query: `orders?.coupon[0]`, want: `"X"`},
+		{query: `orders[coupon = "X"].id`, code: "U1004"},
+		{query: `orders[$?.coupon = "X"].id`, want: `1`},
+		{query: `customer.name.first`, want: ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := parser.Compile(tt.query, ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Lenient navigation: ?. is the same as . and nothing fails.
+			lenient, err := evaluator.New().Eval(context.Background(), expr, data)
+			if err != nil {
+				t.Fatalf("lenient: %v", err)
+			}
+			strict, err := evaluator.New(evaluator.WithStrictNavigation(true)).Eval(context.Background(), expr, data)
+			if tt.code != "" {
+				var jerr *types.Error
+				if !errors.As(err, &jerr) || string(jerr.Code) != tt.code {
+					t.Fatalf("strict: expected %s, got %v", tt.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("strict: %v", err)
+			}
+			for mode, result := range map[string]interface{}{"lenient": lenient, "strict": strict} {
+				got := ""
+				if result != nil {
+					b, _ := json.Marshal(result)
+					got = string(b)
+				}
+				if got != tt.want {
+					t.Errorf("%s: got %s, want %s", mode, got, tt.want)
+				}
+			}
+		})
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		_, err := parser.Compile(`customer?.name`)
+		var jerr *types.Error
+		if !errors.As(err, &jerr) || jerr.Code != "S0201" || jerr.Position != 9 {
+			t.Errorf("got %v, want S0201 at the '.'", err)
+		}
+	})
+}
+
+func TestShardedEvaluation(t *testing.T) {
+	// Shards need several Ps, even on a single-CPU host.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	items := make([]interface{}, 5000)
+	for i := range items {
+		items[i] = map[string]interface{}{
+			"id":    float64(i),
+			"price": float64(i % 97),
+			"qty":   float64(i%5 + 1),
+			"tags":  []interface{}{"t" + fmt.Sprint(i%3), "u"},
+			"code":  fmt.Sprint(i),
+		}
+	}
+	bad := make([]interface{}, len(items))
+	copy(bad, items)
+	bad[4321] = map[string]interface{}{"code": "x"}
+
+	sequential := evaluator.New(evaluator.WithConcurrency(true))
+	sharded := evaluator.New(evaluator.WithConcurrency(true), evaluator.WithShardSize(100))
+
+	for _, query := range []string{
+		`$.{"id": id, "total": price * qty, "tags": $join(tags, ",")}`,
+		`$.(price > 50 ? "high" : "low")`,
+		`$.tags`,
+		`$.[tags]`,
+		`$.($x := price; $x * qty)`,
+		`$.$number(code)`,
+		`$.(code ~> $number)`,
+		`$.(tags[0] = "t1" ? id)`,
+		`$.$string($count($$) - id)`,
+		`$.(function($v) { $v * 2 }(price))`,
+		`$.$random()`,
+	} {
+		t.Run(query, func(t *testing.T) {
+			expr := mustCompile(t, query)
+			want, err := sequential.Eval(context.Background(), expr, items)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := sharded.Eval(context.Background(), expr, items)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query == `$.$random()` {
+				return
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("sharded result differs from the sequential one")
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		expr := mustCompile(t, `$.$number(code)`)
+		_, want := sequential.Eval(context.Background(), expr, bad)
+		_, got := sharded.Eval(context.Background(), expr, bad)
+		if want == nil || got == nil || got.Error() != want.Error() {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestObjectArena(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{"orders": [
+		{"id": 1, "items": [{"sku": "a", "qty": 2}, {"sku": "b", "qty": 1}]},
+		{"id": 2, "items": [{"sku": "c", "qty": 5}]}
+	]}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	plain := evaluator.New()
+	arena := evaluator.New(evaluator.WithObjectArena(true))
+
+	queries := []string{
+		`orders.{"id": id, "lines": items.{"sku": sku, "qty": qty}}`,
+		`orders@$o.items.{"order": $o.id, "sku": sku}`,
+		`orders{$string(id): $count(items)}`,
+		`$merge(orders.{$string(id): {"n": $count(items)}})`,
+		`orders.items#$i.{"i": $i, "sku": sku}`,
+		`{"a": {"b": {"c": [1, {"d": 2}]}}}`,
+	}
+	var results []interface{}
+	var snapshots []string
+	for _, query := range queries {
+		expr := mustCompile(t, query)
+		want, err := plain.Eval(context.Background(), expr, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := arena.Eval(context.Background(), expr, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantJSON, _ := json.Marshal(want)
+		gotJSON, _ := json.Marshal(got)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("%s: got %s, want %s", query, gotJSON, wantJSON)
+		}
+		results = append(results, got)
+		snapshots = append(snapshots, string(gotJSON))
+	}
+
+	// The arena is reused by later evaluations: the results returned before
+	// must not change.
+	expr := mustCompile(t, `[1..200].{"x": $, "y": {"z": $ * 2}}`)
+	for i := 0; i < 10; i++ {
+		if _, err := arena.Eval(context.Background(), expr, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, result := range results {
+		got, _ := json.Marshal(result)
+		if string(got) != snapshots[i] {
+			t.Errorf("%s: result changed after later evaluations: %s, was %s", queries[i], got, snapshots[i])
+		}
+	}
+
+	t.Run("returned function", func(t *testing.T) {
+		expr := mustCompile(t, `($o := {"a": {"b": 1}}; function() { $o })`)
+		fn, err := arena.Eval(context.Background(), expr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			if _, err := arena.Eval(context.Background(), mustCompile(t, `[1..100].{"a": {"c": $}}`), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := arena.EvalWithBindings(context.Background(), mustCompile(t, `$f().a.b`), nil, map[string]interface{}{"f": fn})
+		if err != nil || got != 1.0 {
+			t.Errorf("got %v, %v; want 1", got, err)
+		}
+	})
+}
+
+func TestEvalValue(t *testing.T) {
+	ev := evaluator.New()
+	expr := mustCompile(t, `{"total": $sum(lines.(price * qty)), "skus": lines.sku, "big": $sum(lines.price) > $limit, "none": null, "f": $uppercase}`)
+	input := evaluator.ObjectValue([]string{"lines", "ignored"}, []evaluator.Value{
+		evaluator.ArrayValue([]evaluator.Value{
+			evaluator.ObjectValue([]string{"sku", "price", "qty"}, []evaluator.Value{
+				evaluator.StringValue("a"), evaluator.NumberValue(10), evaluator.NumberValue(2),
+			}),
+			evaluator.ValueOf(map[string]interface{}{"sku": "b", "price": 5.0, "qty": 1}),
+			{},
+		}),
+		{},
+	})
+	if input.Len() != 1 || input.Get("lines").Len() != 2 {
+		t.Fatalf("undefined items kept: %v", input.Interface())
+	}
+
+	out, err := ev.EvalValue(context.Background(), expr, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Kind() != evaluator.KindObject || !reflect.DeepEqual(out.Keys(), []string{"total", "skus", "none", "f"}) {
+		t.Fatalf("got %v %v", out.Kind(), out.Keys())
+	}
+	if total := out.Get("total"); total.Kind() != evaluator.KindNumber || total.Number() != 25 {
+		t.Errorf("total: got %v %v", total.Kind(), total.Number())
+	}
+	skus := out.Get("skus")
+	if skus.Kind() != evaluator.KindArray || skus.Len() != 2 || skus.Index(1).String() != "b" || skus.Index(2).Kind() != evaluator.KindUndefined {
+		t.Errorf("skus: got %v", skus.Interface())
+	}
+	// $limit is unbound: the comparison is undefined and left out.
+	if big := out.Get("big"); big.Kind() != evaluator.KindUndefined {
+		t.Errorf("big: got %v", big.Kind())
+	}
+	if none := out.Get("none"); none.Kind() != evaluator.KindNull {
+		t.Errorf("none: got %v", none.Kind())
+	}
+	if f := out.Get("f"); f.Kind() != evaluator.KindFunction {
+		t.Errorf("f: got %v", f.Kind())
+	}
+
+	// Accessors of another kind return zero values.
+	s := evaluator.StringValue("x")
+	if s.Number() != 0 || s.Bool() || s.Len() != 0 || s.Get("k").Kind() != evaluator.KindUndefined || s.Keys() != nil {
+		t.Error("accessors of a string")
+	}
+	if got := evaluator.NumberValue(1).String(); got != "<number Value>" {
+		t.Errorf("String of a number: %q", got)
+	}
+
+	// Scalars round-trip through Interface.
+	for _, v := range []interface{}{nil, types.NullValue, true, 1.5, 7, "s", []byte("b")} {
+		if got := evaluator.ValueOf(v).Interface(); !reflect.DeepEqual(got, v) {
+			t.Errorf("ValueOf(%v).Interface() = %v", v, got)
+		}
+	}
+
+	out, err = ev.EvalValue(context.Background(), mustCompile(t, `$ > 1`), evaluator.NumberValue(2))
+	if err != nil || out.Kind() != evaluator.KindBool || !out.Bool() {
+		t.Errorf("got %v %v, %v", out.Kind(), out.Bool(), err)
+	}
+	if _, err := ev.EvalValue(context.Background(), mustCompile(t, `$ + "a"`), evaluator.NumberValue(2)); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestWithPrelude(t *testing.T) {
+	compile := func(src string) *types.Expression {
+		t.Helper()
+		expr, err := parser.Compile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return expr
+	}
+	lib := compile(`(
+		$net := function($o) { $o.price * $o.qty };
+		$total := function($orders) { $sum($orders.$net($)) };
+		$fact := function($n) { $n <= 1 ? 1 : $n * $fact($n - 1) };
+		$rate := 2
+	)`)
+	ext := compile(`$double := function($x) { $x * $rate }`)
+	ev := evaluator.New(evaluator.WithPrelude(lib), evaluator.WithPrelude(ext))
+	data := map[string]interface{}{
+		"orders": []interface{}{
+			map[string]interface{}{"price": 2.0, "qty": 3.0},
+			map[string]interface{}{"price": 5.0, "qty": 1.0},
+		},
+	}
+
+	tests := []struct {
+		expr     string
+		bindings map[string]interface{}
+		want     interface{}
+	}{
+		{expr: `$total(orders)`, want: 11.0},
+		{expr: `orders.$net($)`, want: []interface{}{6.0, 5.0}},
+		{expr: `$fact(5)`, want: 120.0},
+		{expr: `$double(21)`, want: 42.0},
+		{expr: `($rate := 10; $double(1))`, want: 2.0},
+		{expr: `$double(1)`, bindings: map[string]interface{}{"rate": 3.0}, want: 2.0},
+		{expr: `$rate`, bindings: map[string]interface{}{"rate": 3.0}, want: 3.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ev.EvalWithBindings(context.Background(), compile(tt.expr), data, tt.bindings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("concurrent evaluations", func(t *testing.T) {
+		expr := compile(`$total(orders) + $fact(3)`)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if got, err := ev.Eval(context.Background(), expr, data); err != nil || got != 17.0 {
+					t.Errorf("got %v, %v; want 17", got, err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("error", func(t *testing.T) {
+		bad := evaluator.New(evaluator.WithPrelude(compile(`$x := 1 + "a"`)))
+		_, err := bad.Eval(context.Background(), compile(`1`), nil)
+		var jerr *types.Error
+		if !errors.As(err, &jerr) || jerr.Code != "T2001" || !strings.HasPrefix(err.Error(), "prelude: ") {
+			t.Errorf("err = %v, want a prelude T2001 error", err)
+		}
+	})
+}
+
 // Conditional tests
 
 func TestEvalConditional(t *testing.T) {
@@ -591,6 +1651,82 @@ func TestEvalAssignment(t *testing.T) {
 	}
 }
 
+// Scoping tests (blocks and closures groups of the JSONata test suite)
+
+func TestEvalScoping(t *testing.T) {
+	data := map[string]interface{}{"a": []interface{}{1.0, 2.0, 3.0}}
+	tests := []struct {
+		name  string
+		query string
+		want  interface{}
+	}{
+		{"block shadows outer", `($x := 1; ($x := 2); $x)`, 1.0},
+		{"inner block sees outer", `($x := 1; ($y := $x + 1; $y))`, 2.0},
+		{"block binding is local", `($f := ($y := 10; function() { $y }); $y)`, nil},
+		{"closure keeps block binding", `($f := ($y := 10; function() { $y }); $f())`, 10.0},
+		{"call does not modify caller", `($f := function() { $x := 5 }; $x := 1; $f(); $x)`, 1.0},
+		{"param shadows outer", `($f := function($x) { ($x := $x + 1; $x) }; $x := 100; [$f(1), $x])`, []interface{}{2.0, 100.0}},
+		{"closure sees later rebinding", `($x := 1; $f := function() { $x }; $x := 2; $f())`, 2.0},
+		{"recursion through own name", `($f := function($n) { $n <= 1 ? 1 : $n * $f($n - 1) }; $f(5))`, 120.0},
+		{"nested closure sees later binding", `($mk := function() { function() { $later } }; $g := $mk(); $later := 5; $g())`, 5.0},
+		{"path step block is local", `($x := 1; a.($x := $); $x)`, 1.0},
+		{"callback binding is local", `($x := 1; $map(a, function($v) { $x := $v }); $x)`, 1.0},
+		{"partial sees later binding", `($add := function($a, $b) { $a + $b + $k }; $inc := $add(?, 1); $k := 10; $inc(1))`, 12.0},
+		{"counter per call", `($mk := function($n) { function() { $n } }; [$mk(1)(), $mk(2)()])`, []interface{}{1.0, 2.0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compareValue(t, eval(t, tt.query, data), tt.want)
+		})
+	}
+}
+
+// accountJSON is dataset5 of the JSONata test suite.
+const accountJSON = `{"Account": {"Account Name": "Firefly", "Order": [
+	{"OrderID": "order103", "Product": [
+		{"Product Name": "Bowler Hat", "ProductID": 858383, "SKU": "0406654608", "Price": 34.45, "Quantity": 2},
+		{"Product Name": "Trilby hat", "ProductID": 858236, "SKU": "0406634348", "Price": 21.67, "Quantity": 1}]},
+	{"OrderID": "order104", "Product": [
+		{"Product Name": "Bowler Hat", "ProductID": 858383, "SKU": "040657863", "Price": 34.45, "Quantity": 4},
+		{"Product Name": "Cloak", "ProductID": 345664, "SKU": "0406654603", "Price": 107.99, "Quantity": 1}]}]}}`
+
+// TestEvalBlockAndClosureGroups runs the cases of the block-expressions and
+// closures groups of the JSONata test suite.
+func TestEvalBlockAndClosureGroups(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(accountJSON), &data); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`()`, `null`},
+		{`(1; 2; 3)`, `3`},
+		{`(1; 2; 3;)`, `3`},
+		{`($a:=1; $b:=2; $c:=($a:=4; $a+$b); [$a, $b, $c])`, `[1,2,6]`},
+		{`Account.Order.Product.($var1 := Price ; $var2:=Quantity; $var1 * $var2)`, `[68.9,21.67,137.8,107.99]`},
+		{`( $func := function($arg) {$arg.Account.Order[0].OrderID}; $func($) )`, `"order103"`},
+		{`( $func := function($arg) {$arg.Account.Order[0]}; $func($).OrderID )`, `"order103"`},
+		{`Account.( $AccName := function() { $.'Account Name' }; Order[OrderID = 'order104'].Product{ 'Account': $AccName(), 'SKU-' & $string(ProductID): $.'Product Name' } )`,
+			`{"Account":"Firefly","SKU-858383":"Bowler Hat","SKU-345664":"Cloak"}`},
+		{`Account.( $AccName := function() { $.'Account Name' }; Order[OrderID = 'order104'].Product.{ 'Account': $AccName(), 'SKU-' & $string(ProductID): $.'Product Name' } )`,
+			`[{"Account":"Firefly","SKU-858383":"Bowler Hat"},{"Account":"Firefly","SKU-345664":"Cloak"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := json.Marshal(eval(t, tt.query, data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
 // In operator tests
 
 func TestEvalIn(t *testing.T) {
@@ -671,3 +1807,802 @@ func TestEvalComplexExpressions(t *testing.T) {
 		})
 	}
 }
+
+func TestReturnedLambdaClosureIsMinimal(t *testing.T) {
+	data := map[string]interface{}{
+		"payload": strings.Repeat("x", 1<<20),
+		"rate":    2.0,
+	}
+	ev := evaluator.New()
+	evalLambda := func(t *testing.T, query string) *evaluator.Lambda {
+		t.Helper()
+		expr, err := parser.Compile(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := ev.Eval(context.Background(), expr, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, ok := result.(*evaluator.Lambda)
+		if !ok {
+			t.Fatalf("expected *evaluator.Lambda, got %T", result)
+		}
+		return l
+	}
+	call := func(t *testing.T, fn *evaluator.Lambda, query string) interface{} {
+		t.Helper()
+		expr, err := parser.Compile(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := ev.EvalWithBindings(context.Background(), expr, nil, map[string]interface{}{"fn": fn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	t.Run("captures referenced variables only", func(t *testing.T) {
+		fn := evalLambda(t, `($k := rate; $unused := payload; function($v) { $v * $k })`)
+		if fn.Ctx.Data() != nil || fn.Ctx.Root().Data() != nil {
+			t.Error("closure should not retain the input document")
+		}
+		if _, found := fn.Ctx.GetBinding("unused"); found {
+			t.Error("closure should not retain unreferenced variables")
+		}
+		if got := call(t, fn, `$fn(21)`); got != 42.0 {
+			t.Errorf("$fn(21) = %v, want 42", got)
+		}
+	})
+
+	t.Run("recursive function", func(t *testing.T) {
+		fn := evalLambda(t, `($f := function($n) { $n <= 1 ? 1 : $n * $f($n - 1) }; $f)`)
+		if got := call(t, fn, `$fn(5)`); got != 120.0 {
+			t.Errorf("$fn(5) = %v, want 120", got)
+		}
+	})
+
+	t.Run("context kept when read", func(t *testing.T) {
+		fn := evalLambda(t, `function($v) { rate * $v }`)
+		if fn.Ctx.Data() == nil {
+			t.Fatal("closure reading a field must keep the context value")
+		}
+		if got := call(t, fn, `$fn(4)`); got != 8.0 {
+			t.Errorf("$fn(4) = %v, want 8", got)
+		}
+	})
+
+	t.Run("implicit context argument", func(t *testing.T) {
+		fn := evalLambda(t, `rate.function() { $string() }`)
+		if got := call(t, fn, `$fn()`); got != "2" {
+			t.Errorf("$fn() = %v, want \"2\"", got)
+		}
+	})
+
+	t.Run("implicit context argument of a lambda", func(t *testing.T) {
+		fn := evalLambda(t, `($f := function($n)<n-:n>{ $n * 3 }; rate.function() { $f() })`)
+		if got := call(t, fn, `$fn()`); got != 6.0 {
+			t.Errorf("$fn() = %v, want 6", got)
+		}
+	})
+
+	t.Run("scope kept for $eval", func(t *testing.T) {
+		fn := evalLambda(t, `($y := 5; function() { $eval("$y") })`)
+		if got := call(t, fn, `$fn()`); got != 5.0 {
+			t.Errorf("$fn() = %v, want 5", got)
+		}
+		fn = evalLambda(t, `function() { $eval("rate") }`)
+		if got := call(t, fn, `$fn()`); got != 2.0 {
+			t.Errorf("$fn() = %v, want 2", got)
+		}
+	})
+}
+
+func TestEvalProfile(t *testing.T) {
+	items := make([]interface{}, 50)
+	for i := range items {
+		items[i] = map[string]interface{}{"n": float64(i), "s": fmt.Sprintf("item%d", i)}
+	}
+	data := map[string]interface{}{"items": items}
+	query := `$count(items[n > 9 and $contains(s, "4")])`
+
+	expr, err := parser.Compile(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval := evaluator.New()
+	profile, err := eval.Profile(context.Background(), expr, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := eval.Eval(context.Background(), expr, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(profile.Result, want) {
+		t.Errorf("Result = %v, want %v", profile.Result, want)
+	}
+
+	byLabel := make(map[string]evaluator.NodeProfile)
+	for i, n := range profile.Nodes {
+		byLabel[n.Label] = n
+		if i > 0 && n.SelfTime > profile.Nodes[i-1].SelfTime {
+			t.Errorf("Nodes not sorted by SelfTime at %d", i)
+		}
+		if n.SelfTime > n.Time || n.Time > profile.Total {
+			t.Errorf("%s: self %v, time %v, total %v", n.Label, n.SelfTime, n.Time, profile.Total)
+		}
+		if n.Position != n.Node.Position {
+			t.Errorf("%s: Position %d, node at %d", n.Label, n.Position, n.Node.Position)
+		}
+	}
+
+	// Minimum call counts: the filter may also probe its predicate once
+	// against the whole collection to detect an index.
+	for label, calls := range map[string]int{
+		`filter`:               1,
+		`binary "and"`:         50,
+		`binary ">"`:           50,
+		`function "$contains"`: 40,
+		`function "$count"`:    1,
+	} {
+		n, ok := byLabel[label]
+		if !ok {
+			t.Errorf("no profile for %s", label)
+			continue
+		}
+		if n.Calls < calls {
+			t.Errorf("%s: Calls = %d, want at least %d", label, n.Calls, calls)
+		}
+	}
+
+	t.Run("recursive calls do not inflate inclusive time", func(t *testing.T) {
+		expr, err := parser.Compile(`($f := function($n) { $n <= 1 ? 1 : $n * $f($n - 1) }; $f(30))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		profile, err := evaluator.New().Profile(context.Background(), expr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range profile.Nodes {
+			if n.Time > profile.Total {
+				t.Errorf("%s: time %v exceeds total %v", n.Label, n.Time, profile.Total)
+			}
+		}
+	})
+
+	t.Run("errors are returned", func(t *testing.T) {
+		expr, err := parser.Compile(`$error("boom")`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := evaluator.New().Profile(context.Background(), expr, nil); err == nil {
+			t.Error("expected evaluation error")
+		}
+	})
+}
+
+func TestEvalCompare(t *testing.T) {
+	decode := func(s string) interface{} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	query := `{"id": id, "name": first & " " & last, "first name": first, "tags": tags, "total": $sum(items.price)}`
+	expr, err := parser.Compile(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval := evaluator.New()
+
+	c, err := eval.EvalCompare(context.Background(), expr,
+		decode(`{"id": 1, "first": "Ada", "last": "Byron", "tags": ["a", "b"], "items": [{"price": 2}, {"price": 3}]}`),
+		decode(`{"last": "Byron", "first": "Ada", "id": 1.0, "tags": ["a", "b"], "items": [{"price": 5}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equal() {
+		t.Errorf("got differences %v, want none", c.Diffs)
+	}
+
+	c, err = eval.EvalCompare(context.Background(), expr,
+		decode(`{"id": 1, "first": "Ada", "last": "Byron", "tags": ["a", "b"], "items": [{"price": 2}]}`),
+		decode(`{"id": 2, "first": "Ada Augusta", "tags": ["a", "c", "d"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range c.Diffs {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`$.id: 1 -> 2`,
+		`$.name: "Ada Byron" -> "Ada Augusta "`,
+		`$["first name"]: "Ada" -> "Ada Augusta"`,
+		`$.tags[1]: "b" -> "c"`,
+		`$.tags[2]: only in B: "d"`,
+		`$.total: only in A: 2`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffs:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if c.Diffs[0].Kind != evaluator.DiffChanged || c.Diffs[5].Kind != evaluator.DiffOnlyA || c.Diffs[4].Kind != evaluator.DiffOnlyB {
+		t.Errorf("unexpected kinds %v", c.Diffs)
+	}
+
+	t.Run("undefined differs from null", func(t *testing.T) {
+		expr, err := parser.Compile(`x`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eval := evaluator.New(evaluator.WithNullHandling(evaluator.NullAsValue))
+		c, err := eval.EvalCompare(context.Background(), expr, decode(`{"x": null}`), decode(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Diffs) != 1 || c.Diffs[0].Path != "$" || c.Diffs[0].Kind != evaluator.DiffOnlyA {
+			t.Errorf("got %v, want the result only in A", c.Diffs)
+		}
+	})
+
+	t.Run("evaluation error", func(t *testing.T) {
+		expr, err := parser.Compile(`x + 1`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := eval.EvalCompare(context.Background(), expr, decode(`{"x": 1}`), decode(`{"x": "a"}`)); err == nil {
+			t.Error("got no error for B")
+		}
+	})
+}
+
+func TestWithNullHandling(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{"a": null, "b": [1, null], "o": {"k": null, "v": 1}}`), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		mode  evaluator.NullHandling
+		want  string // %#v of the result, or JSON when prefixed with "json:"
+	}{
+		{`null`, evaluator.NullAsNil, `<nil>`},
+		{`null`, evaluator.NullAsValue, `types.Null{}`},
+		{`null`, evaluator.NullDrop, `<nil>`},
+		{`missing`, evaluator.NullAsValue, `<nil>`},
+		{`a`, evaluator.NullAsValue, `types.Null{}`},
+		{`b`, evaluator.NullAsNil, `[]interface {}{1, interface {}(nil)}`},
+		{`b`, evaluator.NullAsValue, `[]interface {}{1, types.Null{}}`},
+		{`b`, evaluator.NullDrop, `[]interface {}{1, interface {}(nil)}`},
+		{`{"x": null, "y": 1, "z": [null]}`, evaluator.NullDrop, `json:{"y":1,"z":[null]}`},
+		{`{"x": null, "y": 1}`, evaluator.NullAsNil, `json:{"x":null,"y":1}`},
+		{`o`, evaluator.NullDrop, `json:{"v":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.query, tt.mode), func(t *testing.T) {
+			expr, err := parser.Compile(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := evaluator.New(evaluator.WithNullHandling(tt.mode)).Eval(context.Background(), expr, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := fmt.Sprintf("%#v", result)
+			if want, ok := strings.CutPrefix(tt.want, "json:"); ok {
+				b, _ := json.Marshal(result)
+				got, tt.want = string(b), want
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The input itself is left as decoded.
+	if _, present := data.(map[string]interface{})["o"].(map[string]interface{})["k"]; !present {
+		t.Error("NullDrop removed a field from the input")
+	}
+}
+
+func TestWithResultTransformer(t *testing.T) {
+	// plainMaps turns OrderedObjects into maps, recursively.
+	var plainMaps func(v interface{}) interface{}
+	plainMaps = func(v interface{}) interface{} {
+		switch val := v.(type) {
+		case *evaluator.OrderedObject:
+			m := make(map[string]interface{}, len(val.Keys))
+			for _, k := range val.Keys {
+				m[k] = plainMaps(val.Values[k])
+			}
+			return m
+		case []interface{}:
+			out := make([]interface{}, len(val))
+			for i, item := range val {
+				out[i] = plainMaps(item)
+			}
+			return out
+		}
+		return v
+	}
+	var calls []string
+	eval := evaluator.New(
+		evaluator.WithResultTransformer(func(v interface{}) (interface{}, error) {
+			calls = append(calls, "plain")
+			return plainMaps(v), nil
+		}),
+		evaluator.WithResultTransformer(func(v interface{}) (interface{}, error) {
+			calls = append(calls, "redact")
+			if m, ok := v.(map[string]interface{}); ok {
+				delete(m, "secret")
+			}
+			return v, nil
+		}),
+	)
+
+	expr, err := parser.Compile(`{"name": name, "secret": pw, "tags": [{"t": 1}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := eval.Eval(context.Background(), expr, map[string]interface{}{"name": "ada", "pw": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "ada", "tags": []interface{}{map[string]interface{}{"t": 1.0}}}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %#v, want %#v", result, want)
+	}
+	if strings.Join(calls, ",") != "plain,redact" {
+		t.Errorf("hooks ran as %v", calls)
+	}
+
+	t.Run("EvalIter applies hooks per item", func(t *testing.T) {
+		double := evaluator.New(evaluator.WithResultTransformer(func(v interface{}) (interface{}, error) {
+			if f, ok := v.(float64); ok {
+				return f * 2, nil
+			}
+			return v, nil
+		}))
+		for _, query := range []string{`nums[$ > 1]`, `$append(nums, 4)`} {
+			expr, err := parser.Compile(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seq, err := double.EvalIter(context.Background(), expr, map[string]interface{}{"nums": []interface{}{1.0, 2.0, 3.0}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []interface{}
+			for item, err := range seq {
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, item)
+			}
+			if len(got) < 2 || got[len(got)-1].(float64) < 6 {
+				t.Errorf("%s: items not transformed: %v", query, got)
+			}
+		}
+	})
+
+	t.Run("errors fail the evaluation", func(t *testing.T) {
+		boom := errors.New("boom")
+		failing := evaluator.New(evaluator.WithResultTransformer(func(interface{}) (interface{}, error) {
+			return nil, boom
+		}))
+		expr, err := parser.Compile(`1`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := failing.EvalWithBindings(context.Background(), expr, nil, nil); !errors.Is(err, boom) {
+			t.Errorf("err = %v, want boom", err)
+		}
+	})
+}
+
+func TestWithIntegerResults(t *testing.T) {
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{`42`, int64(42)},
+		{`-0`, int64(0)},
+		{`2.5`, 2.5},
+		{`9007199254740992`, int64(9007199254740992)},
+		{`1e300`, 1e300},
+		{`[1, 1.5, {"id": 7}]`, []interface{}{int64(1), 1.5, map[string]interface{}{"id": int64(7)}}},
+		{`"1"`, "1"},
+		{`items.id`, []interface{}{int64(1), int64(2)}},
+	}
+	data := map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"id": 1.0},
+		map[string]interface{}{"id": 2.0},
+	}}
+	eval := evaluator.New(evaluator.WithIntegerResults(true))
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := parser.Compile(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := eval.Eval(context.Background(), expr, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Constructed objects are OrderedObjects: compare their values.
+			if obj, ok := result.([]interface{}); ok && len(obj) == 3 {
+				if m, ok := obj[2].(*evaluator.OrderedObject); ok {
+					obj[2] = m.Values
+				}
+			}
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("got %#v, want %#v", result, tt.want)
+			}
+		})
+	}
+
+	if id := data["items"].([]interface{})[0].(map[string]interface{})["id"]; id != 1.0 {
+		t.Errorf("input modified: id = %#v", id)
+	}
+}
+
+// TestRootVariableInNestedContexts checks that $$ is the input of the
+// evaluation wherever it is read: in callbacks over derived data, in returned
+// and stored lambdas, and in expressions run by $eval.
+func TestRootVariableInNestedContexts(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{"k": "root", "a": [{"x": 1}, {"x": 2}], "b": {"c": [1, 2]}}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{`$map(a, function($v) { $$.k })`, []interface{}{"root", "root"}},
+		{`$map(a.x, function($v) { $map([$v * 10], function($w) { $$.k & $w }) })`, []interface{}{"root10", "root20"}},
+		{`$reduce(b.c, function($acc, $v) { $acc & $$.k }, "")`, "rootroot"},
+		{`$sift(b, function($v) { $$.k = "root" }).c`, []interface{}{1.0, 2.0}},
+		{`($f := function() { function() { $$.k } }; $f()())`, "root"},
+		{`($fs := a.(function() { $$.k & x }); $fs[1]())`, "root2"},
+		{`a.($eval("$$.k & x"))`, []interface{}{"root1", "root2"}},
+		{`$eval("$$.k", {"k": "other"})`, "root"},
+		{`$eval("k", {"k": "other"})`, "other"},
+		{`($x := 5; $eval("$x + 1"))`, 6.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := eval(t, tt.query, data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalDepthLimits(t *testing.T) {
+	ctx := context.Background()
+	// countdown recurses n calls deep: the addition keeps the call out of tail
+	// position.
+	countdown := func(n int) *types.Expression {
+		return mustCompile(t, fmt.Sprintf(`($f := function($n) { $n = 0 ? 0 : 1 + $f($n - 1) }; $f(%d))`, n))
+	}
+	// nested is a n-deep array constructor.
+	nested := func(n int) *types.Expression {
+		expr, err := parser.Compile(strings.Repeat("[", n)+"1"+strings.Repeat("]", n), parser.WithMaxDepth(n+10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return expr
+	}
+	isU1001 := func(err error) bool {
+		var jerr *types.Error
+		return errors.As(err, &jerr) && jerr.Code == types.ErrUndefinedVariable
+	}
+
+	t.Run("recursion within the default limits", func(t *testing.T) {
+		got, err := evaluator.New().Eval(ctx, countdown(5000), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != float64(5000) {
+			t.Errorf("got %v, want 5000", got)
+		}
+	})
+
+	t.Run("MaxDepth bounds function recursion", func(t *testing.T) {
+		ev := evaluator.New(evaluator.WithMaxDepth(100))
+		if _, err := ev.Eval(ctx, countdown(99), nil); err != nil {
+			t.Errorf("99 calls: %v", err)
+		}
+		if _, err := ev.Eval(ctx, countdown(101), nil); !isU1001(err) {
+			t.Errorf("101 calls: got %v, want U1001", err)
+		}
+		// Tail calls do not nest.
+		tail := mustCompile(t, `($f := function($n, $acc) { $n = 0 ? $acc : $f($n - 1, $acc + 1) }; $f(1000, 0))`)
+		if got, err := ev.Eval(ctx, tail, nil); err != nil || got != float64(1000) {
+			t.Errorf("tail calls: got %v, %v", got, err)
+		}
+		// Nested expressions do not count as recursion.
+		if _, err := ev.Eval(ctx, nested(500), nil); err != nil {
+			t.Errorf("nested expression: %v", err)
+		}
+	})
+
+	t.Run("MaxNestingDepth bounds nesting", func(t *testing.T) {
+		ev := evaluator.New(evaluator.WithMaxNestingDepth(200))
+		if _, err := ev.Eval(ctx, nested(150), nil); err != nil {
+			t.Errorf("150 levels: %v", err)
+		}
+		if _, err := ev.Eval(ctx, nested(250), nil); !isU1001(err) {
+			t.Errorf("250 levels: got %v, want U1001", err)
+		}
+		if _, err := ev.Eval(ctx, countdown(100), nil); !isU1001(err) {
+			t.Errorf("recursion: got %v, want U1001", err)
+		}
+	})
+}
+
+func TestEvaluatorStats(t *testing.T) {
+	ctx := context.Background()
+	expr := mustCompile(t, `$sum(items.price)`)
+	data := map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"price": 1.0},
+		map[string]interface{}{"price": 2.0},
+	}}
+
+	if s := evaluator.New().Stats(); s != (evaluator.Stats{}) {
+		t.Errorf("stats disabled: got %+v, want zero", s)
+	}
+
+	var mu sync.Mutex
+	var hooked []evaluator.Stats
+	ev := evaluator.New(evaluator.WithStatsHook(func(s evaluator.Stats) {
+		mu.Lock()
+		hooked = append(hooked, s)
+		mu.Unlock()
+	}))
+	if _, err := ev.Eval(ctx, expr, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ev.Eval(ctx, mustCompile(t, `$error("boom")`), nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	iter, err := ev.EvalIter(ctx, mustCompile(t, `items[price > 1]`), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range iter {
+	}
+
+	s := ev.Stats()
+	if s.Evaluations != 3 || s.Errors != 1 {
+		t.Errorf("got %d evaluations, %d errors; want 3, 1", s.Evaluations, s.Errors)
+	}
+	if len(hooked) != 3 || hooked[0].Evaluations != 1 || hooked[0].Errors != 0 || hooked[1].Errors != 1 {
+		t.Fatalf("hook got %+v", hooked)
+	}
+	var nodes uint64
+	for _, h := range hooked {
+		if h.Nodes == 0 {
+			t.Errorf("hook got no nodes: %+v", h)
+		}
+		nodes += h.Nodes
+	}
+	if s.Nodes != nodes {
+		t.Errorf("got %d nodes, hooks saw %d", s.Nodes, nodes)
+	}
+
+	if before := ev.ResetStats(); before != s {
+		t.Errorf("ResetStats returned %+v, want %+v", before, s)
+	}
+	if s := ev.Stats(); s != (evaluator.Stats{}) {
+		t.Errorf("after reset: got %+v, want zero", s)
+	}
+
+	// The & of a chain, evaluated in one go, count as the nodes they are.
+	if _, err := ev.Eval(ctx, mustCompile(t, `"a" & "b" & "c"`), nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := ev.ResetStats(); s.Nodes != 5 {
+		t.Errorf("chain of &: got %d nodes, want 5", s.Nodes)
+	}
+}
+
+// TestTupleStreamBindings checks that the variables bound by @ and # survive
+// function calls and group-by constructors applied to the tuple stream, with
+// the results of the reference implementation.
+func TestTupleStreamBindings(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{"library": {
+		"books": [
+			{"title": "Structure and Interpretation", "isbn": "A", "price": 10},
+			{"title": "Compilers", "isbn": "B", "price": 20},
+			{"title": "Godel", "isbn": "C", "price": 30}
+		],
+		"loans": [
+			{"customer": "10001", "isbn": "A"},
+			{"customer": "10003", "isbn": "B"},
+			{"customer": "10003", "isbn": "C"}
+		],
+		"customers": [
+			{"id": "10001", "name": "Joe"},
+			{"id": "10002", "name": "Fred"},
+			{"id": "10003", "name": "Jason"}
+		]
+	}}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`library.loans@$l.books@$b[$l.isbn=$b.isbn]{ $l.customer: $b.title }`, `{"10001":"Structure and Interpretation","10003":["Compilers","Godel"]}`},
+		{`library.loans@$l.books@$b[$l.isbn=$b.isbn]{ $l.customer: $sum($b.price) }`, `{"10001":10,"10003":50}`},
+		{`library.loans@$l.books@$b[$l.isbn=$b.isbn]{ $l.customer: $count($b) }`, `{"10001":1,"10003":2}`},
+		{`library.loans@$l.books@$b[$l.isbn=$b.isbn]^(>$b.price){ $l.customer: $b.title }`, `{"10003":["Godel","Compilers"],"10001":"Structure and Interpretation"}`},
+		{`library.loans@$l.books@$b[$l.isbn=$b.isbn].customers[id=$l.customer]{ name: $sum($b.price) }`, `{"Joe":10,"Jason":50}`},
+		{`library.loans@$l{ $l.customer: $count($l) }`, `{"10001":1,"10003":2}`},
+		{`library.loans#$i.customer{ $: $i }`, `{"10001":0,"10003":[1,2]}`},
+		{`library.loans#$i.customer{ $: $sum($i) }`, `{"10001":0,"10003":3}`},
+		{`library.loans@$l.books@$b[$l.isbn=$b.isbn].$sum([$b.price, 1])`, `[11,21,31]`},
+		{`library.loans@$l.$string($l.isbn).{"s": $, "c": $l.customer}`, `[{"s":"A","c":"10001"},{"s":"B","c":"10003"},{"s":"C","c":"10003"}]`},
+		{`library.loans#$i.$uppercase(isbn).{"s": $, "i": $i}`, `[{"s":"A","i":0},{"s":"B","i":1},{"s":"C","i":2}]`},
+		{`$sum(library.loans@$l.books@$b[$l.isbn=$b.isbn].$b.price)`, `60`},
+		{`$count(library.loans@$l.books@$b[$l.isbn=$b.isbn])`, `3`},
+		{`library.loans@$l.books@$b[$l.isbn=$b.isbn] ~> $count`, `3`},
+		{`$min(library.loans@$l.books@$b[$l.isbn=$b.isbn].$b.price)`, `10`},
+		{`$max(library.books#$i.$i)`, `2`},
+		{`$average(library.books#$i[$i>0].price)`, `25`},
+		{`$count(library.books#$i.[title, isbn])`, `3`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(eval(t, tt.query, data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s\n got %s\nwant %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestEvalWithBindingsValidation(t *testing.T) {
+	ctx := context.Background()
+	ev := evaluator.New()
+
+	for _, name := range []string{"", "$", "$x", "a b", "a.b", "sum"} {
+		_, err := ev.EvalWithBindings(ctx, mustCompile(t, `1`), nil, map[string]interface{}{name: 1.0})
+		var berr *evaluator.BindingError
+		if !errors.As(err, &berr) || berr.Name != name {
+			t.Errorf("binding %q: got %v, want a BindingError", name, err)
+		}
+	}
+	got, err := evaluator.New(evaluator.WithFunctionShadowing(true)).EvalWithBindings(ctx,
+		mustCompile(t, `$sum`), nil, map[string]interface{}{"sum": 3.0})
+	if err != nil || got != 3.0 {
+		t.Errorf("shadowing allowed: got %v, %v", got, err)
+	}
+
+	type item struct {
+		Name  string    `json:"name"`
+		Price int       `json:"price"`
+		Tags  []string  `json:"tags,omitempty"`
+		At    time.Time `json:"-"`
+	}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6_000_000, time.UTC)
+	bindings := map[string]interface{}{
+		"items": []item{{Name: "a", Price: 2, Tags: []string{"x"}}, {Name: "b", Price: 3}},
+		"ptr":   &item{Name: "p"},
+		"at":    at,
+		"limit": int32(2),
+		"names": map[string]int{"n": 1},
+		"raw":   []interface{}{1.0, uint8(2)},
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`$items[price > $limit].name`, `"b"`},
+		{`$items.tags`, `"x"`},
+		{`$ptr.name`, `"p"`},
+		{`$at`, `"2024-01-02T03:04:05.006Z"`},
+		{`$toMillis($at)`, `1704164645006`},
+		{`$names.n + $sum($raw)`, `4`},
+	}
+	for _, tt := range tests {
+		got, err := ev.EvalWithBindings(ctx, mustCompile(t, tt.query), nil, bindings)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if js, _ := json.Marshal(got); string(js) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.query, js, tt.want)
+		}
+	}
+	if raw := bindings["raw"].([]interface{}); raw[1] != uint8(2) {
+		t.Errorf("bindings modified: %v", raw)
+	}
+
+	_, err = ev.EvalWithBindings(ctx, mustCompile(t, `$c`), nil, map[string]interface{}{"c": make(chan int)})
+	var berr *evaluator.BindingError
+	if !errors.As(err, &berr) || berr.Name != "c" {
+		t.Errorf("channel: got %v, want a BindingError", err)
+	}
+}
+
+func TestWithDebugger(t *testing.T) {
+	ctx := context.Background()
+	expr := mustCompile(t, `($x := 2; a.($ * $x))`)
+	data := map[string]interface{}{"a": []interface{}{1.0, 2.0}}
+	run := func(hook evaluator.DebugHook, breakpoints ...int) (interface{}, error) {
+		return evaluator.New(evaluator.WithDebugger(hook, breakpoints...)).Eval(ctx, expr, data)
+	}
+
+	// Stepping pauses at every node, with the data and variables in scope.
+	var frames []evaluator.DebugFrame
+	got, err := run(func(_ context.Context, f *evaluator.DebugFrame) evaluator.DebugAction {
+		frames = append(frames, *f)
+		return evaluator.DebugStep
+	})
+	if err != nil || !reflect.DeepEqual(got, []interface{}{2.0, 4.0}) {
+		t.Fatalf("step: got %v, %v", got, err)
+	}
+	if frames[0].Depth != 0 || frames[0].Node != expr.AST() {
+		t.Errorf("first pause: depth %d at %v, want the root", frames[0].Depth, frames[0].Node)
+	}
+	mult := -1
+	for _, f := range frames {
+		if f.Node.Type == types.NodeBinary && f.Node.StrValue == "*" {
+			if f.Bindings["x"] != 2.0 || f.Data != 1.0 && f.Data != 2.0 {
+				t.Errorf("at *: $ = %v, variables %v", f.Data, f.Bindings)
+			}
+			mult = f.Position
+		}
+	}
+	if mult < 0 {
+		t.Fatal("step: no pause at *")
+	}
+
+	// Continuing pauses at the breakpoints only, once per item here.
+	var hits []interface{}
+	if _, err := run(func(_ context.Context, f *evaluator.DebugFrame) evaluator.DebugAction {
+		if f.Breakpoint {
+			hits = append(hits, f.Data)
+		}
+		return evaluator.DebugContinue
+	}, mult); err != nil || !reflect.DeepEqual(hits, []interface{}{1.0, 2.0}) {
+		t.Errorf("continue: hits %v, %v", hits, err)
+	}
+
+	// Stepping over the root runs the whole expression.
+	pauses := 0
+	if _, err := run(func(context.Context, *evaluator.DebugFrame) evaluator.DebugAction {
+		pauses++
+		return evaluator.DebugStepOver
+	}); err != nil || pauses != 1 {
+		t.Errorf("step over: %d pauses, %v", pauses, err)
+	}
+
+	if _, err := run(func(context.Context, *evaluator.DebugFrame) evaluator.DebugAction {
+		return evaluator.DebugAbort
+	}); !errors.Is(err, evaluator.ErrDebugAborted) {
+		t.Errorf("abort: got %v", err)
+	}
+
+	// Every & of a chain pauses.
+	concats := 0
+	concat := mustCompile(t, `"a" & "b" & "c"`)
+	ev := evaluator.New(evaluator.WithDebugger(func(_ context.Context, f *evaluator.DebugFrame) evaluator.DebugAction {
+		if f.Node.Type == types.NodeBinary && f.Node.StrValue == "&" {
+			concats++
+		}
+		return evaluator.DebugStep
+	}))
+	if got, err := ev.Eval(ctx, concat, nil); err != nil || got != "abc" || concats != 2 {
+		t.Errorf("chain of &: got %v, %v after %d pauses at &, want abc after 2", got, err, concats)
+	}
+}
@@ -630,6 +630,39 @@ func TestExtDateTime(t *testing.T) {
 			t.Errorf("$dateEndOf day: got %v, want 86399999", got)
 		}
 	})
+	t.Run("durations", func(t *testing.T) {
+		tests := []struct {
+			query string
+			want  interface{}
+		}{
+			{`$parseDuration("P1DT2H")`, float64(93600000)},
+			{`$parseDuration("PT1,5S")`, float64(1500)},
+			{`$parseDuration("-P2W")`, float64(-1209600000)},
+			{`$formatDuration(93600000)`, "P1DT2H"},
+			{`$formatDuration(-90061001)`, "-P1DT1H1M1.001S"},
+			{`$formatDuration(0)`, "PT0S"},
+			{`$formatDuration($parseDuration("PT90M"))`, "PT1H30M"},
+			{`$fromMillis($dateAddDuration($toMillis("2024-01-31T10:00:00Z"), "P1MT2H30M"))`, "2024-03-02T12:30:00.000Z"},
+			{`$fromMillis($dateAddDuration($toMillis("2024-03-01T00:00:00Z"), "-P1D"))`, "2024-02-29T00:00:00.000Z"},
+			{`$fromMillis($toMillis("2024-01-01T00:00:00Z") + $parseDuration("PT36H"))`, "2024-01-02T12:00:00.000Z"},
+		}
+		for _, tt := range tests {
+			if got := extEval(t, tt.query, nil, opt); got != tt.want {
+				t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+			}
+		}
+		for _, query := range []string{
+			`$parseDuration("P1M")`,
+			`$parseDuration("P")`,
+			`$parseDuration("PT")`,
+			`$parseDuration("1D")`,
+			`$dateAddDuration(0, "P1.5M")`,
+		} {
+			if _, err := gosonata.Eval(query, nil, opt); err == nil {
+				t.Errorf("%s: expected an error", query)
+			}
+		}
+	})
 }
 
 // ── extformat ────────────────────────────────────────────────────────────────
@@ -0,0 +1,67 @@
+package unit_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sandrolain/gosonata/internal/framing"
+)
+
+func TestFramingRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	bodies := []string{`{"a":1}`, ``, `{"text":"héllo\r\n\r\nContent-Length: 3"}`}
+	for _, body := range bodies {
+		if err := framing.Write(&buf, []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.HasPrefix(buf.String(), "Content-Length: 7\r\n\r\n{") {
+		t.Errorf("unexpected framing %q", buf.String())
+	}
+	r := bufio.NewReader(&buf)
+	for _, want := range bodies {
+		got, err := framing.Read(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	if _, err := framing.Read(r); !errors.Is(err, io.EOF) {
+		t.Errorf("after the last message: got %v, want EOF", err)
+	}
+}
+
+func TestFramingRead(t *testing.T) {
+	tests := []struct {
+		name, input, want, err string
+	}{
+		{"other headers", "Content-Type: application/vscode-jsonrpc; charset=utf-8\r\ncontent-length: 2\r\n\r\n{}", "{}", ""},
+		{"padded length", "Content-Length:  2 \r\n\r\n{}", "{}", ""},
+		{"missing length", "Content-Type: text/plain\r\n\r\n{}", "", `invalid Content-Length ""`},
+		{"negative length", "Content-Length: -1\r\n\r\n", "", `invalid Content-Length "-1"`},
+		{"short body", "Content-Length: 10\r\n\r\n{}", "", io.ErrUnexpectedEOF.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := framing.Read(bufio.NewReader(strings.NewReader(tt.input)))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+func TestFreeze(t *testing.T) {
+	ctx := context.Background()
+	type code string
+	countries := []map[string]interface{}{
+		{"code": code("IT"), "population": int64(59), "languages": []string{"it"}},
+		{"code": code("CH"), "population": uint8(9), "languages": []string{"de", "fr", "it"}},
+	}
+	table := map[string]interface{}{
+		"countries": countries,
+		"rates":     map[string]float32{"EUR": 1, "CHF": 0.5},
+		"updated":   json.Number("20240101"),
+		"note":      nil,
+	}
+	frozen, err := evaluator.Freeze(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The value is a copy in the evaluator's representation.
+	countries[0]["code"] = "XX"
+	want := `{"countries":[{"code":"IT","languages":["it"],"population":59},` +
+		`{"code":"CH","languages":["de","fr","it"],"population":9}],"note":null,"rates":{"CHF":0.5,"EUR":1},"updated":20240101}`
+	if got, _ := json.Marshal(frozen); string(got) != want {
+		t.Errorf("frozen value %s, want %s", got, want)
+	}
+	if again, err := evaluator.Freeze(frozen); err != nil || again != frozen {
+		t.Errorf("Freeze of a Frozen value: %p, %v", again, err)
+	}
+
+	ev := evaluator.New()
+	tests := map[string]interface{}{
+		`$t.countries[code = $c].population * $t.rates.CHF`:                 4.5,
+		`$count($t.countries.languages)`:                                    4.0,
+		`$t.updated > 20000000 and $t.note = null`:                          true,
+		`$count(($t ~> |countries|{"code": "ZZ"}|).countries[code = "ZZ"])`: 2.0,
+	}
+	for query, want := range tests {
+		got, err := ev.EvalWithBindings(ctx, mustCompile(t, query), nil, map[string]interface{}{"t": frozen, "c": "CH"})
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v; want %v", query, got, err, want)
+		}
+	}
+	// As the input, and as a document.
+	if got, err := ev.Eval(ctx, mustCompile(t, `countries[0].code`), frozen); err != nil || got != "IT" {
+		t.Errorf("input: got %v, %v", got, err)
+	}
+	docs := evaluator.New(evaluator.WithDocumentLoader(func(name string) (interface{}, error) { return frozen, nil }))
+	if got, err := docs.Eval(ctx, mustCompile(t, `$doc("t").rates.EUR`), nil); err != nil || got != 1.0 {
+		t.Errorf("$doc: got %v, %v", got, err)
+	}
+	if v := evaluator.ValueOf(frozen); v.Kind() != evaluator.KindObject || v.Get("rates").Get("CHF").Number() != 0.5 {
+		t.Errorf("ValueOf: %v", v.Kind())
+	}
+
+	// Transforms never update a frozen value in place, and concurrent
+	// evaluations share it.
+	inPlace := evaluator.New(evaluator.WithAllowInPlace(true))
+	update := mustCompile(t, `$ ~> |countries|{"population": population + 1}|`)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := inPlace.Eval(ctx, update, frozen); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := inPlace.EvalWithBindings(ctx, mustCompile(t, `$t ~> |rates|{"EUR": 2}|`), nil, map[string]interface{}{"t": frozen}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got, _ := json.Marshal(frozen); string(got) != want {
+		t.Errorf("frozen value modified: %s", got)
+	}
+}
+
+func TestFreezeErrors(t *testing.T) {
+	cyclic := map[string]interface{}{}
+	cyclic["self"] = []interface{}{cyclic}
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{map[string]interface{}{"n": math.NaN()}, "non-finite number"},
+		{[]interface{}{math.Inf(1)}, "non-finite number"},
+		{map[string]interface{}{"c": make(chan int)}, "unsupported value of type chan int"},
+		{struct{ A int }{1}, "unsupported value of type struct"},
+		{map[int]string{1: "a"}, "unsupported value of type map[int]string"},
+		{json.Number("1x"), "invalid number"},
+	}
+	for _, tt := range tests {
+		if _, err := evaluator.Freeze(tt.value); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Freeze(%T): got %v, want %q", tt.value, err, tt.want)
+		}
+	}
+	var jerr *types.Error
+	if _, err := evaluator.Freeze(cyclic); !errors.As(err, &jerr) || jerr.Code != types.ErrDataCycle {
+		t.Errorf("cycle: got %v, want D1010", err)
+	}
+	if f, err := evaluator.Freeze(nil); err != nil || f.Value() != nil {
+		t.Errorf("nil: got %v, %v", f, err)
+	}
+	if f, err := evaluator.Freeze([]int(nil)); err != nil || !reflect.DeepEqual(f.Value(), nil) {
+		t.Errorf("nil slice: got %v, %v", f, err)
+	}
+}
+
+// TestFrozenFilterIndex checks that equality filters over the arrays of a
+// frozen value are answered from an index, with the results of a scan.
+func TestFrozenFilterIndex(t *testing.T) {
+	ctx := context.Background()
+	countries := make([]interface{}, 200)
+	for i := range countries {
+		country := map[string]interface{}{"code": fmt.Sprintf("C%d", i%150), "n": float64(i)}
+		switch i {
+		case 7:
+			delete(country, "code")
+		case 8:
+			country["code"] = nil
+		case 9:
+			country["code"] = 42.0
+		case 10:
+			country["code"] = []interface{}{"C1"}
+		}
+		countries[i] = country
+	}
+	frozen, err := evaluator.Freeze(countries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nodes uint64
+	ev := evaluator.New(evaluator.WithStatsHook(func(s evaluator.Stats) { nodes = s.Nodes }))
+	// indexed is false for the filters that cannot use an index: a null
+	// literal is not recognised as a lookup value, and an undefined one
+	// matches nothing.
+	tests := []struct {
+		query   string
+		indexed bool
+	}{
+		{`$countries[code = $c].n`, true},
+		{`$countries[$c = code].n`, true},
+		{`$countries[code = "C149"]`, true},
+		{`$countries[code = 42].n`, true},
+		{`$countries[code = "none"]`, true},
+		{`$map(["C1", "C2", "C3"], function($c) { $countries[code = $c].n })`, true},
+		{`$countries[code = null].n`, false},
+		{`$countries[code = $missing]`, false},
+	}
+	for _, tt := range tests {
+		expr := mustCompile(t, tt.query)
+		bindings := map[string]interface{}{"c": "C3"}
+		bindings["countries"] = countries
+		want, err := ev.EvalWithBindings(ctx, expr, nil, bindings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanned := nodes
+		bindings["countries"] = frozen
+		got, err := ev.EvalWithBindings(ctx, expr, nil, bindings)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, %v; want %v", tt.query, got, err, want)
+		}
+		if tt.indexed && nodes*4 > scanned {
+			t.Errorf("%s: %d nodes evaluated, %d with a scan", tt.query, nodes, scanned)
+		}
+	}
+
+	// A boolean field is not indexed; under WithStrictNavigation, neither is
+	// a field some objects lack.
+	strict := evaluator.New(evaluator.WithStrictNavigation(true))
+	if _, err := strict.EvalWithBindings(ctx, mustCompile(t, `$countries[code = "C1"]`), nil, map[string]interface{}{"countries": frozen}); err == nil {
+		t.Error("strict navigation: a missing code field was not reported")
+	}
+	flags := make([]interface{}, 20)
+	for i := range flags {
+		flags[i] = map[string]interface{}{"on": i%2 == 0, "n": float64(i)}
+	}
+	frozenFlags, err := evaluator.Freeze(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ev.EvalWithBindings(ctx, mustCompile(t, `$count($flags[on = true])`), nil, map[string]interface{}{"flags": frozenFlags})
+	if err != nil || got != 10.0 {
+		t.Errorf("boolean field: got %v, %v", got, err)
+	}
+
+	// Evaluations on several goroutines share the indexes.
+	expr := mustCompile(t, `$countries[code = $c].n`)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				c := fmt.Sprintf("C%d", 100+(g+i)%50)
+				got, err := evaluator.New().EvalWithBindings(ctx, expr, nil, map[string]interface{}{"countries": frozen, "c": c})
+				if err != nil || got != float64(100+(g+i)%50) {
+					t.Errorf("%s: got %v, %v", c, got, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
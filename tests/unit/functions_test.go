@@ -1,8 +1,21 @@
 package unit_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
 )
 
 // --- Aggregation Function Tests ---
@@ -110,6 +123,17 @@ func TestFnString(t *testing.T) {
 	}{
 		{"number to string", "$string(42)", "42"},
 		{"boolean to string", "$string(true)", "true"},
+		{"rounded to 15 digits", "$string(0.1 + 0.2)", "0.3"},
+		{"repeated nines kept", "$string(1.00009999)", "1.00009999"},
+		{"repeated zeros kept", "$string(1.5000001)", "1.5000001"},
+		{"long fraction", "$string(1.23456789012345678)", "1.23456789012346"},
+		{"small positional", "$string(-0.0000012345)", "-0.0000012345"},
+		{"small exponential", "$string(1.5e-7)", "1.5e-7"},
+		{"large positional", "$string(123456789012345000000)", "123456789012345000000"},
+		{"large exponential", "$string(1e21)", "1e+21"},
+		{"rounds up to exponential", "$string(999999999999999999999)", "1e+21"},
+		{"subnormal", "$string(5e-324)", "5e-324"},
+		{"concatenation", `"x" & 90.57`, "x90.57"},
 	}
 
 	for _, tt := range tests {
@@ -175,6 +199,36 @@ func TestFnSubstring(t *testing.T) {
 	}
 }
 
+func TestStringSemantics(t *testing.T) {
+	data := map[string]interface{}{"s": "a😀b"}
+	tests := []struct {
+		query      string
+		runes, u16 interface{}
+	}{
+		{`$length(s)`, 3.0, 4.0},
+		{`$substring(s, 1, 1)`, "😀", "\uFFFD"},
+		{`$substring(s, 1, 2)`, "😀b", "😀"},
+		{`$substring(s, -1)`, "b", "b"},
+		{`$pad(s, 5, "-")`, "a😀b--", "a😀b-"},
+		{`$pad("x", -3, "😀")`, "😀😀x", "😀x"},
+	}
+	for _, tt := range tests {
+		expr, err := parser.Compile(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for mode, want := range map[evaluator.StringSemantics]interface{}{
+			evaluator.StringRunes: tt.runes,
+			evaluator.StringUTF16: tt.u16,
+		} {
+			got, err := evaluator.New(evaluator.WithStringSemantics(mode)).Eval(context.Background(), expr, data)
+			if err != nil || got != want {
+				t.Errorf("%s (mode %d): got %#v, %v; want %#v", tt.query, mode, got, err, want)
+			}
+		}
+	}
+}
+
 func TestFnUpperLowercase(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -351,6 +405,65 @@ func TestFnNumber(t *testing.T) {
 	}
 }
 
+func TestFnNumberCastErrors(t *testing.T) {
+	for _, query := range []string{
+		`$number("1e400")`, `$number("Infinity")`, `$number("NaN")`,
+		`$number("abc")`, `$number("")`, `$number("0x-1")`, `$number("1_000")`,
+	} {
+		err := evalExpectError(t, query, nil)
+		if err == nil || !strings.Contains(err.Error(), "D3030") {
+			t.Errorf("%s: want D3030, got %v", query, err)
+		}
+	}
+	if got := eval(t, `$number("0x1F") + $number("-0.5e2")`, nil); got != -19.0 {
+		t.Errorf("got %v, want -19", got)
+	}
+}
+
+func TestNonFiniteNumbers(t *testing.T) {
+	data := map[string]interface{}{
+		"inf":  math.Inf(1),
+		"ninf": math.Inf(-1),
+		"nan":  math.NaN(),
+	}
+	for _, query := range []string{
+		"$sum([1e308, 1e308])", "$average([1e308, 1e308])", "1e308 * 10", "0 / 0",
+		"$sum([inf])", "$max([nan, 1])", "$abs(ninf)", "$floor(nan)", "$number(inf)",
+		"$sqrt(inf)", "inf + 1", `$string({"a": inf})`,
+	} {
+		err := evalExpectError(t, query, data)
+		if err == nil || !strings.Contains(err.Error(), "D1001") {
+			t.Errorf("%s: want D1001, got %v", query, err)
+		}
+	}
+	if err := evalExpectError(t, "$string(inf)", data); err == nil || !strings.Contains(err.Error(), "D3001") {
+		t.Errorf("$string(inf): want D3001, got %v", err)
+	}
+	// Selected as is, an input value is returned unchanged.
+	if got, ok := eval(t, "inf", data).(float64); !ok || !math.IsInf(got, 1) {
+		t.Errorf("inf: got %v", got)
+	}
+}
+
+func TestNegativeZero(t *testing.T) {
+	data := map[string]interface{}{"nz": math.Copysign(0, -1)}
+	for _, query := range []string{"0 * -1", "$round(-0.4)", "nz", "[nz][0]", `{"a": 0 * -1}.a`} {
+		got, ok := eval(t, query, data).(float64)
+		if !ok || got != 0 || math.Signbit(got) {
+			t.Errorf("%s: got %v, want 0", query, got)
+		}
+	}
+	for _, query := range []string{"-0 = 0", "nz = 0", "$string(nz) = '0'", "$count($distinct([nz, 0])) = 1"} {
+		if got := eval(t, query, data); got != true {
+			t.Errorf("%s: got %v, want true", query, got)
+		}
+	}
+	out, err := json.Marshal(eval(t, `{"a": [0 * -1, $ceil(-0.5)]}`, nil))
+	if err != nil || string(out) != `{"a":[0,0]}` {
+		t.Errorf("got %s (%v), want {\"a\":[0,0]}", out, err)
+	}
+}
+
 func TestFnBoolean(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -377,6 +490,53 @@ func TestFnBoolean(t *testing.T) {
 	}
 }
 
+// TestTruthiness checks that $boolean, the conditional operators, and/or,
+// filter predicates and $filter agree on the effective boolean value.
+func TestTruthiness(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+		index bool // a number (or numbers) in a predicate selects by index
+	}{
+		{`0`, false, true},
+		{`-1.5`, true, true},
+		{`""`, false, false},
+		{`"false"`, true, false},
+		{`null`, false, false},
+		{`[]`, false, false},
+		{`[0]`, false, true},
+		{`[false, "", [0]]`, false, false},
+		{`[0, "x"]`, true, false},
+		{`{}`, false, false},
+		{`{"a": false}`, true, false},
+		{`$sum`, false, false},
+		{`function() { 1 }`, false, false},
+		{`/x/`, false, false},
+	}
+	for _, tt := range tests {
+		for _, form := range []string{
+			`$boolean(%s)`,
+			`(%s) ? true : false`,
+			`(%s) and true`,
+			`false or (%s)`,
+			`$not(%s) = false`,
+			`$count([1][%s]) = 1`,
+			`$count($filter([1], function() { (%s) })) = 1`,
+			`$exists((%s) ?: undefined)`,
+		} {
+			if tt.index && strings.HasPrefix(form, "$count([1]") {
+				continue
+			}
+			query := fmt.Sprintf(form, tt.value)
+			t.Run(query, func(t *testing.T) {
+				if got := eval(t, query, nil); got != tt.want {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
 // --- Math Function Tests ---
 
 func TestFnAbs(t *testing.T) {
@@ -527,6 +687,48 @@ func TestFnReduce(t *testing.T) {
 	}
 }
 
+func TestFnReduceDone(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  interface{}
+	}{
+		{"threshold", "$reduce([1..1000], function($acc, $x) { $acc + $x > 10 ? $done($acc) : $acc + $x }, 0)", 10.0},
+		{"search", `$reduce(["a", "b", "c"], function($acc, $x, $i) { $x = "b" ? $done($i) : $acc }, -1)`, 1.0},
+		{"undefined", "$reduce([1, 2, 3], function($acc, $x) { $x = 2 ? $done() : $acc + $x })", nil},
+		{"nested", "$reduce([[1, 2], [3]], function($acc, $v) { $acc + $reduce($v, function($a, $x) { $done($a) }) }, 0)", 4.0},
+		// The callback would fail on the last item: reaching it means no early exit.
+		{"stops", `$reduce([1, 2, 3], function($acc, $x) { $x = 3 ? $error("scanned") : $done($x) })`, 2.0},
+		{"in blocks", "$reduce([1, 2, 3], function($acc, $x) { ($y := $x * 10; ($x = 3 ? $done($acc + $y) : $acc + $x)) })", 33.0},
+		{"tail call", "($f := function($acc, $x) { $x > 2 ? $done($acc) : $f($acc, $x + 1) }; $reduce([1, 2], $f))", 1.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compareValue(t, eval(t, tt.query, nil), tt.want)
+		})
+	}
+
+	// $done only stops the reduction as the value the callback returns, and
+	// fails anywhere else rather than leaving its marker in a value.
+	for _, query := range []string{
+		"$done(1)",
+		`$reduce([1, 2, 3], function($acc, $x) { {"x": $done($x)} })`,
+		"$reduce([1, 2, 3], function($acc, $x) { [$done($x)] })",
+		"$reduce([1, 2, 3], function($acc, $x) { $done($x) + 1 })",
+		"$reduce([1, 2, 3], function($acc, $x) { ($y := $done($x); $y) })",
+		"$reduce([1, 2, 3], function($acc, $x) { $map([$x], function($v) { $done($v) }) })",
+		"$reduce([1, 2, 3], function($acc, $x) { $x ~> $done() })",
+		"$reduce([1, 2, 3], function($acc, $x) { [$done][0]($x) })",
+		"($f := function($acc, $x) { $x > 2 ? $done($acc) : $f($acc, $x + 1) + 1 }; $reduce([1, 2], $f))",
+		"$map([1, 2], function($v) { $done($v) })",
+	} {
+		if err := evalExpectError(t, query, nil); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
 func TestFnSort(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -595,6 +797,89 @@ func TestFnReverse(t *testing.T) {
 	}
 }
 
+func TestFnJoinOn(t *testing.T) {
+	data := map[string]interface{}{
+		"orders": []interface{}{
+			map[string]interface{}{"id": 1.0, "cust": "a"},
+			map[string]interface{}{"id": 2.0, "cust": "b"},
+			map[string]interface{}{"id": 3.0, "cust": "z"},
+		},
+		"customers": []interface{}{
+			map[string]interface{}{"key": "a", "name": "Ann"},
+			map[string]interface{}{"key": "b", "name": "Bob"},
+			map[string]interface{}{"key": "q", "name": "Quinn"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  interface{}
+	}{
+		{"inner", `$joinOn(orders, customers, "cust", "key").right.name`, []interface{}{"Ann", "Bob"}},
+		{"left", `$count($joinOn(orders, customers, "cust", "key", "left"))`, 3.0},
+		{"left unmatched", `$joinOn(orders, customers, "cust", "key", "left")[$not($exists(right))].left.id`, 3.0},
+		{"outer", `$count($joinOn(orders, customers, "cust", "key", "outer"))`, 4.0},
+		{"key function", `$joinOn(orders, customers, function($o){ $uppercase($o.cust) }, function($c){ $uppercase($c.key) }).left.id`, []interface{}{1.0, 2.0}},
+		{"no match", `$joinOn(orders, customers, "id", "key")`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := eval(t, tt.query, data)
+			compareValue(t, result, tt.want)
+		})
+	}
+
+	for _, query := range []string{
+		`$joinOn(orders, customers, "cust", "key", "cross")`,
+		`$joinOn(orders, customers, 1, "key")`,
+		`$joinOn(orders, customers, "cust", ["key"])`,
+	} {
+		if err := evalExpectError(t, query, data); err == nil || !strings.Contains(err.Error(), "D3160") {
+			t.Errorf("%s: expected D3160, got %v", query, err)
+		}
+	}
+}
+
+func TestFnFirstAnyAll(t *testing.T) {
+	data := map[string]interface{}{"items": []interface{}{1.0, 2.0, 3.0, 4.0}}
+	tests := []struct {
+		name  string
+		query string
+		want  interface{}
+	}{
+		{"first", `$first(items)`, 1.0},
+		{"first predicate", `$first(items, function($v) { $v > 2 })`, 3.0},
+		{"first index", `$first(items, function($v, $i) { $i = 1 })`, 2.0},
+		{"first no match", `$first(items, function($v) { $v > 9 })`, nil},
+		{"first empty", `$first([])`, nil},
+		{"first undefined", `$first(missing, function($v) { true })`, nil},
+		{"any", `$any(items, function($v) { $v = 3 })`, true},
+		{"any none", `$any(items, function($v) { $v > 9 })`, false},
+		{"any empty", `$any([], function($v) { true })`, false},
+		{"all", `$all(items, function($v) { $v > 0 })`, true},
+		{"all fails", `$all(items, function($v) { $v < 3 })`, false},
+		{"all empty", `$all([], function($v) { false })`, true},
+		// The callbacks would fail on the last item: reaching it means no short-circuit.
+		{"first stops", `$first(items, function($v) { $v = 4 ? $error("scanned") : $v = 2 })`, 2.0},
+		{"any stops", `$any(items, function($v) { $v = 4 ? $error("scanned") : $v = 1 })`, true},
+		{"all stops", `$all(items, function($v) { $v = 4 ? $error("scanned") : $v < 2 })`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := eval(t, tt.query, data)
+			compareValue(t, result, tt.want)
+		})
+	}
+
+	if err := evalExpectError(t, `$any(items, function($v) { $v = 2 ? $error("bad") })`, data); err == nil ||
+		!strings.Contains(err.Error(), "in $any callback at index 1") {
+		t.Errorf("expected annotated callback error, got %v", err)
+	}
+}
+
 // --- Lambda and Apply Tests ---
 
 func TestLambdaBasic(t *testing.T) {
@@ -627,6 +912,37 @@ func TestApplyOperator(t *testing.T) {
 	}
 }
 
+func TestLambdaSignatureArgBinding(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  interface{}
+	}{
+		{"apply with optional arg omitted", `($fn := function($v, $sep)<s-s?:s>{ $v & ($sep ? $sep : "-") }; "a" ~> $fn())`, "a-"},
+		{"apply with optional arg supplied", `($fn := function($v, $sep)<s-s?:s>{ $v & ($sep ? $sep : "-") }; "a" ~> $fn("+"))`, "a+"},
+		{"path call satisfies required arity", `v.function($a, $b)<s-s?:s>{ $a & "|" & ($b ? $b : "none") }("y")`, "y|none"},
+		{"path call injects context", `v.function($a, $b){ $a & "|" & $b }("y")`, "x|y"},
+		{"hof trims to signature arity", `$map([1, 2], function($v, $i)<n-n?:n>{ $v + ($i ? $i : 0) })`, []interface{}{1.0, 3.0}},
+		{"variable path call injects context", `($f := function($s)<s-:s>{ $uppercase($s) }; v.$f())`, "X"},
+		{"variable path call per item", `($f := function($s)<s-:s>{ $uppercase($s) }; [v, "y"].$f())`, []interface{}{"X", "Y"}},
+		{"variable call injects context", `v.($f := function($s)<s-:s>{ $uppercase($s) }; $f())`, "X"},
+		{"variable call satisfies required arity", `($f := function($a, $b)<s-s?:s>{ $a & "|" & ($b ? $b : "none") }; v.$f("y"))`, "y|none"},
+		{"variable call without context marker", `($f := function($a, $b){ $a & "|" & $b }; v.$f("y"))`, "y|"},
+		{"returned closure injects context", `($f := function($s)<s-:s>{ $uppercase($s) }; $g := function(){ v.$f() }; $g())`, "X"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := eval(t, tt.query, map[string]interface{}{"v": "x"})
+			compareValue(t, result, tt.want)
+		})
+	}
+
+	if err := evalExpectError(t, `($f := function($s)<n-:n>{ $s }; v.$f())`, map[string]interface{}{"v": "x"}); err == nil || !strings.Contains(err.Error(), "T0411") {
+		t.Errorf("expected T0411 for an incompatible context value, got %v", err)
+	}
+}
+
 func TestComplexLambda(t *testing.T) {
 	data := map[string]interface{}{
 		"numbers": []interface{}{1.0, 2.0, 3.0, 4.0, 5.0},
@@ -733,6 +1049,101 @@ func TestFnDistinctDeepEquality(t *testing.T) {
 	})
 }
 
+// TestStructuralEquality verifies that `=`, `in` and $distinct compare arrays
+// and objects structurally, an object built by the expression being equal to an
+// input object with the same entries in another order.
+func TestStructuralEquality(t *testing.T) {
+	data := map[string]interface{}{
+		"item":  map[string]interface{}{"b": 2.0, "a": []interface{}{1.0, "x"}},
+		"items": []interface{}{map[string]interface{}{"a": []interface{}{1.0, "x"}, "b": 2.0}},
+		"n":     1,
+	}
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{`{"a": [1, "x"], "b": 2} = item`, true},
+		{`item = {"b": 2, "a": [1, "x"]}`, true},
+		{`{"a": [1, "x"], "b": 3} = item`, false},
+		{`{"a": [1, "x"]} = item`, false},
+		{`{"a": [1, "x"], "b": 2} in items`, true},
+		{`{"a": ["x", 1], "b": 2} in items`, false},
+		{`[n, 2] = [1, 2]`, true},
+		{`{"n": n} = {"n": 1.0}`, true},
+		{`$count($distinct([item, {"a": [1, "x"], "b": 2}, items[0]]))`, 1.0},
+		{`$count($distinct([[1, [2]], [1, [2]], [[1], 2]]))`, 2.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := eval(t, tt.query, data); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestObjectRepresentationEquality verifies that values decoded from JSON (maps,
+// nil for null) and values built by constructors (OrderedObject, types.Null)
+// compare equal when their content is.
+func TestObjectRepresentationEquality(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{"o": {"a": null, "b": [null, {"c": 1}]}, "list": [null, 1]}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{`o = {"b": [null, {"c": 1}], "a": null}`, true},
+		{`o != {"a": null, "b": [null, {"c": 1}]}`, false},
+		{`list = [null, 1]`, true},
+		{`o in [{"a": null, "b": [null, {"c": 1}]}]`, true},
+		{`$count($distinct([o, {"a": null, "b": [null, {"c": 1}]}]))`, 1.0},
+		{`o = {"a": null, "b": [{"c": 1}]}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := eval(t, tt.query, data); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFnSpread covers the $spread edge cases aligned with the reference
+// implementation.
+func TestFnSpread(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`$spread({"a": 1})`, `{"a":1}`},
+		{`$spread({"b": 1, "a": 2})`, `[{"b":1},{"a":2}]`},
+		{`$spread([[{"a": 1}], [{"b": 2, "c": 3}]])`, `[{"a":1},{"b":2},{"c":3}]`},
+		{`$spread([1, [2, {"a": [{"x": 1}]}], null])`, `[1,2,{"a":[{"x":1}]},null]`},
+		{`$spread({"f": function($x){$x}, "g": 1}).g`, `1`},
+		{`$type($spread({"f": function($x){$x}}).f)`, `"function"`},
+		{`$type($spread([function($x){$x}]))`, `"function"`},
+		{`$spread("a")`, `"a"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := json.Marshal(eval(t, tt.query, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+	for _, query := range []string{`$spread([])`, `$spread({})`, `$spread([[], {}])`} {
+		if got := eval(t, query, nil); got != nil {
+			t.Errorf("%s: got %v, want undefined", query, got)
+		}
+	}
+}
+
 // --- $match next() iterator tests ---
 
 // TestFnMatchNextProperty verifies that each match object returned by $match
@@ -797,3 +1208,547 @@ func TestFnMatchNextProperty(t *testing.T) {
 		}
 	})
 }
+
+// TestFnMatch covers string patterns, sequence results and the limit of $match.
+func TestFnMatch(t *testing.T) {
+	data := map[string]interface{}{"s": "abbc ab abbb"}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`$match(s, "ab+").match`, `["abb","ab","abbb"]`},
+		{`$match(s, "a(b+)c").groups`, `["bb"]`},
+		{`$match(s, "A", 2)`, `undefined`},
+		{`$match(s, "(?i)A").index`, `[0,5,8]`},
+		{`$match(s, "a.").match`, `["ab","ab","ab"]`},
+		{`$match(s, /x/)`, `undefined`},
+		{`$match(missing, /a/)`, `undefined`},
+		{`$type($match(s, /ab+/, 1))`, `"object"`},
+		{`$match(s, /ab+/, 1).match`, `"abb"`},
+		{`$match(s, /ab+/, 2).match`, `["abb","ab"]`},
+		{`$match(s, /ab+/, 5).index`, `[0,5,8]`},
+		{`$match(s, /ab+/, 0)`, `undefined`},
+		{`$match(s, /ab+/, undefined).match`, `["abb","ab","abbb"]`},
+		{`$match(s, /c/).match`, `"c"`},
+		{`$match(s, /c/)[0].match`, `"c"`},
+		{`$match(s, /ab+/).[start, end]`, `[[0,3],[5,7],[8,12]]`},
+		{`$match(s, /ab+/).($substring($$.s, start, end - start))`, `["abb","ab","abbb"]`},
+		{`$match("é😀é", /é/).start`, `[0,2]`},
+		{`$replace("é😀é", /é/, function($m) { $string($m.end) })`, `"1😀3"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := "undefined"
+			if v := eval(t, tt.query, data); v != nil {
+				b, err := json.Marshal(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = string(b)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	for _, query := range []string{`$match(s, /a/, -1)`, `$match(s, "[")`} {
+		if err := evalExpectError(t, query, data); err == nil {
+			t.Errorf("%s: want an error", query)
+		}
+	}
+
+	// Offsets follow the string semantics.
+	expr, err := parser.Compile(`$match("é😀é", /é/).[start, end]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := evaluator.New(evaluator.WithStringSemantics(evaluator.StringUTF16)).Eval(context.Background(), expr, nil)
+	if want := []interface{}{[]interface{}{0.0, 1.0}, []interface{}{3.0, 4.0}}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("UTF-16 offsets: got %v, %v; want %v", got, err, want)
+	}
+}
+
+// TestFnIndex covers $index() in filter predicates.
+func TestFnIndex(t *testing.T) {
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"n": "a", "t": []interface{}{1.0, 2.0, 3.0}},
+			map[string]interface{}{"n": "b", "t": []interface{}{4.0}},
+			map[string]interface{}{"n": "c", "t": []interface{}{5.0, 6.0}},
+			map[string]interface{}{"n": "d", "t": []interface{}{}},
+		},
+	}
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{`items[$index() % 2 = 0].n`, []interface{}{"a", "c"}},
+		{`items[$index() >= 1 and $index() < 3].n`, []interface{}{"b", "c"}},
+		{`items[$index() = 3 or n = "a"].n`, []interface{}{"a", "d"}},
+		// Nested filters see the position of their own items.
+		{`items[$count(t[$index() > 0]) > 0].n`, []interface{}{"a", "c"}},
+		// A predicate on a path step counts within each parent.
+		{`items.t[$index() = 0]`, []interface{}{1.0, 4.0, 5.0}},
+		{`$index()`, nil},
+		{`items[0].($index())`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := eval(t, tt.query, data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFnSiftValues covers $siftValues, alone and after a grouping constructor.
+func TestFnSiftValues(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{"items": [
+		{"c": "a", "p": 50}, {"c": "b", "p": 120}, {"c": "a", "p": 70}, {"c": "c", "p": 10}
+	]}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`items{c: $sum(p)} ~> $siftValues(function($v) { $v > 100 })`, `{"a":120,"b":120}`},
+		{`$siftValues(items{c: $sum(p)}, function($v, $k) { $k != "a" })`, `{"b":120,"c":10}`},
+		{`{"a": 0, "b": "", "c": [], "d": 1, "e": null, "f": "x"} ~> $siftValues`, `{"d":1,"f":"x"}`},
+		{`items.{c: p} ~> $siftValues(function($v) { $v > 60 })`, `[{"b":120},{"a":70}]`},
+		{`$siftValues({"a": 1}, function($v) { $v > 5 })`, `undefined`},
+		{`$siftValues(missing)`, `undefined`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := "undefined"
+			if v := eval(t, tt.query, data); v != nil {
+				b, err := json.Marshal(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = string(b)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+	if err := evalExpectError(t, `$siftValues({"a": 1}, 3)`, nil); err == nil {
+		t.Error("non-function predicate: want an error")
+	}
+}
+
+func TestHOFCallbackErrorContext(t *testing.T) {
+	data := map[string]interface{}{"o": map[string]interface{}{"a": 1.0, "b": 2.0}}
+	tests := []struct {
+		name    string
+		query   string
+		wantMsg string
+	}{
+		{"map index", `$map([1, 2, 3], function($v) { $v = 2 ? $error("bad") })`, "D3137: bad (in $map callback at index 1)"},
+		{"filter index", `$filter([1, 2, 3], function($v) { $v = 3 ? $error("bad") })`, "in $filter callback at index 2"},
+		{"reduce index", `$reduce([1, 2, 3], function($a, $v) { $v = 3 ? $error("bad") : $a + $v })`, "in $reduce callback at index 2"},
+		{"each key", `$each(o, function($v, $k) { $k = "b" ? $error("bad") })`, `D3137: bad (in $each callback for key "b")`},
+		{"sift key", `$sift(o, function($v) { $v + "x" })`, `(in $sift callback for key "a")`},
+		{"typed error keeps code", `$map(["x"], function($v) { $v + 1 })`, "T2001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := evalExpectError(t, tt.query, data)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("error %q does not contain %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+
+	t.Run("errors.As finds the JSONata error", func(t *testing.T) {
+		err := evalExpectError(t, `$map(["x"], function($v) { $v + 1 })`, nil)
+		var jerr *types.Error
+		if !errors.As(err, &jerr) || jerr.Code != types.ErrLeftSideAssignment {
+			t.Fatalf("expected *types.Error with code T2001, got %v", err)
+		}
+	})
+}
+
+func TestObjectFunctionCallbackArity(t *testing.T) {
+	data := map[string]interface{}{"o": map[string]interface{}{"a": 1.0, "b": 2.0}}
+	tests := []struct {
+		name  string
+		query string
+		want  interface{}
+	}{
+		{"no params", `$each(o, function() { 5 })`, []interface{}{5.0, 5.0}},
+		{"value only", `$each(o, function($v) { $v })`, []interface{}{1.0, 2.0}},
+		{"value and key", `$each(o, function($v, $k) { $k & $v })`, []interface{}{"a1", "b2"}},
+		{"value key object", `$each(o, function($v, $k, $o) { $o.b + $v })`, []interface{}{3.0, 4.0}},
+		{"extra params stay unbound", `$each(o, function($v, $k, $o, $x) { $exists($x) })`, []interface{}{false, false}},
+		{"signature limits args", `$each(o, function($v, $k)<n:s> { $string($v) })`, []interface{}{"1", "2"}},
+		{"context-accepting builtin", `$each(o, $string)`, []interface{}{"1", "2"}},
+		{"two-arg builtin", `$each(o, $append)`, []interface{}{[]interface{}{1.0, "a"}, []interface{}{2.0, "b"}}},
+		{"sift with object", `$sift(o, function($v, $k, $o) { $v = $o.b })`, map[string]interface{}{"b": 2.0}},
+		{"map with no params", `$map([1, 2], function() { 0 })`, []interface{}{0.0, 0.0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := eval(t, tt.query, data)
+			if obj, ok := result.(*evaluator.OrderedObject); ok {
+				result = obj.Values
+			}
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("got %#v, want %#v", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestFnDoc(t *testing.T) {
+	loads := 0
+	loader := func(name string) (interface{}, error) {
+		loads++
+		switch name {
+		case "rates":
+			return map[string]interface{}{"EUR": 1.0, "USD": 2.0}, nil
+		case "empty":
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unknown document")
+	}
+	ev := evaluator.New(evaluator.WithDocumentLoader(loader))
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"price": 10.0, "cur": "USD"},
+			map[string]interface{}{"price": 5.0, "cur": "EUR"},
+		},
+	}
+	run := func(query string) (interface{}, error) {
+		expr, err := parser.Compile(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ev.Eval(context.Background(), expr, data)
+	}
+
+	got, err := run(`items.(price * $lookup($doc("rates"), cur))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareValue(t, got, []interface{}{20.0, 5.0})
+	if loads != 1 {
+		t.Errorf("expected one load per evaluation, got %d", loads)
+	}
+
+	if got, err := run(`$doc("empty")`); err != nil || got != nil {
+		t.Errorf("expected undefined, got %v, %v", got, err)
+	}
+	if _, err := run(`$doc("missing")`); err == nil || !strings.Contains(err.Error(), `$doc("missing"): unknown document`) {
+		t.Errorf("expected loader error, got %v", err)
+	}
+	if err := evalExpectError(t, `$doc("rates")`, nil); err == nil {
+		t.Error("expected error without a document loader")
+	}
+}
+
+func TestFnDocCache(t *testing.T) {
+	expr, err := parser.Compile(`$doc(name)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loads atomic.Int32
+	fail := atomic.Bool{}
+	loader := func(name string) (interface{}, error) {
+		loads.Add(1)
+		if fail.Load() {
+			return nil, fmt.Errorf("backend down")
+		}
+		return "doc:" + name, nil
+	}
+	evalName := func(ev *evaluator.Evaluator, name string) (interface{}, error) {
+		return ev.Eval(context.Background(), expr, map[string]interface{}{"name": name})
+	}
+
+	t.Run("reused across evaluations until ttl", func(t *testing.T) {
+		loads.Store(0)
+		ev := evaluator.New(evaluator.WithDocumentLoader(loader), evaluator.WithDocumentCache(50*time.Millisecond, 0))
+		for i := 0; i < 3; i++ {
+			if got, err := evalName(ev, "a"); err != nil || got != "doc:a" {
+				t.Fatalf("got %v, %v", got, err)
+			}
+		}
+		if n := loads.Load(); n != 1 {
+			t.Fatalf("expected 1 load, got %d", n)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if _, err := evalName(ev, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if n := loads.Load(); n != 2 {
+			t.Fatalf("expected a reload after ttl, got %d loads", n)
+		}
+	})
+
+	t.Run("max entries evicts least recently used", func(t *testing.T) {
+		loads.Store(0)
+		ev := evaluator.New(evaluator.WithDocumentLoader(loader), evaluator.WithDocumentCache(0, 2))
+		for _, name := range []string{"a", "b", "a", "c", "a", "b"} {
+			if _, err := evalName(ev, name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// a, b, c load; c evicts b (a was used more recently); b reloads.
+		if n := loads.Load(); n != 4 {
+			t.Fatalf("expected 4 loads, got %d", n)
+		}
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		loads.Store(0)
+		ev := evaluator.New(evaluator.WithDocumentLoader(loader), evaluator.WithDocumentCache(0, 0))
+		fail.Store(true)
+		if _, err := evalName(ev, "a"); err == nil {
+			t.Fatal("expected loader error")
+		}
+		fail.Store(false)
+		if got, err := evalName(ev, "a"); err != nil || got != "doc:a" {
+			t.Fatalf("got %v, %v", got, err)
+		}
+		if n := loads.Load(); n != 2 {
+			t.Fatalf("expected 2 loads, got %d", n)
+		}
+	})
+
+	t.Run("concurrent loads are deduplicated", func(t *testing.T) {
+		var calls atomic.Int32
+		release := make(chan struct{})
+		slow := func(name string) (interface{}, error) {
+			calls.Add(1)
+			<-release
+			return "slow", nil
+		}
+		ev := evaluator.New(evaluator.WithDocumentLoader(slow), evaluator.WithDocumentCache(time.Minute, 0))
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if got, err := evalName(ev, "x"); err != nil || got != "slow" {
+					errs <- fmt.Errorf("got %v, %v", got, err)
+				}
+			}()
+		}
+		for calls.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond) // let the other evaluations reach the cache
+		close(release)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Fatalf("expected a single loader call, got %d", n)
+		}
+	})
+}
+
+func TestFnBytes(t *testing.T) {
+	data := map[string]interface{}{
+		"payload": "AAEC/w==",
+		"raw":     []byte{0xca, 0xfe},
+	}
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{`$fromHex("0001ff")`, []byte{0x00, 0x01, 0xff}},
+		{`$fromBase64Bytes(payload)`, []byte{0x00, 0x01, 0x02, 0xff}},
+		{`$fromBase64Bytes("-_8")`, []byte{0xfb, 0xff}},
+		{`$type(raw)`, "bytes"},
+		{`$length($fromBase64Bytes(payload))`, 4.0},
+		{`$toHex($fromBase64Bytes(payload))`, "000102ff"},
+		{`$toHex(raw)`, "cafe"},
+		{`$toHex("hi")`, "6869"},
+		{`$string(raw)`, "yv4="},
+		{`$base64encode($fromHex("cafe"))`, "yv4="},
+		{`$string({"b": raw})`, `{"b":"yv4="}`},
+		{`raw = $fromHex("CAFE")`, true},
+		{`raw = $fromHex("cafd")`, false},
+		{`$boolean($fromHex(""))`, false},
+		{`$count($distinct([raw, $fromHex("cafe")]))`, 1.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			compareValue(t, eval(t, tt.query, data), tt.want)
+		})
+	}
+
+	for _, query := range []string{`$fromHex("0g")`, `$fromBase64Bytes("%%")`, `$toHex(1)`} {
+		if err := evalExpectError(t, query, data); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
+func TestContextArgumentInjection(t *testing.T) {
+	data := map[string]interface{}{
+		"s":   "hello world",
+		"n":   -2.5,
+		"o":   map[string]interface{}{"a": 1.0},
+		"arr": []interface{}{"ab", "cd"},
+	}
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{`s.$substring(2)`, "llo world"},
+		{`s.$substring(1, 3)`, "ell"},
+		{`$substring("abc", 1)`, "bc"},
+		{`s.$substringAfter(" ")`, "world"},
+		{`s.$replace("o", "0")`, "hell0 w0rld"},
+		{`s.$pad(13, "#")`, "hello world##"},
+		{`s.$length()`, 11.0},
+		{`s.$contains("wor")`, true},
+		{`n.$abs()`, 2.5},
+		{`o.$lookup("a")`, 1.0},
+		{`arr.$uppercase()`, []interface{}{"AB", "CD"}},
+		{`s.(function() { $substring(0, 5) })()`, "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			compareValue(t, eval(t, tt.query, data), tt.want)
+		})
+	}
+
+	err := evalExpectError(t, `o.$uppercase()`, data)
+	var jerr *types.Error
+	if !errors.As(err, &jerr) || jerr.Code != "T0411" {
+		t.Errorf("incompatible context: got %v, want T0411", err)
+	}
+}
+
+// TestFnRandom covers the $random ranges and WithRandomSeed.
+func TestFnRandom(t *testing.T) {
+	run := func(query string, opts ...evaluator.EvalOption) (interface{}, error) {
+		t.Helper()
+		expr, err := parser.Compile(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return evaluator.New(opts...).Eval(context.Background(), expr, nil)
+	}
+
+	ranges := []struct {
+		query  string
+		lo, hi float64
+	}{
+		{`$random(6)`, 0, 5},
+		{`$random(1, 6)`, 1, 6},
+		{`$random(-3, -1)`, -3, -1},
+		{`$random(7, 7)`, 7, 7},
+	}
+	for _, tt := range ranges {
+		t.Run(tt.query, func(t *testing.T) {
+			seen := map[float64]bool{}
+			for i := 0; i < 200; i++ {
+				got, err := run(tt.query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				n := got.(float64)
+				if n < tt.lo || n > tt.hi || n != math.Trunc(n) {
+					t.Fatalf("got %v, want an integer in [%v, %v]", n, tt.lo, tt.hi)
+				}
+				seen[n] = true
+			}
+			if len(seen) != int(tt.hi-tt.lo)+1 {
+				t.Errorf("drew %d distinct values, want %v", len(seen), tt.hi-tt.lo+1)
+			}
+		})
+	}
+
+	for _, query := range []string{`$random(0)`, `$random(2.5)`, `$random(3, 1)`, `$random(1, "6")`} {
+		if _, err := run(query); err == nil || !strings.Contains(err.Error(), "D3150") && !strings.Contains(err.Error(), "T0410") {
+			t.Errorf("%s: got %v, want an error", query, err)
+		}
+	}
+	if got, err := run(`$random(missing)`); err != nil || got != nil {
+		t.Errorf("$random(missing): got %v, %v; want undefined", got, err)
+	}
+
+	// Seeded evaluations draw the same sequence, concurrently or not.
+	const query = `[$random(), $random(1000), $shuffle([1..10])]`
+	want, err := run(query, evaluator.WithRandomSeed(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, err := parser.Compile(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := evaluator.New(evaluator.WithRandomSeed(42))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := ev.Eval(context.Background(), expr, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("seeded evaluation: got %v, want %v", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+	if other, _ := run(query, evaluator.WithRandomSeed(43)); reflect.DeepEqual(other, want) {
+		t.Errorf("seeds 42 and 43 drew the same values %v", other)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := time.Date(2017, 11, 7, 16, 12, 37, 121_900_000, rome)
+	ev := evaluator.New(evaluator.WithClock(evaluator.FixedClock(at)), evaluator.WithTimezone("UTC"))
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{`$now()`, "2017-11-07T16:12:37.121+01:00"},
+		{`$millis()`, 1510067557121.0},
+		{`$toMillis($now()) = $millis()`, true},
+		{`$fromMillis(0)`, "1970-01-01T01:00:00.000+01:00"},
+		{`$now(undefined, "UTC")`, "2017-11-07T15:12:37.121Z"},
+	}
+	for _, tt := range tests {
+		got, err := ev.Eval(context.Background(), mustCompile(t, tt.query), nil)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %v, %v; want %v", tt.query, got, err, tt.want)
+		}
+	}
+
+	// The system clock gives a fresh time to each evaluation, consistent
+	// within one.
+	got, err := evaluator.New().Eval(context.Background(), mustCompile(t, `[1..50].($toMillis($now()) = $millis())`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ok := range got.([]interface{}) {
+		if ok != true {
+			t.Fatalf("$toMillis($now()) != $millis(): %v", got)
+		}
+	}
+}
@@ -384,6 +384,14 @@ func TestLexerRegex(t *testing.T) {
 				{Type: parser.TokenRegex, Value: `a\/b`, Position: 1},
 			},
 		},
+		{
+			name:       "regex starting with a multi-byte character",
+			input:      "/é/",
+			allowRegex: true,
+			expected: []parser.Token{
+				{Type: parser.TokenRegex, Value: "é", Position: 1},
+			},
+		},
 		{
 			name:       "regex with brackets",
 			input:      "/[a-z]+/",
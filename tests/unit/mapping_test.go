@@ -0,0 +1,151 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/mapping"
+	"github.com/sandrolain/gosonata/pkg/parser"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+const mappingInput = `{
+	"order": {
+		"id": 7,
+		"customer": {"first": "Ada", "last": "Byron", "email": "ADA@EXAMPLE.ORG"},
+		"items": [
+			{"sku": "a", "price": 2, "qty": 3, "tags": [["x"], ["y", "z"]]},
+			{"sku": "b", "price": 1, "qty": 1, "tags": []},
+			{"sku": "c", "price": 5, "qty": 2}
+		]
+	}
+}`
+
+// TestMappingCompile checks that a compiled spec gives every field the value
+// its expression has on its own, with the shared sub-expressions bound once.
+func TestMappingCompile(t *testing.T) {
+	spec, err := mapping.ParseSpec([]byte(`{
+		"id": "order.id",
+		"customer": {
+			"name": "order.customer.first & ' ' & order.customer.last",
+			"email": "$lowercase(order.customer.email)"
+		},
+		"total": "$sum(order.items.(price * qty))",
+		"count": "$count(order.items)",
+		"average": "$sum(order.items.(price * qty)) / $count(order.items)",
+		"skus": "order.items.sku",
+		"tags": "order.items.tags",
+		"expensive": "order.items[price > 1].sku",
+		"scoped": "($n := $count(order.items); $n * 10)",
+		"missing": "order.nothing.here"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := evaluator.New()
+	expr, err := mapping.Compile(spec, parser.WithBuiltinFunctions(ev.BuiltinFunction))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(mappingInput), &data); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ev.Eval(context.Background(), expr, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := got.(*evaluator.OrderedObject)
+	if !ok {
+		t.Fatalf("got %T, want an object", got)
+	}
+	// An undefined field is left out, as in any object constructor.
+	if want := []string{"id", "customer", "total", "count", "average", "skus", "tags", "expensive", "scoped"}; !reflect.DeepEqual(obj.Keys, want) {
+		t.Errorf("keys %v, want %v", obj.Keys, want)
+	}
+
+	var check func(spec mapping.Spec, obj *evaluator.OrderedObject)
+	check = func(spec mapping.Spec, obj *evaluator.OrderedObject) {
+		for _, f := range spec {
+			if f.Fields != nil {
+				check(f.Fields, obj.Values[f.Name].(*evaluator.OrderedObject))
+				continue
+			}
+			alone, err := ev.Eval(context.Background(), mustCompile(t, f.Expr), data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(obj.Values[f.Name])
+			wantJSON, _ := json.Marshal(alone)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("%s: got %s, want %s", f.Name, gotJSON, wantJSON)
+			}
+		}
+	}
+	check(spec, obj)
+
+	// order.customer, order.items, the $sum and the $count are shared.
+	root := expr.AST()
+	if root.Type != types.NodeBlock || len(root.Expressions) != 5 {
+		t.Fatalf("got a %s with %d expressions, want a block of 4 bindings and the object", root.Type, len(root.Expressions))
+	}
+}
+
+func TestMappingNotShared(t *testing.T) {
+	for _, spec := range []string{
+		`{"a": "$random()", "b": "$random()"}`,
+		`{"a": "$custom()", "b": "$custom()"}`,
+		`{"a": "x.y[]", "b": "x.y[]"}`,
+		`{"a": "p ? x.y : 0", "b": "p ? 0 : x.y"}`,
+		`{"a": "items.(x.y)", "b": "others.(x.y)"}`,
+	} {
+		expr, err := mapping.CompileJSON([]byte(spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root := expr.AST(); root.Type != types.NodeObject {
+			t.Errorf("%s: got a %s, want the object unchanged", spec, root.Type)
+		}
+	}
+
+	expr, err := mapping.CompileJSON([]byte(`{"a": "$random()", "b": "$random()"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := evaluator.New().Eval(context.Background(), expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj := got.(*evaluator.OrderedObject); obj.Values["a"] == obj.Values["b"] {
+		t.Errorf("$random() evaluated once for both fields: %v", obj.Values)
+	}
+}
+
+func TestMappingErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{`{"a": "x", "b": {"c": "y +"}}`, `field "b.c"`},
+		{`{"a": ""}`, `field "a"`},
+		{`{"a": 1}`, `must be an expression string or an object`},
+		{`{"a": "x", "a": "y"}`, `duplicate field "a"`},
+		{`["a"]`, `the spec must be an object`},
+	}
+	for _, tt := range tests {
+		_, err := mapping.CompileJSON([]byte(tt.spec))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.spec, err, tt.want)
+		}
+	}
+
+	// Options apply to every field.
+	_, err := mapping.Compile(mapping.Spec{{Name: "a", Expr: "{...x}"}}, parser.WithExtensions(parser.ExtObjectSpread))
+	if err != nil {
+		t.Errorf("spread with ExtObjectSpread: %v", err)
+	}
+}
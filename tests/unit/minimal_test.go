@@ -0,0 +1,39 @@
+//go:build minimal
+
+package unit_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sandrolain/gosonata"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// TestMinimalBuild checks that a -tags minimal build keeps the picture-less
+// forms of the formatting functions and rejects pictures with U1003.
+func TestMinimalBuild(t *testing.T) {
+	for query, want := range map[string]interface{}{
+		`$formatNumber(1234.5)`:                    "1234.5",
+		`$fromMillis(0)`:                           "1970-01-01T00:00:00.000Z",
+		`$toMillis("1970-01-01T00:00:01Z")`:        1000.0,
+		`$fromMillis(3600000, undefined, "+0100")`: "1970-01-01T02:00:00.000+01:00",
+	} {
+		got, err := gosonata.Eval(query, nil)
+		if err != nil || got != want {
+			t.Errorf("%s = %v, %v; want %v", query, got, err, want)
+		}
+	}
+
+	for _, query := range []string{
+		`$formatNumber(1234.5, "#,##0.00")`,
+		`$fromMillis(0, "[Y0001]")`,
+		`$toMillis("2024", "[Y0001]")`,
+	} {
+		_, err := gosonata.Eval(query, nil)
+		var e *types.Error
+		if !errors.As(err, &e) || e.Code != types.ErrNotInBuild {
+			t.Errorf("%s: got %v, want %s", query, err, types.ErrNotInBuild)
+		}
+	}
+}
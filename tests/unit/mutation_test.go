@@ -0,0 +1,259 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/parser"
+)
+
+// spareSentinel fills the spare capacity of every slice of a frozen document:
+// an append that reuses the caller's backing array overwrites it.
+const spareSentinel = "\x00spare"
+
+// freeze rebuilds a decoded JSON document so that mutations become observable:
+// every slice (and every OrderedObject key list, when ordered) gets spare
+// capacity holding spareSentinel. It returns the document and a deep copy to
+// compare against after the evaluation.
+func freeze(t *testing.T, src string, ordered bool) (doc, snapshot interface{}) {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(src), &v); err != nil {
+		t.Fatal(err)
+	}
+	var build func(v interface{}) interface{}
+	build = func(v interface{}) interface{} {
+		switch val := v.(type) {
+		case []interface{}:
+			s := make([]interface{}, len(val), len(val)+2)
+			for i, item := range val {
+				s[i] = build(item)
+			}
+			s[:cap(s)][len(s)] = spareSentinel
+			s[:cap(s)][len(s)+1] = spareSentinel
+			return s
+		case map[string]interface{}:
+			if !ordered {
+				m := make(map[string]interface{}, len(val))
+				for k, item := range val {
+					m[k] = build(item)
+				}
+				return m
+			}
+			obj := &evaluator.OrderedObject{Values: make(map[string]interface{}, len(val))}
+			keys := make([]string, 0, len(val)+2)
+			for k, item := range val {
+				keys = append(keys, k)
+				obj.Values[k] = build(item)
+			}
+			sort.Strings(keys)
+			keys = append(keys, spareSentinel, spareSentinel)
+			obj.Keys = keys[:len(val)]
+			return obj
+		}
+		return v
+	}
+	return build(v), build(v)
+}
+
+// checkFrozen reports every difference between doc and its snapshot, and every
+// overwritten spare slot of doc.
+func checkFrozen(t *testing.T, path string, doc, snapshot interface{}) {
+	t.Helper()
+	switch val := doc.(type) {
+	case []interface{}:
+		snap, ok := snapshot.([]interface{})
+		if !ok || len(val) != len(snap) {
+			t.Errorf("%s: array changed to %v", path, val)
+			return
+		}
+		for _, spare := range val[len(val):cap(val)] {
+			if spare != spareSentinel {
+				t.Errorf("%s: spare capacity overwritten with %v", path, spare)
+				break
+			}
+		}
+		for i := range val {
+			checkFrozen(t, fmt.Sprintf("%s[%d]", path, i), val[i], snap[i])
+		}
+	case map[string]interface{}:
+		snap, ok := snapshot.(map[string]interface{})
+		if !ok || len(val) != len(snap) {
+			t.Errorf("%s: object changed to %v", path, val)
+			return
+		}
+		for k, item := range val {
+			checkFrozen(t, path+"."+k, item, snap[k])
+		}
+	case *evaluator.OrderedObject:
+		snap, ok := snapshot.(*evaluator.OrderedObject)
+		if !ok || !reflect.DeepEqual(val.Keys, snap.Keys) || len(val.Values) != len(snap.Values) {
+			t.Errorf("%s: object changed to %v", path, val)
+			return
+		}
+		for _, spare := range val.Keys[len(val.Keys):cap(val.Keys)] {
+			if spare != spareSentinel {
+				t.Errorf("%s: spare key capacity overwritten with %q", path, spare)
+				break
+			}
+		}
+		for k, item := range val.Values {
+			checkFrozen(t, path+"."+k, item, snap.Values[k])
+		}
+	default:
+		if !reflect.DeepEqual(doc, snapshot) {
+			t.Errorf("%s: %v changed to %v", path, snapshot, doc)
+		}
+	}
+}
+
+func TestEvalNeverMutatesInput(t *testing.T) {
+	const doc = `{
+		"name": "shop",
+		"tags": ["b", "a", "c"],
+		"nums": [3, 1, 2],
+		"orders": [
+			{"id": 1, "total": 250, "items": [{"sku": "x", "qty": 2}, {"sku": "y", "qty": 1}], "meta": {"k": "v"}},
+			{"id": 2, "total": 40, "items": [{"sku": "x", "qty": 5}], "meta": {"k": "w"}},
+			{"id": 3, "total": 120, "items": [], "meta": {}}
+		]
+	}`
+
+	exprs := []string{
+		`orders`,
+		`orders[total > 100]`,
+		`orders.items`,
+		`orders.items.sku`,
+		`orders^(>total)`,
+		`orders^(id)`,
+		`$sort(nums)`,
+		`$sort(orders, function($a, $b) { $a.total > $b.total })`,
+		`$reverse(tags)`,
+		`$append(tags, nums)`,
+		`$append(orders[0].items, orders[1].items)`,
+		`$distinct(orders.items.sku)`,
+		`$merge(orders.meta)`,
+		`$spread(orders[0])`,
+		`$zip(tags, nums)`,
+		`$keys(orders)`,
+		`$each(orders[0], function($v, $k) { $k })`,
+		`$sift(orders[0], function($v) { $v })`,
+		`$map(orders, function($o) { $o })`,
+		`$filter(orders, function($o) { $o.total > 50 })`,
+		`$reduce(nums, function($a, $b) { $a + $b })`,
+		`orders{$string(id): items}`,
+		`orders.{"id": id, "items": items}`,
+		`orders@$o.$o.items@$i.{"o": $o.id, "i": $i.sku}`,
+		`orders#$n.items.{"n": $n, "sku": sku}`,
+		`orders.items.%.id`,
+		`orders ~> |items|{"qty": qty * 10}|`,
+		`orders ~> |$|{"total": 0}, ["meta"]|`,
+		`$ ~> |orders|{"flag": true}, ["items"]|`,
+		`[tags, nums]`,
+		`tags[]`,
+		`**.sku`,
+		`orders.*`,
+		`$string($)`,
+		`$lookup(orders, "meta")`,
+		`($x := orders; $x[0].items)`,
+	}
+
+	for _, ordered := range []bool{false, true} {
+		for _, query := range exprs {
+			t.Run(fmt.Sprintf("ordered=%v/%s", ordered, query), func(t *testing.T) {
+				expr, err := parser.Compile(query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, snapshot := freeze(t, doc, ordered)
+				if _, err := evaluator.New().Eval(context.Background(), expr, data); err != nil {
+					t.Fatal(err)
+				}
+				checkFrozen(t, "$", data, snapshot)
+			})
+		}
+	}
+
+	t.Run("concurrent evaluations share the input", func(t *testing.T) {
+		// Under -race, any write to the shared document is reported.
+		data, snapshot := freeze(t, doc, true)
+		expr, err := parser.Compile(`orders@$o.$o.items@$i.{"o": $o.id, "i": $i.sku, "m": $o.meta}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eval := evaluator.New()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := eval.Eval(context.Background(), expr, data); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		checkFrozen(t, "$", data, snapshot)
+	})
+}
+
+func TestWithAllowInPlace(t *testing.T) {
+	expr, err := parser.Compile(`$ ~> |orders|{"seen": true}, ["meta"]|`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const doc = `{"orders": [{"id": 1, "meta": {}}, {"id": 2}]}`
+
+	for _, ordered := range []bool{false, true} {
+		data, snapshot := freeze(t, doc, ordered)
+		result, err := evaluator.New(evaluator.WithAllowInPlace(true)).Eval(context.Background(), expr, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(data, snapshot) {
+			t.Errorf("ordered=%v: input was not updated in place", ordered)
+		}
+		got, _ := json.Marshal(result)
+		in, _ := json.Marshal(data)
+		if string(got) != string(in) {
+			t.Errorf("ordered=%v: result %s differs from updated input %s", ordered, got, in)
+		}
+		if want := `{"orders":[{"id":1,"seen":true},{"id":2,"seen":true}]}`; string(got) != want {
+			t.Errorf("ordered=%v: result = %s, want %s", ordered, got, want)
+		}
+	}
+}
+
+func TestPositionalBindingsSharedAcrossSteps(t *testing.T) {
+	// The items of a step share the bindings of the item they come from; an
+	// assignment in one of them must not be seen by the others.
+	data := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"projects": []interface{}{"p", "q"}},
+			map[string]interface{}{"projects": []interface{}{"r"}},
+		},
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`users#$i.projects.[$i := 100 + $i, $i][1]`, `[100,100,101]`},
+		{`users#$i.projects#$j.[$j := $j + 10, $i & "-" & $j][1]`, `["0-10","0-11","1-10"]`},
+		{`users#$i.projects.{"p": $, "i": $i}`, `[{"p":"p","i":0},{"p":"q","i":0},{"p":"r","i":1}]`},
+	}
+	for _, tt := range tests {
+		got, err := evaluator.New().Eval(context.Background(), mustCompile(t, tt.query), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := json.Marshal(got); string(b) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.query, b, tt.want)
+		}
+	}
+}
@@ -1,8 +1,17 @@
 package unit_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"testing/fstest"
 
+	"github.com/sandrolain/gosonata/pkg/evaluator"
 	"github.com/sandrolain/gosonata/pkg/parser"
 	"github.com/sandrolain/gosonata/pkg/types"
 )
@@ -456,6 +465,129 @@ func TestParseErrors(t *testing.T) {
 	}
 }
 
+func TestParseErrorDetails(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		line       int
+		column     int
+		expected   []string
+		suggestion string
+		snippet    string
+	}{
+		{
+			name:     "argument separator",
+			input:    "$sum(a]",
+			line:     1,
+			column:   7,
+			expected: []string{`","`, `")"`},
+			snippet:  "   1 | $sum(a]\n     |       ^",
+		},
+		{
+			name:     "unclosed array",
+			input:    "[1, 2",
+			line:     1,
+			column:   6,
+			expected: []string{`","`, `"]"`},
+		},
+		{
+			name:     "missing colon",
+			input:    `{"a" 1}`,
+			line:     1,
+			column:   6,
+			expected: []string{`":"`},
+		},
+		{
+			name:     "trailing token",
+			input:    "a b",
+			line:     1,
+			column:   3,
+			expected: []string{"an operator", "end of expression"},
+		},
+		{
+			name:       "misspelled function",
+			input:      "$lenght(name",
+			line:       1,
+			column:     13,
+			expected:   []string{`","`, `")"`},
+			suggestion: "$length",
+		},
+		{
+			name:       "multi-line",
+			input:      "(\n  $x := 1;\n  $substrng($x, 1,)\n)",
+			line:       3,
+			column:     19,
+			expected:   []string{"an expression"},
+			suggestion: "$substring",
+			snippet:    "   3 |   $substrng($x, 1,)\n     |                   ^",
+		},
+		{
+			name:     "user-defined function is not corrected",
+			input:    "($lenght := function($s) { $s }; $lenght(name]",
+			line:     1,
+			column:   46,
+			expected: []string{`","`, `")"`},
+		},
+		{
+			name:    "tab aligned caret",
+			input:   "\t1 +",
+			line:    1,
+			column:  5,
+			snippet: "   1 | \t1 +\n     | \t   ^",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.input)
+			var perr *types.Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected *types.Error, got %v", err)
+			}
+			if perr.Line != tt.line || perr.Column != tt.column {
+				t.Errorf("line/column = %d/%d, want %d/%d", perr.Line, perr.Column, tt.line, tt.column)
+			}
+			if tt.expected != nil && !slices.Equal(perr.Expected, tt.expected) {
+				t.Errorf("Expected = %q, want %q", perr.Expected, tt.expected)
+			}
+			if perr.Suggestion != tt.suggestion {
+				t.Errorf("Suggestion = %q, want %q", perr.Suggestion, tt.suggestion)
+			}
+			if tt.snippet != "" && perr.Snippet != tt.snippet {
+				t.Errorf("Snippet =\n%s\nwant\n%s", perr.Snippet, tt.snippet)
+			}
+			if msg := err.Error(); !strings.HasSuffix(msg, "\n"+perr.Snippet) {
+				t.Errorf("Error() lacks the snippet: %q", msg)
+			}
+		})
+	}
+}
+
+func TestParseErrorLongLineSnippet(t *testing.T) {
+	input := strings.Repeat("a + ", 40) + "]"
+	_, err := parser.Parse(input)
+	var perr *types.Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected *types.Error, got %v", err)
+	}
+	lines := strings.Split(perr.Snippet, "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "…") {
+		t.Fatalf("long line not elided:\n%s", perr.Snippet)
+	}
+	src, carets := []rune(lines[0]), []rune(lines[1])
+	if col := slices.Index(carets, '^'); col < 0 || col >= len(src) || src[col] != ']' {
+		t.Errorf("caret not under the error:\n%s", perr.Snippet)
+	}
+}
+
+func TestBuiltinFunctionNames(t *testing.T) {
+	for _, name := range parser.BuiltinFunctionNames() {
+		if _, ok := evaluator.GetFunction(name); !ok {
+			t.Errorf("suggested function $%s is not a built-in", name)
+		}
+	}
+}
+
 // Edge cases
 
 func TestParseEdgeCases(t *testing.T) {
@@ -948,6 +1080,150 @@ func TestParseAssignmentErrors(t *testing.T) {
 
 // Advanced features integration tests
 
+func TestParseAssignmentTargetCode(t *testing.T) {
+	for _, input := range []string{"a := 1", `"s" := 1`, "$a[1] := 3"} {
+		_, err := parser.Parse(input)
+		var perr *types.Error
+		if !errors.As(err, &perr) || perr.Code != types.ErrInvalidAssignTarget {
+			t.Errorf("%s: expected S0212, got %v", input, err)
+		}
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	rejected := []struct {
+		input string
+		code  types.ErrorCode
+		pos   int
+	}{
+		{"a.true", types.ErrInvalidPathStep, 2},
+		{"a.null", types.ErrInvalidPathStep, 2},
+		{"1.a", types.ErrInvalidPathStep, 0},
+		{"a.b.false[0]", types.ErrInvalidPathStep, 4},
+		{"%", types.ErrInvalidParentUse, 0},
+		{"%.a", types.ErrInvalidParentUse, 0},
+		{"$.%", types.ErrInvalidParentUse, 1},
+		{"$x.%", types.ErrInvalidParentUse, 1},
+		{"function($x){ $x.% }", types.ErrInvalidParentUse, 15},
+		{"a.%.%", types.ErrInvalidParentUse, 4},
+		{"a.b.%.%.%", types.ErrInvalidParentUse, 8},
+		{`{"x": 1}.%`, types.ErrInvalidParentUse, 0},
+		{"a.**.%", types.ErrInvalidParentUse, 2},
+		{"$sum(%.x)", types.ErrInvalidParentUse, 5},
+		{"a[%.%.x]", types.ErrInvalidParentUse, 4},
+		{"[1, 2][%]", types.ErrInvalidParentUse, 0},
+		{"($ := 5; $)", types.ErrInvalidAssignTarget, 2},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.input, func(t *testing.T) {
+			if _, err := parser.Compile(tt.input); err != nil {
+				t.Fatalf("rejected without strict mode: %v", err)
+			}
+			_, err := parser.Compile(tt.input, parser.WithStrict(true))
+			var perr *types.Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected %s, got %v", tt.code, err)
+			}
+			if perr.Code != tt.code || perr.Position != tt.pos {
+				t.Errorf("got %s at %d, want %s at %d", perr.Code, perr.Position, tt.code, tt.pos)
+			}
+		})
+	}
+
+	accepted := []string{
+		`a."b"`,
+		"a.b.%",
+		"a.*.%",
+		"**.a.%",
+		"a.b.c.%.%.%",
+		"a.$sum(%.b)",
+		`Account.Order.Product.{"order": %.OrderID, "account": %.%.Name}`,
+		`Account.Order.Product[%.OrderID = "x"].SKU`,
+		"Account.Order.Product^(%.OrderID)",
+		"orders.(%.customers[id = 3].name)",
+		"a.b[%.%.x]",
+		"a@$x.b@$y.%",
+		"a#$i.%",
+		"a.(b; c).%",
+		`a{"k": %.x}`,
+		"function($x){ $x.a.% }",
+		"($x := 5; $x)",
+	}
+	for _, input := range accepted {
+		if _, err := parser.Compile(input, parser.WithStrict(true)); err != nil {
+			t.Errorf("%s: unexpected error %v", input, err)
+		}
+	}
+}
+
+func TestModuleImports(t *testing.T) {
+	modules := fstest.MapFS{
+		"lib/currency.jsonata": {Data: []byte(`(
+			$rates := {"EUR": 1, "USD": 1.08};
+			{"convert": function($v, $to) { $v * $lookup($rates, $to) }}
+		)`)},
+		"lib/orders.jsonata": {Data: []byte(`{
+			"total": function($o) { $import("lib/currency").convert($sum($o.price), "USD") }
+		}`)},
+		"lib/scope.jsonata": {Data: []byte(`$exists($x) or $exists($)`)},
+		"cycle/a.jsonata":   {Data: []byte(`$import("cycle/b")`)},
+		"cycle/b.jsonata":   {Data: []byte(`{"a": $import("cycle/a")}`)},
+		"broken.jsonata":    {Data: []byte(`1 +`)},
+	}
+	resolver := parser.WithModuleResolver(parser.FSModules(modules))
+	data := map[string]interface{}{
+		"orders": []interface{}{map[string]interface{}{"price": 10.0}, map[string]interface{}{"price": 15.0}},
+	}
+
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		{`($c := $import("lib/currency"); $c.convert(10, "USD"))`, 10.8},
+		{`$import("lib/orders").total($$.orders)`, 27.0},
+		{`$import("lib/currency") = $import("lib/currency")`, true},
+		{`($x := 1; $import("lib/scope"))`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parser.Compile(tt.expr, resolver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := evaluator.New().Eval(context.Background(), expr, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	errorTests := []struct {
+		expr string
+		code types.ErrorCode
+		msg  string
+	}{
+		{`$import("missing")`, types.ErrModuleNotFound, `Cannot import module "missing"`},
+		{`$import("cycle/a")`, types.ErrImportCycle, "Import cycle: cycle/a -> cycle/b -> cycle/a"},
+		{`$import(name)`, types.ErrImportArgument, "string literal"},
+		{`$import("broken")`, types.ErrSyntaxError, `module "broken": `},
+	}
+	for _, tt := range errorTests {
+		_, err := parser.Compile(tt.expr, resolver)
+		var perr *types.Error
+		if !errors.As(err, &perr) || perr.Code != tt.code || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: got %v, want %s containing %q", tt.expr, err, tt.code, tt.msg)
+		}
+	}
+
+	// Without a resolver $import is an ordinary function name.
+	if _, err := parser.Compile(`$import(name)`); err != nil {
+		t.Errorf("$import without resolver: %v", err)
+	}
+}
+
 func TestParseAdvancedCombinations(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -1019,3 +1295,590 @@ func TestParseAdvancedPrecedence(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLambdaClosureInfo(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		vars         []string
+		usesContext  bool
+		usesRoot     bool
+		usesParent   bool
+		contextCalls []string
+	}{
+		{"params only", "function($x){$x * 2}", []string{"x"}, false, false, false, nil},
+		{"free variable", "function($x){$x * $k}", []string{"k", "x"}, false, false, false, nil},
+		{"field name", "function($x){price * $x}", []string{"x"}, true, false, false, nil},
+		{"path from variable", "function($x){$x.items[qty > $min].price}", []string{"min", "x"}, false, false, false, nil},
+		{"context variable", "function(){$}", nil, true, false, false, nil},
+		{"root", "function(){$$.a}", nil, false, true, false, nil},
+		{"parent", "function($x){$x.a.%}", []string{"x"}, false, false, true, nil},
+		{"short call", "function(){$string()}", []string{"string"}, false, false, false, []string{"string"}},
+		{"full call", "function($a){$substring($a, 1, 2)}", []string{"a", "substring"}, false, false, false, []string{"substring"}},
+		{"nested lambda", "function(){function($y){$y + n}}", []string{"y"}, true, false, false, nil},
+		{"eval", `function(){$eval("$y")}`, []string{"eval"}, false, false, false, []string{"eval"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := parseExpr(t, tt.input)
+			info := node.Closure
+			if info == nil {
+				t.Fatal("expected closure info on lambda node")
+			}
+			if !slices.Equal(info.Vars, tt.vars) {
+				t.Errorf("Vars = %v, want %v", info.Vars, tt.vars)
+			}
+			if info.UsesContext != tt.usesContext || info.UsesRoot != tt.usesRoot || info.UsesParent != tt.usesParent {
+				t.Errorf("UsesContext/UsesRoot/UsesParent = %v/%v/%v, want %v/%v/%v",
+					info.UsesContext, info.UsesRoot, info.UsesParent, tt.usesContext, tt.usesRoot, tt.usesParent)
+			}
+			if !slices.Equal(info.ContextCalls, tt.contextCalls) {
+				t.Errorf("ContextCalls = %v, want %v", info.ContextCalls, tt.contextCalls)
+			}
+			if want := slices.Contains(tt.vars, "eval"); info.UsesEval != want {
+				t.Errorf("UsesEval = %v, want %v", info.UsesEval, want)
+			}
+		})
+	}
+}
+
+func TestParseLambdaSignature(t *testing.T) {
+	node := parseExpr(t, "function($x, $s)<a<n>s?:n>{$x}")
+	sig := node.ParsedSignature
+	if sig == nil {
+		t.Fatal("expected parsed signature on lambda node")
+	}
+	if len(sig.Params) != 2 || sig.Params[0].Type != types.TypeArray ||
+		sig.Params[0].SubType == nil || sig.Params[0].SubType.Type != types.TypeNumber ||
+		sig.Params[1].Type != types.TypeString || !sig.Params[1].Optional {
+		t.Errorf("Params = %+v", sig.Params)
+	}
+	if sig.ReturnType == nil || sig.ReturnType.Type != types.TypeNumber {
+		t.Errorf("ReturnType = %+v", sig.ReturnType)
+	}
+
+	if node := parseExpr(t, "function($x){$x}"); node.ParsedSignature != nil {
+		t.Errorf("unexpected signature %+v", node.ParsedSignature)
+	}
+
+	_, err := parser.Parse("$map([1], function($x)<q:n>{$x})")
+	var jerr *types.Error
+	if !errors.As(err, &jerr) || jerr.Code != "S0401" {
+		t.Errorf("invalid signature: got %v, want S0401 at compile time", err)
+	}
+}
+
+// TestOptimizeCommonSubexpressions checks that WithOptimize binds repeated
+// sub-expressions once and leaves results unchanged.
+func TestOptimizeCommonSubexpressions(t *testing.T) {
+	data := map[string]interface{}{
+		"a": map[string]interface{}{"b": []interface{}{
+			map[string]interface{}{"c": 1.0, "d": []interface{}{[]interface{}{1.0}, 2.0}},
+			map[string]interface{}{"c": 3.0},
+		}},
+		"p": true,
+	}
+	tests := []struct {
+		query  string
+		shared int // bindings added
+	}{
+		{"$sum(a.b.c) / $count(a.b.c)", 1},
+		{"[a.b.c, a.b.d, a.b[c > 1].c]", 1},
+		{"{'x': $max(a.b.c), 'y': $min(a.b.c), 'z': $max(a.b.c) * 2}", 2},
+		{"($n := 2; $sum(a.b.c) * $n + $sum(a.b.c))", 1},
+		{"($n := a.b; $n.c)", 0},
+		{"($n := 2; $count(a.b[c > $n].c) + $count(a.b[c > $n].c))", 0},
+		{"p ? a.b.c : a.b.c", 0},
+		{"a.b.(c + c)", 0},
+		{"$random() + $random()", 0},
+		{"a.b[].c & a.b[].c", 0},
+		{"a.b.c", 0},
+	}
+	ev := evaluator.New(evaluator.WithRandomSeed(1))
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			plain, err := parser.Compile(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			optimized, err := parser.Compile(tt.query, parser.WithOptimize(true), parser.WithBuiltinFunctions(ev.BuiltinFunction))
+			if err != nil {
+				t.Fatal(err)
+			}
+			shared := 0
+			if root := optimized.AST(); root.Type == types.NodeBlock {
+				for _, e := range root.Expressions {
+					if e.Type == types.NodeBind && strings.HasPrefix(e.StrValue, "cse#") {
+						shared++
+					}
+				}
+			}
+			if shared != tt.shared {
+				t.Errorf("got %d shared sub-expressions, want %d", shared, tt.shared)
+			}
+			want, err := ev.Eval(context.Background(), plain, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ev.Eval(context.Background(), optimized, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantJSON, _ := json.Marshal(want)
+			gotJSON, _ := json.Marshal(got)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+// TestOptimizeImpure checks that WithOptimize shares no sub-expression that
+// may differ between evaluations of it: calls of impure built-ins, passed as
+// values or run by $eval, and calls of names the evaluator does not define as
+// built-ins.
+func TestOptimizeImpure(t *testing.T) {
+	// shared reports whether the operands of the comparison or sum are one
+	// shared sub-expression.
+	shared := func(expr *types.Expression) bool {
+		root := expr.AST()
+		if root.Type == types.NodeBlock {
+			root = root.Expressions[len(root.Expressions)-1]
+		}
+		return root.LHS.Type == types.NodeVariable && strings.HasPrefix(root.LHS.StrValue, "cse#")
+	}
+	ev := evaluator.New()
+	for _, query := range []string{
+		`$eval("$random()") = $eval("$random()")`,
+		`$string($map([[1..10]], $shuffle)) = $string($map([[1..10]], $shuffle))`,
+		`[$random][0]() = [$random][0]()`,
+		`$map([1], $f) = $map([1], $f)`,
+		`$sift({"a": 1}, $g) = $sift({"a": 1}, $g)`,
+		`$map([1], function($v, $i, $a) { $a($v) }) = $map([1], function($v, $i, $a) { $a($v) })`,
+	} {
+		expr, err := parser.Compile(query, parser.WithOptimize(true), parser.WithBuiltinFunctions(ev.BuiltinFunction))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shared(expr) {
+			t.Errorf("%s: shared", query)
+		}
+	}
+
+	expr, err := parser.Compile(`$eval("$random()") = $eval("$random()")`, parser.WithOptimize(true),
+		parser.WithBuiltinFunctions(ev.BuiltinFunction))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ev.Eval(context.Background(), expr, nil); err != nil || got != false {
+		t.Errorf("$eval: got %v, %v, want false", got, err)
+	}
+
+	// Calls of built-ins are only shared when the evaluator says they are its
+	// own.
+	const query = `$sum(a) + $sum(a)`
+	custom := evaluator.New(evaluator.WithCustomFunction("sum", "",
+		func(ctx context.Context, args ...interface{}) (interface{}, error) { return 1.0, nil }))
+	prelude := evaluator.New(evaluator.WithPrelude(mustCompile(t, `$sum := function($x) { 1 }`)))
+	for name, builtin := range map[string]func(string) bool{
+		"no evaluator":    nil,
+		"custom $sum":     custom.BuiltinFunction,
+		"prelude $sum":    prelude.BuiltinFunction,
+		"shadowing":       evaluator.New(evaluator.WithFunctionShadowing(true)).BuiltinFunction,
+		"without $sum":    evaluator.New(evaluator.WithoutFunctions("sum")).BuiltinFunction,
+		"plain evaluator": ev.BuiltinFunction,
+	} {
+		expr, err := parser.Compile(query, parser.WithOptimize(true), parser.WithBuiltinFunctions(builtin))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := name == "plain evaluator"; shared(expr) != want {
+			t.Errorf("%s: shared %v, want %v", name, shared(expr), want)
+		}
+	}
+	set := parser.CompileMany([]string{`$sum(a)`, `$sum(a) * 2`})
+	if len(set.Shared) != 0 {
+		t.Errorf("CompileMany shared %d sub-expressions without WithBuiltinFunctions", len(set.Shared))
+	}
+	set = parser.CompileMany([]string{`$sum(a)`, `$sum(a) * 2`}, parser.WithBuiltinFunctions(ev.BuiltinFunction))
+	if len(set.Shared) != 1 {
+		t.Errorf("CompileMany shared %d sub-expressions, want 1", len(set.Shared))
+	}
+}
+
+func TestParseWarnings(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`a ?? b`, nil},
+		{`a ?: b`, []string{"W1001 at 1:3"}},
+		{"x.(\n  a ?: (b ?: c)\n)", []string{"W1001 at 2:5", "W1001 at 2:11"}},
+		{`x ~> $map($string)[]`, nil},
+		{`x ~> $f()`, nil},
+		{`(x ~> $f())[0]`, nil},
+		{`x ~> $f()[0]`, []string{"W1002 at 1:10"}},
+	}
+	for _, tt := range tests {
+		expr := mustCompile(t, tt.query)
+		var got []string
+		for _, w := range expr.Warnings() {
+			got = append(got, fmt.Sprintf("%s at %d:%d", w.Code, w.Line, w.Column))
+			if !strings.HasPrefix(w.String(), got[len(got)-1]+": ") {
+				t.Errorf("%s: String() = %q", tt.query, w.String())
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got warnings %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// Warnings do not depend on the optimized form of the expression.
+	expr, err := parser.Compile(`$sum(a.b) ?: $sum(a.b)`, parser.WithOptimize(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(expr.Warnings()); n != 1 {
+		t.Errorf("optimized: got %d warnings, want 1", n)
+	}
+}
+
+func TestExpressionMetadata(t *testing.T) {
+	source := `
+/**
+ * Net amount of an order,
+ * after discounts.
+ *
+ * @name order-net
+ * @author Billing team
+ * @version	1.2.0
+ * @tags billing, orders
+ * @tags finance
+ * @since 2024
+ */
+$sum(lines.(price * qty)) - discount`
+	expr := mustCompile(t, source)
+	want := &types.Metadata{
+		Name:        "order-net",
+		Description: "Net amount of an order,\nafter discounts.",
+		Author:      "Billing team",
+		Version:     "1.2.0",
+		Tags:        []string{"billing", "orders", "finance"},
+		Annotations: map[string]string{"since": "2024"},
+	}
+	if got := expr.Metadata(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	result, err := evaluator.New().Eval(context.Background(), expr, map[string]interface{}{
+		"lines":    []interface{}{map[string]interface{}{"price": 2.0, "qty": 3.0}},
+		"discount": 1.0,
+	})
+	if err != nil || result != 5.0 {
+		t.Errorf("got %v, %v; want 5", result, err)
+	}
+
+	if got := parser.ParseMetadata("/** @description Adds one. @ignored */ $ + 1"); got == nil || got.Description != "Adds one. @ignored" {
+		t.Errorf("single line block: got %+v", got)
+	}
+	for _, query := range []string{`$ + 1`, `/* not an annotation block */ $ + 1`, `$ + 1 /** trailing */`} {
+		if got := mustCompile(t, query).Metadata(); got != nil {
+			t.Errorf("%s: got metadata %+v, want none", query, got)
+		}
+	}
+}
+
+func TestKnownFunctions(t *testing.T) {
+	prelude := mustCompile(t, `($net := function($o) { $o.price * $o.qty })`)
+	ev := evaluator.New(
+		evaluator.WithCustomFunction("greet", "", func(ctx context.Context, args ...interface{}) (interface{}, error) { return "hi", nil }),
+		evaluator.WithoutFunctions("eval"),
+		evaluator.WithPrelude(prelude),
+	)
+	known := parser.WithKnownFunctions(ev.KnownFunction)
+
+	valid := []string{
+		`$sum([1, 2]) & $greet()`,
+		`$net(order)`,
+		`($f := $uppercase; $f("a"))`,
+		`($fact := function($n) { $n <= 1 ? 1 : $n * $fact($n - 1) }; $fact(5))`,
+		`function($g) { $g(1) }`,
+		`items@$i.$i()`,
+		`x ~> $string ~> $uppercase`,
+		`$substring(?, 1)`,
+		`$.$()`,
+	}
+	for _, query := range valid {
+		if _, err := parser.Compile(query, known); err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+	}
+
+	invalid := []struct {
+		query string
+		pos   int
+	}{
+		{`$unknwonFn(x)`, 1},
+		{`1 > 2 ? $nope(1) : 3`, 9},
+		{`$eval("1")`, 1},
+		{`x ~> $nope`, 6},
+		{`$nope(?, 1)`, 1},
+		{`[1, 2].function($v) { $nope($v) }($)`, 23},
+	}
+	for _, tt := range invalid {
+		_, err := parser.Compile(tt.query, known)
+		var jerr *types.Error
+		if !errors.As(err, &jerr) || jerr.Code != types.ErrUnknownFunction || jerr.Position != tt.pos {
+			t.Errorf("%s: got %v, want S0410 at %d", tt.query, err, tt.pos)
+		}
+		// Without the option, the call fails at evaluation only.
+		if _, err := parser.Compile(tt.query); err != nil {
+			t.Errorf("%s without known functions: %v", tt.query, err)
+		}
+	}
+}
+
+func TestCompileWithConstants(t *testing.T) {
+	consts := map[string]interface{}{
+		"rate":  0.5,
+		"env":   "prod",
+		"limit": 3,
+		"tags":  []interface{}{"a", "b"},
+		"cfg":   map[string]interface{}{"x": 1.0},
+		"name":  "price",
+	}
+	data := map[string]interface{}{"price": 10.0, "a": "A", "b": "B"}
+	tests := []struct {
+		query  string
+		folded bool // the whole expression becomes a literal
+	}{
+		{`$rate * 2 + 1`, true},
+		{`$env & "-" & $env`, true},
+		{`$env = "prod" and $limit > 2`, true},
+		{`-$limit`, true},
+		{`$env = "prod" ? price * $rate : 0`, false},
+		{`$tags[1]`, false},
+		{`$cfg.x + $limit`, false},
+		{`($limit := 10; $limit + 1)`, false},
+		{`$map([1, 2], function($rate) { $rate * 2 })`, false},
+		{`$.$name`, false},
+		{`$limit / 0`, false},
+		{`$eval("$limit + 1")`, false},
+		{`$eval("$tags[1] & $cfg.x")`, false},
+		{`($limit := 10; $eval("$limit"))`, false},
+		{`$map([1, 2], function($v) { $eval("$v * $rate") })`, false},
+	}
+	ev := evaluator.New()
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			compiled, err := parser.CompileWithConstants(tt.query, consts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			root := compiled.AST()
+			literal := root.Type == types.NodeNumber || root.Type == types.NodeString || root.Type == types.NodeBoolean
+			if literal != tt.folded {
+				t.Errorf("folded to a literal: %v, want %v (root %s)", literal, tt.folded, root.Type)
+			}
+			plain, err := parser.Compile(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, wantErr := ev.EvalWithBindings(context.Background(), plain, data, consts)
+			got, gotErr := ev.Eval(context.Background(), compiled, data)
+			wantJSON, _ := json.Marshal(want)
+			gotJSON, _ := json.Marshal(got)
+			if string(gotJSON) != string(wantJSON) || (gotErr == nil) != (wantErr == nil) {
+				t.Errorf("got %s, %v; want %s, %v", gotJSON, gotErr, wantJSON, wantErr)
+			}
+		})
+	}
+
+	compiled, err := parser.CompileWithConstants(`$eval("$x + 1") & $eval("$n")`, map[string]interface{}{"x": 1, "n": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ev.Eval(context.Background(), compiled, nil); err != nil || got != "2null" {
+		t.Errorf("$eval of constants: got %v, %v; want 2null", got, err)
+	}
+
+	if _, err := parser.CompileWithConstants(`$f`, map[string]interface{}{"f": func() {}}); err == nil {
+		t.Error("expected an error for a function constant")
+	}
+}
+
+func TestWalkAndRewrite(t *testing.T) {
+	expr := mustCompile(t, `items[price > 5].(price * qty) ~> $map(function($v) { $v + price })`)
+
+	var names []string
+	types.Walk(expr.AST(), func(n *types.ASTNode) bool {
+		if n.Type == types.NodeName {
+			names = append(names, n.StrValue)
+		}
+		return true
+	})
+	if want := []string{"items", "price", "price", "qty", "price"}; !slices.Equal(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	// Read price from cost, and double every number.
+	rewritten := expr.Rewrite(func(n *types.ASTNode) *types.ASTNode {
+		switch {
+		case n.Type == types.NodeName && n.StrValue == "price":
+			c := *n
+			c.Value, c.StrValue = "cost", "cost"
+			return &c
+		case n.Type == types.NodeNumber:
+			c := *n
+			c.NumValue *= 2
+			c.Value = c.NumValue
+			return &c
+		}
+		return n
+	})
+	if rewritten.Source() != expr.Source() {
+		t.Errorf("source = %q", rewritten.Source())
+	}
+
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"price": 20.0, "cost": 8.0, "qty": 2.0},
+			map[string]interface{}{"price": 1.0, "cost": 12.0, "qty": 1.0},
+		},
+		"price": 100.0,
+		"cost":  1000.0,
+	}
+	ev := evaluator.New()
+	tests := []struct {
+		expr *types.Expression
+		want string
+	}{
+		{expr, `140`},       // 20*2 + 100
+		{rewritten, `1012`}, // 12*1 + 1000
+	}
+	for _, tt := range tests {
+		got, err := ev.Eval(context.Background(), tt.expr, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j, _ := json.Marshal(got); string(j) != tt.want {
+			t.Errorf("%p: got %s, want %s", tt.expr, j, tt.want)
+		}
+	}
+
+	// Unchanged subtrees are shared, and a nil result keeps the node.
+	same := expr.Rewrite(func(n *types.ASTNode) *types.ASTNode { return nil })
+	if same.AST() != expr.AST() {
+		t.Error("a rewrite changing nothing copied the tree")
+	}
+}
+
+func TestLongExpressions(t *testing.T) {
+	const n = 20000
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `$x = %d ? "v%d" : `, i, i)
+	}
+	b.WriteString(`"none"`)
+	chain := mustCompile(t, b.String())
+	sum := mustCompile(t, strings.TrimSuffix(strings.Repeat("1 + ", n), " + "))
+
+	ev := evaluator.New()
+	tests := []struct {
+		expr *types.Expression
+		x    float64
+		want interface{}
+	}{
+		{chain, 0, "v0"},
+		{chain, n - 1, fmt.Sprintf("v%d", n-1)},
+		{chain, n, "none"},
+		{sum, 0, float64(n)},
+	}
+	for _, tt := range tests {
+		got, err := ev.EvalWithBindings(context.Background(), tt.expr, nil, map[string]interface{}{"x": tt.x})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("x = %v: got %v, want %v", tt.x, got, tt.want)
+		}
+	}
+
+	// A chain without a final else, and one whose branches hold operators.
+	for query, want := range map[string]interface{}{
+		`$x = 1 ? "a" : $x = 2 ? "b"`:               nil,
+		`$x = 1 ? "a" : $x = 3 ? "b" & "c" : "d"`:   "bc",
+		`$x > 5 ? 1 : $x > 2 ? 2 + 3 : $x ? 9 : -1`: 5.0,
+	} {
+		got, err := ev.EvalWithBindings(context.Background(), mustCompile(t, query), nil, map[string]interface{}{"x": 3.0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestWithMaxSize(t *testing.T) {
+	if _, err := parser.Compile(`1 + 1 + 1`, parser.WithMaxSize(5)); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		`1 + 1 + 1`,
+		`[1, 2, 3, 4]`,
+		`a.b.c`,
+		strings.Repeat("1 + ", 1000000) + "1",
+	} {
+		_, err := parser.Compile(query, parser.WithMaxSize(4))
+		var perr *types.Error
+		if !errors.As(err, &perr) || perr.Code != types.ErrExpressionTooLarge {
+			t.Errorf("%.20s: got %v, want S0701", query, err)
+		}
+	}
+}
+
+func TestDepthAndTokenLimits(t *testing.T) {
+	nested := func(n int) string { return strings.Repeat("(", n) + "1" + strings.Repeat(")", n) }
+	modules := fstest.MapFS{"m.jsonata": {Data: []byte(`{"f": function() { 1 + 2 }}`)}}
+	tests := []struct {
+		name  string
+		query string
+		opts  []parser.CompileOption
+		code  types.ErrorCode // "" when the expression compiles
+		pos   int
+	}{
+		{"within the default depth limit", nested(1000), nil, "", 0},
+		{"beyond the default depth limit", nested(1001), nil, types.ErrNestingTooDeep, 1000},
+		{"adversarial nesting by default", strings.Repeat("(", 1000000), nil, types.ErrNestingTooDeep, 1000},
+		{"within the depth limit", nested(100), []parser.CompileOption{parser.WithMaxDepth(100)}, "", 0},
+		{"beyond the depth limit", nested(101), []parser.CompileOption{parser.WithMaxDepth(100)}, types.ErrNestingTooDeep, 100},
+		{"adversarial nesting", strings.Repeat("(", 100000), []parser.CompileOption{parser.WithMaxDepth(100)}, types.ErrNestingTooDeep, 100},
+		{"nesting past a syntax error", "[1,](" + nested(200), []parser.CompileOption{parser.WithMaxDepth(100)}, "S0201", 3},
+		{"mixed brackets", `{"a": [[1]]}`, []parser.CompileOption{parser.WithMaxDepth(2)}, types.ErrNestingTooDeep, 7},
+		{"within a custom depth", `{"a": [[1]]}`, []parser.CompileOption{parser.WithMaxDepth(3)}, "", 0},
+		{"sequential brackets", `[1][0] + (2) + {"a": (3)}.a`, []parser.CompileOption{parser.WithMaxDepth(2)}, "", 0},
+		{"no depth limit", nested(2000), []parser.CompileOption{parser.WithMaxDepth(0)}, "", 0},
+		{"brackets in strings and regexes", `"((((" & $match("a", /((((a))))/).match`, []parser.CompileOption{parser.WithMaxDepth(1)}, "", 0},
+		{"within the token limit", `1 + 2`, []parser.CompileOption{parser.WithMaxTokens(3)}, "", 0},
+		{"beyond the token limit", `1 + 2 + 3`, []parser.CompileOption{parser.WithMaxTokens(3)}, types.ErrTooManyTokens, 6},
+		{"beyond the token limit in a call", `$sum([1, 2, 3])`, []parser.CompileOption{parser.WithMaxTokens(5)}, types.ErrTooManyTokens, 9},
+		{"token limit of a module", `$import("m").f()`, []parser.CompileOption{
+			parser.WithMaxTokens(8), parser.WithModuleResolver(parser.FSModules(modules))}, types.ErrTooManyTokens, 21},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Compile(tt.query, tt.opts...)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var perr *types.Error
+			if !errors.As(err, &perr) || perr.Code != tt.code || perr.Position != tt.pos {
+				t.Fatalf("got %v, want %s at %d", err, tt.code, tt.pos)
+			}
+		})
+	}
+}
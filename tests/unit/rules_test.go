@@ -0,0 +1,103 @@
+package unit_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/rules"
+	"github.com/sandrolain/gosonata/pkg/types"
+)
+
+// TestRulesPredicates checks the values of predicates and groups, the
+// predicates skipped once a group is decided and the error of a failing one.
+func TestRulesPredicates(t *testing.T) {
+	var calls []string
+	probe := func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		name, _ := args[0].(string)
+		calls = append(calls, name)
+		return true, nil
+	}
+	ev := evaluator.New(evaluator.WithCustomFunction("probe", "", probe))
+
+	preds, err := rules.Compile([]rules.Predicate{
+		{Name: "gold", Expr: `customer.tier = "gold"`},
+		{Name: "domestic", Expr: `customer.country = "IT"`},
+		{Name: "large", Expr: `$sum(lines.(price * qty)) > 100`},
+		{Name: "probed", Expr: `$probe("probed")`},
+		{Name: "missing", Expr: `customer.vip`},
+		{Name: "broken", Expr: `customer.tier`},
+		{Name: "late", Expr: `$probe("late")`},
+	}, []rules.Group{
+		{Name: "priority", Mode: rules.Any, Members: []string{"gold", "large", "probed"}},
+		{Name: "express", Mode: rules.All, Members: []string{"domestic", "large", "probed"}},
+		{Name: "review", Mode: rules.All, Members: []string{"domestic", "broken"}},
+		{Name: "none", Mode: rules.Any},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := map[string]interface{}{
+		"customer": map[string]interface{}{"tier": "gold", "country": "IT"},
+		"lines": []interface{}{
+			map[string]interface{}{"price": 10.0, "qty": 2.0},
+		},
+	}
+	values, err := preds.Eval(context.Background(), ev, order)
+	want := map[string]bool{
+		"gold": true, "domestic": true, "large": false, "missing": false, "late": true,
+		"priority": true, "express": false, "none": false,
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+	// probed is skipped: priority holds with gold, express fails with large.
+	if !reflect.DeepEqual(calls, []string{"late"}) {
+		t.Errorf("custom function called for %v", calls)
+	}
+	var perr *rules.Error
+	if !errors.As(err, &perr) || perr.Predicate != "broken" || !strings.Contains(err.Error(), "not a boolean") {
+		t.Errorf("got error %v, want the error of predicate broken", err)
+	}
+
+	// An evaluation error keeps its code.
+	preds, err = rules.Compile([]rules.Predicate{
+		{Name: "ok", Expr: `true`},
+		{Name: "bad", Expr: `$number("x") > 1`},
+	}, []rules.Group{{Name: "both", Mode: rules.All, Members: []string{"bad", "ok"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err = preds.Eval(context.Background(), evaluator.New(), nil)
+	var jerr *types.Error
+	if !errors.As(err, &perr) || perr.Predicate != "bad" || !errors.As(err, &jerr) || jerr.Code != types.ErrCastToNumber {
+		t.Errorf("got error %v", err)
+	}
+	if !reflect.DeepEqual(values, map[string]bool{"ok": true}) {
+		t.Errorf("got %v", values)
+	}
+}
+
+func TestRulesCompileErrors(t *testing.T) {
+	tests := []struct {
+		preds  []rules.Predicate
+		groups []rules.Group
+		want   string
+	}{
+		{[]rules.Predicate{{Name: "a", Expr: "x ="}}, nil, `predicate "a"`},
+		{[]rules.Predicate{{Name: "a", Expr: "x"}, {Name: "a", Expr: "y"}}, nil, `duplicate name "a"`},
+		{[]rules.Predicate{{Name: "a", Expr: "x"}}, []rules.Group{{Name: "a"}}, `duplicate name "a"`},
+		{[]rules.Predicate{{Name: "a", Expr: "x"}}, []rules.Group{{Name: "g", Members: []string{"b"}}}, `unknown predicate "b"`},
+		{[]rules.Predicate{{Expr: "x"}}, nil, "no name"},
+	}
+	for _, tt := range tests {
+		_, err := rules.Compile(tt.preds, tt.groups)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v %v: got %v, want an error containing %q", tt.preds, tt.groups, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/scope"
+)
+
+// TestScopeCollection checks that a scoped expression evaluated against the
+// whole input gives what the original gives against the input holding only
+// the orders of the tenant, and that the expressions that could read other
+// orders are rejected.
+func TestScopeCollection(t *testing.T) {
+	order := func(id, tenant string, total float64) map[string]interface{} {
+		return map[string]interface{}{"id": id, "tenantId": tenant, "total": total}
+	}
+	input := func(orders ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"Orders": orders,
+			"Customers": []interface{}{
+				map[string]interface{}{"name": "Ann", "Orders": []interface{}{"o1", "o2"}},
+			},
+			"limit": 50.0,
+		}
+	}
+	a1, a2, b1 := order("o1", "A", 10), order("o2", "A", 70), order("o3", "B", 900)
+	data := input(a1, b1, a2)
+	tenantData := input(a1, a2)
+	bindings := map[string]interface{}{"tenant": "A"}
+
+	ev := evaluator.New()
+	valid := []string{
+		`Orders`,
+		`Orders.total`,
+		`$sum(Orders.total)`,
+		`$count(Orders)`,
+		`Orders ~> $count()`,
+		`$.Orders[total > $$.limit].id`,
+		`$$.Orders^(>total).id`,
+		`Orders[0].id`,
+		`Orders{tenantId: $sum(total)}`,
+		`{"n": $count(Orders), "top": $max(Orders.total)}`,
+		`Customers.{"name": name, "orders": $$.Orders[id in %.Orders].total}`,
+		`Customers.Orders`,
+		`Orders[total > 5].%.Orders.id`,
+		`Orders@$o.Orders[id = $o.id].total`,
+		`Orders#$i.{"i": $i, "id": id}`,
+		`$$@$r.$r.Orders.total`,
+		`$@$r.$r.Orders.total`,
+		`$$@$r.Customers.{"name": name, "n": $count($r.Orders)}`,
+		`$$#$i.$$.Orders[$i].id`,
+		`($big := function($x) { $x.total > limit }; $filter(Orders, $big).id)`,
+		`$map(Orders, function($o) { $o.id & "/" & $count($$.Orders) })`,
+		`($f := function() { Orders.id }; $f())`,
+		`Orders ~> |$|{"seen": true}|`,
+		`$string(Orders.id)`,
+		`$sort($keys(Orders[0]))`,
+		`Customers.$string()`,
+		`($$).Orders`,
+		`$substring(Orders[0].id, 1)`,
+	}
+	for _, q := range valid {
+		t.Run(q, func(t *testing.T) {
+			expr := mustCompile(t, q)
+			scoped, err := scope.Collection(expr, "Orders", `tenantId = $tenant`, ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ev.EvalWithBindings(context.Background(), scoped, data, bindings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := ev.Eval(context.Background(), expr, tenantData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+			// The original is untouched.
+			before, _ := ev.Eval(context.Background(), mustCompile(t, q), data)
+			after, _ := ev.Eval(context.Background(), expr, data)
+			beforeJSON, _ := json.Marshal(before)
+			afterJSON, _ := json.Marshal(after)
+			if string(beforeJSON) != string(afterJSON) {
+				t.Errorf("the original now gives %s, want %s", afterJSON, beforeJSON)
+			}
+		})
+	}
+
+	rejected := []string{
+		`$`,
+		`$$`,
+		`$string()`,
+		`$string($)`,
+		`$lookup($$, "Orders")`,
+		`$keys($)`,
+		`$each($, function($v) { $v })`,
+		`*`,
+		`*.total`,
+		`$$.*`,
+		`**.total`,
+		`$$.**`,
+		`Customers.%`,
+		`Orders.{"all": %}`,
+		`($r := $$; $r)`,
+		`($s := $string; $s())`,
+		`function() { $ }`,
+		`$eval("Orders")`,
+		`($e := $eval; $e("Orders"))`,
+		`| Orders | {"x": 1} |`,
+		`$ ~> |Orders|{"x": 1}|`,
+		`$unknown()`,
+		`[$$][0].Orders`,
+		`($x := 1; $$).Orders`,
+		`$$@$r.$string($r)`,
+		`$@$r.$r`,
+		`$$@$r.$r.*`,
+	}
+	for _, q := range rejected {
+		t.Run(q, func(t *testing.T) {
+			_, err := scope.Collection(mustCompile(t, q), "Orders", `tenantId = $tenant`, ev)
+			var serr *scope.Error
+			if !errors.As(err, &serr) {
+				t.Fatalf("got %v, want a *scope.Error", err)
+			}
+		})
+	}
+
+	if _, err := scope.Collection(mustCompile(t, `Orders`), "Orders", `tenantId =`, nil); err == nil {
+		t.Error("expected an error for an invalid predicate")
+	}
+	// A custom function is resolved in the registry of the evaluator.
+	custom := evaluator.New(evaluator.WithCustomFunction("tax", "<n:n>",
+		func(ctx context.Context, args ...interface{}) (interface{}, error) { return args[0], nil }))
+	if _, err := scope.Collection(mustCompile(t, `$tax(1)`), "Orders", `tenantId = $tenant`, custom); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,9 +2,16 @@ package unit_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"unsafe"
 
+	"github.com/sandrolain/gosonata"
 	"github.com/sandrolain/gosonata/pkg/evaluator"
 	"github.com/sandrolain/gosonata/pkg/parser"
 )
@@ -137,3 +144,489 @@ func TestEvalStreamSingleDocument(t *testing.T) {
 		t.Fatalf("expected 42, got %v", results[0].Value)
 	}
 }
+
+func TestEvalStreamStringInterning(t *testing.T) {
+	ndjson := `{"status":"active","n":1}
+{"status":"active","n":2}`
+
+	expr, err := parser.Compile("$.status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := evaluator.New(evaluator.WithStringInterning(true))
+	ch, err := ev.EvalStream(context.Background(), expr, strings.NewReader(ndjson))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for res := range ch {
+		if res.Err != nil {
+			t.Fatalf("unexpected error: %v", res.Err)
+		}
+		got = append(got, res.Value.(string))
+	}
+	if len(got) != 2 || got[0] != "active" || got[1] != "active" {
+		t.Fatalf("got %v, want two \"active\" values", got)
+	}
+	if unsafe.StringData(got[0]) != unsafe.StringData(got[1]) {
+		t.Error("repeated string values were not interned across documents")
+	}
+}
+
+func TestEvalStreamOrderedInput(t *testing.T) {
+	ndjson := `{"z":1,"a":{"y":2,"b":null},"m":[{"q":1,"c":2}]}`
+
+	expr, err := parser.Compile(`[$string($keys($)), $string($keys(a)), $string($spread(a)), $string(m), a.b = null]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := evaluator.New(evaluator.WithOrderedInput(true))
+	ch, err := ev.EvalStream(context.Background(), expr, strings.NewReader(ndjson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-ch
+	if res.Err != nil {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+	want := []interface{}{
+		`["z","a","m"]`,
+		`["y","b"]`,
+		`[{"y":2},{"b":null}]`,
+		`[{"q":1,"c":2}]`,
+		true,
+	}
+	if !reflect.DeepEqual(res.Value, want) {
+		t.Errorf("got %#v, want %#v", res.Value, want)
+	}
+}
+
+func TestUnmarshalOrdered(t *testing.T) {
+	v, err := evaluator.UnmarshalOrdered([]byte(`{"b":[1,{"d":true,"c":"x"}],"a":null,"b2":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := v.(*evaluator.OrderedObject)
+	if !ok {
+		t.Fatalf("got %T, want *OrderedObject", v)
+	}
+	if !reflect.DeepEqual(obj.Keys, []string{"b", "a", "b2"}) {
+		t.Errorf("keys = %v", obj.Keys)
+	}
+	inner := obj.Values["b"].([]interface{})[1].(*evaluator.OrderedObject)
+	if !reflect.DeepEqual(inner.Keys, []string{"d", "c"}) {
+		t.Errorf("inner keys = %v", inner.Keys)
+	}
+
+	for _, bad := range []string{`{"a":1} x`, `{"a":`, `[1,]`} {
+		if _, err := evaluator.UnmarshalOrdered([]byte(bad)); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+// TestOrderedObjectKeyQuoting checks that the keys of an OrderedObject are
+// written as JSON strings: control characters as \u escapes and invalid UTF-8
+// as U+FFFD, not with the \x escapes of Go.
+func TestOrderedObjectKeyQuoting(t *testing.T) {
+	keys := []string{"plain", `a"b\c`, "\x01tab\t", "é", "\xff"}
+	obj := &evaluator.OrderedObject{Keys: keys, Values: map[string]interface{}{}}
+	for i, key := range keys {
+		obj.Values[key] = float64(i)
+	}
+	got, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"plain":0,"a\"b\\c":1,"\u0001tab\t":2,"é":3,"` + "\ufffd" + `":4}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if !json.Valid(got) {
+		t.Errorf("%s is not valid JSON", got)
+	}
+}
+
+func TestEvalIterMatchesEval(t *testing.T) {
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"x": 1.0, "tags": []interface{}{"a", "b"}},
+			map[string]interface{}{"x": 2.0, "tags": []interface{}{"c"}},
+			map[string]interface{}{"x": 3.0, "v": nil},
+		},
+	}
+	ev := evaluator.New()
+	queries := []string{
+		`items[x > 1]`,
+		`items[x > 1 and x < 3]`,
+		`items[x > 5]`,
+		`items.tags[$ != "b"]`,
+		`items[x >= 1].x`,
+		`items[0]`,
+		`items.x`,
+		`$count(items)`,
+		`items[v = null]`,
+		`missing[x > 1]`,
+		`items[x > 1].{"x": x, "tags": tags}`,
+		`$.items[x < 3].{"n": $count(tags)}`,
+		`items[x > 5].{"x": x}`,
+	}
+	for _, q := range queries {
+		expr, err := parser.Compile(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := ev.Eval(context.Background(), expr, data)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		var wantItems []interface{}
+		switch w := want.(type) {
+		case nil:
+		case []interface{}:
+			wantItems = w
+		default:
+			wantItems = []interface{}{w}
+		}
+
+		seq, err := ev.EvalIter(context.Background(), expr, data)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		var got []interface{}
+		for item, err := range seq {
+			if err != nil {
+				t.Fatalf("%s: %v", q, err)
+			}
+			got = append(got, item)
+		}
+		if !reflect.DeepEqual(got, wantItems) {
+			t.Errorf("%s: got %v, want %v", q, got, wantItems)
+		}
+	}
+}
+
+func TestEvalIterStopsEarly(t *testing.T) {
+	calls := 0
+	zero := func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		calls++
+		return 0.0, nil
+	}
+	items := make([]interface{}, 1000)
+	for i := range items {
+		items[i] = map[string]interface{}{"x": float64(i + 1)}
+	}
+	expr, err := parser.Compile(`items[x > $zero()]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := evaluator.New(evaluator.WithCustomFunction("zero", "", zero))
+	seq, err := ev.EvalIter(context.Background(), expr, map[string]interface{}{"items": items})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []interface{}
+	for item, err := range seq {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, item)
+		if len(got) == 2 {
+			break
+		}
+	}
+	if len(got) != 2 || calls != 2 {
+		t.Fatalf("expected 2 items after 2 predicate calls, got %d items and %d calls", len(got), calls)
+	}
+}
+
+func TestEvalIterYieldsErrors(t *testing.T) {
+	expr, err := parser.Compile(`items[x > "a"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, err := evaluator.New().EvalIter(context.Background(), expr, map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"x": 1.0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, err := range seq {
+		n++
+		if err == nil {
+			t.Fatal("expected a comparison error")
+		}
+	}
+	if n != 1 {
+		t.Fatalf("expected exactly one error, got %d pairs", n)
+	}
+	if _, err := evaluator.New().EvalIter(context.Background(), nil, nil); err == nil {
+		t.Fatal("expected an error for a nil expression")
+	}
+}
+
+// TestEvalIterRecovers checks that a panic in a lazily evaluated predicate is
+// yielded as a PanicError, as Eval returns it, while a panic in the body of
+// the range loop goes on to the caller.
+func TestEvalIterRecovers(t *testing.T) {
+	boom := func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		if args[0] == 3.0 {
+			panic("boom")
+		}
+		return args[0], nil
+	}
+	items := []interface{}{
+		map[string]interface{}{"x": 1.0}, map[string]interface{}{"x": 2.0}, map[string]interface{}{"x": 3.0},
+	}
+	data := map[string]interface{}{"items": items}
+	ev := evaluator.New(evaluator.WithCustomFunction("boom", "", boom))
+	expr := mustCompile(t, `items[$boom(x) > 0]`)
+
+	seq, err := ev.EvalIter(context.Background(), expr, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []interface{}
+	var perr *evaluator.PanicError
+	for item, err := range seq {
+		if err != nil {
+			if !errors.As(err, &perr) || perr.ExpressionHash == "" {
+				t.Fatalf("got %v, want a PanicError", err)
+			}
+			break
+		}
+		got = append(got, item)
+	}
+	if len(got) != 2 || perr == nil {
+		t.Errorf("got %v and %v, want two items then a PanicError", got, perr)
+	}
+	if _, err := ev.Eval(context.Background(), expr, data); !errors.As(err, &perr) {
+		t.Errorf("Eval: got %v, want a PanicError", err)
+	}
+
+	defer func() {
+		if r := recover(); r != "loop" {
+			t.Errorf("recovered %v, want the panic of the loop body", r)
+		}
+	}()
+	for range seq {
+		panic("loop")
+	}
+}
+
+func TestEvalPage(t *testing.T) {
+	calls := 0
+	zero := func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		calls++
+		return 0.0, nil
+	}
+	items := make([]interface{}, 100)
+	for i := range items {
+		items[i] = map[string]interface{}{"x": float64(i + 1)}
+	}
+	data := map[string]interface{}{"items": items}
+	ev := evaluator.New(evaluator.WithCustomFunction("zero", "", zero))
+
+	for _, q := range []string{`items[x > $zero()]`, `items[x > $zero()].{"y": x * 2}`, `items.x`, `items[x % 2 = 0].x`} {
+		expr, err := parser.Compile(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, err := ev.Eval(context.Background(), expr, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := all.([]interface{})
+		for _, offset := range []int{0, 10, len(want) - 5, len(want)} {
+			page, err := ev.EvalPage(context.Background(), expr, data, offset, 10)
+			if err != nil {
+				t.Fatalf("%s: %v", q, err)
+			}
+			end := min(offset+10, len(want))
+			if !reflect.DeepEqual(page.Items, want[offset:end]) {
+				t.Errorf("%s from %d: got %v, want %v", q, offset, page.Items, want[offset:end])
+			}
+			if page.More != (end < len(want)) {
+				t.Errorf("%s from %d: More is %v", q, offset, page.More)
+			}
+		}
+	}
+
+	// The predicate stops once the page and the item after it are found.
+	calls = 0
+	page, err := ev.EvalPage(context.Background(), mustCompile(t, `items[x > $zero()].{"y": x}`), data, 20, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 5 || !page.More || calls != 26 {
+		t.Errorf("got %d items (more: %v) after %d predicate calls, want 5 after 26", len(page.Items), page.More, calls)
+	}
+
+	if _, err := ev.EvalPage(context.Background(), mustCompile(t, `items`), data, 0, 0); err == nil {
+		t.Error("expected an error for a zero limit")
+	}
+}
+
+func TestEvalMany(t *testing.T) {
+	data := map[string]interface{}{
+		"order": map[string]interface{}{
+			"customer": map[string]interface{}{"tier": "gold", "country": "IT"},
+			"lines": []interface{}{
+				map[string]interface{}{"sku": "a", "price": 10.0, "qty": 2.0},
+				map[string]interface{}{"sku": "b", "price": 25.0, "qty": 1.0},
+			},
+			"code": "x1",
+		},
+	}
+	queries := []string{
+		`$sum(order.lines.(price * qty)) > 40`,
+		`$sum(order.lines.(price * qty)) * 0.9`,
+		`order.customer.tier = "gold" and order.customer.country in ["IT", "FR"]`,
+		`order.customer.tier`,
+		`$number(order.code) > 1`,
+		`$number(order.code) < 1`,
+		`($total := $sum(order.lines.(price * qty)); $total > 100)`,
+		`order.lines[price > 20].sku`,
+		`order.lines[`,
+		`$count(order.lines[price > 20])`,
+	}
+	set := parser.CompileMany(queries)
+	if len(set.Shared) == 0 {
+		t.Fatal("expected shared sub-expressions")
+	}
+
+	// The set evaluates against any document, with the results of Eval.
+	other := map[string]interface{}{"order": map[string]interface{}{"lines": []interface{}{}, "code": "7"}}
+	ev := evaluator.New()
+	for _, doc := range []interface{}{data, other} {
+		results, err := ev.EvalMany(context.Background(), set, doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(queries) {
+			t.Fatalf("got %d results, want %d", len(results), len(queries))
+		}
+		for i, query := range queries {
+			var want interface{}
+			expr, wantErr := parser.Compile(query)
+			if wantErr == nil {
+				want, wantErr = ev.Eval(context.Background(), expr, doc)
+			}
+			got := results[i]
+			if (got.Err == nil) != (wantErr == nil) || (wantErr != nil && got.Err.Error() != wantErr.Error()) {
+				t.Errorf("%s: got error %v, want %v", query, got.Err, wantErr)
+				continue
+			}
+			if !reflect.DeepEqual(got.Value, want) {
+				t.Errorf("%s: got %v, want %v", query, got.Value, want)
+			}
+		}
+	}
+
+	results, err := gosonata.EvalMany(context.Background(), []string{"a + 1", "a +"}, map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Value != 2.0 || results[1].Err == nil {
+		t.Errorf("gosonata.EvalMany: got %+v", results)
+	}
+}
+
+// TestEvalManyStats checks that the shared sub-expressions of a set are
+// accounted for in Stats, as an evaluation of their own.
+func TestEvalManyStats(t *testing.T) {
+	queries := []string{`$sum(order.lines.price) > 10`, `$count(order.lines.price)`}
+	set := parser.CompileMany(queries)
+	if len(set.Shared) == 0 {
+		t.Fatal("expected shared sub-expressions")
+	}
+	var stats []evaluator.Stats
+	ev := evaluator.New(evaluator.WithStatsHook(func(s evaluator.Stats) { stats = append(stats, s) }))
+	data := map[string]interface{}{"order": map[string]interface{}{"lines": []interface{}{
+		map[string]interface{}{"price": 10.0},
+		map[string]interface{}{"price": 25.0},
+	}}}
+	if _, err := ev.EvalMany(context.Background(), set, data); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != len(queries)+1 {
+		t.Fatalf("got %d evaluations, want %d", len(stats), len(queries)+1)
+	}
+	// The shared evaluation comes first and walks the path the queries read.
+	shared := stats[0].Nodes
+	if _, err := ev.Eval(context.Background(), mustCompile(t, `order.lines.price`), data); err != nil {
+		t.Fatal(err)
+	}
+	if want := stats[len(stats)-1].Nodes; shared != want {
+		t.Errorf("shared evaluation counted %d nodes, want %d", shared, want)
+	}
+}
+
+// TestIteratorInput checks Go iterators as input data: collected where the
+// evaluation reads them, and pulled one item at a time by EvalIter filters.
+func TestIteratorInput(t *testing.T) {
+	pulled := 0
+	numbers := func(yield func(any) bool) {
+		for i := 1; i <= 1000; i++ {
+			pulled++
+			if !yield(map[string]interface{}{"x": float64(i)}) {
+				return
+			}
+		}
+	}
+	ctx := context.Background()
+	ev := evaluator.New()
+
+	pair := iter.Seq2[string, any](func(yield func(string, any) bool) {
+		_ = yield("b", 2.0) && yield("a", slices.Values([]any{1.0, 2.0}))
+	})
+	tests := []struct {
+		query string
+		data  interface{}
+		want  string
+	}{
+		{`$count($)`, iter.Seq[any](numbers), `1000`},
+		{`$[x < 3].x`, iter.Seq[any](numbers), `[1,2]`},
+		{`$sum(items.x[$ <= 4])`, map[string]interface{}{"items": numbers}, `10`},
+		{`$keys($)`, pair, `["b","a"]`},
+		{`a`, pair, `[1,2]`},
+		{`$`, map[string]interface{}{"s": slices.Values([]any{"x"})}, `{"s":["x"]}`},
+	}
+	for _, tt := range tests {
+		got, err := ev.Eval(ctx, mustCompile(t, tt.query), tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if js, _ := json.Marshal(got); string(js) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.query, js, tt.want)
+		}
+	}
+
+	for _, data := range []interface{}{iter.Seq[any](numbers), map[string]interface{}{"items": numbers}} {
+		query := `$[x > 10]`
+		if _, ok := data.(map[string]interface{}); ok {
+			query = `items[x > 10]`
+		}
+		seq, err := ev.EvalIter(ctx, mustCompile(t, query), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pulled = 0
+		var got []interface{}
+		for item, err := range seq {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got = append(got, item); len(got) == 2 {
+				break
+			}
+		}
+		if len(got) != 2 || pulled != 12 {
+			t.Errorf("%s: %d items after pulling %d, want 2 after 12", query, len(got), pulled)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package unit_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sandrolain/gosonata/pkg/evaluator"
+	"github.com/sandrolain/gosonata/pkg/testkit"
+)
+
+func TestTestkitRun(t *testing.T) {
+	testkit.Run(t, "testdata/testkit", testkit.WithNumberTolerance(1e-9))
+}
+
+func TestTestkitLoadDir(t *testing.T) {
+	cases, err := testkit.LoadDir("testdata/testkit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range cases {
+		names = append(names, c.Name)
+	}
+	// shared/orders.json is an input, not a case.
+	want := "error inline orders/big orders/none orders/tags"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("cases = %q, want %q", got, want)
+	}
+	for _, c := range cases {
+		if c.Name == "orders/big" && !strings.HasPrefix(c.Expr, "orders[total > $threshold]") {
+			t.Errorf("exprFile not loaded: %q", c.Expr)
+		}
+	}
+}
+
+func TestTestkitDiff(t *testing.T) {
+	ordered := &evaluator.OrderedObject{
+		Keys:   []string{"b", "a"},
+		Values: map[string]interface{}{"b": []interface{}{1, "x"}, "a": 2.0},
+	}
+
+	tests := []struct {
+		name  string
+		want  interface{}
+		got   interface{}
+		opts  []testkit.Option
+		lines []string
+	}{
+		{name: "ordered object equals map", want: map[string]interface{}{"a": 2.0, "b": []interface{}{1.0, "x"}}, got: ordered},
+		{name: "int equals float", want: 3.0, got: int64(3)},
+		{name: "tolerance", want: 0.3, got: 0.30000000000000004, opts: []testkit.Option{testkit.WithNumberTolerance(1e-12)}},
+		{name: "exact by default", want: 0.3, got: 0.30000000000000004, lines: []string{"$: -0.3 +0.30000000000000004"}},
+		{name: "unordered", want: []interface{}{"a", "b"}, got: []interface{}{"b", "a"}, opts: []testkit.Option{testkit.WithUnordered(true)}},
+		{
+			name: "nested differences",
+			want: map[string]interface{}{"a": 1.0, "b": []interface{}{1.0, "y", true}, "first name": "x"},
+			got:  ordered,
+			lines: []string{
+				`$.a: -1 +2`,
+				`$.b[1]: -"y" +"x"`,
+				`$.b[2]: missing true`,
+				`$["first name"]: missing "x"`,
+			},
+		},
+		{name: "undefined", want: nil, got: "x", lines: []string{`$: -undefined +"x"`}},
+		{name: "unexpected key", want: map[string]interface{}{}, got: map[string]interface{}{"k": nil}, lines: []string{`$.k: unexpected undefined`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := testkit.Diff(tt.want, tt.got, tt.opts...)
+			if len(tt.lines) == 0 {
+				if diff != "" {
+					t.Errorf("unexpected diff:\n%s", diff)
+				}
+				return
+			}
+			for _, line := range tt.lines {
+				if !strings.Contains(diff, "  "+line+"\n") {
+					t.Errorf("diff lacks %q:\n%s", line, diff)
+				}
+			}
+			if !strings.Contains(diff, "got:\n") {
+				t.Errorf("diff lacks the rendered result:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTestkitUpdate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "case.json")
+	src := `{"expr": "$uppercase(name) & \"!\"", "data": {"name": "ada"}, "result": "stale"}`
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases, err := testkit.LoadDir(dir)
+	if err != nil || len(cases) != 1 {
+		t.Fatalf("LoadDir = %v, %v", cases, err)
+	}
+	testkit.RunCase(t, cases[0], testkit.WithUpdate(true))
+
+	cases, err = testkit.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cases[0].Result; got != "ADA!" {
+		t.Errorf("updated result = %v, want ADA!", got)
+	}
+	if got := cases[0].Data; got.(map[string]interface{})["name"] != "ada" {
+		t.Errorf("data not preserved: %v", got)
+	}
+}
+
+func TestTestkitGenerate(t *testing.T) {
+	data := map[string]interface{}{"orders": []interface{}{
+		map[string]interface{}{"id": 1.0, "total": 250.0},
+		map[string]interface{}{"id": 2.0, "total": 50.0},
+	}}
+	fixture, err := testkit.Generate(`{"big": orders[total > 100].id, "pick": $shuffle(orders.id)}`, data, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "case.json"), fixture, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases, err := testkit.LoadDir(dir)
+	if err != nil || len(cases) != 1 {
+		t.Fatalf("LoadDir = %v, %v", cases, err)
+	}
+	c := cases[0]
+	if c.Seed == nil || *c.Seed != 42 {
+		t.Errorf("seed = %v, want 42", c.Seed)
+	}
+	if len(c.Trace) == 0 || c.Trace[0].Position > c.Trace[len(c.Trace)-1].Position {
+		t.Errorf("trace = %+v", c.Trace)
+	}
+	// The seeded $shuffle replays the recorded draw.
+	testkit.RunCase(t, c)
+
+	if _, err := testkit.Generate(`$now()`, nil, 0); !errors.Is(err, testkit.ErrNondeterministic) {
+		t.Errorf("$now: got %v, want ErrNondeterministic", err)
+	}
+	fixture, err = testkit.Generate(`$number("x")`, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(fixture), `"error": "D3030"`) || strings.Contains(string(fixture), "trace") {
+		t.Errorf("error fixture:\n%s", fixture)
+	}
+}